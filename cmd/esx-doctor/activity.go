@@ -0,0 +1,94 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"math"
+)
+
+type columnActivityAccumulator struct {
+	valid   int64
+	nonZero int64
+	mean    float64
+	m2      float64
+}
+
+func (a *columnActivityAccumulator) add(v float64) {
+	a.valid++
+	if v != 0 {
+		a.nonZero++
+	}
+	delta := v - a.mean
+	a.mean += delta / float64(a.valid)
+	a.m2 += delta * (v - a.mean)
+}
+
+// score ranks how "interesting" a column is: the fraction of non-zero samples
+// scaled by its typical magnitude plus its variability. Idle or constant-zero
+// instances score 0; busy and fluctuating ones float to the top.
+func (a *columnActivityAccumulator) score() float64 {
+	if a.valid == 0 {
+		return 0
+	}
+	nonZeroFrac := float64(a.nonZero) / float64(a.valid)
+	stddev := 0.0
+	if a.valid > 1 {
+		stddev = math.Sqrt(a.m2 / float64(a.valid-1))
+	}
+	s := nonZeroFrac * (math.Abs(a.mean) + stddev)
+	if !NumberFinite(s) {
+		return 0
+	}
+	return s
+}
+
+// Activity returns per-column activity scores aligned with df.Columns. The
+// scan runs once per file on first use and is cached for later callers.
+func (df *DataFile) Activity() ([]float64, error) {
+	df.activityOnce.Do(func() {
+		df.activity, df.activityErr = computeColumnActivity(df)
+	})
+	return df.activity, df.activityErr
+}
+
+func computeColumnActivity(df *DataFile) ([]float64, error) {
+	acc := make([]columnActivityAccumulator, len(df.Columns))
+	f, data, err := df.openData(df.DataStartOffset)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	lines := getLineReader(data)
+	defer lines.release()
+	for {
+		line, err := lines.next()
+		if err != nil && !errors.Is(err, io.EOF) {
+			return nil, err
+		}
+		if len(line) == 0 && errors.Is(err, io.EOF) {
+			break
+		}
+		record, perr := lines.record(line)
+		if perr == nil {
+			for i := 1; i < len(record) && i < len(acc); i++ {
+				if v, ok := parseFloatValue(record[i]); ok && NumberFinite(v) {
+					acc[i].add(v)
+				} else if values, ok := parseDelimitedFloatValues(record[i], "/"); ok {
+					for _, v := range values {
+						acc[i].add(v)
+					}
+				}
+			}
+		}
+		if errors.Is(err, io.EOF) {
+			break
+		}
+	}
+
+	scores := make([]float64, len(acc))
+	for i := 1; i < len(acc); i++ {
+		scores[i] = acc[i].score()
+	}
+	return scores, nil
+}
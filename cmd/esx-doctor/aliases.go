@@ -0,0 +1,112 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+)
+
+// builtinAttributeAliases maps a canonical "Object: Counter" label to the
+// spellings other ESXi releases use for the same counter.
+var builtinAttributeAliases = map[string][]string{
+	"Vcpu: % CoStop":                                             {"Vcpu: % Co-Stop", "Vcpu: % CSTP"},
+	"Vcpu: % Ready":                                              {"Vcpu: % RDY"},
+	"Group Cpu: % Wait":                                          {"Group Cpu: % WAIT"},
+	"Group Memory: Numa Home Nodes":                              {"Group Memory: NUMA Home Node", "Group Memory: Numa Home Node"},
+	"Group Memory: Numa % Local":                                 {"Group Memory: NUMA % Local Memory", "Group Memory: % Local Memory"},
+	"Memory: Memory Overcommit (1 Minute Avg)":                   {"Memory: Memory Overcommit Avg (1 min)", "Memory: Overcommit (1 Minute Avg)"},
+	"Network Port: % Outbound Packets Dropped":                   {"Network Port: % Transmit Packets Dropped", "Network Port: %DRPTX"},
+	"Physical Disk Adapter: Failed Reads/sec":                    {"Physical Disk Adapter: Failed Read/sec"},
+	"Physical Disk SCSI Device: Average Driver MilliSec/Command": {"Physical Disk SCSI Device: Average Driver MilliSec/Cmd", "Physical Disk SCSI Device: DAVG/cmd"},
+	"Physical Disk Adapter: Average Driver MilliSec/Command":     {"Physical Disk Adapter: Average Driver MilliSec/Cmd", "Physical Disk Adapter: DAVG/cmd"},
+	"PCPU Power State: % of aperf/mperf":                         {"Physical Cpu: % aperf/mperf", "Power: % of aperf/mperf"},
+}
+
+var attributeAliases = struct {
+	mu        sync.RWMutex
+	canonical map[string]string
+}{canonical: map[string]string{}}
+
+func init() {
+	registerAttributeAliases(builtinAttributeAliases)
+}
+
+func registerAttributeAliases(aliases map[string][]string) {
+	attributeAliases.mu.Lock()
+	defer attributeAliases.mu.Unlock()
+	for canonical, list := range aliases {
+		canonical = strings.TrimSpace(canonical)
+		if canonical == "" {
+			continue
+		}
+		attributeAliases.canonical[strings.ToLower(canonical)] = canonical
+		for _, alias := range list {
+			alias = strings.TrimSpace(alias)
+			if alias == "" {
+				continue
+			}
+			attributeAliases.canonical[strings.ToLower(alias)] = canonical
+		}
+	}
+}
+
+// loadAttributeAliases reads extra aliases from a JSON file shaped like
+// {"Canonical: Label": ["Alias: One", "Alias: Two"]}.
+func loadAttributeAliases(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	var aliases map[string][]string
+	if err := json.Unmarshal(data, &aliases); err != nil {
+		return fmt.Errorf("invalid alias file: %w", err)
+	}
+	registerAttributeAliases(aliases)
+	return nil
+}
+
+// canonicalAttributeLabel returns the canonical spelling for label, or label
+// itself when no alias is registered.
+func canonicalAttributeLabel(label string) string {
+	label = strings.TrimSpace(label)
+	attributeAliases.mu.RLock()
+	defer attributeAliases.mu.RUnlock()
+	if c, ok := attributeAliases.canonical[strings.ToLower(label)]; ok {
+		return c
+	}
+	return label
+}
+
+// sameAttribute compares two attribute labels case-insensitively after alias
+// resolution.
+func sameAttribute(a, b string) bool {
+	return strings.EqualFold(canonicalAttributeLabel(a), canonicalAttributeLabel(b))
+}
+
+// resolveColumnsByAttribute returns column indexes whose attribute label
+// matches attr (through aliases), optionally limited to the given instances.
+func (df *DataFile) resolveColumnsByAttribute(attr string, instances []string) []int {
+	want := map[string]bool{}
+	for _, inst := range instances {
+		if inst = strings.TrimSpace(inst); inst != "" {
+			want[strings.ToLower(inst)] = true
+		}
+	}
+	var out []int
+	for i, raw := range df.Columns {
+		if i == 0 {
+			continue
+		}
+		c := parsePDHColumnBackend(raw, i)
+		if !sameAttribute(c.AttributeLabel, attr) {
+			continue
+		}
+		if len(want) > 0 && !want[strings.ToLower(c.Instance)] {
+			continue
+		}
+		out = append(out, i)
+	}
+	return out
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// templateVersionSuffix is the ".vN" ending of template IDs.
+var templateVersionSuffix = regexp.MustCompile(`\.v[0-9]+$`)
+
+// templateShortName is the part of a template ID between its category and
+// its version: "high_ready" for "cpu.high_ready.v1".
+func templateShortName(id string) string {
+	name := templateVersionSuffix.ReplaceAllString(id, "")
+	if p := strings.Index(name, "."); p >= 0 {
+		name = name[p+1:]
+	}
+	return name
+}
+
+// selectTemplatesByName resolves template IDs or short names to templates.
+// A short name that several templates share (say two versions of one rule)
+// must be spelled out as an ID.
+func selectTemplatesByName(store *diagnosticTemplateStore, names []string) ([]DiagnosticTemplate, error) {
+	all := store.list()
+	ids := make([]string, 0, len(names))
+	for _, name := range names {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		var matches []string
+		for _, t := range all {
+			if t.ID == name {
+				matches = []string{t.ID}
+				break
+			}
+			if strings.EqualFold(templateShortName(t.ID), name) {
+				matches = append(matches, t.ID)
+			}
+		}
+		switch len(matches) {
+		case 0:
+			return nil, fmt.Errorf("unknown template %q", name)
+		case 1:
+			ids = append(ids, matches[0])
+		default:
+			sort.Strings(matches)
+			return nil, fmt.Errorf("template %q is ambiguous: %s", name, strings.Join(matches, ", "))
+		}
+	}
+	if len(ids) == 0 {
+		return nil, fmt.Errorf("no templates selected")
+	}
+	selected, skipped := store.byID(ids)
+	if len(skipped) > 0 {
+		return nil, errors.New(strings.Join(skipped, "\n"))
+	}
+	for i := range selected {
+		// Naming a template runs it even when it is disabled by default.
+		selected[i].Enabled = true
+	}
+	return selected, nil
+}
+
+// runAnalyze runs diagnostics over a capture without starting the server,
+// for scripts and CI. It exits 0 whether or not there are findings.
+func runAnalyze(args []string) int {
+	fs := flag.NewFlagSet("analyze", flag.ContinueOnError)
+	file := fs.String("file", "", "Path to ESX CSV file (or pass it as the argument)")
+	names := fs.String("templates", "", "Comma-separated template IDs or short names (high_ready for cpu.high_ready.v1); default every enabled template")
+	asJSON := fs.Bool("json", false, "Print the run as JSON (the /api/diagnostics/run response)")
+	lang := fs.String("lang", "", "Language of finding summaries (default: the built-in messages)")
+	fs.BoolVar(&rebuildIndexes, "rebuild-index", false, "Ignore the capture's x.idx.json and rewrite it from a fresh scan")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: esx-doctor analyze [-json] [-templates a,b] [-lang xx] [-rebuild-index] -file <file.csv>")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	target := *file
+	if target == "" && fs.NArg() == 1 {
+		target = fs.Arg(0)
+	}
+	if target == "" || fs.NArg() > 1 || (*file != "" && fs.NArg() > 0) {
+		fs.Usage()
+		return 2
+	}
+	if *lang != "" && !messages.has(*lang) {
+		fmt.Fprintf(os.Stderr, "no message pack for language: %s\n", *lang)
+		return 2
+	}
+	path, err := filepath.Abs(target)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "invalid path: %v\n", err)
+		return 1
+	}
+
+	builtins, err := loadDiagnosticTemplates(webFS)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to load diagnostic templates: %v\n", err)
+		return 1
+	}
+	store, err := newDiagnosticTemplateStore("", builtins)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to load custom templates: %v\n", err)
+		return 1
+	}
+	var selected []DiagnosticTemplate
+	var skipped []string
+	if strings.TrimSpace(*names) == "" {
+		selected, skipped = store.byID(nil)
+	} else if selected, err = selectTemplatesByName(store, strings.Split(*names, ",")); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+
+	df, err := loadOrBuildIndex(path)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "index build failed: %v\n", err)
+		return 1
+	}
+	resp, err := runDiagnostics(df, selected, time.Time{}, time.Time{})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "diagnostics failed: %v\n", err)
+		return 1
+	}
+	resp.Warnings = append(skipped, resp.Warnings...)
+	if *lang != "" {
+		localizeFindings(resp.Findings, *lang)
+	}
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.SetEscapeHTML(false)
+		if err := enc.Encode(resp); err != nil {
+			return 1
+		}
+		return 0
+	}
+	writeAnalyzeText(os.Stdout, df, resp)
+	return 0
+}
+
+func writeAnalyzeText(w io.Writer, df *DataFile, resp DiagnosticRunResponse) {
+	fmt.Fprintf(w, "Diagnostics: %s\n", df.Label)
+	fmt.Fprintf(w, "Templates: %d   Rows: %d   Health score: %d   Findings: %d\n", resp.Templates, resp.RowsScanned, resp.HealthScore, len(resp.Findings))
+	for _, warn := range resp.Warnings {
+		fmt.Fprintf(w, "warning: %s\n", warn)
+	}
+	for _, f := range resp.Findings {
+		fmt.Fprintf(w, "\n[%s] %s\n", strings.ToUpper(f.Severity), f.Title)
+		if f.Start > 0 {
+			fmt.Fprintf(w, "  %s .. %s", time.UnixMilli(f.Start).UTC().Format("2006-01-02 15:04:05"), time.UnixMilli(f.End).UTC().Format("2006-01-02 15:04:05"))
+			if len(f.Instances) > 0 {
+				fmt.Fprintf(w, "   %s", strings.Join(f.Instances, ", "))
+			}
+			fmt.Fprintln(w)
+		}
+		if f.Summary != "" {
+			fmt.Fprintf(w, "  %s\n", f.Summary)
+		}
+	}
+	if resp.Truncated {
+		fmt.Fprintln(w, "\nSome findings were dropped by the per-run limits.")
+	}
+}
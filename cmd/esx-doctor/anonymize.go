@@ -0,0 +1,440 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+)
+
+// pseudonymStore keeps the real-name -> pseudonym mapping on this machine so
+// exports stay stable across captures and vendor replies can be translated
+// back. It also holds the salt used by the hash mode.
+type pseudonymStore struct {
+	mu   sync.Mutex
+	path string
+	salt string
+	// tables maps "vms", "hosts", "vms_hashed" and "hosts_hashed" to
+	// real name -> pseudonym.
+	tables map[string]map[string]string
+}
+
+func defaultPseudonymStorePath() string {
+	home, err := os.UserHomeDir()
+	if err != nil || strings.TrimSpace(home) == "" {
+		return ".esx-doctor-pseudonyms.json"
+	}
+	return filepath.Join(home, ".esx-doctor", "pseudonyms.json")
+}
+
+func newPseudonymStore(path string) (*pseudonymStore, error) {
+	if strings.TrimSpace(path) == "" {
+		path = defaultPseudonymStorePath()
+	}
+	s := &pseudonymStore{path: path, tables: map[string]map[string]string{}}
+	if err := s.load(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *pseudonymStore) load() error {
+	data, err := os.ReadFile(s.path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	var payload struct {
+		Salt   string                       `json:"salt"`
+		Tables map[string]map[string]string `json:"tables"`
+	}
+	if err := json.Unmarshal(data, &payload); err != nil {
+		return fmt.Errorf("invalid pseudonym store file: %w", err)
+	}
+	s.salt = payload.Salt
+	if payload.Tables != nil {
+		s.tables = payload.Tables
+	}
+	return nil
+}
+
+// persistLocked writes the mapping owner-only: it is the key to the
+// real names.
+func (s *pseudonymStore) persistLocked() error {
+	if err := os.MkdirAll(filepath.Dir(s.path), 0o755); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(map[string]any{"salt": s.salt, "tables": s.tables}, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(s.path, data, 0o600)
+}
+
+// mapping returns pseudonym -> real name for every export made so far.
+// It is the whole key to the real names, for `esx-doctor pseudonyms` on
+// this machine only.
+func (s *pseudonymStore) mapping() map[string]string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	out := map[string]string{}
+	for _, table := range s.tables {
+		for real, p := range table {
+			out[p] = real
+		}
+	}
+	return out
+}
+
+// mappingFor returns pseudonym -> real name restricted to vms and hosts,
+// the names of one capture; names never exported have no entry.
+func (s *pseudonymStore) mappingFor(vms, hosts []string) map[string]string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	out := map[string]string{}
+	add := func(kind string, names []string) {
+		for _, key := range []string{kind, kind + "_hashed"} {
+			table := s.tables[key]
+			for _, name := range names {
+				if p, ok := table[name]; ok {
+					out[p] = name
+				}
+			}
+		}
+	}
+	add("vms", vms)
+	add("hosts", hosts)
+	return out
+}
+
+// assign returns stable pseudonyms for vms and hosts, creating missing ones.
+// Mode "hash" derives them from a keyed hash of the name (the same name
+// maps alike on every export from this machine); "pseudonym" numbers them
+// in order of first appearance.
+func (s *pseudonymStore) assign(vms, hosts []string, mode string) (map[string]string, map[string]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.salt == "" {
+		b := make([]byte, 32)
+		if _, err := rand.Read(b); err != nil {
+			return nil, nil, err
+		}
+		s.salt = hex.EncodeToString(b)
+	}
+	changed := false
+	pick := func(kind, prefix, name string) string {
+		key := kind
+		if mode == "hash" {
+			key += "_hashed"
+		}
+		table := s.tables[key]
+		if table == nil {
+			table = map[string]string{}
+			s.tables[key] = table
+		}
+		if p, ok := table[name]; ok {
+			return p
+		}
+		var p string
+		if mode == "hash" {
+			mac := hmac.New(sha256.New, []byte(s.salt))
+			mac.Write([]byte(prefix + "\x00" + name))
+			p = prefix + "-" + hex.EncodeToString(mac.Sum(nil))[:10]
+		} else {
+			p = fmt.Sprintf("%s-%04d", prefix, len(table)+1)
+		}
+		table[name] = p
+		changed = true
+		return p
+	}
+	vmOut := make(map[string]string, len(vms))
+	for _, name := range vms {
+		vmOut[name] = pick("vms", "vm", name)
+	}
+	hostOut := make(map[string]string, len(hosts))
+	for _, name := range hosts {
+		hostOut[name] = pick("hosts", "host", name)
+	}
+	if changed {
+		if err := s.persistLocked(); err != nil {
+			return nil, nil, err
+		}
+	}
+	return vmOut, hostOut, nil
+}
+
+func isNameByte(b byte) bool {
+	return b == '_' || (b >= '0' && b <= '9') || (b >= 'a' && b <= 'z') || (b >= 'A' && b <= 'Z')
+}
+
+// replaceNames substitutes every whole-token occurrence of a key of repl in
+// s in a single pass, preferring the longest name at each position so
+// "web-01" wins over "web" and pseudonyms are never rewritten again.
+func replaceNames(s string, names []string, repl map[string]string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); {
+		matched := false
+		if i == 0 || !isNameByte(s[i-1]) {
+			for _, name := range names {
+				end := i + len(name)
+				if end <= len(s) && s[i:end] == name && (end == len(s) || !isNameByte(s[end])) {
+					b.WriteString(repl[name])
+					i = end
+					matched = true
+					break
+				}
+			}
+		}
+		if !matched {
+			b.WriteByte(s[i])
+			i++
+		}
+	}
+	return b.String()
+}
+
+type anonymizeOptions struct {
+	Mode    string
+	Columns []int
+	Start   time.Time
+	End     time.Time
+}
+
+// writeAnonymizedCSV copies df to w limited to [start, end] and the chosen
+// columns (all when empty), with VM/world names and host names in the
+// header replaced by pseudonyms. Sample values are numbers and are copied
+// as-is. It returns how many VM names were replaced.
+// captureNames lists the VM and host names an export of df replaces,
+// longest first.
+func captureNames(df *DataFile) (vms, hosts []string) {
+	vmSet := map[string]bool{}
+	hostSet := map[string]bool{}
+	for i, raw := range df.Columns {
+		if i == 0 {
+			continue
+		}
+		if h := pdhHost(raw); h != "" {
+			hostSet[h] = true
+		}
+		c := parsePDHColumnBackend(raw, i)
+		if (strings.EqualFold(c.Object, "Group Cpu") || strings.EqualFold(c.Object, "Group Memory")) && !isSystemGroup(c.Instance) {
+			if name := vmDisplayName(c.Instance); name != "" {
+				vmSet[name] = true
+			}
+		}
+	}
+	sortedKeys := func(m map[string]bool) []string {
+		out := make([]string, 0, len(m))
+		for k := range m {
+			out = append(out, k)
+		}
+		sort.Slice(out, func(i, j int) bool {
+			if len(out[i]) != len(out[j]) {
+				return len(out[i]) > len(out[j])
+			}
+			return out[i] < out[j]
+		})
+		return out
+	}
+	return sortedKeys(vmSet), sortedKeys(hostSet)
+}
+
+func writeAnonymizedCSV(ctx context.Context, w io.Writer, df *DataFile, store *pseudonymStore, opts anonymizeOptions) (int, error) {
+	cols := opts.Columns
+	if len(cols) == 0 {
+		for i := 1; i < len(df.Columns); i++ {
+			cols = append(cols, i)
+		}
+	}
+	for _, idx := range cols {
+		if idx <= 0 || idx >= len(df.Columns) {
+			return 0, fmt.Errorf("column %d out of range", idx)
+		}
+	}
+
+	vms, hosts := captureNames(df)
+	vmRepl, hostRepl, err := store.assign(vms, hosts, opts.Mode)
+	if err != nil {
+		return 0, err
+	}
+	names := append(append([]string{}, vms...), hosts...)
+	repl := make(map[string]string, len(names))
+	for k, v := range vmRepl {
+		repl[k] = v
+	}
+	for k, v := range hostRepl {
+		repl[k] = v
+	}
+	sort.SliceStable(names, func(i, j int) bool { return len(names[i]) > len(names[j]) })
+
+	bw := bufio.NewWriterSize(w, exportChunkSize)
+	header := make([]string, 0, len(cols)+1)
+	header = append(header, df.Columns[0])
+	for _, idx := range cols {
+		header = append(header, replaceNames(df.Columns[idx], names, repl))
+	}
+	writeQuotedCSVRow(bw, header)
+
+	startOffset, _ := df.findOffset(opts.Start)
+	f, data, err := df.openData(startOffset)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	lines := getLineReader(data)
+	defer lines.release()
+	row := make([]string, 0, len(cols)+1)
+	var seen int64
+	for {
+		line, err := lines.next()
+		if err != nil && !errors.Is(err, io.EOF) {
+			return 0, err
+		}
+		if len(line) == 0 && errors.Is(err, io.EOF) {
+			break
+		}
+		if seen++; seen%exportCheckRows == 0 {
+			if cerr := ctx.Err(); cerr != nil {
+				return 0, cerr
+			}
+		}
+		record, perr := lines.record(line)
+		if perr == nil && len(record) > 0 {
+			ts, _, terr := parseTimeValue(record[0])
+			if terr == nil {
+				if !opts.End.IsZero() && ts.After(opts.End) {
+					break
+				}
+				if opts.Start.IsZero() || !ts.Before(opts.Start) {
+					row = append(row[:0], record[0])
+					for _, idx := range cols {
+						v := ""
+						if idx < len(record) {
+							v = record[idx]
+						}
+						row = append(row, v)
+					}
+					writeQuotedCSVRow(bw, row)
+				}
+			}
+		}
+		if errors.Is(err, io.EOF) {
+			break
+		}
+	}
+	return len(vms), bw.Flush()
+}
+
+// writeQuotedCSVRow writes fields the way esxtop does: every field quoted.
+func writeQuotedCSVRow(w *bufio.Writer, fields []string) {
+	for i, f := range fields {
+		if i > 0 {
+			w.WriteByte(',')
+		}
+		w.WriteByte('"')
+		w.WriteString(strings.ReplaceAll(f, `"`, `""`))
+		w.WriteByte('"')
+	}
+	w.WriteByte('\n')
+}
+
+// downloadWriter sets the download headers on the first write, so an
+// export that fails before producing output can still answer with JSON.
+// Every write is flushed to the client: exports hand it buffered chunks,
+// and nothing should pile up in the server's response buffers.
+type downloadWriter struct {
+	w           http.ResponseWriter
+	filename    string
+	contentType string
+	started     bool
+}
+
+func (d *downloadWriter) Write(b []byte) (int, error) {
+	if !d.started {
+		d.started = true
+		d.w.Header().Set("Content-Type", d.contentType)
+		d.w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", d.filename))
+	}
+	n, err := d.w.Write(b)
+	if err == nil {
+		err = http.NewResponseController(d.w).Flush()
+	}
+	return n, err
+}
+
+// runPseudonyms implements `esx-doctor pseudonyms [-json] [-file x.csv]`:
+// the mapping read straight from this machine's store, optionally only for
+// the names in one capture.
+func runPseudonyms(args []string) int {
+	fs := flag.NewFlagSet("pseudonyms", flag.ContinueOnError)
+	file := fs.String("file", "", "Only list the names in this capture")
+	asJSON := fs.Bool("json", false, "Print the mapping as JSON")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: esx-doctor pseudonyms [-json] [-file x.csv]")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() != 0 {
+		fs.Usage()
+		return 2
+	}
+	store, err := newPseudonymStore("")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to load pseudonym store: %v\n", err)
+		return 1
+	}
+	mapping := store.mapping()
+	if *file != "" {
+		path, err := filepath.Abs(*file)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "invalid path: %v\n", err)
+			return 1
+		}
+		df, err := loadOrBuildIndex(path)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "index build failed: %v\n", err)
+			return 1
+		}
+		mapping = store.mappingFor(captureNames(df))
+	}
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.SetEscapeHTML(false)
+		if err := enc.Encode(mapping); err != nil {
+			return 1
+		}
+		return 0
+	}
+	keys := make([]string, 0, len(mapping))
+	for p := range mapping {
+		keys = append(keys, p)
+	}
+	sort.Strings(keys)
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "PSEUDONYM\tNAME")
+	for _, p := range keys {
+		fmt.Fprintf(tw, "%s\t%s\n", p, mapping[p])
+	}
+	_ = tw.Flush()
+	return 0
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// runAttach implements "esx-doctor attach": it loads a file into a browser
+// tab's session, named by the id the tab shows under Dataset. On a server
+// reachable through loopback the server opens the path itself, which skips
+// copying a multi-gigabyte capture through HTTP; anywhere else (or with
+// -upload) the file is uploaded. The tab picks the new file up on its own.
+func runAttach(args []string) int {
+	fs := flag.NewFlagSet("attach", flag.ContinueOnError)
+	session := fs.String("session", "", "Session ID shown in the browser tab")
+	server := fs.String("server", "http://127.0.0.1:8080", "esx-doctor server URL")
+	upload := fs.Bool("upload", false, "Upload the file instead of opening it by path on the server")
+	stitch := fs.Bool("stitch", false, "Join the file with its rotated siblings (path mode only)")
+	force := fs.Bool("force", false, "Replace the tab's file even if someone locked it (servers started with -shared)")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: esx-doctor attach -session <id> [-server URL] [-upload] [-stitch] [-force] <file.csv>")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() != 1 || strings.TrimSpace(*session) == "" {
+		fs.Usage()
+		return 2
+	}
+	base, err := url.Parse(strings.TrimRight(*server, "/"))
+	if err != nil || base.Scheme == "" || base.Host == "" {
+		fmt.Fprintf(os.Stderr, "invalid server URL %q\n", *server)
+		return 2
+	}
+	path, err := filepath.Abs(fs.Arg(0))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "invalid path: %v\n", err)
+		return 1
+	}
+	if _, err := os.Stat(path); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	byUpload := *upload || !isLoopbackHost(base.Hostname())
+	if byUpload && *stitch {
+		fmt.Fprintln(os.Stderr, "-stitch needs the server to open the path; it cannot be combined with uploading")
+		return 2
+	}
+
+	c := &attachClient{base: base.String(), session: strings.TrimSpace(*session), http: &http.Client{}}
+	// Attaching to a session that is gone would quietly create a new one
+	// nobody is looking at.
+	if _, err := c.call(http.MethodGet, "/api/session", nil, ""); err != nil {
+		fmt.Fprintf(os.Stderr, "session %s: %v (is the browser tab still open?)\n", c.session, err)
+		return 1
+	}
+	query := ""
+	if *force {
+		query = "?force=1"
+	}
+	var label string
+	if byUpload {
+		label, err = c.upload(path, query)
+	} else {
+		label, err = c.open(path, *stitch, query)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "attach failed: %v\n", err)
+		return 1
+	}
+	fmt.Printf("attached %s to session %s\n", label, c.session)
+	return 0
+}
+
+func isLoopbackHost(host string) bool {
+	if strings.EqualFold(host, "localhost") {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+type attachClient struct {
+	base    string
+	session string
+	http    *http.Client
+}
+
+// call sends a request in the session and decodes the JSON answer; error
+// answers come back as their "error" text.
+func (c *attachClient) call(method, path string, body io.Reader, contentType string) (map[string]any, error) {
+	req, err := http.NewRequest(method, c.base+path, body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("X-ESX-Session-ID", c.session)
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	var out map[string]any
+	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+		return nil, fmt.Errorf("%s %s: status %d", method, path, resp.StatusCode)
+	}
+	if resp.StatusCode >= 300 {
+		if msg, ok := out["error"].(string); ok && msg != "" {
+			return nil, fmt.Errorf("%s", msg)
+		}
+		return nil, fmt.Errorf("%s %s: status %d", method, path, resp.StatusCode)
+	}
+	return out, nil
+}
+
+func (c *attachClient) open(path string, stitch bool, query string) (string, error) {
+	body, _ := json.Marshal(map[string]any{"path": path, "stitch": stitch})
+	out, err := c.call(http.MethodPost, "/api/open"+query, bytes.NewReader(body), "application/json")
+	if err != nil {
+		return "", err
+	}
+	label, _ := out["file"].(string)
+	return label, nil
+}
+
+// upload streams the file as the form /api/upload expects and waits for
+// the indexing job, so the command returns once the tab can show it.
+func (c *attachClient) upload(path, query string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	pr, pw := io.Pipe()
+	form := multipart.NewWriter(pw)
+	go func() {
+		part, err := form.CreateFormFile("file", filepath.Base(path))
+		if err == nil {
+			_, err = io.Copy(part, f)
+		}
+		if err == nil {
+			err = form.Close()
+		}
+		pw.CloseWithError(err)
+	}()
+	job, err := c.call(http.MethodPost, "/api/upload"+query, pr, form.FormDataContentType())
+	if err != nil {
+		return "", err
+	}
+	id, _ := job["id"].(string)
+	for {
+		switch status, _ := job["status"].(string); status {
+		case "done":
+			return filepath.Base(path), nil
+		case "failed":
+			msg, _ := job["error"].(string)
+			return "", fmt.Errorf("indexing failed: %s", msg)
+		case "superseded":
+			return "", fmt.Errorf("a newer upload to the session replaced this one")
+		}
+		time.Sleep(500 * time.Millisecond)
+		if job, err = c.call(http.MethodGet, "/api/jobs/"+url.PathEscape(id), nil, ""); err != nil {
+			return "", err
+		}
+	}
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+type TimeBookmark struct {
+	Name  string `json:"name"`
+	Start int64  `json:"start"`
+	End   int64  `json:"end"`
+	Note  string `json:"note,omitempty"`
+}
+
+type bookmarkStore struct {
+	mu    sync.RWMutex
+	path  string
+	files map[string]map[string]TimeBookmark
+}
+
+func defaultBookmarkStorePath() string {
+	home, err := os.UserHomeDir()
+	if err != nil || strings.TrimSpace(home) == "" {
+		return ".esx-doctor-bookmarks.json"
+	}
+	return filepath.Join(home, ".esx-doctor", "bookmarks.json")
+}
+
+func newBookmarkStore(path string) (*bookmarkStore, error) {
+	if strings.TrimSpace(path) == "" {
+		path = defaultBookmarkStorePath()
+	}
+	s := &bookmarkStore{
+		path:  path,
+		files: map[string]map[string]TimeBookmark{},
+	}
+	if err := s.load(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *bookmarkStore) load() error {
+	data, err := os.ReadFile(s.path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	var payload struct {
+		Files map[string][]TimeBookmark `json:"files"`
+	}
+	if err := json.Unmarshal(data, &payload); err != nil {
+		return fmt.Errorf("invalid bookmark store file: %w", err)
+	}
+	for file, list := range payload.Files {
+		m := make(map[string]TimeBookmark, len(list))
+		for _, b := range list {
+			if strings.TrimSpace(b.Name) == "" {
+				continue
+			}
+			m[bookmarkKey(b.Name)] = b
+		}
+		s.files[file] = m
+	}
+	return nil
+}
+
+// reload re-reads bookmarks from disk, discarding in-memory state.
+func (s *bookmarkStore) reload() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	prev := s.files
+	s.files = map[string]map[string]TimeBookmark{}
+	if err := s.load(); err != nil {
+		s.files = prev
+		return err
+	}
+	return nil
+}
+
+func (s *bookmarkStore) persistLocked() error {
+	dir := filepath.Dir(s.path)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+	out := make(map[string][]TimeBookmark, len(s.files))
+	for file, m := range s.files {
+		if len(m) == 0 {
+			continue
+		}
+		out[file] = sortedBookmarks(m)
+	}
+	data, err := json.MarshalIndent(map[string]any{"files": out}, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(s.path, data, 0o644)
+}
+
+// bookmarkFileKey identifies a capture across sessions. Uploaded files live
+// in per-upload temp paths, so the label is the stable handle.
+func bookmarkFileKey(df *DataFile) string {
+	if df == nil {
+		return ""
+	}
+	return df.Label
+}
+
+func bookmarkKey(name string) string {
+	return strings.ToLower(strings.TrimSpace(name))
+}
+
+func sortedBookmarks(m map[string]TimeBookmark) []TimeBookmark {
+	out := make([]TimeBookmark, 0, len(m))
+	for _, b := range m {
+		out = append(out, b)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Start != out[j].Start {
+			return out[i].Start < out[j].Start
+		}
+		return strings.ToLower(out[i].Name) < strings.ToLower(out[j].Name)
+	})
+	return out
+}
+
+func (s *bookmarkStore) list(df *DataFile) []TimeBookmark {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return sortedBookmarks(s.files[bookmarkFileKey(df)])
+}
+
+func (s *bookmarkStore) get(df *DataFile, name string) (TimeBookmark, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	b, ok := s.files[bookmarkFileKey(df)][bookmarkKey(name)]
+	return b, ok
+}
+
+func (s *bookmarkStore) upsert(df *DataFile, b TimeBookmark) (TimeBookmark, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	b.Name = strings.TrimSpace(b.Name)
+	b.Note = strings.TrimSpace(b.Note)
+	if b.Name == "" {
+		return b, fmt.Errorf("bookmark name is required")
+	}
+	if b.Start <= 0 || b.End <= 0 {
+		return b, fmt.Errorf("bookmark start and end are required")
+	}
+	if b.End < b.Start {
+		b.Start, b.End = b.End, b.Start
+	}
+	file := bookmarkFileKey(df)
+	if s.files[file] == nil {
+		s.files[file] = map[string]TimeBookmark{}
+	}
+	s.files[file][bookmarkKey(b.Name)] = b
+	if err := s.persistLocked(); err != nil {
+		return b, err
+	}
+	return b, nil
+}
+
+func (s *bookmarkStore) delete(df *DataFile, name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if strings.TrimSpace(name) == "" {
+		return fmt.Errorf("bookmark name is required")
+	}
+	delete(s.files[bookmarkFileKey(df)], bookmarkKey(name))
+	return s.persistLocked()
+}
+
+// resolve returns the time range of a named bookmark for df.
+func (s *bookmarkStore) resolve(df *DataFile, name string) (time.Time, time.Time, error) {
+	b, ok := s.get(df, name)
+	if !ok {
+		return time.Time{}, time.Time{}, fmt.Errorf("unknown bookmark %q", strings.TrimSpace(name))
+	}
+	return time.UnixMilli(b.Start).UTC(), time.UnixMilli(b.End).UTC(), nil
+}
@@ -0,0 +1,240 @@
+package main
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// vm-support bundles (.tgz) carry esxtop batch output somewhere under the
+// host's directory, named after the command that produced it. Rather than
+// guessing names, every regular file is sniffed for the PDH-CSV header
+// esxtop -b writes, gzip-compressed or not.
+
+// BundleCSV is an esxtop capture found inside a bundle.
+type BundleCSV struct {
+	Name       string `json:"name"`
+	Size       int64  `json:"size"`
+	ModTime    int64  `json:"modTime,omitempty"`
+	Compressed bool   `json:"compressed,omitempty"`
+}
+
+var pdhHeaderPrefix = []byte(`"(PDH-CSV`)
+
+// bundleSniffBytes is how much of each entry is read to recognize it.
+const bundleSniffBytes = 64
+
+// walkBundle calls fn for every regular file in the (optionally gzipped)
+// tar at bundlePath, with a reader positioned at the entry's data.
+func walkBundle(bundlePath string, fn func(hdr *tar.Header, r *bufio.Reader) error) error {
+	f, err := os.Open(bundlePath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	src, err := maybeGunzip(f)
+	if err != nil {
+		return err
+	}
+	tr := tar.NewReader(src)
+	br := bufio.NewReaderSize(nil, 64*1024)
+	for {
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("reading bundle: %w", err)
+		}
+		if hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+		br.Reset(tr)
+		if err := fn(hdr, br); err != nil {
+			return err
+		}
+	}
+}
+
+// sniffCapture reports whether r starts with an esxtop CSV header, and
+// whether that header is inside a gzip stream.
+func sniffCapture(r *bufio.Reader) (ok, compressed bool) {
+	head, _ := r.Peek(bundleSniffBytes)
+	if hasGzipMagic(head) {
+		zr, err := maybeGunzip(r)
+		if err != nil {
+			return false, false
+		}
+		inner := make([]byte, len(pdhHeaderPrefix))
+		n, _ := io.ReadFull(zr, inner)
+		return bytes.Equal(inner[:n], pdhHeaderPrefix), true
+	}
+	head = bytes.TrimPrefix(head, []byte("\xef\xbb\xbf"))
+	return bytes.HasPrefix(head, pdhHeaderPrefix), false
+}
+
+// listBundleCSVs returns the esxtop captures in a bundle in archive order.
+func listBundleCSVs(bundlePath string) ([]BundleCSV, error) {
+	out := []BundleCSV{}
+	err := walkBundle(bundlePath, func(hdr *tar.Header, r *bufio.Reader) error {
+		if ok, compressed := sniffCapture(r); ok {
+			out = append(out, BundleCSV{Name: hdr.Name, Size: hdr.Size, ModTime: unixMilliOrZero(hdr.ModTime), Compressed: compressed})
+		}
+		return nil
+	})
+	return out, err
+}
+
+// resolveBundleCSV matches name against the listed captures: the full
+// entry name, or a base name when only one capture has it.
+func resolveBundleCSV(list []BundleCSV, name string) (string, error) {
+	name = strings.TrimSpace(name)
+	var byBase []string
+	for _, c := range list {
+		if c.Name == name {
+			return c.Name, nil
+		}
+		if path.Base(c.Name) == name {
+			byBase = append(byBase, c.Name)
+		}
+	}
+	switch len(byBase) {
+	case 0:
+		return "", fmt.Errorf("no esxtop CSV named %q in the bundle", name)
+	case 1:
+		return byBase[0], nil
+	}
+	return "", fmt.Errorf("%q matches %d CSVs in the bundle; use the full name", name, len(byBase))
+}
+
+// extractBundleCSV copies the named capture out of the bundle into a new
+// temp file, decompressing it when needed, and returns the file's path.
+func extractBundleCSV(bundlePath, name string) (string, error) {
+	var tmpPath string
+	found := errors.New("found")
+	err := walkBundle(bundlePath, func(hdr *tar.Header, r *bufio.Reader) error {
+		if hdr.Name != name {
+			return nil
+		}
+		p, err := persistTempCSV(r, "esx-doctor-bundle-*.csv")
+		if err != nil {
+			return err
+		}
+		tmpPath = p
+		return found
+	})
+	if errors.Is(err, found) {
+		return tmpPath, nil
+	}
+	if err != nil {
+		return "", err
+	}
+	return "", fmt.Errorf("%q is not in the bundle", name)
+}
+
+// bundleLabel names a capture opened from a bundle, e.g.
+// vm-support-host1.tgz:esx-host1/commands/esxtop.csv.
+func bundleLabel(bundlePath, name string) string {
+	return filepath.Base(bundlePath) + ":" + name
+}
+
+// runBundle implements `esx-doctor bundle list|extract`.
+func runBundle(args []string) int {
+	usage := func() {
+		fmt.Fprintln(os.Stderr, "usage: esx-doctor bundle list <vm-support.tgz>")
+		fmt.Fprintln(os.Stderr, "       esx-doctor bundle extract [-out x.csv] <vm-support.tgz> <name>")
+	}
+	if len(args) == 0 {
+		usage()
+		return 2
+	}
+	cmd := args[0]
+	fs := flag.NewFlagSet("bundle "+cmd, flag.ContinueOnError)
+	out := fs.String("out", "", "File to write (extract; default the CSV's base name in the current directory)")
+	if err := fs.Parse(args[1:]); err != nil {
+		return 2
+	}
+	if (cmd == "list" && fs.NArg() != 1) || (cmd == "extract" && fs.NArg() != 2) || (cmd != "list" && cmd != "extract") {
+		usage()
+		return 2
+	}
+	list, err := listBundleCSVs(fs.Arg(0))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	if cmd == "list" {
+		if len(list) == 0 {
+			fmt.Fprintln(os.Stderr, "no esxtop CSVs found in the bundle")
+			return 1
+		}
+		tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		for _, c := range list {
+			note := ""
+			if c.Compressed {
+				note = "gzip"
+			}
+			fmt.Fprintf(tw, "%s\t%d\t%s\t%s\n", c.Name, c.Size, time.UnixMilli(c.ModTime).UTC().Format(time.RFC3339), note)
+		}
+		_ = tw.Flush()
+		return 0
+	}
+
+	name, err := resolveBundleCSV(list, fs.Arg(1))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	tmp, err := extractBundleCSV(fs.Arg(0), name)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	dest := *out
+	if strings.TrimSpace(dest) == "" {
+		dest = trimCaptureExt(path.Base(name)) + ".csv"
+	}
+	if err := moveFile(tmp, dest); err != nil {
+		_ = os.Remove(tmp)
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	fmt.Printf("wrote %s\n", dest)
+	return 0
+}
+
+// moveFile renames src to dst, copying when they are on different
+// filesystems (temp files often are).
+func moveFile(src, dst string) error {
+	if err := os.Rename(src, dst); err == nil {
+		return nil
+	}
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	outFile, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(outFile, in); err != nil {
+		_ = outFile.Close()
+		_ = os.Remove(dst)
+		return err
+	}
+	if err := outFile.Close(); err != nil {
+		return err
+	}
+	return os.Remove(src)
+}
@@ -0,0 +1,347 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// CapacityMetric is one host-level aggregate: every sample combines its
+// columns (sum or mean) into a single value, and the report keeps the
+// average and peak of that value over the window.
+type CapacityMetric struct {
+	Key      string    `json:"key"`
+	Label    string    `json:"label"`
+	Unit     string    `json:"unit"`
+	Instance string    `json:"instance,omitempty"`
+	Columns  []int     `json:"columns"`
+	Samples  int64     `json:"samples"`
+	Avg      float64   `json:"avg"`
+	Peak     float64   `json:"peak"`
+	PeakTime time.Time `json:"peakTime"`
+
+	combine string
+	sum     float64
+}
+
+type CapacitySection struct {
+	Key     string            `json:"key"`
+	Label   string            `json:"label"`
+	Metrics []*CapacityMetric `json:"metrics"`
+}
+
+type CapacityReport struct {
+	File     string            `json:"file"`
+	Hosts    []string          `json:"hosts"`
+	Start    time.Time         `json:"start"`
+	End      time.Time         `json:"end"`
+	Rows     int64             `json:"rows"`
+	VMs      int               `json:"vms"`
+	Sections []CapacitySection `json:"sections"`
+	// ActiveToGrantedPct is average active (touched) memory as a share of
+	// average granted memory across VMs; zero when either is missing.
+	ActiveToGrantedPct float64 `json:"activeToGrantedPct"`
+}
+
+// capacityMetricDefs lists the aggregates per section. Attributes are
+// matched through the alias table; parts of one metric are combined into
+// one per-sample value (e.g. read + write throughput).
+var capacityMetricDefs = []struct {
+	section, sectionLabel string
+	key, label, unit      string
+	combine               string // "sum" or "avg" across columns per sample
+	vmOnly                bool   // skip esxtop system groups
+	attributes            []string
+}{
+	{"cpu", "CPU", "host_cpu_util", "Host CPU utilization", "%", "avg", false, []string{"Physical Cpu: % Util Time"}},
+	{"cpu", "CPU", "host_core_util", "Host core utilization", "%", "avg", false, []string{"Physical Cpu: % Core Util Time"}},
+	{"cpu", "CPU", "vm_cpu_used", "VM CPU used (sum)", "%", "sum", true, []string{"Group Cpu: % Used"}},
+	{"memory", "Memory", "host_mem_free", "Host free memory", "MB", "sum", false, []string{"Memory: Free MBytes"}},
+	{"memory", "Memory", "vm_mem_granted", "VM memory granted (sum)", "MB", "sum", true, []string{"Group Memory: Memory Granted MBytes"}},
+	{"memory", "Memory", "vm_mem_active", "VM memory active/touched (sum)", "MB", "sum", true, []string{"Group Memory: Touched MBytes"}},
+	{"memory", "Memory", "vm_mem_swapped", "VM memory swapped (sum)", "MB", "sum", true, []string{"Group Memory: Swapped MBytes"}},
+	{"storage", "Storage", "disk_throughput", "Disk throughput (read + write)", "MB/s", "sum", false, []string{"Physical Disk SCSI Device: MBytes Read/sec", "Physical Disk SCSI Device: MBytes Written/sec"}},
+	{"storage", "Storage", "disk_iops", "Disk commands", "cmd/s", "sum", false, []string{"Physical Disk SCSI Device: Commands/sec"}},
+}
+
+// capacityNICAttributes are charted per physical uplink (vmnic instances).
+var capacityNICAttributes = []struct{ label, attribute string }{
+	{"transmit", "Network Port: MBits Transmitted/sec"},
+	{"receive", "Network Port: MBits Received/sec"},
+}
+
+// buildCapacityReport resolves the capacity aggregates against df and
+// computes them in one pass over [start, end] (zero means unbounded).
+func buildCapacityReport(df *DataFile, start, end time.Time) (CapacityReport, error) {
+	rep := CapacityReport{
+		File:     df.Label,
+		Hosts:    []string{},
+		Start:    df.StartTime,
+		End:      df.EndTime,
+		Sections: []CapacitySection{},
+	}
+	if !start.IsZero() {
+		rep.Start = start
+	}
+	if !end.IsZero() {
+		rep.End = end
+	}
+
+	hosts := map[string]bool{}
+	vms := map[string]bool{}
+	for i, raw := range df.Columns {
+		if i == 0 {
+			continue
+		}
+		if h := pdhHost(raw); h != "" && !hosts[h] {
+			hosts[h] = true
+			rep.Hosts = append(rep.Hosts, h)
+		}
+		c := parsePDHColumnBackend(raw, i)
+		if strings.EqualFold(c.Object, "Group Cpu") && !isSystemGroup(c.Instance) {
+			vms[c.Instance] = true
+		}
+	}
+	rep.VMs = len(vms)
+
+	var metrics []*CapacityMetric
+	bySection := map[string]int{}
+	addMetric := func(section, sectionLabel string, m *CapacityMetric) {
+		if len(m.Columns) == 0 {
+			return
+		}
+		pos, ok := bySection[section]
+		if !ok {
+			pos = len(rep.Sections)
+			bySection[section] = pos
+			rep.Sections = append(rep.Sections, CapacitySection{Key: section, Label: sectionLabel})
+		}
+		rep.Sections[pos].Metrics = append(rep.Sections[pos].Metrics, m)
+		metrics = append(metrics, m)
+	}
+
+	for _, def := range capacityMetricDefs {
+		m := &CapacityMetric{Key: def.key, Label: def.label, Unit: def.unit, Columns: []int{}, combine: def.combine}
+		for _, attr := range def.attributes {
+			idxs := df.resolveColumnsByAttribute(attr, nil)
+			// A _Total instance already is the host-wide value.
+			var total []int
+			for _, idx := range idxs {
+				if strings.EqualFold(parsePDHColumnBackend(df.Columns[idx], idx).Instance, "_Total") {
+					total = append(total, idx)
+				}
+			}
+			if len(total) > 0 {
+				idxs = total
+			}
+			for _, idx := range idxs {
+				if def.vmOnly && isSystemGroup(parsePDHColumnBackend(df.Columns[idx], idx).Instance) {
+					continue
+				}
+				m.Columns = append(m.Columns, idx)
+			}
+		}
+		addMetric(def.section, def.sectionLabel, m)
+	}
+
+	for _, na := range capacityNICAttributes {
+		for _, idx := range df.resolveColumnsByAttribute(na.attribute, nil) {
+			inst := parsePDHColumnBackend(df.Columns[idx], idx).Instance
+			nic := inst
+			if p := strings.LastIndex(nic, ":"); p >= 0 {
+				nic = nic[p+1:]
+			}
+			if !strings.HasPrefix(strings.ToLower(nic), "vmnic") {
+				continue
+			}
+			addMetric("network", "Network", &CapacityMetric{
+				Key:      "nic_" + na.label,
+				Label:    nic + " " + na.label,
+				Unit:     "Mbit/s",
+				Instance: inst,
+				Columns:  []int{idx},
+				combine:  "sum",
+			})
+		}
+	}
+
+	if len(metrics) > 0 {
+		if err := scanCapacityMetrics(df, metrics, start, end); err != nil {
+			return rep, err
+		}
+	}
+	for _, m := range metrics {
+		if m.Samples > 0 {
+			m.Avg = m.sum / float64(m.Samples)
+		}
+		if m.Samples > rep.Rows {
+			rep.Rows = m.Samples
+		}
+	}
+	var granted, active *CapacityMetric
+	for _, m := range metrics {
+		switch m.Key {
+		case "vm_mem_granted":
+			granted = m
+		case "vm_mem_active":
+			active = m
+		}
+	}
+	if granted != nil && active != nil && granted.Avg > 0 {
+		rep.ActiveToGrantedPct = active.Avg / granted.Avg * 100
+	}
+	return rep, nil
+}
+
+func scanCapacityMetrics(df *DataFile, metrics []*CapacityMetric, start, end time.Time) error {
+	for _, m := range metrics {
+		m.Peak = math.Inf(-1)
+	}
+	startOffset, _ := df.findOffset(start)
+	f, data, err := df.openData(startOffset)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	lines := getLineReader(data)
+	defer lines.release()
+	for {
+		line, err := lines.next()
+		if err != nil && !errors.Is(err, io.EOF) {
+			return err
+		}
+		if len(line) == 0 && errors.Is(err, io.EOF) {
+			break
+		}
+		record, perr := lines.record(line)
+		if perr == nil && len(record) > 0 {
+			ts, _, terr := parseTimeValue(record[0])
+			if terr == nil {
+				if !end.IsZero() && ts.After(end) {
+					break
+				}
+				if start.IsZero() || !ts.Before(start) {
+					for _, m := range metrics {
+						acc, n := 0.0, 0
+						for _, idx := range m.Columns {
+							if idx >= len(record) {
+								continue
+							}
+							v, ok := parseFloatValue(record[idx])
+							if !ok || !NumberFinite(v) {
+								continue
+							}
+							acc += v
+							n++
+						}
+						if n == 0 {
+							continue
+						}
+						if m.combine == "avg" {
+							acc /= float64(n)
+						}
+						m.Samples++
+						m.sum += acc
+						if acc > m.Peak {
+							m.Peak = acc
+							m.PeakTime = ts
+						}
+					}
+				}
+			}
+		}
+		if errors.Is(err, io.EOF) {
+			break
+		}
+	}
+	for _, m := range metrics {
+		if m.Samples == 0 {
+			m.Peak = 0
+		}
+	}
+	return nil
+}
+
+func writeCapacityText(w io.Writer, rep CapacityReport) {
+	fmt.Fprintf(w, "Capacity summary: %s\n", rep.File)
+	hosts := strings.Join(rep.Hosts, ", ")
+	if hosts == "" {
+		hosts = "unknown"
+	}
+	fmt.Fprintf(w, "Host: %s   VMs: %d   Samples: %d\n", hosts, rep.VMs, rep.Rows)
+	if !rep.Start.IsZero() {
+		fmt.Fprintf(w, "Window: %s .. %s\n", rep.Start.Format(time.RFC3339), rep.End.Format(time.RFC3339))
+	}
+	if len(rep.Sections) == 0 {
+		fmt.Fprintln(w, "\nNo capacity counters found in this capture.")
+		return
+	}
+	for _, sec := range rep.Sections {
+		fmt.Fprintf(w, "\n%s\n", sec.Label)
+		tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(tw, "METRIC\tAVG\tPEAK\tPEAK AT\tUNIT")
+		for _, m := range sec.Metrics {
+			peakAt := "-"
+			if !m.PeakTime.IsZero() {
+				peakAt = m.PeakTime.Format("2006-01-02 15:04:05")
+			}
+			fmt.Fprintf(tw, "%s\t%.2f\t%.2f\t%s\t%s\n", m.Label, m.Avg, m.Peak, peakAt, m.Unit)
+		}
+		_ = tw.Flush()
+		if sec.Key == "memory" && rep.ActiveToGrantedPct > 0 {
+			fmt.Fprintf(w, "Active vs granted: %.1f%%\n", rep.ActiveToGrantedPct)
+		}
+	}
+}
+
+// runCapacity implements `esx-doctor capacity [-json] <file.csv>`.
+func runCapacity(args []string) int {
+	fs := flag.NewFlagSet("capacity", flag.ContinueOnError)
+	asJSON := fs.Bool("json", false, "Print the report as JSON")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: esx-doctor capacity [-json] <file.csv>")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return 2
+	}
+	path, err := filepath.Abs(fs.Arg(0))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "invalid path: %v\n", err)
+		return 1
+	}
+	df, err := loadOrBuildIndex(path)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "index build failed: %v\n", err)
+		return 1
+	}
+	rep, err := buildCapacityReport(df, time.Time{}, time.Time{})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "capacity report failed: %v\n", err)
+		return 1
+	}
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.SetEscapeHTML(false)
+		if err := enc.Encode(rep); err != nil {
+			return 1
+		}
+		return 0
+	}
+	writeCapacityText(os.Stdout, rep)
+	return 0
+}
@@ -0,0 +1,68 @@
+package main
+
+import "strings"
+
+// objectDefaultCounters is the curated set of most diagnostic counters per
+// esxtop object, in the order they should be charted. Counter names are
+// matched through the alias table, so release-specific spellings still hit.
+var objectDefaultCounters = []struct {
+	object   string
+	counters []string
+}{
+	{"Physical Cpu", []string{"% Util Time", "% Core Util Time", "% Processor Time"}},
+	{"Group Cpu", []string{"% Used", "% Ready", "% CoStop", "% Max Limited", "% Swap Wait"}},
+	{"Vcpu", []string{"% Used", "% Ready", "% CoStop", "% Wait"}},
+	{"Memory", []string{"Free MBytes", "Memctl Current MBytes", "Zip Stored MBytes", "Swap Used MBytes", "Memory Overcommit (1 Minute Avg)"}},
+	{"Group Memory", []string{"Memory Granted MBytes", "Touched MBytes", "Memctl MBytes", "Swapped MBytes", "Numa % Local"}},
+	{"Numa Node", []string{"% Processor Time", "Free MBytes"}},
+	{"Physical Disk Adapter", []string{"Average Driver MilliSec/Command", "Average Kernel MilliSec/Command", "Commands/sec", "Commands Aborted/sec"}},
+	{"Physical Disk SCSI Device", []string{"Average Driver MilliSec/Command", "Average Kernel MilliSec/Command", "Average Queue MilliSec/Command", "Commands/sec", "Commands Aborted/sec"}},
+	{"Network Port", []string{"MBits Transmitted/sec", "MBits Received/sec", "Packets Transmitted/sec", "Packets Received/sec", "% Outbound Packets Dropped", "% Received Packets Dropped"}},
+	{"Power", []string{"Power Usage Now Watts", "Power Usage Cap Watts"}},
+}
+
+type CatalogDefault struct {
+	Attribute string   `json:"attribute"`
+	Present   bool     `json:"present"`
+	Columns   []int    `json:"columns"`
+	Instances []string `json:"instances"`
+}
+
+type CatalogDefaults struct {
+	Object   string           `json:"object"`
+	Defaults []CatalogDefault `json:"defaults"`
+	Error    string           `json:"error,omitempty"`
+}
+
+// catalogDefaults resolves the curated counters for object against the
+// loaded capture. ok is false when the object has no curated set.
+func catalogDefaults(df *DataFile, object string) (CatalogDefaults, bool) {
+	out := CatalogDefaults{Object: object, Defaults: []CatalogDefault{}}
+	var counters []string
+	for _, def := range objectDefaultCounters {
+		if strings.EqualFold(def.object, strings.TrimSpace(object)) {
+			out.Object = def.object
+			counters = def.counters
+			break
+		}
+	}
+	if counters == nil {
+		return out, false
+	}
+	for _, counter := range counters {
+		d := CatalogDefault{
+			Attribute: out.Object + ": " + counter,
+			Columns:   []int{},
+			Instances: []string{},
+		}
+		if df != nil {
+			for _, idx := range df.resolveColumnsByAttribute(d.Attribute, nil) {
+				d.Columns = append(d.Columns, idx)
+				d.Instances = append(d.Instances, parsePDHColumnBackend(df.Columns[idx], idx).Instance)
+			}
+		}
+		d.Present = len(d.Columns) > 0
+		out.Defaults = append(out.Defaults, d)
+	}
+	return out, true
+}
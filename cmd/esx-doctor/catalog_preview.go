@@ -0,0 +1,112 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"time"
+)
+
+const (
+	defaultPreviewWindow = time.Hour
+	maxPreviewColumns    = 500
+)
+
+type CatalogPreviewValue struct {
+	Column     int      `json:"column"`
+	Attribute  string   `json:"attribute"`
+	Instance   string   `json:"instance"`
+	Latest     *float64 `json:"latest"`
+	LatestTime int64    `json:"latestTime,omitempty"`
+	Mean       *float64 `json:"mean"`
+	Numeric    int      `json:"numeric"`
+}
+
+// CatalogPreview is a quick look at a set of counters: the last numeric
+// value of each and its mean over the capture's final window, enough to
+// show beside counter names while browsing without fetching series.
+type CatalogPreview struct {
+	Start  int64                 `json:"start"`
+	End    int64                 `json:"end"`
+	Values []CatalogPreviewValue `json:"values"`
+	Error  string                `json:"error,omitempty"`
+}
+
+// catalogPreview reads the rows in the last window of the capture (the
+// whole capture when it is shorter) once for all of cols.
+func (df *DataFile) catalogPreview(cols []int, window time.Duration) (CatalogPreview, error) {
+	out := CatalogPreview{Values: []CatalogPreviewValue{}}
+	if len(cols) == 0 {
+		return out, errors.New("no columns selected")
+	}
+	if len(cols) > maxPreviewColumns {
+		return out, fmt.Errorf("too many columns (%d, max %d)", len(cols), maxPreviewColumns)
+	}
+	for _, idx := range cols {
+		if idx <= 0 || idx >= len(df.Columns) {
+			return out, fmt.Errorf("column %d out of range", idx)
+		}
+	}
+	if window <= 0 {
+		window = defaultPreviewWindow
+	}
+	start := df.EndTime.Add(-window)
+	if start.Before(df.StartTime) {
+		start = df.StartTime
+	}
+	out.Start, out.End = start.UnixMilli(), df.EndTime.UnixMilli()
+
+	sums := make([]float64, len(cols))
+	for _, idx := range cols {
+		c := parsePDHColumnBackend(df.Columns[idx], idx)
+		out.Values = append(out.Values, CatalogPreviewValue{Column: idx, Attribute: c.AttributeLabel, Instance: c.Instance})
+	}
+
+	startOffset, _ := df.findOffset(start)
+	f, data, err := df.openData(startOffset)
+	if err != nil {
+		return out, err
+	}
+	defer f.Close()
+
+	lines := getLineReader(data)
+	defer lines.release()
+	for {
+		line, err := lines.next()
+		if err != nil && !errors.Is(err, io.EOF) {
+			return out, err
+		}
+		if len(line) == 0 && errors.Is(err, io.EOF) {
+			break
+		}
+		record, perr := lines.record(line)
+		if perr == nil && len(record) > 0 {
+			ts, _, terr := parseTimeValue(record[0])
+			if terr == nil && !ts.Before(start) {
+				for i, idx := range cols {
+					if idx >= len(record) {
+						continue
+					}
+					v, ok := parseFloatValue(record[idx])
+					if !ok || !NumberFinite(v) {
+						continue
+					}
+					pv := &out.Values[i]
+					pv.Latest, pv.LatestTime = &v, ts.UnixMilli()
+					sums[i] += v
+					pv.Numeric++
+				}
+			}
+		}
+		if errors.Is(err, io.EOF) {
+			break
+		}
+	}
+	for i := range out.Values {
+		if n := out.Values[i].Numeric; n > 0 {
+			mean := sums[i] / float64(n)
+			out.Values[i].Mean = &mean
+		}
+	}
+	return out, nil
+}
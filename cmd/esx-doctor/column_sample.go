@@ -0,0 +1,106 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+const (
+	defaultColumnSamples = 50
+	maxColumnSamples     = 1000
+)
+
+type ColumnSampleValue struct {
+	Time  int64    `json:"time"`
+	Raw   string   `json:"raw"`
+	Value *float64 `json:"value"`
+}
+
+// ColumnSample shows template authors what one counter really contains:
+// raw cells, so empty, "N/A" or slash-delimited values stay visible.
+type ColumnSample struct {
+	Column    int                 `json:"column"`
+	Name      string              `json:"name"`
+	Attribute string              `json:"attribute"`
+	Instance  string              `json:"instance"`
+	Samples   []ColumnSampleValue `json:"samples"`
+	Numeric   int                 `json:"numeric"`
+	Min       float64             `json:"min"`
+	Max       float64             `json:"max"`
+	Error     string              `json:"error,omitempty"`
+}
+
+// sampleColumn returns up to n evenly spaced rows of column idx within
+// [start, end] (zero means unbounded).
+func (df *DataFile) sampleColumn(idx, n int, start, end time.Time) (ColumnSample, error) {
+	out := ColumnSample{Column: idx, Samples: []ColumnSampleValue{}}
+	if idx <= 0 || idx >= len(df.Columns) {
+		return out, fmt.Errorf("column %d out of range", idx)
+	}
+	c := parsePDHColumnBackend(df.Columns[idx], idx)
+	out.Name, out.Attribute, out.Instance = c.Raw, c.AttributeLabel, c.Instance
+	if n <= 0 {
+		n = defaultColumnSamples
+	}
+	if n > maxColumnSamples {
+		n = maxColumnSamples
+	}
+	step := df.estimateRows(start, end) / int64(n)
+	if step < 1 {
+		step = 1
+	}
+
+	startOffset, _ := df.findOffset(start)
+	f, data, err := df.openData(startOffset)
+	if err != nil {
+		return out, err
+	}
+	defer f.Close()
+
+	lines := getLineReader(data)
+	defer lines.release()
+	var seen int64
+	for len(out.Samples) < n {
+		line, err := lines.next()
+		if err != nil && !errors.Is(err, io.EOF) {
+			return out, err
+		}
+		if len(line) == 0 && errors.Is(err, io.EOF) {
+			break
+		}
+		record, perr := lines.record(line)
+		if perr == nil && len(record) > 0 {
+			ts, _, terr := parseTimeValue(record[0])
+			if terr == nil && !end.IsZero() && ts.After(end) {
+				break
+			}
+			if terr == nil && (start.IsZero() || !ts.Before(start)) {
+				if seen%step == 0 {
+					s := ColumnSampleValue{Time: ts.UnixMilli()}
+					if idx < len(record) {
+						s.Raw = strings.Clone(record[idx])
+						if v, ok := parseFloatValue(s.Raw); ok && NumberFinite(v) {
+							s.Value = &v
+							if out.Numeric == 0 || v < out.Min {
+								out.Min = v
+							}
+							if out.Numeric == 0 || v > out.Max {
+								out.Max = v
+							}
+							out.Numeric++
+						}
+					}
+					out.Samples = append(out.Samples, s)
+				}
+				seen++
+			}
+		}
+		if errors.Is(err, io.EOF) {
+			break
+		}
+	}
+	return out, nil
+}
@@ -0,0 +1,146 @@
+package main
+
+import "strings"
+
+const (
+	defaultColumnSearchLimit = 200
+	maxColumnSearchLimit     = 5000
+)
+
+type ColumnInfo struct {
+	Index     int    `json:"index"`
+	Name      string `json:"name"`
+	Object    string `json:"object"`
+	Instance  string `json:"instance"`
+	Counter   string `json:"counter"`
+	Attribute string `json:"attribute"`
+}
+
+// ColumnSearchResponse is one page of the columns matching a search;
+// Total counts every match, so the UI can tell how many pages there are.
+type ColumnSearchResponse struct {
+	Total   int          `json:"total"`
+	Offset  int          `json:"offset"`
+	Limit   int          `json:"limit"`
+	Columns []ColumnInfo `json:"columns"`
+	Error   string       `json:"error,omitempty"`
+}
+
+// parsedColumns parses every header once per DataFile; index 0 is the
+// timestamp column and is left zero.
+func (df *DataFile) parsedColumns() []parsedColumn {
+	df.parsedOnce.Do(func() {
+		df.parsed = make([]parsedColumn, len(df.Columns))
+		for i := 1; i < len(df.Columns); i++ {
+			df.parsed[i] = parsePDHColumnBackend(df.Columns[i], i)
+		}
+	})
+	return df.parsed
+}
+
+// searchColumns pages through the columns matching query (every
+// whitespace-separated term must appear in the raw name or attribute),
+// object (exact) and instance (substring), all case-insensitive, in
+// column order.
+func (df *DataFile) searchColumns(query, object, instance string, offset, limit int) ColumnSearchResponse {
+	if limit <= 0 {
+		limit = defaultColumnSearchLimit
+	}
+	limit = min(limit, maxColumnSearchLimit)
+	offset = max(offset, 0)
+	out := ColumnSearchResponse{Offset: offset, Limit: limit, Columns: []ColumnInfo{}}
+	terms := strings.Fields(strings.ToLower(query))
+	object = strings.TrimSpace(object)
+	instance = strings.ToLower(strings.TrimSpace(instance))
+	cols := df.parsedColumns()
+	for i := 1; i < len(cols); i++ {
+		c := cols[i]
+		if object != "" && !strings.EqualFold(c.Object, object) {
+			continue
+		}
+		if instance != "" && !strings.Contains(strings.ToLower(c.Instance), instance) {
+			continue
+		}
+		if len(terms) > 0 {
+			hay := strings.ToLower(c.Raw + "\n" + c.AttributeLabel)
+			matched := true
+			for _, t := range terms {
+				if !strings.Contains(hay, t) {
+					matched = false
+					break
+				}
+			}
+			if !matched {
+				continue
+			}
+		}
+		if out.Total >= offset && len(out.Columns) < limit {
+			out.Columns = append(out.Columns, ColumnInfo{
+				Index:     c.Idx,
+				Name:      c.Raw,
+				Object:    c.Object,
+				Instance:  c.Instance,
+				Counter:   c.Counter,
+				Attribute: c.AttributeLabel,
+			})
+		}
+		out.Total++
+	}
+	return out
+}
+
+type ColumnTreeCounter struct {
+	Name  string `json:"name"`
+	Index int    `json:"index"`
+}
+
+type ColumnTreeInstance struct {
+	Name     string              `json:"name"`
+	Counters []ColumnTreeCounter `json:"counters"`
+}
+
+type ColumnTreeObject struct {
+	Name      string               `json:"name"`
+	Columns   int                  `json:"columns"`
+	Instances []ColumnTreeInstance `json:"instances"`
+}
+
+// ColumnTree is the header grouped Object → Instance → Counter, each level
+// in order of first appearance in the capture.
+type ColumnTree struct {
+	Objects []ColumnTreeObject `json:"objects"`
+	Error   string             `json:"error,omitempty"`
+}
+
+// columnTree groups the parsed columns; object, when set, keeps only that
+// object (case-insensitive) so a picker can expand one branch at a time.
+func (df *DataFile) columnTree(object string) ColumnTree {
+	out := ColumnTree{Objects: []ColumnTreeObject{}}
+	object = strings.TrimSpace(object)
+	objects := map[string]int{}
+	instances := map[[2]string]int{}
+	cols := df.parsedColumns()
+	for i := 1; i < len(cols); i++ {
+		c := cols[i]
+		if object != "" && !strings.EqualFold(c.Object, object) {
+			continue
+		}
+		oi, ok := objects[c.Object]
+		if !ok {
+			oi = len(out.Objects)
+			objects[c.Object] = oi
+			out.Objects = append(out.Objects, ColumnTreeObject{Name: c.Object, Instances: []ColumnTreeInstance{}})
+		}
+		o := &out.Objects[oi]
+		key := [2]string{c.Object, c.Instance}
+		ii, ok := instances[key]
+		if !ok {
+			ii = len(o.Instances)
+			instances[key] = ii
+			o.Instances = append(o.Instances, ColumnTreeInstance{Name: c.Instance})
+		}
+		o.Instances[ii].Counters = append(o.Instances[ii].Counters, ColumnTreeCounter{Name: c.Counter, Index: c.Idx})
+		o.Columns++
+	}
+	return out
+}
@@ -0,0 +1,227 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// A session can keep extra captures under labels ("healthy", "before",
+// ...) next to the file it has open, to overlay the same counters from a
+// good and a bad capture. The open file is always available as "current".
+
+const currentFileLabel = "current"
+
+type sessionFile struct {
+	label string
+	df    *DataFile
+}
+
+type SessionFile struct {
+	Label string `json:"label"`
+	File  string `json:"file"`
+	Rows  int64  `json:"rows"`
+	Start int64  `json:"start"`
+	End   int64  `json:"end"`
+}
+
+func describeSessionFile(label string, df *DataFile) SessionFile {
+	return SessionFile{Label: label, File: df.Label, Rows: df.Rows, Start: unixMilliOrZero(df.StartTime), End: unixMilliOrZero(df.EndTime)}
+}
+
+// Files lists the current file first, then the labeled ones in the order
+// they were added.
+func (s *Session) Files() []SessionFile {
+	out := []SessionFile{}
+	if df := s.Get(); df != nil {
+		out = append(out, describeSessionFile(currentFileLabel, df))
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for _, f := range s.compare {
+		out = append(out, describeSessionFile(f.label, f.df.latest()))
+	}
+	return out
+}
+
+// File returns the capture kept under label, the open file for "current"
+// or an empty label.
+func (s *Session) File(label string) *DataFile {
+	label = strings.TrimSpace(label)
+	if label == "" || strings.EqualFold(label, currentFileLabel) {
+		return s.Get()
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for _, f := range s.compare {
+		if strings.EqualFold(f.label, label) {
+			return f.df.latest()
+		}
+	}
+	return nil
+}
+
+// AddFile keeps df under label, replacing a capture already there.
+func (s *Session) AddFile(label string, df *DataFile) error {
+	label = strings.TrimSpace(label)
+	if label == "" {
+		return errors.New("label is required")
+	}
+	if strings.EqualFold(label, currentFileLabel) {
+		return fmt.Errorf("%q is reserved for the open file", currentFileLabel)
+	}
+	if len(label) > 64 {
+		return errors.New("label is too long")
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i, f := range s.compare {
+		if strings.EqualFold(f.label, label) {
+			s.compare[i].df = df
+			s.releaseLocked(f.df)
+			return nil
+		}
+	}
+	if len(s.compare) >= maxSessionFiles {
+		return fmt.Errorf("a session keeps at most %d labeled files", maxSessionFiles)
+	}
+	s.compare = append(s.compare, sessionFile{label: label, df: df})
+	return nil
+}
+
+// RemoveFile drops the capture kept under label.
+func (s *Session) RemoveFile(label string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i, f := range s.compare {
+		if strings.EqualFold(f.label, strings.TrimSpace(label)) {
+			s.compare = append(s.compare[:i], s.compare[i+1:]...)
+			s.releaseLocked(f.df)
+			return true
+		}
+	}
+	return false
+}
+
+// maxSessionFiles bounds the labeled files per session.
+const maxSessionFiles = 8
+
+// releaseLocked removes df's temp file once neither the open file nor a
+// labeled one uses it any more. Callers hold s.mu.
+func (s *Session) releaseLocked(df *DataFile) {
+	if df == nil || !df.OwnedTemp || df.Path == "" {
+		return
+	}
+	if s.df != nil && s.df.Path == df.Path {
+		return
+	}
+	for _, f := range s.compare {
+		if f.df.Path == df.Path {
+			return
+		}
+	}
+	_ = os.Remove(df.Path)
+}
+
+// CompareSeries is one file's part of a comparison. With relative
+// alignment Times are milliseconds since Origin, the file's first sample.
+type CompareSeries struct {
+	Label  string `json:"label"`
+	File   string `json:"file"`
+	Origin int64  `json:"origin"`
+	SeriesResponse
+}
+
+type CompareSeriesResponse struct {
+	Align string          `json:"align"`
+	Files []CompareSeries `json:"files"`
+	Error string          `json:"error,omitempty"`
+}
+
+// compareColumns picks the columns to read from each of files: the same
+// counters in every one, matched by attribute and instance since column
+// indexes differ between captures. cols are indexes into the first file;
+// attr (with optional instances) is resolved in each file.
+func compareColumns(files []*DataFile, cols []int, attr string, instances []string) ([][]int, error) {
+	out := make([][]int, len(files))
+	for _, idx := range cols {
+		if idx <= 0 || idx >= len(files[0].Columns) {
+			return nil, fmt.Errorf("column %d out of range", idx)
+		}
+	}
+	for i, df := range files {
+		if i == 0 {
+			out[i] = append(out[i], cols...)
+		} else {
+			for _, idx := range cols {
+				if m := df.matchColumn(files[0], idx); m > 0 {
+					out[i] = append(out[i], m)
+				}
+			}
+		}
+		if attr != "" {
+			out[i] = append(out[i], df.resolveColumnsByAttribute(attr, instances)...)
+		}
+	}
+	if len(out[0]) == 0 {
+		return nil, errors.New("no columns selected")
+	}
+	return out, nil
+}
+
+// matchColumn finds the column of df carrying the same counter and
+// instance as column idx of other, or returns -1.
+func (df *DataFile) matchColumn(other *DataFile, idx int) int {
+	want := parsePDHColumnBackend(other.Columns[idx], idx)
+	for i := 1; i < len(df.Columns); i++ {
+		c := parsePDHColumnBackend(df.Columns[i], i)
+		if sameAttribute(c.AttributeLabel, want.AttributeLabel) && strings.EqualFold(c.Instance, want.Instance) {
+			return i
+		}
+	}
+	return -1
+}
+
+// compareSeries reads the selected counters from each file. Absolute
+// alignment reads [start, end] of wall-clock time from all of them;
+// relative alignment reads [from, to] after each file's own first sample
+// (to zero meaning the end of the file) and rebases times on it, so
+// captures taken on different days line up.
+func compareSeries(labels []string, files []*DataFile, cols [][]int, relative bool, start, end time.Time, from, to time.Duration, maxPoints int, envelope bool) (CompareSeriesResponse, error) {
+	resp := CompareSeriesResponse{Align: "absolute", Files: make([]CompareSeries, 0, len(files))}
+	if relative {
+		resp.Align = "relative"
+	}
+	for i, df := range files {
+		cs := CompareSeries{Label: labels[i], File: df.Label}
+		s, e := start, end
+		if relative {
+			s = df.StartTime.Add(from)
+			e = time.Time{}
+			if to > 0 {
+				e = df.StartTime.Add(to)
+			}
+			cs.Origin = unixMilliOrZero(df.StartTime)
+		}
+		series, err := df.extractSeries(cols[i], nil, s, e, maxPoints, 0, envelope, nil)
+		if err != nil {
+			return resp, fmt.Errorf("%s: %w", labels[i], err)
+		}
+		if relative {
+			for j := range series.Times {
+				series.Times[j] -= cs.Origin
+			}
+			if series.Start != 0 {
+				series.Start -= cs.Origin
+			}
+			if series.End != 0 {
+				series.End -= cs.Origin
+			}
+		}
+		cs.SeriesResponse = series
+		resp.Files = append(resp.Files, cs)
+	}
+	return resp, nil
+}
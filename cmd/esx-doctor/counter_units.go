@@ -0,0 +1,112 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// counterUnit describes what a counter's values can be, for catching
+// thresholds written in the wrong unit.
+type counterUnit struct {
+	unit string
+	// max is the largest value a threshold can sensibly have.
+	max  float64
+	hint string
+}
+
+// maxGroupVCPUs is the largest VM ESXi runs; Group Cpu percentages are
+// summed over vCPUs, so they can reach 100 per vCPU.
+const maxGroupVCPUs = 768
+
+// unitForAttribute infers the unit of an esxtop counter from its name.
+// Only units with a hard ceiling are reported; rates and sizes are open
+// ended and cannot be checked this way.
+func unitForAttribute(label string) (counterUnit, bool) {
+	label = canonicalAttributeLabel(label)
+	object, counter, ok := strings.Cut(label, ": ")
+	if !ok {
+		return counterUnit{}, false
+	}
+	lc := strings.ToLower(counter)
+	switch {
+	case strings.Contains(lc, "millisec"):
+		// A full minute of latency is already a hung device.
+		return counterUnit{unit: "ms", max: 60000, hint: "esxtop reports latency in milliseconds; was this meant as microseconds?"}, true
+	case strings.HasPrefix(lc, "%") || strings.Contains(lc, " % "):
+		if strings.EqualFold(object, "Group Cpu") {
+			return counterUnit{unit: "% summed over vCPUs", max: 100 * maxGroupVCPUs, hint: "Group Cpu percentages reach 100 per vCPU"}, true
+		}
+		return counterUnit{unit: "%", max: 100, hint: "percentages range from 0 to 100; was this meant as a ratio or a per-vCPU sum?"}, true
+	}
+	return counterUnit{}, false
+}
+
+// detectorDefaultAttributes names the counter a detector type reads when
+// the template does not set target_attribute, for checks made before any
+// capture is loaded.
+var detectorDefaultAttributes = map[string]string{
+	"high_ready":                       "Group Cpu: % Ready",
+	"high_costop":                      "Group Cpu: % CoStop",
+	"storage_latency":                  "Physical Disk SCSI Device: Average Driver MilliSec/Command",
+	"low_numa_local":                   "Group Memory: Numa % Local",
+	"network_outbound_drop_high":       "Network Port: % Outbound Packets Dropped",
+	"disk_adapter_driver_latency_high": "Physical Disk Adapter: Average Driver MilliSec/Command",
+	"vcpu_ready_spread":                "Vcpu: % Ready",
+	"latency_attribution":              "Virtual Disk: Average MilliSec/Read",
+	"oversized_vcpus":                  "Vcpu: % Used",
+	"numa_span_remote":                 "Group Memory: Numa % Local",
+	"swap_wait":                        "Group Cpu: % Swap Wait",
+}
+
+// templateAttributes is the counter t is expected to read.
+func templateAttributes(t DiagnosticTemplate) []string {
+	if a := strings.TrimSpace(t.Detector.TargetAttribute); a != "" {
+		return []string{a}
+	}
+	if a, ok := detectorDefaultAttributes[t.Detector.Type]; ok {
+		return []string{a}
+	}
+	return nil
+}
+
+// thresholdUnitWarnings reports thresholds of t that no value of the given
+// counters can reach, e.g. 2000 on a percentage. Such templates silently
+// produce no findings.
+func thresholdUnitWarnings(t DiagnosticTemplate, attributes []string) []string {
+	name := t.Name
+	if strings.TrimSpace(name) == "" {
+		name = t.ID
+	}
+	seen := map[string]bool{}
+	var out []string
+	for _, attr := range attributes {
+		u, ok := unitForAttribute(attr)
+		if !ok || seen[u.unit] {
+			continue
+		}
+		seen[u.unit] = true
+		checks := []struct {
+			field string
+			value float64
+		}{
+			{"threshold", t.Detector.Threshold},
+			{"upper_threshold", t.Detector.UpperThreshold},
+			{"low_threshold", t.Detector.LowThreshold},
+			{"high_threshold", t.Detector.HighThreshold},
+		}
+		if t.Detector.Baseline != nil {
+			checks = append(checks, struct {
+				field string
+				value float64
+			}{"baseline.floor", t.Detector.Baseline.Floor})
+		}
+		for _, f := range checks {
+			if f.value > u.max {
+				out = append(out, fmt.Sprintf("%s: %s %g is above anything %s (%s, at most %g) can report, so it will never match; %s", name, f.field, f.value, canonicalAttributeLabel(attr), u.unit, u.max, u.hint))
+			}
+		}
+	}
+	sort.Strings(out)
+	return out
+}
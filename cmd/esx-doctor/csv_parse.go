@@ -0,0 +1,84 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"strings"
+	"unicode/utf8"
+)
+
+// strictCSV disables lenient quote handling (-csv-mode strict): lines with
+// stray or embedded quotes are rejected as malformed instead of being
+// parsed on a best-effort basis.
+var strictCSV bool
+
+const maxLenientSamples = 20
+
+// LenientLine describes a line that failed strict CSV parsing and was only
+// read with lenient quoting, so its field boundaries may not be what the
+// exporter intended.
+type LenientLine struct {
+	Offset  int64  `json:"offset"`
+	Row     int64  `json:"row"`
+	Reason  string `json:"reason"`
+	Preview string `json:"preview"`
+}
+
+// ShortRow is a data row with fewer fields than the header, typically a
+// sample cut short mid-capture. Fields are positional, so the ones present
+// keep their header columns. The missing trailing ones count as missing
+// samples for diagnostics and statistics; charts carry the previous value
+// forward over them (shortRowFill in the parse report).
+type ShortRow struct {
+	Offset   int64 `json:"offset"`
+	Row      int64 `json:"row"`
+	Fields   int   `json:"fields"`
+	Expected int   `json:"expected"`
+}
+
+func newLenientLine(offset, row int64, err error, line []byte) LenientLine {
+	preview := strings.TrimRight(string(line), "\r\n")
+	if len(preview) > 160 {
+		cut := 160
+		for cut > 0 && !utf8.RuneStart(preview[cut]) {
+			cut--
+		}
+		preview = preview[:cut] + "..."
+	}
+	return LenientLine{Offset: offset, Row: row, Reason: err.Error(), Preview: preview}
+}
+
+func parseCSVRecord(line []byte, lazy bool) ([]string, error) {
+	line = bytes.TrimRight(line, "\r\n")
+	r := csv.NewReader(bytes.NewReader(line))
+	r.FieldsPerRecord = -1
+	r.LazyQuotes = lazy
+	return r.Read()
+}
+
+func readCSVLine(line []byte) ([]string, error) {
+	record, err := parseCSVRecord(line, !strictCSV)
+	if err != nil {
+		return nil, err
+	}
+	return record, nil
+}
+
+// parseIndexedLine parses a data line during indexing with s. Strict
+// parsing is tried first; in lenient mode a failure falls back to lazy
+// quotes and the line is recorded for the parse report.
+func (df *DataFile) parseIndexedLine(s *fieldScanner, line []byte, offset, row int64) ([]string, error) {
+	record, err := s.scan(line, false)
+	if err == nil || strictCSV {
+		return record, err
+	}
+	record, lerr := parseCSVRecord(line, true)
+	if lerr != nil {
+		return nil, lerr
+	}
+	df.LenientLines++
+	if len(df.LenientSamples) < maxLenientSamples {
+		df.LenientSamples = append(df.LenientSamples, newLenientLine(offset, row, err, line))
+	}
+	return record, nil
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// CursorState is the crosshair position and selected range shared by every
+// client of one session. Times are Unix milliseconds; zero means unset.
+// Version increases on every update so pollers can wait for changes.
+type CursorState struct {
+	Version int64  `json:"version"`
+	Cursor  int64  `json:"cursor,omitempty"`
+	Start   int64  `json:"start,omitempty"`
+	End     int64  `json:"end,omitempty"`
+	Source  string `json:"source,omitempty"`
+	Updated int64  `json:"updated,omitempty"`
+}
+
+const maxCursorWait = 25 * time.Second
+
+// cursorSync holds a session's CursorState. Waiters block on changed, which
+// is closed and replaced on every update.
+type cursorSync struct {
+	mu      sync.Mutex
+	state   CursorState
+	changed chan struct{}
+}
+
+func (c *cursorSync) snapshot() (CursorState, <-chan struct{}) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.changed == nil {
+		c.changed = make(chan struct{})
+	}
+	return c.state, c.changed
+}
+
+func (c *cursorSync) set(next CursorState) CursorState {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	next.Version = c.state.Version + 1
+	next.Updated = time.Now().UnixMilli()
+	c.state = next
+	if c.changed != nil {
+		close(c.changed)
+	}
+	c.changed = make(chan struct{})
+	return next
+}
+
+// wait returns the state once its version is newer than since, or the
+// current state when timeout or ctx expires first.
+func (c *cursorSync) wait(ctx context.Context, since int64, timeout time.Duration) CursorState {
+	if timeout > maxCursorWait {
+		timeout = maxCursorWait
+	}
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	for {
+		state, changed := c.snapshot()
+		if state.Version > since || timeout <= 0 {
+			return state
+		}
+		select {
+		case <-changed:
+		case <-timer.C:
+			state, _ = c.snapshot()
+			return state
+		case <-ctx.Done():
+			state, _ = c.snapshot()
+			return state
+		}
+	}
+}
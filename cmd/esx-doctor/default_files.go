@@ -0,0 +1,146 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// defaultFileAssignments pins a different startup file per user or group,
+// so one shared instance can host several investigations. Identity comes
+// from headers set by an authenticating reverse proxy (-user-header,
+// -group-header); the proxy must strip any client-supplied copies.
+type defaultFileAssignments struct {
+	mu          sync.RWMutex
+	path        string
+	userHeader  string
+	groupHeader string
+	users       map[string]string
+	groups      map[string]string
+	// files holds one index per assigned CSV, shared by every session
+	// pinned to it.
+	files map[string]*DataFile
+}
+
+// newDefaultFileAssignments loads the assignment file and indexes every
+// CSV it names. The file looks like
+//
+//	{"users": {"alice": "/cases/1234.csv"}, "groups": {"storage": "/cases/5678.csv"}}
+func newDefaultFileAssignments(path, userHeader, groupHeader string) (*defaultFileAssignments, error) {
+	a := &defaultFileAssignments{
+		path:        path,
+		userHeader:  strings.TrimSpace(userHeader),
+		groupHeader: strings.TrimSpace(groupHeader),
+		users:       map[string]string{},
+		groups:      map[string]string{},
+		files:       map[string]*DataFile{},
+	}
+	if err := a.load(); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+func (a *defaultFileAssignments) load() error {
+	data, err := os.ReadFile(a.path)
+	if err != nil {
+		return err
+	}
+	var payload struct {
+		Users  map[string]string `json:"users"`
+		Groups map[string]string `json:"groups"`
+	}
+	if err := json.Unmarshal(data, &payload); err != nil {
+		return fmt.Errorf("invalid default file assignments: %w", err)
+	}
+
+	a.mu.RLock()
+	files := make(map[string]*DataFile, len(a.files))
+	for p, df := range a.files {
+		files[p] = df
+	}
+	a.mu.RUnlock()
+
+	users := map[string]string{}
+	groups := map[string]string{}
+	next := map[string]*DataFile{}
+	assign := func(dst map[string]string, name, csvPath string) error {
+		abs, err := filepath.Abs(strings.TrimSpace(csvPath))
+		if err != nil {
+			return err
+		}
+		if _, ok := next[abs]; !ok {
+			df, ok := files[abs]
+			if !ok {
+				if df, err = loadOrBuildIndex(abs); err != nil {
+					return fmt.Errorf("default file for %q: %w", name, err)
+				}
+				log.Printf("loaded default file for %q: %s", name, df.Label)
+			}
+			next[abs] = df
+		}
+		dst[strings.ToLower(strings.TrimSpace(name))] = abs
+		return nil
+	}
+	for name, p := range payload.Users {
+		if err := assign(users, name, p); err != nil {
+			return err
+		}
+	}
+	for name, p := range payload.Groups {
+		if err := assign(groups, name, p); err != nil {
+			return err
+		}
+	}
+
+	a.mu.Lock()
+	a.users, a.groups, a.files = users, groups, next
+	a.mu.Unlock()
+	return nil
+}
+
+func (a *defaultFileAssignments) reload() error {
+	return a.load()
+}
+
+// identity returns the user and groups the proxy asserted for r.
+func (a *defaultFileAssignments) identity(r *http.Request) (string, []string) {
+	var user string
+	var groups []string
+	if a.userHeader != "" {
+		user = strings.TrimSpace(r.Header.Get(a.userHeader))
+	}
+	if a.groupHeader != "" {
+		for _, g := range strings.Split(r.Header.Get(a.groupHeader), ",") {
+			if g = strings.TrimSpace(g); g != "" {
+				groups = append(groups, g)
+			}
+		}
+	}
+	return user, groups
+}
+
+// resolve returns the file pinned for the requester: a user assignment
+// wins, then the first of the user's groups (in header order) that has
+// one. nil means no assignment applies.
+func (a *defaultFileAssignments) resolve(r *http.Request) *DataFile {
+	user, groups := a.identity(r)
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	if user != "" {
+		if p, ok := a.users[strings.ToLower(user)]; ok {
+			return a.files[p]
+		}
+	}
+	for _, g := range groups {
+		if p, ok := a.groups[strings.ToLower(g)]; ok {
+			return a.files[p]
+		}
+	}
+	return nil
+}
@@ -1,16 +1,13 @@
 package main
 
 import (
-	"bufio"
-	"bytes"
 	"embed"
-	"encoding/csv"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
 	"math"
-	"os"
+	"math/bits"
 	"regexp"
 	"sort"
 	"strconv"
@@ -24,25 +21,54 @@ type DiagnosticTemplate struct {
 	Description string           `json:"description"`
 	Enabled     bool             `json:"enabled"`
 	Severity    string           `json:"severity"`
+	Extends     string           `json:"extends,omitempty"`
 	Detector    DetectorTemplate `json:"detector"`
 }
 
 type DetectorTemplate struct {
-	Type                    string         `json:"type"`
-	TargetAttribute         string         `json:"target_attribute,omitempty"`
-	Threshold               float64        `json:"threshold,omitempty"`
-	UpperThreshold          float64        `json:"upper_threshold,omitempty"`
-	Comparison              string         `json:"comparison,omitempty"`
-	MinConsecutive          int            `json:"min_consecutive,omitempty"`
-	MinSwitches             int            `json:"min_switches,omitempty"`
-	MinGap                  float64        `json:"min_gap,omitempty"`
-	LowThreshold            float64        `json:"low_threshold,omitempty"`
-	HighThreshold           float64        `json:"high_threshold,omitempty"`
-	IncludeAttributeEquals  []string       `json:"include_attribute_equals,omitempty"`
-	IncludeObjectEquals     []string       `json:"include_object_equals,omitempty"`
-	ExcludeInstanceContains []string       `json:"exclude_instance_contains,omitempty"`
-	ExcludeInstanceRegex    []string       `json:"exclude_instance_regex,omitempty"`
-	Filter                  TemplateFilter `json:"filter,omitempty"`
+	Type                    string   `json:"type"`
+	TargetAttribute         string   `json:"target_attribute,omitempty"`
+	Threshold               float64  `json:"threshold,omitempty"`
+	UpperThreshold          float64  `json:"upper_threshold,omitempty"`
+	Comparison              string   `json:"comparison,omitempty"`
+	MinConsecutive          int      `json:"min_consecutive,omitempty"`
+	MinSwitches             int      `json:"min_switches,omitempty"`
+	MinGap                  float64  `json:"min_gap,omitempty"`
+	LowThreshold            float64  `json:"low_threshold,omitempty"`
+	HighThreshold           float64  `json:"high_threshold,omitempty"`
+	IncludeAttributeEquals  []string `json:"include_attribute_equals,omitempty"`
+	IncludeObjectEquals     []string `json:"include_object_equals,omitempty"`
+	ExcludeInstanceContains []string `json:"exclude_instance_contains,omitempty"`
+	ExcludeInstanceRegex    []string `json:"exclude_instance_regex,omitempty"`
+	IncludeInstanceRegex    []string `json:"include_instance_regex,omitempty"`
+	MinDurationSeconds      float64  `json:"min_duration_seconds,omitempty"`
+	MaxGapFactor            float64  `json:"max_gap_factor,omitempty"`
+	MaxColumns              int      `json:"max_columns,omitempty"`
+	MaxAffinityPCPUs        int      `json:"max_affinity_pcpus,omitempty"`
+	WindowSamples           int      `json:"window_samples,omitempty"`
+	MinVCPUs                int      `json:"min_vcpus,omitempty"`
+	// MaxFindings caps the findings kept (negative for no cap) and Rank
+	// picks which ones: default, duration, peak or severity (see
+	// finding_limits.go).
+	MaxFindings int    `json:"max_findings,omitempty"`
+	Rank        string `json:"rank,omitempty"`
+	// Baseline makes threshold detectors relative: each column's bound is
+	// its own early-capture percentile times a factor.
+	Baseline *ThresholdBaseline `json:"baseline,omitempty"`
+	Filter   TemplateFilter     `json:"filter,omitempty"`
+}
+
+// ThresholdBaseline derives a threshold per column from the first Minutes
+// of the analyzed range: the column's Percentile there times Factor becomes
+// the bound Comparison selects (above it for "greater", below it for
+// "less"). Floor is an absolute minimum for "greater" bounds, so counters
+// that idle near zero early on don't flag every later blip; without it,
+// columns with a zero baseline are skipped.
+type ThresholdBaseline struct {
+	Percentile float64 `json:"percentile,omitempty"` // default 50
+	Minutes    float64 `json:"minutes,omitempty"`    // default 10
+	Factor     float64 `json:"factor"`
+	Floor      float64 `json:"floor,omitempty"`
 }
 
 type TemplateFilter struct {
@@ -75,13 +101,29 @@ type DiagnosticFinding struct {
 	Start          int64    `json:"start,omitempty"`
 	End            int64    `json:"end,omitempty"`
 	Summary        string   `json:"summary"`
+	// Message is what Summary was rendered from, for rendering it again
+	// from another message pack; plugin findings may not have one.
+	Message *FindingMessage `json:"message,omitempty"`
+	// AlsoFlaggedBy lists other templates that reported the same counter,
+	// instances and window; see dedupeFindings.
+	AlsoFlaggedBy []FindingAttribution `json:"alsoFlaggedBy,omitempty"`
+	// ID is derived from the rule and what it found, so a replay that
+	// reproduces the finding yields the same ID; Replay is what the replay
+	// needs (see finding_replay.go).
+	ID     string         `json:"id,omitempty"`
+	Replay *FindingReplay `json:"replay,omitempty"`
 }
 
 type DiagnosticRunResponse struct {
+	RunID       string              `json:"runId,omitempty"`
 	Findings    []DiagnosticFinding `json:"findings"`
 	Templates   int                 `json:"templates"`
 	RowsScanned int64               `json:"rowsScanned"`
+	HealthScore int                 `json:"healthScore"`
 	DurationMs  int64               `json:"durationMs"`
+	Truncated   bool                `json:"truncated,omitempty"`
+	Warnings    []string            `json:"warnings,omitempty"`
+	Trace       *QueryTrace         `json:"trace,omitempty"`
 	Error       string              `json:"error,omitempty"`
 }
 
@@ -138,14 +180,6 @@ func parsePDHColumnBackend(raw string, idx int) parsedColumn {
 	}
 }
 
-func readCSVLineBytes(line []byte) ([]string, error) {
-	line = bytes.TrimRight(line, "\r\n")
-	r := csv.NewReader(bytes.NewReader(line))
-	r.FieldsPerRecord = -1
-	r.LazyQuotes = true
-	return r.Read()
-}
-
 func loadDiagnosticTemplates(fs embed.FS) ([]DiagnosticTemplate, error) {
 	entries, err := fs.ReadDir("templates")
 	if err != nil {
@@ -164,7 +198,7 @@ func loadDiagnosticTemplates(fs embed.FS) ([]DiagnosticTemplate, error) {
 		if err := json.Unmarshal(data, &t); err != nil {
 			return nil, fmt.Errorf("invalid template %s: %w", e.Name(), err)
 		}
-		if strings.TrimSpace(t.ID) == "" || strings.TrimSpace(t.Name) == "" || strings.TrimSpace(t.Detector.Type) == "" {
+		if strings.TrimSpace(t.ID) == "" || strings.TrimSpace(t.Name) == "" || (strings.TrimSpace(t.Detector.Type) == "" && strings.TrimSpace(t.Extends) == "") {
 			return nil, fmt.Errorf("invalid template %s: missing required fields", e.Name())
 		}
 		if strings.TrimSpace(t.Severity) == "" {
@@ -179,16 +213,19 @@ func loadDiagnosticTemplates(fs embed.FS) ([]DiagnosticTemplate, error) {
 type rowProcessor interface {
 	onRow(ts time.Time, record []string)
 	finalize() []DiagnosticFinding
+	columnIndexes() []int
 }
 
 type thresholdEntityState struct {
-	currLen   int
-	currStart time.Time
-	currPeak  float64
-	bestLen   int
-	bestStart time.Time
-	bestEnd   time.Time
-	bestPeak  float64
+	currLen      int
+	currStart    time.Time
+	currLast     time.Time
+	currPeak     float64
+	bestLen      int
+	bestStart    time.Time
+	bestEnd      time.Time
+	bestDuration time.Duration
+	bestPeak     float64
 }
 
 type thresholdProcessor struct {
@@ -202,24 +239,147 @@ type thresholdProcessor struct {
 	hasLowerBound  bool
 	hasUpperBound  bool
 	minConsecutive int
-	states         []thresholdEntityState
+	// minDuration switches the streak requirement from a sample count to
+	// elapsed time; maxGap breaks streaks across capture gaps.
+	minDuration time.Duration
+	maxGap      time.Duration
+	lastTs      time.Time
+	states      []thresholdEntityState
+	// baseline, when set, replaces the bound the comparison picks with a
+	// per-column one once its window has passed.
+	baseline *thresholdBaselineState
+	values   []float64
+}
+
+// thresholdBaselineState holds the rows of the baseline window until the
+// bounds are known, then replays them, so the window is evaluated too.
+type thresholdBaselineState struct {
+	config ThresholdBaseline
+	less   bool
+	until  time.Time
+	held   []thresholdHeldRow
+	ready  bool
+	base   []float64 // per column; NaN when the window had no samples
+	bounds []float64 // per column; NaN when the column is not evaluated
+}
+
+type thresholdHeldRow struct {
+	ts     time.Time
+	values []float64
+}
+
+// Cells in thresholdProcessor.values: a missing field (short row) is
+// skipped, an unparseable one ends the streak.
+var thresholdMissing = math.Inf(-1)
+
+func (p *thresholdProcessor) bounds(i int) (lower, upper float64, hasLower, hasUpper, ok bool) {
+	lower, upper, hasLower, hasUpper = p.lowerBound, p.upperBound, p.hasLowerBound, p.hasUpperBound
+	if p.baseline == nil {
+		return lower, upper, hasLower, hasUpper, true
+	}
+	b := p.baseline.bounds[i]
+	if math.IsNaN(b) {
+		return 0, 0, false, false, false
+	}
+	if p.baseline.less {
+		upper, hasUpper = b, true
+	} else {
+		lower, hasLower = b, true
+	}
+	return lower, upper, hasLower, hasUpper, true
+}
+
+// settleBaseline computes each column's bound from the held window.
+func (p *thresholdProcessor) settleBaseline() {
+	b := p.baseline
+	b.ready = true
+	b.base = make([]float64, len(p.indexes))
+	b.bounds = make([]float64, len(p.indexes))
+	samples := make([]float64, 0, len(b.held))
+	for i := range p.indexes {
+		samples = samples[:0]
+		for _, row := range b.held {
+			if v := row.values[i]; NumberFinite(v) {
+				samples = append(samples, v)
+			}
+		}
+		b.base[i], b.bounds[i] = math.NaN(), math.NaN()
+		if len(samples) == 0 {
+			continue
+		}
+		sort.Float64s(samples)
+		rank := int(math.Ceil(b.config.Percentile/100*float64(len(samples)))) - 1
+		base := samples[max(0, min(rank, len(samples)-1))]
+		b.base[i] = base
+		bound := base * b.config.Factor
+		if !b.less && bound < b.config.Floor {
+			bound = b.config.Floor
+		}
+		if bound <= 0 && !(b.less && base > 0) {
+			continue
+		}
+		b.bounds[i] = bound
+	}
+	held := b.held
+	b.held = nil
+	for _, row := range held {
+		p.evaluate(row.ts, row.values)
+	}
 }
 
 func (p *thresholdProcessor) onRow(ts time.Time, record []string) {
+	if len(p.values) != len(p.indexes) {
+		p.values = make([]float64, len(p.indexes))
+		p.states = make([]thresholdEntityState, len(p.indexes))
+	}
 	for i, idx := range p.indexes {
+		p.values[i] = thresholdMissing
 		if idx < 0 || idx >= len(record) {
 			continue
 		}
 		v, ok := parseFloatValue(record[idx])
 		if !ok || !NumberFinite(v) {
+			v = math.NaN()
+		}
+		p.values[i] = v
+	}
+	if b := p.baseline; b != nil && !b.ready {
+		if b.until.IsZero() {
+			b.until = ts.Add(time.Duration(b.config.Minutes * float64(time.Minute)))
+		}
+		if ts.Before(b.until) {
+			b.held = append(b.held, thresholdHeldRow{ts: ts, values: append([]float64(nil), p.values...)})
+			return
+		}
+		p.settleBaseline()
+	}
+	p.evaluate(ts, p.values)
+}
+
+func (p *thresholdProcessor) evaluate(ts time.Time, values []float64) {
+	if p.maxGap > 0 && !p.lastTs.IsZero() && ts.Sub(p.lastTs) > p.maxGap {
+		for i := range p.states {
+			p.reset(i, p.lastTs)
+		}
+	}
+	p.lastTs = ts
+	for i, v := range values {
+		if v == thresholdMissing {
+			continue
+		}
+		if math.IsNaN(v) {
 			p.reset(i, ts)
 			continue
 		}
+		lower, upper, hasLower, hasUpper, ok := p.bounds(i)
+		if !ok {
+			continue
+		}
 		matched := true
-		if p.hasLowerBound && v < p.lowerBound {
+		if hasLower && v < lower {
 			matched = false
 		}
-		if p.hasUpperBound && v > p.upperBound {
+		if hasUpper && v > upper {
 			matched = false
 		}
 		if matched {
@@ -230,6 +390,7 @@ func (p *thresholdProcessor) onRow(ts time.Time, record []string) {
 			} else if v > s.currPeak {
 				s.currPeak = v
 			}
+			s.currLast = ts
 			s.currLen++
 			continue
 		}
@@ -239,35 +400,62 @@ func (p *thresholdProcessor) onRow(ts time.Time, record []string) {
 
 func (p *thresholdProcessor) reset(i int, ts time.Time) {
 	s := &p.states[i]
-	if s.currLen > s.bestLen {
+	duration := s.currLast.Sub(s.currStart)
+	better := s.currLen > s.bestLen
+	if p.minDuration > 0 {
+		better = s.currLen > 0 && duration > s.bestDuration
+	}
+	if better {
 		s.bestLen = s.currLen
 		s.bestStart = s.currStart
 		s.bestEnd = ts
+		s.bestDuration = duration
 		s.bestPeak = s.currPeak
 	}
 	s.currLen = 0
 	s.currPeak = 0
 }
 
+func (p *thresholdProcessor) columnIndexes() []int { return p.indexes }
+
 func (p *thresholdProcessor) finalize() []DiagnosticFinding {
+	if b := p.baseline; b != nil && !b.ready {
+		// The capture ended inside the baseline window.
+		p.settleBaseline()
+	}
 	for i := range p.states {
 		// finalize open streaks
-		p.reset(i, time.Time{})
+		p.reset(i, p.lastTs)
 	}
 	findings := make([]DiagnosticFinding, 0, len(p.states))
 	for i, s := range p.states {
-		if s.bestLen < p.minConsecutive {
+		if p.minDuration > 0 {
+			if s.bestLen == 0 || s.bestDuration < p.minDuration {
+				continue
+			}
+		} else if s.bestLen < p.minConsecutive {
 			continue
 		}
-		rangeText := "within configured bounds"
-		if p.hasLowerBound && p.hasUpperBound {
-			rangeText = fmt.Sprintf("between %.2f and %.2f", p.lowerBound, p.upperBound)
-		} else if p.hasLowerBound {
-			rangeText = fmt.Sprintf("above %.2f", p.lowerBound)
-		} else if p.hasUpperBound {
-			rangeText = fmt.Sprintf("below %.2f", p.upperBound)
+		lower, upper, hasLower, hasUpper, _ := p.bounds(i)
+		rangeText := newMessage("threshold.range_configured")
+		if hasLower && hasUpper {
+			rangeText = newMessage("threshold.range_between", "low", lower, "high", upper)
+		} else if hasLower {
+			rangeText = newMessage("threshold.range_above", "low", lower)
+		} else if hasUpper {
+			rangeText = newMessage("threshold.range_below", "high", upper)
+		}
+		if b := p.baseline; b != nil {
+			key := "threshold.range_baseline"
+			if !b.less && b.base[i]*b.config.Factor < b.config.Floor {
+				key = "threshold.range_baseline_floor"
+			}
+			rangeText = newMessage(key, "range", rangeText, "factor", b.config.Factor, "percentile", b.config.Percentile, "base", b.base[i], "minutes", b.config.Minutes)
+		}
+		msg := newMessage("threshold.sustained", "range", rangeText, "samples", s.bestLen, "peak", s.bestPeak)
+		if p.minDuration > 0 {
+			msg = newMessage("threshold.sustained_duration", "range", rangeText, "duration", s.bestDuration.Round(time.Second).String(), "samples", s.bestLen, "peak", s.bestPeak)
 		}
-		summary := fmt.Sprintf("Sustained threshold breach: values stayed %s for %d consecutive samples (peak %.2f).", rangeText, s.bestLen, s.bestPeak)
 		f := DiagnosticFinding{
 			TemplateID:     p.template.ID,
 			TemplateName:   p.template.Name,
@@ -276,7 +464,8 @@ func (p *thresholdProcessor) finalize() []DiagnosticFinding {
 			ReportKey:      p.reportKey,
 			AttributeLabel: p.attributeLabel,
 			Instances:      []string{p.labels[i]},
-			Summary:        summary,
+			Summary:        msg.String(),
+			Message:        msg,
 		}
 		if !s.bestStart.IsZero() {
 			f.Start = s.bestStart.UnixMilli()
@@ -286,9 +475,6 @@ func (p *thresholdProcessor) finalize() []DiagnosticFinding {
 		}
 		findings = append(findings, f)
 	}
-	if len(findings) > 20 {
-		findings = findings[:20]
-	}
 	return findings
 }
 
@@ -366,11 +552,14 @@ func (p *rangeImbalanceProcessor) reset(ts time.Time) {
 	p.currLow = ""
 }
 
+func (p *rangeImbalanceProcessor) columnIndexes() []int { return p.indexes }
+
 func (p *rangeImbalanceProcessor) finalize() []DiagnosticFinding {
 	p.reset(time.Time{})
 	if p.bestLen < p.minConsecutive {
 		return nil
 	}
+	msg := newMessage("range_imbalance", "high", p.highThreshold, "low", p.lowThreshold, "samples", p.bestLen)
 	out := DiagnosticFinding{
 		TemplateID:     p.template.ID,
 		TemplateName:   p.template.Name,
@@ -379,7 +568,8 @@ func (p *rangeImbalanceProcessor) finalize() []DiagnosticFinding {
 		ReportKey:      p.reportKey,
 		AttributeLabel: p.attributeLabel,
 		Instances:      []string{p.bestHigh, p.bestLow},
-		Summary:        fmt.Sprintf("Persistent imbalance: one node stayed high (>=%.1f%%) while another stayed low (<=%.1f%%) for %d samples.", p.highThreshold, p.lowThreshold, p.bestLen),
+		Summary:        msg.String(),
+		Message:        msg,
 	}
 	if !p.bestStart.IsZero() {
 		out.Start = p.bestStart.UnixMilli()
@@ -442,6 +632,8 @@ func (p *numaZigzagProcessor) onRow(ts time.Time, record []string) {
 	p.prevDominant = bestIdx
 }
 
+func (p *numaZigzagProcessor) columnIndexes() []int { return p.indexes }
+
 func (p *numaZigzagProcessor) finalize() []DiagnosticFinding {
 	if p.switches < p.minSwitches || p.observations < p.minSwitches+1 {
 		return nil
@@ -457,6 +649,7 @@ func (p *numaZigzagProcessor) finalize() []DiagnosticFinding {
 			break
 		}
 	}
+	msg := newMessage("numa_zigzag", "switches", p.switches, "samples", p.observations)
 	return []DiagnosticFinding{{
 		TemplateID:   p.template.ID,
 		TemplateName: p.template.Name,
@@ -464,7 +657,8 @@ func (p *numaZigzagProcessor) finalize() []DiagnosticFinding {
 		Severity:     p.template.Severity,
 		ReportKey:    "numa",
 		Instances:    instances,
-		Summary:      fmt.Sprintf("Detected %d dominance switches across NUMA nodes (%d analyzed samples).", p.switches, p.observations),
+		Summary:      msg.String(),
+		Message:      msg,
 		Start:        p.firstSwitch.UnixMilli(),
 		End:          p.lastSwitch.UnixMilli(),
 	}}
@@ -480,6 +674,11 @@ type affinityProcessor struct {
 }
 
 func (p *affinityProcessor) onRow(ts time.Time, record []string) {
+	if len(p.hitCounts) != len(p.indexes) {
+		p.hitCounts = make([]int, len(p.indexes))
+		p.firstSeen = make([]time.Time, len(p.indexes))
+		p.lastSeen = make([]time.Time, len(p.indexes))
+	}
 	for i, idx := range p.indexes {
 		if idx < 0 || idx >= len(record) {
 			continue
@@ -495,6 +694,8 @@ func (p *affinityProcessor) onRow(ts time.Time, record []string) {
 	}
 }
 
+func (p *affinityProcessor) columnIndexes() []int { return p.indexes }
+
 func (p *affinityProcessor) finalize() []DiagnosticFinding {
 	entities := make([]string, 0)
 	var first, last time.Time
@@ -516,6 +717,7 @@ func (p *affinityProcessor) finalize() []DiagnosticFinding {
 	if len(entities) > 12 {
 		entities = append(entities[:12], fmt.Sprintf("... and %d more", len(entities)-12))
 	}
+	msg := newMessage("exclusive_affinity")
 	return []DiagnosticFinding{{
 		TemplateID:   p.template.ID,
 		TemplateName: p.template.Name,
@@ -523,7 +725,8 @@ func (p *affinityProcessor) finalize() []DiagnosticFinding {
 		Severity:     p.template.Severity,
 		ReportKey:    "cpu",
 		Instances:    entities,
-		Summary:      "Exclusive affinity is enabled for one or more entities. Verify pinning side-effects and contention behavior.",
+		Summary:      msg.String(),
+		Message:      msg,
 		Start:        first.UnixMilli(),
 		End:          last.UnixMilli(),
 	}}
@@ -548,6 +751,9 @@ type valueSwitchProcessor struct {
 }
 
 func (p *valueSwitchProcessor) onRow(ts time.Time, record []string) {
+	if len(p.states) != len(p.indexes) {
+		p.states = make([]valueSwitchEntityState, len(p.indexes))
+	}
 	for i, idx := range p.indexes {
 		if idx < 0 || idx >= len(record) {
 			continue
@@ -574,12 +780,15 @@ func (p *valueSwitchProcessor) onRow(ts time.Time, record []string) {
 	}
 }
 
+func (p *valueSwitchProcessor) columnIndexes() []int { return p.indexes }
+
 func (p *valueSwitchProcessor) finalize() []DiagnosticFinding {
 	findings := make([]DiagnosticFinding, 0, len(p.states))
 	for i, s := range p.states {
 		if s.switches < p.minSwitches {
 			continue
 		}
+		msg := newMessage("value_switch", "switches", s.switches)
 		f := DiagnosticFinding{
 			TemplateID:     p.template.ID,
 			TemplateName:   p.template.Name,
@@ -588,7 +797,8 @@ func (p *valueSwitchProcessor) finalize() []DiagnosticFinding {
 			ReportKey:      p.reportKey,
 			AttributeLabel: p.attributeLabel,
 			Instances:      []string{p.labels[i]},
-			Summary:        fmt.Sprintf("Detected %d home-node switches for this instance.", s.switches),
+			Summary:        msg.String(),
+			Message:        msg,
 		}
 		if !s.firstSwitch.IsZero() {
 			f.Start = s.firstSwitch.UnixMilli()
@@ -601,421 +811,5159 @@ func (p *valueSwitchProcessor) finalize() []DiagnosticFinding {
 	sort.Slice(findings, func(i, j int) bool {
 		return findings[i].Instances[0] < findings[j].Instances[0]
 	})
-	if len(findings) > 30 {
-		findings = findings[:30]
-	}
 	return findings
 }
 
-func NumberFinite(v float64) bool {
-	return !math.IsNaN(v) && !math.IsInf(v, 0)
+type vmotionEntityState struct {
+	label      string
+	seenValid  bool
+	missing    bool
+	prevWait   float64
+	waitSet    bool
+	prevHome   string
+	gapRow     int64
+	gapTime    time.Time
+	waitRow    int64
+	waitTime   time.Time
+	homeRow    int64
+	homeTime   time.Time
+	lastWindow int64
+	windows    int
+	kinds      map[string]bool
+	firstStart time.Time
+	firstEnd   time.Time
 }
 
-func parseTruthy(s string) bool {
-	s = strings.TrimSpace(strings.ToLower(s))
-	return s == "true" || s == "1" || s == "yes" || s == "y"
+type vmotionColumn struct {
+	idx    int
+	entity int
+	kind   string
 }
 
-func containsAnyFold(s string, subs ...string) bool {
-	s = strings.ToLower(s)
-	for _, sub := range subs {
-		if strings.Contains(s, strings.ToLower(sub)) {
-			return true
+type vmotionStunProcessor struct {
+	template DiagnosticTemplate
+	columns  []vmotionColumn
+	states   []vmotionEntityState
+	waitJump float64
+	window   int64
+	minKinds int
+	row      int64
+}
+
+func (p *vmotionStunProcessor) onRow(ts time.Time, record []string) {
+	p.row++
+	for _, c := range p.columns {
+		if c.idx < 0 || c.idx >= len(record) {
+			continue
+		}
+		s := &p.states[c.entity]
+		raw := strings.TrimSpace(record[c.idx])
+		switch c.kind {
+		case "presence":
+			v, ok := parseFloatValue(raw)
+			if !ok || !NumberFinite(v) {
+				if s.seenValid {
+					s.missing = true
+				}
+				continue
+			}
+			if s.missing {
+				s.gapRow = p.row
+				s.gapTime = ts
+				s.missing = false
+			}
+			s.seenValid = true
+		case "wait":
+			v, ok := parseFloatValue(raw)
+			if !ok || !NumberFinite(v) {
+				continue
+			}
+			if s.waitSet && v-s.prevWait >= p.waitJump {
+				s.waitRow = p.row
+				s.waitTime = ts
+			}
+			s.prevWait = v
+			s.waitSet = true
+		case "home":
+			if raw == "" {
+				continue
+			}
+			if s.prevHome != "" && raw != s.prevHome {
+				s.homeRow = p.row
+				s.homeTime = ts
+			}
+			s.prevHome = raw
 		}
 	}
-	return false
+	for i := range p.states {
+		p.evaluate(i, ts)
+	}
 }
 
-func excludedByName(name string, excludes []string) bool {
-	if len(excludes) == 0 {
-		return false
+func (p *vmotionStunProcessor) evaluate(i int, ts time.Time) {
+	s := &p.states[i]
+	if s.windows > 0 && p.row <= s.lastWindow+p.window {
+		return
 	}
-	n := strings.ToLower(name)
-	for _, ex := range excludes {
-		ex = strings.TrimSpace(strings.ToLower(ex))
-		if ex == "" {
-			continue
+	var kinds []string
+	var start time.Time
+	mark := func(row int64, at time.Time, kind string) {
+		if row == 0 || p.row-row >= p.window {
+			return
 		}
-		if strings.Contains(n, ex) {
-			return true
+		kinds = append(kinds, kind)
+		if start.IsZero() || at.Before(start) {
+			start = at
 		}
 	}
-	return false
+	mark(s.gapRow, s.gapTime, "sample gap")
+	mark(s.waitRow, s.waitTime, "%WAIT spike")
+	mark(s.homeRow, s.homeTime, "NUMA home change")
+	if len(kinds) < p.minKinds {
+		return
+	}
+	s.lastWindow = p.row
+	s.windows++
+	if s.kinds == nil {
+		s.kinds = map[string]bool{}
+	}
+	for _, k := range kinds {
+		s.kinds[k] = true
+	}
+	if s.firstStart.IsZero() {
+		s.firstStart = start
+		s.firstEnd = ts
+	}
 }
 
-func excludedByRegex(name string, patterns []string) bool {
-	if len(patterns) == 0 {
-		return false
+func (p *vmotionStunProcessor) columnIndexes() []int {
+	out := make([]int, 0, len(p.columns))
+	for _, c := range p.columns {
+		out = append(out, c.idx)
 	}
-	for _, p := range patterns {
-		p = strings.TrimSpace(p)
-		if p == "" {
+	return out
+}
+
+func (p *vmotionStunProcessor) finalize() []DiagnosticFinding {
+	findings := make([]DiagnosticFinding, 0)
+	for _, s := range p.states {
+		if s.windows == 0 {
 			continue
 		}
-		re, err := regexp.Compile("(?i)" + p)
-		if err != nil {
-			continue
+		kinds := make([]string, 0, len(s.kinds))
+		for k := range s.kinds {
+			kinds = append(kinds, k)
 		}
-		if re.MatchString(name) {
-			return true
+		sort.Strings(kinds)
+		msg := newMessage("vmotion_stun", "kinds", strings.Join(kinds, ", "), "windows", s.windows)
+		f := DiagnosticFinding{
+			TemplateID:     p.template.ID,
+			TemplateName:   p.template.Name,
+			Title:          p.template.Name,
+			Severity:       p.template.Severity,
+			ReportKey:      "cpu",
+			AttributeLabel: "Group Cpu: % Wait",
+			Instances:      []string{s.label},
+			Summary:        msg.String(),
+			Message:        msg,
+		}
+		if !s.firstStart.IsZero() {
+			f.Start = s.firstStart.UnixMilli()
+		}
+		if !s.firstEnd.IsZero() {
+			f.End = s.firstEnd.UnixMilli()
 		}
+		findings = append(findings, f)
 	}
-	return false
+	sort.Slice(findings, func(i, j int) bool {
+		return findings[i].Instances[0] < findings[j].Instances[0]
+	})
+	return findings
 }
 
-func matchesIncludedAttribute(label string, includes []string) bool {
-	if len(includes) == 0 {
-		return true
-	}
-	for _, inc := range includes {
-		if strings.EqualFold(strings.TrimSpace(inc), strings.TrimSpace(label)) {
-			return true
-		}
-	}
-	return false
+type queueGroupState struct {
+	label     string
+	indexes   []int
+	queues    []string
+	currLen   int
+	currStart time.Time
+	currTop   string
+	currShare float64
+	bestLen   int
+	bestStart time.Time
+	bestEnd   time.Time
+	bestTop   string
+	bestShare float64
 }
 
-func matchesTargetAttribute(label, target string) bool {
-	target = strings.TrimSpace(target)
-	if target == "" {
-		return true
-	}
-	return strings.EqualFold(strings.TrimSpace(label), target)
+type queueImbalanceProcessor struct {
+	template       DiagnosticTemplate
+	attributeLabel string
+	groups         []queueGroupState
+	minTotal       float64
+	highShare      float64
+	lowShare       float64
+	minConsecutive int
 }
 
-func matchesIncludedObject(object string, includes []string) bool {
-	if len(includes) == 0 {
-		return true
-	}
-	for _, inc := range includes {
-		if strings.EqualFold(strings.TrimSpace(inc), strings.TrimSpace(object)) {
-			return true
+func (p *queueImbalanceProcessor) onRow(ts time.Time, record []string) {
+	for gi := range p.groups {
+		g := &p.groups[gi]
+		total := 0.0
+		top := -1
+		topVal := -math.MaxFloat64
+		values := make([]float64, len(g.indexes))
+		valid := 0
+		for i, idx := range g.indexes {
+			values[i] = math.NaN()
+			if idx < 0 || idx >= len(record) {
+				continue
+			}
+			v, ok := parseFloatValue(record[idx])
+			if !ok || !NumberFinite(v) || v < 0 {
+				continue
+			}
+			values[i] = v
+			valid++
+			total += v
+			if v > topVal {
+				topVal = v
+				top = i
+			}
+		}
+		if valid < 2 || total < p.minTotal || top < 0 {
+			p.reset(gi, ts)
+			continue
+		}
+		share := topVal / total * 100
+		idle := true
+		for i, v := range values {
+			if i == top || math.IsNaN(v) {
+				continue
+			}
+			if v/total*100 > p.lowShare {
+				idle = false
+				break
+			}
 		}
+		if share < p.highShare || !idle {
+			p.reset(gi, ts)
+			continue
+		}
+		if g.currLen == 0 {
+			g.currStart = ts
+			g.currTop = g.queues[top]
+			g.currShare = share
+		} else if share > g.currShare {
+			g.currShare = share
+		}
+		g.currLen++
 	}
-	return false
 }
 
-func evaluateTemplateCondition(c parsedColumn, cond TemplateCondition) bool {
-	field := strings.TrimSpace(strings.ToLower(cond.Field))
-	op := strings.TrimSpace(strings.ToLower(cond.Op))
-	val := cond.Value
-	var target string
-	switch field {
-	case "object":
-		target = c.Object
-	case "attribute", "attributelabel":
-		target = c.AttributeLabel
-	case "instance":
-		target = c.Instance
-	case "counter":
-		target = c.Counter
-	case "raw":
-		target = c.Raw
-	default:
-		return false
+func (p *queueImbalanceProcessor) reset(gi int, ts time.Time) {
+	g := &p.groups[gi]
+	if g.currLen > g.bestLen {
+		g.bestLen = g.currLen
+		g.bestStart = g.currStart
+		g.bestEnd = ts
+		g.bestTop = g.currTop
+		g.bestShare = g.currShare
 	}
-	targetFold := strings.ToLower(strings.TrimSpace(target))
-	valueFold := strings.ToLower(strings.TrimSpace(val))
+	g.currLen = 0
+	g.currTop = ""
+	g.currShare = 0
+}
 
-	switch op {
-	case "eq", "=":
-		return targetFold == valueFold
-	case "neq", "!=":
-		return targetFold != valueFold
-	case "contains":
-		return strings.Contains(targetFold, valueFold)
-	case "not_contains":
-		return !strings.Contains(targetFold, valueFold)
-	case "regex":
-		re, err := regexp.Compile("(?i)" + strings.TrimSpace(val))
-		return err == nil && re.MatchString(target)
-	case "not_regex":
-		re, err := regexp.Compile("(?i)" + strings.TrimSpace(val))
-		return err == nil && !re.MatchString(target)
-	case "prefix", "starts_with":
-		return strings.HasPrefix(targetFold, valueFold)
-	case "suffix", "ends_with":
-		return strings.HasSuffix(targetFold, valueFold)
-	default:
-		return false
+func (p *queueImbalanceProcessor) columnIndexes() []int {
+	var out []int
+	for _, g := range p.groups {
+		out = append(out, g.indexes...)
 	}
+	return out
 }
 
-func matchesTemplateFilter(c parsedColumn, filter TemplateFilter) bool {
-	if len(filter.Conditions) == 0 {
-		return true
-	}
-	logic := strings.TrimSpace(strings.ToLower(filter.Logic))
-	if logic == "or" {
-		for _, cond := range filter.Conditions {
-			if evaluateTemplateCondition(c, cond) {
-				return true
-			}
+func (p *queueImbalanceProcessor) finalize() []DiagnosticFinding {
+	findings := make([]DiagnosticFinding, 0)
+	for gi := range p.groups {
+		p.reset(gi, time.Time{})
+		g := p.groups[gi]
+		if g.bestLen < p.minConsecutive {
+			continue
 		}
-		return false
-	}
-	for _, cond := range filter.Conditions {
-		if !evaluateTemplateCondition(c, cond) {
-			return false
+		msg := newMessage("nic_queue_imbalance", "nic", g.label, "queue", g.bestTop, "share", g.bestShare, "others", len(g.queues)-1, "low", p.lowShare, "samples", g.bestLen)
+		f := DiagnosticFinding{
+			TemplateID:     p.template.ID,
+			TemplateName:   p.template.Name,
+			Title:          p.template.Name,
+			Severity:       p.template.Severity,
+			ReportKey:      "network",
+			AttributeLabel: p.attributeLabel,
+			Instances:      []string{g.bestTop},
+			Summary:        msg.String(),
+			Message:        msg,
 		}
+		if !g.bestStart.IsZero() {
+			f.Start = g.bestStart.UnixMilli()
+		}
+		if !g.bestEnd.IsZero() {
+			f.End = g.bestEnd.UnixMilli()
+		}
+		findings = append(findings, f)
 	}
-	return true
+	sort.Slice(findings, func(i, j int) bool {
+		return findings[i].Summary < findings[j].Summary
+	})
+	return findings
 }
 
-func inferReportKeyFromAttribute(attr string) string {
-	l := strings.ToLower(attr)
-	switch {
-	case strings.Contains(l, "cpu") || strings.Contains(l, "vcpu") || strings.Contains(l, "% ready") || strings.Contains(l, "% costop"):
-		return "cpu"
-	case strings.Contains(l, "memory") || strings.Contains(l, "swap") || strings.Contains(l, "group memory"):
-		return "memory"
-	case strings.Contains(l, "numa"):
-		return "numa"
-	case strings.Contains(l, "network") || strings.Contains(l, "net"):
-		return "network"
-	case strings.Contains(l, "disk") || strings.Contains(l, "storage") || strings.Contains(l, "latency"):
-		return "storage"
-	case strings.Contains(l, "power") || strings.Contains(l, "pstate") || strings.Contains(l, "watts"):
-		return "power"
-	case strings.Contains(l, "vsan"):
-		return "vsan"
+type readySpreadGroupState struct {
+	label     string
+	indexes   []int
+	vcpus     []string
+	currLen   int
+	currStart time.Time
+	currTop   int
+	currPeak  float64
+	currGap   float64
+	bestLen   int
+	bestStart time.Time
+	bestEnd   time.Time
+	bestTop   int
+	bestPeak  float64
+	bestGap   float64
+}
+
+// readySpreadProcessor flags VMs where the same vCPU keeps a much higher
+// %RDY than its siblings. Host-wide contention raises ready time on all
+// vCPUs together; a single outlier points at guest threading or vNUMA sizing.
+type readySpreadProcessor struct {
+	template       DiagnosticTemplate
+	attributeLabel string
+	groups         []readySpreadGroupState
+	minReady       float64
+	minGap         float64
+	minConsecutive int
+}
+
+func (p *readySpreadProcessor) onRow(ts time.Time, record []string) {
+	for gi := range p.groups {
+		g := &p.groups[gi]
+		values := make([]float64, 0, len(g.indexes))
+		top := -1
+		topVal := -math.MaxFloat64
+		for i, idx := range g.indexes {
+			if idx < 0 || idx >= len(record) {
+				continue
+			}
+			v, ok := parseFloatValue(record[idx])
+			if !ok || !NumberFinite(v) {
+				continue
+			}
+			values = append(values, v)
+			if v > topVal {
+				topVal = v
+				top = i
+			}
+		}
+		if len(values) < 2 || topVal < p.minReady {
+			p.reset(gi, ts)
+			continue
+		}
+		sort.Float64s(values)
+		siblings := values[:len(values)-1]
+		median := siblings[len(siblings)/2]
+		if len(siblings)%2 == 0 {
+			median = (siblings[len(siblings)/2-1] + siblings[len(siblings)/2]) / 2
+		}
+		gap := topVal - median
+		if gap < p.minGap || (g.currLen > 0 && top != g.currTop) {
+			p.reset(gi, ts)
+			if gap < p.minGap {
+				continue
+			}
+		}
+		if g.currLen == 0 {
+			g.currStart = ts
+			g.currTop = top
+		}
+		if topVal > g.currPeak {
+			g.currPeak = topVal
+		}
+		if gap > g.currGap {
+			g.currGap = gap
+		}
+		g.currLen++
+	}
+}
+
+func (p *readySpreadProcessor) reset(gi int, ts time.Time) {
+	g := &p.groups[gi]
+	if g.currLen > g.bestLen {
+		g.bestLen = g.currLen
+		g.bestStart = g.currStart
+		g.bestEnd = ts
+		g.bestTop = g.currTop
+		g.bestPeak = g.currPeak
+		g.bestGap = g.currGap
+	}
+	g.currLen = 0
+	g.currPeak = 0
+	g.currGap = 0
+}
+
+func (p *readySpreadProcessor) columnIndexes() []int {
+	var out []int
+	for _, g := range p.groups {
+		out = append(out, g.indexes...)
+	}
+	return out
+}
+
+func (p *readySpreadProcessor) finalize() []DiagnosticFinding {
+	findings := make([]DiagnosticFinding, 0)
+	for gi := range p.groups {
+		p.reset(gi, time.Time{})
+		g := p.groups[gi]
+		if g.bestLen < p.minConsecutive {
+			continue
+		}
+		msg := newMessage("vcpu_ready_spread", "vm", g.label, "vcpu", g.vcpus[g.bestTop], "peak", g.bestPeak, "gap", g.bestGap, "siblings", len(g.vcpus)-1, "samples", g.bestLen)
+		f := DiagnosticFinding{
+			TemplateID:     p.template.ID,
+			TemplateName:   p.template.Name,
+			Title:          p.template.Name,
+			Severity:       p.template.Severity,
+			ReportKey:      "cpu",
+			AttributeLabel: p.attributeLabel,
+			Instances:      []string{g.vcpus[g.bestTop]},
+			Summary:        msg.String(),
+			Message:        msg,
+		}
+		if !g.bestStart.IsZero() {
+			f.Start = g.bestStart.UnixMilli()
+		}
+		if !g.bestEnd.IsZero() {
+			f.End = g.bestEnd.UnixMilli()
+		}
+		findings = append(findings, f)
+	}
+	sort.Slice(findings, func(i, j int) bool {
+		return findings[i].Summary < findings[j].Summary
+	})
+	return findings
+}
+
+// vcpuGroupKey returns the VM part of a Vcpu instance ("GID:VMName:WID:world"
+// -> "GID:VMName"); instances that don't follow the layout are their own VM.
+func vcpuGroupKey(instance string) string {
+	parts := strings.SplitN(instance, ":", 3)
+	if len(parts) < 3 {
+		return instance
+	}
+	return parts[0] + ":" + parts[1]
+}
+
+// affinityShrinkProcessor flags worlds whose CPU affinity is limited to a
+// few PCPUs while they accumulate ready time: partial pinning (a mask, not
+// exclusive affinity) that leaves the scheduler too few places to run them.
+type affinityShrinkProcessor struct {
+	template       DiagnosticTemplate
+	entities       []affinityShrinkEntityState
+	hostPCPUs      int
+	maxPCPUs       int
+	minReady       float64
+	minConsecutive int
+}
+
+type affinityShrinkEntityState struct {
+	label     string
+	attribute string
+	maskIdx   int
+	readyIdx  int
+	currLen   int
+	currStart time.Time
+	currPeak  float64
+	currPCPUs int
+	lastTs    time.Time
+	bestLen   int
+	bestStart time.Time
+	bestEnd   time.Time
+	bestPeak  float64
+	bestPCPUs int
+}
+
+func (p *affinityShrinkProcessor) onRow(ts time.Time, record []string) {
+	for i := range p.entities {
+		e := &p.entities[i]
+		if e.maskIdx >= len(record) || e.readyIdx >= len(record) {
+			continue
+		}
+		pcpus, ok := parseAffinityPCPUs(record[e.maskIdx])
+		ready, rok := parseFloatValue(record[e.readyIdx])
+		restricted := ok && pcpus > 0 && pcpus <= p.maxPCPUs && (p.hostPCPUs == 0 || pcpus < p.hostPCPUs)
+		if !restricted || !rok || !NumberFinite(ready) || ready < p.minReady {
+			p.reset(i, ts)
+			continue
+		}
+		if e.currLen == 0 {
+			e.currStart = ts
+		}
+		if ready > e.currPeak {
+			e.currPeak = ready
+		}
+		e.currPCPUs = pcpus
+		e.currLen++
+		e.lastTs = ts
+	}
+}
+
+func (p *affinityShrinkProcessor) reset(i int, ts time.Time) {
+	e := &p.entities[i]
+	if e.currLen > e.bestLen {
+		e.bestLen = e.currLen
+		e.bestStart = e.currStart
+		e.bestEnd = e.lastTs
+		e.bestPeak = e.currPeak
+		e.bestPCPUs = e.currPCPUs
+	}
+	e.currLen = 0
+	e.currPeak = 0
+}
+
+func (p *affinityShrinkProcessor) columnIndexes() []int {
+	out := make([]int, 0, 2*len(p.entities))
+	for _, e := range p.entities {
+		out = append(out, e.maskIdx, e.readyIdx)
+	}
+	return out
+}
+
+func (p *affinityShrinkProcessor) finalize() []DiagnosticFinding {
+	findings := make([]DiagnosticFinding, 0)
+	for i := range p.entities {
+		p.reset(i, time.Time{})
+		e := p.entities[i]
+		if e.bestLen < p.minConsecutive {
+			continue
+		}
+		var host any = ""
+		if p.hostPCPUs > 0 {
+			host = newMessage("affinity_shrinkage.of_host", "pcpus", p.hostPCPUs)
+		}
+		msg := newMessage("affinity_shrinkage", "world", e.label, "pcpus", e.bestPCPUs, "host", host, "peak", e.bestPeak, "samples", e.bestLen)
+		findings = append(findings, DiagnosticFinding{
+			TemplateID:     p.template.ID,
+			TemplateName:   p.template.Name,
+			Title:          p.template.Name,
+			Severity:       p.template.Severity,
+			ReportKey:      "cpu",
+			AttributeLabel: e.attribute,
+			Instances:      []string{e.label},
+			Start:          e.bestStart.UnixMilli(),
+			End:            e.bestEnd.UnixMilli(),
+			Summary:        msg.String(),
+			Message:        msg,
+		})
+	}
+	sort.Slice(findings, func(i, j int) bool {
+		return findings[i].Summary < findings[j].Summary
+	})
+	return findings
+}
+
+// parseAffinityPCPUs returns how many PCPUs an affinity value allows. It
+// understands hex bit masks ("0x0f", or bare hex as esxtop prints
+// AFFINITY_BIT_MASK), comma-separated masks ("ff,ffffffff") and CPU lists
+// with ranges ("0-3,8"). Empty, "all" and "-" mean unrestricted.
+func parseAffinityPCPUs(raw string) (int, bool) {
+	s := strings.ToLower(strings.TrimSpace(raw))
+	if s == "" || s == "all" || s == "-" {
+		return 0, false
+	}
+	if strings.Contains(s, "-") {
+		n := 0
+		for _, part := range strings.Split(s, ",") {
+			part = strings.TrimSpace(part)
+			lo, hi, isRange := strings.Cut(part, "-")
+			a, err := strconv.Atoi(strings.TrimSpace(lo))
+			if err != nil {
+				return 0, false
+			}
+			b := a
+			if isRange {
+				if b, err = strconv.Atoi(strings.TrimSpace(hi)); err != nil || b < a {
+					return 0, false
+				}
+			}
+			n += b - a + 1
+		}
+		return n, true
+	}
+	n := 0
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimPrefix(strings.TrimSpace(part), "0x")
+		if part == "" {
+			return 0, false
+		}
+		for _, ch := range part {
+			v, err := strconv.ParseUint(string(ch), 16, 8)
+			if err != nil {
+				return 0, false
+			}
+			n += bits.OnesCount8(uint8(v))
+		}
+	}
+	return n, true
+}
+
+// latencyBimodalProcessor flags latency counters whose samples split into
+// two well separated clusters within a window: path flapping between a fast
+// and a slow path, or auto-tiering moving the working set, both of which an
+// average blends into one unremarkable number.
+type latencyBimodalProcessor struct {
+	template   DiagnosticTemplate
+	entities   []latencyBimodalEntityState
+	window     int
+	minD       float64
+	minGap     float64
+	minWindows int
+}
+
+type latencyBimodalEntityState struct {
+	label       string
+	attribute   string
+	idx         int
+	values      []float64
+	windowStart time.Time
+	lastTs      time.Time
+	windows     int
+	bestD       float64
+	bestLow     float64
+	bestHigh    float64
+	bestShare   float64
+	bestStart   time.Time
+	bestEnd     time.Time
+}
+
+// bimodalMinShare is the smallest fraction of a window the minority mode
+// must hold, so a handful of spikes is not read as a second mode.
+const bimodalMinShare = 0.15
+
+func (p *latencyBimodalProcessor) onRow(ts time.Time, record []string) {
+	for i := range p.entities {
+		e := &p.entities[i]
+		if e.idx >= len(record) {
+			continue
+		}
+		v, ok := parseFloatValue(record[e.idx])
+		if !ok || !NumberFinite(v) || v < 0 {
+			continue
+		}
+		if len(e.values) == 0 {
+			e.windowStart = ts
+		}
+		e.values = append(e.values, v)
+		e.lastTs = ts
+		if len(e.values) >= p.window {
+			p.evaluate(e)
+		}
+	}
+}
+
+func (p *latencyBimodalProcessor) evaluate(e *latencyBimodalEntityState) {
+	values := e.values
+	e.values = e.values[:0]
+	low, high, share, d, ok := splitBimodal(values)
+	if !ok || d < p.minD || high-low < p.minGap || share < bimodalMinShare {
+		return
+	}
+	e.windows++
+	if d > e.bestD {
+		e.bestD, e.bestLow, e.bestHigh, e.bestShare = d, low, high, share
+		e.bestStart, e.bestEnd = e.windowStart, e.lastTs
+	}
+}
+
+// splitBimodal builds a log-scaled histogram of values, picks the split
+// that maximizes between-class variance (Otsu) and returns the two cluster
+// means, the minority cluster's share and Ashman's D, which exceeds 2 when
+// the clusters are cleanly separated.
+func splitBimodal(values []float64) (low, high, share, d float64, ok bool) {
+	const bins = 32
+	if len(values) < 8 {
+		return 0, 0, 0, 0, false
+	}
+	lo, hi := math.Inf(1), math.Inf(-1)
+	for _, v := range values {
+		lv := math.Log1p(v)
+		lo, hi = math.Min(lo, lv), math.Max(hi, lv)
+	}
+	if hi-lo < 1e-9 {
+		return 0, 0, 0, 0, false
+	}
+	var hist [bins]int
+	binOf := func(v float64) int {
+		b := int((math.Log1p(v) - lo) / (hi - lo) * bins)
+		if b >= bins {
+			b = bins - 1
+		}
+		return b
+	}
+	for _, v := range values {
+		hist[binOf(v)]++
+	}
+	total := float64(len(values))
+	sumAll := 0.0
+	for b, n := range hist {
+		sumAll += float64(b * n)
+	}
+	bestSplit, bestVar := -1, -1.0
+	w0, sum0 := 0.0, 0.0
+	for b := 0; b < bins-1; b++ {
+		w0 += float64(hist[b])
+		sum0 += float64(b * hist[b])
+		w1 := total - w0
+		if w0 == 0 || w1 == 0 {
+			continue
+		}
+		m0, m1 := sum0/w0, (sumAll-sum0)/w1
+		if between := w0 * w1 * (m0 - m1) * (m0 - m1); between > bestVar {
+			bestSplit, bestVar = b, between
+		}
+	}
+	if bestSplit < 0 {
+		return 0, 0, 0, 0, false
+	}
+	var n0, n1 int
+	var s0, s1, q0, q1 float64
+	for _, v := range values {
+		if binOf(v) <= bestSplit {
+			n0++
+			s0 += v
+			q0 += v * v
+		} else {
+			n1++
+			s1 += v
+			q1 += v * v
+		}
+	}
+	m0, m1 := s0/float64(n0), s1/float64(n1)
+	v0 := math.Max(0, q0/float64(n0)-m0*m0)
+	v1 := math.Max(0, q1/float64(n1)-m1*m1)
+	spread := math.Sqrt(v0 + v1)
+	if spread < 1e-9 {
+		spread = 1e-9
+	}
+	return m0, m1, float64(min(n0, n1)) / total, math.Sqrt2 * math.Abs(m1-m0) / spread, true
+}
+
+func (p *latencyBimodalProcessor) columnIndexes() []int {
+	out := make([]int, 0, len(p.entities))
+	for _, e := range p.entities {
+		out = append(out, e.idx)
+	}
+	return out
+}
+
+func (p *latencyBimodalProcessor) finalize() []DiagnosticFinding {
+	findings := make([]DiagnosticFinding, 0)
+	for i := range p.entities {
+		e := &p.entities[i]
+		// A trailing partial window still counts when it holds at least
+		// half a window of samples.
+		if len(e.values) >= p.window/2 {
+			p.evaluate(e)
+		}
+		if e.windows < p.minWindows {
+			continue
+		}
+		msg := newMessage("latency_bimodality", "device", e.label, "attribute", e.attribute, "low", e.bestLow, "high", e.bestHigh, "minority", e.bestShare*100, "separation", e.bestD, "windows", e.windows)
+		findings = append(findings, DiagnosticFinding{
+			TemplateID:     p.template.ID,
+			TemplateName:   p.template.Name,
+			Title:          p.template.Name,
+			Severity:       p.template.Severity,
+			ReportKey:      "storage",
+			AttributeLabel: e.attribute,
+			Instances:      []string{e.label},
+			Start:          e.bestStart.UnixMilli(),
+			End:            e.bestEnd.UnixMilli(),
+			Summary:        msg.String(),
+			Message:        msg,
+		})
+	}
+	sort.Slice(findings, func(i, j int) bool {
+		return findings[i].Summary < findings[j].Summary
+	})
+	return findings
+}
+
+// clockSkewProcessor flags captures whose timestamps step backwards, repeat
+// or jump forward by several sample intervals (host clock adjustments, NTP
+// steps). Every rate, duration and consecutive-sample rule downstream
+// assumes evenly spaced samples, so this is reported as a capture-quality
+// finding rather than left to distort other results.
+type clockSkewProcessor struct {
+	template  DiagnosticTemplate
+	gapFactor float64
+	prev      time.Time
+	// deltas counts forward steps rounded to 100 ms; the most common one is
+	// the nominal sample interval.
+	deltas     map[int64]int64
+	mode       int64
+	modeCount  int64
+	backward   clockSkewEvents
+	duplicates clockSkewEvents
+	jumps      []clockSkewEvent
+}
+
+type clockSkewEvent struct {
+	at    time.Time
+	delta time.Duration
+}
+
+type clockSkewEvents struct {
+	count   int
+	first   time.Time
+	last    time.Time
+	largest time.Duration
+}
+
+// maxClockJumpCandidates bounds memory on captures that are gaps throughout.
+const maxClockJumpCandidates = 1000
+
+func (e *clockSkewEvents) add(ts time.Time, delta time.Duration) {
+	if e.count == 0 {
+		e.first = ts
+	}
+	e.count++
+	e.last = ts
+	if delta > e.largest {
+		e.largest = delta
+	}
+}
+
+func (p *clockSkewProcessor) onRow(ts time.Time, _ []string) {
+	defer func() { p.prev = ts }()
+	if p.prev.IsZero() {
+		return
+	}
+	delta := ts.Sub(p.prev)
+	switch {
+	case delta < 0:
+		p.backward.add(ts, -delta)
+	case delta == 0:
+		p.duplicates.add(ts, 0)
 	default:
-		return "other"
+		bucket := (delta.Milliseconds() + 50) / 100
+		p.deltas[bucket]++
+		if p.deltas[bucket] > p.modeCount {
+			p.mode, p.modeCount = bucket, p.deltas[bucket]
+		}
+		// The nominal interval may still settle, so keep candidates loosely
+		// (including everything before a second repeat) and re-check them
+		// in finalize.
+		if (p.modeCount == 1 || float64(delta.Milliseconds()) > p.gapFactor*float64(p.mode*100)/2) && len(p.jumps) < maxClockJumpCandidates {
+			p.jumps = append(p.jumps, clockSkewEvent{at: ts, delta: delta})
+		}
 	}
 }
 
-func buildProcessors(templates []DiagnosticTemplate, cols []parsedColumn) []rowProcessor {
-	var processors []rowProcessor
-	for _, t := range templates {
-		switch t.Detector.Type {
-		case "threshold_sustained", "high_ready", "high_costop", "storage_latency", "low_numa_local", "memory_overcommit_high", "network_outbound_drop_high", "disk_adapter_failed_reads_high", "disk_adapter_driver_latency_high":
-			var idxs []int
-			var labels []string
-			attribute := ""
-			reportKey := "cpu"
-			lowerBound := 0.0
-			upperBound := 0.0
-			hasLowerBound := false
-			hasUpperBound := false
+func (p *clockSkewProcessor) columnIndexes() []int { return nil }
+
+func (p *clockSkewProcessor) finalize() []DiagnosticFinding {
+	findings := make([]DiagnosticFinding, 0)
+	nominal := time.Duration(p.mode*100) * time.Millisecond
+	add := func(e clockSkewEvents, what *FindingMessage) {
+		findings = append(findings, DiagnosticFinding{
+			TemplateID:   p.template.ID,
+			TemplateName: p.template.Name,
+			Title:        p.template.Name,
+			Severity:     p.template.Severity,
+			ReportKey:    "other",
+			Start:        e.first.UnixMilli(),
+			End:          e.last.UnixMilli(),
+			Summary:      what.String(),
+			Message:      what,
+		})
+	}
+	if p.backward.count > 0 {
+		add(p.backward, newMessage("clock_skew.backward", "count", p.backward.count, "largest", p.backward.largest.String(), "first", p.backward.first.UTC().Format("2006-01-02 15:04:05")))
+	}
+	if p.duplicates.count > 0 {
+		add(p.duplicates, newMessage("clock_skew.duplicate", "count", p.duplicates.count, "first", p.duplicates.first.UTC().Format("2006-01-02 15:04:05")))
+	}
+	if nominal > 0 {
+		var jumps clockSkewEvents
+		for _, j := range p.jumps {
+			if float64(j.delta) > p.gapFactor*float64(nominal) {
+				jumps.add(j.at, j.delta)
+			}
+		}
+		if jumps.count > 0 {
+			add(jumps, newMessage("clock_skew.jump", "count", jumps.count, "largest", jumps.largest.String(), "interval", nominal.String(), "first", jumps.first.UTC().Format("2006-01-02 15:04:05")))
+		}
+	}
+	return findings
+}
+
+// latencyAttributionProcessor separates "one VM is slow on a healthy
+// datastore" from "the whole datastore is slow". For every sample where a
+// VM's virtual disk latency is high it checks whether the backing devices
+// show comparable latency at the same time. esxtop does not record which
+// device backs which VM, so the busiest device stands in for the backing
+// one.
+type latencyAttributionProcessor struct {
+	template       DiagnosticTemplate
+	vms            []latencyAttributionVM
+	deviceIdxs     []int
+	threshold      float64
+	minConsecutive int
+	// explainRatio is the share of the VM's latency the device must show
+	// to count as explaining it.
+	explainRatio float64
+}
+
+type latencyAttributionVM struct {
+	name      string
+	attribute string
+	idxs      []int
+	currLen   int
+	currStart time.Time
+	currLast  time.Time
+	currPeak  float64
+	currDev   float64
+	currExpl  int
+	best      latencyAttributionStreak
+}
+
+type latencyAttributionStreak struct {
+	length    int
+	start     time.Time
+	end       time.Time
+	peak      float64
+	devPeak   float64
+	explained int
+}
+
+func (p *latencyAttributionProcessor) onRow(ts time.Time, record []string) {
+	devMax, devOK := maxColumnValue(record, p.deviceIdxs)
+	for i := range p.vms {
+		v := &p.vms[i]
+		lat, ok := maxColumnValue(record, v.idxs)
+		if !ok || lat < p.threshold {
+			p.reset(v)
+			continue
+		}
+		if v.currLen == 0 {
+			v.currStart = ts
+		}
+		v.currLen++
+		v.currLast = ts
+		v.currPeak = math.Max(v.currPeak, lat)
+		if devOK {
+			v.currDev = math.Max(v.currDev, devMax)
+			if devMax >= p.explainRatio*lat {
+				v.currExpl++
+			}
+		}
+	}
+}
+
+func (p *latencyAttributionProcessor) reset(v *latencyAttributionVM) {
+	if v.currLen > v.best.length {
+		v.best = latencyAttributionStreak{
+			length:    v.currLen,
+			start:     v.currStart,
+			end:       v.currLast,
+			peak:      v.currPeak,
+			devPeak:   v.currDev,
+			explained: v.currExpl,
+		}
+	}
+	v.currLen, v.currPeak, v.currDev, v.currExpl = 0, 0, 0, 0
+}
+
+func (p *latencyAttributionProcessor) columnIndexes() []int {
+	out := append([]int(nil), p.deviceIdxs...)
+	for _, v := range p.vms {
+		out = append(out, v.idxs...)
+	}
+	return out
+}
+
+func (p *latencyAttributionProcessor) finalize() []DiagnosticFinding {
+	findings := make([]DiagnosticFinding, 0)
+	// Datastore-wide episodes are merged into one finding naming every VM.
+	var wide []latencyAttributionVM
+	for i := range p.vms {
+		v := &p.vms[i]
+		p.reset(v)
+		b := v.best
+		if b.length < p.minConsecutive {
+			continue
+		}
+		if len(p.deviceIdxs) > 0 && b.explained*2 >= b.length {
+			wide = append(wide, *v)
+			continue
+		}
+		deviceNote := newMessage("latency_attribution.no_devices")
+		if len(p.deviceIdxs) > 0 {
+			deviceNote = newMessage("latency_attribution.devices_healthy", "device", b.devPeak)
+		}
+		msg := newMessage("latency_attribution.single_vm", "vm", v.name, "peak", b.peak, "samples", b.length, "devices", deviceNote)
+		findings = append(findings, DiagnosticFinding{
+			TemplateID:     p.template.ID,
+			TemplateName:   p.template.Name,
+			Title:          p.template.Name + ": single VM",
+			Severity:       p.template.Severity,
+			ReportKey:      "storage",
+			AttributeLabel: v.attribute,
+			Instances:      []string{v.name},
+			Start:          b.start.UnixMilli(),
+			End:            b.end.UnixMilli(),
+			Summary:        msg.String(),
+			Message:        msg,
+		})
+	}
+	if len(wide) > 0 {
+		sort.Slice(wide, func(i, j int) bool { return wide[i].best.peak > wide[j].best.peak })
+		f := DiagnosticFinding{
+			TemplateID:     p.template.ID,
+			TemplateName:   p.template.Name,
+			Title:          p.template.Name + ": datastore-wide",
+			Severity:       p.template.Severity,
+			ReportKey:      "storage",
+			AttributeLabel: wide[0].attribute,
+			Start:          wide[0].best.start.UnixMilli(),
+			End:            wide[0].best.end.UnixMilli(),
+		}
+		devPeak := 0.0
+		for _, v := range wide {
+			f.Instances = append(f.Instances, v.name)
+			devPeak = math.Max(devPeak, v.best.devPeak)
+			if ms := v.best.start.UnixMilli(); ms < f.Start {
+				f.Start = ms
+			}
+			if ms := v.best.end.UnixMilli(); ms > f.End {
+				f.End = ms
+			}
+		}
+		f.Message = newMessage("latency_attribution.datastore", "vms", len(wide), "peak", wide[0].best.peak, "device", devPeak)
+		f.Summary = f.Message.String()
+		if len(f.Instances) > 10 {
+			f.Instances = append(f.Instances[:10], fmt.Sprintf("... and %d more", len(wide)-10))
+		}
+		findings = append(findings, f)
+	}
+	sort.Slice(findings, func(i, j int) bool {
+		return findings[i].Summary < findings[j].Summary
+	})
+	return findings
+}
+
+// Configuration smells are settings visible in esxtop that work against
+// the scheduler; their findings share the "configuration" report key.
+
+// affinityLatencyProcessor compares a group's exclusive affinity with its
+// latency sensitivity. Latency sensitivity High is the supported way to get
+// exclusive PCPUs; exclusive cores without it mean manual pinning, and High
+// without them usually means the VM lacks the full CPU reservation.
+type affinityLatencyProcessor struct {
+	template DiagnosticTemplate
+	groups   []affinityLatencyGroup
+}
+
+type affinityLatencyGroup struct {
+	label        string
+	exclusiveIdx int
+	latencyIdx   int
+	pinned       affinityLatencySpan
+	ungranted    affinityLatencySpan
+}
+
+type affinityLatencySpan struct {
+	samples     int
+	first, last time.Time
+}
+
+func (s *affinityLatencySpan) add(ts time.Time) {
+	if s.samples == 0 {
+		s.first = ts
+	}
+	s.samples++
+	s.last = ts
+}
+
+// isHighLatencySensitivity reads esxtop's latency sensitivity level, which
+// is reported as text (normal, high, ...).
+func isHighLatencySensitivity(v string) bool {
+	return strings.EqualFold(strings.TrimSpace(v), "high")
+}
+
+func (p *affinityLatencyProcessor) onRow(ts time.Time, record []string) {
+	for i := range p.groups {
+		g := &p.groups[i]
+		if g.exclusiveIdx >= len(record) || g.latencyIdx >= len(record) {
+			continue
+		}
+		exclusive := parseTruthy(record[g.exclusiveIdx])
+		high := isHighLatencySensitivity(record[g.latencyIdx])
+		switch {
+		case exclusive && !high:
+			g.pinned.add(ts)
+		case high && !exclusive:
+			g.ungranted.add(ts)
+		}
+	}
+}
+
+func (p *affinityLatencyProcessor) columnIndexes() []int {
+	out := make([]int, 0, len(p.groups)*2)
+	for _, g := range p.groups {
+		out = append(out, g.exclusiveIdx, g.latencyIdx)
+	}
+	return out
+}
+
+func (p *affinityLatencyProcessor) finalize() []DiagnosticFinding {
+	build := func(title, key string, span func(*affinityLatencyGroup) affinityLatencySpan) []DiagnosticFinding {
+		var names []string
+		var first, last time.Time
+		for i := range p.groups {
+			s := span(&p.groups[i])
+			if s.samples == 0 {
+				continue
+			}
+			names = append(names, p.groups[i].label)
+			if first.IsZero() || s.first.Before(first) {
+				first = s.first
+			}
+			if s.last.After(last) {
+				last = s.last
+			}
+		}
+		if len(names) == 0 {
+			return nil
+		}
+		sort.Strings(names)
+		n := len(names)
+		if n > 12 {
+			names = append(names[:12], fmt.Sprintf("... and %d more", n-12))
+		}
+		msg := newMessage(key, "vms", n)
+		return []DiagnosticFinding{{
+			TemplateID:     p.template.ID,
+			TemplateName:   p.template.Name,
+			Title:          p.template.Name + ": " + title,
+			Severity:       p.template.Severity,
+			ReportKey:      "configuration",
+			AttributeLabel: "Group Cpu: Exclusive Affinity",
+			Instances:      names,
+			Start:          first.UnixMilli(),
+			End:            last.UnixMilli(),
+			Summary:        msg.String(),
+			Message:        msg,
+		}}
+	}
+	findings := build("exclusive affinity without latency sensitivity High",
+		"affinity_latency.pinned",
+		func(g *affinityLatencyGroup) affinityLatencySpan { return g.pinned })
+	findings = append(findings, build("latency sensitivity High without exclusive PCPUs",
+		"affinity_latency.ungranted",
+		func(g *affinityLatencyGroup) affinityLatencySpan { return g.ungranted })...)
+	return findings
+}
+
+// numaSpanProcessor flags VMs homed on more than one NUMA node that also
+// serve most memory remotely: the VM is wider than a node (or vNUMA does not
+// match the host), so it pays remote access on every miss.
+type numaSpanProcessor struct {
+	template       DiagnosticTemplate
+	vms            []numaSpanVM
+	localThreshold float64
+	minConsecutive int
+}
+
+type numaSpanVM struct {
+	label     string
+	homeIdx   int
+	localIdx  int
+	homes     string
+	currLen   int
+	currStart time.Time
+	currLast  time.Time
+	currLow   float64
+	bestLen   int
+	bestStart time.Time
+	bestEnd   time.Time
+	bestLow   float64
+	bestHomes string
+}
+
+// numaHomeCount counts the node IDs in a Numa Home Nodes value ("0",
+// "0,1", "0 1").
+func numaHomeCount(v string) int {
+	return len(strings.FieldsFunc(v, func(r rune) bool {
+		return r == ',' || r == ' ' || r == ';' || r == '|' || r == '/'
+	}))
+}
+
+func (p *numaSpanProcessor) onRow(ts time.Time, record []string) {
+	for i := range p.vms {
+		v := &p.vms[i]
+		if v.homeIdx >= len(record) || v.localIdx >= len(record) {
+			p.reset(v)
+			continue
+		}
+		// Clone: fields share the row's string, which can be megabytes.
+		homes := strings.Clone(strings.TrimSpace(record[v.homeIdx]))
+		local, ok := parseFloatValue(record[v.localIdx])
+		if !ok || numaHomeCount(homes) < 2 || local >= p.localThreshold {
+			p.reset(v)
+			continue
+		}
+		if v.currLen == 0 {
+			v.currStart = ts
+			v.currLow = local
+		}
+		v.currLen++
+		v.currLast = ts
+		v.currLow = math.Min(v.currLow, local)
+		v.homes = homes
+	}
+}
+
+func (p *numaSpanProcessor) reset(v *numaSpanVM) {
+	if v.currLen > v.bestLen {
+		v.bestLen, v.bestStart, v.bestEnd, v.bestLow, v.bestHomes = v.currLen, v.currStart, v.currLast, v.currLow, v.homes
+	}
+	v.currLen = 0
+}
+
+func (p *numaSpanProcessor) columnIndexes() []int {
+	out := make([]int, 0, len(p.vms)*2)
+	for _, v := range p.vms {
+		out = append(out, v.homeIdx, v.localIdx)
+	}
+	return out
+}
+
+func (p *numaSpanProcessor) finalize() []DiagnosticFinding {
+	findings := make([]DiagnosticFinding, 0)
+	for i := range p.vms {
+		v := &p.vms[i]
+		p.reset(v)
+		if v.bestLen < p.minConsecutive {
+			continue
+		}
+		name := vmDisplayName(v.label)
+		msg := newMessage("numa_span_remote", "vm", name, "nodes", v.bestHomes, "local", v.bestLow, "samples", v.bestLen)
+		findings = append(findings, DiagnosticFinding{
+			TemplateID:     p.template.ID,
+			TemplateName:   p.template.Name,
+			Title:          p.template.Name,
+			Severity:       p.template.Severity,
+			ReportKey:      "configuration",
+			AttributeLabel: "Group Memory: Numa % Local",
+			Instances:      []string{v.label},
+			Start:          v.bestStart.UnixMilli(),
+			End:            v.bestEnd.UnixMilli(),
+			Summary:        msg.String(),
+			Message:        msg,
+		})
+	}
+	sort.Slice(findings, func(i, j int) bool {
+		return findings[i].Summary < findings[j].Summary
+	})
+	return findings
+}
+
+// oversizedVCPUProcessor flags VMs whose combined vCPU demand never comes
+// close to their vCPU count. Idle vCPUs are not free: they widen
+// co-scheduling (%CSTP) and NUMA placement for no gain.
+type oversizedVCPUProcessor struct {
+	template DiagnosticTemplate
+	vms      []oversizedVCPUVM
+	// maxShare is the peak demand, as a share of the vCPU count, at or
+	// below which the VM counts as oversized.
+	maxShare   float64
+	minSamples int
+}
+
+type oversizedVCPUVM struct {
+	label     string
+	attribute string
+	idxs      []int
+	vcpus     []string
+	samples   int
+	peak      float64
+	peakTime  time.Time
+	first     time.Time
+	last      time.Time
+}
+
+func (p *oversizedVCPUProcessor) onRow(ts time.Time, record []string) {
+	for i := range p.vms {
+		v := &p.vms[i]
+		sum, seen := 0.0, false
+		for _, idx := range v.idxs {
+			if idx >= len(record) {
+				continue
+			}
+			if x, ok := parseFloatValue(record[idx]); ok && NumberFinite(x) {
+				sum += x
+				seen = true
+			}
+		}
+		if !seen {
+			continue
+		}
+		if v.samples == 0 {
+			v.first = ts
+		}
+		v.samples++
+		v.last = ts
+		if demand := sum / 100; demand > v.peak || v.peakTime.IsZero() {
+			v.peak, v.peakTime = demand, ts
+		}
+	}
+}
+
+func (p *oversizedVCPUProcessor) columnIndexes() []int {
+	var out []int
+	for _, v := range p.vms {
+		out = append(out, v.idxs...)
+	}
+	return out
+}
+
+func (p *oversizedVCPUProcessor) finalize() []DiagnosticFinding {
+	findings := make([]DiagnosticFinding, 0)
+	for _, v := range p.vms {
+		n := float64(len(v.idxs))
+		if v.samples < p.minSamples || v.peak > n*p.maxShare {
+			continue
+		}
+		name := vmDisplayName(v.label)
+		msg := newMessage("oversized_vcpus", "vm", name, "vcpus", len(v.idxs), "samples", v.samples, "peak", v.peak, "share", v.peak/n*100)
+		findings = append(findings, DiagnosticFinding{
+			TemplateID:     p.template.ID,
+			TemplateName:   p.template.Name,
+			Title:          p.template.Name,
+			Severity:       p.template.Severity,
+			ReportKey:      "configuration",
+			AttributeLabel: v.attribute,
+			Instances:      v.vcpus,
+			Start:          v.first.UnixMilli(),
+			End:            v.last.UnixMilli(),
+			Summary:        msg.String(),
+			Message:        msg,
+		})
+	}
+	sort.Slice(findings, func(i, j int) bool {
+		return findings[i].Summary < findings[j].Summary
+	})
+	return findings
+}
+
+// periodicityProcessor finds counters whose spikes recur on a fixed period
+// (a nightly backup, a five-minute poller). Samples are kept in at most
+// window buckets, each the maximum of its samples; when the capture
+// outgrows them, adjacent buckets merge and the bucket width doubles. The
+// period is the first strong peak of the autocorrelation and the phase is
+// where the series, folded at that period, is highest.
+type periodicityProcessor struct {
+	template       DiagnosticTemplate
+	entities       []periodicityEntity
+	window         int
+	minCorrelation float64
+	// minSpike is how far (in multiples of the series' median absolute
+	// deviation) the folded peak must stand above the median, so smooth
+	// drifts are not reported as spikes.
+	minSpike float64
+	origin   time.Time
+	width    time.Duration
+	base     time.Duration
+}
+
+type periodicityEntity struct {
+	label     string
+	attribute string
+	idx       int
+	buckets   []float64
+	present   []bool
+}
+
+func (p *periodicityProcessor) onRow(ts time.Time, record []string) {
+	if p.origin.IsZero() {
+		p.origin = ts
+		p.width = p.base
+		for i := range p.entities {
+			p.entities[i].buckets = make([]float64, p.window)
+			p.entities[i].present = make([]bool, p.window)
+		}
+	}
+	if ts.Before(p.origin) {
+		return
+	}
+	b := int(ts.Sub(p.origin) / p.width)
+	for b >= p.window {
+		p.mergeBuckets()
+		b = int(ts.Sub(p.origin) / p.width)
+	}
+	for i := range p.entities {
+		e := &p.entities[i]
+		if e.idx >= len(record) {
+			continue
+		}
+		v, ok := parseFloatValue(record[e.idx])
+		if !ok || !NumberFinite(v) {
+			continue
+		}
+		if !e.present[b] || v > e.buckets[b] {
+			e.buckets[b] = v
+			e.present[b] = true
+		}
+	}
+}
+
+func (p *periodicityProcessor) mergeBuckets() {
+	for i := range p.entities {
+		e := &p.entities[i]
+		for j := 0; j < p.window/2; j++ {
+			a, b := 2*j, 2*j+1
+			v, ok := e.buckets[a], e.present[a]
+			if e.present[b] && (!ok || e.buckets[b] > v) {
+				v, ok = e.buckets[b], true
+			}
+			e.buckets[j], e.present[j] = v, ok
+		}
+		for j := p.window / 2; j < p.window; j++ {
+			e.buckets[j], e.present[j] = 0, false
+		}
+	}
+	p.width *= 2
+}
+
+func (p *periodicityProcessor) columnIndexes() []int {
+	out := make([]int, 0, len(p.entities))
+	for _, e := range p.entities {
+		out = append(out, e.idx)
+	}
+	return out
+}
+
+func (p *periodicityProcessor) finalize() []DiagnosticFinding {
+	findings := make([]DiagnosticFinding, 0)
+	for i := range p.entities {
+		e := &p.entities[i]
+		n := 0
+		for j, ok := range e.present {
+			if ok {
+				n = j + 1
+			}
+		}
+		period, corr, ok := dominantPeriod(e.buckets[:n], e.present[:n])
+		if !ok || corr < p.minCorrelation {
+			continue
+		}
+		// Fold at the period and find the hottest phase.
+		sums := make([]float64, period)
+		counts := make([]int, period)
+		var values []float64
+		for j := 0; j < n; j++ {
+			if e.present[j] {
+				sums[j%period] += e.buckets[j]
+				counts[j%period]++
+				values = append(values, e.buckets[j])
+			}
+		}
+		phase, peak := 0, math.Inf(-1)
+		for k := range sums {
+			if counts[k] > 0 && sums[k]/float64(counts[k]) > peak {
+				phase, peak = k, sums[k]/float64(counts[k])
+			}
+		}
+		median, mad := medianAbsDeviation(values)
+		if mad <= 0 || peak-median < p.minSpike*mad {
+			continue
+		}
+		periodDur := time.Duration(period) * p.width
+		first := p.origin.Add(time.Duration(phase) * p.width)
+		cycles := n / period
+		last := first.Add(time.Duration(cycles-1) * periodDur)
+		if last.After(p.origin.Add(time.Duration(n) * p.width)) {
+			last = last.Add(-periodDur)
+		}
+		phaseText := newMessage("periodicity.phase_offset", "offset", (time.Duration(phase) * p.width).Round(time.Second).String(), "first", first.UTC().Format("2006-01-02 15:04:05"))
+		if periodDur >= 23*time.Hour && periodDur <= 25*time.Hour {
+			phaseText = newMessage("periodicity.phase_daily", "time", first.UTC().Format("15:04"))
+		}
+		msg := newMessage("periodicity", "instance", e.label, "period", periodDur.Round(time.Second).String(), "phase", phaseText, "correlation", corr, "cycles", cycles, "peak", peak, "median", median)
+		findings = append(findings, DiagnosticFinding{
+			TemplateID:     p.template.ID,
+			TemplateName:   p.template.Name,
+			Title:          p.template.Name,
+			Severity:       p.template.Severity,
+			ReportKey:      inferReportKeyFromAttribute(e.attribute),
+			AttributeLabel: e.attribute,
+			Instances:      []string{e.label},
+			Start:          first.UnixMilli(),
+			End:            last.Add(p.width).UnixMilli(),
+			Summary:        msg.String(),
+			Message:        msg,
+		})
+	}
+	sort.Slice(findings, func(i, j int) bool {
+		return findings[i].Summary < findings[j].Summary
+	})
+	return findings
+}
+
+// dominantPeriod returns the lag, in buckets, of the first autocorrelation
+// peak that comes close to the strongest one; taking the first rather
+// than the strongest avoids reporting a multiple of the real period. Only
+// lags seen at least three times in the series are considered.
+func dominantPeriod(values []float64, present []bool) (int, float64, bool) {
+	n := len(values)
+	if n < 12 {
+		return 0, 0, false
+	}
+	mean, count := 0.0, 0
+	for i, v := range values {
+		if present[i] {
+			mean += v
+			count++
+		}
+	}
+	if count < n/2 {
+		return 0, 0, false
+	}
+	mean /= float64(count)
+	size := 1
+	for size < 2*n {
+		size <<= 1
+	}
+	buf := make([]complex128, size)
+	for i, v := range values {
+		if present[i] {
+			buf[i] = complex(v-mean, 0)
+		}
+	}
+	fft(buf, false)
+	for i, c := range buf {
+		buf[i] = complex(real(c)*real(c)+imag(c)*imag(c), 0)
+	}
+	fft(buf, true)
+	zero := real(buf[0])
+	if zero <= 0 {
+		return 0, 0, false
+	}
+	maxLag := n / 3
+	acf := make([]float64, maxLag+2)
+	for k := range acf {
+		acf[k] = real(buf[k]) / zero * float64(n) / float64(n-k)
+	}
+	var peaks []int
+	best := 0.0
+	for k := 2; k <= maxLag; k++ {
+		if acf[k] > acf[k-1] && acf[k] >= acf[k+1] && acf[k] > 0 {
+			peaks = append(peaks, k)
+			best = math.Max(best, acf[k])
+		}
+	}
+	for _, k := range peaks {
+		if acf[k] >= 0.9*best {
+			return k, acf[k], true
+		}
+	}
+	return 0, 0, false
+}
+
+// fft transforms a in place; len(a) must be a power of two. The inverse is
+// scaled by 1/len(a).
+func fft(a []complex128, inverse bool) {
+	n := len(a)
+	for i, j := 1, 0; i < n; i++ {
+		bit := n >> 1
+		for ; j&bit != 0; bit >>= 1 {
+			j ^= bit
+		}
+		j ^= bit
+		if i < j {
+			a[i], a[j] = a[j], a[i]
+		}
+	}
+	for length := 2; length <= n; length <<= 1 {
+		angle := 2 * math.Pi / float64(length)
+		if !inverse {
+			angle = -angle
+		}
+		wl := complex(math.Cos(angle), math.Sin(angle))
+		for i := 0; i < n; i += length {
+			w := complex(1, 0)
+			for k := 0; k < length/2; k++ {
+				u, v := a[i+k], a[i+k+length/2]*w
+				a[i+k], a[i+k+length/2] = u+v, u-v
+				w *= wl
+			}
+		}
+	}
+	if inverse {
+		for i := range a {
+			a[i] /= complex(float64(n), 0)
+		}
+	}
+}
+
+func medianAbsDeviation(values []float64) (float64, float64) {
+	if len(values) == 0 {
+		return 0, 0
+	}
+	s := append([]float64(nil), values...)
+	sort.Float64s(s)
+	median := s[len(s)/2]
+	for i, v := range s {
+		s[i] = math.Abs(v - median)
+	}
+	sort.Float64s(s)
+	return median, s[len(s)/2]
+}
+
+// swapWaitProcessor flags VMs whose vCPUs sit in %SWPWT, waiting for pages
+// the hypervisor swapped out. Unlike swap used, which only says pages were
+// moved at some point, swap wait is the guest stalling right now. Each
+// episode is reported with the host-wide swap rate over the same samples.
+type swapWaitProcessor struct {
+	template       DiagnosticTemplate
+	vms            []swapWaitVM
+	threshold      float64
+	minConsecutive int
+	// hostReadIdx and hostWriteIdx are the host Memory swap rate columns,
+	// hostUsedIdx the host's swap used MBytes; any may be empty.
+	hostReadIdx  []int
+	hostWriteIdx []int
+	hostUsedIdx  []int
+}
+
+type swapWaitVM struct {
+	label string
+	idx   int
+	curr  swapWaitEpisode
+	best  swapWaitEpisode
+}
+
+type swapWaitEpisode struct {
+	samples   int
+	start     time.Time
+	end       time.Time
+	peak      float64
+	sum       float64
+	hostRead  float64
+	hostWrite float64
+	hostPeak  float64
+	hostUsed  float64
+	hostRows  int
+}
+
+func sumColumns(record []string, idxs []int) (float64, bool) {
+	total, any := 0.0, false
+	for _, idx := range idxs {
+		if idx >= len(record) {
+			continue
+		}
+		if v, ok := parseFloatValue(record[idx]); ok && NumberFinite(v) {
+			total += v
+			any = true
+		}
+	}
+	return total, any
+}
+
+func (p *swapWaitProcessor) onRow(ts time.Time, record []string) {
+	read, hasRead := sumColumns(record, p.hostReadIdx)
+	write, hasWrite := sumColumns(record, p.hostWriteIdx)
+	used, _ := sumColumns(record, p.hostUsedIdx)
+	for i := range p.vms {
+		v := &p.vms[i]
+		wait, ok := 0.0, false
+		if v.idx < len(record) {
+			wait, ok = parseFloatValue(record[v.idx])
+		}
+		if !ok || wait < p.threshold {
+			p.reset(v)
+			continue
+		}
+		e := &v.curr
+		if e.samples == 0 {
+			e.start = ts
+		}
+		e.samples++
+		e.end = ts
+		e.peak = math.Max(e.peak, wait)
+		e.sum += wait
+		e.hostUsed = math.Max(e.hostUsed, used)
+		if hasRead || hasWrite {
+			e.hostRead += read
+			e.hostWrite += write
+			e.hostPeak = math.Max(e.hostPeak, read+write)
+			e.hostRows++
+		}
+	}
+}
+
+func (p *swapWaitProcessor) reset(v *swapWaitVM) {
+	if v.curr.samples > v.best.samples || (v.curr.samples == v.best.samples && v.curr.peak > v.best.peak) {
+		v.best = v.curr
+	}
+	v.curr = swapWaitEpisode{}
+}
+
+func (p *swapWaitProcessor) columnIndexes() []int {
+	out := make([]int, 0, len(p.vms)+len(p.hostReadIdx)+len(p.hostWriteIdx)+len(p.hostUsedIdx))
+	for _, v := range p.vms {
+		out = append(out, v.idx)
+	}
+	out = append(out, p.hostReadIdx...)
+	out = append(out, p.hostWriteIdx...)
+	return append(out, p.hostUsedIdx...)
+}
+
+func (p *swapWaitProcessor) finalize() []DiagnosticFinding {
+	findings := make([]DiagnosticFinding, 0)
+	for i := range p.vms {
+		v := &p.vms[i]
+		p.reset(v)
+		e := v.best
+		if e.samples < p.minConsecutive {
+			continue
+		}
+		host := newMessage("swap_wait.no_host")
+		if e.hostRows > 0 {
+			n := float64(e.hostRows)
+			var used, idle any = "", ""
+			if len(p.hostUsedIdx) > 0 {
+				used = newMessage("swap_wait.host_used", "mb", e.hostUsed)
+			}
+			if e.hostPeak < 0.1 {
+				idle = newMessage("swap_wait.host_idle")
+			}
+			host = newMessage("swap_wait.host", "in", e.hostRead/n, "out", e.hostWrite/n, "peak", e.hostPeak, "used", used, "idle", idle)
+		}
+		msg := newMessage("swap_wait", "vm", vmDisplayName(v.label), "threshold", p.threshold, "samples", e.samples, "avg", e.sum/float64(e.samples), "peak", e.peak, "host", host)
+		findings = append(findings, DiagnosticFinding{
+			TemplateID:     p.template.ID,
+			TemplateName:   p.template.Name,
+			Title:          p.template.Name,
+			Severity:       p.template.Severity,
+			ReportKey:      "memory",
+			AttributeLabel: "Group Cpu: % Swap Wait",
+			Instances:      []string{v.label},
+			Start:          e.start.UnixMilli(),
+			End:            e.end.UnixMilli(),
+			Summary:        msg.String(),
+			Message:        msg,
+		})
+	}
+	sort.Slice(findings, func(i, j int) bool {
+		return findings[i].Summary < findings[j].Summary
+	})
+	return findings
+}
+
+// readyIOProcessor flags VMs whose %RDY rises and falls with their virtual
+// disk latency. Ready time that tracks storage stalls points at the IO path
+// (the vCPUs wake on completions in bursts), so the fix is on the storage
+// side rather than in CPU sizing or scheduling.
+type readyIOProcessor struct {
+	template       DiagnosticTemplate
+	vms            []readyIOVM
+	readyThreshold float64
+	latThreshold   float64
+	minCorrelation float64
+	window         int
+	minConsecutive int
+}
+
+type readyIOVM struct {
+	name     string
+	readyIdx int
+	latIdxs  []int
+	ready    []float64
+	lat      []float64
+	pos      int
+	filled   int
+	curr     readyIOEpisode
+	best     readyIOEpisode
+}
+
+type readyIOEpisode struct {
+	samples   int
+	start     time.Time
+	end       time.Time
+	peakReady float64
+	peakLat   float64
+	corrSum   float64
+}
+
+// pearson returns the correlation of x and y, or false when either is flat.
+func pearson(x, y []float64) (float64, bool) {
+	n := float64(len(x))
+	var sx, sy float64
+	for i := range x {
+		sx += x[i]
+		sy += y[i]
+	}
+	mx, my := sx/n, sy/n
+	var cov, vx, vy float64
+	for i := range x {
+		dx, dy := x[i]-mx, y[i]-my
+		cov += dx * dy
+		vx += dx * dx
+		vy += dy * dy
+	}
+	if vx == 0 || vy == 0 {
+		return 0, false
+	}
+	return cov / math.Sqrt(vx*vy), true
+}
+
+func (p *readyIOProcessor) onRow(ts time.Time, record []string) {
+	for i := range p.vms {
+		v := &p.vms[i]
+		ready, okReady := 0.0, false
+		if v.readyIdx < len(record) {
+			ready, okReady = parseFloatValue(record[v.readyIdx])
+		}
+		lat, okLat := maxColumnValue(record, v.latIdxs)
+		if !okReady || !okLat {
+			// A gap breaks the window; correlating across it would pair
+			// samples that were not taken together.
+			v.filled, v.pos = 0, 0
+			p.reset(v)
+			continue
+		}
+		v.ready[v.pos], v.lat[v.pos] = ready, lat
+		v.pos = (v.pos + 1) % p.window
+		if v.filled < p.window {
+			v.filled++
+		}
+		if v.filled < p.window || ready < p.readyThreshold || lat < p.latThreshold {
+			p.reset(v)
+			continue
+		}
+		corr, ok := pearson(v.ready, v.lat)
+		if !ok || corr < p.minCorrelation {
+			p.reset(v)
+			continue
+		}
+		e := &v.curr
+		if e.samples == 0 {
+			e.start = ts
+		}
+		e.samples++
+		e.end = ts
+		e.peakReady = math.Max(e.peakReady, ready)
+		e.peakLat = math.Max(e.peakLat, lat)
+		e.corrSum += corr
+	}
+}
+
+func (p *readyIOProcessor) reset(v *readyIOVM) {
+	if v.curr.samples > v.best.samples {
+		v.best = v.curr
+	}
+	v.curr = readyIOEpisode{}
+}
+
+func (p *readyIOProcessor) columnIndexes() []int {
+	var out []int
+	for _, v := range p.vms {
+		out = append(out, v.readyIdx)
+		out = append(out, v.latIdxs...)
+	}
+	return out
+}
+
+func (p *readyIOProcessor) finalize() []DiagnosticFinding {
+	findings := make([]DiagnosticFinding, 0)
+	for i := range p.vms {
+		v := &p.vms[i]
+		p.reset(v)
+		e := v.best
+		if e.samples < p.minConsecutive {
+			continue
+		}
+		msg := newMessage("ready_io_correlation", "vm", vmDisplayName(v.name), "samples", e.samples, "correlation", e.corrSum/float64(e.samples), "window", p.window, "ready", e.peakReady, "latency", e.peakLat)
+		findings = append(findings, DiagnosticFinding{
+			TemplateID:     p.template.ID,
+			TemplateName:   p.template.Name,
+			Title:          p.template.Name,
+			Severity:       p.template.Severity,
+			ReportKey:      "storage",
+			AttributeLabel: "Group Cpu: % Ready",
+			Instances:      []string{v.name},
+			Start:          e.start.UnixMilli(),
+			End:            e.end.UnixMilli(),
+			Summary:        msg.String(),
+			Message:        msg,
+		})
+	}
+	sort.Slice(findings, func(i, j int) bool {
+		return findings[i].Summary < findings[j].Summary
+	})
+	return findings
+}
+
+// cstateColumn matches per-PCPU C-state residency counters (%C0, %C1,
+// %C2 State Time, ...) and captures the state number.
+var cstateColumn = regexp.MustCompile(`(?i)^%\s*C(\d+)\b`)
+
+// deepCState returns the C-state a power column reports and whether it is
+// one of the deep states (C2 and below) that take microseconds to leave.
+// C1 is a plain halt and cheap to wake from.
+func deepCState(c parsedColumn) (int, bool) {
+	if !strings.EqualFold(c.Object, "PCPU Power State") && !strings.EqualFold(c.Object, "Power") && !strings.EqualFold(c.Object, "Physical Cpu") {
+		return 0, false
+	}
+	m := cstateColumn.FindStringSubmatch(strings.TrimSpace(c.Counter))
+	if m == nil {
+		return 0, false
+	}
+	n, err := strconv.Atoi(m[1])
+	if err != nil || n < 2 {
+		return 0, false
+	}
+	return n, true
+}
+
+// cstateLatencyProcessor flags stretches where the host's PCPUs sat mostly
+// in deep C-states while latency-sensitive VMs were running. Every wakeup
+// from a deep state costs exit latency, which shows up as jitter for
+// exactly the VMs that asked not to have any; the power policy is then a
+// plausible contributor.
+type cstateLatencyProcessor struct {
+	template       DiagnosticTemplate
+	pcpus          [][]int
+	deepest        int
+	vms            []cstateLatencyVM
+	threshold      float64
+	minConsecutive int
+	curr           cstateLatencyEpisode
+	best           cstateLatencyEpisode
+}
+
+type cstateLatencyVM struct {
+	label      string
+	latencyIdx int
+	seen       bool
+}
+
+type cstateLatencyEpisode struct {
+	samples int
+	start   time.Time
+	end     time.Time
+	sum     float64
+	peak    float64
+	vms     map[int]bool
+}
+
+func (p *cstateLatencyProcessor) onRow(ts time.Time, record []string) {
+	// Host residency is the mean over PCPUs of the time in any deep state.
+	total, n := 0.0, 0
+	for _, idxs := range p.pcpus {
+		if v, ok := sumColumns(record, idxs); ok {
+			total += v
+			n++
+		}
+	}
+	var sensitive []int
+	for i, v := range p.vms {
+		if v.latencyIdx < len(record) && isHighLatencySensitivity(record[v.latencyIdx]) {
+			sensitive = append(sensitive, i)
+		}
+	}
+	if n == 0 || len(sensitive) == 0 || total/float64(n) < p.threshold {
+		p.reset()
+		return
+	}
+	residency := total / float64(n)
+	e := &p.curr
+	if e.samples == 0 {
+		e.start = ts
+		e.vms = map[int]bool{}
+	}
+	e.samples++
+	e.end = ts
+	e.sum += residency
+	e.peak = math.Max(e.peak, residency)
+	for _, i := range sensitive {
+		e.vms[i] = true
+	}
+}
+
+func (p *cstateLatencyProcessor) reset() {
+	if p.curr.samples > p.best.samples {
+		p.best = p.curr
+	}
+	p.curr = cstateLatencyEpisode{}
+}
+
+func (p *cstateLatencyProcessor) columnIndexes() []int {
+	var out []int
+	for _, idxs := range p.pcpus {
+		out = append(out, idxs...)
+	}
+	for _, v := range p.vms {
+		out = append(out, v.latencyIdx)
+	}
+	return out
+}
+
+func (p *cstateLatencyProcessor) finalize() []DiagnosticFinding {
+	p.reset()
+	e := p.best
+	if e.samples < p.minConsecutive {
+		return nil
+	}
+	names := make([]string, 0, len(e.vms))
+	for i := range e.vms {
+		names = append(names, vmDisplayName(p.vms[i].label))
+	}
+	sort.Strings(names)
+	listed := names
+	if len(listed) > 12 {
+		listed = append(listed[:12:12], fmt.Sprintf("... and %d more", len(names)-12))
+	}
+	msg := newMessage("cstate_latency", "pcpus", len(p.pcpus), "deepest", p.deepest, "avg", e.sum/float64(e.samples), "peak", e.peak, "samples", e.samples, "vms", len(names), "names", strings.Join(listed, ", "))
+	return []DiagnosticFinding{{
+		TemplateID:     p.template.ID,
+		TemplateName:   p.template.Name,
+		Title:          p.template.Name,
+		Severity:       p.template.Severity,
+		ReportKey:      "cpu",
+		AttributeLabel: "PCPU Power State: C-state residency",
+		Instances:      listed,
+		Start:          e.start.UnixMilli(),
+		End:            e.end.UnixMilli(),
+		Summary:        msg.String(),
+		Message:        msg,
+	}}
+}
+
+// numaFreeImbalanceProcessor is the memory-side counterpart of the NUMA
+// processor imbalance check: one node runs short of free memory while
+// another has plenty, and the VMs homed on the short node are ballooned or
+// swapped anyway. The host is not short of memory, its placement is.
+type numaFreeImbalanceProcessor struct {
+	template       DiagnosticTemplate
+	nodes          []numaFreeNode
+	vms            []numaFreeVM
+	minGap         float64
+	minConsecutive int
+	curr           numaFreeEpisode
+	best           numaFreeEpisode
+}
+
+type numaFreeNode struct {
+	label    string
+	freeIdx  int
+	totalIdx int
+}
+
+type numaFreeVM struct {
+	label      string
+	homeIdx    int
+	reclaimIdx []int
+}
+
+type numaFreeEpisode struct {
+	samples  int
+	start    time.Time
+	end      time.Time
+	low      string
+	high     string
+	lowFree  float64
+	highFree float64
+	gap      float64
+	reclaim  float64
+	vms      map[string]bool
+}
+
+// freeShare returns how free n is: percent of its total when the
+// capture has totals, else its free MB.
+func (n numaFreeNode) freeShare(record []string) (share, free float64, pct, ok bool) {
+	if n.freeIdx >= len(record) {
+		return 0, 0, false, false
+	}
+	free, ok = parseFloatValue(record[n.freeIdx])
+	if !ok || !NumberFinite(free) {
+		return 0, 0, false, false
+	}
+	if n.totalIdx >= 0 && n.totalIdx < len(record) {
+		if total, ok := parseFloatValue(record[n.totalIdx]); ok && total > 0 {
+			return free / total * 100, free, true, true
+		}
+	}
+	return free, free, false, true
+}
+
+func (p *numaFreeImbalanceProcessor) onRow(ts time.Time, record []string) {
+	lo, hi := -1, -1
+	var loShare, hiShare, loFree, hiFree float64
+	valid, allPct := 0, true
+	for i, n := range p.nodes {
+		share, free, pct, ok := n.freeShare(record)
+		if !ok {
+			continue
+		}
+		valid++
+		allPct = allPct && pct
+		if lo < 0 || share < loShare {
+			lo, loShare, loFree = i, share, free
+		}
+		if hi < 0 || share > hiShare {
+			hi, hiShare, hiFree = i, share, free
+		}
+	}
+	if valid < 2 {
+		p.reset()
+		return
+	}
+	// Percent-of-total shares compare directly; raw MB only relative to
+	// the freest node.
+	gap := hiShare - loShare
+	if !allPct {
+		gap = 0
+		if hiFree > 0 {
+			gap = (hiFree - loFree) / hiFree * 100
+		}
+	}
+	if gap < p.minGap {
+		p.reset()
+		return
+	}
+	low := p.nodes[lo].label
+	var reclaiming []string
+	reclaim := 0.0
+	for _, v := range p.vms {
+		if v.homeIdx >= len(record) || !numaHomedOn(record[v.homeIdx], low) {
+			continue
+		}
+		if mb, ok := sumColumns(record, v.reclaimIdx); ok && mb > 0 {
+			reclaiming = append(reclaiming, v.label)
+			reclaim += mb
+		}
+	}
+	if len(reclaiming) == 0 || (p.curr.samples > 0 && p.curr.low != low) {
+		p.reset()
+		if len(reclaiming) == 0 {
+			return
+		}
+	}
+	e := &p.curr
+	if e.samples == 0 {
+		*e = numaFreeEpisode{start: ts, low: low, high: p.nodes[hi].label, lowFree: loFree, highFree: hiFree, vms: map[string]bool{}}
+	}
+	e.samples++
+	e.end = ts
+	if gap > e.gap {
+		e.gap, e.high, e.lowFree, e.highFree = gap, p.nodes[hi].label, loFree, hiFree
+	}
+	e.reclaim = math.Max(e.reclaim, reclaim)
+	for _, name := range reclaiming {
+		e.vms[name] = true
+	}
+}
+
+// numaHomedOn reports whether a Numa Home Nodes value includes node.
+func numaHomedOn(homes, node string) bool {
+	for _, h := range strings.FieldsFunc(homes, func(r rune) bool {
+		return r == ',' || r == ' ' || r == ';' || r == '|' || r == '/'
+	}) {
+		if h == node {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *numaFreeImbalanceProcessor) reset() {
+	if p.curr.samples > p.best.samples {
+		p.best = p.curr
+	}
+	p.curr = numaFreeEpisode{}
+}
+
+func (p *numaFreeImbalanceProcessor) columnIndexes() []int {
+	var out []int
+	for _, n := range p.nodes {
+		out = append(out, n.freeIdx)
+		if n.totalIdx >= 0 {
+			out = append(out, n.totalIdx)
+		}
+	}
+	for _, v := range p.vms {
+		out = append(out, v.homeIdx)
+		out = append(out, v.reclaimIdx...)
+	}
+	return out
+}
+
+func (p *numaFreeImbalanceProcessor) finalize() []DiagnosticFinding {
+	p.reset()
+	e := p.best
+	if e.samples < p.minConsecutive {
+		return nil
+	}
+	names := make([]string, 0, len(e.vms))
+	for name := range e.vms {
+		names = append(names, vmDisplayName(name))
+	}
+	sort.Strings(names)
+	if len(names) > 12 {
+		names = append(names[:12:12], fmt.Sprintf("... and %d more", len(e.vms)-12))
+	}
+	msg := newMessage("numa_free_imbalance", "low", e.low, "high", e.high, "low_free", e.lowFree, "high_free", e.highFree, "gap", e.gap, "samples", e.samples, "vms", len(e.vms), "names", strings.Join(names, ", "), "reclaim", e.reclaim)
+	return []DiagnosticFinding{{
+		TemplateID:     p.template.ID,
+		TemplateName:   p.template.Name,
+		Title:          p.template.Name,
+		Severity:       p.template.Severity,
+		ReportKey:      "numa",
+		AttributeLabel: "Numa Node: Free MBytes",
+		Instances:      append([]string{"Numa Node " + e.low}, names...),
+		Start:          e.start.UnixMilli(),
+		End:            e.end.UnixMilli(),
+		Summary:        msg.String(),
+		Message:        msg,
+	}}
+}
+
+// latencySensitiveContentionProcessor flags VMs set to latency sensitivity
+// High that still wait for a PCPU. The setting asks for exclusive cores, so
+// any sustained %RDY means the VM shares them after all; neighbors that were
+// busy at the same time are named as the likely competition.
+type latencySensitiveContentionProcessor struct {
+	template       DiagnosticTemplate
+	vms            []latencySensitiveVM
+	readyThreshold float64
+	heavyUsed      float64
+	minConsecutive int
+}
+
+type latencySensitiveVM struct {
+	label      string
+	latencyIdx int
+	readyIdx   int
+	usedIdx    int
+	curr       latencySensitiveEpisode
+	best       latencySensitiveEpisode
+}
+
+type latencySensitiveEpisode struct {
+	samples   int
+	start     time.Time
+	end       time.Time
+	sum       float64
+	peak      float64
+	neighbors map[int]int // vm index -> samples above heavyUsed
+}
+
+func (p *latencySensitiveContentionProcessor) onRow(ts time.Time, record []string) {
+	var heavy []int
+	for i, v := range p.vms {
+		if v.usedIdx >= 0 && v.usedIdx < len(record) {
+			if used, ok := parseFloatValue(record[v.usedIdx]); ok && used >= p.heavyUsed {
+				heavy = append(heavy, i)
+			}
+		}
+	}
+	for i := range p.vms {
+		v := &p.vms[i]
+		if v.latencyIdx < 0 || v.latencyIdx >= len(record) || !isHighLatencySensitivity(record[v.latencyIdx]) {
+			p.reset(v)
+			continue
+		}
+		ready, ok := 0.0, false
+		if v.readyIdx < len(record) {
+			ready, ok = parseFloatValue(record[v.readyIdx])
+		}
+		if !ok || ready <= p.readyThreshold {
+			p.reset(v)
+			continue
+		}
+		e := &v.curr
+		if e.samples == 0 {
+			e.start = ts
+			e.neighbors = map[int]int{}
+		}
+		e.samples++
+		e.end = ts
+		e.sum += ready
+		e.peak = math.Max(e.peak, ready)
+		for _, n := range heavy {
+			if n != i {
+				e.neighbors[n]++
+			}
+		}
+	}
+}
+
+func (p *latencySensitiveContentionProcessor) reset(v *latencySensitiveVM) {
+	if v.curr.samples > v.best.samples {
+		v.best = v.curr
+	}
+	v.curr = latencySensitiveEpisode{}
+}
+
+func (p *latencySensitiveContentionProcessor) columnIndexes() []int {
+	var out []int
+	for _, v := range p.vms {
+		for _, idx := range []int{v.latencyIdx, v.readyIdx, v.usedIdx} {
+			if idx >= 0 {
+				out = append(out, idx)
+			}
+		}
+	}
+	return out
+}
+
+func (p *latencySensitiveContentionProcessor) finalize() []DiagnosticFinding {
+	findings := make([]DiagnosticFinding, 0)
+	for i := range p.vms {
+		v := &p.vms[i]
+		p.reset(v)
+		e := v.best
+		if e.samples < p.minConsecutive {
+			continue
+		}
+		// Busiest neighbors first: the ones that overlapped the most.
+		order := make([]int, 0, len(e.neighbors))
+		for n := range e.neighbors {
+			order = append(order, n)
+		}
+		sort.Slice(order, func(a, b int) bool {
+			if e.neighbors[order[a]] != e.neighbors[order[b]] {
+				return e.neighbors[order[a]] > e.neighbors[order[b]]
+			}
+			return p.vms[order[a]].label < p.vms[order[b]].label
+		})
+		var neighbors any = ""
+		if len(order) > 0 {
+			names := make([]string, 0, min(len(order), 5))
+			for _, n := range order[:min(len(order), 5)] {
+				names = append(names, vmDisplayName(p.vms[n].label))
+			}
+			if len(order) > 5 {
+				names = append(names, fmt.Sprintf("... and %d more", len(order)-5))
+			}
+			neighbors = newMessage("latency_sensitive_contention.neighbors", "count", len(order), "used", p.heavyUsed, "names", strings.Join(names, ", "))
+		}
+		msg := newMessage("latency_sensitive_contention", "vm", vmDisplayName(v.label), "avg", e.sum/float64(e.samples), "peak", e.peak, "samples", e.samples, "neighbors", neighbors)
+		findings = append(findings, DiagnosticFinding{
+			TemplateID:     p.template.ID,
+			TemplateName:   p.template.Name,
+			Title:          p.template.Name,
+			Severity:       p.template.Severity,
+			ReportKey:      "cpu",
+			AttributeLabel: "Group Cpu: % Ready",
+			Instances:      []string{v.label},
+			Start:          e.start.UnixMilli(),
+			End:            e.end.UnixMilli(),
+			Summary:        msg.String(),
+			Message:        msg,
+		})
+	}
+	sort.Slice(findings, func(i, j int) bool {
+		return findings[i].Summary < findings[j].Summary
+	})
+	return findings
+}
+
+// ioKneeProcessor looks for the outstanding-IO level at which a device's
+// latency stops being flat and starts climbing with the queue, the point
+// past which more parallelism only adds waiting (Little's law: once the
+// device is saturated, latency grows as OIO / IOPS). Samples are binned by
+// OIO (ACTV + QUED) so memory stays bounded however long the capture is;
+// the knee is where a two-segment line fit over the bins' mean latency
+// breaks.
+type ioKneeProcessor struct {
+	template    DiagnosticTemplate
+	devices     []ioKneeDevice
+	minLatency  float64
+	factor      float64
+	minSamples  int
+	minBinCount int
+}
+
+type ioKneeDevice struct {
+	label     string
+	attribute string
+	actvIdx   int
+	quedIdx   int
+	latIdx    int
+	bins      []ioKneeBin
+}
+
+type ioKneeBin struct {
+	count       int
+	latSum      float64
+	first, last time.Time
+}
+
+// ioKneeMaxOIO caps the bins; deeper queues share the last one.
+const ioKneeMaxOIO = 1024
+
+func (p *ioKneeProcessor) onRow(ts time.Time, record []string) {
+	for i := range p.devices {
+		d := &p.devices[i]
+		if d.latIdx >= len(record) || d.actvIdx >= len(record) {
+			continue
+		}
+		actv, ok1 := parseFloatValue(record[d.actvIdx])
+		lat, ok2 := parseFloatValue(record[d.latIdx])
+		if !ok1 || !ok2 || !NumberFinite(actv) || !NumberFinite(lat) || actv < 0 || lat < 0 {
+			continue
+		}
+		oio := actv
+		if d.quedIdx >= 0 && d.quedIdx < len(record) {
+			if qued, ok := parseFloatValue(record[d.quedIdx]); ok && NumberFinite(qued) && qued > 0 {
+				oio += qued
+			}
+		}
+		bin := min(int(math.Round(oio)), ioKneeMaxOIO)
+		if bin >= len(d.bins) {
+			d.bins = append(d.bins, make([]ioKneeBin, bin+1-len(d.bins))...)
+		}
+		b := &d.bins[bin]
+		if b.count == 0 {
+			b.first = ts
+		}
+		b.count++
+		b.latSum += lat
+		b.last = ts
+	}
+}
+
+func (p *ioKneeProcessor) columnIndexes() []int {
+	out := make([]int, 0, len(p.devices)*3)
+	for _, d := range p.devices {
+		out = append(out, d.actvIdx, d.latIdx)
+		if d.quedIdx >= 0 {
+			out = append(out, d.quedIdx)
+		}
+	}
+	return out
+}
+
+// ioKneePoint is one populated bin: OIO, mean latency, weight.
+type ioKneePoint struct {
+	oio, lat float64
+	n        int
+	bin      int
+}
+
+// fitLine is a weighted least-squares line through pts; sse is its
+// weighted squared error.
+func fitLine(pts []ioKneePoint) (slope, sse float64) {
+	var w, sx, sy, sxx, sxy float64
+	for _, p := range pts {
+		n := float64(p.n)
+		w += n
+		sx += n * p.oio
+		sy += n * p.lat
+		sxx += n * p.oio * p.oio
+		sxy += n * p.oio * p.lat
+	}
+	den := w*sxx - sx*sx
+	if den == 0 {
+		return 0, 0
+	}
+	slope = (w*sxy - sx*sy) / den
+	icept := (sy - slope*sx) / w
+	for _, p := range pts {
+		r := p.lat - (icept + slope*p.oio)
+		sse += float64(p.n) * r * r
+	}
+	return slope, sse
+}
+
+func meanLatency(pts []ioKneePoint) (float64, int) {
+	var sum float64
+	var n int
+	for _, p := range pts {
+		sum += p.lat * float64(p.n)
+		n += p.n
+	}
+	if n == 0 {
+		return 0, 0
+	}
+	return sum / float64(n), n
+}
+
+// knee returns the index of the last bin before the latency inflection,
+// or -1 when the curve has no clear knee.
+func (p *ioKneeProcessor) knee(pts []ioKneePoint) int {
+	best, bestSSE := -1, math.Inf(1)
+	for k := 1; k < len(pts)-2; k++ {
+		left, right := pts[:k+1], pts[k+1:]
+		ls, lsse := fitLine(left)
+		rs, rsse := fitLine(right)
+		if rs <= 0 || rs < 2*math.Max(ls, 0) {
+			continue
+		}
+		lowLat, _ := meanLatency(left)
+		highLat, _ := meanLatency(right)
+		if highLat < p.factor*lowLat || highLat < p.minLatency {
+			continue
+		}
+		if sse := lsse + rsse; sse < bestSSE {
+			best, bestSSE = k, sse
+		}
+	}
+	return best
+}
+
+func (p *ioKneeProcessor) finalize() []DiagnosticFinding {
+	findings := make([]DiagnosticFinding, 0)
+	for _, d := range p.devices {
+		var pts []ioKneePoint
+		for i, b := range d.bins {
+			if b.count >= p.minBinCount {
+				pts = append(pts, ioKneePoint{oio: float64(i), lat: b.latSum / float64(b.count), n: b.count, bin: i})
+			}
+		}
+		if len(pts) < 4 {
+			continue
+		}
+		k := p.knee(pts)
+		if k < 0 {
+			continue
+		}
+		lowLat, _ := meanLatency(pts[:k+1])
+		highLat, _ := meanLatency(pts[k+1:])
+		// Every sample deeper than the knee counts as past it, including
+		// those in bins too sparse to take part in the fit.
+		kneeOIO := pts[k].bin
+		var past, total int
+		var first, last time.Time
+		for i, b := range d.bins {
+			total += b.count
+			if i <= kneeOIO || b.count == 0 {
+				continue
+			}
+			past += b.count
+			if first.IsZero() || b.first.Before(first) {
+				first = b.first
+			}
+			if b.last.After(last) {
+				last = b.last
+			}
+		}
+		if past < p.minSamples {
+			continue
+		}
+		iops := 0.0
+		if lowLat > 0 {
+			iops = float64(max(kneeOIO, 1)) * 1000 / lowLat
+		}
+		msg := newMessage("io_knee", "device", d.label, "knee", kneeOIO, "low", lowLat, "high", highLat,
+			"past", past, "share", 100*float64(past)/float64(total), "peak", len(d.bins)-1, "iops", iops, "attribute", d.attribute)
+		findings = append(findings, DiagnosticFinding{
+			TemplateID:     p.template.ID,
+			TemplateName:   p.template.Name,
+			Title:          p.template.Name,
+			Severity:       p.template.Severity,
+			ReportKey:      "storage",
+			AttributeLabel: d.attribute,
+			Instances:      []string{d.label},
+			Start:          first.UnixMilli(),
+			End:            last.UnixMilli(),
+			Summary:        msg.String(),
+			Message:        msg,
+		})
+	}
+	sort.Slice(findings, func(i, j int) bool {
+		return findings[i].Summary < findings[j].Summary
+	})
+	return findings
+}
+
+// wakeupStormProcessor flags worlds whose %RUN keeps flipping between high
+// and low from one sample to the next: a vCPU that halts and is woken again
+// far more often than its load explains. Each sample compares the step in
+// %RUN (or %WAIT, whichever moved more) with the previous step; a churn
+// sample is a large step in the opposite direction to the last one, and a
+// run of them in a row is a storm. Interrupt storms and guests polling in
+// their idle loop look like this.
+type wakeupStormProcessor struct {
+	template       DiagnosticTemplate
+	worlds         []wakeupStormWorld
+	attribute      string
+	threshold      float64
+	minConsecutive int
+}
+
+type wakeupStormWorld struct {
+	label    string
+	runIdx   int
+	waitIdx  int
+	havePrev bool
+	prevRun  float64
+	prevWait float64
+	waitOK   bool
+	prevStep float64
+	samples  int
+	churn    int
+	curr     wakeupStormEpisode
+	best     wakeupStormEpisode
+}
+
+type wakeupStormEpisode struct {
+	samples int
+	start   time.Time
+	end     time.Time
+	sum     float64
+	peak    float64
+}
+
+func (p *wakeupStormProcessor) onRow(ts time.Time, record []string) {
+	for i := range p.worlds {
+		w := &p.worlds[i]
+		if w.runIdx >= len(record) {
+			continue
+		}
+		run, ok := parseFloatValue(record[w.runIdx])
+		if !ok || !NumberFinite(run) {
+			w.end()
+			w.havePrev = false
+			continue
+		}
+		wait, waitOK := 0.0, false
+		if w.waitIdx >= 0 && w.waitIdx < len(record) {
+			wait, waitOK = parseFloatValue(record[w.waitIdx])
+			waitOK = waitOK && NumberFinite(wait)
+		}
+		if !w.havePrev {
+			w.havePrev, w.prevRun, w.prevWait, w.waitOK, w.prevStep = true, run, wait, waitOK, 0
+			continue
+		}
+		w.samples++
+		step := run - w.prevRun
+		swing := math.Abs(step)
+		if waitOK && w.waitOK {
+			swing = math.Max(swing, math.Abs(wait-w.prevWait))
+		}
+		flipped := (step > 0 && w.prevStep < 0) || (step < 0 && w.prevStep > 0)
+		if swing >= p.threshold && flipped {
+			w.churn++
+			e := &w.curr
+			if e.samples == 0 {
+				e.start = ts
+			}
+			e.samples++
+			e.end = ts
+			e.sum += swing
+			e.peak = math.Max(e.peak, swing)
+		} else {
+			w.end()
+		}
+		w.prevRun, w.prevWait, w.waitOK, w.prevStep = run, wait, waitOK, step
+	}
+}
+
+func (w *wakeupStormWorld) end() {
+	if w.curr.samples > w.best.samples {
+		w.best = w.curr
+	}
+	w.curr = wakeupStormEpisode{}
+}
+
+func (p *wakeupStormProcessor) columnIndexes() []int {
+	out := make([]int, 0, len(p.worlds)*2)
+	for _, w := range p.worlds {
+		out = append(out, w.runIdx)
+		if w.waitIdx >= 0 {
+			out = append(out, w.waitIdx)
+		}
+	}
+	return out
+}
+
+func (p *wakeupStormProcessor) finalize() []DiagnosticFinding {
+	findings := make([]DiagnosticFinding, 0)
+	for i := range p.worlds {
+		w := &p.worlds[i]
+		w.end()
+		e := w.best
+		if e.samples < p.minConsecutive || w.samples == 0 {
+			continue
+		}
+		msg := newMessage("wakeup_storm", "world", w.label, "avg", e.sum/float64(e.samples), "peak", e.peak,
+			"samples", e.samples, "share", 100*float64(w.churn)/float64(w.samples))
+		findings = append(findings, DiagnosticFinding{
+			TemplateID:     p.template.ID,
+			TemplateName:   p.template.Name,
+			Title:          p.template.Name,
+			Severity:       p.template.Severity,
+			ReportKey:      "cpu",
+			AttributeLabel: p.attribute,
+			Instances:      []string{w.label},
+			Start:          e.start.UnixMilli(),
+			End:            e.end.UnixMilli(),
+			Summary:        msg.String(),
+			Message:        msg,
+		})
+	}
+	sort.Slice(findings, func(i, j int) bool {
+		return findings[i].Summary < findings[j].Summary
+	})
+	return findings
+}
+
+// memschedLimitProcessor flags groups (VMs or resource pools) that the
+// memory scheduler holds at their configured limit while they need more:
+// the target (or granted) memory sits at the limit and touched plus
+// reclaimed (ballooned, compressed, swapped) memory exceeds it. That is
+// the "host has free memory but the VM swaps" case; quoting host free
+// memory over the same samples makes the misconfiguration obvious.
+type memschedLimitProcessor struct {
+	template       DiagnosticTemplate
+	groups         []memschedLimitGroup
+	hostFreeIdx    []int
+	atLimit        float64
+	minConsecutive int
+}
+
+type memschedLimitGroup struct {
+	label      string
+	limitIdx   int
+	heldIdx    []int
+	touchedIdx int
+	reclaimIdx []int
+	curr       memschedLimitEpisode
+	best       memschedLimitEpisode
+}
+
+type memschedLimitEpisode struct {
+	samples   int
+	start     time.Time
+	end       time.Time
+	limit     float64
+	heldSum   float64
+	demandSum float64
+	peak      float64
+	freeSum   float64
+	freeRows  int
+}
+
+func (p *memschedLimitProcessor) onRow(ts time.Time, record []string) {
+	free, freeOK := 0.0, false
+	for _, idx := range p.hostFreeIdx {
+		if v, ok := parseFloatAt(record, idx); ok {
+			free, freeOK = free+v, true
+		}
+	}
+	for i := range p.groups {
+		g := &p.groups[i]
+		limit, ok := parseFloatAt(record, g.limitIdx)
+		// Unlimited groups report -1 (or 0 when the counter is unset).
+		if !ok || limit <= 0 {
+			g.end()
+			continue
+		}
+		held := 0.0
+		for _, idx := range g.heldIdx {
+			if v, ok := parseFloatAt(record, idx); ok {
+				held = math.Max(held, v)
+			}
+		}
+		reclaimed := 0.0
+		for _, idx := range g.reclaimIdx {
+			if v, ok := parseFloatAt(record, idx); ok {
+				reclaimed += v
+			}
+		}
+		inRAM := held
+		if v, ok := parseFloatAt(record, g.touchedIdx); ok {
+			inRAM = v
+		}
+		demand := inRAM + reclaimed
+		if held < limit*p.atLimit/100 || demand <= limit || reclaimed <= 0 {
+			g.end()
+			continue
+		}
+		e := &g.curr
+		if e.samples == 0 {
+			e.start = ts
+		}
+		e.samples++
+		e.end = ts
+		e.limit = limit
+		e.heldSum += held
+		e.demandSum += demand
+		e.peak = math.Max(e.peak, reclaimed)
+		if freeOK {
+			e.freeSum += free
+			e.freeRows++
+		}
+	}
+}
+
+// parseFloatAt returns the finite value at record[idx], if any.
+func parseFloatAt(record []string, idx int) (float64, bool) {
+	if idx < 0 || idx >= len(record) {
+		return 0, false
+	}
+	v, ok := parseFloatValue(record[idx])
+	return v, ok && NumberFinite(v)
+}
+
+func (g *memschedLimitGroup) end() {
+	if g.curr.samples > g.best.samples {
+		g.best = g.curr
+	}
+	g.curr = memschedLimitEpisode{}
+}
+
+func (p *memschedLimitProcessor) columnIndexes() []int {
+	out := append([]int(nil), p.hostFreeIdx...)
+	for _, g := range p.groups {
+		out = append(out, g.limitIdx)
+		out = append(out, g.heldIdx...)
+		out = append(out, g.reclaimIdx...)
+		if g.touchedIdx >= 0 {
+			out = append(out, g.touchedIdx)
+		}
+	}
+	return out
+}
+
+func (p *memschedLimitProcessor) finalize() []DiagnosticFinding {
+	findings := make([]DiagnosticFinding, 0)
+	for i := range p.groups {
+		g := &p.groups[i]
+		g.end()
+		e := g.best
+		if e.samples < p.minConsecutive {
+			continue
+		}
+		n := float64(e.samples)
+		demand := e.demandSum / n
+		var host any = ""
+		if e.freeRows > 0 {
+			free := e.freeSum / float64(e.freeRows)
+			var spare any = ""
+			if free > demand-e.limit {
+				spare = newMessage("memsched_limit.host_spare")
+			}
+			host = newMessage("memsched_limit.host", "free", free, "spare", spare)
+		}
+		msg := newMessage("memsched_limit", "group", vmDisplayName(g.label), "limit", e.limit, "held", e.heldSum/n,
+			"demand", demand, "samples", e.samples, "peak", e.peak, "host", host)
+		findings = append(findings, DiagnosticFinding{
+			TemplateID:     p.template.ID,
+			TemplateName:   p.template.Name,
+			Title:          p.template.Name,
+			Severity:       p.template.Severity,
+			ReportKey:      "memory",
+			AttributeLabel: "Group Memory: Memory Limit MBytes",
+			Instances:      []string{g.label},
+			Start:          e.start.UnixMilli(),
+			End:            e.end.UnixMilli(),
+			Summary:        msg.String(),
+			Message:        msg,
+		})
+	}
+	sort.Slice(findings, func(i, j int) bool {
+		return findings[i].Summary < findings[j].Summary
+	})
+	return findings
+}
+
+// ipStorageNICProcessor pairs IP storage paths (NFS, software iSCSI) with
+// the vmnic uplinks and flags a path whose latency rises and falls with an
+// uplink's traffic while that uplink is under pressure (dropping packets,
+// or near its link speed when the capture has it). Latency that moves with
+// the network rather than on its own is added by the network layer, not
+// the array.
+type ipStorageNICProcessor struct {
+	template       DiagnosticTemplate
+	paths          []ipStoragePath
+	uplinks        []ipStorageUplink
+	pairs          [][]ipStoragePair // [path][uplink]
+	latThreshold   float64
+	dropThreshold  float64
+	utilThreshold  float64
+	minCorrelation float64
+	window         int
+	minConsecutive int
+	// pos is the ring slot shared by every series, so windows stay
+	// aligned sample for sample.
+	pos int
+}
+
+type ipStoragePath struct {
+	label     string
+	attribute string
+	latIdxs   []int
+	lat       []float64
+	filled    int
+	cur       float64
+}
+
+type ipStorageUplink struct {
+	label    string
+	txIdxs   []int
+	rxIdxs   []int
+	dropIdxs []int
+	speedIdx int
+	load     []float64
+	filled   int
+	mbits    float64
+	drops    float64
+	util     float64
+	utilOK   bool
+}
+
+type ipStoragePair struct {
+	curr ipStorageEpisode
+	best ipStorageEpisode
+}
+
+type ipStorageEpisode struct {
+	samples   int
+	start     time.Time
+	end       time.Time
+	corrSum   float64
+	peakLat   float64
+	peakMbits float64
+	peakDrops float64
+	peakUtil  float64
+	utilOK    bool
+}
+
+func (p *ipStorageNICProcessor) onRow(ts time.Time, record []string) {
+	for i := range p.uplinks {
+		u := &p.uplinks[i]
+		tx, okTx := sumColumns(record, u.txIdxs)
+		rx, okRx := sumColumns(record, u.rxIdxs)
+		if !okTx && !okRx {
+			// A gap breaks the window, as in readyIOProcessor.
+			u.filled = 0
+			continue
+		}
+		u.mbits = math.Max(tx, rx)
+		u.drops, _ = sumColumns(record, u.dropIdxs)
+		u.util, u.utilOK = 0, false
+		if speed, ok := parseFloatAt(record, u.speedIdx); ok && speed > 0 {
+			u.util, u.utilOK = 100*u.mbits/speed, true
+		}
+		u.load[p.pos] = u.mbits
+		u.filled = min(u.filled+1, p.window)
+	}
+	for i := range p.paths {
+		s := &p.paths[i]
+		lat, ok := maxColumnValue(record, s.latIdxs)
+		if !ok {
+			s.filled = 0
+			continue
+		}
+		s.cur, s.lat[p.pos] = lat, lat
+		s.filled = min(s.filled+1, p.window)
+	}
+	p.pos = (p.pos + 1) % p.window
+
+	for pi := range p.paths {
+		s := &p.paths[pi]
+		for ui := range p.uplinks {
+			u := &p.uplinks[ui]
+			pair := &p.pairs[pi][ui]
+			pressured := u.drops >= p.dropThreshold || (u.utilOK && u.util >= p.utilThreshold)
+			if s.filled < p.window || u.filled < p.window || s.cur < p.latThreshold || !pressured {
+				pair.end()
+				continue
+			}
+			corr, ok := pearson(s.lat, u.load)
+			if !ok || corr < p.minCorrelation {
+				pair.end()
+				continue
+			}
+			e := &pair.curr
+			if e.samples == 0 {
+				e.start = ts
+			}
+			e.samples++
+			e.end = ts
+			e.corrSum += corr
+			e.peakLat = math.Max(e.peakLat, s.cur)
+			e.peakMbits = math.Max(e.peakMbits, u.mbits)
+			e.peakDrops = math.Max(e.peakDrops, u.drops)
+			if u.utilOK {
+				e.peakUtil, e.utilOK = math.Max(e.peakUtil, u.util), true
+			}
+		}
+	}
+}
+
+func (pair *ipStoragePair) end() {
+	if pair.curr.samples > pair.best.samples {
+		pair.best = pair.curr
+	}
+	pair.curr = ipStorageEpisode{}
+}
+
+func (p *ipStorageNICProcessor) columnIndexes() []int {
+	var out []int
+	for _, s := range p.paths {
+		out = append(out, s.latIdxs...)
+	}
+	for _, u := range p.uplinks {
+		out = append(out, u.txIdxs...)
+		out = append(out, u.rxIdxs...)
+		out = append(out, u.dropIdxs...)
+		if u.speedIdx >= 0 {
+			out = append(out, u.speedIdx)
+		}
+	}
+	return out
+}
+
+// finalize reports each path once, against the uplink it tracked longest.
+func (p *ipStorageNICProcessor) finalize() []DiagnosticFinding {
+	findings := make([]DiagnosticFinding, 0)
+	for pi, s := range p.paths {
+		best, uplink := ipStorageEpisode{}, ""
+		for ui := range p.uplinks {
+			pair := &p.pairs[pi][ui]
+			pair.end()
+			if pair.best.samples > best.samples {
+				best, uplink = pair.best, p.uplinks[ui].label
+			}
+		}
+		if best.samples < p.minConsecutive {
+			continue
+		}
+		var util any = ""
+		if best.utilOK {
+			util = newMessage("ip_storage_nic.util", "pct", best.peakUtil)
+		}
+		msg := newMessage("ip_storage_nic", "path", s.label, "uplink", uplink, "samples", best.samples,
+			"correlation", best.corrSum/float64(best.samples), "window", p.window, "peak", best.peakLat,
+			"mbits", best.peakMbits, "util", util, "drops", best.peakDrops)
+		findings = append(findings, DiagnosticFinding{
+			TemplateID:     p.template.ID,
+			TemplateName:   p.template.Name,
+			Title:          p.template.Name,
+			Severity:       p.template.Severity,
+			ReportKey:      "storage",
+			AttributeLabel: s.attribute,
+			Instances:      []string{s.label, uplink},
+			Start:          best.start.UnixMilli(),
+			End:            best.end.UnixMilli(),
+			Summary:        msg.String(),
+			Message:        msg,
+		})
+	}
+	sort.Slice(findings, func(i, j int) bool {
+		return findings[i].Summary < findings[j].Summary
+	})
+	return findings
+}
+
+// pcpuImbalanceProcessor flags samples where some PCPUs are saturated and
+// others nearly idle while VMs still wait in the ready queue. With real
+// capacity shortage every PCPU would be busy; runnable worlds next to idle
+// cores mean the scheduler may not place them there: CPU affinity, NUMA
+// home node constraints, latency sensitivity or a licensing cap on usable
+// cores.
+type pcpuImbalanceProcessor struct {
+	template       DiagnosticTemplate
+	attribute      string
+	pcpuLabels     []string
+	pcpuIdxs       []int
+	groupLabels    []string
+	readyIdxs      []int
+	busyThreshold  float64
+	idleThreshold  float64
+	minReady       float64
+	minConsecutive int
+	curr           pcpuImbalanceEpisode
+	best           pcpuImbalanceEpisode
+	episodes       int
+}
+
+type pcpuImbalanceEpisode struct {
+	samples  int
+	start    time.Time
+	end      time.Time
+	busySum  int
+	idleSum  int
+	peakBusy int
+	peakIdle int
+	peakRdy  float64
+	rdyGroup string
+	// busyHits and idleHits count samples per PCPU, to name the ones
+	// that were busy or idle most of the episode.
+	busyHits map[int]int
+	idleHits map[int]int
+}
+
+func (p *pcpuImbalanceProcessor) onRow(ts time.Time, record []string) {
+	busy, idle := 0, 0
+	var busyNow, idleNow []int
+	for i, idx := range p.pcpuIdxs {
+		v, ok := parseFloatAt(record, idx)
+		if !ok {
+			continue
+		}
+		if v >= p.busyThreshold {
+			busy++
+			busyNow = append(busyNow, i)
+		} else if v <= p.idleThreshold {
+			idle++
+			idleNow = append(idleNow, i)
+		}
+	}
+	ready, group := 0.0, ""
+	for i, idx := range p.readyIdxs {
+		if v, ok := parseFloatAt(record, idx); ok && v > ready {
+			ready, group = v, p.groupLabels[i]
+		}
+	}
+	if busy == 0 || idle == 0 || ready < p.minReady {
+		p.end()
+		return
+	}
+	e := &p.curr
+	if e.samples == 0 {
+		e.start = ts
+		e.busyHits, e.idleHits = map[int]int{}, map[int]int{}
+	}
+	e.samples++
+	e.end = ts
+	e.busySum += busy
+	e.idleSum += idle
+	e.peakBusy = max(e.peakBusy, busy)
+	e.peakIdle = max(e.peakIdle, idle)
+	if ready > e.peakRdy {
+		e.peakRdy, e.rdyGroup = ready, group
+	}
+	for _, i := range busyNow {
+		e.busyHits[i]++
+	}
+	for _, i := range idleNow {
+		e.idleHits[i]++
+	}
+}
+
+func (p *pcpuImbalanceProcessor) end() {
+	if p.curr.samples >= p.minConsecutive {
+		p.episodes++
+	}
+	if p.curr.samples > p.best.samples {
+		p.best = p.curr
+	}
+	p.curr = pcpuImbalanceEpisode{}
+}
+
+func (p *pcpuImbalanceProcessor) columnIndexes() []int {
+	out := append([]int(nil), p.pcpuIdxs...)
+	return append(out, p.readyIdxs...)
+}
+
+// topPCPUs names up to n PCPUs with the most hits, most first.
+func (p *pcpuImbalanceProcessor) topPCPUs(hits map[int]int, n int) []string {
+	order := make([]int, 0, len(hits))
+	for i := range hits {
+		order = append(order, i)
+	}
+	sort.Slice(order, func(a, b int) bool {
+		if hits[order[a]] != hits[order[b]] {
+			return hits[order[a]] > hits[order[b]]
+		}
+		return order[a] < order[b]
+	})
+	out := make([]string, 0, n)
+	for _, i := range order[:min(n, len(order))] {
+		out = append(out, p.pcpuLabels[i])
+	}
+	return out
+}
+
+// finalize reports the longest episode once for the host.
+func (p *pcpuImbalanceProcessor) finalize() []DiagnosticFinding {
+	p.end()
+	b := p.best
+	if b.samples < p.minConsecutive {
+		return nil
+	}
+	busy := p.topPCPUs(b.busyHits, 4)
+	idle := p.topPCPUs(b.idleHits, 4)
+	msg := newMessage("pcpu_imbalance", "busy", float64(b.busySum)/float64(b.samples), "busy_names", strings.Join(busy, ", "),
+		"high", p.busyThreshold, "idle", float64(b.idleSum)/float64(b.samples), "idle_names", strings.Join(idle, ", "),
+		"low", p.idleThreshold, "pcpus", len(p.pcpuIdxs), "samples", b.samples, "group", b.rdyGroup, "ready", b.peakRdy,
+		"episodes", p.episodes)
+	instances := append(append(busy, idle...), b.rdyGroup)
+	return []DiagnosticFinding{{
+		TemplateID:     p.template.ID,
+		TemplateName:   p.template.Name,
+		Title:          p.template.Name,
+		Severity:       p.template.Severity,
+		ReportKey:      "cpu",
+		AttributeLabel: p.attribute,
+		Instances:      instances,
+		Start:          b.start.UnixMilli(),
+		End:            b.end.UnixMilli(),
+		Summary:        msg.String(),
+		Message:        msg,
+	}}
+}
+
+// memoryReclaimStages are ESXi's reclamation techniques in the order the
+// host is expected to escalate through them as free memory shrinks.
+var memoryReclaimStages = []string{"balloon", "compress", "swap"}
+
+// memoryReclaimStage classifies a memory column into a reclamation stage, or
+// "" when it isn't one of the tracked size counters.
+func memoryReclaimStage(c parsedColumn) string {
+	if !strings.EqualFold(c.Object, "Memory") && !strings.EqualFold(c.Object, "Group Memory") {
+		return ""
+	}
+	if containsAnyFold(c.Counter, "/sec", "target", "max", "saved") {
+		return ""
+	}
+	switch {
+	case containsAnyFold(c.Counter, "memctl"):
+		return "balloon"
+	case containsAnyFold(c.Counter, "zip", "compressed"):
+		return "compress"
+	case containsAnyFold(c.Counter, "swap used", "swapped"):
+		return "swap"
+	}
+	return ""
+}
+
+type memoryReclaimStageState struct {
+	indexes   []int
+	baseSet   bool
+	base      float64
+	peak      float64
+	first     time.Time
+	activeLen int
+}
+
+type memoryReclaimSegment struct {
+	stage string
+	start time.Time
+	end   time.Time
+}
+
+// memoryReclaimProcessor looks at balloon, compression and swap columns
+// together (summed per stage) and reports when each stage engaged, so the
+// finding tells the escalation story instead of three separate threshold hits.
+type memoryReclaimProcessor struct {
+	template DiagnosticTemplate
+	stages   []memoryReclaimStageState
+	minDelta float64
+	segments []memoryReclaimSegment
+	lastTs   time.Time
+}
+
+func (p *memoryReclaimProcessor) onRow(ts time.Time, record []string) {
+	current := ""
+	for si := range p.stages {
+		st := &p.stages[si]
+		if len(st.indexes) == 0 {
+			continue
+		}
+		total := 0.0
+		valid := 0
+		for _, idx := range st.indexes {
+			if idx < 0 || idx >= len(record) {
+				continue
+			}
+			v, ok := parseFloatValue(record[idx])
+			if !ok || !NumberFinite(v) {
+				continue
+			}
+			total += v
+			valid++
+		}
+		if valid == 0 {
+			continue
+		}
+		if !st.baseSet {
+			st.baseSet = true
+			st.base = total
+		}
+		if total-st.base < p.minDelta {
+			continue
+		}
+		if st.first.IsZero() {
+			st.first = ts
+		}
+		if total > st.peak {
+			st.peak = total
+		}
+		st.activeLen++
+		current = memoryReclaimStages[si]
+	}
+	if n := len(p.segments); n > 0 && p.segments[n-1].stage == current {
+		p.segments[n-1].end = ts
+	} else {
+		p.segments = append(p.segments, memoryReclaimSegment{stage: current, start: ts, end: ts})
+	}
+	p.lastTs = ts
+}
+
+func (p *memoryReclaimProcessor) columnIndexes() []int {
+	var out []int
+	for _, st := range p.stages {
+		out = append(out, st.indexes...)
+	}
+	return out
+}
+
+func (p *memoryReclaimProcessor) finalize() []DiagnosticFinding {
+	var engaged []string
+	var parts []*FindingMessage
+	var first, last time.Time
+	for si, st := range p.stages {
+		if st.first.IsZero() {
+			continue
+		}
+		name := memoryReclaimStages[si]
+		engaged = append(engaged, name)
+		parts = append(parts, newMessage("memory_reclaim.stage", "stage", name, "first", st.first.UTC().Format("15:04:05"), "peak", st.peak, "samples", st.activeLen))
+		if first.IsZero() || st.first.Before(first) {
+			first = st.first
+		}
+	}
+	if len(engaged) == 0 {
+		return nil
+	}
+	last = p.lastTs
+
+	// Order in which stages actually engaged.
+	order := append([]string(nil), engaged...)
+	sort.SliceStable(order, func(i, j int) bool {
+		return p.stageState(order[i]).first.Before(p.stageState(order[j]).first)
+	})
+	outOfOrder := false
+	for i := 1; i < len(order); i++ {
+		if stageRank(order[i]) < stageRank(order[i-1]) {
+			outOfOrder = true
+		}
+	}
+
+	var timeline []string
+	for _, seg := range p.segments {
+		if seg.stage == "" {
+			continue
+		}
+		timeline = append(timeline, fmt.Sprintf("%s %s-%s", seg.stage, seg.start.UTC().Format("15:04:05"), seg.end.UTC().Format("15:04:05")))
+	}
+	if len(timeline) > 8 {
+		timeline = append(timeline[:8], fmt.Sprintf("... and %d more", len(timeline)-8))
+	}
+
+	var verdict *FindingMessage
+	switch {
+	case outOfOrder:
+		verdict = newMessage("memory_reclaim.out_of_order")
+	case order[len(order)-1] == "swap":
+		verdict = newMessage("memory_reclaim.swap")
+	default:
+		verdict = newMessage("memory_reclaim.cheap")
+	}
+	var timelineText any = ""
+	if len(timeline) > 0 {
+		timelineText = newMessage("memory_reclaim.timeline", "entries", strings.Join(timeline, ", "))
+	}
+	msg := newMessage("memory_reclaim", "stages", parts, "order", strings.Join(order, " -> "), "verdict", verdict, "timeline", timelineText)
+	return []DiagnosticFinding{{
+		TemplateID:   p.template.ID,
+		TemplateName: p.template.Name,
+		Title:        p.template.Name,
+		Severity:     p.template.Severity,
+		ReportKey:    "memory",
+		Instances:    engaged,
+		Summary:      msg.String(),
+		Message:      msg,
+		Start:        first.UnixMilli(),
+		End:          last.UnixMilli(),
+	}}
+}
+
+func (p *memoryReclaimProcessor) stageState(name string) memoryReclaimStageState {
+	return p.stages[stageRank(name)]
+}
+
+func stageRank(name string) int {
+	for i, s := range memoryReclaimStages {
+		if s == name {
+			return i
+		}
+	}
+	return len(memoryReclaimStages)
+}
+
+var vmnicNamePattern = regexp.MustCompile(`(?i)vmnic\d+`)
+
+// nicQueueGroup derives the NIC and direction a per-queue column belongs to,
+// e.g. "vmnic2 rx" for a receive counter on any of vmnic2's queues.
+func nicQueueGroup(c parsedColumn) string {
+	nic := vmnicNamePattern.FindString(c.Instance)
+	if nic == "" {
+		nic = c.Instance
+		if p := strings.LastIndexAny(nic, ":./-"); p > 0 {
+			nic = nic[:p]
+		}
+	}
+	dir := "rx"
+	if containsAnyFold(c.Counter, "transmit", "tx", "outbound", "sent") {
+		dir = "tx"
+	}
+	return strings.ToLower(nic) + " " + dir
+}
+
+func NumberFinite(v float64) bool {
+	return !math.IsNaN(v) && !math.IsInf(v, 0)
+}
+
+func parseTruthy(s string) bool {
+	s = strings.TrimSpace(strings.ToLower(s))
+	return s == "true" || s == "1" || s == "yes" || s == "y"
+}
+
+func containsAnyFold(s string, subs ...string) bool {
+	s = strings.ToLower(s)
+	for _, sub := range subs {
+		if strings.Contains(s, strings.ToLower(sub)) {
+			return true
+		}
+	}
+	return false
+}
+
+func excludedByName(name string, excludes []string) bool {
+	if len(excludes) == 0 {
+		return false
+	}
+	n := strings.ToLower(name)
+	for _, ex := range excludes {
+		ex = strings.TrimSpace(strings.ToLower(ex))
+		if ex == "" {
+			continue
+		}
+		if strings.Contains(n, ex) {
+			return true
+		}
+	}
+	return false
+}
+
+func excludedByRegex(name string, patterns []string) bool {
+	if len(patterns) == 0 {
+		return false
+	}
+	for _, p := range patterns {
+		p = strings.TrimSpace(p)
+		if p == "" {
+			continue
+		}
+		re, err := regexp.Compile("(?i)" + p)
+		if err != nil {
+			continue
+		}
+		if re.MatchString(name) {
+			return true
+		}
+	}
+	return false
+}
+
+// includedByRegex reports whether name matches one of patterns; none
+// matches nothing.
+func includedByRegex(name string, patterns []string) bool {
+	return excludedByRegex(name, patterns)
+}
+
+func matchesIncludedAttribute(label string, includes []string) bool {
+	if len(includes) == 0 {
+		return true
+	}
+	for _, inc := range includes {
+		if sameAttribute(inc, label) {
+			return true
+		}
+	}
+	return false
+}
+
+func matchesTargetAttribute(label, target string) bool {
+	target = strings.TrimSpace(target)
+	if target == "" {
+		return true
+	}
+	return sameAttribute(label, target)
+}
+
+func matchesIncludedObject(object string, includes []string) bool {
+	if len(includes) == 0 {
+		return true
+	}
+	for _, inc := range includes {
+		if strings.EqualFold(strings.TrimSpace(inc), strings.TrimSpace(object)) {
+			return true
+		}
+	}
+	return false
+}
+
+func evaluateTemplateCondition(c parsedColumn, cond TemplateCondition) bool {
+	field := strings.TrimSpace(strings.ToLower(cond.Field))
+	op := strings.TrimSpace(strings.ToLower(cond.Op))
+	val := cond.Value
+	var target string
+	switch field {
+	case "object":
+		target = c.Object
+	case "attribute", "attributelabel":
+		target = c.AttributeLabel
+	case "instance":
+		target = c.Instance
+	case "counter":
+		target = c.Counter
+	case "raw":
+		target = c.Raw
+	default:
+		return false
+	}
+	targetFold := strings.ToLower(strings.TrimSpace(target))
+	valueFold := strings.ToLower(strings.TrimSpace(val))
+
+	switch op {
+	case "eq", "=":
+		return targetFold == valueFold
+	case "neq", "!=":
+		return targetFold != valueFold
+	case "contains":
+		return strings.Contains(targetFold, valueFold)
+	case "not_contains":
+		return !strings.Contains(targetFold, valueFold)
+	case "regex":
+		re, err := regexp.Compile("(?i)" + strings.TrimSpace(val))
+		return err == nil && re.MatchString(target)
+	case "not_regex":
+		re, err := regexp.Compile("(?i)" + strings.TrimSpace(val))
+		return err == nil && !re.MatchString(target)
+	case "prefix", "starts_with":
+		return strings.HasPrefix(targetFold, valueFold)
+	case "suffix", "ends_with":
+		return strings.HasSuffix(targetFold, valueFold)
+	case "gt", ">", "gte", ">=", "lt", "<", "lte", "<=":
+		n, ok := conditionNumber(target)
+		limit, err := strconv.ParseFloat(valueFold, 64)
+		if !ok || err != nil {
+			return false
+		}
+		switch op {
+		case "gt", ">":
+			return n > limit
+		case "gte", ">=":
+			return n >= limit
+		case "lt", "<":
+			return n < limit
+		default:
+			return n <= limit
+		}
+	case "in", "between", "not_in":
+		n, ok := conditionNumber(target)
+		if !ok {
+			return false
+		}
+		in, valid := numberInRanges(n, valueFold)
+		return valid && in == (op != "not_in")
+	case "even", "odd":
+		n, ok := conditionNumber(target)
+		return ok && n == math.Trunc(n) && (int64(n)%2 == 0) == (op == "even")
+	default:
+		return false
+	}
+}
+
+var conditionNumberPattern = regexp.MustCompile(`\d+(?:\.\d+)?`)
+
+// conditionNumber is the number numeric operators compare: the whole value
+// when it is numeric ("3"), else its first number ("vmhba2" -> 2,
+// "12:vm-web" -> 12).
+func conditionNumber(target string) (float64, bool) {
+	target = strings.TrimSpace(target)
+	if v, err := strconv.ParseFloat(target, 64); err == nil && NumberFinite(v) {
+		return v, true
+	}
+	m := conditionNumberPattern.FindString(target)
+	if m == "" {
+		return 0, false
+	}
+	v, err := strconv.ParseFloat(m, 64)
+	return v, err == nil
+}
+
+// numberInRanges reports whether n falls in spec, a comma-separated list
+// of numbers and inclusive ranges ("0-3", "0..3", "0–3", "2,5-7"). valid
+// is false when spec does not parse.
+func numberInRanges(n float64, spec string) (in, valid bool) {
+	for _, part := range strings.Split(spec, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		lo, hi := part, part
+		for _, sep := range []string{"..", "–", "-"} {
+			if a, b, ok := strings.Cut(part, sep); ok && a != "" {
+				lo, hi = a, b
+				break
+			}
+		}
+		from, err1 := strconv.ParseFloat(strings.TrimSpace(lo), 64)
+		to, err2 := strconv.ParseFloat(strings.TrimSpace(hi), 64)
+		if err1 != nil || err2 != nil {
+			return false, false
+		}
+		if from > to {
+			from, to = to, from
+		}
+		valid = true
+		if n >= from && n <= to {
+			in = true
+		}
+	}
+	return in, valid
+}
+
+func matchesTemplateFilter(c parsedColumn, filter TemplateFilter) bool {
+	if len(filter.Conditions) == 0 {
+		return true
+	}
+	logic := strings.TrimSpace(strings.ToLower(filter.Logic))
+	if logic == "or" {
+		for _, cond := range filter.Conditions {
+			if evaluateTemplateCondition(c, cond) {
+				return true
+			}
+		}
+		return false
+	}
+	for _, cond := range filter.Conditions {
+		if !evaluateTemplateCondition(c, cond) {
+			return false
+		}
+	}
+	return true
+}
+
+func inferReportKeyFromAttribute(attr string) string {
+	l := strings.ToLower(attr)
+	switch {
+	case strings.Contains(l, "cpu") || strings.Contains(l, "vcpu") || strings.Contains(l, "% ready") || strings.Contains(l, "% costop"):
+		return "cpu"
+	case strings.Contains(l, "memory") || strings.Contains(l, "swap") || strings.Contains(l, "group memory"):
+		return "memory"
+	case strings.Contains(l, "numa"):
+		return "numa"
+	case strings.Contains(l, "network") || strings.Contains(l, "net"):
+		return "network"
+	case strings.Contains(l, "disk") || strings.Contains(l, "storage") || strings.Contains(l, "latency"):
+		return "storage"
+	case strings.Contains(l, "power") || strings.Contains(l, "pstate") || strings.Contains(l, "watts"):
+		return "power"
+	case strings.Contains(l, "vsan"):
+		return "vsan"
+	default:
+		return "other"
+	}
+}
+
+func buildProcessors(templates []DiagnosticTemplate, cols []parsedColumn, sampleInterval time.Duration) []rowProcessor {
+	var processors []rowProcessor
+	for _, t := range templates {
+		switch t.Detector.Type {
+		case "threshold_sustained", "high_ready", "high_costop", "storage_latency", "low_numa_local", "memory_overcommit_high", "network_outbound_drop_high", "disk_adapter_failed_reads_high", "disk_adapter_driver_latency_high", "scsi_queue_full":
+			var idxs []int
+			var labels []string
+			attribute := ""
+			reportKey := "cpu"
+			lowerBound := 0.0
+			upperBound := 0.0
+			hasLowerBound := false
+			hasUpperBound := false
+			threshold := t.Detector.Threshold
+			minConsecutive := t.Detector.MinConsecutive
+			if minConsecutive <= 0 {
+				minConsecutive = 6
+				if t.Detector.Type == "scsi_queue_full" {
+					// QFULL/BUSY should be zero on a healthy path; a few
+					// samples in a row already warrant array-side review.
+					minConsecutive = 3
+				}
+			}
+			if threshold <= 0 {
+				switch t.Detector.Type {
+				case "high_ready":
+					threshold = 5
+				case "high_costop":
+					threshold = 3
+				case "storage_latency":
+					threshold = 20
+				case "low_numa_local":
+					threshold = 85
+				case "memory_overcommit_high":
+					threshold = 100
+				case "network_outbound_drop_high":
+					threshold = 1
+				case "disk_adapter_failed_reads_high":
+					threshold = 5
+				case "disk_adapter_driver_latency_high":
+					threshold = 30
+				case "scsi_queue_full":
+					threshold = 0.01
+				}
+			}
+			upperThreshold := t.Detector.UpperThreshold
+			comparison := strings.TrimSpace(strings.ToLower(t.Detector.Comparison))
+			switch comparison {
+			case "less":
+				if threshold > 0 {
+					upperBound = threshold
+					hasUpperBound = true
+				}
+			case "greater", "":
+				if threshold > 0 {
+					lowerBound = threshold
+					hasLowerBound = true
+				}
+			default:
+				if threshold > 0 {
+					lowerBound = threshold
+					hasLowerBound = true
+				}
+			}
+			if upperThreshold > 0 {
+				upperBound = upperThreshold
+				hasUpperBound = true
+			}
+			if hasLowerBound && hasUpperBound && upperBound < lowerBound {
+				lowerBound, upperBound = upperBound, lowerBound
+			}
+
+			for _, c := range cols {
+				l := strings.ToLower(canonicalAttributeLabel(c.AttributeLabel))
+				match := false
+				switch t.Detector.Type {
+				case "threshold_sustained":
+					match = true
+					reportKey = "other"
+				case "high_ready":
+					match = strings.Contains(l, "% ready")
+					reportKey = "cpu"
+				case "high_costop":
+					match = strings.Contains(l, "% costop")
+					reportKey = "cpu"
+				case "storage_latency":
+					match = strings.Contains(l, "latency")
+					reportKey = "storage"
+				case "low_numa_local":
+					match = strings.Contains(l, "group memory: numa % local")
+					reportKey = "numa"
+				case "memory_overcommit_high":
+					match = strings.Contains(l, "memory: memory overcommit (1 minute avg)")
+					reportKey = "memory"
+				case "network_outbound_drop_high":
+					match = strings.Contains(l, "network port: % outbound packets dropped")
+					reportKey = "network"
+				case "disk_adapter_failed_reads_high":
+					match = strings.Contains(l, "failed reads/sec")
+					reportKey = "storage"
+				case "disk_adapter_driver_latency_high":
+					match = strings.Contains(l, "average driver millisec/command")
+					reportKey = "storage"
+				case "scsi_queue_full":
+					match = containsAnyFold(c.Object, "disk", "scsi", "path") && containsAnyFold(c.Counter, "queue full", "qfull", "busy", "reservation conflict")
+					reportKey = "storage"
+				}
+				if !match {
+					continue
+				}
+				if !matchesTargetAttribute(c.AttributeLabel, t.Detector.TargetAttribute) {
+					continue
+				}
+				if !matchesTemplateFilter(c, t.Detector.Filter) {
+					continue
+				}
+				if !matchesIncludedAttribute(c.AttributeLabel, t.Detector.IncludeAttributeEquals) {
+					continue
+				}
+				if !matchesIncludedObject(c.Object, t.Detector.IncludeObjectEquals) {
+					continue
+				}
+				if excludedByName(c.Instance, t.Detector.ExcludeInstanceContains) {
+					continue
+				}
+				if excludedByRegex(c.Instance, t.Detector.ExcludeInstanceRegex) {
+					continue
+				}
+				idxs = append(idxs, c.Idx)
+				labels = append(labels, c.Instance)
+				if attribute == "" {
+					attribute = c.AttributeLabel
+				}
+			}
+			if len(idxs) > 0 {
+				if reportKey == "other" && attribute != "" {
+					reportKey = inferReportKeyFromAttribute(attribute)
+				}
+				gapFactor := t.Detector.MaxGapFactor
+				if gapFactor <= 0 {
+					gapFactor = 3
+				}
+				var maxGap time.Duration
+				if sampleInterval > 0 {
+					maxGap = time.Duration(gapFactor * float64(sampleInterval))
+				}
+				p := &thresholdProcessor{
+					template:       t,
+					reportKey:      reportKey,
+					attributeLabel: attribute,
+					indexes:        idxs,
+					labels:         labels,
+					lowerBound:     lowerBound,
+					upperBound:     upperBound,
+					hasLowerBound:  hasLowerBound,
+					hasUpperBound:  hasUpperBound,
+					minConsecutive: minConsecutive,
+					minDuration:    time.Duration(t.Detector.MinDurationSeconds * float64(time.Second)),
+					maxGap:         maxGap,
+				}
+				if t.Detector.Baseline != nil && t.Detector.Baseline.Factor > 0 {
+					cfg := *t.Detector.Baseline
+					if cfg.Percentile <= 0 || cfg.Percentile > 100 {
+						cfg.Percentile = 50
+					}
+					if cfg.Minutes <= 0 {
+						cfg.Minutes = 10
+					}
+					p.baseline = &thresholdBaselineState{config: cfg, less: comparison == "less"}
+				}
+				processors = append(processors, p)
+			}
+		case "numa_zigzag", "zigzag_switch":
+			var idxs []int
+			var labels []string
+			for _, c := range cols {
+				if !matchesTargetAttribute(c.AttributeLabel, t.Detector.TargetAttribute) {
+					continue
+				}
+				if len(t.Detector.Filter.Conditions) > 0 {
+					if !matchesTemplateFilter(c, t.Detector.Filter) {
+						continue
+					}
+				} else if !(containsAnyFold(c.AttributeLabel, "numa") && containsAnyFold(c.AttributeLabel, "load", "% used", "% processor time")) {
+					continue
+				}
+				idxs = append(idxs, c.Idx)
+				labels = append(labels, c.AttributeLabel)
+			}
+			if len(idxs) >= 2 {
+				minSwitches := t.Detector.MinSwitches
+				if minSwitches <= 0 {
+					minSwitches = 6
+				}
+				minGap := t.Detector.MinGap
+				if minGap <= 0 {
+					minGap = 3.0
+				}
+				processors = append(processors, &numaZigzagProcessor{
+					template:     t,
+					indexes:      idxs,
+					labels:       labels,
+					minSwitches:  minSwitches,
+					minGap:       minGap,
+					prevDominant: -1,
+				})
+			}
+		case "value_switch":
+			var idxs []int
+			var labels []string
+			attrLabel := strings.TrimSpace(t.Detector.TargetAttribute)
+			if attrLabel == "" {
+				break
+			}
+			for _, c := range cols {
+				if !matchesTargetAttribute(c.AttributeLabel, attrLabel) {
+					continue
+				}
+				if len(t.Detector.Filter.Conditions) > 0 && !matchesTemplateFilter(c, t.Detector.Filter) {
+					continue
+				}
+				idxs = append(idxs, c.Idx)
+				labels = append(labels, c.Instance)
+			}
+			if len(idxs) > 0 {
+				minSwitches := t.Detector.MinSwitches
+				if minSwitches <= 0 {
+					minSwitches = 6
+				}
+				processors = append(processors, &valueSwitchProcessor{
+					template:       t,
+					reportKey:      inferReportKeyFromAttribute(attrLabel),
+					attributeLabel: attrLabel,
+					indexes:        idxs,
+					labels:         labels,
+					minSwitches:    minSwitches,
+				})
+			}
+		case "exclusive_affinity":
+			var idxs []int
+			var labels []string
+			for _, c := range cols {
+				if !matchesTargetAttribute(c.AttributeLabel, t.Detector.TargetAttribute) {
+					continue
+				}
+				if !matchesTemplateFilter(c, t.Detector.Filter) {
+					continue
+				}
+				if containsAnyFold(c.AttributeLabel, "exclusive affinity") {
+					idxs = append(idxs, c.Idx)
+					labels = append(labels, c.Instance)
+				}
+			}
+			if len(idxs) > 0 {
+				processors = append(processors, &affinityProcessor{
+					template: t,
+					indexes:  idxs,
+					labels:   labels,
+				})
+			}
+		case "affinity_shrinkage":
+			// Affinity counters are named differently across esxtop
+			// releases, so without a target any affinity/mask column counts.
+			target := strings.TrimSpace(t.Detector.TargetAttribute)
+			readyAttr := "% Ready"
+			hostPCPUs := 0
+			readyByEntity := map[string]int{}
+			for _, c := range cols {
+				if strings.EqualFold(c.Object, "Physical Cpu") && containsAnyFold(c.AttributeLabel, "% Util Time") && !strings.EqualFold(c.Instance, "_Total") {
+					hostPCPUs++
+				}
+				if strings.EqualFold(c.Counter, readyAttr) {
+					readyByEntity[strings.ToLower(c.Object+"|"+c.Instance)] = c.Idx
+				}
+			}
+			var entities []affinityShrinkEntityState
+			for _, c := range cols {
+				if target != "" && !matchesTargetAttribute(c.AttributeLabel, target) {
+					continue
+				}
+				if !containsAnyFold(c.Counter, "affinity", "cpu mask", "cpumask") || containsAnyFold(c.Counter, "exclusive") {
+					continue
+				}
+				if !matchesTemplateFilter(c, t.Detector.Filter) {
+					continue
+				}
+				if excludedByName(c.Instance, t.Detector.ExcludeInstanceContains) || excludedByRegex(c.Instance, t.Detector.ExcludeInstanceRegex) {
+					continue
+				}
+				readyIdx, ok := readyByEntity[strings.ToLower(c.Object+"|"+c.Instance)]
+				if !ok {
+					continue
+				}
+				entities = append(entities, affinityShrinkEntityState{label: c.Instance, attribute: c.AttributeLabel, maskIdx: c.Idx, readyIdx: readyIdx})
+			}
+			if len(entities) == 0 {
+				continue
+			}
+			maxPCPUs := t.Detector.MaxAffinityPCPUs
+			if maxPCPUs <= 0 {
+				maxPCPUs = 4
+			}
+			minReady := t.Detector.Threshold
+			if minReady <= 0 {
+				minReady = 10
+			}
+			minConsecutive := t.Detector.MinConsecutive
+			if minConsecutive <= 0 {
+				minConsecutive = 6
+			}
+			processors = append(processors, &affinityShrinkProcessor{
+				template:       t,
+				entities:       entities,
+				hostPCPUs:      hostPCPUs,
+				maxPCPUs:       maxPCPUs,
+				minReady:       minReady,
+				minConsecutive: minConsecutive,
+			})
+		case "latency_bimodality":
+			// Without a target, device- and guest-side latencies are checked;
+			// kernel and queue time reflect host queueing, not the path.
+			target := strings.TrimSpace(t.Detector.TargetAttribute)
+			var entities []latencyBimodalEntityState
+			for _, c := range cols {
+				if target != "" {
+					if !matchesTargetAttribute(c.AttributeLabel, target) {
+						continue
+					}
+				} else if !containsAnyFold(c.Counter, "millisec") || containsAnyFold(c.Counter, "kernel", "queue") {
+					continue
+				}
+				if !matchesIncludedObject(c.Object, t.Detector.IncludeObjectEquals) || !matchesTemplateFilter(c, t.Detector.Filter) {
+					continue
+				}
+				if excludedByName(c.Instance, t.Detector.ExcludeInstanceContains) || excludedByRegex(c.Instance, t.Detector.ExcludeInstanceRegex) {
+					continue
+				}
+				entities = append(entities, latencyBimodalEntityState{label: c.Instance, attribute: c.AttributeLabel, idx: c.Idx})
+			}
+			if len(entities) == 0 {
+				continue
+			}
+			window := t.Detector.WindowSamples
+			if window <= 0 {
+				window = 60
+			}
+			if window < 8 {
+				window = 8
+			}
+			minD := t.Detector.Threshold
+			if minD <= 0 {
+				minD = 2
+			}
+			minGap := t.Detector.MinGap
+			if minGap <= 0 {
+				minGap = 5
+			}
+			minWindows := t.Detector.MinConsecutive
+			if minWindows <= 0 {
+				minWindows = 1
+			}
+			processors = append(processors, &latencyBimodalProcessor{
+				template:   t,
+				entities:   entities,
+				window:     window,
+				minD:       minD,
+				minGap:     minGap,
+				minWindows: minWindows,
+			})
+		case "clock_skew":
+			gapFactor := t.Detector.MaxGapFactor
+			if gapFactor <= 0 {
+				gapFactor = 3
+			}
+			processors = append(processors, &clockSkewProcessor{template: t, gapFactor: gapFactor, deltas: map[int64]int64{}})
+		case "latency_attribution":
+			// VM side: Virtual Disk read/write latency grouped per VM (the
+			// instance is "vm" or "vm:scsi0:0"). Device side: DAVG.
+			target := strings.TrimSpace(t.Detector.TargetAttribute)
+			byVM := map[string]int{}
+			var vms []latencyAttributionVM
+			var devices []int
+			for _, c := range cols {
+				if excludedByName(c.Instance, t.Detector.ExcludeInstanceContains) || excludedByRegex(c.Instance, t.Detector.ExcludeInstanceRegex) {
+					continue
+				}
+				if !matchesTemplateFilter(c, t.Detector.Filter) {
+					continue
+				}
+				if strings.EqualFold(c.Object, "Physical Disk SCSI Device") && sameAttribute(c.AttributeLabel, "Physical Disk SCSI Device: Average Driver MilliSec/Command") {
+					devices = append(devices, c.Idx)
+					continue
+				}
+				if target != "" {
+					if !matchesTargetAttribute(c.AttributeLabel, target) {
+						continue
+					}
+				} else if !strings.EqualFold(c.Object, "Virtual Disk") || !containsAnyFold(c.Counter, "millisec/read", "millisec/write", "millisec/command") {
+					continue
+				}
+				name := c.Instance
+				if p := strings.Index(name, ":"); p >= 0 {
+					name = name[:p]
+				}
+				i, ok := byVM[strings.ToLower(name)]
+				if !ok {
+					i = len(vms)
+					byVM[strings.ToLower(name)] = i
+					vms = append(vms, latencyAttributionVM{name: name, attribute: c.AttributeLabel})
+				}
+				vms[i].idxs = append(vms[i].idxs, c.Idx)
+			}
+			if len(vms) == 0 {
+				continue
+			}
+			threshold := t.Detector.Threshold
+			if threshold <= 0 {
+				threshold = 20
+			}
+			minConsecutive := t.Detector.MinConsecutive
+			if minConsecutive <= 0 {
+				minConsecutive = 3
+			}
+			processors = append(processors, &latencyAttributionProcessor{
+				template:       t,
+				vms:            vms,
+				deviceIdxs:     devices,
+				threshold:      threshold,
+				minConsecutive: minConsecutive,
+				explainRatio:   0.5,
+			})
+		case "affinity_latency_sensitivity":
+			byGroup := map[string]int{}
+			var groups []affinityLatencyGroup
+			for _, c := range cols {
+				if !strings.EqualFold(c.Object, "Group Cpu") || isSystemGroup(c.Instance) {
+					continue
+				}
+				exclusive := sameAttribute(c.AttributeLabel, "Group Cpu: Exclusive Affinity")
+				if !exclusive && !sameAttribute(c.AttributeLabel, "Group Cpu: Latency Sensitivity") {
+					continue
+				}
+				if excludedByName(c.Instance, t.Detector.ExcludeInstanceContains) || excludedByRegex(c.Instance, t.Detector.ExcludeInstanceRegex) {
+					continue
+				}
+				if !matchesTemplateFilter(c, t.Detector.Filter) {
+					continue
+				}
+				i, ok := byGroup[c.Instance]
+				if !ok {
+					i = len(groups)
+					byGroup[c.Instance] = i
+					groups = append(groups, affinityLatencyGroup{label: c.Instance, exclusiveIdx: -1, latencyIdx: -1})
+				}
+				if exclusive {
+					groups[i].exclusiveIdx = c.Idx
+				} else {
+					groups[i].latencyIdx = c.Idx
+				}
+			}
+			// Both settings are needed to compare them.
+			kept := groups[:0]
+			for _, g := range groups {
+				if g.exclusiveIdx >= 0 && g.latencyIdx >= 0 {
+					kept = append(kept, g)
+				}
+			}
+			if len(kept) > 0 {
+				processors = append(processors, &affinityLatencyProcessor{template: t, groups: kept})
+			}
+		case "numa_span_remote":
+			byVM := map[string]int{}
+			var vms []numaSpanVM
+			for _, c := range cols {
+				if !strings.EqualFold(c.Object, "Group Memory") || isSystemGroup(c.Instance) {
+					continue
+				}
+				home := sameAttribute(c.AttributeLabel, "Group Memory: Numa Home Nodes")
+				if !home && !sameAttribute(c.AttributeLabel, "Group Memory: Numa % Local") {
+					continue
+				}
+				if excludedByName(c.Instance, t.Detector.ExcludeInstanceContains) || excludedByRegex(c.Instance, t.Detector.ExcludeInstanceRegex) {
+					continue
+				}
+				if !matchesTemplateFilter(c, t.Detector.Filter) {
+					continue
+				}
+				i, ok := byVM[c.Instance]
+				if !ok {
+					i = len(vms)
+					byVM[c.Instance] = i
+					vms = append(vms, numaSpanVM{label: c.Instance, homeIdx: -1, localIdx: -1})
+				}
+				if home {
+					vms[i].homeIdx = c.Idx
+				} else {
+					vms[i].localIdx = c.Idx
+				}
+			}
+			kept := vms[:0]
+			for _, v := range vms {
+				if v.homeIdx >= 0 && v.localIdx >= 0 {
+					kept = append(kept, v)
+				}
+			}
+			if len(kept) == 0 {
+				continue
+			}
 			threshold := t.Detector.Threshold
+			if threshold <= 0 {
+				threshold = 50
+			}
 			minConsecutive := t.Detector.MinConsecutive
 			if minConsecutive <= 0 {
 				minConsecutive = 6
 			}
-			if threshold <= 0 {
-				switch t.Detector.Type {
-				case "high_ready":
-					threshold = 5
-				case "high_costop":
-					threshold = 3
-				case "storage_latency":
-					threshold = 20
-				case "low_numa_local":
-					threshold = 85
-				case "memory_overcommit_high":
-					threshold = 100
-				case "network_outbound_drop_high":
-					threshold = 1
-				case "disk_adapter_failed_reads_high":
-					threshold = 5
-				case "disk_adapter_driver_latency_high":
-					threshold = 30
+			processors = append(processors, &numaSpanProcessor{template: t, vms: kept, localThreshold: threshold, minConsecutive: minConsecutive})
+		case "oversized_vcpus":
+			target := t.Detector.TargetAttribute
+			if strings.TrimSpace(target) == "" {
+				target = "Vcpu: % Used"
+			}
+			byVM := map[string]int{}
+			var vms []oversizedVCPUVM
+			for _, c := range cols {
+				if !matchesTargetAttribute(c.AttributeLabel, target) || isSystemGroup(vcpuGroupKey(c.Instance)) {
+					continue
+				}
+				if excludedByName(c.Instance, t.Detector.ExcludeInstanceContains) || excludedByRegex(c.Instance, t.Detector.ExcludeInstanceRegex) {
+					continue
+				}
+				if !matchesTemplateFilter(c, t.Detector.Filter) {
+					continue
 				}
+				key := vcpuGroupKey(c.Instance)
+				i, ok := byVM[key]
+				if !ok {
+					i = len(vms)
+					byVM[key] = i
+					vms = append(vms, oversizedVCPUVM{label: key, attribute: c.AttributeLabel})
+				}
+				vms[i].idxs = append(vms[i].idxs, c.Idx)
+				vms[i].vcpus = append(vms[i].vcpus, c.Instance)
 			}
-			upperThreshold := t.Detector.UpperThreshold
-			comparison := strings.TrimSpace(strings.ToLower(t.Detector.Comparison))
-			switch comparison {
-			case "less":
-				if threshold > 0 {
-					upperBound = threshold
-					hasUpperBound = true
+			minVCPUs := t.Detector.MinVCPUs
+			if minVCPUs <= 0 {
+				minVCPUs = 4
+			}
+			kept := vms[:0]
+			for _, v := range vms {
+				if len(v.idxs) >= minVCPUs {
+					kept = append(kept, v)
 				}
-			case "greater", "":
-				if threshold > 0 {
-					lowerBound = threshold
-					hasLowerBound = true
+			}
+			if len(kept) == 0 {
+				continue
+			}
+			share := t.Detector.Threshold
+			if share <= 0 {
+				share = 50
+			}
+			minSamples := t.Detector.MinConsecutive
+			if minSamples <= 0 {
+				minSamples = 30
+			}
+			processors = append(processors, &oversizedVCPUProcessor{template: t, vms: kept, maxShare: share / 100, minSamples: minSamples})
+		case "periodicity":
+			target := strings.TrimSpace(t.Detector.TargetAttribute)
+			if target == "" {
+				target = "Group Cpu: % Used"
+			}
+			var entities []periodicityEntity
+			for _, c := range cols {
+				if !matchesTargetAttribute(c.AttributeLabel, target) {
+					continue
 				}
-			default:
-				if threshold > 0 {
-					lowerBound = threshold
-					hasLowerBound = true
+				if !matchesIncludedObject(c.Object, t.Detector.IncludeObjectEquals) || !matchesTemplateFilter(c, t.Detector.Filter) {
+					continue
 				}
+				if excludedByName(c.Instance, t.Detector.ExcludeInstanceContains) || excludedByRegex(c.Instance, t.Detector.ExcludeInstanceRegex) {
+					continue
+				}
+				entities = append(entities, periodicityEntity{label: c.Instance, attribute: c.AttributeLabel, idx: c.Idx})
 			}
-			if upperThreshold > 0 {
-				upperBound = upperThreshold
-				hasUpperBound = true
+			if len(entities) == 0 {
+				continue
 			}
-			if hasLowerBound && hasUpperBound && upperBound < lowerBound {
-				lowerBound, upperBound = upperBound, lowerBound
+			window := t.Detector.WindowSamples
+			if window <= 0 {
+				window = 4096
 			}
-
+			if window < 64 {
+				window = 64
+			}
+			minCorrelation := t.Detector.Threshold
+			if minCorrelation <= 0 {
+				minCorrelation = 0.5
+			}
+			minSpike := t.Detector.MinGap
+			if minSpike <= 0 {
+				minSpike = 4
+			}
+			base := sampleInterval
+			if base <= 0 {
+				base = 5 * time.Second
+			}
+			processors = append(processors, &periodicityProcessor{
+				template:       t,
+				entities:       entities,
+				window:         window,
+				minCorrelation: minCorrelation,
+				minSpike:       minSpike,
+				base:           base,
+			})
+		case "swap_wait":
+			target := strings.TrimSpace(t.Detector.TargetAttribute)
+			if target == "" {
+				target = "Group Cpu: % Swap Wait"
+			}
+			p := &swapWaitProcessor{template: t}
 			for _, c := range cols {
-				l := strings.ToLower(c.AttributeLabel)
-				match := false
-				switch t.Detector.Type {
-				case "threshold_sustained":
-					match = true
-					reportKey = "other"
-				case "high_ready":
-					match = strings.Contains(l, "% ready")
-					reportKey = "cpu"
-				case "high_costop":
-					match = strings.Contains(l, "% costop")
-					reportKey = "cpu"
-				case "storage_latency":
-					match = strings.Contains(l, "latency")
-					reportKey = "storage"
-				case "low_numa_local":
-					match = strings.Contains(l, "group memory: numa % local")
-					reportKey = "numa"
-				case "memory_overcommit_high":
-					match = strings.Contains(l, "memory: memory overcommit (1 minute avg)")
-					reportKey = "memory"
-				case "network_outbound_drop_high":
-					match = strings.Contains(l, "network port: % outbound packets dropped")
-					reportKey = "network"
-				case "disk_adapter_failed_reads_high":
-					match = strings.Contains(l, "failed reads/sec")
-					reportKey = "storage"
-				case "disk_adapter_driver_latency_high":
-					match = strings.Contains(l, "average driver millisec/command")
-					reportKey = "storage"
+				if strings.EqualFold(c.Object, "Memory") {
+					counter := strings.ToLower(c.Counter)
+					switch {
+					case strings.Contains(counter, "swap") && strings.Contains(counter, "read") && strings.Contains(counter, "/sec"):
+						p.hostReadIdx = append(p.hostReadIdx, c.Idx)
+					case strings.Contains(counter, "swap") && strings.Contains(counter, "writ") && strings.Contains(counter, "/sec"):
+						p.hostWriteIdx = append(p.hostWriteIdx, c.Idx)
+					case sameAttribute(c.AttributeLabel, "Memory: Swap Used MBytes"):
+						p.hostUsedIdx = append(p.hostUsedIdx, c.Idx)
+					}
+					continue
 				}
-				if !match {
+				if !matchesTargetAttribute(c.AttributeLabel, target) || isSystemGroup(c.Instance) {
 					continue
 				}
-				if !matchesTargetAttribute(c.AttributeLabel, t.Detector.TargetAttribute) {
+				if !matchesIncludedObject(c.Object, t.Detector.IncludeObjectEquals) || !matchesTemplateFilter(c, t.Detector.Filter) {
+					continue
+				}
+				if excludedByName(c.Instance, t.Detector.ExcludeInstanceContains) || excludedByRegex(c.Instance, t.Detector.ExcludeInstanceRegex) {
+					continue
+				}
+				p.vms = append(p.vms, swapWaitVM{label: c.Instance, idx: c.Idx})
+			}
+			if len(p.vms) == 0 {
+				continue
+			}
+			p.threshold = t.Detector.Threshold
+			if p.threshold <= 0 {
+				p.threshold = 1
+			}
+			p.minConsecutive = t.Detector.MinConsecutive
+			if p.minConsecutive <= 0 {
+				p.minConsecutive = 6
+			}
+			processors = append(processors, p)
+		case "ready_io_correlation":
+			// Group Cpu instances are "id:vm", Virtual Disk ones "vm" or
+			// "vm:scsi0:0"; they pair up on the VM name.
+			byVM := map[string]int{}
+			var vms []readyIOVM
+			vmFor := func(name string) *readyIOVM {
+				key := strings.ToLower(name)
+				i, ok := byVM[key]
+				if !ok {
+					i = len(vms)
+					byVM[key] = i
+					vms = append(vms, readyIOVM{name: name, readyIdx: -1})
+				}
+				return &vms[i]
+			}
+			for _, c := range cols {
+				if excludedByName(c.Instance, t.Detector.ExcludeInstanceContains) || excludedByRegex(c.Instance, t.Detector.ExcludeInstanceRegex) {
+					continue
+				}
+				if !matchesTemplateFilter(c, t.Detector.Filter) {
+					continue
+				}
+				switch {
+				case strings.EqualFold(c.Object, "Group Cpu") && sameAttribute(c.AttributeLabel, "Group Cpu: % Ready"):
+					if isSystemGroup(c.Instance) {
+						continue
+					}
+					vmFor(vmDisplayName(c.Instance)).readyIdx = c.Idx
+				case strings.EqualFold(c.Object, "Virtual Disk") && containsAnyFold(c.Counter, "millisec/read", "millisec/write", "millisec/command"):
+					name := c.Instance
+					if p := strings.Index(name, ":"); p >= 0 {
+						name = name[:p]
+					}
+					v := vmFor(name)
+					v.latIdxs = append(v.latIdxs, c.Idx)
+				}
+			}
+			window := t.Detector.WindowSamples
+			if window <= 0 {
+				window = 12
+			}
+			if window < 4 {
+				window = 4
+			}
+			var kept []readyIOVM
+			for _, v := range vms {
+				if v.readyIdx >= 0 && len(v.latIdxs) > 0 {
+					v.ready, v.lat = make([]float64, window), make([]float64, window)
+					kept = append(kept, v)
+				}
+			}
+			if len(kept) == 0 {
+				continue
+			}
+			p := &readyIOProcessor{
+				template:       t,
+				vms:            kept,
+				readyThreshold: t.Detector.Threshold,
+				latThreshold:   t.Detector.HighThreshold,
+				minCorrelation: t.Detector.LowThreshold,
+				window:         window,
+				minConsecutive: t.Detector.MinConsecutive,
+			}
+			if p.readyThreshold <= 0 {
+				p.readyThreshold = 5
+			}
+			if p.latThreshold <= 0 {
+				p.latThreshold = 20
+			}
+			if p.minCorrelation <= 0 {
+				p.minCorrelation = 0.6
+			}
+			if p.minConsecutive <= 0 {
+				p.minConsecutive = 3
+			}
+			processors = append(processors, p)
+		case "cstate_latency":
+			byPCPU := map[string]int{}
+			var pcpus [][]int
+			var vms []cstateLatencyVM
+			deepest := 0
+			for _, c := range cols {
+				if excludedByName(c.Instance, t.Detector.ExcludeInstanceContains) || excludedByRegex(c.Instance, t.Detector.ExcludeInstanceRegex) {
+					continue
+				}
+				if !matchesTemplateFilter(c, t.Detector.Filter) {
+					continue
+				}
+				if n, ok := deepCState(c); ok {
+					i, seen := byPCPU[c.Instance]
+					if !seen {
+						i = len(pcpus)
+						byPCPU[c.Instance] = i
+						pcpus = append(pcpus, nil)
+					}
+					pcpus[i] = append(pcpus[i], c.Idx)
+					deepest = max(deepest, n)
+					continue
+				}
+				if strings.EqualFold(c.Object, "Group Cpu") && sameAttribute(c.AttributeLabel, "Group Cpu: Latency Sensitivity") && !isSystemGroup(c.Instance) {
+					vms = append(vms, cstateLatencyVM{label: c.Instance, latencyIdx: c.Idx})
+				}
+			}
+			// Without both sides there is nothing to relate.
+			if len(pcpus) == 0 || len(vms) == 0 {
+				continue
+			}
+			p := &cstateLatencyProcessor{
+				template:       t,
+				pcpus:          pcpus,
+				deepest:        deepest,
+				vms:            vms,
+				threshold:      t.Detector.Threshold,
+				minConsecutive: t.Detector.MinConsecutive,
+			}
+			if p.threshold <= 0 {
+				p.threshold = 50
+			}
+			if p.minConsecutive <= 0 {
+				p.minConsecutive = 6
+			}
+			processors = append(processors, p)
+		case "numa_free_imbalance":
+			byNode := map[string]int{}
+			var nodes []numaFreeNode
+			byVM := map[string]int{}
+			var vms []numaFreeVM
+			vmFor := func(label string) *numaFreeVM {
+				i, ok := byVM[label]
+				if !ok {
+					i = len(vms)
+					byVM[label] = i
+					vms = append(vms, numaFreeVM{label: label, homeIdx: -1})
+				}
+				return &vms[i]
+			}
+			for _, c := range cols {
+				if excludedByName(c.Instance, t.Detector.ExcludeInstanceContains) || excludedByRegex(c.Instance, t.Detector.ExcludeInstanceRegex) {
+					continue
+				}
+				if !matchesTemplateFilter(c, t.Detector.Filter) {
+					continue
+				}
+				if strings.EqualFold(c.Object, "Numa Node") {
+					free := strings.EqualFold(c.Counter, "Free MBytes")
+					if !free && !strings.EqualFold(c.Counter, "Total MBytes") {
+						continue
+					}
+					i, ok := byNode[c.Instance]
+					if !ok {
+						i = len(nodes)
+						byNode[c.Instance] = i
+						nodes = append(nodes, numaFreeNode{label: c.Instance, freeIdx: -1, totalIdx: -1})
+					}
+					if free {
+						nodes[i].freeIdx = c.Idx
+					} else {
+						nodes[i].totalIdx = c.Idx
+					}
+					continue
+				}
+				if !strings.EqualFold(c.Object, "Group Memory") || isSystemGroup(c.Instance) {
+					continue
+				}
+				if sameAttribute(c.AttributeLabel, "Group Memory: Numa Home Nodes") {
+					vmFor(c.Instance).homeIdx = c.Idx
+				} else if stage := memoryReclaimStage(c); stage == "balloon" || stage == "swap" {
+					v := vmFor(c.Instance)
+					v.reclaimIdx = append(v.reclaimIdx, c.Idx)
+				}
+			}
+			keptNodes := nodes[:0]
+			for _, n := range nodes {
+				if n.freeIdx >= 0 {
+					keptNodes = append(keptNodes, n)
+				}
+			}
+			keptVMs := vms[:0]
+			for _, v := range vms {
+				if v.homeIdx >= 0 && len(v.reclaimIdx) > 0 {
+					keptVMs = append(keptVMs, v)
+				}
+			}
+			if len(keptNodes) < 2 || len(keptVMs) == 0 {
+				continue
+			}
+			p := &numaFreeImbalanceProcessor{
+				template:       t,
+				nodes:          keptNodes,
+				vms:            keptVMs,
+				minGap:         t.Detector.MinGap,
+				minConsecutive: t.Detector.MinConsecutive,
+			}
+			if p.minGap <= 0 {
+				p.minGap = 30
+			}
+			if p.minConsecutive <= 0 {
+				p.minConsecutive = 6
+			}
+			processors = append(processors, p)
+		case "latency_sensitive_contention":
+			byVM := map[string]int{}
+			var vms []latencySensitiveVM
+			for _, c := range cols {
+				if !strings.EqualFold(c.Object, "Group Cpu") || isSystemGroup(c.Instance) {
+					continue
+				}
+				if excludedByName(c.Instance, t.Detector.ExcludeInstanceContains) || excludedByRegex(c.Instance, t.Detector.ExcludeInstanceRegex) {
+					continue
+				}
+				if !matchesTemplateFilter(c, t.Detector.Filter) {
+					continue
+				}
+				i, ok := byVM[c.Instance]
+				if !ok {
+					i = len(vms)
+					byVM[c.Instance] = i
+					vms = append(vms, latencySensitiveVM{label: c.Instance, latencyIdx: -1, readyIdx: -1, usedIdx: -1})
+				}
+				var field *int
+				switch {
+				case sameAttribute(c.AttributeLabel, "Group Cpu: Latency Sensitivity"):
+					field = &vms[i].latencyIdx
+				case sameAttribute(c.AttributeLabel, "Group Cpu: % Ready"):
+					field = &vms[i].readyIdx
+				case sameAttribute(c.AttributeLabel, "Group Cpu: % Used"):
+					field = &vms[i].usedIdx
+				}
+				if field != nil {
+					*field = c.Idx
+				}
+			}
+			// A VM needs its setting and %RDY to be checked, and %USED to
+			// count as someone else's neighbor.
+			candidates := 0
+			for _, v := range vms {
+				if v.latencyIdx >= 0 && v.readyIdx >= 0 {
+					candidates++
+				}
+			}
+			if candidates == 0 {
+				continue
+			}
+			p := &latencySensitiveContentionProcessor{
+				template:       t,
+				vms:            vms,
+				readyThreshold: t.Detector.Threshold,
+				heavyUsed:      t.Detector.HighThreshold,
+				minConsecutive: t.Detector.MinConsecutive,
+			}
+			if p.heavyUsed <= 0 {
+				p.heavyUsed = 80
+			}
+			if p.minConsecutive <= 0 {
+				p.minConsecutive = 2
+			}
+			processors = append(processors, p)
+		case "io_knee":
+			// Per SCSI device: ACTV and QUED for the outstanding IO, GAVG
+			// (what the guest waits, queueing included) for the latency,
+			// DAVG when GAVG is missing.
+			byDevice := map[string]int{}
+			var devices []ioKneeDevice
+			var davg []int
+			for _, c := range cols {
+				if !strings.EqualFold(c.Object, "Physical Disk SCSI Device") {
+					continue
+				}
+				if excludedByName(c.Instance, t.Detector.ExcludeInstanceContains) || excludedByRegex(c.Instance, t.Detector.ExcludeInstanceRegex) {
+					continue
+				}
+				if !matchesTemplateFilter(c, t.Detector.Filter) {
+					continue
+				}
+				i, ok := byDevice[c.Instance]
+				if !ok {
+					i = len(devices)
+					byDevice[c.Instance] = i
+					devices = append(devices, ioKneeDevice{label: c.Instance, actvIdx: -1, quedIdx: -1, latIdx: -1})
+					davg = append(davg, -1)
+				}
+				switch {
+				case sameAttribute(c.AttributeLabel, "Physical Disk SCSI Device: Active Commands"):
+					devices[i].actvIdx = c.Idx
+				case sameAttribute(c.AttributeLabel, "Physical Disk SCSI Device: Queued Commands"):
+					devices[i].quedIdx = c.Idx
+				case sameAttribute(c.AttributeLabel, "Physical Disk SCSI Device: Average Guest MilliSec/Command"):
+					devices[i].latIdx, devices[i].attribute = c.Idx, c.AttributeLabel
+				case sameAttribute(c.AttributeLabel, "Physical Disk SCSI Device: Average Driver MilliSec/Command"):
+					davg[i] = c.Idx
+				}
+			}
+			usable := devices[:0]
+			for i, d := range devices {
+				if d.latIdx < 0 && davg[i] >= 0 {
+					d.latIdx, d.attribute = davg[i], "Physical Disk SCSI Device: Average Driver MilliSec/Command"
+				}
+				if d.actvIdx >= 0 && d.latIdx >= 0 {
+					usable = append(usable, d)
+				}
+			}
+			if len(usable) == 0 {
+				continue
+			}
+			p := &ioKneeProcessor{
+				template:    t,
+				devices:     usable,
+				minLatency:  t.Detector.Threshold,
+				factor:      t.Detector.HighThreshold,
+				minSamples:  t.Detector.MinConsecutive,
+				minBinCount: 3,
+			}
+			if p.minLatency <= 0 {
+				p.minLatency = 10
+			}
+			if p.factor <= 1 {
+				p.factor = 2
+			}
+			if p.minSamples <= 0 {
+				p.minSamples = 6
+			}
+			processors = append(processors, p)
+		case "wakeup_storm":
+			// Per vCPU when the capture has Vcpu counters, per VM (Group
+			// Cpu) otherwise; %WAIT is optional.
+			build := func(object string) []wakeupStormWorld {
+				byWorld := map[string]int{}
+				var worlds []wakeupStormWorld
+				for _, c := range cols {
+					if !strings.EqualFold(c.Object, object) {
+						continue
+					}
+					if excludedByName(c.Instance, t.Detector.ExcludeInstanceContains) || excludedByRegex(c.Instance, t.Detector.ExcludeInstanceRegex) {
+						continue
+					}
+					if !matchesTemplateFilter(c, t.Detector.Filter) {
+						continue
+					}
+					isRun := sameAttribute(c.AttributeLabel, object+": % Run")
+					if !isRun && !sameAttribute(c.AttributeLabel, object+": % Wait") {
+						continue
+					}
+					i, ok := byWorld[c.Instance]
+					if !ok {
+						i = len(worlds)
+						byWorld[c.Instance] = i
+						worlds = append(worlds, wakeupStormWorld{label: c.Instance, runIdx: -1, waitIdx: -1})
+					}
+					if isRun {
+						worlds[i].runIdx = c.Idx
+					} else {
+						worlds[i].waitIdx = c.Idx
+					}
+				}
+				usable := worlds[:0]
+				for _, w := range worlds {
+					if w.runIdx >= 0 {
+						usable = append(usable, w)
+					}
+				}
+				return usable
+			}
+			object := "Vcpu"
+			worlds := build(object)
+			if len(worlds) == 0 {
+				object = "Group Cpu"
+				worlds = build(object)
+			}
+			if len(worlds) == 0 {
+				continue
+			}
+			p := &wakeupStormProcessor{
+				template:       t,
+				worlds:         worlds,
+				attribute:      object + ": % Run",
+				threshold:      t.Detector.Threshold,
+				minConsecutive: t.Detector.MinConsecutive,
+			}
+			if p.threshold <= 0 {
+				p.threshold = 25
+			}
+			if p.minConsecutive <= 0 {
+				p.minConsecutive = 6
+			}
+			processors = append(processors, p)
+		case "memsched_limit":
+			// Per Group Memory instance: the limit, the target and granted
+			// sizes it is held to, touched memory and the reclaimed sizes.
+			byGroup := map[string]int{}
+			var groups []memschedLimitGroup
+			var hostFree []int
+			for _, c := range cols {
+				if strings.EqualFold(c.Object, "Memory") && strings.EqualFold(c.Counter, "Free MBytes") {
+					hostFree = append(hostFree, c.Idx)
+					continue
+				}
+				if !strings.EqualFold(c.Object, "Group Memory") || isSystemGroup(c.Instance) {
+					continue
+				}
+				if excludedByName(c.Instance, t.Detector.ExcludeInstanceContains) || excludedByRegex(c.Instance, t.Detector.ExcludeInstanceRegex) {
 					continue
 				}
 				if !matchesTemplateFilter(c, t.Detector.Filter) {
 					continue
 				}
-				if !matchesIncludedAttribute(c.AttributeLabel, t.Detector.IncludeAttributeEquals) {
+				i, ok := byGroup[c.Instance]
+				if !ok {
+					i = len(groups)
+					byGroup[c.Instance] = i
+					groups = append(groups, memschedLimitGroup{label: c.Instance, limitIdx: -1, touchedIdx: -1})
+				}
+				g := &groups[i]
+				counter := strings.ToLower(c.Counter)
+				switch {
+				case strings.Contains(counter, "/sec"):
+				case strings.Contains(counter, "limit"):
+					g.limitIdx = c.Idx
+				case strings.Contains(counter, "touched"):
+					g.touchedIdx = c.Idx
+				case strings.Contains(counter, "granted"),
+					strings.Contains(counter, "target") && !containsAnyFold(counter, "swap", "memctl", "zip"):
+					g.heldIdx = append(g.heldIdx, c.Idx)
+				case memoryReclaimStage(c) != "":
+					g.reclaimIdx = append(g.reclaimIdx, c.Idx)
+				}
+			}
+			usable := groups[:0]
+			for _, g := range groups {
+				if g.limitIdx >= 0 && len(g.heldIdx) > 0 && len(g.reclaimIdx) > 0 {
+					usable = append(usable, g)
+				}
+			}
+			if len(usable) == 0 {
+				continue
+			}
+			p := &memschedLimitProcessor{
+				template:       t,
+				groups:         usable,
+				hostFreeIdx:    hostFree,
+				atLimit:        t.Detector.Threshold,
+				minConsecutive: t.Detector.MinConsecutive,
+			}
+			if p.atLimit <= 0 {
+				p.atLimit = 95
+			}
+			if p.minConsecutive <= 0 {
+				p.minConsecutive = 6
+			}
+			processors = append(processors, p)
+		case "ip_storage_nic":
+			// Storage paths are NFS objects and adapters or devices whose
+			// instance names iSCSI/NFS or matches include_instance_regex
+			// (software iSCSI adapters are plain vmhbaNN). Uplinks are
+			// Network Port instances naming a vmnic, merged per vmnic.
+			byPath := map[string]int{}
+			byUplink := map[string]int{}
+			var paths []ipStoragePath
+			var uplinks []ipStorageUplink
+			for _, c := range cols {
+				if excludedByName(c.Instance, t.Detector.ExcludeInstanceContains) || excludedByRegex(c.Instance, t.Detector.ExcludeInstanceRegex) {
 					continue
 				}
-				if !matchesIncludedObject(c.Object, t.Detector.IncludeObjectEquals) {
+				if strings.EqualFold(c.Object, "Network Port") {
+					nic := strings.ToLower(vmnicNamePattern.FindString(c.Instance))
+					if nic == "" {
+						continue
+					}
+					i, ok := byUplink[nic]
+					if !ok {
+						i = len(uplinks)
+						byUplink[nic] = i
+						uplinks = append(uplinks, ipStorageUplink{label: nic, speedIdx: -1})
+					}
+					u := &uplinks[i]
+					switch {
+					case containsAnyFold(c.Counter, "dropped"):
+						u.dropIdxs = append(u.dropIdxs, c.Idx)
+					case containsAnyFold(c.Counter, "speed"):
+						u.speedIdx = c.Idx
+					case containsAnyFold(c.Counter, "mbits transmitted"):
+						u.txIdxs = append(u.txIdxs, c.Idx)
+					case containsAnyFold(c.Counter, "mbits received"):
+						u.rxIdxs = append(u.rxIdxs, c.Idx)
+					}
 					continue
 				}
-				if excludedByName(c.Instance, t.Detector.ExcludeInstanceContains) {
+				if !containsAnyFold(c.Counter, "millisec/command", "millisec/read", "millisec/write") {
 					continue
 				}
-				if excludedByRegex(c.Instance, t.Detector.ExcludeInstanceRegex) {
+				ip := containsAnyFold(c.Object, "nfs") || containsAnyFold(c.Instance, "iscsi", "nfs") || includedByRegex(c.Instance, t.Detector.IncludeInstanceRegex)
+				if !ip || !matchesTemplateFilter(c, t.Detector.Filter) {
 					continue
 				}
-				idxs = append(idxs, c.Idx)
-				labels = append(labels, c.Instance)
-				if attribute == "" {
-					attribute = c.AttributeLabel
+				key := c.Object + "\x00" + c.Instance
+				i, ok := byPath[key]
+				if !ok {
+					i = len(paths)
+					byPath[key] = i
+					paths = append(paths, ipStoragePath{label: c.Instance, attribute: c.AttributeLabel})
 				}
+				paths[i].latIdxs = append(paths[i].latIdxs, c.Idx)
 			}
-			if len(idxs) > 0 {
-				if reportKey == "other" && attribute != "" {
-					reportKey = inferReportKeyFromAttribute(attribute)
+			window := t.Detector.WindowSamples
+			if window <= 0 {
+				window = 12
+			}
+			window = max(window, 4)
+			keptUplinks := uplinks[:0]
+			for _, u := range uplinks {
+				if len(u.txIdxs)+len(u.rxIdxs) > 0 {
+					u.load = make([]float64, window)
+					keptUplinks = append(keptUplinks, u)
 				}
-				processors = append(processors, &thresholdProcessor{
-					template:       t,
-					reportKey:      reportKey,
-					attributeLabel: attribute,
-					indexes:        idxs,
-					labels:         labels,
-					lowerBound:     lowerBound,
-					upperBound:     upperBound,
-					hasLowerBound:  hasLowerBound,
-					hasUpperBound:  hasUpperBound,
-					minConsecutive: minConsecutive,
-					states:         make([]thresholdEntityState, len(idxs)),
-				})
 			}
-		case "numa_zigzag", "zigzag_switch":
-			var idxs []int
-			var labels []string
+			if len(paths) == 0 || len(keptUplinks) == 0 {
+				continue
+			}
+			p := &ipStorageNICProcessor{
+				template:       t,
+				paths:          paths,
+				uplinks:        keptUplinks,
+				pairs:          make([][]ipStoragePair, len(paths)),
+				latThreshold:   t.Detector.HighThreshold,
+				dropThreshold:  t.Detector.Threshold,
+				utilThreshold:  t.Detector.UpperThreshold,
+				minCorrelation: t.Detector.LowThreshold,
+				window:         window,
+				minConsecutive: t.Detector.MinConsecutive,
+			}
+			for i := range p.paths {
+				p.paths[i].lat = make([]float64, window)
+				p.pairs[i] = make([]ipStoragePair, len(keptUplinks))
+			}
+			if p.latThreshold <= 0 {
+				p.latThreshold = 20
+			}
+			if p.dropThreshold <= 0 {
+				p.dropThreshold = 0.1
+			}
+			if p.utilThreshold <= 0 {
+				p.utilThreshold = 80
+			}
+			if p.minCorrelation <= 0 {
+				p.minCorrelation = 0.6
+			}
+			if p.minConsecutive <= 0 {
+				p.minConsecutive = 3
+			}
+			processors = append(processors, p)
+		case "pcpu_imbalance":
+			// PCPUs are Physical Cpu instances of target_attribute (% Util
+			// Time by default; % Core Util Time also works); ready time comes
+			// from VM Group Cpu % Ready, system groups excluded.
+			target := strings.TrimSpace(t.Detector.TargetAttribute)
+			if target == "" {
+				target = "Physical Cpu: % Util Time"
+			}
+			p := &pcpuImbalanceProcessor{
+				template:       t,
+				attribute:      target,
+				busyThreshold:  t.Detector.HighThreshold,
+				idleThreshold:  t.Detector.LowThreshold,
+				minReady:       t.Detector.Threshold,
+				minConsecutive: t.Detector.MinConsecutive,
+			}
 			for _, c := range cols {
-				if !matchesTargetAttribute(c.AttributeLabel, t.Detector.TargetAttribute) {
+				if excludedByName(c.Instance, t.Detector.ExcludeInstanceContains) || excludedByRegex(c.Instance, t.Detector.ExcludeInstanceRegex) {
 					continue
 				}
-				if len(t.Detector.Filter.Conditions) > 0 {
-					if !matchesTemplateFilter(c, t.Detector.Filter) {
+				switch {
+				case strings.EqualFold(c.Object, "Physical Cpu") && matchesTargetAttribute(c.AttributeLabel, target):
+					if strings.EqualFold(c.Instance, "_Total") || !matchesTemplateFilter(c, t.Detector.Filter) {
 						continue
 					}
-				} else if !(containsAnyFold(c.AttributeLabel, "numa") && containsAnyFold(c.AttributeLabel, "load", "% used", "% processor time")) {
+					p.pcpuLabels = append(p.pcpuLabels, "PCPU "+c.Instance)
+					p.pcpuIdxs = append(p.pcpuIdxs, c.Idx)
+				case strings.EqualFold(c.Object, "Group Cpu") && sameAttribute(c.AttributeLabel, "Group Cpu: % Ready"):
+					if isSystemGroup(c.Instance) {
+						continue
+					}
+					p.groupLabels = append(p.groupLabels, vmDisplayName(c.Instance))
+					p.readyIdxs = append(p.readyIdxs, c.Idx)
+				}
+			}
+			if len(p.pcpuIdxs) < 2 || len(p.readyIdxs) == 0 {
+				continue
+			}
+			if p.busyThreshold <= 0 {
+				p.busyThreshold = 90
+			}
+			if p.idleThreshold <= 0 {
+				p.idleThreshold = 10
+			}
+			if p.minReady <= 0 {
+				p.minReady = 1
+			}
+			if p.minConsecutive <= 0 {
+				p.minConsecutive = 6
+			}
+			processors = append(processors, p)
+		case "memory_reclaim_order":
+			// Host-level Memory columns win; per-VM Group Memory columns are
+			// summed only for stages the host doesn't report.
+			hostCols := make([][]int, len(memoryReclaimStages))
+			groupCols := make([][]int, len(memoryReclaimStages))
+			for _, c := range cols {
+				stage := memoryReclaimStage(c)
+				if stage == "" {
 					continue
 				}
-				idxs = append(idxs, c.Idx)
-				labels = append(labels, c.AttributeLabel)
+				if !matchesTemplateFilter(c, t.Detector.Filter) {
+					continue
+				}
+				if excludedByName(c.Instance, t.Detector.ExcludeInstanceContains) || excludedByRegex(c.Instance, t.Detector.ExcludeInstanceRegex) {
+					continue
+				}
+				si := stageRank(stage)
+				if strings.EqualFold(c.Object, "Memory") {
+					hostCols[si] = append(hostCols[si], c.Idx)
+				} else {
+					groupCols[si] = append(groupCols[si], c.Idx)
+				}
 			}
-			if len(idxs) >= 2 {
-				minSwitches := t.Detector.MinSwitches
-				if minSwitches <= 0 {
-					minSwitches = 6
+			stages := make([]memoryReclaimStageState, len(memoryReclaimStages))
+			found := false
+			for si := range stages {
+				stages[si].indexes = hostCols[si]
+				if len(stages[si].indexes) == 0 {
+					stages[si].indexes = groupCols[si]
 				}
-				minGap := t.Detector.MinGap
-				if minGap <= 0 {
-					minGap = 3.0
+				if len(stages[si].indexes) > 0 {
+					found = true
 				}
-				processors = append(processors, &numaZigzagProcessor{
-					template:     t,
-					indexes:      idxs,
-					labels:       labels,
-					minSwitches:  minSwitches,
-					minGap:       minGap,
-					prevDominant: -1,
+			}
+			if found {
+				minDelta := t.Detector.Threshold
+				if minDelta <= 0 {
+					minDelta = 16
+				}
+				processors = append(processors, &memoryReclaimProcessor{
+					template: t,
+					stages:   stages,
+					minDelta: minDelta,
 				})
 			}
-		case "value_switch":
-			var idxs []int
-			var labels []string
-			attrLabel := strings.TrimSpace(t.Detector.TargetAttribute)
-			if attrLabel == "" {
-				break
+		case "vmotion_stun":
+			var columns []vmotionColumn
+			var states []vmotionEntityState
+			entities := map[string]int{}
+			for _, c := range cols {
+				kind := ""
+				switch {
+				case sameAttribute(c.AttributeLabel, "Group Cpu: % Used"):
+					kind = "presence"
+				case sameAttribute(c.AttributeLabel, "Group Cpu: % Wait"):
+					kind = "wait"
+				case containsAnyFold(canonicalAttributeLabel(c.AttributeLabel), "numa home node"):
+					kind = "home"
+				}
+				if kind == "" {
+					continue
+				}
+				if !matchesTemplateFilter(c, t.Detector.Filter) {
+					continue
+				}
+				if excludedByName(c.Instance, t.Detector.ExcludeInstanceContains) || excludedByRegex(c.Instance, t.Detector.ExcludeInstanceRegex) {
+					continue
+				}
+				entity, ok := entities[c.Instance]
+				if !ok {
+					entity = len(states)
+					entities[c.Instance] = entity
+					states = append(states, vmotionEntityState{label: c.Instance})
+				}
+				columns = append(columns, vmotionColumn{idx: c.Idx, entity: entity, kind: kind})
+			}
+			if len(columns) > 0 {
+				waitJump := t.Detector.MinGap
+				if waitJump <= 0 {
+					waitJump = 50
+				}
+				window := t.Detector.MinConsecutive
+				if window <= 0 {
+					window = 6
+				}
+				minKinds := t.Detector.MinSwitches
+				if minKinds <= 0 {
+					minKinds = 2
+				}
+				processors = append(processors, &vmotionStunProcessor{
+					template: t,
+					columns:  columns,
+					states:   states,
+					waitJump: waitJump,
+					window:   int64(window),
+					minKinds: minKinds,
+				})
 			}
+		case "nic_queue_imbalance":
+			var groups []queueGroupState
+			groupIdx := map[string]int{}
+			attribute := ""
 			for _, c := range cols {
-				if !matchesTargetAttribute(c.AttributeLabel, attrLabel) {
+				if !matchesTargetAttribute(c.AttributeLabel, t.Detector.TargetAttribute) {
 					continue
 				}
-				if len(t.Detector.Filter.Conditions) > 0 && !matchesTemplateFilter(c, t.Detector.Filter) {
+				if strings.TrimSpace(t.Detector.TargetAttribute) == "" && !(containsAnyFold(c.AttributeLabel, "queue") && containsAnyFold(c.Counter, "packets")) {
 					continue
 				}
-				idxs = append(idxs, c.Idx)
-				labels = append(labels, c.Instance)
+				if !matchesTemplateFilter(c, t.Detector.Filter) {
+					continue
+				}
+				if excludedByName(c.Instance, t.Detector.ExcludeInstanceContains) || excludedByRegex(c.Instance, t.Detector.ExcludeInstanceRegex) {
+					continue
+				}
+				key := nicQueueGroup(c)
+				gi, ok := groupIdx[key]
+				if !ok {
+					gi = len(groups)
+					groupIdx[key] = gi
+					groups = append(groups, queueGroupState{label: key})
+				}
+				groups[gi].indexes = append(groups[gi].indexes, c.Idx)
+				groups[gi].queues = append(groups[gi].queues, c.Instance)
+				if attribute == "" {
+					attribute = c.AttributeLabel
+				}
 			}
-			if len(idxs) > 0 {
-				minSwitches := t.Detector.MinSwitches
-				if minSwitches <= 0 {
-					minSwitches = 6
+			kept := groups[:0]
+			for _, g := range groups {
+				if len(g.indexes) >= 2 {
+					kept = append(kept, g)
 				}
-				processors = append(processors, &valueSwitchProcessor{
+			}
+			if len(kept) > 0 {
+				minTotal := t.Detector.Threshold
+				if minTotal <= 0 {
+					minTotal = 1000
+				}
+				high := t.Detector.HighThreshold
+				if high <= 0 {
+					high = 80
+				}
+				low := t.Detector.LowThreshold
+				if low <= 0 {
+					low = 5
+				}
+				minConsecutive := t.Detector.MinConsecutive
+				if minConsecutive <= 0 {
+					minConsecutive = 6
+				}
+				processors = append(processors, &queueImbalanceProcessor{
 					template:       t,
-					reportKey:      inferReportKeyFromAttribute(attrLabel),
-					attributeLabel: attrLabel,
-					indexes:        idxs,
-					labels:         labels,
-					minSwitches:    minSwitches,
-					states:         make([]valueSwitchEntityState, len(idxs)),
+					attributeLabel: attribute,
+					groups:         kept,
+					minTotal:       minTotal,
+					highShare:      high,
+					lowShare:       low,
+					minConsecutive: minConsecutive,
 				})
 			}
-		case "exclusive_affinity":
-			var idxs []int
-			var labels []string
+		case "vcpu_ready_spread":
+			var groups []readySpreadGroupState
+			groupIdx := map[string]int{}
+			attribute := ""
+			target := t.Detector.TargetAttribute
+			if strings.TrimSpace(target) == "" {
+				target = "Vcpu: % Ready"
+			}
 			for _, c := range cols {
-				if !matchesTargetAttribute(c.AttributeLabel, t.Detector.TargetAttribute) {
+				if !matchesTargetAttribute(c.AttributeLabel, target) {
 					continue
 				}
 				if !matchesTemplateFilter(c, t.Detector.Filter) {
 					continue
 				}
-				if containsAnyFold(c.AttributeLabel, "exclusive affinity") {
-					idxs = append(idxs, c.Idx)
-					labels = append(labels, c.Instance)
+				if excludedByName(c.Instance, t.Detector.ExcludeInstanceContains) || excludedByRegex(c.Instance, t.Detector.ExcludeInstanceRegex) {
+					continue
+				}
+				key := vcpuGroupKey(c.Instance)
+				gi, ok := groupIdx[key]
+				if !ok {
+					gi = len(groups)
+					groupIdx[key] = gi
+					groups = append(groups, readySpreadGroupState{label: key})
+				}
+				groups[gi].indexes = append(groups[gi].indexes, c.Idx)
+				groups[gi].vcpus = append(groups[gi].vcpus, c.Instance)
+				if attribute == "" {
+					attribute = c.AttributeLabel
 				}
 			}
-			if len(idxs) > 0 {
-				processors = append(processors, &affinityProcessor{
-					template:  t,
-					indexes:   idxs,
-					labels:    labels,
-					hitCounts: make([]int, len(idxs)),
-					firstSeen: make([]time.Time, len(idxs)),
-					lastSeen:  make([]time.Time, len(idxs)),
+			kept := groups[:0]
+			for _, g := range groups {
+				if len(g.indexes) >= 2 {
+					kept = append(kept, g)
+				}
+			}
+			if len(kept) > 0 {
+				minReady := t.Detector.Threshold
+				if minReady <= 0 {
+					minReady = 5
+				}
+				minGap := t.Detector.MinGap
+				if minGap <= 0 {
+					minGap = 5
+				}
+				minConsecutive := t.Detector.MinConsecutive
+				if minConsecutive <= 0 {
+					minConsecutive = 6
+				}
+				processors = append(processors, &readySpreadProcessor{
+					template:       t,
+					attributeLabel: attribute,
+					groups:         kept,
+					minReady:       minReady,
+					minGap:         minGap,
+					minConsecutive: minConsecutive,
 				})
 			}
 		case "numa_imbalance", "dominance_imbalance":
@@ -1075,16 +6023,146 @@ func buildProcessors(templates []DiagnosticTemplate, cols []parsedColumn) []rowP
 	return processors
 }
 
-func runDiagnostics(df *DataFile, selected []DiagnosticTemplate) (DiagnosticRunResponse, error) {
-	startRun := time.Now()
-	resp := DiagnosticRunResponse{Findings: []DiagnosticFinding{}}
-	if df == nil {
-		return resp, fmt.Errorf("no file loaded")
+// defaultMaxTemplateColumns bounds how many columns one template tracks when
+// it doesn't set max_columns, so monster captures can't exhaust memory.
+const defaultMaxTemplateColumns = 5000
+
+// buildCappedProcessors builds processors template by template. Building
+// one is how a template's columns are matched; processors allocate their
+// per-column state on the first row, so matching all columns is cheap. When
+// a template matches more columns than its cap, whole column groups (see
+// capGroupKey) are kept by activity score, its processors are built once
+// over those, and a warning is returned for the response.
+func buildCappedProcessors(df *DataFile, selected []DiagnosticTemplate, cols []parsedColumn) ([]rowProcessor, []string) {
+	interval := df.SampleInterval()
+	var processors []rowProcessor
+	var warnings []string
+	for _, t := range selected {
+		if _, ok := detectorPlugins[t.Detector.Type]; ok {
+			p, err := newPluginRowProcessor(t, df.Columns)
+			if err != nil {
+				warnings = append(warnings, fmt.Sprintf("%s: detector plugin failed: %v; template skipped", t.Name, err))
+				continue
+			}
+			processors = append(processors, p)
+			continue
+		}
+		matchedProcs := buildProcessors([]DiagnosticTemplate{t}, cols, interval)
+		limit := t.Detector.MaxColumns
+		if limit <= 0 {
+			limit = defaultMaxTemplateColumns
+		}
+		var matched []int
+		for _, p := range matchedProcs {
+			matched = append(matched, p.columnIndexes()...)
+		}
+		attrs := map[string]bool{}
+		for _, idx := range matched {
+			if idx > 0 && idx < len(df.Columns) {
+				attrs[parsePDHColumnBackend(df.Columns[idx], idx).AttributeLabel] = true
+			}
+		}
+		labels := make([]string, 0, len(attrs))
+		for a := range attrs {
+			labels = append(labels, a)
+		}
+		warnings = append(warnings, thresholdUnitWarnings(t, labels)...)
+		if len(matched) <= limit {
+			processors = append(processors, matchedProcs...)
+			continue
+		}
+		scores, err := df.Activity()
+		if err != nil {
+			warnings = append(warnings, fmt.Sprintf("%s: matched %d columns (limit %d) and activity ranking failed: %v; template skipped", t.Name, len(matched), limit, err))
+			continue
+		}
+		keep, groups, keptGroups := capColumnGroups(cols, matched, scores, limit)
+		kept := make([]parsedColumn, 0, len(keep))
+		for _, c := range cols {
+			if keep[c.Idx] {
+				kept = append(kept, c)
+			}
+		}
+		processors = append(processors, buildProcessors([]DiagnosticTemplate{t}, kept, interval)...)
+		warnings = append(warnings, fmt.Sprintf("%s: matched %d columns in %d groups; analyzed the %d most active groups (%d columns) and skipped %d columns (raise detector.max_columns to include more)", t.Name, len(matched), groups, keptGroups, len(keep), len(matched)-len(keep)))
 	}
-	if len(selected) == 0 {
-		return resp, nil
+	return processors, warnings
+}
+
+// capGroupKey names the group a column is kept or dropped with under a
+// template's column cap: a NIC's queues, a VM's vCPUs, and otherwise every
+// counter of one instance (a VM's memory reclaim stages, say), so a
+// detector never sees half an entity.
+func capGroupKey(c parsedColumn) string {
+	host := ""
+	if parts := strings.SplitN(c.Raw, `\`, 4); len(parts) == 4 {
+		host = parts[2]
+	}
+	switch {
+	case strings.EqualFold(c.Object, "Vcpu"):
+		return host + `\vcpu\` + vcpuGroupKey(c.Instance)
+	case containsAnyFold(c.AttributeLabel, "queue") && vmnicNamePattern.MatchString(c.Instance):
+		return host + `\nic\` + nicQueueGroup(c)
+	}
+	return host + `\` + c.Object + `\` + c.Instance
+}
+
+// capColumnGroups picks which of the matched columns to analyze: groups go
+// in order of their most active column while they fit in limit. It
+// returns the kept columns and the group counts for the warning.
+func capColumnGroups(cols []parsedColumn, matched []int, scores []float64, limit int) (keep map[int]bool, groups, kept int) {
+	isMatched := make(map[int]bool, len(matched))
+	for _, idx := range matched {
+		isMatched[idx] = true
+	}
+	type group struct {
+		indexes []int
+		score   float64
+	}
+	var order []*group
+	byKey := map[string]*group{}
+	for _, c := range cols {
+		if !isMatched[c.Idx] {
+			continue
+		}
+		key := capGroupKey(c)
+		g := byKey[key]
+		if g == nil {
+			g = &group{score: math.Inf(-1)}
+			byKey[key] = g
+			order = append(order, g)
+		}
+		g.indexes = append(g.indexes, c.Idx)
+		if c.Idx < len(scores) && scores[c.Idx] > g.score {
+			g.score = scores[c.Idx]
+		}
+	}
+	sort.SliceStable(order, func(i, j int) bool {
+		return order[i].score > order[j].score
+	})
+	keep = make(map[int]bool, limit)
+	for _, g := range order {
+		if len(keep)+len(g.indexes) > limit {
+			continue
+		}
+		for _, idx := range g.indexes {
+			keep[idx] = true
+		}
+		kept++
 	}
+	return keep, len(order), kept
+}
 
+func runDiagnostics(df *DataFile, selected []DiagnosticTemplate, start, end time.Time) (DiagnosticRunResponse, error) {
+	return runDiagnosticsTraced(df, selected, start, end, nil)
+}
+
+// runDiagnosticsTraced is runDiagnostics with an optional QueryTrace; the
+// aggregate phase is the time spent in the detectors' onRow and finalize.
+func runDiagnosticsTraced(df *DataFile, selected []DiagnosticTemplate, start, end time.Time, trace *QueryTrace) (DiagnosticRunResponse, error) {
+	if df == nil {
+		return DiagnosticRunResponse{Findings: []DiagnosticFinding{}, HealthScore: 100}, fmt.Errorf("no file loaded")
+	}
 	cols := make([]parsedColumn, 0, len(df.Columns))
 	for i, c := range df.Columns {
 		if i == 0 {
@@ -1092,73 +6170,129 @@ func runDiagnostics(df *DataFile, selected []DiagnosticTemplate) (DiagnosticRunR
 		}
 		cols = append(cols, parsePDHColumnBackend(c, i))
 	}
-	processors := buildProcessors(selected, cols)
+	return runDiagnosticsColumns(df, selected, cols, start, end, trace)
+}
+
+// runDiagnosticsColumns runs selected over the given columns only; a
+// finding replay passes just the columns the finding was computed from.
+func runDiagnosticsColumns(df *DataFile, selected []DiagnosticTemplate, cols []parsedColumn, start, end time.Time, trace *QueryTrace) (DiagnosticRunResponse, error) {
+	startRun := time.Now()
+	resp := DiagnosticRunResponse{Findings: []DiagnosticFinding{}, HealthScore: 100}
+	if df == nil {
+		return resp, fmt.Errorf("no file loaded")
+	}
+	if len(selected) == 0 {
+		return resp, nil
+	}
+
+	processors, warnings := buildCappedProcessors(df, selected, cols)
+	resp.Warnings = warnings
+	if w := remoteIntervalWarning(df); w != "" {
+		resp.Warnings = append(resp.Warnings, w)
+	}
 	if len(processors) == 0 {
 		resp.Templates = len(selected)
 		return resp, nil
 	}
 
-	f, err := os.Open(df.Path)
+	trace.skip()
+	startOffset, _ := df.findOffset(start)
+	f, data, err := df.openData(startOffset)
 	if err != nil {
 		return resp, err
 	}
 	defer f.Close()
-
-	reader := bufio.NewReaderSize(f, 4*1024*1024)
-	// consume header
-	if _, err := reader.ReadBytes('\n'); err != nil && err != io.EOF {
-		return resp, err
+	if trace != nil {
+		trace.StartOffset = startOffset
+		trace.lap(traceSeek)
 	}
+	lines := getLineReader(data)
+	defer lines.release()
 
 	var rows int64
 	for {
-		line, err := reader.ReadBytes('\n')
+		line, err := lines.next()
+		trace.lap(traceRead)
 		if err != nil && !errors.Is(err, io.EOF) {
 			return resp, err
 		}
 		if len(line) == 0 && errors.Is(err, io.EOF) {
 			break
 		}
-		record, perr := readCSVLineBytes(line)
+		record, perr := lines.record(line)
 		if perr != nil || len(record) == 0 {
+			trace.lap(traceParse)
+			trace.line(len(line), true)
 			if errors.Is(err, io.EOF) {
 				break
 			}
 			continue
 		}
 		ts, _, terr := parseTimeValue(record[0])
+		trace.lap(traceParse)
 		if terr != nil {
-			if ms, serr := strconv.ParseInt(strings.TrimSpace(record[0]), 10, 64); serr == nil {
-				ts = time.UnixMilli(ms).UTC()
-			} else {
-				if errors.Is(err, io.EOF) {
-					break
-				}
-				continue
+			trace.line(len(line), true)
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			continue
+		}
+		if !start.IsZero() && ts.Before(start) {
+			trace.line(len(line), true)
+			if errors.Is(err, io.EOF) {
+				break
 			}
+			continue
+		}
+		if !end.IsZero() && ts.After(end) {
+			trace.line(len(line), true)
+			break
 		}
+		trace.line(len(line), false)
 		rows++
 		for _, p := range processors {
 			p.onRow(ts, record)
 		}
+		trace.lap(traceAggregate)
 		if errors.Is(err, io.EOF) {
 			break
 		}
 	}
 
+	byID := make(map[string]DiagnosticTemplate, len(selected))
+	for _, t := range selected {
+		byID[t.ID] = t
+	}
 	for _, p := range processors {
-		resp.Findings = append(resp.Findings, p.finalize()...)
+		found := p.finalize()
+		attachFindingReplay(found, p, df, byID, start, end)
+		resp.Findings = append(resp.Findings, found...)
 	}
+	// Duplicates fold first, so they do not use up a template's cap.
+	resp.Findings = dedupeFindings(resp.Findings, selected)
+	var capped []string
+	resp.Findings, capped = limitFindings(resp.Findings, selected)
+	resp.Warnings = append(resp.Warnings, capped...)
+	trace.lap(traceAggregate)
 	sort.Slice(resp.Findings, func(i, j int) bool {
 		a, b := resp.Findings[i], resp.Findings[j]
 		if a.Severity != b.Severity {
-			order := map[string]int{"critical": 0, "high": 1, "medium": 2, "low": 3}
-			return order[strings.ToLower(a.Severity)] < order[strings.ToLower(b.Severity)]
+			return severityRank[strings.ToLower(a.Severity)] < severityRank[strings.ToLower(b.Severity)]
 		}
 		return a.Title < b.Title
 	})
 	resp.Templates = len(selected)
 	resp.RowsScanned = rows
+	windowStart, windowEnd := unixMilliOrZero(df.StartTime), unixMilliOrZero(df.EndTime)
+	if !start.IsZero() && start.UnixMilli() > windowStart {
+		windowStart = start.UnixMilli()
+	}
+	if !end.IsZero() && end.UnixMilli() < windowEnd {
+		windowEnd = end.UnixMilli()
+	}
+	resp.HealthScore = healthScore(resp.Findings, windowStart, windowEnd)
+	resp.Truncated = df.Truncated
 	resp.DurationMs = time.Since(startRun).Milliseconds()
+	resp.Trace = trace.finish()
 	return resp, nil
 }
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Event is one notification on /api/events: an index job changing state,
+// a diagnostics run finishing, or a fleet or watch capture analyzed. Seq
+// increases by one per event across the server, so a client that slept
+// can ask for everything after the last Seq it saw.
+type Event struct {
+	Seq  uint64 `json:"seq"`
+	Time int64  `json:"time"`
+	Type string `json:"type"` // job, diagnostics, fleet, watch, live, load
+	Data any    `json:"data"`
+	// session limits the event to one session; nil is for everyone.
+	session *Session
+}
+
+// DiagnosticsEvent is the data of a "diagnostics" event.
+type DiagnosticsEvent struct {
+	RunID       string         `json:"runId,omitempty"`
+	File        string         `json:"file"`
+	Templates   int            `json:"templates"`
+	HealthScore int            `json:"healthScore"`
+	Findings    int            `json:"findings"`
+	BySeverity  map[string]int `json:"bySeverity,omitempty"`
+}
+
+func newDiagnosticsEvent(df *DataFile, resp DiagnosticRunResponse) DiagnosticsEvent {
+	ev := DiagnosticsEvent{RunID: resp.RunID, File: df.Label, Templates: resp.Templates, HealthScore: resp.HealthScore, Findings: len(resp.Findings), BySeverity: map[string]int{}}
+	for _, f := range resp.Findings {
+		ev.BySeverity[strings.ToLower(f.Severity)]++
+	}
+	return ev
+}
+
+type EventsResponse struct {
+	Events []Event `json:"events"`
+	// Next is the since to ask with for the following page.
+	Next uint64 `json:"next"`
+	// Oldest is the first Seq still buffered; Missed is set when events
+	// after since were already dropped, so the client should re-read
+	// state (jobs, runs) instead of relying on the stream.
+	Oldest uint64 `json:"oldest"`
+	Missed bool   `json:"missed,omitempty"`
+	More   bool   `json:"more,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+const (
+	// maxEvents and eventRetention bound the buffer; whichever is hit
+	// first drops the oldest events.
+	maxEvents      = 2000
+	eventRetention = 6 * time.Hour
+	maxEventPage   = 500
+	// eventHeartbeat keeps idle streams alive through proxies.
+	eventHeartbeat = 30 * time.Second
+)
+
+type eventLog struct {
+	mu     sync.Mutex
+	seq    uint64
+	events []Event // oldest first
+	// wake is closed (and replaced) on every publish.
+	wake chan struct{}
+}
+
+func newEventLog() *eventLog {
+	return &eventLog{wake: make(chan struct{})}
+}
+
+// publish records an event for session (nil for every session).
+func (l *eventLog) publish(session *Session, typ string, data any) {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.seq++
+	now := time.Now()
+	l.events = append(l.events, Event{Seq: l.seq, Time: now.UnixMilli(), Type: typ, Data: data, session: session})
+	drop := max(len(l.events)-maxEvents, 0)
+	cutoff := now.Add(-eventRetention).UnixMilli()
+	for drop < len(l.events)-1 && l.events[drop].Time < cutoff {
+		drop++
+	}
+	if drop > 0 {
+		l.events = append(l.events[:0], l.events[drop:]...)
+	}
+	close(l.wake)
+	l.wake = make(chan struct{})
+}
+
+// since pages through the events session can see after seq. wake is
+// closed by the next publish.
+func (l *eventLog) since(session *Session, seq uint64, limit int) (EventsResponse, <-chan struct{}) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	resp := EventsResponse{Events: []Event{}, Next: seq, Oldest: l.seq + 1}
+	if len(l.events) > 0 {
+		resp.Oldest = l.events[0].Seq
+	}
+	resp.Missed = seq+1 < resp.Oldest && seq < l.seq
+	for _, e := range l.events {
+		if e.Seq <= seq || (e.session != nil && e.session != session) {
+			continue
+		}
+		if len(resp.Events) == limit {
+			resp.More = true
+			break
+		}
+		resp.Events = append(resp.Events, e)
+		resp.Next = e.Seq
+	}
+	return resp, l.wake
+}
+
+// serve answers /api/events. since (or Last-Event-ID on an EventSource
+// reconnect) picks up after the last event a client saw. Clients asking
+// for text/event-stream get the backlog and then live events; others get
+// one JSON page of up to limit events.
+func (l *eventLog) serve(w http.ResponseWriter, r *http.Request, session *Session) {
+	q := r.URL.Query()
+	raw := strings.TrimSpace(q.Get("since"))
+	if raw == "" {
+		raw = strings.TrimSpace(r.Header.Get("Last-Event-ID"))
+	}
+	var since uint64
+	if raw != "" {
+		v, err := strconv.ParseUint(raw, 10, 64)
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, EventsResponse{Error: fmt.Sprintf("invalid since %q", raw)})
+			return
+		}
+		since = v
+	}
+	if !strings.Contains(r.Header.Get("Accept"), "text/event-stream") {
+		limit := maxEventPage
+		if raw := strings.TrimSpace(q.Get("limit")); raw != "" {
+			v, err := strconv.Atoi(raw)
+			if err != nil || v <= 0 {
+				writeJSON(w, http.StatusBadRequest, EventsResponse{Error: fmt.Sprintf("invalid limit %q", raw)})
+				return
+			}
+			limit = min(v, maxEventPage)
+		}
+		resp, _ := l.since(session, since, limit)
+		writeJSON(w, http.StatusOK, resp)
+		return
+	}
+
+	rc := http.NewResponseController(w)
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	heartbeat := time.NewTicker(eventHeartbeat)
+	defer heartbeat.Stop()
+	for first := true; ; first = false {
+		page, wake := l.since(session, since, maxEventPage)
+		if first && page.Missed {
+			fmt.Fprintf(w, "event: missed\ndata: {\"oldest\":%d}\n\n", page.Oldest)
+		}
+		for _, e := range page.Events {
+			data, err := json.Marshal(e)
+			if err != nil {
+				continue
+			}
+			fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", e.Seq, e.Type, data)
+		}
+		since = page.Next
+		if err := rc.Flush(); err != nil {
+			return
+		}
+		if page.More {
+			continue
+		}
+		select {
+		case <-r.Context().Done():
+			return
+		case <-wake:
+		case <-heartbeat.C:
+			fmt.Fprint(w, ": keep-alive\n\n")
+		}
+	}
+}
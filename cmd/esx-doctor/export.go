@@ -0,0 +1,154 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// exportChunkSize is how much an export buffers before handing a chunk to
+// the client; memory use is bounded by it and the scan reader, whatever the
+// size of the selection.
+const exportChunkSize = 256 * 1024
+
+// exportCheckRows is how often long exports look for a gone client.
+const exportCheckRows = 4096
+
+// exportFilename names a download after the capture and the exported range,
+// e.g. esxtop-host1_20240101T000000Z-20240101T010000Z.csv.
+func exportFilename(df *DataFile, start, end time.Time) string {
+	base := filepath.Base(df.Label)
+	if len(df.Parts) > 0 {
+		base = filepath.Base(df.Parts[0].Label)
+	}
+	base = trimCaptureExt(base)
+	base = strings.Map(func(r rune) rune {
+		if r == '-' || r == '_' || r == '.' || (r >= '0' && r <= '9') || (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') {
+			return r
+		}
+		return '_'
+	}, base)
+	if base == "" {
+		base = "capture"
+	}
+	if start.IsZero() || start.Before(df.StartTime) {
+		start = df.StartTime
+	}
+	if end.IsZero() || end.After(df.EndTime) {
+		end = df.EndTime
+	}
+	const stamp = "20060102T150405Z"
+	name := base
+	if !start.IsZero() && !end.IsZero() {
+		name += "_" + start.UTC().Format(stamp) + "-" + end.UTC().Format(stamp)
+	}
+	return name + ".csv"
+}
+
+// headerLine returns the capture's header exactly as written, so a full
+// slice stays a valid esxtop CSV.
+func (df *DataFile) headerLine() ([]byte, error) {
+	path, n := df.Path, df.DataStartOffset
+	if len(df.Parts) > 0 {
+		path, n = df.Parts[0].Path, df.Parts[0].DataStart
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	buf := make([]byte, n)
+	if _, err := io.ReadFull(f, buf); err != nil {
+		return nil, err
+	}
+	return buf, nil
+}
+
+// writeSlice streams the rows of df within [start, end] to w. With no
+// columns the rows are copied byte for byte; otherwise only Time and cols
+// are written. It stops with ctx's error once the client goes away.
+func writeSlice(ctx context.Context, w io.Writer, df *DataFile, cols []int, start, end time.Time) (int64, error) {
+	for _, idx := range cols {
+		if idx <= 0 || idx >= len(df.Columns) {
+			return 0, fmt.Errorf("column %d out of range", idx)
+		}
+	}
+	bw := bufio.NewWriterSize(w, exportChunkSize)
+	if len(cols) == 0 {
+		header, err := df.headerLine()
+		if err != nil {
+			return 0, err
+		}
+		bw.Write(header)
+	} else {
+		header := make([]string, 0, len(cols)+1)
+		header = append(header, df.Columns[0])
+		for _, idx := range cols {
+			header = append(header, df.Columns[idx])
+		}
+		writeQuotedCSVRow(bw, header)
+	}
+
+	startOffset, _ := df.findOffset(start)
+	f, data, err := df.openData(startOffset)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	lines := getLineReader(data)
+	defer lines.release()
+	row := make([]string, 0, len(cols)+1)
+	var rows, seen int64
+	for {
+		line, err := lines.next()
+		if err != nil && !errors.Is(err, io.EOF) {
+			return rows, err
+		}
+		if len(line) == 0 && errors.Is(err, io.EOF) {
+			break
+		}
+		if seen++; seen%exportCheckRows == 0 {
+			if cerr := ctx.Err(); cerr != nil {
+				return rows, cerr
+			}
+		}
+		record, perr := lines.record(line)
+		if perr == nil && len(record) > 0 {
+			ts, _, terr := parseTimeValue(record[0])
+			if terr == nil {
+				if !end.IsZero() && ts.After(end) {
+					break
+				}
+				if start.IsZero() || !ts.Before(start) {
+					if len(cols) == 0 {
+						bw.Write(line)
+						if line[len(line)-1] != '\n' {
+							bw.WriteByte('\n')
+						}
+					} else {
+						row = append(row[:0], record[0])
+						for _, idx := range cols {
+							v := ""
+							if idx < len(record) {
+								v = record[idx]
+							}
+							row = append(row, v)
+						}
+						writeQuotedCSVRow(bw, row)
+					}
+					rows++
+				}
+			}
+		}
+		if errors.Is(err, io.EOF) {
+			break
+		}
+	}
+	return rows, bw.Flush()
+}
@@ -0,0 +1,421 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Derived series: /api/series?expr=... evaluates a small arithmetic
+// expression over each row's values, e.g.
+//
+//	[Physical Disk Adapter(vmhba0)\Average Guest MilliSec/Command] - [Physical Disk Adapter(vmhba0)\Average Driver MilliSec/Command]
+//	$12 / $13 * 100
+//	sum([Vcpu(*)\% Used])
+//
+// $N is column N; [...] is a column by header, either in full or without
+// the leading \\host\ part, with * matching anything. A pattern matching
+// several columns is only allowed as an argument of sum, avg, min or max.
+// A row where a referenced value is missing (or a division by zero)
+// yields no point; the aggregate functions skip missing values instead.
+
+const (
+	maxSeriesExprs   = 16
+	maxSeriesExprLen = 1000
+	// maxExprColumns bounds the columns one expression may read.
+	maxExprColumns = 5000
+)
+
+type seriesExpr struct {
+	Text string
+	root exprNode
+	cols []int
+}
+
+type exprNode interface {
+	eval(value func(col int) (float64, bool)) (float64, bool)
+}
+
+type exprNum float64
+
+func (n exprNum) eval(func(int) (float64, bool)) (float64, bool) { return float64(n), true }
+
+type exprCol int
+
+func (c exprCol) eval(value func(int) (float64, bool)) (float64, bool) { return value(int(c)) }
+
+type exprNeg struct{ x exprNode }
+
+func (n exprNeg) eval(value func(int) (float64, bool)) (float64, bool) {
+	v, ok := n.x.eval(value)
+	return -v, ok
+}
+
+type exprBinary struct {
+	op   byte
+	l, r exprNode
+}
+
+func (b exprBinary) eval(value func(int) (float64, bool)) (float64, bool) {
+	l, ok := b.l.eval(value)
+	if !ok {
+		return 0, false
+	}
+	r, ok := b.r.eval(value)
+	if !ok {
+		return 0, false
+	}
+	switch b.op {
+	case '+':
+		return l + r, true
+	case '-':
+		return l - r, true
+	case '*':
+		return l * r, true
+	case '/':
+		if r == 0 {
+			return 0, false
+		}
+		return l / r, true
+	}
+	return 0, false
+}
+
+type exprCall struct {
+	fn   string
+	args []exprNode
+}
+
+func (c exprCall) eval(value func(int) (float64, bool)) (float64, bool) {
+	if c.fn == "abs" {
+		v, ok := c.args[0].eval(value)
+		return math.Abs(v), ok
+	}
+	var acc float64
+	n := 0
+	for _, a := range c.args {
+		v, ok := a.eval(value)
+		if !ok {
+			continue
+		}
+		switch {
+		case n == 0:
+			acc = v
+		case c.fn == "min":
+			acc = math.Min(acc, v)
+		case c.fn == "max":
+			acc = math.Max(acc, v)
+		default:
+			acc += v
+		}
+		n++
+	}
+	if n == 0 {
+		return 0, false
+	}
+	if c.fn == "avg" {
+		acc /= float64(n)
+	}
+	return acc, true
+}
+
+// exprFuncs maps the supported functions to whether they aggregate any
+// number of arguments (and so accept multi-column patterns).
+var exprFuncs = map[string]bool{"sum": true, "avg": true, "min": true, "max": true, "abs": false}
+
+type exprParser struct {
+	df   *DataFile
+	src  string
+	pos  int
+	cols map[int]bool
+}
+
+// parseSeriesExpr compiles text against df's columns.
+func parseSeriesExpr(df *DataFile, text string) (*seriesExpr, error) {
+	text = strings.TrimSpace(text)
+	if text == "" {
+		return nil, fmt.Errorf("empty expression")
+	}
+	if len(text) > maxSeriesExprLen {
+		return nil, fmt.Errorf("expression is too long (max %d characters)", maxSeriesExprLen)
+	}
+	p := &exprParser{df: df, src: text, cols: map[int]bool{}}
+	root, err := p.parseSum()
+	if err == nil && p.skipSpace() < len(p.src) {
+		err = p.errorf("unexpected %q", p.src[p.pos])
+	}
+	if err != nil {
+		return nil, fmt.Errorf("expression %q: %w", text, err)
+	}
+	out := &seriesExpr{Text: text, root: root, cols: make([]int, 0, len(p.cols))}
+	for idx := range p.cols {
+		out.cols = append(out.cols, idx)
+	}
+	return out, nil
+}
+
+func (p *exprParser) errorf(format string, args ...any) error {
+	return fmt.Errorf("at %d: %s", p.pos+1, fmt.Sprintf(format, args...))
+}
+
+func (p *exprParser) skipSpace() int {
+	for p.pos < len(p.src) && (p.src[p.pos] == ' ' || p.src[p.pos] == '\t') {
+		p.pos++
+	}
+	return p.pos
+}
+
+func (p *exprParser) peek() byte {
+	if p.skipSpace() >= len(p.src) {
+		return 0
+	}
+	return p.src[p.pos]
+}
+
+func (p *exprParser) parseSum() (exprNode, error) {
+	l, err := p.parseProduct()
+	if err != nil {
+		return nil, err
+	}
+	for op := p.peek(); op == '+' || op == '-'; op = p.peek() {
+		p.pos++
+		r, err := p.parseProduct()
+		if err != nil {
+			return nil, err
+		}
+		l = exprBinary{op: op, l: l, r: r}
+	}
+	return l, nil
+}
+
+func (p *exprParser) parseProduct() (exprNode, error) {
+	l, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for op := p.peek(); op == '*' || op == '/'; op = p.peek() {
+		p.pos++
+		r, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		l = exprBinary{op: op, l: l, r: r}
+	}
+	return l, nil
+}
+
+func (p *exprParser) parseUnary() (exprNode, error) {
+	if p.peek() == '-' {
+		p.pos++
+		x, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return exprNeg{x}, nil
+	}
+	args, err := p.parsePrimary(false)
+	if err != nil {
+		return nil, err
+	}
+	return args[0], nil
+}
+
+// parsePrimary returns one node, or one per matched column for a pattern
+// when multi is set.
+func (p *exprParser) parsePrimary(multi bool) ([]exprNode, error) {
+	c := p.peek()
+	switch {
+	case c == 0:
+		return nil, p.errorf("unexpected end of expression")
+	case c == '(':
+		p.pos++
+		x, err := p.parseSum()
+		if err != nil {
+			return nil, err
+		}
+		if p.peek() != ')' {
+			return nil, p.errorf("missing )")
+		}
+		p.pos++
+		return []exprNode{x}, nil
+	case c == '$':
+		p.pos++
+		start := p.pos
+		for p.pos < len(p.src) && p.src[p.pos] >= '0' && p.src[p.pos] <= '9' {
+			p.pos++
+		}
+		idx, err := strconv.Atoi(p.src[start:p.pos])
+		if err != nil || idx <= 0 || idx >= len(p.df.Columns) {
+			return nil, p.errorf("invalid column $%s", p.src[start:p.pos])
+		}
+		p.cols[idx] = true
+		return []exprNode{exprCol(idx)}, nil
+	case c == '[':
+		end := strings.IndexByte(p.src[p.pos:], ']')
+		if end < 0 {
+			return nil, p.errorf("missing ]")
+		}
+		pattern := p.src[p.pos+1 : p.pos+end]
+		matches := p.df.matchColumnPattern(pattern)
+		switch {
+		case len(matches) == 0:
+			return nil, p.errorf("no column matches [%s]", pattern)
+		case len(matches) > 1 && !multi:
+			return nil, p.errorf("[%s] matches %d columns; wrap it in sum, avg, min or max", pattern, len(matches))
+		case len(matches) > maxExprColumns:
+			return nil, p.errorf("[%s] matches too many columns (%d, max %d)", pattern, len(matches), maxExprColumns)
+		}
+		p.pos += end + 1
+		out := make([]exprNode, len(matches))
+		for i, idx := range matches {
+			p.cols[idx] = true
+			out[i] = exprCol(idx)
+		}
+		return out, nil
+	case c >= '0' && c <= '9' || c == '.':
+		start := p.pos
+		for p.pos < len(p.src) && (p.src[p.pos] >= '0' && p.src[p.pos] <= '9' || p.src[p.pos] == '.') {
+			p.pos++
+		}
+		if p.pos < len(p.src) && (p.src[p.pos] == 'e' || p.src[p.pos] == 'E') {
+			p.pos++
+			if p.pos < len(p.src) && (p.src[p.pos] == '+' || p.src[p.pos] == '-') {
+				p.pos++
+			}
+			for p.pos < len(p.src) && p.src[p.pos] >= '0' && p.src[p.pos] <= '9' {
+				p.pos++
+			}
+		}
+		num := p.src[start:p.pos]
+		v, err := strconv.ParseFloat(num, 64)
+		if err != nil {
+			p.pos = start
+			return nil, p.errorf("invalid number %q", num)
+		}
+		return []exprNode{exprNum(v)}, nil
+	case c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z':
+		start := p.pos
+		for p.pos < len(p.src) && (p.src[p.pos] >= 'a' && p.src[p.pos] <= 'z' || p.src[p.pos] >= 'A' && p.src[p.pos] <= 'Z') {
+			p.pos++
+		}
+		name := strings.ToLower(p.src[start:p.pos])
+		variadic, ok := exprFuncs[name]
+		if !ok {
+			p.pos = start
+			return nil, p.errorf("unknown function %q (use sum, avg, min, max or abs)", name)
+		}
+		if p.peek() != '(' {
+			return nil, p.errorf("missing ( after %s", name)
+		}
+		p.pos++
+		var args []exprNode
+		for {
+			var arg []exprNode
+			var err error
+			if p.peek() == '[' {
+				arg, err = p.parsePrimary(variadic)
+				// A pattern may still start a larger argument: [a] * 2.
+				if err == nil && len(arg) == 1 && p.peek() != ',' && p.peek() != ')' {
+					arg, err = p.continueArg(arg[0])
+				}
+			} else {
+				var x exprNode
+				x, err = p.parseSum()
+				arg = []exprNode{x}
+			}
+			if err != nil {
+				return nil, err
+			}
+			args = append(args, arg...)
+			if p.peek() == ',' {
+				p.pos++
+				continue
+			}
+			if p.peek() != ')' {
+				return nil, p.errorf("missing ) after %s arguments", name)
+			}
+			p.pos++
+			break
+		}
+		if !variadic && len(args) != 1 {
+			return nil, p.errorf("%s takes one argument", name)
+		}
+		return []exprNode{exprCall{fn: name, args: args}}, nil
+	}
+	return nil, p.errorf("unexpected %q", c)
+}
+
+// continueArg parses the rest of a function argument that began with the
+// already parsed column x, honoring precedence.
+func (p *exprParser) continueArg(x exprNode) ([]exprNode, error) {
+	for op := p.peek(); op == '*' || op == '/'; op = p.peek() {
+		p.pos++
+		r, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		x = exprBinary{op: op, l: x, r: r}
+	}
+	for op := p.peek(); op == '+' || op == '-'; op = p.peek() {
+		p.pos++
+		r, err := p.parseProduct()
+		if err != nil {
+			return nil, err
+		}
+		x = exprBinary{op: op, l: x, r: r}
+	}
+	return []exprNode{x}, nil
+}
+
+// matchColumnPattern returns the columns whose header matches pattern in
+// full or after the leading \\host\ part, case-insensitively, with *
+// matching any run of characters.
+func (df *DataFile) matchColumnPattern(pattern string) []int {
+	pattern = strings.ToLower(strings.TrimSpace(pattern))
+	if pattern == "" {
+		return nil
+	}
+	var out []int
+	for i := 1; i < len(df.Columns); i++ {
+		h := strings.ToLower(df.Columns[i])
+		if globMatch(pattern, h) || globMatch(pattern, stripHostPrefix(h)) {
+			out = append(out, i)
+		}
+	}
+	return out
+}
+
+// stripHostPrefix turns \\host\Object(Instance)\Counter into
+// Object(Instance)\Counter.
+func stripHostPrefix(h string) string {
+	if !strings.HasPrefix(h, `\\`) {
+		return h
+	}
+	if i := strings.IndexByte(h[2:], '\\'); i >= 0 {
+		return h[2+i+1:]
+	}
+	return h
+}
+
+// globMatch reports whether s matches pattern, where * matches any run of
+// characters and everything else matches itself.
+func globMatch(pattern, s string) bool {
+	parts := strings.Split(pattern, "*")
+	if len(parts) == 1 {
+		return pattern == s
+	}
+	if !strings.HasPrefix(s, parts[0]) {
+		return false
+	}
+	s = s[len(parts[0]):]
+	last := parts[len(parts)-1]
+	for _, part := range parts[1 : len(parts)-1] {
+		i := strings.Index(s, part)
+		if i < 0 {
+			return false
+		}
+		s = s[i+len(part):]
+	}
+	return strings.HasSuffix(s, last)
+}
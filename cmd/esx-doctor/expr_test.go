@@ -0,0 +1,137 @@
+package main
+
+import (
+	"slices"
+	"strings"
+	"testing"
+)
+
+func exprTestFile() *DataFile {
+	return &DataFile{Columns: []string{
+		"(PDH-CSV 4.0) (UTC)(0)",
+		`\\esx01\Vcpu(1:vm-a)\% Used`,
+		`\\esx01\Vcpu(2:vm-a)\% Used`,
+		`\\esx01\Memory\Free MBytes`,
+	}}
+}
+
+func TestSeriesExprEval(t *testing.T) {
+	row := map[int]float64{1: 10, 2: 20, 3: 200}
+	tests := []struct {
+		expr    string
+		missing int // a column left out of the row
+		want    float64
+		ok      bool
+		cols    []int
+	}{
+		{expr: "1 + 2 * 3", want: 7, ok: true},
+		{expr: "(1 + 2) * 3", want: 9, ok: true},
+		{expr: "10 - 4 - 3", want: 3, ok: true},
+		{expr: "8 / 4 / 2", want: 1, ok: true},
+		{expr: "2 * 3 - 4 / 2", want: 4, ok: true},
+		{expr: "-2 * -3", want: 6, ok: true},
+		{expr: "- (1 + 2) * 2", want: -6, ok: true},
+		{expr: "1e3 / 10", want: 100, ok: true},
+		{expr: ".5 * 4", want: 2, ok: true},
+		{expr: "$2 / $3 * 100", want: 10, ok: true, cols: []int{2, 3}},
+		{expr: `[Memory\Free MBytes] - [\\esx01\Vcpu(1:vm-a)\% Used]`, want: 190, ok: true, cols: []int{1, 3}},
+		{expr: `sum([vcpu(*)\% used])`, want: 30, ok: true, cols: []int{1, 2}},
+		{expr: `avg([Vcpu(*)\% Used]) * 2`, want: 30, ok: true, cols: []int{1, 2}},
+		{expr: `max([Vcpu(1:*)\% Used] * 3, 25)`, want: 30, ok: true, cols: []int{1}},
+		{expr: `min($1, $2, $3)`, want: 10, ok: true, cols: []int{1, 2, 3}},
+		{expr: "abs(3 - 5)", want: 2, ok: true},
+		{expr: "1 / 0", ok: false},
+		{expr: "$1 / ($2 - 20)", ok: false, cols: []int{1, 2}},
+		{expr: "0 / 0 + 1", ok: false},
+		{expr: "$1 + $2", missing: 2, ok: false, cols: []int{1, 2}},
+		{expr: `sum([Vcpu(*)\% Used])`, missing: 2, want: 10, ok: true, cols: []int{1, 2}},
+		{expr: `avg([Vcpu(*)\% Used])`, missing: 2, want: 10, ok: true, cols: []int{1, 2}},
+		{expr: "sum($2)", missing: 2, ok: false, cols: []int{2}},
+	}
+	df := exprTestFile()
+	for _, tt := range tests {
+		e, err := parseSeriesExpr(df, tt.expr)
+		if err != nil {
+			t.Errorf("%s: %v", tt.expr, err)
+			continue
+		}
+		got, ok := e.root.eval(func(col int) (float64, bool) {
+			if col == tt.missing {
+				return 0, false
+			}
+			v, ok := row[col]
+			return v, ok
+		})
+		if ok != tt.ok || ok && got != tt.want {
+			t.Errorf("%s = %v, %v; want %v, %v", tt.expr, got, ok, tt.want, tt.ok)
+		}
+		cols := slices.Sorted(slices.Values(e.cols))
+		if len(cols) == 0 {
+			cols = nil
+		}
+		if !slices.Equal(cols, tt.cols) {
+			t.Errorf("%s reads columns %v, want %v", tt.expr, cols, tt.cols)
+		}
+	}
+}
+
+func TestSeriesExprErrors(t *testing.T) {
+	tests := []struct {
+		expr string
+		want string
+	}{
+		{"", "empty expression"},
+		{"   ", "empty expression"},
+		{strings.Repeat("1+", 500) + "1", "too long"},
+		{"$0", "invalid column $0"},
+		{"$4", "invalid column $4"},
+		{"$99999999999999999999", "invalid column $99999999999999999999"},
+		{"$", "invalid column $"},
+		{"$-1", "invalid column $"},
+		{`[Memory\Nope]`, `no column matches [Memory\Nope]`},
+		{`[Vcpu(*)\% Used]`, "matches 2 columns; wrap it in sum"},
+		{`[Vcpu(*)\% Used] + 1`, "matches 2 columns"},
+		{`[Memory\Free MBytes`, "at 1: missing ]"},
+		{"foo(1)", `unknown function "foo"`},
+		{"abs(1, 2)", "abs takes one argument"},
+		{"sum 1", "missing ( after sum"},
+		{"sum(1, 2", "missing ) after sum arguments"},
+		{`max([Vcpu(*)\% Used] * 3)`, "missing ) after max arguments"},
+		{"1 +", "at 4: unexpected end of expression"},
+		{"(1 + 2", "missing )"},
+		{"1 2", `at 3: unexpected '2'`},
+		{"1 % 2", `unexpected '%'`},
+		{"2 * * 3", `unexpected '*'`},
+		{"1..2", `invalid number "1..2"`},
+		{")", `unexpected ')'`},
+	}
+	df := exprTestFile()
+	for _, tt := range tests {
+		_, err := parseSeriesExpr(df, tt.expr)
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%.40q: err = %v, want %q", tt.expr, err, tt.want)
+		}
+	}
+}
+
+func TestGlobMatch(t *testing.T) {
+	tests := []struct {
+		pattern, s string
+		want       bool
+	}{
+		{"abc", "abc", true},
+		{"abc", "abcd", false},
+		{"a*", "abc", true},
+		{"*c", "abc", true},
+		{"a*c", "ac", true},
+		{"a*b*c", "axxbyyc", true},
+		{"a*b*c", "axxcyyb", false},
+		{"*", "", true},
+		{"a*a", "a", false},
+	}
+	for _, tt := range tests {
+		if got := globMatch(tt.pattern, tt.s); got != tt.want {
+			t.Errorf("globMatch(%q, %q) = %v, want %v", tt.pattern, tt.s, got, tt.want)
+		}
+	}
+}
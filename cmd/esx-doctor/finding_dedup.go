@@ -0,0 +1,100 @@
+package main
+
+import (
+	"sort"
+	"strings"
+)
+
+// FindingAttribution names a template whose finding was folded into
+// another one by dedupeFindings.
+type FindingAttribution struct {
+	TemplateID   string `json:"templateId"`
+	TemplateName string `json:"templateName"`
+	Severity     string `json:"severity"`
+}
+
+var severityRank = map[string]int{"critical": 0, "high": 1, "medium": 2, "low": 3, "info": 4}
+
+// flaggedBy reports whether template id produced f, directly or as one of
+// the templates folded into it.
+func (f DiagnosticFinding) flaggedBy(id string) bool {
+	if f.TemplateID == id {
+		return true
+	}
+	for _, a := range f.AlsoFlaggedBy {
+		if a.TemplateID == id {
+			return true
+		}
+	}
+	return false
+}
+
+// dedupeFindings collapses findings from different templates that describe
+// the same thing: the same counter and instances over overlapping windows,
+// where one of the two comes from a generic threshold_sustained template
+// (a custom threshold and a specialized built-in both flagging one disk).
+// Two specialized detectors agreeing are separate findings. The kept
+// finding is the most severe; on a tie, the specialized one, then the
+// longer window. It keeps its own window, summary and replay, so they
+// still describe each other, and the others are listed in AlsoFlaggedBy.
+// Findings without a counter, instances or a window are never merged.
+func dedupeFindings(findings []DiagnosticFinding, templates []DiagnosticTemplate) []DiagnosticFinding {
+	if len(findings) < 2 {
+		return findings
+	}
+	generic := make(map[string]bool, len(templates))
+	for _, t := range templates {
+		generic[t.ID] = t.Detector.Type == "threshold_sustained"
+	}
+	better := func(a, b DiagnosticFinding) bool {
+		ra, rb := severityRank[strings.ToLower(a.Severity)], severityRank[strings.ToLower(b.Severity)]
+		if ra != rb {
+			return ra < rb
+		}
+		if generic[a.TemplateID] != generic[b.TemplateID] {
+			return !generic[a.TemplateID]
+		}
+		return a.End-a.Start > b.End-b.Start
+	}
+	key := func(f DiagnosticFinding) string {
+		if f.AttributeLabel == "" || len(f.Instances) == 0 || f.Start <= 0 || f.End < f.Start {
+			return ""
+		}
+		inst := append([]string(nil), f.Instances...)
+		sort.Strings(inst)
+		return strings.ToLower(canonicalAttributeLabel(f.AttributeLabel)) + "\x00" + strings.Join(inst, "\x00")
+	}
+
+	out := make([]DiagnosticFinding, 0, len(findings))
+	groups := map[string][]int{} // key -> indexes into out
+	for _, f := range findings {
+		k := key(f)
+		if k == "" {
+			out = append(out, f)
+			continue
+		}
+		merged := false
+		for _, i := range groups[k] {
+			g := &out[i]
+			if g.TemplateID == f.TemplateID || f.Start > g.End || g.Start > f.End || g.flaggedBy(f.TemplateID) {
+				continue
+			}
+			if !generic[f.TemplateID] && !generic[g.TemplateID] {
+				continue
+			}
+			if better(f, *g) {
+				f.AlsoFlaggedBy = append(g.AlsoFlaggedBy, FindingAttribution{TemplateID: g.TemplateID, TemplateName: g.TemplateName, Severity: g.Severity})
+				*g = f
+			} else {
+				g.AlsoFlaggedBy = append(g.AlsoFlaggedBy, FindingAttribution{TemplateID: f.TemplateID, TemplateName: f.TemplateName, Severity: f.Severity})
+			}
+			merged = true
+			break
+		}
+		if !merged {
+			groups[k] = append(groups[k], len(out))
+			out = append(out, f)
+		}
+	}
+	return out
+}
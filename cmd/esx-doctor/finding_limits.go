@@ -0,0 +1,140 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// defaultMaxFindings is how many findings a template keeps unless its
+// detector sets max_findings. A few detectors report one finding per
+// instance of something short-lived and keep more.
+const defaultMaxFindings = 20
+
+var detectorMaxFindings = map[string]int{
+	"value_switch": 30,
+	"vmotion_stun": 30,
+}
+
+// findingRanks are the policies for picking which findings a template
+// keeps when it has more than max_findings:
+//
+//	default   the detector's own order (mostly by summary or instance)
+//	duration  longest window first
+//	peak      highest "peak" message parameter first; findings without
+//	          one last
+//	severity  most severe first, then longest
+var findingRanks = []string{"default", "duration", "peak", "severity"}
+
+func validFindingRank(rank string) bool {
+	rank = strings.ToLower(strings.TrimSpace(rank))
+	if rank == "" {
+		return true
+	}
+	for _, r := range findingRanks {
+		if r == rank {
+			return true
+		}
+	}
+	return false
+}
+
+// maxFindingsFor is t's cap; a negative max_findings means no cap.
+func maxFindingsFor(t DiagnosticTemplate) int {
+	if n := t.Detector.MaxFindings; n != 0 {
+		return n
+	}
+	if n, ok := detectorMaxFindings[t.Detector.Type]; ok {
+		return n
+	}
+	return defaultMaxFindings
+}
+
+func findingDuration(f DiagnosticFinding) time.Duration {
+	if f.Start == 0 || f.End < f.Start {
+		return 0
+	}
+	return time.Duration(f.End-f.Start) * time.Millisecond
+}
+
+// findingPeak is the finding's "peak" message parameter, when it has one.
+func findingPeak(f DiagnosticFinding) (float64, bool) {
+	if f.Message == nil {
+		return 0, false
+	}
+	switch v := f.Message.Params["peak"].(type) {
+	case float64:
+		return v, true
+	case float32:
+		return float64(v), true
+	case int:
+		return float64(v), true
+	case int64:
+		return float64(v), true
+	}
+	return 0, false
+}
+
+// rankFindings orders findings by rank; ties keep the detector's order.
+func rankFindings(findings []DiagnosticFinding, rank string) {
+	var less func(a, b DiagnosticFinding) bool
+	switch strings.ToLower(strings.TrimSpace(rank)) {
+	case "duration":
+		less = func(a, b DiagnosticFinding) bool { return findingDuration(a) > findingDuration(b) }
+	case "peak":
+		less = func(a, b DiagnosticFinding) bool {
+			pa, oka := findingPeak(a)
+			pb, okb := findingPeak(b)
+			if oka != okb {
+				return oka
+			}
+			return pa > pb
+		}
+	case "severity":
+		less = func(a, b DiagnosticFinding) bool {
+			ra, rb := severityRank[strings.ToLower(a.Severity)], severityRank[strings.ToLower(b.Severity)]
+			if ra != rb {
+				return ra < rb
+			}
+			return findingDuration(a) > findingDuration(b)
+		}
+	default:
+		return
+	}
+	sort.SliceStable(findings, func(i, j int) bool { return less(findings[i], findings[j]) })
+}
+
+// limitFindings applies each template's ranking and cap to the findings
+// it produced, and says which templates had findings dropped.
+func limitFindings(findings []DiagnosticFinding, templates []DiagnosticTemplate) ([]DiagnosticFinding, []string) {
+	byID := make(map[string]DiagnosticTemplate, len(templates))
+	for _, t := range templates {
+		byID[t.ID] = t
+	}
+	var order []string
+	groups := map[string][]DiagnosticFinding{}
+	for _, f := range findings {
+		if _, ok := groups[f.TemplateID]; !ok {
+			order = append(order, f.TemplateID)
+		}
+		groups[f.TemplateID] = append(groups[f.TemplateID], f)
+	}
+	out := findings[:0:0]
+	var warnings []string
+	for _, id := range order {
+		group := groups[id]
+		t, ok := byID[id]
+		if !ok {
+			out = append(out, group...)
+			continue
+		}
+		rankFindings(group, t.Detector.Rank)
+		if limit := maxFindingsFor(t); limit >= 0 && len(group) > limit {
+			warnings = append(warnings, fmt.Sprintf("%s: kept %d of %d findings (detector max_findings)", t.Name, limit, len(group)))
+			group = group[:limit]
+		}
+		out = append(out, group...)
+	}
+	return out, warnings
+}
@@ -0,0 +1,260 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// FindingReplay is everything needed to compute a finding again: the
+// template as it was resolved for the run, the columns its detector read
+// (by index and header, so a reordered or trimmed copy of the capture
+// still works), the effective thresholds where the detector derives them,
+// and the analyzed window.
+type FindingReplay struct {
+	Template    DiagnosticTemplate `json:"template"`
+	Columns     []int              `json:"columns"`
+	ColumnNames []string           `json:"columnNames"`
+	Params      map[string]float64 `json:"params,omitempty"`
+	Start       int64              `json:"start"`
+	End         int64              `json:"end"`
+}
+
+// findingColumner is implemented by detectors whose findings each depend
+// on their own columns only; other detectors replay over every column they
+// read, since their findings compare columns with each other.
+type findingColumner interface {
+	findingColumns(f DiagnosticFinding) (cols []int, params map[string]float64)
+}
+
+func (p *thresholdProcessor) findingColumns(f DiagnosticFinding) ([]int, map[string]float64) {
+	if len(f.Instances) != 1 {
+		return nil, nil
+	}
+	var cols []int
+	var params map[string]float64
+	for i, label := range p.labels {
+		if label != f.Instances[0] {
+			continue
+		}
+		cols = append(cols, p.indexes[i])
+		if params != nil {
+			continue
+		}
+		params = map[string]float64{"minConsecutive": float64(p.minConsecutive)}
+		if p.minDuration > 0 {
+			params["minDurationSeconds"] = p.minDuration.Seconds()
+		}
+		lower, upper, hasLower, hasUpper, _ := p.bounds(i)
+		if hasLower {
+			params["lower"] = lower
+		}
+		if hasUpper {
+			params["upper"] = upper
+		}
+	}
+	return cols, params
+}
+
+// attachFindingReplay gives each of p's findings its ID and replay spec.
+// It runs before dedupeFindings and limitFindings, which may widen a
+// finding's window; the ID keeps describing what the detector reported.
+func attachFindingReplay(findings []DiagnosticFinding, p rowProcessor, df *DataFile, templates map[string]DiagnosticTemplate, start, end time.Time) {
+	if start.IsZero() {
+		start = df.StartTime
+	}
+	if end.IsZero() {
+		// Pinned, so a replay of a growing capture covers the same rows.
+		end = df.EndTime
+	}
+	all := p.columnIndexes()
+	fc, narrow := p.(findingColumner)
+	for i := range findings {
+		f := &findings[i]
+		t, ok := templates[f.TemplateID]
+		if !ok {
+			continue
+		}
+		cols, params := all, map[string]float64(nil)
+		if narrow {
+			if c, pr := fc.findingColumns(*f); len(c) > 0 {
+				cols, params = c, pr
+			}
+		}
+		spec := &FindingReplay{
+			Template:    t,
+			Columns:     append([]int(nil), cols...),
+			ColumnNames: make([]string, len(cols)),
+			Params:      params,
+			Start:       unixMilliOrZero(start),
+			End:         unixMilliOrZero(end),
+		}
+		for j, idx := range cols {
+			if idx >= 0 && idx < len(df.Columns) {
+				spec.ColumnNames[j] = df.Columns[idx]
+			}
+		}
+		f.Replay = spec
+		f.ID = findingID(*f)
+	}
+}
+
+// findingID hashes the rule (the template snapshot) together with what it
+// reported, so the same rule finding the same thing in the same window
+// always gets the same ID.
+func findingID(f DiagnosticFinding) string {
+	h := sha256.New()
+	if f.Replay != nil {
+		t, _ := json.Marshal(f.Replay.Template.Detector)
+		h.Write(t)
+		fmt.Fprintf(h, "|%s|%d|%d", f.Replay.Template.Severity, f.Replay.Start, f.Replay.End)
+	}
+	fmt.Fprintf(h, "|%s|%s|%s|%d|%d", f.TemplateID, f.AttributeLabel, strings.Join(f.Instances, "\x00"), f.Start, f.End)
+	return "f-" + hex.EncodeToString(h.Sum(nil))[:16]
+}
+
+// StoredFinding is a finding found by ID in the run store or the history,
+// with the run it came from.
+type StoredFinding struct {
+	ID          string            `json:"id"`
+	RunID       string            `json:"runId"`
+	File        string            `json:"file"`
+	ContentHash string            `json:"contentHash,omitempty"`
+	At          int64             `json:"at"`
+	Finding     DiagnosticFinding `json:"finding"`
+}
+
+// lookupFinding finds id in the newest run that has it. Recent runs are
+// kept whole in memory; older ones come from the history, which keeps the
+// replay spec but not the prose.
+func lookupFinding(runs *runStore, history *historyStore, id string) (StoredFinding, bool) {
+	runs.mu.Lock()
+	for i := len(runs.runs) - 1; i >= 0; i-- {
+		run := runs.runs[i]
+		for _, f := range run.Result.Findings {
+			if f.ID == id {
+				runs.mu.Unlock()
+				return StoredFinding{ID: id, RunID: run.ID, File: run.File, At: run.At, Finding: f}, true
+			}
+		}
+	}
+	runs.mu.Unlock()
+
+	history.mu.Lock()
+	defer history.mu.Unlock()
+	for i := len(history.entries) - 1; i >= 0; i-- {
+		e := history.entries[i]
+		for _, hf := range e.Findings {
+			if hf.ID != id || hf.Replay == nil {
+				continue
+			}
+			f := DiagnosticFinding{
+				ID:             hf.ID,
+				TemplateID:     hf.TemplateID,
+				TemplateName:   hf.Replay.Template.Name,
+				Title:          hf.Replay.Template.Name,
+				Severity:       hf.Severity,
+				AttributeLabel: hf.AttributeLabel,
+				Instances:      hf.Instances,
+				Start:          hf.Start,
+				End:            hf.End,
+				Replay:         hf.Replay,
+			}
+			return StoredFinding{ID: id, RunID: e.ID, File: e.File, ContentHash: e.ContentHash, At: e.At, Finding: f}, true
+		}
+	}
+	return StoredFinding{}, false
+}
+
+// FindingReplayResponse is the body of /api/diagnostics/findings/{id}/replay.
+type FindingReplayResponse struct {
+	Original StoredFinding `json:"original"`
+	File     string        `json:"file"`
+	// SameCapture compares content hashes when both are known and falls
+	// back to the file label.
+	SameCapture bool `json:"sameCapture"`
+	// Reproduced is set when the replay found a finding with the original
+	// ID: same rule, same columns, same window, same result.
+	Reproduced bool                `json:"reproduced"`
+	Findings   []DiagnosticFinding `json:"findings"`
+	Warnings   []string            `json:"warnings,omitempty"`
+	Error      string              `json:"error,omitempty"`
+}
+
+// replayFinding runs the finding's template snapshot over its columns of
+// df, located by header so the capture may have been re-exported with
+// other columns around them.
+func replayFinding(df *DataFile, stored StoredFinding) (FindingReplayResponse, error) {
+	resp := FindingReplayResponse{Original: stored, File: df.Label, Findings: []DiagnosticFinding{}}
+	spec := stored.Finding.Replay
+	if spec == nil {
+		return resp, fmt.Errorf("finding %s was recorded without a replay spec", stored.ID)
+	}
+	if stored.ContentHash != "" {
+		if sum, ok := df.contentHash(); ok {
+			resp.SameCapture = sum == stored.ContentHash
+		} else {
+			resp.SameCapture = df.Label == stored.File
+		}
+	} else {
+		resp.SameCapture = df.Label == stored.File
+	}
+
+	byName := map[string]int{}
+	for i, c := range df.Columns {
+		if _, dup := byName[c]; !dup {
+			byName[c] = i
+		}
+	}
+	cols := make([]parsedColumn, 0, len(spec.Columns))
+	for j, idx := range spec.Columns {
+		name := ""
+		if j < len(spec.ColumnNames) {
+			name = spec.ColumnNames[j]
+		}
+		if name == "" || (idx > 0 && idx < len(df.Columns) && df.Columns[idx] == name) {
+			if idx <= 0 || idx >= len(df.Columns) {
+				return resp, fmt.Errorf("column %d is not in this capture", idx)
+			}
+			cols = append(cols, parsePDHColumnBackend(df.Columns[idx], idx))
+			continue
+		}
+		moved, ok := byName[name]
+		if !ok || moved == 0 {
+			return resp, fmt.Errorf("column %q is not in this capture", name)
+		}
+		cols = append(cols, parsePDHColumnBackend(name, moved))
+	}
+	if len(cols) == 0 && len(spec.Columns) > 0 {
+		return resp, fmt.Errorf("none of the finding's columns are in this capture")
+	}
+
+	var start, end time.Time
+	if spec.Start > 0 {
+		start = time.UnixMilli(spec.Start).UTC()
+	}
+	if spec.End > 0 {
+		end = time.UnixMilli(spec.End).UTC()
+	}
+	t := spec.Template
+	t.Enabled = true
+	result, err := runDiagnosticsColumns(df, []DiagnosticTemplate{t}, cols, start, end, nil)
+	if err != nil {
+		return resp, err
+	}
+	resp.Warnings = result.Warnings
+	if !resp.SameCapture {
+		resp.Warnings = append(resp.Warnings, "replayed on a different capture than "+strconv.Quote(stored.File))
+	}
+	resp.Findings = result.Findings
+	for _, f := range result.Findings {
+		if f.ID == stored.ID {
+			resp.Reproduced = true
+		}
+	}
+	return resp, nil
+}
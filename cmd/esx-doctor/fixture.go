@@ -0,0 +1,384 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"math/rand"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// FixtureSpec describes a synthetic esxtop capture. The same spec and seed
+// always produce the same bytes, so a detector or performance check can
+// regenerate its input instead of keeping large CSVs around.
+type FixtureSpec struct {
+	Host      string
+	VCenter   string // writes resxtop-through-vCenter headers, \\vcenter\host\...
+	Start     time.Time
+	Interval  time.Duration
+	Samples   int
+	VMs       int
+	VCPUs     int // per VM
+	PCPUs     int
+	NUMANodes int
+	Adapters  int
+	NICs      int
+	// Columns pads the capture with idle filler counters up to this many
+	// data columns; smaller values leave the generated set as is.
+	Columns   int
+	Seed      int64
+	Anomalies []FixtureAnomaly
+}
+
+// FixtureAnomaly injects one pattern for Length samples from sample At:
+//
+//	spike      a VM's vCPUs and Group Cpu % Ready jump to ~20% (high ready)
+//	latency    a disk adapter's driver latency jumps to ~45 ms
+//	imbalance  one NUMA node runs at ~95% while the others idle
+//	zigzag     a VM's NUMA home node flips between two nodes every 2 samples
+//
+// Repeated anomalies of one kind move on to the next VM, adapter or node.
+type FixtureAnomaly struct {
+	Kind   string
+	At     int
+	Length int
+}
+
+// FixtureWindow reports where an anomaly landed, for checking findings
+// against it.
+type FixtureWindow struct {
+	Kind     string
+	Instance string
+	Start    time.Time
+	End      time.Time
+}
+
+var fixtureAnomalyKinds = []string{"spike", "latency", "imbalance", "zigzag"}
+
+const fixtureTimeLayout = "01/02/2006 15:04:05"
+
+type fixtureColumn struct {
+	name   string
+	base   float64
+	jitter float64
+	digits int
+	// windows override the base value while a row falls inside them.
+	windows []fixtureOverride
+}
+
+type fixtureOverride struct {
+	from, to int
+	value    func(row int, rng *rand.Rand) float64
+}
+
+// parseFixtureAnomaly reads "kind[@at][+length]", at and length in samples.
+// Without them the anomaly starts halfway through and lasts 24 samples.
+func parseFixtureAnomaly(s string, samples int) (FixtureAnomaly, error) {
+	a := FixtureAnomaly{At: samples / 2, Length: 24}
+	rest := strings.TrimSpace(s)
+	if i := strings.IndexByte(rest, '+'); i >= 0 {
+		n, err := strconv.Atoi(rest[i+1:])
+		if err != nil || n <= 0 {
+			return a, fmt.Errorf("anomaly %q: length must be a positive number of samples", s)
+		}
+		a.Length = n
+		rest = rest[:i]
+	}
+	if i := strings.IndexByte(rest, '@'); i >= 0 {
+		n, err := strconv.Atoi(rest[i+1:])
+		if err != nil || n < 0 {
+			return a, fmt.Errorf("anomaly %q: start must be a sample number", s)
+		}
+		a.At = n
+		rest = rest[:i]
+	}
+	a.Kind = strings.ToLower(rest)
+	for _, k := range fixtureAnomalyKinds {
+		if a.Kind == k {
+			return a, nil
+		}
+	}
+	return a, fmt.Errorf("anomaly %q: kind must be one of %s", s, strings.Join(fixtureAnomalyKinds, ", "))
+}
+
+// fixtureColumns lays out the columns in esxtop's group order and attaches
+// the anomaly overrides.
+func fixtureColumns(spec FixtureSpec) ([]fixtureColumn, []FixtureWindow, error) {
+	if spec.Samples <= 0 || spec.Interval <= 0 {
+		return nil, nil, fmt.Errorf("samples and interval must be positive")
+	}
+	if spec.VMs < 0 || spec.VCPUs < 1 || spec.PCPUs < 1 || spec.NUMANodes < 1 || spec.Adapters < 0 || spec.NICs < 0 {
+		return nil, nil, fmt.Errorf("vcpus, pcpus and numa nodes must be at least 1; counts cannot be negative")
+	}
+	host := spec.Host
+	if host == "" {
+		host = "esx01"
+	}
+	prefix := `\\` + host + `\`
+	if spec.VCenter != "" {
+		prefix = `\\` + spec.VCenter + `\` + host + `\`
+	}
+	var cols []fixtureColumn
+	add := func(name string, base, jitter float64) int {
+		cols = append(cols, fixtureColumn{name: prefix + name, base: base, jitter: jitter, digits: 2})
+		return len(cols) - 1
+	}
+
+	add(`Memory\Free MBytes`, 65536, 512)
+	nodeCols := make([]int, spec.NUMANodes)
+	for n := range nodeCols {
+		nodeCols[n] = add(fmt.Sprintf(`Numa Node(%d)\%% Processor Time`, n), 45, 8)
+	}
+	for n := 0; n < spec.NUMANodes; n++ {
+		add(fmt.Sprintf(`Numa Node(%d)\Free MBytes`, n), float64(32768/spec.NUMANodes), 256)
+	}
+	for c := 0; c < spec.PCPUs; c++ {
+		add(fmt.Sprintf(`Physical Cpu(%d)\%% Util Time`, c), 40, 15)
+	}
+
+	vmName := func(i int) string { return fmt.Sprintf("vm%02d", i+1) }
+	groupReady := make([]int, spec.VMs)
+	homeCols := make([]int, spec.VMs)
+	vcpuReady := make([][]int, spec.VMs)
+	for i := 0; i < spec.VMs; i++ {
+		gid := 1000 + i
+		group := fmt.Sprintf("(%d:%s)", gid, vmName(i))
+		vcpus := float64(spec.VCPUs)
+		add(`Group Cpu`+group+`\% Used`, 45*vcpus, 15*vcpus)
+		groupReady[i] = add(`Group Cpu`+group+`\% Ready`, 0.8*vcpus, 0.6*vcpus)
+		add(`Group Cpu`+group+`\% CoStop`, 0.05*vcpus, 0.05*vcpus)
+		add(`Group Cpu`+group+`\% Swap Wait`, 0, 0)
+		homeCols[i] = add(`Group Memory`+group+`\Numa Home Nodes`, float64(i%spec.NUMANodes), 0)
+		cols[homeCols[i]].digits = 0
+		add(`Group Memory`+group+`\Numa % Local`, 98, 2)
+	}
+	for i := 0; i < spec.VMs; i++ {
+		gid := 1000 + i
+		vcpuReady[i] = make([]int, spec.VCPUs)
+		for j := 0; j < spec.VCPUs; j++ {
+			vcpu := fmt.Sprintf("(%d:%s:%d:vmx-vcpu-%d:%s)", gid, vmName(i), 100000+i*100+j, j, vmName(i))
+			add(`Vcpu`+vcpu+`\% Used`, 45, 20)
+			vcpuReady[i][j] = add(`Vcpu`+vcpu+`\% Ready`, 0.8, 0.6)
+			add(`Vcpu`+vcpu+`\% CoStop`, 0.05, 0.05)
+		}
+	}
+	adapterCols := make([]int, spec.Adapters)
+	for a := range adapterCols {
+		adapterCols[a] = add(fmt.Sprintf(`Physical Disk Adapter(vmhba%d)\Average Driver MilliSec/Command`, a), 1.2, 0.8)
+		add(fmt.Sprintf(`Physical Disk Adapter(vmhba%d)\Commands/sec`, a), 1500, 400)
+	}
+	for n := 0; n < spec.NICs; n++ {
+		port := fmt.Sprintf("(DvsPortset-0:%d:vmnic%d)", 2214592512+n, n)
+		add(`Network Port`+port+`\MBits Transmitted/sec`, 800, 200)
+		add(`Network Port`+port+`\MBits Received/sec`, 600, 200)
+	}
+	for v := 0; len(cols) < spec.Columns; v++ {
+		add(fmt.Sprintf(`Interrupt Vector(0x%04x)\Interrupts/sec`, v), 50, 10)
+	}
+
+	level := func(base, jitter float64) func(int, *rand.Rand) float64 {
+		return func(_ int, rng *rand.Rand) float64 { return base + jitter*(rng.Float64()*2-1) }
+	}
+	var windows []FixtureWindow
+	used := map[string]int{}
+	for _, a := range spec.Anomalies {
+		if a.Length <= 0 || a.At < 0 || a.At+a.Length > spec.Samples {
+			return nil, nil, fmt.Errorf("%s anomaly at sample %d for %d samples does not fit in %d samples", a.Kind, a.At, a.Length, spec.Samples)
+		}
+		nth := used[a.Kind]
+		used[a.Kind]++
+		from, to := a.At, a.At+a.Length
+		var instance string
+		switch a.Kind {
+		case "spike":
+			if spec.VMs == 0 {
+				return nil, nil, fmt.Errorf("spike needs at least one VM")
+			}
+			vm := nth % spec.VMs
+			instance = vmName(vm)
+			for _, c := range vcpuReady[vm] {
+				cols[c].windows = append(cols[c].windows, fixtureOverride{from, to, level(20, 3)})
+			}
+			g := groupReady[vm]
+			cols[g].windows = append(cols[g].windows, fixtureOverride{from, to, level(20*float64(spec.VCPUs), 3)})
+		case "latency":
+			if spec.Adapters == 0 {
+				return nil, nil, fmt.Errorf("latency needs at least one adapter")
+			}
+			a := nth % spec.Adapters
+			instance = fmt.Sprintf("vmhba%d", a)
+			c := adapterCols[a]
+			cols[c].windows = append(cols[c].windows, fixtureOverride{from, to, level(45, 5)})
+		case "imbalance":
+			if spec.NUMANodes < 2 {
+				return nil, nil, fmt.Errorf("imbalance needs at least two NUMA nodes")
+			}
+			hot := nth % spec.NUMANodes
+			instance = strconv.Itoa(hot)
+			for n, c := range nodeCols {
+				v := level(5, 3)
+				if n == hot {
+					v = level(95, 3)
+				}
+				cols[c].windows = append(cols[c].windows, fixtureOverride{from, to, v})
+			}
+		case "zigzag":
+			if spec.VMs == 0 || spec.NUMANodes < 2 {
+				return nil, nil, fmt.Errorf("zigzag needs at least one VM and two NUMA nodes")
+			}
+			vm := nth % spec.VMs
+			instance = vmName(vm)
+			home := float64(vm % spec.NUMANodes)
+			other := float64((vm + 1) % spec.NUMANodes)
+			c := homeCols[vm]
+			cols[c].windows = append(cols[c].windows, fixtureOverride{from, to, func(row int, _ *rand.Rand) float64 {
+				if (row-from)/2%2 == 0 {
+					return other
+				}
+				return home
+			}})
+		}
+		windows = append(windows, FixtureWindow{
+			Kind:     a.Kind,
+			Instance: instance,
+			Start:    spec.Start.Add(time.Duration(from) * spec.Interval),
+			End:      spec.Start.Add(time.Duration(to-1) * spec.Interval),
+		})
+	}
+	return cols, windows, nil
+}
+
+// writeFixture streams the capture to w one row at a time, so the size of
+// the output is bounded only by the spec.
+func writeFixture(w io.Writer, spec FixtureSpec) ([]FixtureWindow, error) {
+	cols, windows, err := fixtureColumns(spec)
+	if err != nil {
+		return nil, err
+	}
+	bw := bufio.NewWriterSize(w, 1<<20)
+	buf := make([]byte, 0, 64*1024)
+	buf = append(buf, `"(PDH-CSV 4.0) (UTC)(0)"`...)
+	for _, c := range cols {
+		buf = append(buf, ',', '"')
+		buf = append(buf, c.name...)
+		buf = append(buf, '"')
+	}
+	buf = append(buf, '\n')
+	if _, err := bw.Write(buf); err != nil {
+		return nil, err
+	}
+
+	rng := rand.New(rand.NewSource(spec.Seed))
+	for row := 0; row < spec.Samples; row++ {
+		ts := spec.Start.Add(time.Duration(row) * spec.Interval).UTC()
+		buf = append(buf[:0], '"')
+		buf = ts.AppendFormat(buf, fixtureTimeLayout)
+		buf = append(buf, '"')
+		for i := range cols {
+			c := &cols[i]
+			v := c.base + c.jitter*(rng.Float64()*2-1)
+			for _, o := range c.windows {
+				if row >= o.from && row < o.to {
+					v = o.value(row, rng)
+				}
+			}
+			if v < 0 {
+				v = 0
+			}
+			buf = append(buf, ',', '"')
+			buf = strconv.AppendFloat(buf, v, 'f', c.digits, 64)
+			buf = append(buf, '"')
+		}
+		buf = append(buf, '\n')
+		if _, err := bw.Write(buf); err != nil {
+			return nil, err
+		}
+	}
+	return windows, bw.Flush()
+}
+
+type fixtureAnomalyFlags []string
+
+func (f *fixtureAnomalyFlags) String() string     { return strings.Join(*f, ",") }
+func (f *fixtureAnomalyFlags) Set(s string) error { *f = append(*f, s); return nil }
+
+// runGenFixture implements "esx-doctor gen-fixture".
+func runGenFixture(args []string) int {
+	fs := flag.NewFlagSet("gen-fixture", flag.ContinueOnError)
+	out := fs.String("out", "", "File to write (default stdout)")
+	host := fs.String("host", "esx01", "Host name in the column headers")
+	vcenter := fs.String("vcenter", "", "Write resxtop-through-vCenter headers naming this server (pair with a coarser -interval)")
+	start := fs.String("start", "2024-01-01T00:00:00Z", "Timestamp of the first sample (RFC 3339)")
+	interval := fs.Duration("interval", 5*time.Second, "Sample interval")
+	samples := fs.Int("samples", 720, "Number of samples")
+	vms := fs.Int("vms", 8, "Number of VMs")
+	vcpus := fs.Int("vcpus", 4, "vCPUs per VM")
+	pcpus := fs.Int("pcpus", 16, "Physical CPUs")
+	numa := fs.Int("numa", 2, "NUMA nodes")
+	adapters := fs.Int("adapters", 2, "Disk adapters")
+	nics := fs.Int("nics", 2, "Physical NICs")
+	columns := fs.Int("columns", 0, "Pad with idle counters up to this many data columns")
+	seed := fs.Int64("seed", 1, "Random seed; the same seed and flags give the same file")
+	var anomalies fixtureAnomalyFlags
+	fs.Var(&anomalies, "anomaly", "Inject kind[@sample][+samples]; kinds: "+strings.Join(fixtureAnomalyKinds, ", ")+" (repeatable)")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: esx-doctor gen-fixture [-samples N] [-vms N] [-columns N] [-seed N] [-anomaly spike@120+24 ...] [-out x.csv]")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() != 0 {
+		fs.Usage()
+		return 2
+	}
+	t0, err := time.Parse(time.RFC3339, *start)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "invalid -start: %v\n", err)
+		return 2
+	}
+	spec := FixtureSpec{
+		Host: *host, VCenter: *vcenter, Start: t0, Interval: *interval, Samples: *samples,
+		VMs: *vms, VCPUs: *vcpus, PCPUs: *pcpus, NUMANodes: *numa,
+		Adapters: *adapters, NICs: *nics, Columns: *columns, Seed: *seed,
+	}
+	for _, s := range anomalies {
+		a, err := parseFixtureAnomaly(s, *samples)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 2
+		}
+		spec.Anomalies = append(spec.Anomalies, a)
+	}
+
+	var w io.Writer = os.Stdout
+	var f *os.File
+	if *out != "" {
+		if f, err = os.Create(*out); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		w = f
+	}
+	windows, err := writeFixture(w, spec)
+	if f != nil {
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "gen-fixture failed: %v\n", err)
+		if f != nil {
+			os.Remove(*out)
+		}
+		return 1
+	}
+	for _, win := range windows {
+		fmt.Fprintf(os.Stderr, "%s\t%s\t%s\t%s\n", win.Kind, win.Instance, win.Start.Format(time.RFC3339), win.End.Format(time.RFC3339))
+	}
+	return 0
+}
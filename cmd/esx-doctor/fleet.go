@@ -0,0 +1,325 @@
+package main
+
+import (
+	"fmt"
+	"hash/fnv"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// FleetCapture is one capture in the -fleet directory and the outcome of
+// running the default templates over it.
+type FleetCapture struct {
+	ID           string         `json:"id"`
+	Path         string         `json:"path"`
+	Host         string         `json:"host"`
+	Status       string         `json:"status"` // queued, indexing, analyzing, done, failed
+	Size         int64          `json:"size"`
+	ModTime      int64          `json:"modTime"`
+	Rows         int64          `json:"rows,omitempty"`
+	Start        int64          `json:"start,omitempty"`
+	End          int64          `json:"end,omitempty"`
+	HealthScore  int            `json:"healthScore"`
+	FindingCount int            `json:"findingCount"`
+	BySeverity   map[string]int `json:"bySeverity,omitempty"`
+	Error        string         `json:"error,omitempty"`
+	AnalyzedAt   int64          `json:"analyzedAt,omitempty"`
+}
+
+type FleetResponse struct {
+	Dir       string         `json:"dir"`
+	ScannedAt int64          `json:"scannedAt"`
+	Status    map[string]int `json:"status"`
+	// Captures lists analyzed captures worst health first, then the ones
+	// still in progress or failed.
+	Captures []FleetCapture `json:"captures"`
+}
+
+type FleetCaptureDetail struct {
+	FleetCapture
+	Findings []DiagnosticFinding `json:"findings"`
+}
+
+type fleetEntry struct {
+	info     FleetCapture
+	df       *DataFile
+	findings []DiagnosticFinding
+}
+
+// fleetStore watches a directory of captures from many hosts. Each new or
+// changed CSV is indexed and run through the enabled templates on a small
+// worker pool, so the overview fills in while the server is already up.
+type fleetStore struct {
+	mu        sync.RWMutex
+	dir       string
+	templates *diagnosticTemplateStore
+	history   *historyStore
+	events    *eventLog
+	entries   map[string]*fleetEntry // by path
+	queue     chan string
+	scannedAt time.Time
+}
+
+func newFleetStore(dir string, templates *diagnosticTemplateStore, history *historyStore, events *eventLog, workers int) (*fleetStore, error) {
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, err
+	}
+	st, err := os.Stat(abs)
+	if err != nil {
+		return nil, err
+	}
+	if !st.IsDir() {
+		return nil, fmt.Errorf("%s is not a directory", abs)
+	}
+	if workers < 1 {
+		workers = 1
+	}
+	f := &fleetStore{
+		dir:       abs,
+		templates: templates,
+		history:   history,
+		events:    events,
+		entries:   map[string]*fleetEntry{},
+		queue:     make(chan string, 1024),
+	}
+	for i := 0; i < workers; i++ {
+		go f.worker()
+	}
+	return f, nil
+}
+
+func fleetCaptureID(path string) string {
+	h := fnv.New64a()
+	h.Write([]byte(path))
+	return strconv.FormatUint(h.Sum64(), 36)
+}
+
+// scan picks up new and changed CSVs under dir and forgets removed ones.
+func (f *fleetStore) scan() error {
+	found := map[string]fs.FileInfo{}
+	err := filepath.WalkDir(f.dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			if path != f.dir && strings.HasPrefix(d.Name(), ".") {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !strings.EqualFold(filepath.Ext(d.Name()), ".csv") {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return nil
+		}
+		found[path] = info
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	var todo []string
+	f.mu.Lock()
+	for path := range f.entries {
+		if _, ok := found[path]; !ok {
+			delete(f.entries, path)
+		}
+	}
+	for path, info := range found {
+		e, ok := f.entries[path]
+		if ok && e.info.Size == info.Size() && e.info.ModTime == info.ModTime().UnixMilli() {
+			continue
+		}
+		f.entries[path] = &fleetEntry{info: FleetCapture{
+			ID:      fleetCaptureID(path),
+			Path:    path,
+			Host:    strings.TrimSuffix(filepath.Base(path), filepath.Ext(path)),
+			Status:  "queued",
+			Size:    info.Size(),
+			ModTime: info.ModTime().UnixMilli(),
+		}}
+		todo = append(todo, path)
+	}
+	f.scannedAt = time.Now()
+	f.mu.Unlock()
+
+	sort.Strings(todo)
+	go func() {
+		for _, path := range todo {
+			f.queue <- path
+		}
+	}()
+	return nil
+}
+
+// watch rescans every interval until the process exits.
+func (f *fleetStore) watch(interval time.Duration) {
+	if interval <= 0 {
+		return
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		if err := f.scan(); err != nil {
+			log.Printf("fleet rescan failed: %v", err)
+		}
+	}
+}
+
+func (f *fleetStore) worker() {
+	for path := range f.queue {
+		f.analyze(path)
+	}
+}
+
+func (f *fleetStore) analyze(path string) {
+	if !f.setStatus(path, "indexing") {
+		return
+	}
+	df, err := loadOrBuildIndex(path)
+	if err != nil {
+		f.fail(path, fmt.Errorf("index build failed: %w", err))
+		return
+	}
+	if df.Rows == 0 {
+		// An empty capture would otherwise rank as perfectly healthy.
+		f.fail(path, fmt.Errorf("no data rows"))
+		return
+	}
+	df.Provenance.Source, df.Provenance.Origin = "fleet", path
+	if !f.setStatus(path, "analyzing") {
+		return
+	}
+	selected, skipped := f.templates.byID(nil)
+	resp, err := runDiagnostics(df, selected, time.Time{}, time.Time{})
+	if err != nil {
+		f.fail(path, err)
+		return
+	}
+	resp.Warnings = append(skipped, resp.Warnings...)
+	if f.history != nil {
+		if _, err := f.history.add(df, selected, time.Time{}, time.Time{}, resp); err != nil {
+			log.Printf("recording fleet run history failed: %v", err)
+		}
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	e, ok := f.entries[path]
+	if !ok {
+		return
+	}
+	e.df, e.findings = df, resp.Findings
+	info := &e.info
+	info.Status = "done"
+	info.Error = ""
+	if host := captureHosts(df); host != "" {
+		info.Host = host
+	}
+	info.Rows = df.Rows
+	info.Start, info.End = unixMilliOrZero(df.StartTime), unixMilliOrZero(df.EndTime)
+	info.HealthScore = resp.HealthScore
+	info.FindingCount = len(resp.Findings)
+	info.BySeverity = map[string]int{}
+	for _, finding := range resp.Findings {
+		info.BySeverity[strings.ToLower(finding.Severity)]++
+	}
+	info.AnalyzedAt = time.Now().UnixMilli()
+	f.events.publish(nil, "fleet", *info)
+}
+
+// setStatus moves path to status, reporting false when the capture was
+// removed meanwhile.
+func (f *fleetStore) setStatus(path, status string) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	e, ok := f.entries[path]
+	if ok {
+		e.info.Status = status
+	}
+	return ok
+}
+
+func (f *fleetStore) fail(path string, err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if e, ok := f.entries[path]; ok {
+		e.info.Status, e.info.Error = "failed", err.Error()
+		e.df, e.findings = nil, nil
+		f.events.publish(nil, "fleet", e.info)
+	}
+}
+
+func (f *fleetStore) overview() FleetResponse {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	out := FleetResponse{Dir: f.dir, ScannedAt: unixMilliOrZero(f.scannedAt), Status: map[string]int{}, Captures: make([]FleetCapture, 0, len(f.entries))}
+	for _, e := range f.entries {
+		out.Status[e.info.Status]++
+		out.Captures = append(out.Captures, e.info)
+	}
+	sort.Slice(out.Captures, func(i, j int) bool {
+		a, b := out.Captures[i], out.Captures[j]
+		if (a.Status == "done") != (b.Status == "done") {
+			return a.Status == "done"
+		}
+		if a.HealthScore != b.HealthScore {
+			return a.HealthScore < b.HealthScore
+		}
+		if a.Host != b.Host {
+			return a.Host < b.Host
+		}
+		return a.Path < b.Path
+	})
+	return out
+}
+
+func (f *fleetStore) byID(id string) (*fleetEntry, bool) {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	for _, e := range f.entries {
+		if e.info.ID == id {
+			return e, true
+		}
+	}
+	return nil, false
+}
+
+func (f *fleetStore) detail(id string) (FleetCaptureDetail, bool) {
+	e, ok := f.byID(id)
+	if !ok {
+		return FleetCaptureDetail{}, false
+	}
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	findings := e.findings
+	if findings == nil {
+		findings = []DiagnosticFinding{}
+	}
+	return FleetCaptureDetail{FleetCapture: e.info, Findings: findings}, true
+}
+
+// capture returns the indexed file behind id, once it has been analyzed.
+func (f *fleetStore) capture(id string) (*DataFile, error) {
+	e, ok := f.byID(id)
+	if !ok {
+		return nil, fmt.Errorf("unknown capture: %s", id)
+	}
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	if e.df == nil {
+		return nil, fmt.Errorf("capture %s is %s", id, e.info.Status)
+	}
+	return e.df, nil
+}
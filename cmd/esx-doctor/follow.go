@@ -0,0 +1,175 @@
+package main
+
+import (
+	"log"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Following keeps the index of a capture that is still being written up to
+// date without waiting for a request to notice: a follower per path polls
+// the file, extends the index through the usual refresh (DataFile.latest),
+// and publishes a "follow" event when rows arrive, so a tab can fetch just
+// the new tail with /api/series?start=<previous end>. Sessions holding an
+// older DataFile of the capture pick up the follower's work on their next
+// Get. -follow follows the startup file, /api/open {"follow": true} any
+// other, and -live its own capture.
+
+// FollowStatus is the "follow" block of /api/meta and an entry of
+// /api/follow.
+type FollowStatus struct {
+	File       string `json:"file"`
+	Rows       int64  `json:"rows"`
+	End        int64  `json:"end"`
+	Interval   int64  `json:"intervalMs"`
+	Started    int64  `json:"started"`
+	LastGrowth int64  `json:"lastGrowth,omitempty"`
+	// Pending counts the bytes of a row still being written.
+	Pending int64 `json:"pendingBytes,omitempty"`
+}
+
+// FollowEvent is the data of a "follow" event.
+type FollowEvent struct {
+	File  string `json:"file"`
+	Rows  int64  `json:"rows"`
+	Added int64  `json:"added"`
+	Start int64  `json:"start"`
+	End   int64  `json:"end"`
+	// Rebuilt is set when the file was replaced rather than appended to,
+	// so earlier rows may have changed too.
+	Rebuilt bool `json:"rebuilt,omitempty"`
+}
+
+type follower struct {
+	mu         sync.Mutex
+	df         *DataFile
+	started    time.Time
+	lastGrowth time.Time
+}
+
+type followStore struct {
+	mu        sync.Mutex
+	interval  time.Duration
+	followers map[string]*follower
+	events    *eventLog
+}
+
+func newFollowStore(interval time.Duration, events *eventLog) *followStore {
+	// Polling faster than refresh allows would only find nothing new.
+	interval = max(interval, refreshCheckInterval)
+	return &followStore{interval: interval, followers: map[string]*follower{}, events: events}
+}
+
+// follow starts following df, which must have been indexed with
+// buildIndexFollow. When the path is followed already, the follower's
+// newer DataFile is returned instead.
+func (s *followStore) follow(df *DataFile) *DataFile {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if f, ok := s.followers[df.Path]; ok {
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		return f.df
+	}
+	f := &follower{df: df, started: time.Now()}
+	s.followers[df.Path] = f
+	go s.run(df.Path, f)
+	log.Printf("following %s", df.Label)
+	return df
+}
+
+// current returns the newest DataFile of a followed path.
+func (s *followStore) current(path string) (*DataFile, bool) {
+	s.mu.Lock()
+	f, ok := s.followers[path]
+	s.mu.Unlock()
+	if !ok {
+		return nil, false
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.df, true
+}
+
+func (s *followStore) run(path string, f *follower) {
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		if _, err := os.Stat(path); err != nil {
+			log.Printf("stopped following %s: %v", path, err)
+			s.mu.Lock()
+			delete(s.followers, path)
+			s.mu.Unlock()
+			return
+		}
+		f.mu.Lock()
+		cur := f.df
+		f.mu.Unlock()
+		next := cur.latest()
+		if next == cur {
+			continue
+		}
+		f.mu.Lock()
+		f.df = next
+		if next.Rows != cur.Rows {
+			f.lastGrowth = time.Now()
+		}
+		f.mu.Unlock()
+		if next.Rows != cur.Rows {
+			s.events.publish(nil, "follow", FollowEvent{
+				File:    next.Label,
+				Rows:    next.Rows,
+				Added:   next.Rows - cur.Rows,
+				Start:   unixMilliOrZero(next.StartTime),
+				End:     unixMilliOrZero(next.EndTime),
+				Rebuilt: next.Provenance.Index != "extended",
+			})
+		}
+	}
+}
+
+func (s *followStore) statusOf(f *follower) FollowStatus {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return FollowStatus{
+		File:       f.df.Label,
+		Rows:       f.df.Rows,
+		End:        unixMilliOrZero(f.df.EndTime),
+		Interval:   s.interval.Milliseconds(),
+		Started:    unixMilliOrZero(f.started),
+		LastGrowth: unixMilliOrZero(f.lastGrowth),
+		Pending:    max(f.df.Size-f.df.DataEndOffset, 0),
+	}
+}
+
+// status reports whether df's capture is followed.
+func (s *followStore) status(df *DataFile) (FollowStatus, bool) {
+	if df == nil || !df.Follow {
+		return FollowStatus{}, false
+	}
+	s.mu.Lock()
+	f, ok := s.followers[df.Path]
+	s.mu.Unlock()
+	if !ok {
+		return FollowStatus{}, false
+	}
+	return s.statusOf(f), true
+}
+
+// list returns every followed capture by file name.
+func (s *followStore) list() []FollowStatus {
+	s.mu.Lock()
+	followers := make([]*follower, 0, len(s.followers))
+	for _, f := range s.followers {
+		followers = append(followers, f)
+	}
+	s.mu.Unlock()
+	out := make([]FollowStatus, 0, len(followers))
+	for _, f := range followers {
+		out = append(out, s.statusOf(f))
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].File < out[j].File })
+	return out
+}
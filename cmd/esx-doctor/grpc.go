@@ -0,0 +1,691 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// -grpc serves the service in esxdoctor.proto on the HTTP port, over
+// cleartext HTTP/2: requests with an application/grpc content type go
+// here, the rest to the HTTP API. Like msgpack.go it is written against
+// the wire format directly, so the build stays on the standard library.
+// Open, Meta and RunDiagnostics are answered by the HTTP handlers
+// themselves (same sessions, -read-only, -shared locks and limits);
+// Series streams rows straight from the capture in one pass.
+
+const (
+	grpcServicePrefix = "/esxdoctor.v1.EsxDoctor/"
+	// grpcMaxMessage bounds a request message, as grpc-go does.
+	grpcMaxMessage       = 4 << 20
+	grpcDefaultChunkRows = 1000
+	grpcMaxChunkRows     = 100000
+	grpcMaxSeriesColumns = 2000
+)
+
+// gRPC status codes used here.
+const (
+	grpcOK                 = 0
+	grpcInvalidArgument    = 3
+	grpcNotFound           = 5
+	grpcPermissionDenied   = 7
+	grpcResourceExhausted  = 8
+	grpcFailedPrecondition = 9
+	grpcAborted            = 10
+	grpcUnimplemented      = 12
+	grpcInternal           = 13
+	grpcUnavailable        = 14
+)
+
+type grpcError struct {
+	code int
+	msg  string
+}
+
+func (e *grpcError) Error() string { return e.msg }
+
+func grpcErrorf(code int, format string, args ...any) *grpcError {
+	return &grpcError{code: code, msg: fmt.Sprintf(format, args...)}
+}
+
+// grpcCodeForStatus maps the HTTP API's answers to gRPC codes.
+func grpcCodeForStatus(status int) int {
+	switch status {
+	case http.StatusBadRequest:
+		return grpcInvalidArgument
+	case http.StatusForbidden:
+		return grpcPermissionDenied
+	case http.StatusNotFound:
+		return grpcNotFound
+	case http.StatusConflict:
+		return grpcAborted
+	case http.StatusRequestEntityTooLarge:
+		return grpcResourceExhausted
+	case http.StatusServiceUnavailable, http.StatusTooManyRequests:
+		return grpcUnavailable
+	}
+	return grpcInternal
+}
+
+type grpcServer struct {
+	api      http.Handler
+	sessions *SessionStore
+	scans    *scanLimiter
+}
+
+// grpcHandler sends gRPC calls to g and everything else to api.
+func grpcHandler(g *grpcServer, api http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.ProtoMajor == 2 && strings.HasPrefix(r.Header.Get("Content-Type"), "application/grpc") {
+			g.ServeHTTP(w, r)
+			return
+		}
+		api.ServeHTTP(w, r)
+	})
+}
+
+func (g *grpcServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/grpc")
+	w.Header().Set("Trailer", "Grpc-Status, Grpc-Message")
+	if r.Method != http.MethodPost {
+		g.finish(w, grpcErrorf(grpcUnimplemented, "gRPC calls are POSTs"))
+		return
+	}
+	if enc := r.Header.Get("Grpc-Encoding"); enc != "" && enc != "identity" {
+		g.finish(w, grpcErrorf(grpcUnimplemented, "grpc-encoding %s is not supported", enc))
+		return
+	}
+	method, ok := strings.CutPrefix(r.URL.Path, grpcServicePrefix)
+	if !ok {
+		g.finish(w, grpcErrorf(grpcUnimplemented, "unknown service in %s", r.URL.Path))
+		return
+	}
+	req, err := readGRPCMessage(r.Body)
+	if err != nil {
+		g.finish(w, err)
+		return
+	}
+	sess := g.sessions.SessionForRequest(&grpcRecorder{header: http.Header{}}, r)
+	w.Header().Set("X-Esx-Session-Id", sess.id)
+
+	var resp []byte
+	switch method {
+	case "Open":
+		resp, err = g.open(r, sess, req)
+	case "Meta":
+		resp, err = g.meta(r, sess, req)
+	case "RunDiagnostics":
+		resp, err = g.runDiagnostics(r, sess, req)
+	case "Series":
+		g.finish(w, g.series(w, r, sess, req))
+		return
+	default:
+		err = grpcErrorf(grpcUnimplemented, "unknown method %s", method)
+	}
+	if err == nil {
+		w.WriteHeader(http.StatusOK)
+		err = writeGRPCMessage(w, resp)
+	}
+	g.finish(w, err)
+}
+
+// finish sends the status trailers.
+func (g *grpcServer) finish(w http.ResponseWriter, err error) {
+	code, msg := grpcOK, ""
+	if err != nil {
+		var ge *grpcError
+		if errors.As(err, &ge) {
+			code, msg = ge.code, ge.msg
+		} else {
+			code, msg = grpcInternal, err.Error()
+		}
+	}
+	w.Header().Set("Grpc-Status", strconv.Itoa(code))
+	if msg != "" {
+		w.Header().Set("Grpc-Message", grpcPercentEncode(msg))
+	}
+}
+
+// grpcPercentEncode encodes grpc-message as the gRPC spec asks: printable
+// ASCII except '%' stays, every other byte becomes %XX.
+func grpcPercentEncode(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if c >= 0x20 && c <= 0x7e && c != '%' {
+			b.WriteByte(c)
+			continue
+		}
+		fmt.Fprintf(&b, "%%%02X", c)
+	}
+	return b.String()
+}
+
+// readGRPCMessage reads the one length-prefixed message of a unary or
+// server-streaming call.
+func readGRPCMessage(r io.Reader) ([]byte, error) {
+	var prefix [5]byte
+	if _, err := io.ReadFull(r, prefix[:]); err != nil {
+		if errors.Is(err, io.EOF) {
+			// An empty body is an empty message.
+			return nil, nil
+		}
+		return nil, grpcErrorf(grpcInvalidArgument, "reading request: %v", err)
+	}
+	if prefix[0] != 0 {
+		return nil, grpcErrorf(grpcUnimplemented, "compressed requests are not supported")
+	}
+	n := binary.BigEndian.Uint32(prefix[1:])
+	if n > grpcMaxMessage {
+		return nil, grpcErrorf(grpcResourceExhausted, "request of %d bytes exceeds %d", n, grpcMaxMessage)
+	}
+	msg := make([]byte, n)
+	if _, err := io.ReadFull(r, msg); err != nil {
+		return nil, grpcErrorf(grpcInvalidArgument, "reading request: %v", err)
+	}
+	return msg, nil
+}
+
+func writeGRPCMessage(w io.Writer, msg []byte) error {
+	var prefix [5]byte
+	binary.BigEndian.PutUint32(prefix[1:], uint32(len(msg)))
+	if _, err := w.Write(prefix[:]); err != nil {
+		return err
+	}
+	_, err := w.Write(msg)
+	return err
+}
+
+// grpcRecorder collects a response of the HTTP API.
+type grpcRecorder struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func (rec *grpcRecorder) Header() http.Header { return rec.header }
+
+func (rec *grpcRecorder) WriteHeader(status int) {
+	if rec.status == 0 {
+		rec.status = status
+	}
+}
+
+func (rec *grpcRecorder) Write(b []byte) (int, error) {
+	if rec.status == 0 {
+		rec.status = http.StatusOK
+	}
+	return rec.body.Write(b)
+}
+
+// callAPI runs one HTTP API request in the caller's session and decodes
+// its JSON answer into out. The caller's headers (the -user-header, for
+// instance) and address come along.
+func (g *grpcServer) callAPI(r *http.Request, sess *Session, method, target string, body, out any) error {
+	var payload io.Reader = http.NoBody
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		payload = bytes.NewReader(data)
+	}
+	inner, err := http.NewRequestWithContext(r.Context(), method, target, payload)
+	if err != nil {
+		return err
+	}
+	for k, v := range r.Header {
+		if strings.HasPrefix(k, "Grpc-") || k == "Content-Type" || k == "Te" {
+			continue
+		}
+		inner.Header[k] = v
+	}
+	inner.Header.Set("Content-Type", "application/json")
+	inner.Header.Set("X-ESX-Session-ID", sess.id)
+	inner.RemoteAddr = r.RemoteAddr
+	rec := &grpcRecorder{header: http.Header{}}
+	g.api.ServeHTTP(rec, inner)
+	if rec.status != http.StatusOK {
+		var e struct {
+			Error string `json:"error"`
+		}
+		if json.Unmarshal(rec.body.Bytes(), &e) != nil || e.Error == "" {
+			e.Error = http.StatusText(rec.status)
+		}
+		return grpcErrorf(grpcCodeForStatus(rec.status), "%s", e.Error)
+	}
+	if err := json.Unmarshal(rec.body.Bytes(), out); err != nil {
+		return grpcErrorf(grpcInternal, "decoding %s: %v", target, err)
+	}
+	return nil
+}
+
+func (g *grpcServer) open(r *http.Request, sess *Session, req []byte) ([]byte, error) {
+	var in struct {
+		Path   string `json:"path"`
+		Stitch bool   `json:"stitch"`
+		Follow bool   `json:"follow"`
+	}
+	force := false
+	err := decodeProto(req, func(f protoField) error {
+		switch f.num {
+		case 1:
+			in.Path = f.str()
+		case 2:
+			in.Stitch = f.v != 0
+		case 3:
+			force = f.v != 0
+		case 4:
+			in.Follow = f.v != 0
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	target := "/api/open"
+	if force {
+		target += "?force=1"
+	}
+	var out struct {
+		File  string `json:"file"`
+		Rows  int64  `json:"rows"`
+		Start int64  `json:"start"`
+		End   int64  `json:"end"`
+	}
+	if err := g.callAPI(r, sess, http.MethodPost, target, in, &out); err != nil {
+		return nil, err
+	}
+	var b protoBuffer
+	b.string(1, sess.id)
+	b.bool(2, true)
+	b.string(3, out.File)
+	b.int64(4, out.Rows)
+	b.int64(5, out.Start)
+	b.int64(6, out.End)
+	return b.bytes(), nil
+}
+
+func (g *grpcServer) meta(r *http.Request, sess *Session, req []byte) ([]byte, error) {
+	columns := false
+	if err := decodeProto(req, func(f protoField) error {
+		if f.num == 1 {
+			columns = f.v != 0
+		}
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+	target := "/api/meta?columns=0"
+	if columns {
+		target = "/api/meta"
+	}
+	var out struct {
+		Loaded      bool     `json:"loaded"`
+		File        string   `json:"file"`
+		Rows        int64    `json:"rows"`
+		Start       int64    `json:"start"`
+		End         int64    `json:"end"`
+		ColumnCount int      `json:"columnCount"`
+		Columns     []string `json:"columns"`
+	}
+	if err := g.callAPI(r, sess, http.MethodGet, target, nil, &out); err != nil {
+		return nil, err
+	}
+	var b protoBuffer
+	b.string(1, sess.id)
+	b.bool(2, out.Loaded)
+	b.string(3, out.File)
+	b.int64(4, out.Rows)
+	b.int64(5, out.Start)
+	b.int64(6, out.End)
+	b.int64(7, int64(out.ColumnCount))
+	for _, c := range out.Columns {
+		b.repeatedString(8, c)
+	}
+	return b.bytes(), nil
+}
+
+func (g *grpcServer) runDiagnostics(r *http.Request, sess *Session, req []byte) ([]byte, error) {
+	in := struct {
+		TemplateIDs []string `json:"templateIds"`
+		Start       int64    `json:"start"`
+		End         int64    `json:"end"`
+		Bookmark    string   `json:"bookmark"`
+		Lang        string   `json:"lang"`
+	}{TemplateIDs: []string{}}
+	err := decodeProto(req, func(f protoField) error {
+		switch f.num {
+		case 1:
+			in.TemplateIDs = append(in.TemplateIDs, f.str())
+		case 2:
+			in.Start = int64(f.v)
+		case 3:
+			in.End = int64(f.v)
+		case 4:
+			in.Bookmark = f.str()
+		case 5:
+			in.Lang = f.str()
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	var out DiagnosticRunResponse
+	if err := g.callAPI(r, sess, http.MethodPost, "/api/diagnostics/run", in, &out); err != nil {
+		return nil, err
+	}
+	var b protoBuffer
+	b.string(1, out.RunID)
+	for _, f := range out.Findings {
+		var fb protoBuffer
+		fb.string(1, f.TemplateID)
+		fb.string(2, f.Title)
+		fb.string(3, f.Severity)
+		fb.string(4, f.AttributeLabel)
+		for _, inst := range f.Instances {
+			fb.repeatedString(5, inst)
+		}
+		fb.int64(6, f.Start)
+		fb.int64(7, f.End)
+		fb.string(8, f.Summary)
+		fb.string(9, f.ID)
+		b.message(2, fb.bytes())
+	}
+	b.int64(3, int64(out.Templates))
+	b.int64(4, out.RowsScanned)
+	b.int64(5, int64(out.HealthScore))
+	b.int64(6, out.DurationMs)
+	for _, warn := range out.Warnings {
+		b.repeatedString(7, warn)
+	}
+	return b.bytes(), nil
+}
+
+// series streams the requested columns, chunkRows rows per message. It
+// holds a scan slot for the whole stream, as /api/series does for a read.
+func (g *grpcServer) series(w http.ResponseWriter, r *http.Request, sess *Session, req []byte) error {
+	var cols []int
+	var startMs, endMs int64
+	chunkRows := grpcDefaultChunkRows
+	err := decodeProto(req, func(f protoField) error {
+		switch f.num {
+		case 1:
+			return f.varints(func(v uint64) { cols = append(cols, int(int32(v))) })
+		case 2:
+			startMs = int64(f.v)
+		case 3:
+			endMs = int64(f.v)
+		case 4:
+			if n := int(int32(f.v)); n > 0 {
+				chunkRows = min(n, grpcMaxChunkRows)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	df := sess.Get()
+	if df == nil {
+		return grpcErrorf(grpcFailedPrecondition, "no file loaded")
+	}
+	if len(cols) == 0 {
+		return grpcErrorf(grpcInvalidArgument, "no columns selected")
+	}
+	if len(cols) > grpcMaxSeriesColumns {
+		return grpcErrorf(grpcInvalidArgument, "too many columns (max %d)", grpcMaxSeriesColumns)
+	}
+	for _, idx := range cols {
+		if idx <= 0 || idx >= len(df.Columns) {
+			return grpcErrorf(grpcInvalidArgument, "column %d out of range", idx)
+		}
+	}
+	var start, end time.Time
+	if startMs > 0 {
+		start = time.UnixMilli(startMs).UTC()
+	}
+	if endMs > 0 {
+		end = time.UnixMilli(endMs).UTC()
+	}
+
+	release, ok := g.scans.acquire(r.Context())
+	if !ok {
+		return grpcErrorf(grpcUnavailable, "server busy, retry later")
+	}
+	defer release()
+
+	startOffset, _ := df.findOffset(start)
+	f, data, err := df.openData(startOffset)
+	if err != nil {
+		return grpcErrorf(grpcInternal, "%v", err)
+	}
+	defer f.Close()
+	lines := getLineReader(data)
+	defer lines.release()
+
+	w.WriteHeader(http.StatusOK)
+	flush := http.NewResponseController(w)
+	times := make([]int64, 0, chunkRows)
+	values := make([][]float64, len(cols))
+	for i := range values {
+		values[i] = make([]float64, 0, chunkRows)
+	}
+	first := true
+	send := func() error {
+		if len(times) == 0 && !first {
+			return nil
+		}
+		var b protoBuffer
+		b.packedInt64(1, times)
+		for i, idx := range cols {
+			var cb protoBuffer
+			cb.int64(1, int64(idx))
+			if first {
+				cb.string(2, df.Columns[idx])
+			}
+			cb.packedDouble(3, values[i])
+			b.message(2, cb.bytes())
+		}
+		first = false
+		times = times[:0]
+		for i := range values {
+			values[i] = values[i][:0]
+		}
+		if err := writeGRPCMessage(w, b.bytes()); err != nil {
+			return err
+		}
+		return flush.Flush()
+	}
+	for {
+		if err := r.Context().Err(); err != nil {
+			return grpcErrorf(grpcAborted, "client went away")
+		}
+		line, rerr := lines.next()
+		if rerr != nil && !errors.Is(rerr, io.EOF) {
+			return grpcErrorf(grpcInternal, "%v", rerr)
+		}
+		if len(line) == 0 && errors.Is(rerr, io.EOF) {
+			break
+		}
+		record, perr := lines.record(line)
+		if perr == nil && len(record) > 0 {
+			if ts, _, terr := parseTimeValue(record[0]); terr == nil {
+				if !end.IsZero() && ts.After(end) {
+					break
+				}
+				if start.IsZero() || !ts.Before(start) {
+					times = append(times, ts.UnixMilli())
+					for i, idx := range cols {
+						v, ok := parseFloatAt(record, idx)
+						if !ok {
+							v = math.NaN()
+						}
+						values[i] = append(values[i], v)
+					}
+					if len(times) >= chunkRows {
+						if err := send(); err != nil {
+							return err
+						}
+					}
+				}
+			}
+		}
+		if errors.Is(rerr, io.EOF) {
+			break
+		}
+	}
+	// The last chunk, or the one carrying the names when no row matched.
+	return send()
+}
+
+// protoBuffer encodes protobuf fields; like proto3, zero scalars are left
+// out.
+type protoBuffer struct {
+	b []byte
+}
+
+func (p *protoBuffer) bytes() []byte { return p.b }
+
+func (p *protoBuffer) tag(num, wire int) {
+	p.b = binary.AppendUvarint(p.b, uint64(num)<<3|uint64(wire))
+}
+
+func (p *protoBuffer) int64(num int, v int64) {
+	if v == 0 {
+		return
+	}
+	p.tag(num, 0)
+	p.b = binary.AppendUvarint(p.b, uint64(v))
+}
+
+func (p *protoBuffer) bool(num int, v bool) {
+	if v {
+		p.int64(num, 1)
+	}
+}
+
+func (p *protoBuffer) string(num int, s string) {
+	if s != "" {
+		p.repeatedString(num, s)
+	}
+}
+
+// repeatedString writes s even when empty, keeping positions in a
+// repeated field.
+func (p *protoBuffer) repeatedString(num int, s string) {
+	p.tag(num, 2)
+	p.b = binary.AppendUvarint(p.b, uint64(len(s)))
+	p.b = append(p.b, s...)
+}
+
+func (p *protoBuffer) message(num int, m []byte) {
+	p.tag(num, 2)
+	p.b = binary.AppendUvarint(p.b, uint64(len(m)))
+	p.b = append(p.b, m...)
+}
+
+func (p *protoBuffer) packedInt64(num int, vs []int64) {
+	if len(vs) == 0 {
+		return
+	}
+	var body []byte
+	for _, v := range vs {
+		body = binary.AppendUvarint(body, uint64(v))
+	}
+	p.message(num, body)
+}
+
+func (p *protoBuffer) packedDouble(num int, vs []float64) {
+	if len(vs) == 0 {
+		return
+	}
+	p.tag(num, 2)
+	p.b = binary.AppendUvarint(p.b, uint64(len(vs)*8))
+	for _, v := range vs {
+		p.b = binary.LittleEndian.AppendUint64(p.b, math.Float64bits(v))
+	}
+}
+
+// protoField is one decoded field: v holds varint and fixed values, data
+// the bytes of length-delimited ones.
+type protoField struct {
+	num  int
+	wire int
+	v    uint64
+	data []byte
+}
+
+func (f protoField) str() string { return string(f.data) }
+
+// varints calls fn for a repeated varint field, packed or not.
+func (f protoField) varints(fn func(uint64)) error {
+	if f.wire == 0 {
+		fn(f.v)
+		return nil
+	}
+	if f.wire != 2 {
+		return grpcErrorf(grpcInvalidArgument, "field %d: unexpected wire type %d", f.num, f.wire)
+	}
+	for b := f.data; len(b) > 0; {
+		v, n := binary.Uvarint(b)
+		if n <= 0 {
+			return grpcErrorf(grpcInvalidArgument, "field %d: bad varint", f.num)
+		}
+		fn(v)
+		b = b[n:]
+	}
+	return nil
+}
+
+// decodeProto walks the fields of a message; unknown fields are skipped,
+// as protobuf requires.
+func decodeProto(b []byte, fn func(protoField) error) error {
+	for len(b) > 0 {
+		key, n := binary.Uvarint(b)
+		if n <= 0 {
+			return grpcErrorf(grpcInvalidArgument, "malformed request message")
+		}
+		b = b[n:]
+		f := protoField{num: int(key >> 3), wire: int(key & 7)}
+		switch f.wire {
+		case 0:
+			f.v, n = binary.Uvarint(b)
+			if n <= 0 {
+				return grpcErrorf(grpcInvalidArgument, "malformed request message")
+			}
+			b = b[n:]
+		case 1:
+			if len(b) < 8 {
+				return grpcErrorf(grpcInvalidArgument, "malformed request message")
+			}
+			f.v, b = binary.LittleEndian.Uint64(b), b[8:]
+		case 2:
+			l, n := binary.Uvarint(b)
+			if n <= 0 || uint64(len(b)-n) < l {
+				return grpcErrorf(grpcInvalidArgument, "malformed request message")
+			}
+			f.data, b = b[n:n+int(l)], b[n+int(l):]
+		case 5:
+			if len(b) < 4 {
+				return grpcErrorf(grpcInvalidArgument, "malformed request message")
+			}
+			f.v, b = uint64(binary.LittleEndian.Uint32(b)), b[4:]
+		default:
+			return grpcErrorf(grpcInvalidArgument, "unsupported wire type %d", f.wire)
+		}
+		if err := fn(f); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,370 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"io"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestProtoBufferEncoding(t *testing.T) {
+	tests := []struct {
+		name string
+		enc  func(*protoBuffer)
+		want string
+	}{
+		{"string", func(b *protoBuffer) { b.string(1, "esx") }, "0a03657378"},
+		{"empty string omitted", func(b *protoBuffer) { b.string(1, "") }, ""},
+		{"repeated empty string", func(b *protoBuffer) { b.repeatedString(4, "") }, "2200"},
+		{"varint", func(b *protoBuffer) { b.int64(2, 150) }, "109601"},
+		{"negative varint", func(b *protoBuffer) { b.int64(2, -1) }, "10ffffffffffffffffff01"},
+		{"zero omitted", func(b *protoBuffer) { b.int64(2, 0) }, ""},
+		{"bool", func(b *protoBuffer) { b.bool(3, true) }, "1801"},
+		{"two-byte tag", func(b *protoBuffer) { b.string(16, "a") }, "82010161"},
+		{"message", func(b *protoBuffer) { b.message(2, []byte{0x08, 0x01}) }, "12020801"},
+		{"packed int64", func(b *protoBuffer) { b.packedInt64(1, []int64{1, 300}) }, "0a0301ac02"},
+		{"packed double", func(b *protoBuffer) { b.packedDouble(3, []float64{1.5}) }, "1a08000000000000f83f"},
+		{"empty packed omitted", func(b *protoBuffer) { b.packedDouble(3, nil) }, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var b protoBuffer
+			tt.enc(&b)
+			if got := hex.EncodeToString(b.bytes()); got != tt.want {
+				t.Errorf("got %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDecodeProto(t *testing.T) {
+	// A SeriesRequest: columns 1 and 2 packed, column 5 unpacked, start_ms
+	// 3, an unknown fixed64 field 9, chunk_rows 2.
+	msg, _ := hex.DecodeString("0a020102" + "0805" + "1003" + "490102030405060708" + "2002")
+	var cols []uint64
+	var start, chunk uint64
+	err := decodeProto(msg, func(f protoField) error {
+		switch f.num {
+		case 1:
+			return f.varints(func(v uint64) { cols = append(cols, v) })
+		case 2:
+			start = f.v
+		case 4:
+			chunk = f.v
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(cols, []uint64{1, 2, 5}) || start != 3 || chunk != 2 {
+		t.Errorf("columns %v, start %d, chunk %d", cols, start, chunk)
+	}
+
+	for _, bad := range []string{"0a05010203", "10", "4901", "0f"} {
+		msg, _ := hex.DecodeString(bad)
+		err := decodeProto(msg, func(protoField) error { return nil })
+		var ge *grpcError
+		if !errors.As(err, &ge) || ge.code != grpcInvalidArgument {
+			t.Errorf("%s: err = %v, want InvalidArgument", bad, err)
+		}
+	}
+}
+
+const grpcTestCSV = `"(PDH-CSV 4.0) (UTC)(0)","\\esx01\Memory\Free MBytes","\\esx01\Group Cpu(1:vm-a)\% Ready"
+"01/01/2024 00:00:00","100","1.5"
+"01/01/2024 00:00:05","101",""
+"01/01/2024 00:00:10","102","2.5"
+`
+
+// newGRPCTestServer serves a capture over h2c the way -grpc does. api
+// stands in for the HTTP handlers, which live in main.
+func newGRPCTestServer(t *testing.T, api http.Handler) (*httptest.Server, *http.Client, *DataFile) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "capture.csv")
+	if err := os.WriteFile(path, []byte(grpcTestCSV), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	df, err := buildIndex(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	df.Label = path
+	g := &grpcServer{
+		api:      api,
+		sessions: NewSessionStore(df, time.Hour),
+		scans:    newScanLimiter(1, 0, time.Second),
+	}
+	if api == nil {
+		g.api = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			current := g.sessions.SessionForRequest(w, r).Get()
+			payload := map[string]any{
+				"columns":     current.Columns,
+				"columnCount": len(current.Columns),
+				"rows":        current.Rows,
+				"start":       unixMilliOrZero(current.StartTime),
+				"end":         unixMilliOrZero(current.EndTime),
+				"file":        current.Label,
+				"loaded":      true,
+			}
+			if r.URL.Query().Get("columns") == "0" {
+				delete(payload, "columns")
+			}
+			writeJSON(w, http.StatusOK, payload)
+		})
+	}
+	ts := httptest.NewUnstartedServer(nil)
+	ts.Config = serverOptions{H2C: true}.newServer(grpcHandler(g, g.api))
+	ts.Start()
+	t.Cleanup(ts.Close)
+
+	var protocols http.Protocols
+	protocols.SetUnencryptedHTTP2(true)
+	client := &http.Client{Transport: &http.Transport{Protocols: &protocols}}
+	t.Cleanup(client.CloseIdleConnections)
+	return ts, client, df
+}
+
+type grpcTestResponse struct {
+	header http.Header
+	msgs   [][]byte
+	status int
+	msg    string
+}
+
+// grpcTestCall makes one call and splits the response into its messages.
+func grpcTestCall(t *testing.T, ts *httptest.Server, client *http.Client, method string, req []byte, sessionID string) grpcTestResponse {
+	t.Helper()
+	var body bytes.Buffer
+	if err := writeGRPCMessage(&body, req); err != nil {
+		t.Fatal(err)
+	}
+	hr, err := http.NewRequest(http.MethodPost, ts.URL+grpcServicePrefix+method, &body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hr.Header.Set("Content-Type", "application/grpc")
+	hr.Header.Set("Te", "trailers")
+	if sessionID != "" {
+		hr.Header.Set("X-Esx-Session-Id", sessionID)
+	}
+	resp, err := client.Do(hr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.ProtoMajor != 2 {
+		t.Fatalf("served over %s, want HTTP/2", resp.Proto)
+	}
+	out := grpcTestResponse{header: resp.Header}
+	for {
+		var prefix [5]byte
+		if _, err := io.ReadFull(resp.Body, prefix[:]); err != nil {
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			t.Fatal(err)
+		}
+		if prefix[0] != 0 {
+			t.Fatalf("compressed flag %d on a response message", prefix[0])
+		}
+		msg := make([]byte, binary.BigEndian.Uint32(prefix[1:]))
+		if _, err := io.ReadFull(resp.Body, msg); err != nil {
+			t.Fatal(err)
+		}
+		out.msgs = append(out.msgs, msg)
+	}
+	out.status, err = strconv.Atoi(resp.Trailer.Get("Grpc-Status"))
+	if err != nil {
+		t.Fatalf("grpc-status trailer %q", resp.Trailer.Get("Grpc-Status"))
+	}
+	out.msg = resp.Trailer.Get("Grpc-Message")
+	return out
+}
+
+func TestGRPCMetaOverH2C(t *testing.T) {
+	ts, client, df := newGRPCTestServer(t, nil)
+
+	// include_columns = true
+	resp := grpcTestCall(t, ts, client, "Meta", []byte{0x08, 0x01}, "")
+	if resp.status != grpcOK || len(resp.msgs) != 1 {
+		t.Fatalf("status %d %q, %d messages", resp.status, resp.msg, len(resp.msgs))
+	}
+	sid := resp.header.Get("X-Esx-Session-Id")
+	if sid == "" {
+		t.Fatal("no session ID header")
+	}
+	var got struct {
+		session, file string
+		loaded        bool
+		rows, count   uint64
+		start, end    uint64
+		columns       []string
+	}
+	err := decodeProto(resp.msgs[0], func(f protoField) error {
+		switch f.num {
+		case 1:
+			got.session = f.str()
+		case 2:
+			got.loaded = f.v != 0
+		case 3:
+			got.file = f.str()
+		case 4:
+			got.rows = f.v
+		case 5:
+			got.start = f.v
+		case 6:
+			got.end = f.v
+		case 7:
+			got.count = f.v
+		case 8:
+			got.columns = append(got.columns, f.str())
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.session != sid || !got.loaded || got.file != df.Label || got.rows != 3 || got.count != 3 {
+		t.Errorf("meta = %+v", got)
+	}
+	if got.start != uint64(df.StartTime.UnixMilli()) || got.end != uint64(df.EndTime.UnixMilli()) {
+		t.Errorf("range %d-%d, want %d-%d", got.start, got.end, df.StartTime.UnixMilli(), df.EndTime.UnixMilli())
+	}
+	if !reflect.DeepEqual(got.columns, df.Columns) {
+		t.Errorf("columns = %q", got.columns)
+	}
+
+	// The session comes back as metadata and is kept.
+	again := grpcTestCall(t, ts, client, "Meta", nil, sid)
+	if again.status != grpcOK || again.header.Get("X-Esx-Session-Id") != sid {
+		t.Fatalf("second call: status %d, session %q, want %q", again.status, again.header.Get("X-Esx-Session-Id"), sid)
+	}
+	// Without include_columns only the count comes back.
+	var count, names int
+	err = decodeProto(again.msgs[0], func(f protoField) error {
+		switch f.num {
+		case 7:
+			count = int(f.v)
+		case 8:
+			names++
+		}
+		return nil
+	})
+	if err != nil || count != 3 || names != 0 {
+		t.Errorf("column count %d with %d names (%v), want 3 and none", count, names, err)
+	}
+}
+
+func TestGRPCSeriesStream(t *testing.T) {
+	ts, client, df := newGRPCTestServer(t, nil)
+
+	// columns [1, 2], chunk_rows 2
+	resp := grpcTestCall(t, ts, client, "Series", []byte{0x0a, 0x02, 0x01, 0x02, 0x20, 0x02}, "")
+	if resp.status != grpcOK {
+		t.Fatalf("status %d %q", resp.status, resp.msg)
+	}
+	if len(resp.msgs) != 2 {
+		t.Fatalf("%d chunks, want 2", len(resp.msgs))
+	}
+	t0 := df.StartTime.UnixMilli()
+	wantTimes := [][]int64{{t0, t0 + 5000}, {t0 + 10000}}
+	wantValues := [][][]float64{{{100, 101}, {1.5, math.NaN()}}, {{102}, {2.5}}}
+	for i, msg := range resp.msgs {
+		var times []int64
+		var names []string
+		var values [][]float64
+		err := decodeProto(msg, func(f protoField) error {
+			switch f.num {
+			case 1:
+				return f.varints(func(v uint64) { times = append(times, int64(v)) })
+			case 2:
+				var vs []float64
+				err := decodeProto(f.data, func(cf protoField) error {
+					switch cf.num {
+					case 2:
+						names = append(names, cf.str())
+					case 3:
+						for b := cf.data; len(b) >= 8; b = b[8:] {
+							vs = append(vs, math.Float64frombits(binary.LittleEndian.Uint64(b)))
+						}
+					}
+					return nil
+				})
+				values = append(values, vs)
+				return err
+			}
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(times, wantTimes[i]) {
+			t.Errorf("chunk %d: times %v, want %v", i, times, wantTimes[i])
+		}
+		wantNames := df.Columns[1:3]
+		if i > 0 {
+			wantNames = nil
+		}
+		if !reflect.DeepEqual(names, wantNames) {
+			t.Errorf("chunk %d: names %q, want %q", i, names, wantNames)
+		}
+		if len(values) != 2 {
+			t.Fatalf("chunk %d: %d columns, want 2", i, len(values))
+		}
+		for c := range values {
+			if len(values[c]) != len(wantValues[i][c]) {
+				t.Fatalf("chunk %d column %d: %v, want %v", i, c, values[c], wantValues[i][c])
+			}
+			for j, v := range values[c] {
+				want := wantValues[i][c][j]
+				if v != want && !(math.IsNaN(v) && math.IsNaN(want)) {
+					t.Errorf("chunk %d column %d: %v, want %v", i, c, values[c], wantValues[i][c])
+					break
+				}
+			}
+		}
+	}
+}
+
+func TestGRPCErrors(t *testing.T) {
+	ts, client, _ := newGRPCTestServer(t, nil)
+
+	tests := []struct {
+		method string
+		req    []byte
+		code   int
+	}{
+		{"Series", []byte{0x08, 0x09}, grpcInvalidArgument}, // column 9 of 3
+		{"Series", nil, grpcInvalidArgument},                // no columns
+		{"Series", []byte{0x0a, 0x05}, grpcInvalidArgument}, // truncated
+		{"Reindex", nil, grpcUnimplemented},
+	}
+	for _, tt := range tests {
+		resp := grpcTestCall(t, ts, client, tt.method, tt.req, "")
+		if resp.status != tt.code || len(resp.msgs) != 0 {
+			t.Errorf("%s %x: status %d %q with %d messages, want %d", tt.method, tt.req, resp.status, resp.msg, len(resp.msgs), tt.code)
+		}
+	}
+}
+
+func TestGRPCCallAPIStatus(t *testing.T) {
+	api := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusForbidden, map[string]string{"error": "server is read-only"})
+	})
+	ts, client, _ := newGRPCTestServer(t, api)
+	resp := grpcTestCall(t, ts, client, "Open", []byte{0x0a, 0x01, 'x'}, "")
+	if resp.status != grpcPermissionDenied || resp.msg != "server is read-only" {
+		t.Errorf("status %d %q, want %d", resp.status, resp.msg, grpcPermissionDenied)
+	}
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// esxtop batch captures usually travel as .csv.gz. Queries seek to indexed
+// byte offsets, which a gzip stream cannot do, so compressed captures are
+// decompressed once while they are indexed: uploads and URL fetches into
+// their temp file, files opened by path into a cached copy.
+
+// maxCaptureBytes (-max-capture-bytes) bounds a capture written to disk
+// from an upload, URL, bundle or .gz, counted after decompression: the
+// limits on what is received only see the compressed bytes, and a few MB
+// of gzip can expand to fill the disk. 0 disables it.
+var maxCaptureBytes int64 = 16 << 30
+
+var errCaptureTooLarge = errors.New("capture exceeds the -max-capture-bytes limit once decompressed")
+
+// capCapture applies maxCaptureBytes to a decompressed stream.
+func capCapture(r io.Reader) io.Reader {
+	if maxCaptureBytes <= 0 {
+		return r
+	}
+	return &cappedReader{r: r, remaining: maxCaptureBytes, tooLarge: errCaptureTooLarge}
+}
+
+func hasGzipMagic(b []byte) bool {
+	return len(b) >= 2 && b[0] == 0x1f && b[1] == 0x8b
+}
+
+// trimCaptureExt drops a file name's extension, both of them for x.csv.gz.
+func trimCaptureExt(name string) string {
+	if strings.EqualFold(filepath.Ext(name), ".gz") {
+		name = name[:len(name)-3]
+	}
+	return strings.TrimSuffix(name, filepath.Ext(name))
+}
+
+// isGzipFile sniffs the magic bytes rather than trusting the extension.
+func isGzipFile(path string) bool {
+	f, err := os.Open(path)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+	var magic [2]byte
+	n, _ := io.ReadFull(f, magic[:])
+	return hasGzipMagic(magic[:n])
+}
+
+// maybeGunzip returns r decompressed when it starts with the gzip magic
+// bytes, and r's bytes unchanged otherwise.
+func maybeGunzip(r io.Reader) (io.Reader, error) {
+	br := bufio.NewReaderSize(r, 64*1024)
+	magic, _ := br.Peek(2)
+	if !hasGzipMagic(magic) {
+		return br, nil
+	}
+	zr, err := gzip.NewReader(br)
+	if err != nil {
+		return nil, fmt.Errorf("invalid gzip stream: %w", err)
+	}
+	return zr, nil
+}
+
+// gunzipCacheDir holds decompressed copies of captures opened by path.
+func gunzipCacheDir() string {
+	return filepath.Join(os.TempDir(), "esx-doctor-gunzip")
+}
+
+// gunzipCached returns a decompressed copy of the .gz at path, reusing the
+// one from an earlier open while the .gz is unchanged. Copies are named by
+// a hash of the path plus one of its size and mtime; writing a new copy
+// removes older ones of the same path.
+func gunzipCached(path string) (string, error) {
+	st, err := os.Stat(path)
+	if err != nil {
+		return "", err
+	}
+	pathSum := sha256.Sum256([]byte(path))
+	stateSum := sha256.Sum256([]byte(strconv.FormatInt(st.Size(), 10) + "|" + strconv.FormatInt(st.ModTime().UnixNano(), 10)))
+	prefix := hex.EncodeToString(pathSum[:6]) + "-"
+	name := prefix + hex.EncodeToString(stateSum[:4]) + "-" + trimCaptureExt(filepath.Base(path)) + ".csv"
+	dir := gunzipCacheDir()
+	dest := filepath.Join(dir, name)
+	if _, err := os.Stat(dest); err == nil {
+		return dest, nil
+	}
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return "", err
+	}
+
+	src, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer src.Close()
+	zr, err := gzip.NewReader(bufio.NewReaderSize(src, 256*1024))
+	if err != nil {
+		return "", fmt.Errorf("invalid gzip stream: %w", err)
+	}
+	tmp, err := os.CreateTemp(dir, name+".part-*")
+	if err != nil {
+		return "", err
+	}
+	if _, err := io.Copy(tmp, capCapture(zr)); err != nil {
+		_ = tmp.Close()
+		_ = os.Remove(tmp.Name())
+		return "", fmt.Errorf("decompressing %s: %w", filepath.Base(path), err)
+	}
+	if err := tmp.Close(); err != nil {
+		_ = os.Remove(tmp.Name())
+		return "", err
+	}
+	if err := os.Rename(tmp.Name(), dest); err != nil {
+		_ = os.Remove(tmp.Name())
+		return "", err
+	}
+	if stale, _ := filepath.Glob(filepath.Join(dir, prefix+"*")); len(stale) > 0 {
+		for _, p := range stale {
+			if p != dest && !strings.Contains(filepath.Base(p), ".part-") {
+				_ = os.Remove(p)
+			}
+		}
+	}
+	return dest, nil
+}
+
+// buildGzipIndex indexes the decompressed copy of the .gz at path. The
+// DataFile reads from the copy but is labeled with the .gz.
+func buildGzipIndex(path string) (*DataFile, error) {
+	began := time.Now()
+	copyPath, err := gunzipCached(path)
+	if err != nil {
+		return nil, err
+	}
+	df, err := buildIndex(copyPath)
+	if err != nil {
+		return nil, err
+	}
+	df.Label = path
+	df.Provenance.Origin = path
+	df.Provenance.Compression = "gzip"
+	df.Provenance.IndexDurationMs = time.Since(began).Milliseconds()
+	return df, nil
+}
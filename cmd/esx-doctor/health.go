@@ -0,0 +1,53 @@
+package main
+
+import (
+	"math"
+	"strings"
+)
+
+// severityPenalty is what one finding costs the health score at full weight.
+var severityPenalty = map[string]float64{
+	"critical": 30,
+	"high":     15,
+	"medium":   7,
+	"low":      3,
+	"info":     0,
+}
+
+// healthScore condenses findings into 0 (needs attention) .. 100 (clean).
+// Each finding's severity penalty is scaled by how much of the window
+// [windowStart, windowEnd] (Unix ms) it covers and by how many instances it
+// names; penalties add up and decay the score exponentially, so it never
+// goes negative and a single critical finding still leaves room to rank
+// worse hosts below it.
+func healthScore(findings []DiagnosticFinding, windowStart, windowEnd int64) int {
+	window := float64(windowEnd - windowStart)
+	total := 0.0
+	for _, f := range findings {
+		penalty, ok := severityPenalty[strings.ToLower(formatSRSeverity(f.Severity))]
+		if !ok {
+			penalty = severityPenalty["medium"]
+		}
+		// Findings without a time range count as covering half the window.
+		coverage := 0.5
+		if f.Start > 0 && window > 0 {
+			end := f.End
+			if end <= 0 || end > windowEnd {
+				end = windowEnd
+			}
+			coverage = math.Min(1, math.Max(0, float64(end-f.Start))/window)
+		}
+		instances := 0
+		for _, inst := range f.Instances {
+			if inst != "" && !strings.HasPrefix(inst, "... and ") {
+				instances++
+			}
+		}
+		spread := 1.0
+		if instances > 1 {
+			spread = math.Min(2, 1+math.Log2(float64(instances))/2)
+		}
+		total += penalty * (0.5 + 0.5*coverage) * spread
+	}
+	return int(math.Round(100 * math.Exp(-total/100)))
+}
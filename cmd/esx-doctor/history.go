@@ -0,0 +1,439 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maxHistoryEntries bounds the history file; the oldest runs are dropped
+// first. Entries hold no summaries, only the replay specs, so this stays
+// in the tens of megabytes.
+const maxHistoryEntries = 5000
+
+// HistoryFinding is the part of a finding worth keeping for trends and
+// replays: which rule fired on what, not the prose.
+type HistoryFinding struct {
+	ID             string         `json:"id,omitempty"`
+	TemplateID     string         `json:"templateId"`
+	Severity       string         `json:"severity"`
+	AttributeLabel string         `json:"attributeLabel,omitempty"`
+	Instances      []string       `json:"instances,omitempty"`
+	Start          int64          `json:"start,omitempty"`
+	End            int64          `json:"end,omitempty"`
+	Replay         *FindingReplay `json:"replay,omitempty"`
+}
+
+// HistoryEntry is one diagnostics run as recorded on disk. Unlike StoredRun
+// it survives restarts and names the capture by host and content hash, so
+// runs over weeks of captures from the same host line up.
+type HistoryEntry struct {
+	ID           string           `json:"id"`
+	At           int64            `json:"at"`
+	File         string           `json:"file"`
+	Host         string           `json:"host,omitempty"`
+	ContentHash  string           `json:"contentHash,omitempty"`
+	CaptureStart int64            `json:"captureStart"`
+	CaptureEnd   int64            `json:"captureEnd"`
+	Start        int64            `json:"start,omitempty"`
+	End          int64            `json:"end,omitempty"`
+	TemplateIDs  []string         `json:"templateIds"`
+	HealthScore  int              `json:"healthScore"`
+	FindingCount int              `json:"findingCount"`
+	BySeverity   map[string]int   `json:"bySeverity"`
+	Findings     []HistoryFinding `json:"findings,omitempty"`
+}
+
+// historyStore appends every run to a JSON-lines file.
+type historyStore struct {
+	mu      sync.Mutex
+	path    string
+	entries []HistoryEntry
+}
+
+func defaultHistoryPath() string {
+	home, err := os.UserHomeDir()
+	if err != nil || strings.TrimSpace(home) == "" {
+		return ".esx-doctor-history.jsonl"
+	}
+	return filepath.Join(home, ".esx-doctor", "history.jsonl")
+}
+
+func newHistoryStore(path string) (*historyStore, error) {
+	if strings.TrimSpace(path) == "" {
+		path = defaultHistoryPath()
+	}
+	s := &historyStore{path: path}
+	if err := s.load(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *historyStore) load() error {
+	f, err := os.Open(s.path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	defer f.Close()
+	sc := bufio.NewScanner(f)
+	sc.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for sc.Scan() {
+		b := bytes.TrimSpace(sc.Bytes())
+		if len(b) == 0 {
+			continue
+		}
+		var e HistoryEntry
+		// A line cut short by a crash should not lose the rest.
+		if err := json.Unmarshal(b, &e); err != nil {
+			continue
+		}
+		if e.ID != "" {
+			s.entries = append(s.entries, e)
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return fmt.Errorf("invalid history file: %w", err)
+	}
+	if len(s.entries) > maxHistoryEntries {
+		s.entries = s.entries[len(s.entries)-maxHistoryEntries:]
+	}
+	return nil
+}
+
+// reload re-reads history from disk, discarding in-memory state.
+func (s *historyStore) reload() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	prev := s.entries
+	s.entries = nil
+	if err := s.load(); err != nil {
+		s.entries = prev
+		return err
+	}
+	return nil
+}
+
+// rewriteLocked replaces the file with the current entries.
+func (s *historyStore) rewriteLocked() error {
+	if err := os.MkdirAll(filepath.Dir(s.path), 0o755); err != nil {
+		return err
+	}
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	for _, e := range s.entries {
+		if err := enc.Encode(e); err != nil {
+			return err
+		}
+	}
+	tmp := s.path + ".tmp"
+	if err := os.WriteFile(tmp, buf.Bytes(), 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.path)
+}
+
+func (s *historyStore) appendLocked(e HistoryEntry) error {
+	if err := os.MkdirAll(filepath.Dir(s.path), 0o755); err != nil {
+		return err
+	}
+	data, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(s.path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(append(data, '\n'))
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// captureHosts names the hosts a capture's columns come from.
+func captureHosts(df *DataFile) string {
+	seen := map[string]bool{}
+	var hosts []string
+	for _, raw := range df.Columns {
+		if h := strings.ToLower(pdhHost(raw)); h != "" && !seen[h] {
+			seen[h] = true
+			hosts = append(hosts, h)
+		}
+	}
+	sort.Strings(hosts)
+	return strings.Join(hosts, ",")
+}
+
+// add records a finished run of selected over df.
+func (s *historyStore) add(df *DataFile, selected []DiagnosticTemplate, start, end time.Time, result DiagnosticRunResponse) (HistoryEntry, error) {
+	now := time.Now()
+	e := HistoryEntry{
+		ID:           strconv.FormatInt(now.UnixNano(), 36),
+		At:           now.UnixMilli(),
+		File:         df.Label,
+		Host:         captureHosts(df),
+		CaptureStart: unixMilliOrZero(df.StartTime),
+		CaptureEnd:   unixMilliOrZero(df.EndTime),
+		Start:        unixMilliOrZero(start),
+		End:          unixMilliOrZero(end),
+		TemplateIDs:  make([]string, 0, len(selected)),
+		HealthScore:  result.HealthScore,
+		FindingCount: len(result.Findings),
+		BySeverity:   map[string]int{},
+		Findings:     make([]HistoryFinding, 0, len(result.Findings)),
+	}
+	if sum, ok := df.contentHash(); ok {
+		e.ContentHash = sum
+	}
+	for _, t := range selected {
+		e.TemplateIDs = append(e.TemplateIDs, t.ID)
+	}
+	for _, f := range result.Findings {
+		e.BySeverity[strings.ToLower(f.Severity)]++
+		e.Findings = append(e.Findings, HistoryFinding{ID: f.ID, TemplateID: f.TemplateID, Severity: f.Severity, AttributeLabel: f.AttributeLabel, Instances: f.Instances, Start: f.Start, End: f.End, Replay: f.Replay})
+		// Folded duplicates still count for their own template's trend.
+		for _, a := range f.AlsoFlaggedBy {
+			e.Findings = append(e.Findings, HistoryFinding{TemplateID: a.TemplateID, Severity: a.Severity, AttributeLabel: f.AttributeLabel, Instances: f.Instances})
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.entries = append(s.entries, e)
+	if len(s.entries) > maxHistoryEntries {
+		s.entries = s.entries[len(s.entries)-maxHistoryEntries:]
+		return e, s.rewriteLocked()
+	}
+	return e, s.appendLocked(e)
+}
+
+func (s *historyStore) get(id string) (HistoryEntry, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, e := range s.entries {
+		if e.ID == id {
+			return e, true
+		}
+	}
+	return HistoryEntry{}, false
+}
+
+func (s *historyStore) delete(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i, e := range s.entries {
+		if e.ID == id {
+			s.entries = append(s.entries[:i:i], s.entries[i+1:]...)
+			return s.rewriteLocked()
+		}
+	}
+	return fmt.Errorf("unknown history entry %q", id)
+}
+
+// list returns entries for host (all hosts when empty) newest first,
+// without their findings.
+func (s *historyStore) list(host string, limit int) []HistoryEntry {
+	host = strings.ToLower(strings.TrimSpace(host))
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	out := make([]HistoryEntry, 0)
+	for i := len(s.entries) - 1; i >= 0 && (limit <= 0 || len(out) < limit); i-- {
+		e := s.entries[i]
+		if host != "" && e.Host != host {
+			continue
+		}
+		e.Findings = nil
+		out = append(out, e)
+	}
+	return out
+}
+
+type HistoryHost struct {
+	Host        string `json:"host"`
+	Runs        int    `json:"runs"`
+	Captures    int    `json:"captures"`
+	FirstAt     int64  `json:"firstAt"`
+	LastAt      int64  `json:"lastAt"`
+	LatestScore int    `json:"latestScore"`
+}
+
+func (s *historyStore) hosts() []HistoryHost {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	byHost := map[string]*HistoryHost{}
+	captures := map[string]map[string]bool{}
+	for _, e := range s.entries {
+		h, ok := byHost[e.Host]
+		if !ok {
+			h = &HistoryHost{Host: e.Host, FirstAt: e.At}
+			byHost[e.Host] = h
+			captures[e.Host] = map[string]bool{}
+		}
+		h.Runs++
+		h.LastAt, h.LatestScore = e.At, e.HealthScore
+		captures[e.Host][historyCaptureKey(e)] = true
+	}
+	out := make([]HistoryHost, 0, len(byHost))
+	for host, h := range byHost {
+		h.Captures = len(captures[host])
+		out = append(out, *h)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Host < out[j].Host })
+	return out
+}
+
+// historyCaptureKey identifies the capture a run looked at; the content
+// hash when known, else the file and its time range.
+func historyCaptureKey(e HistoryEntry) string {
+	if e.ContentHash != "" {
+		return e.ContentHash
+	}
+	return fmt.Sprintf("%s|%d|%d", e.File, e.CaptureStart, e.CaptureEnd)
+}
+
+type HistoryPoint struct {
+	RunID        string         `json:"runId"`
+	At           int64          `json:"at"`
+	File         string         `json:"file"`
+	ContentHash  string         `json:"contentHash,omitempty"`
+	CaptureStart int64          `json:"captureStart"`
+	CaptureEnd   int64          `json:"captureEnd"`
+	HealthScore  int            `json:"healthScore"`
+	FindingCount int            `json:"findingCount"`
+	BySeverity   map[string]int `json:"bySeverity"`
+}
+
+// TemplateTrend follows one template across the points. Counts has one
+// entry per point, null where the run did not include the template.
+type TemplateTrend struct {
+	TemplateID string `json:"templateId"`
+	Counts     []*int `json:"counts"`
+	// Direction compares the last two points that ran the template:
+	// improving (fewer findings), regressing (more) or steady.
+	Direction string `json:"direction,omitempty"`
+}
+
+type HistoryTrend struct {
+	Host      string          `json:"host"`
+	Points    []HistoryPoint  `json:"points"`
+	Templates []TemplateTrend `json:"templates"`
+	// Direction compares the health scores of the last two points.
+	Direction string `json:"direction,omitempty"`
+	Error     string `json:"error,omitempty"`
+}
+
+func trendDirection(prev, last int, higherIsBetter bool) string {
+	switch {
+	case last == prev:
+		return "steady"
+	case (last > prev) == higherIsBetter:
+		return "improving"
+	default:
+		return "regressing"
+	}
+}
+
+// trend lines up host's captures in capture order, one point per capture
+// (its latest run), optionally limited to one template.
+func (s *historyStore) trend(host, templateID string) (HistoryTrend, error) {
+	host = strings.ToLower(strings.TrimSpace(host))
+	templateID = strings.TrimSpace(templateID)
+	out := HistoryTrend{Host: host, Points: []HistoryPoint{}, Templates: []TemplateTrend{}}
+	s.mu.Lock()
+	latest := map[string]HistoryEntry{}
+	hosts := map[string]bool{}
+	for _, e := range s.entries {
+		hosts[e.Host] = true
+		if host == "" || e.Host == host {
+			latest[historyCaptureKey(e)] = e
+		}
+	}
+	s.mu.Unlock()
+	if host == "" && len(hosts) > 1 {
+		return out, fmt.Errorf("history covers %d hosts; pass host=", len(hosts))
+	}
+	if len(latest) == 0 {
+		return out, nil
+	}
+
+	runs := make([]HistoryEntry, 0, len(latest))
+	for _, e := range latest {
+		runs = append(runs, e)
+	}
+	sort.Slice(runs, func(i, j int) bool {
+		if runs[i].CaptureStart != runs[j].CaptureStart {
+			return runs[i].CaptureStart < runs[j].CaptureStart
+		}
+		return runs[i].At < runs[j].At
+	})
+	if out.Host == "" {
+		out.Host = runs[0].Host
+	}
+
+	ids := map[string]bool{}
+	for _, e := range runs {
+		out.Points = append(out.Points, HistoryPoint{
+			RunID:        e.ID,
+			At:           e.At,
+			File:         e.File,
+			ContentHash:  e.ContentHash,
+			CaptureStart: e.CaptureStart,
+			CaptureEnd:   e.CaptureEnd,
+			HealthScore:  e.HealthScore,
+			FindingCount: e.FindingCount,
+			BySeverity:   e.BySeverity,
+		})
+		for _, id := range e.TemplateIDs {
+			if templateID == "" || id == templateID {
+				ids[id] = true
+			}
+		}
+	}
+	if n := len(out.Points); n >= 2 {
+		out.Direction = trendDirection(out.Points[n-2].HealthScore, out.Points[n-1].HealthScore, true)
+	}
+
+	for id := range ids {
+		tt := TemplateTrend{TemplateID: id, Counts: make([]*int, len(runs))}
+		var ran []int
+		for i, e := range runs {
+			included := false
+			for _, t := range e.TemplateIDs {
+				if t == id {
+					included = true
+					break
+				}
+			}
+			if !included {
+				continue
+			}
+			n := 0
+			for _, f := range e.Findings {
+				if f.TemplateID == id {
+					n++
+				}
+			}
+			tt.Counts[i] = &n
+			ran = append(ran, n)
+		}
+		if len(ran) >= 2 {
+			tt.Direction = trendDirection(ran[len(ran)-2], ran[len(ran)-1], false)
+		}
+		out.Templates = append(out.Templates, tt)
+	}
+	sort.Slice(out.Templates, func(i, j int) bool { return out.Templates[i].TemplateID < out.Templates[j].TemplateID })
+	return out, nil
+}
@@ -0,0 +1,332 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// indexFileVersion is bumped whenever what an index means changes, so a
+// sidecar written by an older or newer esx-doctor is never read with the
+// wrong semantics. Versions from minIndexFileVersion up are upgraded on
+// load (see upgradeIndexFile); anything else is rebuilt.
+//
+//	1: offsets, times and counters
+//	2: adds stride and the CSV mode the rows were counted with
+const (
+	indexFileVersion    = 2
+	minIndexFileVersion = 1
+)
+
+// rebuildIndexes (-rebuild-index) ignores sidecar indexes and rewrites
+// them from a fresh scan.
+var rebuildIndexes bool
+
+// errStaleIndex marks an index that no longer describes its capture (or
+// that this build cannot read); loadOrBuildIndex replaces such a sidecar.
+var errStaleIndex = errors.New("stale index")
+
+// IndexFile is the on-disk form of a DataFile's offsets/time index. Times are
+// Unix milliseconds so other tools don't need Go's time format.
+type IndexFile struct {
+	Version         int      `json:"version"`
+	File            string   `json:"file"`
+	Size            int64    `json:"size"`
+	Columns         []string `json:"columns"`
+	Rows            int64    `json:"rows"`
+	Start           int64    `json:"start"`
+	End             int64    `json:"end"`
+	DataStartOffset int64    `json:"dataStartOffset"`
+	DataEndOffset   int64    `json:"dataEndOffset"`
+	TimeLayout      string   `json:"timeLayout"`
+	Truncated       bool     `json:"truncated,omitempty"`
+	MalformedLines  int64    `json:"malformedLines,omitempty"`
+	BadTimestamps   int64    `json:"badTimestamps,omitempty"`
+	ShortRows       int64    `json:"shortRows,omitempty"`
+	LenientLines    int64    `json:"lenientLines,omitempty"`
+	// Stride is the rows between index entries; Strict is set when the
+	// capture was indexed with -csv-mode strict, which counts rows
+	// differently.
+	Stride int64 `json:"stride,omitempty"`
+	Strict bool  `json:"strict,omitempty"`
+	// Columns of a resxtop capture are stored normalized; Remote says so.
+	Remote *RemoteCapture   `json:"remote,omitempty"`
+	Index  []IndexFileEntry `json:"index"`
+}
+
+type IndexFileEntry struct {
+	Row    int64 `json:"row"`
+	Offset int64 `json:"offset"`
+	Time   int64 `json:"time"`
+}
+
+// sidecarIndexPath is where an index shipped alongside a capture is expected:
+// x.csv -> x.idx.json.
+func sidecarIndexPath(csvPath string) string {
+	return strings.TrimSuffix(csvPath, filepath.Ext(csvPath)) + ".idx.json"
+}
+
+func exportIndex(df *DataFile, out string) error {
+	st, err := os.Stat(df.Path)
+	if err != nil {
+		return err
+	}
+	idx := IndexFile{
+		Version:         indexFileVersion,
+		File:            filepath.Base(df.Path),
+		Size:            st.Size(),
+		Columns:         df.Columns,
+		Rows:            df.Rows,
+		Start:           unixMilliOrZero(df.StartTime),
+		End:             unixMilliOrZero(df.EndTime),
+		DataStartOffset: df.DataStartOffset,
+		DataEndOffset:   df.DataEndOffset,
+		TimeLayout:      df.TimeLayout,
+		Truncated:       df.Truncated,
+		MalformedLines:  df.MalformedLines,
+		BadTimestamps:   df.BadTimestamps,
+		ShortRows:       df.ShortRows,
+		LenientLines:    df.LenientLines,
+		Stride:          df.Provenance.Stride,
+		Strict:          strictCSV,
+		Remote:          df.Remote,
+		Index:           make([]IndexFileEntry, 0, len(df.Index)),
+	}
+	if idx.Stride <= 0 {
+		idx.Stride = indexStride
+	}
+	for _, e := range df.Index {
+		idx.Index = append(idx.Index, IndexFileEntry{Row: e.Row, Offset: e.Offset, Time: e.Time.UnixMilli()})
+	}
+	data, err := json.Marshal(idx)
+	if err != nil {
+		return err
+	}
+	tmp := out + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, out)
+}
+
+// upgradeIndexFile brings an index written by an older esx-doctor up to
+// indexFileVersion, or rejects it when it cannot be.
+func upgradeIndexFile(idx *IndexFile) error {
+	if idx.Version > indexFileVersion {
+		return fmt.Errorf("%w: index version %d is newer than this build (%d)", errStaleIndex, idx.Version, indexFileVersion)
+	}
+	if idx.Version < minIndexFileVersion {
+		return fmt.Errorf("%w: unsupported index version %d", errStaleIndex, idx.Version)
+	}
+	if idx.Version < 2 {
+		// v1 only wrote the lenient default; entries sit at row 1 and then
+		// every stride rows.
+		idx.Strict = false
+		if len(idx.Index) > 1 {
+			idx.Stride = idx.Index[1].Row
+		}
+	}
+	idx.Version = indexFileVersion
+	return nil
+}
+
+// importIndex loads an exported index for csvPath. The capture's size and
+// header must match what was indexed; otherwise the index is rejected so a
+// stale or foreign index never drives offsets into the wrong bytes.
+func importIndex(csvPath, indexPath string) (*DataFile, error) {
+	began := time.Now()
+	data, err := os.ReadFile(indexPath)
+	if err != nil {
+		return nil, err
+	}
+	var idx IndexFile
+	if err := json.Unmarshal(data, &idx); err != nil {
+		return nil, fmt.Errorf("invalid index file: %w", err)
+	}
+	upgraded := idx.Version
+	if err := upgradeIndexFile(&idx); err != nil {
+		return nil, err
+	}
+	if idx.Strict != strictCSV {
+		return nil, fmt.Errorf("%w: index was built with another -csv-mode", errStaleIndex)
+	}
+	st, err := os.Stat(csvPath)
+	if err != nil {
+		return nil, err
+	}
+	if st.Size() != idx.Size {
+		return nil, fmt.Errorf("%w: index was built for a %d byte file, capture is %d bytes", errStaleIndex, idx.Size, st.Size())
+	}
+	if len(idx.Columns) == 0 || idx.DataStartOffset <= 0 || idx.DataEndOffset > idx.Size {
+		return nil, fmt.Errorf("index offsets are inconsistent")
+	}
+	f, err := os.Open(csvPath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	if err := verifyHeader(f, idx.DataStartOffset, idx.Columns); err != nil {
+		return nil, fmt.Errorf("%w: index does not match capture: %v", errStaleIndex, err)
+	}
+
+	df := &DataFile{
+		Path:            csvPath,
+		Label:           csvPath,
+		Columns:         idx.Columns,
+		Rows:            idx.Rows,
+		DataStartOffset: idx.DataStartOffset,
+		DataEndOffset:   idx.DataEndOffset,
+		TimeLayout:      idx.TimeLayout,
+		Truncated:       idx.Truncated,
+		MalformedLines:  idx.MalformedLines,
+		BadTimestamps:   idx.BadTimestamps,
+		ShortRows:       idx.ShortRows,
+		LenientLines:    idx.LenientLines,
+		Remote:          idx.Remote,
+		Size:            st.Size(),
+		ModTime:         st.ModTime(),
+		Index:           make([]IndexEntry, 0, len(idx.Index)),
+	}
+	if idx.Start > 0 {
+		df.StartTime = time.UnixMilli(idx.Start).UTC()
+	}
+	if idx.End > 0 {
+		df.EndTime = time.UnixMilli(idx.End).UTC()
+	}
+	for _, e := range idx.Index {
+		if e.Offset < idx.DataStartOffset || e.Offset > idx.DataEndOffset {
+			return nil, fmt.Errorf("index entry for row %d points outside the data", e.Row)
+		}
+		df.Index = append(df.Index, IndexEntry{Row: e.Row, Offset: e.Offset, Time: time.UnixMilli(e.Time).UTC()})
+	}
+	if df.TimeLayout == "" {
+		df.TimeLayout = timeLayouts[0]
+	}
+	df.tailSum, _ = tailChecksum(f, df.DataEndOffset)
+	df.Provenance = Provenance{
+		Source:          "path",
+		Origin:          csvPath,
+		Index:           "sidecar",
+		IndexFile:       indexPath,
+		IndexDurationMs: time.Since(began).Milliseconds(),
+		IndexedAt:       time.Now().UnixMilli(),
+		Stride:          indexStride,
+		IndexVersion:    upgraded,
+	}
+	// The exporter may have used another stride.
+	if idx.Stride > 0 {
+		df.Provenance.Stride = idx.Stride
+	}
+	return df, nil
+}
+
+// loadOrBuildIndex reuses a sidecar index next to the capture when it is
+// valid and falls back to scanning the file otherwise. A sidecar that is
+// stale, written in an older format or skipped by -rebuild-index is
+// rewritten from the scan, so the next load is fast and correct again.
+func loadOrBuildIndex(path string) (*DataFile, error) {
+	sidecar := sidecarIndexPath(path)
+	// x.csv.gz would pick up x.csv's sidecar, and offsets into a gzip
+	// stream are useless anyway.
+	if isGzipFile(path) {
+		return buildIndex(path)
+	}
+	if _, err := os.Stat(sidecar); err != nil {
+		return buildIndex(path)
+	}
+	refresh := rebuildIndexes
+	if !refresh {
+		df, err := importIndex(path, sidecar)
+		if err == nil {
+			if df.Provenance.IndexVersion < indexFileVersion {
+				if err := exportIndex(df, sidecar); err != nil {
+					fmt.Fprintf(os.Stderr, "upgrading index %s: %v\n", sidecar, err)
+				}
+			}
+			return df, nil
+		}
+		fmt.Fprintf(os.Stderr, "ignoring index %s: %v\n", sidecar, err)
+		refresh = errors.Is(err, errStaleIndex)
+	}
+	df, err := buildIndex(path)
+	if err != nil || !refresh {
+		return df, err
+	}
+	if err := exportIndex(df, sidecar); err != nil {
+		fmt.Fprintf(os.Stderr, "rewriting index %s: %v\n", sidecar, err)
+	} else {
+		fmt.Fprintf(os.Stderr, "rebuilt index %s\n", sidecar)
+	}
+	return df, nil
+}
+
+// runIndex implements `esx-doctor index export|import`.
+func runIndex(args []string) int {
+	usage := func() {
+		fmt.Fprintln(os.Stderr, "usage: esx-doctor index export --file x.csv [--out x.idx.json]")
+		fmt.Fprintln(os.Stderr, "       esx-doctor index import --file x.csv [--index x.idx.json]")
+	}
+	if len(args) == 0 {
+		usage()
+		return 2
+	}
+	cmd := args[0]
+	fs := flag.NewFlagSet("index "+cmd, flag.ContinueOnError)
+	file := fs.String("file", "", "Path to ESX CSV file")
+	out := fs.String("out", "", "Index file to write (export; default x.idx.json next to the capture)")
+	indexPath := fs.String("index", "", "Index file to load (import; default x.idx.json next to the capture)")
+	if err := fs.Parse(args[1:]); err != nil {
+		return 2
+	}
+	if strings.TrimSpace(*file) == "" {
+		usage()
+		return 2
+	}
+	path, err := filepath.Abs(*file)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "invalid path: %v\n", err)
+		return 1
+	}
+
+	switch cmd {
+	case "export":
+		if isGzipFile(path) {
+			fmt.Fprintf(os.Stderr, "%s is gzip-compressed; an index can only be shipped with the uncompressed capture\n", path)
+			return 1
+		}
+		df, err := buildIndex(path)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "index build failed: %v\n", err)
+			return 1
+		}
+		dest := *out
+		if strings.TrimSpace(dest) == "" {
+			dest = sidecarIndexPath(path)
+		}
+		if err := exportIndex(df, dest); err != nil {
+			fmt.Fprintf(os.Stderr, "index export failed: %v\n", err)
+			return 1
+		}
+		fmt.Printf("wrote %s (%d rows, %d index entries)\n", dest, df.Rows, len(df.Index))
+	case "import":
+		src := *indexPath
+		if strings.TrimSpace(src) == "" {
+			src = sidecarIndexPath(path)
+		}
+		df, err := importIndex(path, src)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "index import failed: %v\n", err)
+			return 1
+		}
+		fmt.Printf("index OK for %s: %d rows, %d columns, %d index entries\n", df.Label, df.Rows, len(df.Columns), len(df.Index))
+	default:
+		usage()
+		return 2
+	}
+	return 0
+}
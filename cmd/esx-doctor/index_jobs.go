@@ -0,0 +1,175 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// finishedJobTTL is how long finished jobs stay queryable.
+const finishedJobTTL = 15 * time.Minute
+
+// IndexJob reports a background indexing job started by /api/upload (or
+// another endpoint handing over a temp file, such as /api/open-bundle).
+type IndexJob struct {
+	ID       string `json:"id"`
+	Status   string `json:"status"` // queued, indexing, done, failed, superseded
+	File     string `json:"file"`
+	Rows     int64  `json:"rows,omitempty"`
+	Start    int64  `json:"start,omitempty"`
+	End      int64  `json:"end,omitempty"`
+	Error    string `json:"error,omitempty"`
+	Created  int64  `json:"created"`
+	Finished int64  `json:"finished,omitempty"`
+}
+
+type indexJobRun struct {
+	id      string
+	path    string
+	label   string
+	source  string
+	session *Session
+	by      FileOwner
+	force   bool
+}
+
+// indexJobs indexes persisted uploads on a fixed pool of workers so a large
+// buildIndex never holds an HTTP handler goroutine.
+type indexJobs struct {
+	mu    sync.Mutex
+	jobs  map[string]*IndexJob
+	queue chan indexJobRun
+	// latest is the newest job per session; an older job finishing later
+	// must not replace the file a newer upload already opened.
+	latest map[*Session]string
+	events *eventLog
+	shared *sharedFiles
+}
+
+func newIndexJobs(workers, queued int, events *eventLog, shared *sharedFiles) *indexJobs {
+	if workers < 1 {
+		workers = 1
+	}
+	if queued < 0 {
+		queued = 0
+	}
+	j := &indexJobs{
+		jobs:   map[string]*IndexJob{},
+		queue:  make(chan indexJobRun, queued),
+		latest: map[*Session]string{},
+		events: events,
+		shared: shared,
+	}
+	for i := 0; i < workers; i++ {
+		go j.worker()
+	}
+	return j
+}
+
+// submit queues path for indexing; source is recorded in the provenance
+// (upload, bundle) and by is who the file is opened for in -shared mode.
+// It fails when the queue is full or the session's file is locked; the
+// caller still owns path in that case.
+func (j *indexJobs) submit(path, label, source string, session *Session, by FileOwner, force bool) (IndexJob, error) {
+	if err := j.shared.check(session, by, force); err != nil {
+		return IndexJob{}, err
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.pruneLocked()
+	job := &IndexJob{ID: randomSessionID(), Status: "queued", File: label, Created: time.Now().UnixMilli()}
+	select {
+	case j.queue <- indexJobRun{id: job.ID, path: path, label: label, source: source, session: session, by: by, force: force}:
+	default:
+		return IndexJob{}, fmt.Errorf("indexing queue is full")
+	}
+	j.jobs[job.ID] = job
+	j.latest[session] = job.ID
+	j.events.publish(session, "job", *job)
+	return *job, nil
+}
+
+func (j *indexJobs) get(id string) (IndexJob, bool) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	job, ok := j.jobs[id]
+	if !ok {
+		return IndexJob{}, false
+	}
+	return *job, true
+}
+
+func (j *indexJobs) pruneLocked() {
+	cutoff := time.Now().Add(-finishedJobTTL).UnixMilli()
+	for id, job := range j.jobs {
+		if job.Finished > 0 && job.Finished < cutoff {
+			delete(j.jobs, id)
+		}
+	}
+}
+
+func (j *indexJobs) worker() {
+	for run := range j.queue {
+		j.update(run.id, func(job *IndexJob) { job.Status = "indexing" })
+		if job, ok := j.get(run.id); ok {
+			j.events.publish(run.session, "job", job)
+		}
+		newDF, err := indexTempCSV(run.path, run.label)
+		if err == nil {
+			newDF.Provenance.Source, newDF.Provenance.Origin = run.source, run.label
+		}
+
+		j.mu.Lock()
+		current := j.latest[run.session] == run.id
+		if current {
+			delete(j.latest, run.session)
+		}
+		j.mu.Unlock()
+
+		// The file is opened before the job reports done, so a client
+		// reacting to "done" already sees it.
+		var lockErr error
+		if newDF != nil {
+			if current {
+				lockErr = j.shared.replace(run.session, newDF, run.by, run.force)
+			}
+			if !current || lockErr != nil {
+				_ = os.Remove(newDF.Path)
+			}
+		}
+
+		j.mu.Lock()
+		job := j.jobs[run.id]
+		job.Finished = time.Now().UnixMilli()
+		switch {
+		case err != nil:
+			job.Status = "failed"
+			job.Error = fmt.Sprintf("index build failed: %v", err)
+		case lockErr != nil:
+			// Someone locked the session while this was indexing.
+			job.Status = "failed"
+			job.Error = lockErr.Error()
+		case !current:
+			// A newer upload to the session was opened instead.
+			job.Status = "superseded"
+		default:
+			job.Status = "done"
+			job.File = newDF.Label
+			job.Rows = newDF.Rows
+			job.Start = unixMilliOrZero(newDF.StartTime)
+			job.End = unixMilliOrZero(newDF.EndTime)
+		}
+		finished := *job
+		j.mu.Unlock()
+		j.events.publish(run.session, "job", finished)
+	}
+}
+
+func (j *indexJobs) update(id string, fn func(*IndexJob)) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if job, ok := j.jobs[id]; ok {
+		fn(job)
+	}
+}
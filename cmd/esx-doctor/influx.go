@@ -0,0 +1,582 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Captures map onto InfluxDB line protocol one PDH object instance per
+// line: \\host\Group Cpu(100:vm)\% Ready becomes measurement "Group Cpu",
+// tags host and instance, field "% Ready". Importing reverses the mapping,
+// so a capture exported and read back keeps its column names.
+
+// influxPrecisions are the timestamp units Influx and Telegraf accept.
+var influxPrecisions = map[string]time.Duration{
+	"ns": time.Nanosecond,
+	"us": time.Microsecond,
+	"ms": time.Millisecond,
+	"s":  time.Second,
+}
+
+// influxReorderWindow is how many distinct timestamps an import holds back
+// before writing the oldest row, which absorbs the interleaving of batched
+// Telegraf output without buffering the whole file.
+const influxReorderWindow = 1024
+
+func parseInfluxPrecision(v string) (time.Duration, error) {
+	v = strings.TrimSpace(strings.ToLower(v))
+	if v == "" {
+		return time.Nanosecond, nil
+	}
+	unit, ok := influxPrecisions[v]
+	if !ok {
+		return 0, fmt.Errorf("unknown precision %q (use ns, us, ms or s)", v)
+	}
+	return unit, nil
+}
+
+// influxFilename names an export like exportFilename, with the .lp
+// extension.
+func influxFilename(df *DataFile, start, end time.Time) string {
+	return strings.TrimSuffix(exportFilename(df, start, end), ".csv") + ".lp"
+}
+
+type influxSeries struct {
+	prefix string // escaped "measurement,tag=value" ready for writing
+	fields []influxField
+}
+
+type influxField struct {
+	key string // escaped
+	idx int
+}
+
+// influxSeriesFor groups cols by object instance, in column order.
+func influxSeriesFor(df *DataFile, cols []int) []influxSeries {
+	var out []influxSeries
+	byPrefix := map[string]int{}
+	for _, idx := range cols {
+		c := parsePDHColumnBackend(df.Columns[idx], idx)
+		measurement, field := c.Object, c.Counter
+		var b strings.Builder
+		if c.Raw == c.Counter {
+			// Not a PDH path: keep the header as the field name.
+			measurement = "esxtop"
+		}
+		b.WriteString(escapeInflux(measurement, ", "))
+		if host := pdhHost(c.Raw); host != "" {
+			b.WriteString(",host=")
+			b.WriteString(escapeInflux(host, ",= "))
+		}
+		if parts := strings.Split(c.Raw, "\\"); c.Raw != c.Counter && len(parts) > 3 && strings.Contains(parts[3], "(") {
+			b.WriteString(",instance=")
+			b.WriteString(escapeInflux(c.Instance, ",= "))
+		}
+		prefix := b.String()
+		i, ok := byPrefix[prefix]
+		if !ok {
+			i = len(out)
+			byPrefix[prefix] = i
+			out = append(out, influxSeries{prefix: prefix})
+		}
+		out[i].fields = append(out[i].fields, influxField{key: escapeInflux(field, ",= "), idx: idx})
+	}
+	return out
+}
+
+func escapeInflux(s, special string) string {
+	if !strings.ContainsAny(s, special) {
+		return s
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if strings.IndexByte(special, s[i]) >= 0 {
+			b.WriteByte('\\')
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
+
+// appendInfluxValue appends raw as a line protocol field value: numbers as
+// floats, anything else (latency sensitivity levels, power policies) as a
+// string. It reports false for an empty or non-finite cell, which gets no
+// field.
+func appendInfluxValue(buf []byte, raw string) ([]byte, bool) {
+	raw = strings.TrimSpace(raw)
+	if raw == "" {
+		return buf, false
+	}
+	if v, err := strconv.ParseFloat(raw, 64); err == nil {
+		if !NumberFinite(v) {
+			return buf, false
+		}
+		return strconv.AppendFloat(buf, v, 'g', -1, 64), true
+	}
+	buf = append(buf, '"')
+	for i := 0; i < len(raw); i++ {
+		if raw[i] == '"' || raw[i] == '\\' {
+			buf = append(buf, '\\')
+		}
+		buf = append(buf, raw[i])
+	}
+	return append(buf, '"'), true
+}
+
+// writeInflux streams the rows of df within [start, end] as line protocol
+// with the capture's own timestamps in unit. With no columns every column
+// is written. Like writeSlice it stops once ctx is done.
+func writeInflux(ctx context.Context, w io.Writer, df *DataFile, cols []int, start, end time.Time, unit time.Duration) (int64, error) {
+	for _, idx := range cols {
+		if idx <= 0 || idx >= len(df.Columns) {
+			return 0, fmt.Errorf("column %d out of range", idx)
+		}
+	}
+	if len(cols) == 0 {
+		for idx := 1; idx < len(df.Columns); idx++ {
+			cols = append(cols, idx)
+		}
+	}
+	series := influxSeriesFor(df, cols)
+	bw := bufio.NewWriterSize(w, exportChunkSize)
+
+	startOffset, _ := df.findOffset(start)
+	f, data, err := df.openData(startOffset)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	lines := getLineReader(data)
+	defer lines.release()
+	var buf []byte
+	var rows, seen int64
+	for {
+		line, err := lines.next()
+		if err != nil && !errors.Is(err, io.EOF) {
+			return rows, err
+		}
+		if len(line) == 0 && errors.Is(err, io.EOF) {
+			break
+		}
+		if seen++; seen%exportCheckRows == 0 {
+			if cerr := ctx.Err(); cerr != nil {
+				return rows, cerr
+			}
+		}
+		record, perr := lines.record(line)
+		if perr == nil && len(record) > 0 {
+			ts, _, terr := parseTimeValue(record[0])
+			if terr == nil {
+				if !end.IsZero() && ts.After(end) {
+					break
+				}
+				if start.IsZero() || !ts.Before(start) {
+					stamp := ts.UnixNano() / int64(unit)
+					for _, s := range series {
+						buf = append(buf[:0], s.prefix...)
+						written := 0
+						for _, fld := range s.fields {
+							if fld.idx >= len(record) {
+								continue
+							}
+							mark := len(buf)
+							if written == 0 {
+								buf = append(buf, ' ')
+							} else {
+								buf = append(buf, ',')
+							}
+							buf = append(buf, fld.key...)
+							buf = append(buf, '=')
+							var ok bool
+							if buf, ok = appendInfluxValue(buf, record[fld.idx]); !ok {
+								buf = buf[:mark]
+								continue
+							}
+							written++
+						}
+						if written > 0 {
+							buf = append(buf, ' ')
+							buf = strconv.AppendInt(buf, stamp, 10)
+							bw.Write(append(buf, '\n'))
+						}
+					}
+					rows++
+				}
+			}
+		}
+		if errors.Is(err, io.EOF) {
+			break
+		}
+	}
+	return rows, bw.Flush()
+}
+
+// influxPoint is one parsed line: a series key, its fields as CSV cell text
+// and the timestamp in ns.
+type influxPoint struct {
+	measurement string
+	tags        [][2]string
+	fields      [][2]string
+	ts          int64
+}
+
+// cutInflux splits s at the first sep that is neither escaped nor inside a
+// double-quoted string.
+func cutInflux(s string, sep byte) (string, string, bool) {
+	quoted := false
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case c == '\\':
+			i++
+		case c == '"':
+			quoted = !quoted
+		case c == sep && !quoted:
+			return s[:i], s[i+1:], true
+		}
+	}
+	return s, "", false
+}
+
+func splitInflux(s string, sep byte) []string {
+	var out []string
+	for {
+		part, rest, ok := cutInflux(s, sep)
+		out = append(out, part)
+		if !ok {
+			return out
+		}
+		s = rest
+	}
+}
+
+// unescapeInflux drops the backslash in front of escaped characters.
+func unescapeInflux(s string) string {
+	if !strings.Contains(s, `\`) {
+		return s
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+1 < len(s) && strings.IndexByte(`,= "\`, s[i+1]) >= 0 {
+			i++
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
+
+// influxFieldValue turns a field value into the text a capture cell would
+// hold.
+func influxFieldValue(v string) (string, error) {
+	switch {
+	case strings.HasPrefix(v, `"`):
+		if len(v) < 2 || !strings.HasSuffix(v, `"`) {
+			return "", fmt.Errorf("unterminated string %s", v)
+		}
+		return unescapeInflux(v[1 : len(v)-1]), nil
+	case v == "t" || v == "T" || strings.EqualFold(v, "true"):
+		return "true", nil
+	case v == "f" || v == "F" || strings.EqualFold(v, "false"):
+		return "false", nil
+	case strings.HasSuffix(v, "i") || strings.HasSuffix(v, "u"):
+		if _, err := strconv.ParseInt(v[:len(v)-1], 10, 64); err != nil {
+			if _, err := strconv.ParseUint(v[:len(v)-1], 10, 64); err != nil {
+				return "", fmt.Errorf("invalid integer %s", v)
+			}
+		}
+		return v[:len(v)-1], nil
+	}
+	f, err := strconv.ParseFloat(v, 64)
+	if err != nil || !NumberFinite(f) {
+		return "", fmt.Errorf("invalid field value %s", v)
+	}
+	return strconv.FormatFloat(f, 'g', -1, 64), nil
+}
+
+func parseInfluxLine(line string, unit time.Duration) (influxPoint, error) {
+	var p influxPoint
+	sections := splitInflux(line, ' ')
+	if len(sections) != 3 {
+		if len(sections) == 2 {
+			return p, fmt.Errorf("missing timestamp")
+		}
+		return p, fmt.Errorf("expected series, fields and timestamp")
+	}
+	key := splitInflux(sections[0], ',')
+	p.measurement = unescapeInflux(key[0])
+	if p.measurement == "" {
+		return p, fmt.Errorf("missing measurement")
+	}
+	for _, tag := range key[1:] {
+		k, v, ok := cutInflux(tag, '=')
+		if !ok || k == "" || v == "" {
+			return p, fmt.Errorf("invalid tag %q", tag)
+		}
+		p.tags = append(p.tags, [2]string{unescapeInflux(k), unescapeInflux(v)})
+	}
+	for _, field := range splitInflux(sections[1], ',') {
+		k, v, ok := cutInflux(field, '=')
+		if !ok || k == "" {
+			return p, fmt.Errorf("invalid field %q", field)
+		}
+		text, err := influxFieldValue(v)
+		if err != nil {
+			return p, err
+		}
+		p.fields = append(p.fields, [2]string{unescapeInflux(k), text})
+	}
+	ts, err := strconv.ParseInt(sections[2], 10, 64)
+	if err != nil {
+		return p, fmt.Errorf("invalid timestamp %q", sections[2])
+	}
+	if ts > math.MaxInt64/int64(unit) || ts < math.MinInt64/int64(unit) {
+		return p, fmt.Errorf("timestamp %d out of range for precision", ts)
+	}
+	p.ts = ts * int64(unit)
+	return p, nil
+}
+
+// influxColumnPrefix is the PDH path a point's fields become columns under:
+// the host tag is the machine, the instance tag (or the remaining tag
+// values, for series written by other Telegraf inputs) the instance.
+func influxColumnPrefix(p influxPoint) string {
+	host, instance := "influx", ""
+	var rest []string
+	for _, t := range p.tags {
+		switch t[0] {
+		case "host":
+			host = t[1]
+		case "instance":
+			instance = t[1]
+		default:
+			rest = append(rest, t[0]+"\x00"+t[1])
+		}
+	}
+	if instance == "" && len(rest) > 0 {
+		sort.Strings(rest)
+		for i, kv := range rest {
+			rest[i] = kv[strings.IndexByte(kv, 0)+1:]
+		}
+		instance = strings.Join(rest, ":")
+	}
+	if instance == "" {
+		return `\\` + host + `\` + p.measurement + `\`
+	}
+	return `\\` + host + `\` + p.measurement + "(" + instance + `)\`
+}
+
+// eachInfluxPoint calls fn for every point in r, skipping blank and comment
+// lines. Errors name the line.
+func eachInfluxPoint(r io.Reader, unit time.Duration, fn func(influxPoint) error) error {
+	lines := getLineReader(r)
+	defer lines.release()
+	for n := 1; ; n++ {
+		line, err := lines.next()
+		if err != nil && !errors.Is(err, io.EOF) {
+			return err
+		}
+		if text := strings.TrimSpace(string(line)); text != "" && !strings.HasPrefix(text, "#") {
+			p, perr := parseInfluxLine(text, unit)
+			if perr != nil {
+				return fmt.Errorf("line %d: %w", n, perr)
+			}
+			if ferr := fn(p); ferr != nil {
+				return fmt.Errorf("line %d: %w", n, ferr)
+			}
+		}
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+	}
+}
+
+// convertInflux writes the line protocol file at src as an esxtop CSV. The
+// first pass collects the columns for the header; the second fills rows,
+// holding back influxReorderWindow timestamps so points of one sample may
+// arrive interleaved with the next. A point older than a row already
+// written is an error: the input is not time ordered.
+func convertInflux(src string, dst io.Writer, unit time.Duration) (int64, error) {
+	f, err := os.Open(src)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	columns := map[string]int{}
+	header := []string{"(PDH-CSV 4.0) (UTC)(0)"}
+	err = eachInfluxPoint(f, unit, func(p influxPoint) error {
+		prefix := influxColumnPrefix(p)
+		for _, fld := range p.fields {
+			name := prefix + fld[0]
+			if _, ok := columns[name]; !ok {
+				columns[name] = len(header)
+				header = append(header, name)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	if len(header) == 1 {
+		return 0, fmt.Errorf("no points found")
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return 0, err
+	}
+
+	bw := bufio.NewWriterSize(dst, exportChunkSize)
+	writeQuotedCSVRow(bw, header)
+	pending := map[int64][]string{}
+	var order []int64 // pending timestamps, ascending
+	var rows int64
+	written, last := false, int64(0)
+	flush := func() {
+		ts := order[0]
+		order = order[1:]
+		row := pending[ts]
+		delete(pending, ts)
+		t := time.Unix(0, ts).UTC()
+		if t.Nanosecond() == 0 {
+			row[0] = t.Format(timeLayouts[0])
+		} else {
+			row[0] = t.Format(timeLayouts[1])
+		}
+		writeQuotedCSVRow(bw, row)
+		written, last = true, ts
+		rows++
+	}
+	err = eachInfluxPoint(f, unit, func(p influxPoint) error {
+		if written && p.ts <= last {
+			return fmt.Errorf("point at %s arrived after later samples were written; sort the input by time", time.Unix(0, p.ts).UTC().Format(time.RFC3339Nano))
+		}
+		row, ok := pending[p.ts]
+		if !ok {
+			row = make([]string, len(header))
+			pending[p.ts] = row
+			i := sort.Search(len(order), func(i int) bool { return order[i] > p.ts })
+			order = append(order, 0)
+			copy(order[i+1:], order[i:])
+			order[i] = p.ts
+		}
+		prefix := influxColumnPrefix(p)
+		for _, fld := range p.fields {
+			row[columns[prefix+fld[0]]] = fld[1]
+		}
+		for len(order) > influxReorderWindow {
+			flush()
+		}
+		return nil
+	})
+	if err != nil {
+		return rows, err
+	}
+	for len(order) > 0 {
+		flush()
+	}
+	return rows, bw.Flush()
+}
+
+// runInflux implements "esx-doctor influx import|export".
+func runInflux(args []string) int {
+	usage := func() {
+		fmt.Fprintln(os.Stderr, "usage: esx-doctor influx export [-cols 1,2] [-precision ns] [-out x.lp] <file.csv>")
+		fmt.Fprintln(os.Stderr, "       esx-doctor influx import [-precision ns] [-out x.csv] <file.lp>")
+	}
+	if len(args) == 0 {
+		usage()
+		return 2
+	}
+	cmd := args[0]
+	fs := flag.NewFlagSet("influx "+cmd, flag.ContinueOnError)
+	precision := fs.String("precision", "ns", "Timestamp precision: ns, us, ms or s")
+	out := fs.String("out", "", "File to write (export: default stdout; import: default x.csv next to the input)")
+	colsFlag := fs.String("cols", "", "Comma-separated column indexes to export (default all)")
+	if err := fs.Parse(args[1:]); err != nil {
+		return 2
+	}
+	if fs.NArg() != 1 || (cmd != "export" && cmd != "import") {
+		usage()
+		return 2
+	}
+	unit, err := parseInfluxPrecision(*precision)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	path, err := filepath.Abs(fs.Arg(0))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "invalid path: %v\n", err)
+		return 1
+	}
+
+	if cmd == "import" {
+		dest := *out
+		if strings.TrimSpace(dest) == "" {
+			dest = strings.TrimSuffix(path, filepath.Ext(path)) + ".csv"
+		}
+		if dest == path {
+			fmt.Fprintln(os.Stderr, "refusing to overwrite the input; pass -out")
+			return 2
+		}
+		w, err := os.Create(dest)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "create %s: %v\n", dest, err)
+			return 1
+		}
+		rows, err := convertInflux(path, w, unit)
+		if cerr := w.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			_ = os.Remove(dest)
+			fmt.Fprintf(os.Stderr, "import failed: %v\n", err)
+			return 1
+		}
+		fmt.Fprintf(os.Stderr, "wrote %d rows to %s\n", rows, dest)
+		return 0
+	}
+
+	var cols []int
+	for _, raw := range strings.Split(*colsFlag, ",") {
+		if raw = strings.TrimSpace(raw); raw == "" {
+			continue
+		}
+		idx, err := strconv.Atoi(raw)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "invalid column %q\n", raw)
+			return 2
+		}
+		cols = append(cols, idx)
+	}
+	df, err := loadOrBuildIndex(path)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "index build failed: %v\n", err)
+		return 1
+	}
+	var w io.Writer = os.Stdout
+	if *out != "" {
+		file, err := os.Create(*out)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "create %s: %v\n", *out, err)
+			return 1
+		}
+		defer file.Close()
+		w = file
+	}
+	if _, err := writeInflux(context.Background(), w, df, cols, time.Time{}, time.Time{}, unit); err != nil {
+		fmt.Fprintf(os.Stderr, "export failed: %v\n", err)
+		return 1
+	}
+	return 0
+}
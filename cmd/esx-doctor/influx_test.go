@@ -0,0 +1,171 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// influxTestCSV has an instance with a space, a comma and an equals sign,
+// a host with a space, and a string counter whose values need quoting.
+const influxTestCSV = `"(PDH-CSV 4.0) (UTC)(0)","\\esx 01\Group Cpu(1:vm a,b=c)\% Ready","\\esx 01\Group Cpu(1:vm a,b=c)\Latency Sensitivity","\\esx 01\Memory\Free MBytes"
+"01/01/2024 00:00:00","1.5","normal","100"
+"01/01/2024 00:00:05.250","","say ""hi"", x=1","101"
+"01/01/2024 00:00:10","2","","102.5"
+`
+
+func influxTestFile(t *testing.T) *DataFile {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "capture.csv")
+	if err := os.WriteFile(path, []byte(influxTestCSV), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	df, err := buildIndex(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return df
+}
+
+func TestWriteInfluxEscaping(t *testing.T) {
+	df := influxTestFile(t)
+	var buf bytes.Buffer
+	rows, err := writeInflux(context.Background(), &buf, df, nil, time.Time{}, time.Time{}, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rows != 3 {
+		t.Errorf("rows = %d, want 3", rows)
+	}
+	want := `Group\ Cpu,host=esx\ 01,instance=1:vm\ a\,b\=c %\ Ready=1.5,Latency\ Sensitivity="normal" 1704067200
+Memory,host=esx\ 01 Free\ MBytes=100 1704067200
+Group\ Cpu,host=esx\ 01,instance=1:vm\ a\,b\=c Latency\ Sensitivity="say \"hi\", x=1" 1704067205
+Memory,host=esx\ 01 Free\ MBytes=101 1704067205
+Group\ Cpu,host=esx\ 01,instance=1:vm\ a\,b\=c %\ Ready=2 1704067210
+Memory,host=esx\ 01 Free\ MBytes=102.5 1704067210
+`
+	if got := buf.String(); got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestWriteInfluxPrecision(t *testing.T) {
+	df := influxTestFile(t)
+	tests := []struct {
+		precision string
+		want      string // the timestamp of the 00:00:05.250 row
+	}{
+		{"", "1704067205250000000"},
+		{"ns", "1704067205250000000"},
+		{"us", "1704067205250000"},
+		{"MS", "1704067205250"},
+		{"s", "1704067205"},
+	}
+	for _, tt := range tests {
+		unit, err := parseInfluxPrecision(tt.precision)
+		if err != nil {
+			t.Errorf("%q: %v", tt.precision, err)
+			continue
+		}
+		var buf bytes.Buffer
+		start := time.Date(2024, 1, 1, 0, 0, 5, 0, time.UTC)
+		if _, err := writeInflux(context.Background(), &buf, df, []int{3}, start, start.Add(time.Second), unit); err != nil {
+			t.Errorf("%q: %v", tt.precision, err)
+			continue
+		}
+		if got, want := buf.String(), `Memory,host=esx\ 01 Free\ MBytes=101 `+tt.want+"\n"; got != want {
+			t.Errorf("%q: got %q, want %q", tt.precision, got, want)
+		}
+	}
+	if _, err := parseInfluxPrecision("h"); err == nil || !strings.Contains(err.Error(), `unknown precision "h"`) {
+		t.Errorf("precision h: err = %v", err)
+	}
+}
+
+func TestParseInfluxLine(t *testing.T) {
+	p, err := parseInfluxLine(`Group\ Cpu,host=esx\ 01,instance=1:vm\ a\,b\=c %\ Ready=1.5,Latency\ Sensitivity="say \"hi\", x=1",Count=3i 1704067205250`, time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := influxPoint{
+		measurement: "Group Cpu",
+		tags:        [][2]string{{"host", "esx 01"}, {"instance", "1:vm a,b=c"}},
+		fields:      [][2]string{{"% Ready", "1.5"}, {"Latency Sensitivity", `say "hi", x=1`}, {"Count", "3"}},
+		ts:          1704067205250000000,
+	}
+	if !reflect.DeepEqual(p, want) {
+		t.Errorf("got %+v, want %+v", p, want)
+	}
+	if got := influxColumnPrefix(p); got != `\\esx 01\Group Cpu(1:vm a,b=c)\` {
+		t.Errorf("column prefix %q", got)
+	}
+
+	errs := []struct {
+		line string
+		unit time.Duration
+		want string
+	}{
+		{"cpu usage=1", time.Nanosecond, "missing timestamp"},
+		{"cpu", time.Nanosecond, "expected series, fields and timestamp"},
+		{",host=a usage=1 1", time.Nanosecond, "missing measurement"},
+		{"cpu,host usage=1 1", time.Nanosecond, `invalid tag "host"`},
+		{"cpu usage 1", time.Nanosecond, `invalid field "usage"`},
+		{"cpu usage=high 1", time.Nanosecond, "invalid field value high"},
+		{`cpu usage="open 1`, time.Nanosecond, "missing timestamp"},
+		{"cpu usage=1 soon", time.Nanosecond, `invalid timestamp "soon"`},
+		{"cpu usage=1 9223372036854775807", time.Nanosecond, ""},
+		{"cpu usage=1 9223372036854775807", time.Second, "out of range for precision"},
+		{"cpu usage=1 -9223372036855", time.Millisecond, "out of range for precision"},
+	}
+	for _, tt := range errs {
+		_, err := parseInfluxLine(tt.line, tt.unit)
+		switch {
+		case tt.want == "" && err != nil:
+			t.Errorf("%q: %v", tt.line, err)
+		case tt.want != "" && (err == nil || !strings.Contains(err.Error(), tt.want)):
+			t.Errorf("%q: err = %v, want %q", tt.line, err, tt.want)
+		}
+	}
+}
+
+// TestInfluxRoundTrip exports the capture and imports it again: column
+// names with backslashes, spaces and escaped characters, values and
+// sub-second timestamps all come back as they were.
+func TestInfluxRoundTrip(t *testing.T) {
+	df := influxTestFile(t)
+	want, err := csv.NewReader(strings.NewReader(influxTestCSV)).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, unit := range []time.Duration{time.Nanosecond, time.Millisecond} {
+		var lp bytes.Buffer
+		if _, err := writeInflux(context.Background(), &lp, df, nil, time.Time{}, time.Time{}, unit); err != nil {
+			t.Fatal(err)
+		}
+		src := filepath.Join(t.TempDir(), "capture.lp")
+		if err := os.WriteFile(src, lp.Bytes(), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		var out bytes.Buffer
+		rows, err := convertInflux(src, &out, unit)
+		if err != nil {
+			t.Fatalf("%v: %v", unit, err)
+		}
+		if rows != 3 {
+			t.Errorf("%v: rows = %d, want 3", unit, rows)
+		}
+		got, err := csv.NewReader(&out).ReadAll()
+		if err != nil {
+			t.Fatalf("%v: %v", unit, err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("%v: import gives\n%q\nwant\n%q", unit, got, want)
+		}
+	}
+}
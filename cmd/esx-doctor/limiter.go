@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// scanLimiter bounds how many scan-heavy requests (diagnostics, wide series
+// reads) run at once. Extra requests wait in a bounded queue; once the queue
+// is full, or a request has waited too long, the client gets 503 with a
+// Retry-After hint instead of piling more work onto the host.
+type scanLimiter struct {
+	slots   chan struct{}
+	queue   chan struct{}
+	maxWait time.Duration
+}
+
+func newScanLimiter(concurrent, queued int, maxWait time.Duration) *scanLimiter {
+	if concurrent < 1 {
+		concurrent = 1
+	}
+	if queued < 0 {
+		queued = 0
+	}
+	return &scanLimiter{
+		slots:   make(chan struct{}, concurrent),
+		queue:   make(chan struct{}, concurrent+queued),
+		maxWait: maxWait,
+	}
+}
+
+func (l *scanLimiter) retryAfter() string {
+	secs := int(l.maxWait / time.Second)
+	if secs < 1 {
+		secs = 1
+	}
+	return strconv.Itoa(secs)
+}
+
+func (l *scanLimiter) reject(w http.ResponseWriter) {
+	w.Header().Set("Retry-After", l.retryAfter())
+	writeJSON(w, http.StatusServiceUnavailable, map[string]string{"error": "server busy, retry later"})
+}
+
+func (l *scanLimiter) wrap(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		release, ok := l.acquire(r.Context())
+		if !ok {
+			if r.Context().Err() == nil {
+				l.reject(w)
+			}
+			return
+		}
+		defer release()
+		h(w, r)
+	}
+}
+
+// acquire takes a slot for a scan that isn't an HTTP handler (the gRPC
+// series stream). ok is false when the queue is full, the wait timed out
+// or ctx ended; otherwise release must be called when the scan is done.
+func (l *scanLimiter) acquire(ctx context.Context) (release func(), ok bool) {
+	select {
+	case l.queue <- struct{}{}:
+	default:
+		return nil, false
+	}
+	timer := time.NewTimer(l.maxWait)
+	defer timer.Stop()
+	select {
+	case l.slots <- struct{}{}:
+	case <-timer.C:
+		<-l.queue
+		return nil, false
+	case <-ctx.Done():
+		<-l.queue
+		return nil, false
+	}
+	return func() {
+		<-l.slots
+		<-l.queue
+	}, true
+}
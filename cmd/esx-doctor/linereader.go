@@ -0,0 +1,153 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"sync"
+)
+
+// Captures with 100k+ columns have multi-megabyte lines. ReadBytes copies
+// each one into a fresh slice and csv.Reader allocates its own buffers per
+// line, so scanning a file used to churn through gigabytes of garbage.
+// lineReader and fieldScanner reuse their buffers across lines and across
+// scans instead.
+
+const (
+	lineReaderSize = 4 * 1024 * 1024
+	// maxPooledLineBuf drops line buffers grown past this size on release,
+	// so one pathological capture doesn't pin its longest line forever.
+	maxPooledLineBuf = 64 * 1024 * 1024
+)
+
+var lineReaderPool = sync.Pool{
+	New: func() any {
+		return &lineReader{r: bufio.NewReaderSize(nil, lineReaderSize)}
+	},
+}
+
+// lineReader returns lines like bufio.Reader.ReadBytes('\n'), but the slice
+// it returns is only valid until the next call to next.
+type lineReader struct {
+	r      *bufio.Reader
+	long   []byte
+	fields fieldScanner
+}
+
+// getLineReader takes a reader from the pool; callers release it when done.
+func getLineReader(src io.Reader) *lineReader {
+	lr := lineReaderPool.Get().(*lineReader)
+	lr.r.Reset(src)
+	return lr
+}
+
+func (lr *lineReader) release() {
+	lr.r.Reset(nil)
+	if cap(lr.long) > maxPooledLineBuf {
+		lr.long = nil
+	}
+	lr.fields.release()
+	lineReaderPool.Put(lr)
+}
+
+// next returns the next line including its '\n', with the same error
+// semantics as ReadBytes: io.EOF comes with the unterminated last line.
+// Lines that fit the read buffer are returned without copying.
+func (lr *lineReader) next() ([]byte, error) {
+	line, err := lr.r.ReadSlice('\n')
+	if err != bufio.ErrBufferFull {
+		return line, err
+	}
+	lr.long = append(lr.long[:0], line...)
+	for err == bufio.ErrBufferFull {
+		line, err = lr.r.ReadSlice('\n')
+		lr.long = append(lr.long, line...)
+	}
+	return lr.long, err
+}
+
+// record parses line with the configured quoting, like readCSVLine. The
+// returned slice is reused by the next call.
+func (lr *lineReader) record(line []byte) ([]string, error) {
+	return lr.fields.scan(line, !strictCSV)
+}
+
+// fieldScanner splits one CSV line into fields. The fields of a line share
+// a single string, and the returned slice is reused by the next scan, so a
+// caller that keeps a field past the row should strings.Clone it rather
+// than pin the whole line.
+//
+// Only the shapes esxtop writes are handled here: plain fields and quoted
+// fields with "" escapes. Anything else (bare or stray quotes, a quote left
+// open) goes to encoding/csv so results and errors stay exactly the same.
+type fieldScanner struct {
+	buf    []byte
+	ends   []int
+	record []string
+}
+
+func (s *fieldScanner) release() {
+	if cap(s.buf) > maxPooledLineBuf {
+		s.buf, s.ends, s.record = nil, nil, nil
+	}
+}
+
+func (s *fieldScanner) scan(line []byte, lazy bool) ([]string, error) {
+	line = bytes.TrimRight(line, "\r\n")
+	if len(line) == 0 {
+		// csv.Reader skips blank lines and reports the end of input.
+		return nil, io.EOF
+	}
+	s.buf, s.ends = s.buf[:0], s.ends[:0]
+	for pos := 0; ; {
+		if pos < len(line) && line[pos] == '"' {
+			i := pos + 1
+			for {
+				j := bytes.IndexByte(line[i:], '"')
+				if j < 0 {
+					return parseCSVRecord(line, lazy)
+				}
+				s.buf = append(s.buf, line[i:i+j]...)
+				i += j + 1
+				if i < len(line) && line[i] == '"' {
+					s.buf = append(s.buf, '"')
+					i++
+					continue
+				}
+				break
+			}
+			s.ends = append(s.ends, len(s.buf))
+			if i == len(line) {
+				break
+			}
+			if line[i] != ',' {
+				return parseCSVRecord(line, lazy)
+			}
+			pos = i + 1
+			continue
+		}
+		end := len(line)
+		if j := bytes.IndexByte(line[pos:], ','); j >= 0 {
+			end = pos + j
+		}
+		field := line[pos:end]
+		if bytes.IndexByte(field, '"') >= 0 {
+			return parseCSVRecord(line, lazy)
+		}
+		s.buf = append(s.buf, field...)
+		s.ends = append(s.ends, len(s.buf))
+		if end == len(line) {
+			break
+		}
+		pos = end + 1
+	}
+
+	str := string(s.buf)
+	s.record = s.record[:0]
+	start := 0
+	for _, end := range s.ends {
+		s.record = append(s.record, str[start:end])
+		start = end
+	}
+	return s.record, nil
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ChartLink is a chart as someone was looking at it, stored server-side so
+// it can be shared as a short /l/<token> URL instead of a query string that
+// chat tools mangle. Columns are kept by header name, so a link still
+// resolves after the capture is re-indexed or reopened with another
+// column order.
+type ChartLink struct {
+	Token   string `json:"token"`
+	Created int64  `json:"created"`
+	// File and ContentHash identify the capture the link was made on.
+	File        string `json:"file,omitempty"`
+	ContentHash string `json:"contentHash,omitempty"`
+
+	Report    string   `json:"report,omitempty"`
+	Attribute string   `json:"attribute,omitempty"`
+	Columns   []string `json:"columns"`
+	// Start and End are the visible range; zero means the whole capture.
+	Start int64 `json:"start,omitempty"`
+	End   int64 `json:"end,omitempty"`
+	// FilterMin and FilterMax are the chart's value filter.
+	FilterMin *float64 `json:"filterMin,omitempty"`
+	FilterMax *float64 `json:"filterMax,omitempty"`
+}
+
+const (
+	linkTokenLen = 8
+	// maxLinks bounds the store; the oldest links go first.
+	maxLinks         = 10000
+	maxLinkColumns   = 2000
+	linkTokenLetters = "abcdefghijkmnpqrstuvwxyz23456789"
+)
+
+type linkStore struct {
+	mu    sync.RWMutex
+	path  string
+	links map[string]ChartLink
+}
+
+func defaultLinkStorePath() string {
+	home, err := os.UserHomeDir()
+	if err != nil || strings.TrimSpace(home) == "" {
+		return ".esx-doctor-links.json"
+	}
+	return filepath.Join(home, ".esx-doctor", "links.json")
+}
+
+func newLinkStore(path string) (*linkStore, error) {
+	if strings.TrimSpace(path) == "" {
+		path = defaultLinkStorePath()
+	}
+	s := &linkStore{path: path, links: map[string]ChartLink{}}
+	if err := s.load(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *linkStore) load() error {
+	data, err := os.ReadFile(s.path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	var payload struct {
+		Links []ChartLink `json:"links"`
+	}
+	if err := json.Unmarshal(data, &payload); err != nil {
+		return fmt.Errorf("invalid link store file: %w", err)
+	}
+	for _, l := range payload.Links {
+		if l.Token != "" {
+			s.links[l.Token] = l
+		}
+	}
+	return nil
+}
+
+// reload re-reads links from disk, discarding in-memory state.
+func (s *linkStore) reload() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	prev := s.links
+	s.links = map[string]ChartLink{}
+	if err := s.load(); err != nil {
+		s.links = prev
+		return err
+	}
+	return nil
+}
+
+func (s *linkStore) persistLocked() error {
+	if err := os.MkdirAll(filepath.Dir(s.path), 0o755); err != nil {
+		return err
+	}
+	out := make([]ChartLink, 0, len(s.links))
+	for _, l := range s.links {
+		out = append(out, l)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Created < out[j].Created })
+	data, err := json.MarshalIndent(map[string]any{"links": out}, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(s.path, data, 0o644)
+}
+
+func newLinkToken() (string, error) {
+	b := make([]byte, linkTokenLen)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	for i := range b {
+		b[i] = linkTokenLetters[int(b[i])%len(linkTokenLetters)]
+	}
+	return string(b), nil
+}
+
+// create stores l under a new token.
+func (s *linkStore) create(l ChartLink) (ChartLink, error) {
+	if len(l.Columns) == 0 {
+		return l, fmt.Errorf("a link needs at least one column")
+	}
+	if len(l.Columns) > maxLinkColumns {
+		return l, fmt.Errorf("too many columns for a link (%d, max %d)", len(l.Columns), maxLinkColumns)
+	}
+	if l.Start > 0 && l.End > 0 && l.End < l.Start {
+		return l, fmt.Errorf("end is before start")
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for {
+		token, err := newLinkToken()
+		if err != nil {
+			return l, err
+		}
+		if _, taken := s.links[token]; !taken {
+			l.Token = token
+			break
+		}
+	}
+	l.Created = time.Now().UnixMilli()
+	s.links[l.Token] = l
+	if len(s.links) > maxLinks {
+		oldest := ""
+		for t, x := range s.links {
+			if oldest == "" || x.Created < s.links[oldest].Created {
+				oldest = t
+			}
+		}
+		delete(s.links, oldest)
+	}
+	if err := s.persistLocked(); err != nil {
+		delete(s.links, l.Token)
+		return l, err
+	}
+	return l, nil
+}
+
+func (s *linkStore) get(token string) (ChartLink, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	l, ok := s.links[strings.ToLower(strings.TrimSpace(token))]
+	return l, ok
+}
@@ -0,0 +1,253 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// -live runs esxtop in batch mode (or whatever -live-cmd names, such as
+// resxtop against a remote host) and serves its output as it is written.
+// The rows go to a capture file under ~/.esx-doctor/live, which is followed
+// like -follow does (see follow.go), so nothing about a live capture is
+// special but the /api/meta "live" block telling the UI to keep following
+// the tail.
+//
+// esxtop's stdout is copied through a pipe rather than handed the file, so
+// when esx-doctor exits the next batch write fails and esxtop stops too.
+
+// liveStartTimeout is how long -live waits for esxtop to print its header
+// before giving up; resxtop may first spend a while logging in.
+const liveStartTimeout = time.Minute
+
+// LiveStatus is the "live" block of /api/meta and the body of /api/live.
+type LiveStatus struct {
+	Running  bool   `json:"running"`
+	Command  string `json:"command"`
+	File     string `json:"file"`
+	Interval int64  `json:"intervalMs,omitempty"`
+	Started  int64  `json:"started"`
+	Exited   int64  `json:"exited,omitempty"`
+	Bytes    int64  `json:"bytes"`
+	Error    string `json:"error,omitempty"`
+}
+
+type liveCapture struct {
+	mu       sync.Mutex
+	path     string
+	args     []string
+	interval time.Duration
+	cmd      *exec.Cmd
+	started  time.Time
+	exited   time.Time
+	bytes    int64
+	err      string
+	stopped  bool // by stop, so the kill is not an error
+	stderr   bytes.Buffer
+	header   chan struct{} // closed once the header line is on disk
+	done     chan struct{}
+	events   *eventLog
+}
+
+func defaultLiveDir() string {
+	home, err := os.UserHomeDir()
+	if err != nil || strings.TrimSpace(home) == "" {
+		return ".esx-doctor-live"
+	}
+	return filepath.Join(home, ".esx-doctor", "live")
+}
+
+// liveCommand is the command line -live runs: command when set, else
+// esxtop in batch mode sampling every interval.
+func liveCommand(command string, interval time.Duration) ([]string, error) {
+	if strings.TrimSpace(command) != "" {
+		return strings.Fields(command), nil
+	}
+	secs := int(interval.Round(time.Second) / time.Second)
+	if secs < 2 {
+		// esxtop refuses delays below two seconds.
+		return nil, fmt.Errorf("-live-interval must be at least 2s")
+	}
+	return []string{"esxtop", "-b", "-d", strconv.Itoa(secs)}, nil
+}
+
+// startLiveCapture runs args, writing its output to a new capture file in
+// dir, and returns once the header has been written.
+func startLiveCapture(args []string, interval time.Duration, dir string, events *eventLog) (*liveCapture, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	name := filepath.Base(args[0]) + "-" + time.Now().UTC().Format("20060102T150405Z") + ".csv"
+	path := filepath.Join(dir, name)
+	out, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	l := &liveCapture{
+		path:     path,
+		args:     args,
+		interval: interval,
+		header:   make(chan struct{}),
+		done:     make(chan struct{}),
+		events:   events,
+	}
+	l.cmd = exec.Command(args[0], args[1:]...)
+	l.cmd.Stderr = &stderrTail{mu: &l.mu, buf: &l.stderr}
+	stdout, err := l.cmd.StdoutPipe()
+	if err != nil {
+		out.Close()
+		return nil, err
+	}
+	if err := l.cmd.Start(); err != nil {
+		out.Close()
+		_ = os.Remove(path)
+		return nil, err
+	}
+	l.started = time.Now()
+	go l.copy(stdout, out)
+
+	select {
+	case <-l.header:
+		return l, nil
+	case <-l.done:
+		st := l.status()
+		_ = os.Remove(path)
+		return nil, fmt.Errorf("%s exited before writing a header: %s", args[0], st.Error)
+	case <-time.After(liveStartTimeout):
+		_ = l.cmd.Process.Kill()
+		<-l.done
+		_ = os.Remove(path)
+		return nil, fmt.Errorf("%s wrote no header within %s", args[0], liveStartTimeout)
+	}
+}
+
+// copy moves esxtop's output to the capture file until it exits.
+func (l *liveCapture) copy(stdout io.Reader, out *os.File) {
+	buf := make([]byte, 64*1024)
+	headerSeen := false
+	var copyErr error
+	for {
+		n, err := stdout.Read(buf)
+		if n > 0 {
+			if _, werr := out.Write(buf[:n]); werr != nil {
+				copyErr = werr
+				break
+			}
+			l.mu.Lock()
+			l.bytes += int64(n)
+			l.mu.Unlock()
+			if !headerSeen && bytes.IndexByte(buf[:n], '\n') >= 0 {
+				headerSeen = true
+				close(l.header)
+			}
+		}
+		if err != nil {
+			if !errors.Is(err, io.EOF) {
+				copyErr = err
+			}
+			break
+		}
+	}
+	if copyErr != nil {
+		// Stop esxtop rather than let it block on a full pipe.
+		_ = l.cmd.Process.Kill()
+		_, _ = io.Copy(io.Discard, stdout)
+	}
+	waitErr := l.cmd.Wait()
+	out.Close()
+
+	l.mu.Lock()
+	l.exited = time.Now()
+	switch {
+	case copyErr != nil:
+		l.err = copyErr.Error()
+	case waitErr != nil && !l.stopped:
+		l.err = waitErr.Error()
+		if msg := strings.TrimSpace(l.stderr.String()); msg != "" {
+			l.err += ": " + lastLine(msg)
+		}
+	}
+	l.mu.Unlock()
+	close(l.done)
+
+	st := l.status()
+	if st.Error != "" {
+		log.Printf("live capture %s stopped: %s", l.path, st.Error)
+	} else {
+		log.Printf("live capture %s finished", l.path)
+	}
+	l.events.publish(nil, "live", st)
+}
+
+func (l *liveCapture) status() LiveStatus {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	st := LiveStatus{
+		Running:  l.exited.IsZero(),
+		Command:  strings.Join(l.args, " "),
+		File:     l.path,
+		Interval: l.interval.Milliseconds(),
+		Started:  unixMilliOrZero(l.started),
+		Exited:   unixMilliOrZero(l.exited),
+		Bytes:    l.bytes,
+		Error:    l.err,
+	}
+	if st.Error == "" && !st.Running && !l.stopped && l.stderr.Len() > 0 {
+		st.Error = lastLine(strings.TrimSpace(l.stderr.String()))
+	}
+	return st
+}
+
+// covers reports whether df is this capture's file.
+func (l *liveCapture) covers(df *DataFile) bool {
+	return l != nil && df != nil && df.Path == l.path
+}
+
+// stop ends the capture and waits for the output to be flushed.
+func (l *liveCapture) stop() {
+	select {
+	case <-l.done:
+		return
+	default:
+	}
+	l.mu.Lock()
+	l.stopped = true
+	l.mu.Unlock()
+	_ = l.cmd.Process.Kill()
+	<-l.done
+}
+
+func lastLine(s string) string {
+	if i := strings.LastIndexByte(s, '\n'); i >= 0 {
+		return s[i+1:]
+	}
+	return s
+}
+
+// stderrTail keeps the last few KiB esxtop writes to stderr, enough for
+// the error that made it exit.
+type stderrTail struct {
+	mu  *sync.Mutex
+	buf *bytes.Buffer
+}
+
+func (t *stderrTail) Write(b []byte) (int, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.buf.Write(b)
+	if t.buf.Len() > 8<<10 {
+		keep := append([]byte(nil), t.buf.Bytes()[t.buf.Len()-4<<10:]...)
+		t.buf.Reset()
+		t.buf.Write(keep)
+	}
+	return len(b), nil
+}
@@ -1,11 +1,11 @@
 package main
 
 import (
-	"bufio"
 	"bytes"
+	"context"
 	"crypto/rand"
+	"crypto/sha256"
 	"embed"
-	"encoding/csv"
 	"encoding/hex"
 	"encoding/json"
 	"errors"
@@ -26,7 +26,7 @@ import (
 	"time"
 )
 
-//go:embed web/* templates/*.json
+//go:embed web/* templates/*.json reports/*.json messages/*.json
 var webFS embed.FS
 
 type IndexEntry struct {
@@ -45,19 +45,92 @@ type DataFile struct {
 	StartTime       time.Time
 	EndTime         time.Time
 	DataStartOffset int64
+	DataEndOffset   int64
 	TimeLayout      string
+	// Truncated is set when the capture ends with a partial row (for example
+	// when esxtop was interrupted mid-write); that row is excluded from the data.
+	Truncated bool
+	// Line quality counters gathered while indexing.
+	MalformedLines int64
+	BadTimestamps  int64
+	ShortRows      int64
+	// ShortRowSamples keeps the first few short rows for the parse report.
+	ShortRowSamples []ShortRow
+	// LenientLines counts lines that only parsed with lenient quoting; the
+	// first few are kept in LenientSamples for the parse report.
+	LenientLines   int64
+	LenientSamples []LenientLine
+	// Size and ModTime describe the file as it was when indexed; they are
+	// compared on access to pick up rolling exports (see refresh).
+	Size    int64
+	ModTime time.Time
+	tailSum uint32
+	// Parts is set for a stitched rotation series (see stitch.go); offsets
+	// then address the parts' data regions laid end to end.
+	Parts []StitchPart
+	// Follow marks a capture that is still being written (see follow.go):
+	// a last line without its newline is left for the next pass instead of
+	// being indexed half-written or reported as Truncated.
+	Follow bool
+	// Remote is set for a resxtop capture, whose headers were normalized
+	// (see resxtop.go).
+	Remote *RemoteCapture
+
+	refreshMu sync.Mutex
+	lastCheck time.Time
+	successor *DataFile
+
+	activityOnce sync.Once
+	activity     []float64
+	activityErr  error
+
+	fingerprintOnce sync.Once
+	fingerprintSum  string
+
+	parsedOnce sync.Once
+	parsed     []parsedColumn
+
+	Provenance Provenance
+	hashState  contentHashState
 }
 
 type Session struct {
-	mu       sync.RWMutex
+	mu sync.RWMutex
+	// id is the session's key, which gRPC clients send back as metadata.
+	id       string
 	df       *DataFile
 	lastSeen time.Time
+	cursor   cursorSync
+	// templatePrefs overrides template Enabled flags for this session when
+	// no user is known; see templatePrefStore.
+	templatePrefs map[string]bool
+	// generation counts file replacements, so an open tab can notice a file
+	// attached from the CLI.
+	generation uint64
+	// compare holds labeled captures kept for comparison (see compare.go).
+	compare []sessionFile
+	// owner, lock and handoff back -shared mode (see ownership.go).
+	owner   *FileOwner
+	lock    *FileLock
+	handoff *FileHandoff
 }
 
 func (s *Session) Get() *DataFile {
 	s.mu.RLock()
-	defer s.mu.RUnlock()
-	return s.df
+	df := s.df
+	s.mu.RUnlock()
+	if df == nil {
+		return nil
+	}
+	latest := df.latest()
+	if latest != df {
+		s.mu.Lock()
+		if s.df == df {
+			s.df = latest
+		}
+		s.mu.Unlock()
+	}
+	return latest
 }
 
 func (s *Session) Touch(now time.Time) {
@@ -77,13 +150,27 @@ func (s *Session) Replace(df *DataFile) {
 	defer s.mu.Unlock()
 	old := s.df
 	s.df = df
-	if old != nil && old.OwnedTemp && old.Path != "" && (df == nil || old.Path != df.Path) {
-		_ = os.Remove(old.Path)
-	}
+	s.generation++
+	s.releaseLocked(old)
+}
+
+func (s *Session) Generation() uint64 {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.generation
 }
 
 func (s *Session) Close() {
+	s.mu.Lock()
+	kept := s.compare
+	s.compare = nil
+	s.mu.Unlock()
 	s.Replace(nil)
+	s.mu.Lock()
+	for _, f := range kept {
+		s.releaseLocked(f.df)
+	}
+	s.mu.Unlock()
 }
 
 type SessionStore struct {
@@ -92,6 +179,9 @@ type SessionStore struct {
 	defaultDF  *DataFile
 	ttl        time.Duration
 	cookieName string
+	// defaults, when set, overrides defaultDF for new sessions of users or
+	// groups with a pinned file.
+	defaults *defaultFileAssignments
 }
 
 func NewSessionStore(defaultDF *DataFile, ttl time.Duration) *SessionStore {
@@ -144,7 +234,13 @@ func (s *SessionStore) SessionForRequest(w http.ResponseWriter, r *http.Request)
 	}
 	sess, ok := s.sessions[id]
 	if !ok {
-		sess = &Session{df: s.defaultDF, lastSeen: now}
+		df := s.defaultDF
+		if s.defaults != nil {
+			if pinned := s.defaults.resolve(r); pinned != nil {
+				df = pinned
+			}
+		}
+		sess = &Session{df: df, lastSeen: now, id: id}
 		s.sessions[id] = sess
 	} else {
 		sess.lastSeen = now
@@ -153,6 +249,21 @@ func (s *SessionStore) SessionForRequest(w http.ResponseWriter, r *http.Request)
 	return sess
 }
 
+// Lookup returns a live session without creating one, unlike
+// SessionForRequest.
+func (s *SessionStore) Lookup(id string) (*Session, bool) {
+	if id == "" {
+		return nil, false
+	}
+	s.mu.RLock()
+	sess, ok := s.sessions[id]
+	s.mu.RUnlock()
+	if ok {
+		sess.Touch(time.Now())
+	}
+	return sess, ok
+}
+
 func (s *SessionStore) CleanupExpired() {
 	now := time.Now()
 	var expired []*Session
@@ -184,6 +295,16 @@ var timeLayouts = []string{
 	time.RFC3339Nano,
 }
 
+// Layout names reported for numeric time columns written by pipelines that
+// rewrite the PDH timestamp as Unix epoch.
+const (
+	epochSecondsLayout = "epoch_s"
+	epochMillisLayout  = "epoch_ms"
+)
+
+// parseTimeValue parses a sample timestamp: one of timeLayouts, or Unix
+// epoch seconds/milliseconds (told apart by magnitude, so any capture taken
+// after 1973 resolves unambiguously).
 func parseTimeValue(s string) (time.Time, string, error) {
 	s = strings.TrimSpace(s)
 	for _, layout := range timeLayouts {
@@ -191,32 +312,44 @@ func parseTimeValue(s string) (time.Time, string, error) {
 			return t, layout, nil
 		}
 	}
+	if v, err := strconv.ParseFloat(s, 64); err == nil && NumberFinite(v) {
+		switch {
+		case v >= 1e11 && v < 1e14:
+			return time.UnixMilli(int64(v)).UTC(), epochMillisLayout, nil
+		case v >= 1e8 && v < 1e11:
+			return time.UnixMilli(int64(v * 1000)).UTC(), epochSecondsLayout, nil
+		}
+	}
 	return time.Time{}, "", fmt.Errorf("unrecognized time format: %q", s)
 }
 
-func readCSVLine(line []byte) ([]string, error) {
-	line = bytes.TrimRight(line, "\r\n")
-	r := csv.NewReader(bytes.NewReader(line))
-	r.FieldsPerRecord = -1
-	r.LazyQuotes = true
-	record, err := r.Read()
-	if err != nil {
-		return nil, err
-	}
-	return record, nil
+func buildIndex(path string) (*DataFile, error) {
+	return buildIndexFollow(path, false)
 }
 
-func buildIndex(path string) (*DataFile, error) {
+// buildIndexFollow is buildIndex for a capture that may still be written
+// to; with follow set, see DataFile.Follow.
+func buildIndexFollow(path string, follow bool) (*DataFile, error) {
+	if isGzipFile(path) {
+		if follow {
+			return nil, fmt.Errorf("cannot follow a gzip-compressed capture")
+		}
+		return buildGzipIndex(path)
+	}
+	began := time.Now()
 	f, err := os.Open(path)
 	if err != nil {
 		return nil, err
 	}
 	defer f.Close()
 
-	reader := bufio.NewReaderSize(f, 4*1024*1024)
+	// Indexing reads every byte anyway, so the content hash comes for free.
+	hash := sha256.New()
+	lines := getLineReader(io.TeeReader(f, hash))
+	defer lines.release()
 	var offset int64
 
-	line, err := reader.ReadBytes('\n')
+	line, err := lines.next()
 	if err != nil {
 		if !errors.Is(err, io.EOF) {
 			return nil, err
@@ -226,7 +359,15 @@ func buildIndex(path string) (*DataFile, error) {
 		}
 	}
 	offset += int64(len(line))
-	header, err := readCSVLine(line)
+	var lenient []LenientLine
+	header, err := parseCSVRecord(line, false)
+	if err != nil {
+		if strictCSV {
+			return nil, fmt.Errorf("failed to parse header (strict CSV mode): %w", err)
+		}
+		lenient = append(lenient, newLenientLine(0, 0, err, line))
+		header, err = parseCSVRecord(line, true)
+	}
 	if err != nil {
 		return nil, fmt.Errorf("failed to parse header: %w", err)
 	}
@@ -234,6 +375,7 @@ func buildIndex(path string) (*DataFile, error) {
 		return nil, fmt.Errorf("empty header")
 	}
 	header[0] = "Time"
+	remote := normalizeRemoteHeader(header)
 
 	df := &DataFile{
 		Path:            path,
@@ -241,20 +383,61 @@ func buildIndex(path string) (*DataFile, error) {
 		Columns:         header,
 		DataStartOffset: offset,
 		Index:           make([]IndexEntry, 0, 1024),
+		LenientLines:    int64(len(lenient)),
+		LenientSamples:  lenient,
+		Follow:          follow,
+		Remote:          remote,
+	}
+	if st, err := f.Stat(); err == nil {
+		df.Size = st.Size()
+		df.ModTime = st.ModTime()
 	}
+	if err := df.indexRows(lines, offset, 0); err != nil {
+		return nil, err
+	}
+	df.tailSum, _ = tailChecksum(f, df.DataEndOffset)
+	df.Provenance = Provenance{
+		Source:          "path",
+		Origin:          path,
+		ContentHash:     hex.EncodeToString(hash.Sum(nil)),
+		Index:           "built",
+		IndexDurationMs: time.Since(began).Milliseconds(),
+		IndexedAt:       time.Now().UnixMilli(),
+		Stride:          indexStride,
+	}
+	return df, nil
+}
 
-	var row int64
+// indexRows scans data rows from lines, which must be positioned at offset
+// just after row, and extends the index, counters and time range in place.
+func (df *DataFile) indexRows(lines *lineReader, offset, row int64) error {
+	header := df.Columns
 	for {
-		line, err = reader.ReadBytes('\n')
+		line, err := lines.next()
 		if err != nil && !errors.Is(err, io.EOF) {
-			return nil, err
+			return err
 		}
 		if len(line) == 0 && errors.Is(err, io.EOF) {
 			break
 		}
 
-		record, perr := readCSVLine(line)
+		if errors.Is(err, io.EOF) && !bytes.HasSuffix(line, []byte("\n")) {
+			if df.Follow {
+				// The writer is mid-row; the next pass indexes it once the
+				// newline is there.
+				break
+			}
+			if record, perr := lines.record(line); perr != nil || len(record) < len(header) {
+				df.Truncated = true
+				break
+			}
+		}
+
+		record, perr := df.parseIndexedLine(&lines.fields, line, offset, row+1)
 		if perr != nil || len(record) == 0 {
+			if perr != nil {
+				df.MalformedLines++
+			}
 			offset += int64(len(line))
 			if errors.Is(err, io.EOF) {
 				break
@@ -263,7 +446,16 @@ func buildIndex(path string) (*DataFile, error) {
 		}
 
 		row++
+		if len(record) < len(header) {
+			df.ShortRows++
+			if len(df.ShortRowSamples) < maxLenientSamples {
+				df.ShortRowSamples = append(df.ShortRowSamples, ShortRow{Offset: offset, Row: row, Fields: len(record), Expected: len(header)})
+			}
+		}
 		timestamp, layout, terr := parseTimeValue(record[0])
+		if terr != nil {
+			df.BadTimestamps++
+		}
 		if terr == nil {
 			if df.TimeLayout == "" {
 				df.TimeLayout = layout
@@ -287,10 +479,11 @@ func buildIndex(path string) (*DataFile, error) {
 	}
 
 	df.Rows = row
+	df.DataEndOffset = offset
 	if df.TimeLayout == "" {
 		df.TimeLayout = timeLayouts[0]
 	}
-	return df, nil
+	return nil
 }
 
 func (df *DataFile) findOffset(t time.Time) (int64, int64) {
@@ -307,6 +500,42 @@ func (df *DataFile) findOffset(t time.Time) (int64, int64) {
 	return entry.Offset, entry.Row
 }
 
+// openData opens the capture positioned at offset, limited to the rows that
+// were indexed so a truncated trailing row is never read back.
+func (df *DataFile) openData(offset int64) (io.Closer, io.Reader, error) {
+	if len(df.Parts) > 0 {
+		return df.openStitched(offset)
+	}
+	f, err := os.Open(df.Path)
+	if err != nil {
+		return nil, nil, err
+	}
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		_ = f.Close()
+		return nil, nil, err
+	}
+	if df.DataEndOffset <= 0 {
+		return f, f, nil
+	}
+	remaining := df.DataEndOffset - offset
+	if remaining < 0 {
+		remaining = 0
+	}
+	return f, io.LimitReader(f, remaining), nil
+}
+
+// SampleInterval estimates the capture's sampling period from the index.
+func (df *DataFile) SampleInterval() time.Duration {
+	if df.Rows < 2 || df.StartTime.IsZero() || df.EndTime.IsZero() {
+		return 0
+	}
+	span := df.EndTime.Sub(df.StartTime)
+	if span <= 0 {
+		return 0
+	}
+	return span / time.Duration(df.Rows-1)
+}
+
 func (df *DataFile) estimateRows(start, end time.Time) int64 {
 	if len(df.Index) < 2 {
 		return df.Rows
@@ -382,15 +611,37 @@ type SeriesResponse struct {
 	Start  int64           `json:"start"`
 	End    int64           `json:"end"`
 	Rows   int64           `json:"rows"`
-	Error  string          `json:"error,omitempty"`
+	// Step is how many rows each returned point stands for; MaxPoints the
+	// budget it was derived from (zero for every row).
+	Step      int64 `json:"step"`
+	MaxPoints int   `json:"maxPoints,omitempty"`
+	// Bucket is the wall-clock bucket width in ms when align was asked for
+	// and the series was downsampled; Times are then bucket starts.
+	Bucket int64 `json:"bucket,omitempty"`
+	// Agg is "envelope" when points are bucket means with Min/Max.
+	Agg   string      `json:"agg,omitempty"`
+	Trace *QueryTrace `json:"trace,omitempty"`
+	Error string      `json:"error,omitempty"`
 }
 
 type SeriesPayload struct {
 	Name   string    `json:"name"`
 	Values []float64 `json:"values"`
+	// Min and Max are set with agg=envelope when points were downsampled:
+	// the extremes of the rows behind each point, for drawing bands.
+	Min []float64 `json:"min,omitempty"`
+	Max []float64 `json:"max,omitempty"`
+	// Quality is set when the series was downsampled.
+	Quality *SeriesQuality `json:"quality,omitempty"`
 }
 
-func (df *DataFile) extractSeries(cols []int, start, end time.Time, maxPoints int) (SeriesResponse, error) {
+// extractSeries reads cols between start and end, keeping every step-th row
+// to fit maxPoints. With align, downsampled points are instead the first
+// row of each wall-clock bucket (see alignedBucket), so the same rows are
+// picked whatever start is. With envelopeAgg, downsampled points summarize
+// every row they stand for instead of sampling one. exprs add one derived
+// series each, after those of cols, evaluated on every row they cover.
+func (df *DataFile) extractSeries(cols []int, exprs []*seriesExpr, start, end time.Time, maxPoints int, align time.Duration, envelopeAgg bool, trace *QueryTrace) (SeriesResponse, error) {
 	resp := SeriesResponse{
 		Series: make([]SeriesPayload, 0, len(cols)),
 	}
@@ -405,32 +656,97 @@ func (df *DataFile) extractSeries(cols []int, start, end time.Time, maxPoints in
 		seriesMap[i] = []int{len(resp.Series) - 1}
 		validCounts = append(validCounts, 0)
 	}
+	exprBase := len(resp.Series)
+	for _, e := range exprs {
+		resp.Series = append(resp.Series, SeriesPayload{Name: e.Text})
+		validCounts = append(validCounts, 0)
+	}
 
 	estimated := df.estimateRows(start, end)
 	step := int64(1)
 	if maxPoints > 0 && estimated > int64(maxPoints) {
-		step = estimated / int64(maxPoints)
+		// Round up so the budget is a ceiling, not a rough target.
+		step = (estimated + int64(maxPoints) - 1) / int64(maxPoints)
 		if step < 1 {
 			step = 1
 		}
 	}
+	resp.Step, resp.MaxPoints = step, max(maxPoints, 0)
+	var bucket int64
+	if align > 0 && step > 1 {
+		bucket = alignedBucket(time.Duration(step)*df.SampleInterval(), align).Milliseconds()
+		resp.Bucket = bucket
+	}
+	lastBucket := int64(math.MinInt64)
+
+	// A downsampled series folds every row of a point's bucket into the
+	// bucket's Min and Max and into full-resolution statistics, so its
+	// Quality can say what the points lose. With envelope Values is the
+	// bucket mean and Min/Max are returned; otherwise Values is the kept
+	// row. A bucket without a parsable value holds the previous point.
+	downsampled := step > 1
+	envelope := envelopeAgg && downsampled
+	if envelope {
+		resp.Agg = "envelope"
+	}
+	sums, counts := make([]float64, len(resp.Series)), make([]int, len(resp.Series))
+	rowStats := make([]seriesRowStats, len(resp.Series))
+	observe := func(t, pos int, v float64, first bool) {
+		validCounts[t]++
+		if !downsampled || (first && !envelope) {
+			resp.Series[t].Values[pos] = v
+		}
+		if !downsampled {
+			return
+		}
+		s := &resp.Series[t]
+		if counts[t] == 0 || v < s.Min[pos] {
+			s.Min[pos] = v
+		}
+		if counts[t] == 0 || v > s.Max[pos] {
+			s.Max[pos] = v
+		}
+		sums[t] += v
+		counts[t]++
+		rowStats[t].add(v)
+	}
+	closeBucket := func(pos int) {
+		for t := range resp.Series {
+			s := &resp.Series[t]
+			switch {
+			case counts[t] > 0:
+				if envelope {
+					s.Values[pos] = sums[t] / float64(counts[t])
+				}
+			case pos > 0:
+				if envelope {
+					s.Values[pos] = s.Values[pos-1]
+				}
+				s.Min[pos], s.Max[pos] = s.Min[pos-1], s.Max[pos-1]
+			}
+			sums[t], counts[t] = 0, 0
+		}
+	}
 
-	f, err := os.Open(df.Path)
+	trace.skip()
+	startOffset, startRow := df.findOffset(start)
+	f, data, err := df.openData(startOffset)
 	if err != nil {
 		return resp, err
 	}
 	defer f.Close()
-
-	startOffset, startRow := df.findOffset(start)
-	if _, err := f.Seek(startOffset, io.SeekStart); err != nil {
-		return resp, err
+	if trace != nil {
+		trace.StartOffset = startOffset
+		trace.lap(traceSeek)
 	}
 
-	reader := bufio.NewReaderSize(f, 4*1024*1024)
+	lines := getLineReader(data)
+	defer lines.release()
 	row := startRow
 	var kept int64
 	for {
-		line, err := reader.ReadBytes('\n')
+		line, err := lines.next()
+		trace.lap(traceRead)
 		if err != nil && !errors.Is(err, io.EOF) {
 			return resp, err
 		}
@@ -438,8 +754,10 @@ func (df *DataFile) extractSeries(cols []int, start, end time.Time, maxPoints in
 			break
 		}
 
-		record, perr := readCSVLine(line)
+		record, perr := lines.record(line)
 		if perr != nil || len(record) == 0 {
+			trace.lap(traceParse)
+			trace.line(len(line), true)
 			if errors.Is(err, io.EOF) {
 				break
 			}
@@ -447,7 +765,9 @@ func (df *DataFile) extractSeries(cols []int, start, end time.Time, maxPoints in
 		}
 
 		timestamp, _, terr := parseTimeValue(record[0])
+		trace.lap(traceParse)
 		if terr != nil {
+			trace.line(len(line), true)
 			row++
 			if errors.Is(err, io.EOF) {
 				break
@@ -456,6 +776,7 @@ func (df *DataFile) extractSeries(cols []int, start, end time.Time, maxPoints in
 		}
 
 		if !start.IsZero() && timestamp.Before(start) {
+			trace.line(len(line), true)
 			row++
 			if errors.Is(err, io.EOF) {
 				break
@@ -463,16 +784,36 @@ func (df *DataFile) extractSeries(cols []int, start, end time.Time, maxPoints in
 			continue
 		}
 		if !end.IsZero() && timestamp.After(end) {
+			trace.line(len(line), true)
 			break
 		}
+		trace.line(len(line), false)
 
-		if (row-startRow)%step == 0 {
-			resp.Times = append(resp.Times, timestamp.UnixMilli())
-			currentPos := len(resp.Times) - 1
+		keep, pointTime := (row-startRow)%step == 0, timestamp.UnixMilli()
+		if bucket > 0 {
+			b := pointTime / bucket
+			if pointTime < 0 && pointTime%bucket != 0 {
+				b--
+			}
+			keep, pointTime = b != lastBucket, b*bucket
+			lastBucket = b
+		}
+		if keep {
+			if downsampled && len(resp.Times) > 0 {
+				closeBucket(len(resp.Times) - 1)
+			}
+			resp.Times = append(resp.Times, pointTime)
 			for si := range resp.Series {
 				resp.Series[si].Values = append(resp.Series[si].Values, 0)
+				if downsampled {
+					resp.Series[si].Min = append(resp.Series[si].Min, 0)
+					resp.Series[si].Max = append(resp.Series[si].Max, 0)
+				}
 			}
-
+			kept++
+		}
+		if keep || (downsampled && len(resp.Times) > 0) {
+			currentPos := len(resp.Times) - 1
 			for i, idx := range cols {
 				targets := seriesMap[i]
 				if idx >= 0 && idx < len(record) {
@@ -495,28 +836,48 @@ func (df *DataFile) extractSeries(cols []int, start, end time.Time, maxPoints in
 								name = fmt.Sprintf("col_%d [home %d]", idx, nextHome)
 							}
 							sp := SeriesPayload{Name: name, Values: make([]float64, currentPos+1)}
-							for x := 0; x <= currentPos; x++ {
-								sp.Values[x] = 0
+							if downsampled {
+								sp.Min, sp.Max = make([]float64, currentPos+1), make([]float64, currentPos+1)
 							}
+							sums, counts = append(sums, 0), append(counts, 0)
+							rowStats = append(rowStats, seriesRowStats{})
 							resp.Series = append(resp.Series, sp)
 							targets = append(targets, len(resp.Series)-1)
 							validCounts = append(validCounts, 0)
 						}
 						seriesMap[i] = targets
 						for vi, val := range values {
-							resp.Series[targets[vi]].Values[currentPos] = val
-							validCounts[targets[vi]]++
+							observe(targets[vi], currentPos, val, keep)
 						}
 						continue
 					}
 					if v, ok := parseFloatValue(raw); ok {
-						resp.Series[targets[0]].Values[currentPos] = v
-						validCounts[targets[0]]++
+						observe(targets[0], currentPos, v, keep)
+					}
+				} else if keep && !envelope && idx > 0 && currentPos > 0 {
+					// Short row: the sample was cut before this field, so hold
+					// the previous value instead of charting a drop to zero.
+					for _, t := range targets {
+						resp.Series[t].Values[currentPos] = resp.Series[t].Values[currentPos-1]
+					}
+				}
+			}
+			if len(exprs) > 0 {
+				value := func(idx int) (float64, bool) {
+					if idx >= len(record) {
+						return 0, false
+					}
+					v, ok := parseFloatValue(record[idx])
+					return v, ok && NumberFinite(v)
+				}
+				for i, e := range exprs {
+					if v, ok := e.root.eval(value); ok && NumberFinite(v) {
+						observe(exprBase+i, currentPos, v, keep)
 					}
 				}
 			}
-			kept++
 		}
+		trace.lap(traceAggregate)
 
 		row++
 		if errors.Is(err, io.EOF) {
@@ -524,6 +885,18 @@ func (df *DataFile) extractSeries(cols []int, start, end time.Time, maxPoints in
 		}
 	}
 
+	if downsampled && len(resp.Times) > 0 {
+		closeBucket(len(resp.Times) - 1)
+	}
+	if downsampled {
+		for t := range resp.Series {
+			s := &resp.Series[t]
+			s.Quality = rowStats[t].quality(s, envelope)
+			if !envelope {
+				s.Min, s.Max = nil, nil
+			}
+		}
+	}
 	if len(resp.Times) > 0 {
 		resp.Start = resp.Times[0]
 		resp.End = resp.Times[len(resp.Times)-1]
@@ -536,9 +909,31 @@ func (df *DataFile) extractSeries(cols []int, start, end time.Time, maxPoints in
 	}
 	resp.Series = filtered
 	resp.Rows = kept
+	resp.Trace = trace.finish()
 	return resp, nil
 }
 
+func unixMilliOrZero(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+	return t.UnixMilli()
+}
+
+// parseTimeQuery reads a time query parameter given as Unix milliseconds or
+// in the CSV timestamp format; missing or invalid values yield zero.
+func parseTimeQuery(r *http.Request, key string) time.Time {
+	val := strings.TrimSpace(r.URL.Query().Get(key))
+	if val == "" {
+		return time.Time{}
+	}
+	if ms, err := strconv.ParseInt(val, 10, 64); err == nil {
+		return time.UnixMilli(ms).UTC()
+	}
+	t, _, _ := parseTimeValue(val)
+	return t
+}
+
 func writeJSON(w http.ResponseWriter, status int, payload any) {
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(status)
@@ -548,21 +943,59 @@ func writeJSON(w http.ResponseWriter, status int, payload any) {
 }
 
 func indexUploadedOrFetchedCSV(reader io.Reader, label, prefix string) (*DataFile, error) {
+	tmpPath, err := persistTempCSV(reader, prefix)
+	if err != nil {
+		return nil, err
+	}
+	return indexTempCSV(tmpPath, label)
+}
+
+// limitUpload caps the request body at limit bytes (-upload-max-bytes);
+// 0 leaves it unlimited.
+func limitUpload(w http.ResponseWriter, r *http.Request, limit int64) {
+	if limit > 0 {
+		r.Body = http.MaxBytesReader(w, r.Body, limit)
+	}
+}
+
+func isUploadTooLarge(err error) bool {
+	var tooLarge *http.MaxBytesError
+	return errors.As(err, &tooLarge)
+}
+
+// persistTempCSV copies reader into a new temp file and returns its path.
+func persistTempCSV(reader io.Reader, prefix string) (string, error) {
 	tmp, err := os.CreateTemp("", prefix)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create temp file: %w", err)
+		return "", fmt.Errorf("failed to create temp file: %w", err)
 	}
 	tmpPath := tmp.Name()
-	if _, err := io.Copy(tmp, reader); err != nil {
+	// Captures are often shipped as .csv.gz; store them decompressed so
+	// the index can seek.
+	src, err := maybeGunzip(reader)
+	if err != nil {
+		_ = tmp.Close()
+		_ = os.Remove(tmpPath)
+		return "", err
+	}
+	if _, err := io.Copy(tmp, capCapture(src)); err != nil {
 		_ = tmp.Close()
 		_ = os.Remove(tmpPath)
-		return nil, fmt.Errorf("failed to write temp file: %w", err)
+		if errors.Is(err, errCaptureTooLarge) {
+			return "", fmt.Errorf("%w (%d bytes)", err, maxCaptureBytes)
+		}
+		return "", fmt.Errorf("failed to write temp file: %w", err)
 	}
 	if err := tmp.Close(); err != nil {
 		_ = os.Remove(tmpPath)
-		return nil, fmt.Errorf("failed to finalize temp file: %w", err)
+		return "", fmt.Errorf("failed to finalize temp file: %w", err)
 	}
+	return tmpPath, nil
+}
 
+// indexTempCSV indexes a temp file written by persistTempCSV. The file is
+// owned by the returned DataFile, or removed when indexing fails.
+func indexTempCSV(tmpPath, label string) (*DataFile, error) {
 	newDF, err := buildIndex(tmpPath)
 	if err != nil {
 		_ = os.Remove(tmpPath)
@@ -590,7 +1023,7 @@ func guessDefaultCSV() (string, bool) {
 			continue
 		}
 		name := strings.ToLower(e.Name())
-		if !strings.HasSuffix(name, ".csv") {
+		if !strings.HasSuffix(name, ".csv") && !strings.HasSuffix(name, ".csv.gz") {
 			continue
 		}
 		info, err := e.Info()
@@ -613,11 +1046,163 @@ func guessDefaultCSV() (string, bool) {
 }
 
 func main() {
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "summarize":
+			os.Exit(runSummarize(os.Args[2:]))
+		case "index":
+			os.Exit(runIndex(os.Args[2:]))
+		case "capacity":
+			os.Exit(runCapacity(os.Args[2:]))
+		case "analyze":
+			os.Exit(runAnalyze(os.Args[2:]))
+		case "pseudonyms":
+			os.Exit(runPseudonyms(os.Args[2:]))
+		case "influx":
+			os.Exit(runInflux(os.Args[2:]))
+		case "parquet":
+			os.Exit(runParquet(os.Args[2:]))
+		case "attach":
+			os.Exit(runAttach(os.Args[2:]))
+		case "bundle":
+			os.Exit(runBundle(os.Args[2:]))
+		case "gen-fixture":
+			os.Exit(runGenFixture(os.Args[2:]))
+		}
+	}
+
 	var filePath string
 	var port int
+	var stitch bool
+	var follow bool
+	var followInterval time.Duration
 	flag.StringVar(&filePath, "file", "", "Path to ESX CSV file")
+	flag.BoolVar(&stitch, "stitch", false, "Join -file with its rotated siblings (capture-01.csv, capture-02.csv, ...) into one capture")
+	flag.BoolVar(&follow, "follow", false, "Keep indexing -file as it grows (a capture still being written); the UI can follow the tail")
+	flag.DurationVar(&followInterval, "follow-interval", 2*time.Second, "How often followed captures are checked for new rows")
+	var serviceMode bool
+	var pidFile string
+	var serveGRPC bool
+	var aliasFile string
+	var maxScans, scanQueue int
+	var readOnly bool
+	var csvMode string
+	var pluginDir string
+	var reportDir string
+	var messageDir, lang string
+	var defaultsFile, userHeader, groupHeader string
+	var urlAllow, urlDeny string
+	var urlAllowPrivate bool
+	var urlMaxBytes, uploadMaxBytes int64
+	var indexWorkers, indexQueue int
+	var fleetDir string
+	var fleetWorkers int
+	var fleetRescan time.Duration
+	var watchDir string
+	var watchWorkers int
+	var watchInterval time.Duration
+	var cacheMB int
+	var liveMode bool
+	var liveCmd, liveDir string
+	var liveInterval time.Duration
+	var sharedMode bool
+	var srvOpts serverOptions
 	flag.IntVar(&port, "port", 8080, "Port to serve on")
+	flag.BoolVar(&serviceMode, "service", false, "Run as a long-lived service (systemd socket activation, SIGHUP reload)")
+	flag.StringVar(&pidFile, "pid-file", "", "Write the process ID to this file (service mode)")
+	flag.BoolVar(&serveGRPC, "grpc", false, "Also serve the gRPC API (esxdoctor.proto) on the same port; implies -h2c")
+	flag.StringVar(&aliasFile, "aliases", "", "JSON file of extra counter aliases ({\"Canonical: Label\": [\"Alias: Label\"]})")
+	flag.IntVar(&maxScans, "max-scans", 4, "Maximum concurrent scan-heavy requests (diagnostics, series)")
+	flag.IntVar(&cacheMB, "cache-mb", 64, "Memory for cached meta, catalog and series responses in MiB (0 disables)")
+	flag.IntVar(&scanQueue, "scan-queue", 16, "Scan-heavy requests allowed to wait for a slot before returning 503")
+	flag.BoolVar(&readOnly, "read-only", false, "Disable opening/uploading files and changing templates or bookmarks (safe sharing)")
+	flag.StringVar(&csvMode, "csv-mode", "lenient", "CSV quoting: lenient (tolerate stray quotes, report affected lines) or strict (reject them)")
+	flag.BoolVar(&rebuildIndexes, "rebuild-index", false, "Ignore x.idx.json sidecar indexes and rewrite them from a fresh scan (after an upgrade or a suspect index)")
+	flag.StringVar(&pluginDir, "plugins", defaultPluginDir(), "Directory of detector plugins (*.so built with -buildmode=plugin)")
+	flag.StringVar(&reportDir, "reports", defaultReportTemplateDir(), "Directory of extra report templates (*.json)")
+	flag.StringVar(&messageDir, "messages", defaultMessageDir(), "Directory of finding message packs (<lang>.json); en.json overrides the built-in terminology")
+	flag.StringVar(&lang, "lang", "en", "Default language for finding summaries")
+	flag.StringVar(&defaultsFile, "defaults-file", "", "JSON file pinning a default CSV per user/group ({\"users\": {...}, \"groups\": {...}})")
+	flag.StringVar(&userHeader, "user-header", "", "Request header carrying the user name set by an authenticating proxy (e.g. X-Remote-User)")
+	flag.StringVar(&groupHeader, "group-header", "", "Request header carrying comma-separated groups set by an authenticating proxy")
+	flag.BoolVar(&sharedMode, "shared", false, "Team mode: record who loaded each file (owner in /api/meta, feed at /api/loads) and honor soft locks on sessions")
+	flag.StringVar(&urlAllow, "url-allow", "", "Comma-separated hosts /api/open-url may fetch (*.example.com for subdomains); empty allows any public host")
+	flag.StringVar(&urlDeny, "url-deny", "", "Comma-separated extra IPs/CIDRs /api/open-url must never fetch")
+	flag.BoolVar(&urlAllowPrivate, "url-allow-private", false, "Let /api/open-url fetch loopback, private and link-local addresses")
+	flag.Int64Var(&urlMaxBytes, "url-max-bytes", 4<<30, "Largest CSV /api/open-url will download, in bytes (0 = unlimited)")
+	flag.Int64Var(&uploadMaxBytes, "upload-max-bytes", 4<<30, "Largest request body /api/upload and /api/import/influx accept, in bytes (0 = unlimited)")
+	flag.Int64Var(&maxCaptureBytes, "max-capture-bytes", maxCaptureBytes, "Largest capture written to disk from an upload, URL, bundle or .gz, counted after decompression (0 = unlimited)")
+	flag.IntVar(&indexWorkers, "index-workers", 2, "Uploads indexed concurrently in the background")
+	flag.IntVar(&indexQueue, "index-queue", 8, "Uploads allowed to wait for an indexing worker before returning 503")
+	flag.StringVar(&fleetDir, "fleet", "", "Directory of captures from many hosts to index and diagnose in the background (fleet dashboard at /fleet)")
+	flag.IntVar(&fleetWorkers, "fleet-workers", 2, "Fleet captures indexed and diagnosed concurrently")
+	flag.DurationVar(&fleetRescan, "fleet-rescan", 5*time.Minute, "How often to look for new or changed captures in -fleet (0 disables)")
+	flag.StringVar(&watchDir, "watch-dir", "", "Drop folder: analyze each CSV copied into this directory and write <name>.findings.json next to it (queue at /api/watch)")
+	flag.IntVar(&watchWorkers, "watch-workers", 1, "Captures from -watch-dir indexed and diagnosed concurrently")
+	flag.DurationVar(&watchInterval, "watch-interval", 10*time.Second, "How often -watch-dir is polled; a file is analyzed once it is unchanged between two polls")
+	flag.BoolVar(&liveMode, "live", false, "Run esxtop in batch mode and serve its output as it is captured (UI follows the tail)")
+	flag.StringVar(&liveCmd, "live-cmd", "", "Command -live runs instead of esxtop, e.g. \"resxtop --server esx01 --username root -b -d 5\" (implies -live)")
+	flag.DurationVar(&liveInterval, "live-interval", 5*time.Second, "Sampling interval for the esxtop -live runs (-d)")
+	flag.StringVar(&liveDir, "live-dir", defaultLiveDir(), "Directory -live writes its captures to")
+	flag.DurationVar(&srvOpts.ReadHeaderTimeout, "read-header-timeout", 10*time.Second, "Time a client gets to send request headers (guards against slow-header stalls)")
+	flag.DurationVar(&srvOpts.ReadTimeout, "read-timeout", 0, "Time limit for reading a whole request including the body (0 = none; large uploads need minutes)")
+	flag.DurationVar(&srvOpts.WriteTimeout, "write-timeout", 0, "Time limit for writing a whole response (0 = none; long exports and diagnostics runs need minutes)")
+	flag.DurationVar(&srvOpts.IdleTimeout, "idle-timeout", 2*time.Minute, "How long an idle keep-alive connection stays open")
+	flag.IntVar(&srvOpts.MaxHeaderBytes, "max-header-bytes", 256<<10, "Largest request header block accepted, in bytes")
+	flag.IntVar(&srvOpts.MaxConns, "max-conns", 0, "Maximum open client connections; more clients wait to be accepted (0 = unlimited)")
+	flag.BoolVar(&srvOpts.H2C, "h2c", false, "Also serve HTTP/2 without TLS (h2c), e.g. behind a proxy that speaks h2c to backends")
 	flag.Parse()
+	if serveGRPC {
+		srvOpts.H2C = true
+	}
+
+	switch strings.ToLower(strings.TrimSpace(csvMode)) {
+	case "lenient", "":
+	case "strict":
+		strictCSV = true
+	default:
+		log.Fatalf("invalid -csv-mode %q (use lenient or strict)", csvMode)
+	}
+
+	if strings.TrimSpace(aliasFile) != "" {
+		if err := loadAttributeAliases(aliasFile); err != nil {
+			log.Fatalf("failed to load aliases: %v", err)
+		}
+	}
+
+	loadDetectorPlugins(pluginDir)
+
+	catalog, err := loadMessageCatalog(webFS, messageDir, lang)
+	if err != nil {
+		log.Fatalf("failed to load message packs: %v", err)
+	}
+	messages = catalog
+
+	events := newEventLog()
+	var live *liveCapture
+	if liveMode || strings.TrimSpace(liveCmd) != "" {
+		if strings.TrimSpace(filePath) != "" || stitch {
+			log.Fatal("-live writes its own capture; drop -file and -stitch")
+		}
+		args, err := liveCommand(liveCmd, liveInterval)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if strings.TrimSpace(liveCmd) != "" {
+			liveInterval = 0 // whatever the command samples at
+		}
+		live, err = startLiveCapture(args, liveInterval, liveDir, events)
+		if err != nil {
+			log.Fatalf("live capture failed: %v", err)
+		}
+		filePath = live.path
+		follow = true
+		log.Printf("live capture: %s -> %s", strings.Join(args, " "), live.path)
+	}
+	if follow && stitch {
+		log.Fatal("-follow cannot be combined with -stitch; follow the capture being written")
+	}
+	follows := newFollowStore(followInterval, events)
 
 	var df *DataFile
 	if strings.TrimSpace(filePath) != "" {
@@ -628,14 +1213,28 @@ func main() {
 		if _, err := os.Stat(absPath); err != nil {
 			log.Fatalf("file not found: %s", absPath)
 		}
-		df, err = buildIndex(absPath)
+		if stitch {
+			var skipped []string
+			df, skipped, err = stitchCapture(absPath)
+			for _, s := range skipped {
+				log.Printf("stitch: skipped %s", s)
+			}
+		} else if follow {
+			// A sidecar would describe an older, shorter capture.
+			df, err = buildIndexFollow(absPath, true)
+		} else {
+			df, err = loadOrBuildIndex(absPath)
+		}
 		if err != nil {
 			log.Fatalf("index build failed: %v", err)
 		}
+		if follow {
+			df = follows.follow(df)
+		}
 		log.Printf("loaded startup file: %s", df.Label)
 	} else if guessed, ok := guessDefaultCSV(); ok {
 		var err error
-		df, err = buildIndex(guessed)
+		df, err = loadOrBuildIndex(guessed)
 		if err != nil {
 			log.Printf("default CSV found but indexing failed (%s): %v", guessed, err)
 		} else {
@@ -645,6 +1244,16 @@ func main() {
 		log.Printf("no startup CSV found; open one from UI file picker")
 	}
 	sessions := NewSessionStore(df, 24*time.Hour)
+	if strings.TrimSpace(defaultsFile) != "" {
+		if userHeader == "" && groupHeader == "" {
+			log.Fatal("-defaults-file needs -user-header and/or -group-header")
+		}
+		defaults, err := newDefaultFileAssignments(defaultsFile, userHeader, groupHeader)
+		if err != nil {
+			log.Fatalf("failed to load default file assignments: %v", err)
+		}
+		sessions.defaults = defaults
+	}
 	go func() {
 		ticker := time.NewTicker(30 * time.Minute)
 		defer ticker.Stop()
@@ -661,137 +1270,1574 @@ func main() {
 		log.Fatalf("failed to initialize diagnostics template store: %v", err)
 	}
 
+	bookmarks, err := newBookmarkStore("")
+	if err != nil {
+		log.Fatalf("failed to initialize bookmark store: %v", err)
+	}
+
+	pseudonyms, err := newPseudonymStore("")
+	if err != nil {
+		log.Fatalf("failed to load pseudonym store: %v", err)
+	}
+	queries, err := newQueryStore("")
+	if err != nil {
+		log.Fatalf("failed to initialize query store: %v", err)
+	}
+	links, err := newLinkStore("")
+	if err != nil {
+		log.Fatalf("failed to initialize link store: %v", err)
+	}
+	templatePrefs, err := newTemplatePrefStore("", userHeader)
+	if err != nil {
+		log.Fatalf("failed to load template preferences: %v", err)
+	}
+
+	runs := &runStore{}
+	history, err := newHistoryStore("")
+	if err != nil {
+		log.Fatalf("failed to load run history: %v", err)
+	}
+
+	urlPolicy, err := newURLFetchPolicy(urlAllow, urlDeny, urlAllowPrivate, urlMaxBytes)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	scans := newScanLimiter(maxScans, scanQueue, 30*time.Second)
+	var cache *responseCache
+	if cacheMB > 0 {
+		cache = newResponseCache(int64(cacheMB) << 20)
+	}
+	var shared *sharedFiles
+	if sharedMode {
+		if userHeader == "" {
+			log.Printf("shared mode without -user-header: owners are told apart by client address only")
+		}
+		shared = newSharedFiles(userHeader, events)
+	}
+	indexing := newIndexJobs(indexWorkers, indexQueue, events, shared)
+
+	var fleet *fleetStore
+	if strings.TrimSpace(fleetDir) != "" {
+		fleet, err = newFleetStore(fleetDir, templateStore, history, events, fleetWorkers)
+		if err != nil {
+			log.Fatalf("failed to open fleet directory: %v", err)
+		}
+		if err := fleet.scan(); err != nil {
+			log.Fatalf("failed to scan fleet directory: %v", err)
+		}
+		go fleet.watch(fleetRescan)
+		log.Printf("fleet: %s", fleet.dir)
+	}
+
+	var watch *watchStore
+	if strings.TrimSpace(watchDir) != "" {
+		watch, err = newWatchStore(watchDir, watchInterval, templateStore, history, events, watchWorkers)
+		if err != nil {
+			log.Fatalf("failed to open watch directory: %v", err)
+		}
+		if err := watch.scan(); err != nil {
+			log.Fatalf("failed to scan watch directory: %v", err)
+		}
+		go watch.run()
+		log.Printf("watching: %s", watch.dir)
+	}
+
+	// mutating guards endpoints that change the loaded file or saved state;
+	// with -read-only they answer 403 so a shared instance stays as prepared.
+	mutating := func(h http.HandlerFunc) http.HandlerFunc {
+		if !readOnly {
+			return h
+		}
+		return func(w http.ResponseWriter, r *http.Request) {
+			writeJSON(w, http.StatusForbidden, map[string]string{"error": "server is read-only"})
+		}
+	}
+
 	mux := http.NewServeMux()
 
-	mux.HandleFunc("/api/meta", func(w http.ResponseWriter, r *http.Request) {
-		current := sessions.SessionForRequest(w, r).Get()
+	mux.HandleFunc("/api/meta", cache.wrap(sessions, func(w http.ResponseWriter, r *http.Request) {
+		sess := sessions.SessionForRequest(w, r)
+		current := sess.Get()
 		if current == nil {
 			writeJSON(w, http.StatusOK, map[string]any{
-				"columns": []string{},
-				"rows":    0,
-				"start":   0,
-				"end":     0,
-				"file":    "",
-				"loaded":  false,
+				"columns":  []string{},
+				"rows":     0,
+				"start":    0,
+				"end":      0,
+				"file":     "",
+				"loaded":   false,
+				"readOnly": readOnly,
 			})
 			return
 		}
 		payload := map[string]any{
-			"columns": current.Columns,
-			"rows":    current.Rows,
-			"start":   current.StartTime.UnixMilli(),
-			"end":     current.EndTime.UnixMilli(),
-			"file":    current.Label,
-			"loaded":  true,
+			"columns":      current.Columns,
+			"columnCount":  len(current.Columns),
+			"rows":         current.Rows,
+			"start":        unixMilliOrZero(current.StartTime),
+			"end":          unixMilliOrZero(current.EndTime),
+			"file":         current.Label,
+			"loaded":       true,
+			"truncated":    current.Truncated,
+			"headerOnly":   current.Rows == 0,
+			"lenientLines": current.LenientLines,
+			"readOnly":     readOnly,
+		}
+		if parts := current.partNames(); parts != nil {
+			payload["parts"] = parts
+		}
+		if v := r.URL.Query().Get("columns"); v == "0" || v == "false" {
+			// Wide captures: the UI pages through /api/columns instead.
+			delete(payload, "columns")
+		}
+		if st, ok := follows.status(current); ok {
+			payload["follow"] = st
+		}
+		if current.Remote != nil {
+			payload["remote"] = current.Remote
+		}
+		if live.covers(current) {
+			// Rows keep arriving; a cached answer would stop the tail.
+			payload["live"] = live.status()
+			w.Header().Set("Cache-Control", "no-store")
+		}
+		if shared != nil {
+			// Ownership is per session and the cache is keyed by file.
+			payload["ownership"] = shared.ownership(sess, shared.requester(r, sess, ""))
+			w.Header().Set("Cache-Control", "no-store")
+		}
+		prov, pending := current.provenance()
+		payload["provenance"] = prov
+		if pending {
+			// Don't let the response cache pin the hash-less answer.
+			payload["contentHashPending"] = true
+			w.Header().Set("Cache-Control", "no-store")
 		}
 		writeJSON(w, http.StatusOK, payload)
+	}))
+
+	mux.HandleFunc("/api/follow", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, map[string]any{"files": follows.list()})
 	})
 
-	mux.HandleFunc("/api/diagnostics/templates", func(w http.ResponseWriter, r *http.Request) {
-		_ = sessions.SessionForRequest(w, r)
-		writeJSON(w, http.StatusOK, map[string]any{
-			"templates": templateStore.list(),
-		})
+	mux.HandleFunc("/api/live", func(w http.ResponseWriter, r *http.Request) {
+		if live == nil {
+			writeJSON(w, http.StatusNotFound, map[string]string{"error": "not started with -live"})
+			return
+		}
+		writeJSON(w, http.StatusOK, live.status())
+	})
+	mux.HandleFunc("/api/live/stop", mutating(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "use POST"})
+			return
+		}
+		if live == nil {
+			writeJSON(w, http.StatusNotFound, map[string]string{"error": "not started with -live"})
+			return
+		}
+		live.stop()
+		writeJSON(w, http.StatusOK, live.status())
+	}))
+
+	mux.HandleFunc("/api/session", func(w http.ResponseWriter, r *http.Request) {
+		id := sessions.getSessionIDFromRequest(r)
+		sess, ok := sessions.Lookup(id)
+		if !ok {
+			writeJSON(w, http.StatusNotFound, map[string]string{"error": "unknown session"})
+			return
+		}
+		file := ""
+		if df := sess.Get(); df != nil {
+			file = df.Label
+		}
+		writeJSON(w, http.StatusOK, map[string]any{"id": id, "generation": sess.Generation(), "file": file})
 	})
 
-	mux.HandleFunc("/api/diagnostics/templates/save", func(w http.ResponseWriter, r *http.Request) {
+	mux.HandleFunc("/api/session/lock", mutating(func(w http.ResponseWriter, r *http.Request) {
+		if shared == nil {
+			writeJSON(w, http.StatusNotFound, map[string]string{"error": "shared mode is off (start with -shared)"})
+			return
+		}
 		if r.Method != http.MethodPost {
 			w.Header().Set("Allow", http.MethodPost)
 			writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "use POST"})
 			return
 		}
-		_ = sessions.SessionForRequest(w, r)
 		var req struct {
-			Template DiagnosticTemplate `json:"template"`
+			Locked bool   `json:"locked"`
+			Note   string `json:"note"`
+			Force  bool   `json:"force"`
 		}
 		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid JSON body"})
 			return
 		}
-		t, err := templateStore.upsert(req.Template)
-		if err != nil {
+		if len(req.Note) > 200 {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "note is too long"})
+			return
+		}
+		sess := sessions.SessionForRequest(w, r)
+		lock, err := shared.setLock(sess, shared.requester(r, sess, ""), req.Locked, req.Note, req.Force)
+		switch {
+		case isFileLocked(err):
+			writeJSON(w, http.StatusConflict, map[string]string{"error": err.Error()})
+		case err != nil:
 			writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		default:
+			writeJSON(w, http.StatusOK, map[string]any{"locked": lock != nil, "lock": lock})
+		}
+	}))
+
+	mux.HandleFunc("/api/loads", func(w http.ResponseWriter, r *http.Request) {
+		if shared == nil {
+			writeJSON(w, http.StatusNotFound, map[string]string{"error": "shared mode is off (start with -shared)"})
 			return
 		}
-		writeJSON(w, http.StatusOK, map[string]any{"template": t, "templates": templateStore.list()})
+		limit := 100
+		if raw := strings.TrimSpace(r.URL.Query().Get("limit")); raw != "" {
+			v, err := strconv.Atoi(raw)
+			if err != nil || v <= 0 {
+				writeJSON(w, http.StatusBadRequest, map[string]string{"error": fmt.Sprintf("invalid limit %q", raw)})
+				return
+			}
+			limit = min(v, maxLoadRecords)
+		}
+		writeJSON(w, http.StatusOK, map[string]any{"loads": shared.recent(limit, strings.TrimSpace(r.URL.Query().Get("user")))})
 	})
 
-	mux.HandleFunc("/api/diagnostics/templates/delete", func(w http.ResponseWriter, r *http.Request) {
+	mux.HandleFunc("/api/session/files", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, map[string]any{"files": sessions.SessionForRequest(w, r).Files()})
+	})
+
+	mux.HandleFunc("/api/session/files/add", mutating(func(w http.ResponseWriter, r *http.Request) {
 		if r.Method != http.MethodPost {
 			w.Header().Set("Allow", http.MethodPost)
 			writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "use POST"})
 			return
 		}
-		_ = sessions.SessionForRequest(w, r)
 		var req struct {
-			ID string `json:"id"`
+			Label string `json:"label"`
+			// Path opens a capture from disk; without it the open file is
+			// kept under Label, so another can be opened next to it.
+			Path string `json:"path"`
 		}
 		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid JSON body"})
 			return
 		}
-		if err := templateStore.delete(req.ID); err != nil {
+		sess := sessions.SessionForRequest(w, r)
+		var df *DataFile
+		if path := strings.TrimSpace(req.Path); path != "" {
+			abs, err := filepath.Abs(path)
+			if err != nil {
+				writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid path"})
+				return
+			}
+			if _, err := os.Stat(abs); err != nil {
+				writeJSON(w, http.StatusBadRequest, map[string]string{"error": "file not found"})
+				return
+			}
+			df, err = loadOrBuildIndex(abs)
+			if err != nil {
+				writeJSON(w, http.StatusBadRequest, map[string]string{"error": fmt.Sprintf("index build failed: %v", err)})
+				return
+			}
+			if len(df.Parts) == 0 {
+				df.Label = abs
+			}
+		} else if df = sess.Get(); df == nil {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "no file loaded"})
+			return
+		}
+		if err := sess.AddFile(req.Label, df); err != nil {
 			writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
 			return
 		}
-		writeJSON(w, http.StatusOK, map[string]any{"templates": templateStore.list()})
-	})
+		writeJSON(w, http.StatusOK, map[string]any{"files": sess.Files()})
+	}))
 
-	mux.HandleFunc("/api/diagnostics/templates/import", func(w http.ResponseWriter, r *http.Request) {
+	mux.HandleFunc("/api/session/files/remove", mutating(func(w http.ResponseWriter, r *http.Request) {
 		if r.Method != http.MethodPost {
 			w.Header().Set("Allow", http.MethodPost)
 			writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "use POST"})
 			return
 		}
-		_ = sessions.SessionForRequest(w, r)
 		var req struct {
-			Templates []DiagnosticTemplate `json:"templates"`
-			Replace   bool                 `json:"replace"`
+			Label string `json:"label"`
 		}
 		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid JSON body"})
 			return
 		}
-		if err := templateStore.importTemplates(req.Templates, req.Replace); err != nil {
+		sess := sessions.SessionForRequest(w, r)
+		if !sess.RemoveFile(req.Label) {
+			writeJSON(w, http.StatusNotFound, map[string]string{"error": "no file labeled " + req.Label})
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]any{"files": sess.Files()})
+	}))
+
+	mux.HandleFunc("/api/cursor", func(w http.ResponseWriter, r *http.Request) {
+		sess := sessions.SessionForRequest(w, r)
+		switch r.Method {
+		case http.MethodGet:
+			since := int64(-1)
+			if v, err := strconv.ParseInt(r.URL.Query().Get("since"), 10, 64); err == nil {
+				since = v
+			}
+			// The wait stays inside maxCursorWait and leaves a second of the
+			// write timeout for the response; a bad value keeps the default.
+			limit := maxCursorWait
+			if srvOpts.WriteTimeout > 0 {
+				limit = min(limit, max(srvOpts.WriteTimeout-time.Second, 0))
+			}
+			wait := limit
+			if v, err := strconv.Atoi(r.URL.Query().Get("wait")); err == nil {
+				wait = time.Duration(min(max(v, 0), int(limit/time.Second))) * time.Second
+			}
+			if since < 0 {
+				wait = 0
+			}
+			writeJSON(w, http.StatusOK, sess.cursor.wait(r.Context(), since, wait))
+		case http.MethodPost:
+			var req CursorState
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid JSON body"})
+				return
+			}
+			if req.Start > 0 && req.End > 0 && req.End < req.Start {
+				writeJSON(w, http.StatusBadRequest, map[string]string{"error": "end is before start"})
+				return
+			}
+			writeJSON(w, http.StatusOK, sess.cursor.set(req))
+		default:
+			w.Header().Set("Allow", "GET, POST")
+			writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "use GET or POST"})
+		}
+	})
+
+	mux.HandleFunc("/api/parse-report", func(w http.ResponseWriter, r *http.Request) {
+		current := sessions.SessionForRequest(w, r).Get()
+		if current == nil {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "no file loaded"})
+			return
+		}
+		samples := current.LenientSamples
+		if samples == nil {
+			samples = []LenientLine{}
+		}
+		shortSamples := current.ShortRowSamples
+		if shortSamples == nil {
+			shortSamples = []ShortRow{}
+		}
+		mode := "lenient"
+		if strictCSV {
+			mode = "strict"
+		}
+		writeJSON(w, http.StatusOK, map[string]any{
+			"file":            current.Label,
+			"mode":            mode,
+			"lenientLines":    current.LenientLines,
+			"malformedLines":  current.MalformedLines,
+			"shortRows":       current.ShortRows,
+			"samples":         samples,
+			"shortRowSamples": shortSamples,
+			// What /api/series charts for the fields a short row lacks.
+			"shortRowFill": "previous",
+		})
+	})
+
+	mux.HandleFunc("/api/activity", scans.wrap(func(w http.ResponseWriter, r *http.Request) {
+		current := sessions.SessionForRequest(w, r).Get()
+		if current == nil {
+			writeJSON(w, http.StatusOK, map[string]any{"scores": []float64{}})
+			return
+		}
+		scores, err := current.Activity()
+		if err != nil {
+			writeJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]any{"scores": scores})
+	}))
+
+	mux.HandleFunc("/api/diagnostics/templates", func(w http.ResponseWriter, r *http.Request) {
+		sess := sessions.SessionForRequest(w, r)
+		scope, prefs := templatePrefs.get(sess, r)
+		writeJSON(w, http.StatusOK, map[string]any{
+			"templates":       templateStore.list(),
+			"preferences":     prefs,
+			"preferenceScope": scope,
+			"readOnly":        readOnly,
+		})
+	})
+
+	// Preferences are personal, so they stay writable with -read-only.
+	mux.HandleFunc("/api/diagnostics/preferences", func(w http.ResponseWriter, r *http.Request) {
+		sess := sessions.SessionForRequest(w, r)
+		switch r.Method {
+		case http.MethodGet:
+			scope, prefs := templatePrefs.get(sess, r)
+			writeJSON(w, http.StatusOK, map[string]any{"scope": scope, "preferences": prefs})
+		case http.MethodPost:
+			var req struct {
+				Enabled map[string]*bool `json:"enabled"`
+				Replace bool             `json:"replace"`
+			}
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid JSON body"})
+				return
+			}
+			scope, prefs, err := templatePrefs.update(sess, r, req.Enabled, req.Replace)
+			if err != nil {
+				writeJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+				return
+			}
+			writeJSON(w, http.StatusOK, map[string]any{"scope": scope, "preferences": prefs})
+		default:
+			w.Header().Set("Allow", "GET, POST")
+			writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "use GET or POST"})
+		}
+	})
+
+	mux.HandleFunc("/api/diagnostics/templates/save", mutating(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "use POST"})
+			return
+		}
+		_ = sessions.SessionForRequest(w, r)
+		var req struct {
+			Template DiagnosticTemplate `json:"template"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid JSON body"})
+			return
+		}
+		t, err := templateStore.upsert(req.Template)
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+			return
+		}
+		resp := map[string]any{"template": t, "templates": templateStore.list()}
+		if resolved, _ := templateStore.byID([]string{t.ID}); len(resolved) > 0 {
+			if warnings := thresholdUnitWarnings(resolved[0], templateAttributes(resolved[0])); len(warnings) > 0 {
+				resp["warnings"] = warnings
+			}
+		}
+		writeJSON(w, http.StatusOK, resp)
+	}))
+
+	mux.HandleFunc("/api/diagnostics/templates/delete", mutating(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "use POST"})
+			return
+		}
+		_ = sessions.SessionForRequest(w, r)
+		var req struct {
+			ID string `json:"id"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid JSON body"})
+			return
+		}
+		if err := templateStore.delete(req.ID); err != nil {
 			writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
 			return
 		}
 		writeJSON(w, http.StatusOK, map[string]any{"templates": templateStore.list()})
-	})
+	}))
+
+	mux.HandleFunc("/api/diagnostics/templates/import", mutating(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "use POST"})
+			return
+		}
+		_ = sessions.SessionForRequest(w, r)
+		var req struct {
+			Templates []DiagnosticTemplate `json:"templates"`
+			Replace   bool                 `json:"replace"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid JSON body"})
+			return
+		}
+		if err := templateStore.importTemplates(req.Templates, req.Replace); err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]any{"templates": templateStore.list()})
+	}))
 
 	mux.HandleFunc("/api/diagnostics/templates/export", func(w http.ResponseWriter, r *http.Request) {
 		_ = sessions.SessionForRequest(w, r)
 		writeJSON(w, http.StatusOK, map[string]any{"templates": templateStore.exportTemplates()})
 	})
 
-	mux.HandleFunc("/api/diagnostics/run", func(w http.ResponseWriter, r *http.Request) {
+	mux.HandleFunc("/api/diagnostics/run", scans.wrap(func(w http.ResponseWriter, r *http.Request) {
 		if r.Method != http.MethodPost {
 			w.Header().Set("Allow", http.MethodPost)
 			writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "use POST"})
 			return
 		}
-		current := sessions.SessionForRequest(w, r).Get()
+		sess := sessions.SessionForRequest(w, r)
+		current := sess.Get()
 		if current == nil {
 			writeJSON(w, http.StatusBadRequest, DiagnosticRunResponse{Error: "no file loaded"})
 			return
 		}
-		var req struct {
-			TemplateIDs []string `json:"templateIds"`
+		var req struct {
+			TemplateIDs []string `json:"templateIds"`
+			Start       int64    `json:"start"`
+			End         int64    `json:"end"`
+			Bookmark    string   `json:"bookmark"`
+			Lang        string   `json:"lang"`
+			Debug       bool     `json:"debug"`
+		}
+		trace := newQueryTrace(r.URL.Query())
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeJSON(w, http.StatusBadRequest, DiagnosticRunResponse{Error: "invalid JSON body"})
+			return
+		}
+		if req.Lang != "" && !messages.has(req.Lang) {
+			writeJSON(w, http.StatusBadRequest, DiagnosticRunResponse{Error: "no message pack for language: " + req.Lang})
+			return
+		}
+		var start, end time.Time
+		if req.Start > 0 {
+			start = time.UnixMilli(req.Start).UTC()
+		}
+		if req.End > 0 {
+			end = time.UnixMilli(req.End).UTC()
+		}
+		if strings.TrimSpace(req.Bookmark) != "" {
+			var err error
+			start, end, err = bookmarks.resolve(current, req.Bookmark)
+			if err != nil {
+				writeJSON(w, http.StatusBadRequest, DiagnosticRunResponse{Error: err.Error()})
+				return
+			}
+		}
+		ids := req.TemplateIDs
+		if len(ids) == 0 {
+			if _, prefs := templatePrefs.get(sess, r); len(prefs) > 0 {
+				// An empty list would mean "every enabled template" again.
+				if ids = preferredTemplateIDs(templateStore.list(), prefs); len(ids) == 0 {
+					writeJSON(w, http.StatusBadRequest, DiagnosticRunResponse{Error: "your preferences disable every template"})
+					return
+				}
+			}
+		}
+		selected, skipped := templateStore.byID(ids)
+		if req.Debug && trace == nil {
+			trace = startQueryTrace()
+		}
+		resp, err := runDiagnosticsTraced(current, selected, start, end, trace)
+		if err != nil {
+			writeJSON(w, http.StatusInternalServerError, DiagnosticRunResponse{Error: err.Error()})
+			return
+		}
+		resp.Warnings = append(skipped, resp.Warnings...)
+		resp.RunID = runs.add(current, selected, start, end, resp)
+		if _, err := history.add(current, selected, start, end, resp); err != nil {
+			log.Printf("recording run history failed: %v", err)
+		}
+		events.publish(sess, "diagnostics", newDiagnosticsEvent(current, resp))
+		if req.Lang != "" {
+			// Stored runs keep the default language; localize a copy.
+			resp.Findings = append([]DiagnosticFinding(nil), resp.Findings...)
+			localizeFindings(resp.Findings, req.Lang)
+		}
+		writeJSON(w, http.StatusOK, resp)
+	}))
+
+	mux.HandleFunc("/api/messages", func(w http.ResponseWriter, r *http.Request) {
+		out := map[string]any{
+			"default":   messages.defaultLang,
+			"languages": messages.languages(),
+		}
+		if l := strings.TrimSpace(r.URL.Query().Get("lang")); l != "" {
+			if !messages.has(l) {
+				writeJSON(w, http.StatusNotFound, map[string]string{"error": "no message pack for language: " + l})
+				return
+			}
+			out["lang"] = normalizeLang(l)
+			out["texts"] = messages.texts(l)
+		}
+		writeJSON(w, http.StatusOK, out)
+	})
+
+	mux.HandleFunc("/api/diagnostics/sweep", scans.wrap(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "use POST"})
+			return
+		}
+		current := sessions.SessionForRequest(w, r).Get()
+		if current == nil {
+			writeJSON(w, http.StatusBadRequest, SweepResponse{Error: "no file loaded"})
+			return
+		}
+		var req struct {
+			TemplateID     string    `json:"templateId"`
+			Thresholds     []float64 `json:"thresholds"`
+			MinConsecutive []int     `json:"minConsecutive"`
+			Start          int64     `json:"start"`
+			End            int64     `json:"end"`
+			Bookmark       string    `json:"bookmark"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeJSON(w, http.StatusBadRequest, SweepResponse{Error: "invalid JSON body"})
+			return
+		}
+		if strings.TrimSpace(req.TemplateID) == "" {
+			writeJSON(w, http.StatusBadRequest, SweepResponse{Error: "templateId is required"})
+			return
+		}
+		selected, skipped := templateStore.byID([]string{req.TemplateID})
+		if len(selected) == 0 {
+			writeJSON(w, http.StatusNotFound, SweepResponse{Error: strings.Join(skipped, "; ")})
+			return
+		}
+		var start, end time.Time
+		if req.Start > 0 {
+			start = time.UnixMilli(req.Start).UTC()
+		}
+		if req.End > 0 {
+			end = time.UnixMilli(req.End).UTC()
+		}
+		if strings.TrimSpace(req.Bookmark) != "" {
+			var err error
+			start, end, err = bookmarks.resolve(current, req.Bookmark)
+			if err != nil {
+				writeJSON(w, http.StatusBadRequest, SweepResponse{Error: err.Error()})
+				return
+			}
+		}
+		resp, err := sweepTemplate(current, selected[0], req.Thresholds, req.MinConsecutive, start, end)
+		if err != nil {
+			resp.Error = err.Error()
+			writeJSON(w, http.StatusBadRequest, resp)
+			return
+		}
+		writeJSON(w, http.StatusOK, resp)
+	}))
+
+	mux.HandleFunc("/api/diagnostics/runs", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, map[string]any{"runs": runs.list()})
+	})
+
+	mux.HandleFunc("/api/diagnostics/findings/", scans.wrap(func(w http.ResponseWriter, r *http.Request) {
+		rest := strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/diagnostics/findings/"), "/")
+		id, action, _ := strings.Cut(rest, "/")
+		stored, ok := lookupFinding(runs, history, id)
+		if !ok {
+			writeJSON(w, http.StatusNotFound, map[string]string{"error": "unknown finding: " + id})
+			return
+		}
+		switch action {
+		case "":
+			writeJSON(w, http.StatusOK, stored)
+			return
+		case "replay":
+		default:
+			writeJSON(w, http.StatusNotFound, map[string]string{"error": "unknown finding action: " + action})
+			return
+		}
+		// Replays run against the session's file, or a capture it keeps
+		// for comparison (?file=<label>).
+		q := r.URL.Query()
+		df := sessions.SessionForRequest(w, r).File(q.Get("file"))
+		if df == nil {
+			writeJSON(w, http.StatusBadRequest, FindingReplayResponse{Error: "no file loaded"})
+			return
+		}
+		if lang := q.Get("lang"); lang != "" && !messages.has(lang) {
+			writeJSON(w, http.StatusBadRequest, FindingReplayResponse{Error: "no message pack for language: " + lang})
+			return
+		}
+		resp, err := replayFinding(df, stored)
+		if err != nil {
+			resp.Error = err.Error()
+			writeJSON(w, http.StatusUnprocessableEntity, resp)
+			return
+		}
+		if lang := q.Get("lang"); lang != "" {
+			localizeFindings(resp.Findings, lang)
+		}
+		writeJSON(w, http.StatusOK, resp)
+	}))
+
+	mux.HandleFunc("/api/history", func(w http.ResponseWriter, r *http.Request) {
+		limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
+		if limit <= 0 {
+			limit = 100
+		}
+		writeJSON(w, http.StatusOK, map[string]any{"entries": history.list(r.URL.Query().Get("host"), limit)})
+	})
+
+	mux.HandleFunc("/api/history/hosts", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, map[string]any{"hosts": history.hosts()})
+	})
+
+	mux.HandleFunc("/api/history/trend", func(w http.ResponseWriter, r *http.Request) {
+		trend, err := history.trend(r.URL.Query().Get("host"), r.URL.Query().Get("template"))
+		if err != nil {
+			trend.Error = err.Error()
+			writeJSON(w, http.StatusBadRequest, trend)
+			return
+		}
+		writeJSON(w, http.StatusOK, trend)
+	})
+
+	mux.HandleFunc("/api/history/run/", func(w http.ResponseWriter, r *http.Request) {
+		e, ok := history.get(strings.TrimPrefix(r.URL.Path, "/api/history/run/"))
+		if !ok {
+			writeJSON(w, http.StatusNotFound, map[string]string{"error": "unknown history entry"})
+			return
+		}
+		writeJSON(w, http.StatusOK, e)
+	})
+
+	mux.HandleFunc("/api/history/delete", mutating(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "use POST"})
+			return
+		}
+		var req struct {
+			ID string `json:"id"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid JSON body"})
+			return
+		}
+		if err := history.delete(req.ID); err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]any{"deleted": req.ID})
+	}))
+
+	mux.HandleFunc("/api/diagnostics/diff", func(w http.ResponseWriter, r *http.Request) {
+		var base, target []DiagnosticFinding
+		var baseName, targetName string
+		switch r.Method {
+		case http.MethodGet:
+			b, ok := runs.get(r.URL.Query().Get("base"))
+			if !ok {
+				writeJSON(w, http.StatusNotFound, FindingsDiff{Error: "unknown base run"})
+				return
+			}
+			t, ok := runs.get(r.URL.Query().Get("target"))
+			if !ok {
+				writeJSON(w, http.StatusNotFound, FindingsDiff{Error: "unknown target run"})
+				return
+			}
+			base, target = b.Result.Findings, t.Result.Findings
+			baseName, targetName = b.ID, t.ID
+		case http.MethodPost:
+			var req struct {
+				Base   DiagnosticRunResponse `json:"base"`
+				Target DiagnosticRunResponse `json:"target"`
+			}
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				writeJSON(w, http.StatusBadRequest, FindingsDiff{Error: "invalid JSON body"})
+				return
+			}
+			base, target = req.Base.Findings, req.Target.Findings
+			baseName, targetName = req.Base.RunID, req.Target.RunID
+		default:
+			w.Header().Set("Allow", "GET, POST")
+			writeJSON(w, http.StatusMethodNotAllowed, FindingsDiff{Error: "use GET or POST"})
+			return
+		}
+		diff := diffFindings(base, target)
+		diff.Base, diff.Target = baseName, targetName
+		writeJSON(w, http.StatusOK, diff)
+	})
+
+	mux.HandleFunc("/api/diagnostics/export/sr", scans.wrap(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "use POST"})
+			return
+		}
+		current := sessions.SessionForRequest(w, r).Get()
+		var req struct {
+			Findings    *[]DiagnosticFinding `json:"findings"`
+			TemplateIDs []string             `json:"templateIds"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid JSON body"})
+			return
+		}
+		var findings []DiagnosticFinding
+		if req.Findings != nil {
+			findings = *req.Findings
+		} else {
+			if current == nil {
+				writeJSON(w, http.StatusBadRequest, map[string]string{"error": "no file loaded"})
+				return
+			}
+			selected, skipped := templateStore.byID(req.TemplateIDs)
+			if len(skipped) > 0 {
+				writeJSON(w, http.StatusBadRequest, map[string]string{"error": strings.Join(skipped, "; ")})
+				return
+			}
+			resp, err := runDiagnostics(current, selected, time.Time{}, time.Time{})
+			if err != nil {
+				writeJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+				return
+			}
+			findings = resp.Findings
+		}
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		_, _ = io.WriteString(w, renderSRNote(current, findings))
+	}))
+
+	mux.HandleFunc("/api/report/sections", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, map[string]any{"sections": reportSectionDefs})
+	})
+
+	mux.HandleFunc("/api/report/section/", scans.wrap(func(w http.ResponseWriter, r *http.Request) {
+		key := strings.ToLower(strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/report/section/"), "/"))
+		if _, ok := reportSectionLabel(key); !ok {
+			writeJSON(w, http.StatusNotFound, ReportSection{ReportKey: key, Error: "unknown report key"})
+			return
+		}
+		current := sessions.SessionForRequest(w, r).Get()
+		if current == nil {
+			writeJSON(w, http.StatusBadRequest, ReportSection{ReportKey: key, Error: "no file loaded"})
+			return
+		}
+		start := parseTimeQuery(r, "start")
+		end := parseTimeQuery(r, "end")
+		if name := strings.TrimSpace(r.URL.Query().Get("bookmark")); name != "" {
+			var err error
+			start, end, err = bookmarks.resolve(current, name)
+			if err != nil {
+				writeJSON(w, http.StatusBadRequest, ReportSection{ReportKey: key, Error: err.Error()})
+				return
+			}
+		}
+		selected, skipped := templateStore.byID(r.URL.Query()["template"])
+		sec, err := buildReportSection(current, key, selected, start, end)
+		sec.Warnings = append(skipped, sec.Warnings...)
+		if err != nil {
+			sec.Error = err.Error()
+			writeJSON(w, http.StatusInternalServerError, sec)
+			return
+		}
+		writeJSON(w, http.StatusOK, sec)
+	}))
+
+	mux.HandleFunc("/api/report/templates", func(w http.ResponseWriter, r *http.Request) {
+		list, err := loadReportTemplates(webFS, reportDir)
+		if err != nil {
+			writeJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]any{"reports": list})
+	})
+
+	mux.HandleFunc("/api/report/render", scans.wrap(func(w http.ResponseWriter, r *http.Request) {
+		current := sessions.SessionForRequest(w, r).Get()
+		if current == nil {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "no file loaded"})
+			return
+		}
+		list, err := loadReportTemplates(webFS, reportDir)
+		if err != nil {
+			writeJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+			return
+		}
+		id := strings.TrimSpace(r.URL.Query().Get("template"))
+		var rt *ReportTemplate
+		for i := range list {
+			if list[i].ID == id {
+				rt = &list[i]
+				break
+			}
+		}
+		if rt == nil {
+			writeJSON(w, http.StatusNotFound, map[string]string{"error": "unknown report template"})
+			return
+		}
+		params := r.URL.Query()
+		if name := strings.TrimSpace(params.Get("bookmark")); name != "" {
+			params.Set("start", "bookmark:"+name)
+			params.Set("end", "bookmark:"+name)
+		}
+		var buf bytes.Buffer
+		if err := renderReportTemplate(&buf, current, *rt, params, queries, bookmarks, templateStore); err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+			return
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		if r.URL.Query().Get("download") == "1" {
+			w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", rt.ID+".html"))
+		}
+		_, _ = w.Write(buf.Bytes())
+	}))
+
+	mux.HandleFunc("/api/report/capacity", scans.wrap(func(w http.ResponseWriter, r *http.Request) {
+		current := sessions.SessionForRequest(w, r).Get()
+		if current == nil {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "no file loaded"})
+			return
+		}
+		start := parseTimeQuery(r, "start")
+		end := parseTimeQuery(r, "end")
+		if name := strings.TrimSpace(r.URL.Query().Get("bookmark")); name != "" {
+			var err error
+			start, end, err = bookmarks.resolve(current, name)
+			if err != nil {
+				writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+				return
+			}
+		}
+		rep, err := buildCapacityReport(current, start, end)
+		if err != nil {
+			writeJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+			return
+		}
+		if r.URL.Query().Get("format") == "text" {
+			w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+			writeCapacityText(w, rep)
+			return
+		}
+		writeJSON(w, http.StatusOK, rep)
+	}))
+
+	// The anonymized export assigns and saves pseudonyms for names it has
+	// not seen, so it is a write.
+	mux.HandleFunc("/api/export/anonymized", mutating(scans.wrap(func(w http.ResponseWriter, r *http.Request) {
+		current := sessions.SessionForRequest(w, r).Get()
+		if current == nil {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "no file loaded"})
+			return
+		}
+		q := r.URL.Query()
+		opts := anonymizeOptions{Mode: strings.TrimSpace(q.Get("mode")), Start: parseTimeQuery(r, "start"), End: parseTimeQuery(r, "end")}
+		if opts.Mode == "" {
+			opts.Mode = "pseudonym"
+		}
+		if opts.Mode != "pseudonym" && opts.Mode != "hash" {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "mode must be pseudonym or hash"})
+			return
+		}
+		for _, raw := range strings.Split(q.Get("cols"), ",") {
+			if raw = strings.TrimSpace(raw); raw == "" {
+				continue
+			}
+			idx, err := strconv.Atoi(raw)
+			if err != nil {
+				writeJSON(w, http.StatusBadRequest, map[string]string{"error": fmt.Sprintf("invalid column %q", raw)})
+				return
+			}
+			opts.Columns = append(opts.Columns, idx)
+		}
+		if name := strings.TrimSpace(q.Get("bookmark")); name != "" {
+			var err error
+			opts.Start, opts.End, err = bookmarks.resolve(current, name)
+			if err != nil {
+				writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+				return
+			}
+		}
+		out := &downloadWriter{w: w, filename: "anonymized.csv", contentType: "text/csv; charset=utf-8"}
+		if _, err := writeAnonymizedCSV(r.Context(), out, current, pseudonyms, opts); err != nil {
+			if !out.started {
+				writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+				return
+			}
+			if !errors.Is(err, context.Canceled) {
+				log.Printf("anonymized export failed: %v", err)
+			}
+		}
+	})))
+
+	// exportSlice serves /api/export and its older name /api/export/slice.
+	exportSlice := scans.wrap(func(w http.ResponseWriter, r *http.Request) {
+		current := sessions.SessionForRequest(w, r).Get()
+		if current == nil {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "no file loaded"})
+			return
+		}
+		q := r.URL.Query()
+		colsParam := q["col"]
+		if len(colsParam) == 0 {
+			colsParam = strings.Split(q.Get("cols"), ",")
+		}
+		var cols []int
+		for _, raw := range colsParam {
+			if raw = strings.TrimSpace(raw); raw == "" {
+				continue
+			}
+			idx, err := strconv.Atoi(raw)
+			if err != nil {
+				writeJSON(w, http.StatusBadRequest, map[string]string{"error": fmt.Sprintf("invalid column %q", raw)})
+				return
+			}
+			cols = append(cols, idx)
+		}
+		if attr := strings.TrimSpace(q.Get("attr")); attr != "" {
+			matched := current.resolveColumnsByAttribute(attr, q["instance"])
+			if len(matched) == 0 {
+				writeJSON(w, http.StatusBadRequest, map[string]string{"error": "no columns match attr " + attr})
+				return
+			}
+			cols = append(cols, matched...)
+		}
+		start, end := parseTimeQuery(r, "start"), parseTimeQuery(r, "end")
+		if name := strings.TrimSpace(q.Get("bookmark")); name != "" {
+			var err error
+			start, end, err = bookmarks.resolve(current, name)
+			if err != nil {
+				writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+				return
+			}
+		}
+		out := &downloadWriter{w: w, filename: exportFilename(current, start, end), contentType: "text/csv; charset=utf-8"}
+		if _, err := writeSlice(r.Context(), out, current, cols, start, end); err != nil {
+			if !out.started {
+				writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+				return
+			}
+			if !errors.Is(err, context.Canceled) {
+				log.Printf("slice export failed: %v", err)
+			}
+		}
+	})
+	mux.HandleFunc("/api/export", exportSlice)
+	mux.HandleFunc("/api/export/slice", exportSlice)
+
+	mux.HandleFunc("/api/export/influx", scans.wrap(func(w http.ResponseWriter, r *http.Request) {
+		current := sessions.SessionForRequest(w, r).Get()
+		if current == nil {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "no file loaded"})
+			return
+		}
+		q := r.URL.Query()
+		var cols []int
+		for _, raw := range strings.Split(q.Get("cols"), ",") {
+			if raw = strings.TrimSpace(raw); raw == "" {
+				continue
+			}
+			idx, err := strconv.Atoi(raw)
+			if err != nil {
+				writeJSON(w, http.StatusBadRequest, map[string]string{"error": fmt.Sprintf("invalid column %q", raw)})
+				return
+			}
+			cols = append(cols, idx)
+		}
+		unit, err := parseInfluxPrecision(q.Get("precision"))
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+			return
+		}
+		start, end := parseTimeQuery(r, "start"), parseTimeQuery(r, "end")
+		if name := strings.TrimSpace(q.Get("bookmark")); name != "" {
+			start, end, err = bookmarks.resolve(current, name)
+			if err != nil {
+				writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+				return
+			}
+		}
+		out := &downloadWriter{w: w, filename: influxFilename(current, start, end), contentType: "text/plain; charset=utf-8"}
+		if _, err := writeInflux(r.Context(), out, current, cols, start, end, unit); err != nil {
+			if !out.started {
+				writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+				return
+			}
+			if !errors.Is(err, context.Canceled) {
+				log.Printf("line protocol export failed: %v", err)
+			}
+		}
+	}))
+
+	mux.HandleFunc("/api/export/parquet", scans.wrap(func(w http.ResponseWriter, r *http.Request) {
+		current := sessions.SessionForRequest(w, r).Get()
+		if current == nil {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "no file loaded"})
+			return
+		}
+		q := r.URL.Query()
+		colsParam := q["col"]
+		if len(colsParam) == 0 {
+			colsParam = strings.Split(q.Get("cols"), ",")
+		}
+		var cols []int
+		for _, raw := range colsParam {
+			if raw = strings.TrimSpace(raw); raw == "" {
+				continue
+			}
+			idx, err := strconv.Atoi(raw)
+			if err != nil {
+				writeJSON(w, http.StatusBadRequest, map[string]string{"error": fmt.Sprintf("invalid column %q", raw)})
+				return
+			}
+			cols = append(cols, idx)
+		}
+		if attr := strings.TrimSpace(q.Get("attr")); attr != "" {
+			matched := current.resolveColumnsByAttribute(attr, q["instance"])
+			if len(matched) == 0 {
+				writeJSON(w, http.StatusBadRequest, map[string]string{"error": "no columns match attr " + attr})
+				return
+			}
+			cols = append(cols, matched...)
+		}
+		start, end := parseTimeQuery(r, "start"), parseTimeQuery(r, "end")
+		if name := strings.TrimSpace(q.Get("bookmark")); name != "" {
+			var err error
+			start, end, err = bookmarks.resolve(current, name)
+			if err != nil {
+				writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+				return
+			}
+		}
+		out := &downloadWriter{w: w, filename: parquetFilename(current, start, end), contentType: "application/vnd.apache.parquet"}
+		if _, err := writeParquet(r.Context(), out, current, cols, start, end); err != nil {
+			if !out.started {
+				writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+				return
+			}
+			if !errors.Is(err, context.Canceled) {
+				log.Printf("parquet export failed: %v", err)
+			}
+		}
+	}))
+
+	mux.HandleFunc("/api/export/pseudonyms", func(w http.ResponseWriter, r *http.Request) {
+		// The mapping undoes the anonymization, so it is only for whoever
+		// runs this instance for themselves: never on a read-only or
+		// shared instance, only from this machine, and only for the names
+		// of the session's capture. `esx-doctor pseudonyms` shows it all.
+		if readOnly || shared != nil {
+			writeJSON(w, http.StatusForbidden, map[string]string{"error": "the pseudonym mapping is not served by a read-only or shared instance; run esx-doctor pseudonyms on the server"})
+			return
+		}
+		if host, _, err := net.SplitHostPort(r.RemoteAddr); err != nil || !isLoopbackHost(host) {
+			writeJSON(w, http.StatusForbidden, map[string]string{"error": "the pseudonym mapping is only served to this machine"})
+			return
+		}
+		current := sessions.SessionForRequest(w, r).Get()
+		if current == nil {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "no file loaded"})
+			return
+		}
+		vms, hosts := captureNames(current)
+		w.Header().Set("Cache-Control", "no-store")
+		writeJSON(w, http.StatusOK, map[string]any{"file": current.Label, "pseudonyms": pseudonyms.mappingFor(vms, hosts)})
+	})
+
+	mux.HandleFunc("/api/states", scans.wrap(func(w http.ResponseWriter, r *http.Request) {
+		current := sessions.SessionForRequest(w, r).Get()
+		if current == nil {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "no file loaded"})
+			return
+		}
+		thresholds, err := parseStateThresholds(r.URL.Query())
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+			return
+		}
+		start := parseTimeQuery(r, "start")
+		end := parseTimeQuery(r, "end")
+		if name := strings.TrimSpace(r.URL.Query().Get("bookmark")); name != "" {
+			start, end, err = bookmarks.resolve(current, name)
+			if err != nil {
+				writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+				return
+			}
+		}
+		resp, err := buildVMStates(current, r.URL.Query().Get("vm"), thresholds, start, end)
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+			return
+		}
+		writeJSON(w, http.StatusOK, resp)
+	}))
+
+	mux.HandleFunc("/api/columns", cache.wrap(sessions, func(w http.ResponseWriter, r *http.Request) {
+		current := sessions.SessionForRequest(w, r).Get()
+		if current == nil {
+			writeJSON(w, http.StatusBadRequest, ColumnSearchResponse{Error: "no file loaded"})
+			return
+		}
+		q := r.URL.Query()
+		offset, _ := strconv.Atoi(q.Get("offset"))
+		limit, _ := strconv.Atoi(q.Get("limit"))
+		writeJSON(w, http.StatusOK, current.searchColumns(q.Get("q"), q.Get("object"), q.Get("instance"), offset, limit))
+	}))
+
+	mux.HandleFunc("/api/columns/tree", cache.wrap(sessions, func(w http.ResponseWriter, r *http.Request) {
+		current := sessions.SessionForRequest(w, r).Get()
+		if current == nil {
+			writeJSON(w, http.StatusBadRequest, ColumnTree{Error: "no file loaded"})
+			return
+		}
+		writeJSON(w, http.StatusOK, current.columnTree(r.URL.Query().Get("object")))
+	}))
+
+	mux.HandleFunc("/api/columns/", scans.wrap(func(w http.ResponseWriter, r *http.Request) {
+		rest := strings.TrimPrefix(r.URL.Path, "/api/columns/")
+		rawIdx, ok := strings.CutSuffix(strings.TrimSuffix(rest, "/"), "/sample")
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		idx, err := strconv.Atoi(rawIdx)
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, ColumnSample{Error: "invalid column index"})
+			return
+		}
+		current := sessions.SessionForRequest(w, r).Get()
+		if current == nil {
+			writeJSON(w, http.StatusBadRequest, ColumnSample{Column: idx, Error: "no file loaded"})
+			return
+		}
+		n, _ := strconv.Atoi(r.URL.Query().Get("n"))
+		sample, err := current.sampleColumn(idx, n, parseTimeQuery(r, "start"), parseTimeQuery(r, "end"))
+		if err != nil {
+			sample.Error = err.Error()
+			writeJSON(w, http.StatusBadRequest, sample)
+			return
+		}
+		writeJSON(w, http.StatusOK, sample)
+	}))
+
+	mux.HandleFunc("/api/rows", scans.wrap(func(w http.ResponseWriter, r *http.Request) {
+		current := sessions.SessionForRequest(w, r).Get()
+		if current == nil {
+			writeJSON(w, http.StatusBadRequest, RowsResponse{Error: "no file loaded"})
+			return
+		}
+		q := r.URL.Query()
+		colsParam := q["col"]
+		if len(colsParam) == 0 {
+			colsParam = strings.Split(q.Get("cols"), ",")
+		}
+		var cols []int
+		for _, raw := range colsParam {
+			if raw = strings.TrimSpace(raw); raw == "" {
+				continue
+			}
+			idx, err := strconv.Atoi(raw)
+			if err != nil {
+				writeJSON(w, http.StatusBadRequest, RowsResponse{Error: fmt.Sprintf("invalid column %q", raw)})
+				return
+			}
+			cols = append(cols, idx)
+		}
+		if attr := strings.TrimSpace(q.Get("attr")); attr != "" {
+			cols = append(cols, current.resolveColumnsByAttribute(attr, q["instance"])...)
+		}
+		start, end := parseTimeQuery(r, "start"), parseTimeQuery(r, "end")
+		if name := strings.TrimSpace(q.Get("bookmark")); name != "" {
+			var err error
+			start, end, err = bookmarks.resolve(current, name)
+			if err != nil {
+				writeJSON(w, http.StatusBadRequest, RowsResponse{Error: err.Error()})
+				return
+			}
+		}
+		limit, _ := strconv.Atoi(q.Get("limit"))
+		resp, err := current.readRows(cols, start, end, limit)
+		if err != nil {
+			resp.Error = err.Error()
+			writeJSON(w, http.StatusBadRequest, resp)
+			return
+		}
+		writeJSON(w, http.StatusOK, resp)
+	}))
+
+	mux.HandleFunc("/api/catalog/preview", cache.wrap(sessions, scans.wrap(func(w http.ResponseWriter, r *http.Request) {
+		current := sessions.SessionForRequest(w, r).Get()
+		if current == nil {
+			writeJSON(w, http.StatusBadRequest, CatalogPreview{Error: "no file loaded"})
+			return
+		}
+		q := r.URL.Query()
+		colsParam := q["col"]
+		if len(colsParam) == 0 {
+			colsParam = strings.Split(q.Get("cols"), ",")
+		}
+		var cols []int
+		for _, raw := range colsParam {
+			if raw = strings.TrimSpace(raw); raw == "" {
+				continue
+			}
+			idx, err := strconv.Atoi(raw)
+			if err != nil {
+				writeJSON(w, http.StatusBadRequest, CatalogPreview{Error: fmt.Sprintf("invalid column %q", raw)})
+				return
+			}
+			cols = append(cols, idx)
+		}
+		if attr := strings.TrimSpace(q.Get("attr")); attr != "" {
+			cols = append(cols, current.resolveColumnsByAttribute(attr, q["instance"])...)
+		}
+		var window time.Duration
+		if raw := strings.TrimSpace(q.Get("window")); raw != "" {
+			d, err := time.ParseDuration(raw)
+			if err != nil || d <= 0 {
+				writeJSON(w, http.StatusBadRequest, CatalogPreview{Error: fmt.Sprintf("invalid window %q", raw)})
+				return
+			}
+			window = d
+		}
+		resp, err := current.catalogPreview(cols, window)
+		if err != nil {
+			resp.Error = err.Error()
+			writeJSON(w, http.StatusBadRequest, resp)
+			return
+		}
+		writeJSON(w, http.StatusOK, resp)
+	})))
+
+	mux.HandleFunc("/api/catalog/", cache.wrap(sessions, func(w http.ResponseWriter, r *http.Request) {
+		rest := strings.TrimPrefix(r.URL.Path, "/api/catalog/")
+		object, ok := strings.CutSuffix(strings.TrimSuffix(rest, "/"), "/defaults")
+		if !ok || strings.TrimSpace(object) == "" {
+			http.NotFound(w, r)
+			return
+		}
+		current := sessions.SessionForRequest(w, r).Get()
+		resp, ok := catalogDefaults(current, object)
+		if !ok {
+			resp.Error = "no curated defaults for object"
+			writeJSON(w, http.StatusNotFound, resp)
+			return
+		}
+		writeJSON(w, http.StatusOK, resp)
+	}))
+
+	mux.HandleFunc("/api/bookmarks", func(w http.ResponseWriter, r *http.Request) {
+		current := sessions.SessionForRequest(w, r).Get()
+		if current == nil {
+			writeJSON(w, http.StatusOK, map[string]any{"bookmarks": []TimeBookmark{}})
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]any{"bookmarks": bookmarks.list(current)})
+	})
+
+	mux.HandleFunc("/api/bookmarks/save", mutating(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "use POST"})
+			return
+		}
+		current := sessions.SessionForRequest(w, r).Get()
+		if current == nil {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "no file loaded"})
+			return
+		}
+		var req struct {
+			Bookmark TimeBookmark `json:"bookmark"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid JSON body"})
+			return
+		}
+		b, err := bookmarks.upsert(current, req.Bookmark)
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]any{"bookmark": b, "bookmarks": bookmarks.list(current)})
+	}))
+
+	mux.HandleFunc("/api/bookmarks/delete", mutating(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "use POST"})
+			return
+		}
+		current := sessions.SessionForRequest(w, r).Get()
+		if current == nil {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "no file loaded"})
+			return
+		}
+		var req struct {
+			Name string `json:"name"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid JSON body"})
+			return
+		}
+		if err := bookmarks.delete(current, req.Name); err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]any{"bookmarks": bookmarks.list(current)})
+	}))
+
+	// Links are how a read-only instance gets shared, so creating one is
+	// not a mutating request.
+	mux.HandleFunc("/api/links", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "use POST"})
+			return
+		}
+		var req ChartLink
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<20)).Decode(&req); err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid JSON body"})
+			return
+		}
+		req.File, req.ContentHash = "", ""
+		if current := sessions.SessionForRequest(w, r).Get(); current != nil {
+			req.File = current.Label
+			req.ContentHash, _ = current.contentHash()
+		}
+		link, err := links.create(req)
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]any{"token": link.Token, "url": "/l/" + link.Token, "link": link})
+	})
+
+	mux.HandleFunc("/api/links/", func(w http.ResponseWriter, r *http.Request) {
+		link, ok := links.get(strings.TrimPrefix(r.URL.Path, "/api/links/"))
+		if !ok {
+			writeJSON(w, http.StatusNotFound, map[string]string{"error": "unknown link"})
+			return
+		}
+		writeJSON(w, http.StatusOK, link)
+	})
+
+	mux.HandleFunc("/api/queries", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, map[string]any{"queries": queries.list()})
+	})
+
+	mux.HandleFunc("/api/queries/save", mutating(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "use POST"})
+			return
+		}
+		var req struct {
+			Query SavedQuery `json:"query"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid JSON body"})
+			return
+		}
+		q, err := queries.upsert(req.Query)
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]any{"query": q, "queries": queries.list()})
+	}))
+
+	mux.HandleFunc("/api/queries/delete", mutating(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "use POST"})
+			return
+		}
+		var req struct {
+			Name string `json:"name"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid JSON body"})
+			return
+		}
+		if err := queries.delete(req.Name); err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]any{"queries": queries.list()})
+	}))
+
+	mux.HandleFunc("/api/open", mutating(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "use POST"})
+			return
+		}
+		var req struct {
+			Path   string `json:"path"`
+			Stitch bool   `json:"stitch"`
+			// Follow keeps indexing the capture as it is written.
+			Follow bool `json:"follow"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid JSON body"})
+			return
+		}
+		req.Path = strings.TrimSpace(req.Path)
+		if req.Path == "" {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "path is required"})
+			return
+		}
+		abs, err := filepath.Abs(req.Path)
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid path"})
+			return
+		}
+		if _, err := os.Stat(abs); err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "file not found"})
+			return
+		}
+		// A locked session is refused before spending time on the index.
+		sess := sessions.SessionForRequest(w, r)
+		by, force := shared.requester(r, sess, "open"), parseTruthy(r.URL.Query().Get("force"))
+		if err := shared.check(sess, by, force); err != nil {
+			writeJSON(w, http.StatusConflict, map[string]string{"error": err.Error()})
+			return
+		}
+		var newDF *DataFile
+		skipped := []string{}
+		if req.Stitch && req.Follow {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "stitch and follow cannot be combined"})
+			return
+		}
+		if req.Stitch {
+			var s []string
+			newDF, s, err = stitchCapture(abs)
+			skipped = append(skipped, s...)
+		} else if req.Follow {
+			var ok bool
+			if newDF, ok = follows.current(abs); !ok {
+				if newDF, err = buildIndexFollow(abs, true); err == nil {
+					newDF = follows.follow(newDF)
+				}
+			}
+		} else {
+			newDF, err = loadOrBuildIndex(abs)
 		}
-		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-			writeJSON(w, http.StatusBadRequest, DiagnosticRunResponse{Error: "invalid JSON body"})
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": fmt.Sprintf("index build failed: %v", err)})
 			return
 		}
-		selected := templateStore.byID(req.TemplateIDs)
-		resp, err := runDiagnostics(current, selected)
-		if err != nil {
-			writeJSON(w, http.StatusInternalServerError, DiagnosticRunResponse{Error: err.Error()})
+		if len(newDF.Parts) == 0 && newDF.Label != abs {
+			newDF.Label = abs
+		}
+		if err := shared.replace(sess, newDF, by, force); err != nil {
+			writeJSON(w, http.StatusConflict, map[string]string{"error": err.Error()})
 			return
 		}
-		writeJSON(w, http.StatusOK, resp)
-	})
+		writeJSON(w, http.StatusOK, map[string]any{
+			"file":    newDF.Label,
+			"rows":    newDF.Rows,
+			"start":   unixMilliOrZero(newDF.StartTime),
+			"end":     unixMilliOrZero(newDF.EndTime),
+			"parts":   newDF.partNames(),
+			"skipped": skipped,
+		})
+	}))
 
-	mux.HandleFunc("/api/open", func(w http.ResponseWriter, r *http.Request) {
+	mux.HandleFunc("/api/open-bundle", mutating(func(w http.ResponseWriter, r *http.Request) {
 		if r.Method != http.MethodPost {
 			w.Header().Set("Allow", http.MethodPost)
 			writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "use POST"})
@@ -799,6 +2845,7 @@ func main() {
 		}
 		var req struct {
 			Path string `json:"path"`
+			Name string `json:"name"`
 		}
 		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid JSON body"})
@@ -818,51 +2865,261 @@ func main() {
 			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "file not found"})
 			return
 		}
-		newDF, err := buildIndex(abs)
+		list, err := listBundleCSVs(abs)
 		if err != nil {
-			writeJSON(w, http.StatusBadRequest, map[string]string{"error": fmt.Sprintf("index build failed: %v", err)})
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
 			return
 		}
-		newDF.Label = abs
-		sessions.SessionForRequest(w, r).Replace(newDF)
-		writeJSON(w, http.StatusOK, map[string]any{
-			"file":  newDF.Label,
-			"rows":  newDF.Rows,
-			"start": newDF.StartTime.UnixMilli(),
-			"end":   newDF.EndTime.UnixMilli(),
-		})
+		// Without a name this only lists; the caller picks one and asks again.
+		if strings.TrimSpace(req.Name) == "" {
+			writeJSON(w, http.StatusOK, map[string]any{"bundle": abs, "csvs": list})
+			return
+		}
+		name, err := resolveBundleCSV(list, req.Name)
+		if err != nil {
+			writeJSON(w, http.StatusNotFound, map[string]string{"error": err.Error()})
+			return
+		}
+		tmpPath, err := extractBundleCSV(abs, name)
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+			return
+		}
+		sess := sessions.SessionForRequest(w, r)
+		job, err := indexing.submit(tmpPath, bundleLabel(abs, name), "bundle", sess, shared.requester(r, sess, "bundle"), parseTruthy(r.URL.Query().Get("force")))
+		if isFileLocked(err) {
+			_ = os.Remove(tmpPath)
+			writeJSON(w, http.StatusConflict, map[string]string{"error": err.Error()})
+			return
+		}
+		if err != nil {
+			_ = os.Remove(tmpPath)
+			w.Header().Set("Retry-After", "5")
+			writeJSON(w, http.StatusServiceUnavailable, map[string]string{"error": err.Error()})
+			return
+		}
+		w.Header().Set("Location", "/api/jobs/"+job.ID)
+		writeJSON(w, http.StatusAccepted, job)
+	}))
+
+	mux.HandleFunc("/api/watch", func(w http.ResponseWriter, r *http.Request) {
+		if watch == nil {
+			writeJSON(w, http.StatusNotFound, map[string]string{"error": "watch mode is off (start with -watch-dir <dir>)"})
+			return
+		}
+		writeJSON(w, http.StatusOK, watch.overview())
+	})
+
+	mux.HandleFunc("/api/fleet", func(w http.ResponseWriter, r *http.Request) {
+		if fleet == nil {
+			writeJSON(w, http.StatusNotFound, map[string]string{"error": "fleet mode is off (start with -fleet <dir>)"})
+			return
+		}
+		writeJSON(w, http.StatusOK, fleet.overview())
+	})
+
+	mux.HandleFunc("/api/fleet/", func(w http.ResponseWriter, r *http.Request) {
+		if fleet == nil {
+			writeJSON(w, http.StatusNotFound, map[string]string{"error": "fleet mode is off (start with -fleet <dir>)"})
+			return
+		}
+		id := strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/fleet/"), "/")
+		if id == "open" {
+			// Fleet captures were chosen by the operator, so drilling into
+			// one is allowed even with -read-only.
+			if r.Method != http.MethodPost {
+				w.Header().Set("Allow", http.MethodPost)
+				writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "use POST"})
+				return
+			}
+			var req struct {
+				ID string `json:"id"`
+			}
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid JSON body"})
+				return
+			}
+			df, err := fleet.capture(strings.TrimSpace(req.ID))
+			if err != nil {
+				writeJSON(w, http.StatusNotFound, map[string]string{"error": err.Error()})
+				return
+			}
+			sess := sessions.SessionForRequest(w, r)
+			if err := shared.replace(sess, df, shared.requester(r, sess, "fleet"), parseTruthy(r.URL.Query().Get("force"))); err != nil {
+				writeJSON(w, http.StatusConflict, map[string]string{"error": err.Error()})
+				return
+			}
+			writeJSON(w, http.StatusOK, map[string]any{
+				"file":  df.Label,
+				"rows":  df.Rows,
+				"start": unixMilliOrZero(df.StartTime),
+				"end":   unixMilliOrZero(df.EndTime),
+			})
+			return
+		}
+		detail, ok := fleet.detail(id)
+		if !ok {
+			writeJSON(w, http.StatusNotFound, map[string]string{"error": "unknown capture: " + id})
+			return
+		}
+		writeJSON(w, http.StatusOK, detail)
+	})
+
+	mux.HandleFunc("/api/whoami", func(w http.ResponseWriter, r *http.Request) {
+		resp := map[string]any{"user": "", "groups": []string{}, "defaultFile": ""}
+		if sessions.defaults != nil {
+			user, groups := sessions.defaults.identity(r)
+			resp["user"] = user
+			if groups != nil {
+				resp["groups"] = groups
+			}
+			if pinned := sessions.defaults.resolve(r); pinned != nil {
+				resp["defaultFile"] = pinned.Label
+			}
+		}
+		writeJSON(w, http.StatusOK, resp)
 	})
 
-	mux.HandleFunc("/api/upload", func(w http.ResponseWriter, r *http.Request) {
+	mux.HandleFunc("/api/upload", mutating(func(w http.ResponseWriter, r *http.Request) {
 		if r.Method != http.MethodPost {
 			w.Header().Set("Allow", http.MethodPost)
 			writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "use POST"})
 			return
 		}
 
+		limitUpload(w, r, uploadMaxBytes)
 		file, header, err := r.FormFile("file")
+		if isUploadTooLarge(err) {
+			writeJSON(w, http.StatusRequestEntityTooLarge, map[string]string{"error": fmt.Sprintf("upload exceeds the -upload-max-bytes limit (%d bytes)", uploadMaxBytes)})
+			return
+		}
 		if err != nil {
 			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "file is required"})
 			return
 		}
 		defer file.Close()
 
-		newDF, err := indexUploadedOrFetchedCSV(file, strings.TrimSpace(header.Filename), "esx-doctor-upload-*.csv")
+		// The body can only be read while the request is alive, so the copy
+		// to disk happens here; indexing is handed to the worker pool.
+		tmpPath, err := persistTempCSV(file, "esx-doctor-upload-*.csv")
+		if errors.Is(err, errCaptureTooLarge) {
+			writeJSON(w, http.StatusRequestEntityTooLarge, map[string]string{"error": err.Error()})
+			return
+		}
 		if err != nil {
-			writeJSON(w, http.StatusBadRequest, map[string]string{"error": fmt.Sprintf("index build failed: %v", err)})
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
 			return
 		}
+		sess := sessions.SessionForRequest(w, r)
+		job, err := indexing.submit(tmpPath, strings.TrimSpace(header.Filename), "upload", sess, shared.requester(r, sess, "upload"), parseTruthy(r.URL.Query().Get("force")))
+		if isFileLocked(err) {
+			_ = os.Remove(tmpPath)
+			writeJSON(w, http.StatusConflict, map[string]string{"error": err.Error()})
+			return
+		}
+		if err != nil {
+			_ = os.Remove(tmpPath)
+			w.Header().Set("Retry-After", "5")
+			writeJSON(w, http.StatusServiceUnavailable, map[string]string{"error": err.Error()})
+			return
+		}
+		w.Header().Set("Location", "/api/jobs/"+job.ID)
+		writeJSON(w, http.StatusAccepted, job)
+	}))
 
-		sessions.SessionForRequest(w, r).Replace(newDF)
-		writeJSON(w, http.StatusOK, map[string]any{
-			"file":  newDF.Label,
-			"rows":  newDF.Rows,
-			"start": newDF.StartTime.UnixMilli(),
-			"end":   newDF.EndTime.UnixMilli(),
-		})
+	mux.HandleFunc("/api/import/influx", mutating(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "use POST"})
+			return
+		}
+		unit, err := parseInfluxPrecision(r.URL.Query().Get("precision"))
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+			return
+		}
+		// A form upload like /api/upload, or the line protocol as the body.
+		limitUpload(w, r, uploadMaxBytes)
+		var body io.Reader = r.Body
+		label := strings.TrimSpace(r.URL.Query().Get("label"))
+		if strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/") {
+			file, header, err := r.FormFile("file")
+			if isUploadTooLarge(err) {
+				writeJSON(w, http.StatusRequestEntityTooLarge, map[string]string{"error": fmt.Sprintf("upload exceeds the -upload-max-bytes limit (%d bytes)", uploadMaxBytes)})
+				return
+			}
+			if err != nil {
+				writeJSON(w, http.StatusBadRequest, map[string]string{"error": "file is required"})
+				return
+			}
+			defer file.Close()
+			body = file
+			if label == "" {
+				label = strings.TrimSpace(header.Filename)
+			}
+		}
+		if label == "" {
+			label = "influx.lp"
+		}
+		lpPath, err := persistTempCSV(body, "esx-doctor-influx-*.lp")
+		if isUploadTooLarge(err) {
+			writeJSON(w, http.StatusRequestEntityTooLarge, map[string]string{"error": fmt.Sprintf("upload exceeds the -upload-max-bytes limit (%d bytes)", uploadMaxBytes)})
+			return
+		}
+		if errors.Is(err, errCaptureTooLarge) {
+			writeJSON(w, http.StatusRequestEntityTooLarge, map[string]string{"error": err.Error()})
+			return
+		}
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+			return
+		}
+		defer os.Remove(lpPath)
+		tmp, err := os.CreateTemp("", "esx-doctor-influx-*.csv")
+		if err != nil {
+			writeJSON(w, http.StatusInternalServerError, map[string]string{"error": fmt.Sprintf("failed to create temp file: %v", err)})
+			return
+		}
+		_, err = convertInflux(lpPath, tmp, unit)
+		if cerr := tmp.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			_ = os.Remove(tmp.Name())
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+			return
+		}
+		sess := sessions.SessionForRequest(w, r)
+		job, err := indexing.submit(tmp.Name(), label, "upload", sess, shared.requester(r, sess, "upload"), parseTruthy(r.URL.Query().Get("force")))
+		if isFileLocked(err) {
+			_ = os.Remove(tmp.Name())
+			writeJSON(w, http.StatusConflict, map[string]string{"error": err.Error()})
+			return
+		}
+		if err != nil {
+			_ = os.Remove(tmp.Name())
+			w.Header().Set("Retry-After", "5")
+			writeJSON(w, http.StatusServiceUnavailable, map[string]string{"error": err.Error()})
+			return
+		}
+		w.Header().Set("Location", "/api/jobs/"+job.ID)
+		writeJSON(w, http.StatusAccepted, job)
+	}))
+
+	mux.HandleFunc("/api/events", func(w http.ResponseWriter, r *http.Request) {
+		events.serve(w, r, sessions.SessionForRequest(w, r))
+	})
+
+	mux.HandleFunc("/api/jobs/", func(w http.ResponseWriter, r *http.Request) {
+		job, ok := indexing.get(strings.TrimPrefix(r.URL.Path, "/api/jobs/"))
+		if !ok {
+			writeJSON(w, http.StatusNotFound, map[string]string{"error": "job not found"})
+			return
+		}
+		writeJSON(w, http.StatusOK, job)
 	})
 
-	mux.HandleFunc("/api/open-url", func(w http.ResponseWriter, r *http.Request) {
+	mux.HandleFunc("/api/open-url", mutating(func(w http.ResponseWriter, r *http.Request) {
 		if r.Method != http.MethodPost {
 			w.Header().Set("Allow", http.MethodPost)
 			writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "use POST"})
@@ -885,21 +3142,18 @@ func main() {
 			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid URL"})
 			return
 		}
-		if parsed.Scheme != "http" && parsed.Scheme != "https" {
-			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "URL must use http or https"})
+		if err := urlPolicy.checkURL(parsed); err != nil {
+			writeJSON(w, http.StatusForbidden, map[string]string{"error": err.Error()})
 			return
 		}
-
-		client := &http.Client{
-			Timeout: 60 * time.Second,
-			Transport: &http.Transport{
-				DialContext: (&net.Dialer{
-					Timeout: 10 * time.Second,
-				}).DialContext,
-				TLSHandshakeTimeout: 10 * time.Second,
-			},
+		sess := sessions.SessionForRequest(w, r)
+		by, force := shared.requester(r, sess, "url"), parseTruthy(r.URL.Query().Get("force"))
+		if err := shared.check(sess, by, force); err != nil {
+			writeJSON(w, http.StatusConflict, map[string]string{"error": err.Error()})
+			return
 		}
-		resp, err := client.Get(raw)
+
+		resp, err := urlPolicy.client().Get(raw)
 		if err != nil {
 			writeJSON(w, http.StatusBadGateway, map[string]string{"error": fmt.Sprintf("failed to fetch URL: %v", err)})
 			return
@@ -909,6 +3163,15 @@ func main() {
 			writeJSON(w, http.StatusBadGateway, map[string]string{"error": fmt.Sprintf("URL returned status %d", resp.StatusCode)})
 			return
 		}
+		if err := urlPolicy.checkResponse(resp); err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+			return
+		}
+		body, err := urlPolicy.body(resp)
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+			return
+		}
 
 		label := raw
 		if parsed.Path != "" {
@@ -916,22 +3179,176 @@ func main() {
 				label = base
 			}
 		}
-		newDF, err := indexUploadedOrFetchedCSV(resp.Body, label, "esx-doctor-url-*.csv")
+		newDF, err := indexUploadedOrFetchedCSV(body, label, "esx-doctor-url-*.csv")
 		if err != nil {
 			writeJSON(w, http.StatusBadRequest, map[string]string{"error": fmt.Sprintf("invalid CSV from URL: %v", err)})
 			return
 		}
+		newDF.Provenance.Source, newDF.Provenance.Origin = "url", parsed.Redacted()
 
-		sessions.SessionForRequest(w, r).Replace(newDF)
+		if err := shared.replace(sess, newDF, by, force); err != nil {
+			_ = os.Remove(newDF.Path)
+			writeJSON(w, http.StatusConflict, map[string]string{"error": err.Error()})
+			return
+		}
 		writeJSON(w, http.StatusOK, map[string]any{
 			"file":  newDF.Label,
 			"rows":  newDF.Rows,
-			"start": newDF.StartTime.UnixMilli(),
-			"end":   newDF.EndTime.UnixMilli(),
+			"start": unixMilliOrZero(newDF.StartTime),
+			"end":   unixMilliOrZero(newDF.EndTime),
 		})
-	})
+	}))
+
+	mux.HandleFunc("/api/series/compare", scans.wrap(func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		sess := sessions.SessionForRequest(w, r)
+		labels := q["file"]
+		if len(labels) == 0 {
+			for _, f := range sess.Files() {
+				labels = append(labels, f.Label)
+			}
+		}
+		if len(labels) < 2 {
+			writeJSON(w, http.StatusBadRequest, CompareSeriesResponse{Error: "need at least two files to compare"})
+			return
+		}
+		files := make([]*DataFile, len(labels))
+		for i, label := range labels {
+			if files[i] = sess.File(label); files[i] == nil {
+				writeJSON(w, http.StatusNotFound, CompareSeriesResponse{Error: "no file labeled " + label})
+				return
+			}
+		}
+		colsParam := q["col"]
+		if len(colsParam) == 0 {
+			colsParam = strings.Split(q.Get("cols"), ",")
+		}
+		var cols []int
+		for _, raw := range colsParam {
+			if raw = strings.TrimSpace(raw); raw == "" {
+				continue
+			}
+			idx, err := strconv.Atoi(raw)
+			if err != nil {
+				writeJSON(w, http.StatusBadRequest, CompareSeriesResponse{Error: fmt.Sprintf("invalid column %q", raw)})
+				return
+			}
+			cols = append(cols, idx)
+		}
+		perFile, err := compareColumns(files, cols, strings.TrimSpace(q.Get("attr")), q["instance"])
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, CompareSeriesResponse{Error: err.Error()})
+			return
+		}
+		var relative bool
+		switch q.Get("align") {
+		case "", "absolute":
+		case "relative":
+			relative = true
+		default:
+			writeJSON(w, http.StatusBadRequest, CompareSeriesResponse{Error: "align must be absolute or relative"})
+			return
+		}
+		var from, to time.Duration
+		for _, p := range []struct {
+			key string
+			dst *time.Duration
+		}{{"from", &from}, {"to", &to}} {
+			if raw := strings.TrimSpace(q.Get(p.key)); raw != "" {
+				d, err := time.ParseDuration(raw)
+				if err != nil || d < 0 {
+					writeJSON(w, http.StatusBadRequest, CompareSeriesResponse{Error: fmt.Sprintf("invalid %s %q", p.key, raw)})
+					return
+				}
+				*p.dst = d
+			}
+		}
+		envelope, err := parseSeriesAgg(q.Get("agg"))
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, CompareSeriesResponse{Error: err.Error()})
+			return
+		}
+		resp, err := compareSeries(labels, files, perFile, relative, parseTimeQuery(r, "start"), parseTimeQuery(r, "end"), from, to, seriesMaxPoints(q, 0), envelope)
+		if err != nil {
+			resp.Error = err.Error()
+			writeJSON(w, http.StatusInternalServerError, resp)
+			return
+		}
+		writeJSON(w, http.StatusOK, resp)
+	}))
+
+	mux.HandleFunc("/api/stats", cache.wrap(sessions, scans.wrap(func(w http.ResponseWriter, r *http.Request) {
+		current := sessions.SessionForRequest(w, r).Get()
+		if current == nil {
+			writeJSON(w, http.StatusBadRequest, StatsResponse{Error: "no file loaded"})
+			return
+		}
+		q := r.URL.Query()
+		colsParam := q["col"]
+		if len(colsParam) == 0 {
+			colsParam = strings.Split(q.Get("cols"), ",")
+		}
+		var cols []int
+		for _, raw := range colsParam {
+			if raw = strings.TrimSpace(raw); raw == "" {
+				continue
+			}
+			idx, err := strconv.Atoi(raw)
+			if err != nil || idx <= 0 || idx >= len(current.Columns) {
+				writeJSON(w, http.StatusBadRequest, StatsResponse{Error: fmt.Sprintf("invalid column %q", raw)})
+				return
+			}
+			cols = append(cols, idx)
+		}
+		if attr := strings.TrimSpace(q.Get("attr")); attr != "" {
+			cols = append(cols, current.resolveColumnsByAttribute(attr, q["instance"])...)
+		}
+		if len(cols) == 0 {
+			writeJSON(w, http.StatusBadRequest, StatsResponse{Error: "no columns selected"})
+			return
+		}
+		if len(cols) > maxStatsColumns {
+			writeJSON(w, http.StatusBadRequest, StatsResponse{Error: fmt.Sprintf("too many columns (%d, max %d)", len(cols), maxStatsColumns)})
+			return
+		}
+		start := parseTimeQuery(r, "start")
+		end := parseTimeQuery(r, "end")
+		if name := strings.TrimSpace(q.Get("bookmark")); name != "" {
+			var err error
+			start, end, err = bookmarks.resolve(current, name)
+			if err != nil {
+				writeJSON(w, http.StatusBadRequest, StatsResponse{Error: err.Error()})
+				return
+			}
+		}
+		stats, err := computeColumnStats(current, cols, start, end)
+		if err != nil {
+			writeJSON(w, http.StatusInternalServerError, StatsResponse{Error: err.Error()})
+			return
+		}
+		writeJSON(w, http.StatusOK, StatsResponse{Start: unixMilliOrZero(start), End: unixMilliOrZero(end), Stats: stats})
+	})))
 
-	mux.HandleFunc("/api/series", func(w http.ResponseWriter, r *http.Request) {
+	mux.HandleFunc("/api/series", cache.wrap(sessions, scans.wrap(func(w http.ResponseWriter, r *http.Request) {
+		if name := strings.TrimSpace(r.URL.Query().Get("query")); name != "" {
+			q, ok := queries.get(name)
+			if !ok {
+				writeSeries(w, r, http.StatusNotFound, SeriesResponse{Error: "unknown query: " + name})
+				return
+			}
+			current := sessions.SessionForRequest(w, r).Get()
+			if current == nil {
+				writeSeries(w, r, http.StatusInternalServerError, SeriesResponse{Error: "no file loaded"})
+				return
+			}
+			resp, err := runSavedQuery(current, q, r.URL.Query(), bookmarks)
+			if err != nil {
+				writeSeries(w, r, http.StatusBadRequest, SeriesResponse{Error: err.Error()})
+				return
+			}
+			writeSeries(w, r, http.StatusOK, resp)
+			return
+		}
 		colsParam := r.URL.Query()["col"]
 		if len(colsParam) == 0 {
 			colsParam = strings.Split(r.URL.Query().Get("cols"), ",")
@@ -948,44 +3365,60 @@ func main() {
 			}
 			cols = append(cols, idx)
 		}
-		if len(cols) == 0 {
-			writeJSON(w, http.StatusBadRequest, SeriesResponse{Error: "no columns selected"})
+		current := sessions.SessionForRequest(w, r).Get()
+		if attr := strings.TrimSpace(r.URL.Query().Get("attr")); attr != "" && current != nil {
+			cols = append(cols, current.resolveColumnsByAttribute(attr, r.URL.Query()["instance"])...)
+		}
+		exprParams := r.URL.Query()["expr"]
+		if len(cols) == 0 && len(exprParams) == 0 {
+			writeSeries(w, r, http.StatusBadRequest, SeriesResponse{Error: "no columns selected"})
 			return
 		}
-		current := sessions.SessionForRequest(w, r).Get()
 		if current == nil {
-			writeJSON(w, http.StatusInternalServerError, SeriesResponse{Error: "no file loaded"})
+			writeSeries(w, r, http.StatusInternalServerError, SeriesResponse{Error: "no file loaded"})
 			return
 		}
-
-		parseTimeParam := func(key string) time.Time {
-			val := strings.TrimSpace(r.URL.Query().Get(key))
-			if val == "" {
-				return time.Time{}
-			}
-			if ms, err := strconv.ParseInt(val, 10, 64); err == nil {
-				return time.UnixMilli(ms).UTC()
+		if len(exprParams) > maxSeriesExprs {
+			writeSeries(w, r, http.StatusBadRequest, SeriesResponse{Error: fmt.Sprintf("too many expressions (max %d)", maxSeriesExprs)})
+			return
+		}
+		exprs := make([]*seriesExpr, 0, len(exprParams))
+		for _, raw := range exprParams {
+			e, err := parseSeriesExpr(current, raw)
+			if err != nil {
+				writeSeries(w, r, http.StatusBadRequest, SeriesResponse{Error: err.Error()})
+				return
 			}
-			t, _, _ := parseTimeValue(val)
-			return t
+			exprs = append(exprs, e)
 		}
 
-		start := parseTimeParam("start")
-		end := parseTimeParam("end")
-		maxPoints := 0
-		if mp := r.URL.Query().Get("maxPoints"); mp != "" {
-			if v, err := strconv.Atoi(mp); err == nil {
-				maxPoints = v
+		start := parseTimeQuery(r, "start")
+		end := parseTimeQuery(r, "end")
+		if name := strings.TrimSpace(r.URL.Query().Get("bookmark")); name != "" {
+			var err error
+			start, end, err = bookmarks.resolve(current, name)
+			if err != nil {
+				writeSeries(w, r, http.StatusBadRequest, SeriesResponse{Error: err.Error()})
+				return
 			}
 		}
-
-		resp, err := current.extractSeries(cols, start, end, maxPoints)
+		align, err := parseSeriesAlign(r.URL.Query().Get("align"))
 		if err != nil {
-			writeJSON(w, http.StatusInternalServerError, SeriesResponse{Error: err.Error()})
+			writeSeries(w, r, http.StatusBadRequest, SeriesResponse{Error: err.Error()})
 			return
 		}
-		writeJSON(w, http.StatusOK, resp)
-	})
+		envelope, err := parseSeriesAgg(r.URL.Query().Get("agg"))
+		if err != nil {
+			writeSeries(w, r, http.StatusBadRequest, SeriesResponse{Error: err.Error()})
+			return
+		}
+		resp, err := current.extractSeries(cols, exprs, start, end, seriesMaxPoints(r.URL.Query(), 0), align, envelope, newQueryTrace(r.URL.Query()))
+		if err != nil {
+			writeSeries(w, r, http.StatusInternalServerError, SeriesResponse{Error: err.Error()})
+			return
+		}
+		writeSeries(w, r, http.StatusOK, resp)
+	})))
 
 	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
 		if r.URL.Path != "/" {
@@ -1001,6 +3434,21 @@ func main() {
 		_, _ = w.Write(data)
 	})
 
+	// /l/<token> is the page itself; app.js restores the link's chart.
+	mux.HandleFunc("/l/", func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := links.get(strings.TrimPrefix(r.URL.Path, "/l/")); !ok {
+			http.Error(w, "link not found", http.StatusNotFound)
+			return
+		}
+		data, err := webFS.ReadFile("web/index.html")
+		if err != nil {
+			http.Error(w, "index not found", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		_, _ = w.Write(data)
+	})
+
 	mux.HandleFunc("/manual", func(w http.ResponseWriter, r *http.Request) {
 		data, err := webFS.ReadFile("web/manual.html")
 		if err != nil {
@@ -1021,6 +3469,16 @@ func main() {
 		_, _ = w.Write(data)
 	})
 
+	mux.HandleFunc("/fleet", func(w http.ResponseWriter, r *http.Request) {
+		data, err := webFS.ReadFile("web/fleet.html")
+		if err != nil {
+			http.Error(w, "fleet page not found", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		_, _ = w.Write(data)
+	})
+
 	mux.HandleFunc("/templates", func(w http.ResponseWriter, r *http.Request) {
 		data, err := webFS.ReadFile("web/templates.html")
 		if err != nil {
@@ -1082,12 +3540,64 @@ func main() {
 	})
 
 	addr := fmt.Sprintf(":%d", port)
+	var root http.Handler = mux
+	if serveGRPC {
+		root = grpcHandler(&grpcServer{api: mux, sessions: sessions, scans: scans}, mux)
+	}
+	if serviceMode {
+		reload := func() {
+			if err := templateStore.reload(); err != nil {
+				log.Printf("template store reload failed: %v", err)
+			}
+			if err := bookmarks.reload(); err != nil {
+				log.Printf("bookmark store reload failed: %v", err)
+			}
+			if err := queries.reload(); err != nil {
+				log.Printf("query store reload failed: %v", err)
+			}
+			if err := links.reload(); err != nil {
+				log.Printf("link store reload failed: %v", err)
+			}
+			if err := templatePrefs.reload(); err != nil {
+				log.Printf("template preferences reload failed: %v", err)
+			}
+			if err := history.reload(); err != nil {
+				log.Printf("run history reload failed: %v", err)
+			}
+			if err := messages.reload(); err != nil {
+				log.Printf("message pack reload failed: %v", err)
+			}
+			if fleet != nil {
+				if err := fleet.scan(); err != nil {
+					log.Printf("fleet rescan failed: %v", err)
+				}
+			}
+			if watch != nil {
+				if err := watch.scan(); err != nil {
+					log.Printf("watch scan failed: %v", err)
+				}
+			}
+			if sessions.defaults != nil {
+				if err := sessions.defaults.reload(); err != nil {
+					log.Printf("default file assignments reload failed: %v", err)
+				}
+			}
+		}
+		if err := runService(root, addr, pidFile, srvOpts, reload); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
 	log.Printf("esx-doctor listening on %s", addr)
 	log.Printf("open: http://localhost:%d", port)
 	if current := df; current != nil {
 		log.Printf("file: %s", current.Label)
 	}
-	if err := http.ListenAndServe(addr, mux); err != nil {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := srvOpts.newServer(root).Serve(srvOpts.listener(ln)); err != nil {
 		log.Fatal(err)
 	}
 }
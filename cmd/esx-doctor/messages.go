@@ -0,0 +1,262 @@
+package main
+
+import (
+	"embed"
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// FindingMessage is a finding summary before rendering: a catalog key and
+// the values its placeholders refer to. Findings keep it so their summary
+// can be rendered again from another message pack.
+type FindingMessage struct {
+	Key    string         `json:"key"`
+	Params map[string]any `json:"params,omitempty"`
+}
+
+// newMessage builds a message from key and name/value pairs. A value may
+// itself be a *FindingMessage (a sentence fragment that also needs
+// translating) or a []*FindingMessage, rendered and joined with "; ".
+func newMessage(key string, kv ...any) *FindingMessage {
+	m := &FindingMessage{Key: key}
+	if len(kv) > 0 {
+		m.Params = make(map[string]any, len(kv)/2)
+	}
+	for i := 0; i+1 < len(kv); i += 2 {
+		m.Params[fmt.Sprint(kv[i])] = kv[i+1]
+	}
+	return m
+}
+
+// String renders m with the default pack.
+func (m *FindingMessage) String() string {
+	return messages.render("", m)
+}
+
+// messagePlaceholder matches ${name} and ${name:%.1f}; the optional verb
+// formats numbers, so packs choose their own precision.
+var messagePlaceholder = regexp.MustCompile(`\$\{([A-Za-z0-9_.]+)(?::(%[^}]*))?\}`)
+
+// messageCatalog holds the built-in English texts and any packs loaded from
+// -messages. A pack is a flat JSON object of key to text named after its
+// language (de.json); keys it leaves out fall back to English, so a pack
+// can also just adjust terminology (an en.json overriding a few keys).
+type messageCatalog struct {
+	mu          sync.RWMutex
+	dir         string
+	defaultLang string
+	builtin     map[string]string
+	packs       map[string]map[string]string
+}
+
+// messages renders finding summaries. It starts with the built-in texts
+// only, which is what the CLI subcommands use; main replaces it once
+// -messages and -lang are parsed.
+var messages = mustBuiltinMessages()
+
+func mustBuiltinMessages() *messageCatalog {
+	c, err := loadMessageCatalog(webFS, "", "en")
+	if err != nil {
+		panic(err)
+	}
+	return c
+}
+
+func defaultMessageDir() string {
+	home, err := os.UserHomeDir()
+	if err != nil || strings.TrimSpace(home) == "" {
+		return ""
+	}
+	return filepath.Join(home, ".esx-doctor", "messages")
+}
+
+func loadMessageCatalog(fs embed.FS, dir, lang string) (*messageCatalog, error) {
+	data, err := fs.ReadFile("messages/en.json")
+	if err != nil {
+		return nil, err
+	}
+	c := &messageCatalog{dir: strings.TrimSpace(dir), defaultLang: normalizeLang(lang)}
+	if err := json.Unmarshal(data, &c.builtin); err != nil {
+		return nil, fmt.Errorf("built-in messages: %w", err)
+	}
+	if err := c.load(); err != nil {
+		return nil, err
+	}
+	if _, ok := c.packs[c.defaultLang]; !ok && c.defaultLang != "en" {
+		return nil, fmt.Errorf("no message pack for language %q in %s", c.defaultLang, c.dir)
+	}
+	return c, nil
+}
+
+func normalizeLang(lang string) string {
+	lang = strings.ToLower(strings.TrimSpace(lang))
+	if lang == "" {
+		return "en"
+	}
+	return lang
+}
+
+func (c *messageCatalog) load() error {
+	packs := map[string]map[string]string{}
+	if c.dir != "" {
+		paths, err := filepath.Glob(filepath.Join(c.dir, "*.json"))
+		if err != nil {
+			return err
+		}
+		for _, p := range paths {
+			data, err := os.ReadFile(p)
+			if err != nil {
+				return err
+			}
+			pack := map[string]string{}
+			if err := json.Unmarshal(data, &pack); err != nil {
+				return fmt.Errorf("message pack %s: %w", filepath.Base(p), err)
+			}
+			for key := range pack {
+				if _, ok := c.builtin[key]; !ok {
+					return fmt.Errorf("message pack %s: unknown key %q", filepath.Base(p), key)
+				}
+			}
+			packs[normalizeLang(strings.TrimSuffix(filepath.Base(p), ".json"))] = pack
+		}
+	}
+	c.mu.Lock()
+	c.packs = packs
+	c.mu.Unlock()
+	return nil
+}
+
+// reload re-reads the packs from disk, keeping the old ones on error.
+func (c *messageCatalog) reload() error {
+	return c.load()
+}
+
+// languages lists the languages summaries can be rendered in.
+func (c *messageCatalog) languages() []string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	out := []string{"en"}
+	for lang := range c.packs {
+		if lang != "en" {
+			out = append(out, lang)
+		}
+	}
+	sort.Strings(out[1:])
+	return out
+}
+
+func (c *messageCatalog) has(lang string) bool {
+	lang = normalizeLang(lang)
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	_, ok := c.packs[lang]
+	return ok || lang == "en"
+}
+
+// texts returns every key as lang renders it.
+func (c *messageCatalog) texts(lang string) map[string]string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	out := make(map[string]string, len(c.builtin))
+	for k, v := range c.builtin {
+		out[k] = v
+	}
+	for k, v := range c.packs[normalizeLang(lang)] {
+		out[k] = v
+	}
+	return out
+}
+
+func (c *messageCatalog) text(lang, key string) string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	if lang == "" {
+		lang = c.defaultLang
+	}
+	if t, ok := c.packs[lang][key]; ok {
+		return t
+	}
+	if t, ok := c.builtin[key]; ok {
+		return t
+	}
+	return key
+}
+
+// render fills m's text for lang (the default pack when empty).
+// Placeholders without a value are left as written.
+func (c *messageCatalog) render(lang string, m *FindingMessage) string {
+	if m == nil {
+		return ""
+	}
+	lang = strings.ToLower(strings.TrimSpace(lang))
+	return messagePlaceholder.ReplaceAllStringFunc(c.text(lang, m.Key), func(ph string) string {
+		sub := messagePlaceholder.FindStringSubmatch(ph)
+		v, ok := m.Params[sub[1]]
+		if !ok {
+			return ph
+		}
+		return c.formatParam(lang, v, sub[2])
+	})
+}
+
+func (c *messageCatalog) formatParam(lang string, v any, verb string) string {
+	switch t := v.(type) {
+	case *FindingMessage:
+		return c.render(lang, t)
+	case []*FindingMessage:
+		parts := make([]string, 0, len(t))
+		for _, m := range t {
+			parts = append(parts, c.render(lang, m))
+		}
+		return strings.Join(parts, "; ")
+	case map[string]any:
+		// A nested message after a JSON round trip.
+		if m, ok := messageFromJSON(t); ok {
+			return c.render(lang, m)
+		}
+	case []any:
+		parts := make([]string, 0, len(t))
+		for _, item := range t {
+			parts = append(parts, c.formatParam(lang, item, verb))
+		}
+		return strings.Join(parts, "; ")
+	case float64:
+		// JSON turns every number into float64; %d still has to work.
+		if strings.HasSuffix(verb, "d") && t == math.Trunc(t) {
+			return fmt.Sprintf(verb, int64(t))
+		}
+	}
+	if verb == "" {
+		return fmt.Sprint(v)
+	}
+	return fmt.Sprintf(verb, v)
+}
+
+func messageFromJSON(v map[string]any) (*FindingMessage, bool) {
+	key, ok := v["key"].(string)
+	if !ok {
+		return nil, false
+	}
+	m := &FindingMessage{Key: key}
+	if params, ok := v["params"].(map[string]any); ok {
+		m.Params = params
+	}
+	return m, true
+}
+
+// localizeFindings re-renders the summaries of findings that carry a
+// message in lang. Plugin findings without one keep their text.
+func localizeFindings(findings []DiagnosticFinding, lang string) {
+	for i := range findings {
+		if findings[i].Message != nil {
+			findings[i].Summary = messages.render(lang, findings[i].Message)
+		}
+	}
+}
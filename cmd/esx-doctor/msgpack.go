@@ -0,0 +1,236 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+	"math"
+	"mime"
+	"net/http"
+	"strings"
+)
+
+const msgpackContentType = "application/msgpack"
+
+// msgpackWriter is a minimal MessagePack encoder covering the value kinds the
+// series endpoints emit (maps, arrays, strings, integers and float64).
+type msgpackWriter struct {
+	w   *bufio.Writer
+	buf [9]byte
+}
+
+func newMsgpackWriter(w io.Writer) *msgpackWriter {
+	return &msgpackWriter{w: bufio.NewWriterSize(w, 64*1024)}
+}
+
+func (m *msgpackWriter) header(fix byte, fixMax int, n int, c16, c32 byte) {
+	switch {
+	case n <= fixMax:
+		_ = m.w.WriteByte(fix | byte(n))
+	case n <= math.MaxUint16:
+		m.buf[0] = c16
+		binary.BigEndian.PutUint16(m.buf[1:], uint16(n))
+		_, _ = m.w.Write(m.buf[:3])
+	default:
+		m.buf[0] = c32
+		binary.BigEndian.PutUint32(m.buf[1:], uint32(n))
+		_, _ = m.w.Write(m.buf[:5])
+	}
+}
+
+func (m *msgpackWriter) mapHeader(n int) {
+	m.header(0x80, 15, n, 0xde, 0xdf)
+}
+
+func (m *msgpackWriter) arrayHeader(n int) {
+	m.header(0x90, 15, n, 0xdc, 0xdd)
+}
+
+func (m *msgpackWriter) str(s string) {
+	n := len(s)
+	switch {
+	case n <= 31:
+		_ = m.w.WriteByte(0xa0 | byte(n))
+	case n <= math.MaxUint8:
+		_ = m.w.WriteByte(0xd9)
+		_ = m.w.WriteByte(byte(n))
+	case n <= math.MaxUint16:
+		m.buf[0] = 0xda
+		binary.BigEndian.PutUint16(m.buf[1:], uint16(n))
+		_, _ = m.w.Write(m.buf[:3])
+	default:
+		m.buf[0] = 0xdb
+		binary.BigEndian.PutUint32(m.buf[1:], uint32(n))
+		_, _ = m.w.Write(m.buf[:5])
+	}
+	_, _ = m.w.WriteString(s)
+}
+
+func (m *msgpackWriter) int(v int64) {
+	if v >= 0 && v <= 127 {
+		_ = m.w.WriteByte(byte(v))
+		return
+	}
+	if v < 0 && v >= -32 {
+		_ = m.w.WriteByte(byte(int8(v)))
+		return
+	}
+	m.buf[0] = 0xd3
+	binary.BigEndian.PutUint64(m.buf[1:], uint64(v))
+	_, _ = m.w.Write(m.buf[:9])
+}
+
+func (m *msgpackWriter) float(v float64) {
+	m.buf[0] = 0xcb
+	binary.BigEndian.PutUint64(m.buf[1:], math.Float64bits(v))
+	_, _ = m.w.Write(m.buf[:9])
+}
+
+func (m *msgpackWriter) floats(vs []float64) {
+	m.arrayHeader(len(vs))
+	for _, v := range vs {
+		m.float(v)
+	}
+}
+
+func (m *msgpackWriter) flush() error {
+	return m.w.Flush()
+}
+
+// wantsMsgpack reports whether the client asked for MessagePack, either via
+// ?format=msgpack or an Accept header listing a MessagePack media type.
+func wantsMsgpack(r *http.Request) bool {
+	if f := strings.TrimSpace(strings.ToLower(r.URL.Query().Get("format"))); f != "" {
+		return f == "msgpack"
+	}
+	for _, part := range strings.Split(r.Header.Get("Accept"), ",") {
+		mt, _, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+		switch mt {
+		case "application/msgpack", "application/x-msgpack", "application/vnd.msgpack":
+			return true
+		}
+	}
+	return false
+}
+
+func writeSeriesMsgpack(w http.ResponseWriter, status int, resp SeriesResponse) {
+	w.Header().Set("Content-Type", msgpackContentType)
+	w.Header().Set("Vary", "Accept")
+	w.WriteHeader(status)
+	m := newMsgpackWriter(w)
+	fields := 6
+	if resp.MaxPoints > 0 {
+		fields++
+	}
+	if resp.Bucket > 0 {
+		fields++
+	}
+	if resp.Agg != "" {
+		fields++
+	}
+	if resp.Trace != nil {
+		fields++
+	}
+	if resp.Error != "" {
+		fields++
+	}
+	m.mapHeader(fields)
+	m.str("times")
+	m.arrayHeader(len(resp.Times))
+	for _, t := range resp.Times {
+		m.int(t)
+	}
+	m.str("series")
+	m.arrayHeader(len(resp.Series))
+	for _, s := range resp.Series {
+		bands := len(s.Min) > 0
+		fields := 2
+		if bands {
+			fields += 2
+		}
+		if s.Quality != nil {
+			fields++
+		}
+		m.mapHeader(fields)
+		m.str("name")
+		m.str(s.Name)
+		m.str("values")
+		m.floats(s.Values)
+		if bands {
+			m.str("min")
+			m.floats(s.Min)
+			m.str("max")
+			m.floats(s.Max)
+		}
+		if q := s.Quality; q != nil {
+			m.str("quality")
+			m.mapHeader(5)
+			m.str("rows")
+			m.int(q.Rows)
+			m.str("varianceRetained")
+			m.float(q.VarianceRetained)
+			m.str("clipped")
+			m.int(int64(q.Clipped))
+			m.str("min")
+			m.float(q.Min)
+			m.str("max")
+			m.float(q.Max)
+		}
+	}
+	m.str("start")
+	m.int(resp.Start)
+	m.str("end")
+	m.int(resp.End)
+	m.str("rows")
+	m.int(resp.Rows)
+	m.str("step")
+	m.int(resp.Step)
+	if resp.MaxPoints > 0 {
+		m.str("maxPoints")
+		m.int(int64(resp.MaxPoints))
+	}
+	if resp.Bucket > 0 {
+		m.str("bucket")
+		m.int(resp.Bucket)
+	}
+	if resp.Agg != "" {
+		m.str("agg")
+		m.str(resp.Agg)
+	}
+	if t := resp.Trace; t != nil {
+		m.str("trace")
+		m.mapHeader(9)
+		for _, f := range []struct {
+			name string
+			v    float64
+		}{{"seekMs", t.SeekMs}, {"readMs", t.ReadMs}, {"parseMs", t.ParseMs}, {"aggregateMs", t.AggregateMs}, {"totalMs", t.TotalMs}} {
+			m.str(f.name)
+			m.float(f.v)
+		}
+		m.str("startOffset")
+		m.int(t.StartOffset)
+		m.str("bytesRead")
+		m.int(t.BytesRead)
+		m.str("rowsRead")
+		m.int(t.RowsRead)
+		m.str("rowsSkipped")
+		m.int(t.RowsSkipped)
+	}
+	if resp.Error != "" {
+		m.str("error")
+		m.str(resp.Error)
+	}
+	_ = m.flush()
+}
+
+// writeSeries encodes resp as MessagePack or JSON depending on the request.
+func writeSeries(w http.ResponseWriter, r *http.Request, status int, resp SeriesResponse) {
+	if wantsMsgpack(r) {
+		writeSeriesMsgpack(w, status, resp)
+		return
+	}
+	writeJSON(w, status, resp)
+}
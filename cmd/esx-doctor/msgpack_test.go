@@ -0,0 +1,236 @@
+package main
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// msgpackTestDecode decodes one value the way encoding/json decodes into
+// an any, so a MessagePack response compares equal to the JSON one.
+func msgpackTestDecode(b []byte) (any, []byte, error) {
+	if len(b) == 0 {
+		return nil, nil, fmt.Errorf("unexpected end of input")
+	}
+	c, b := b[0], b[1:]
+	need := func(n int) error {
+		if len(b) < n {
+			return fmt.Errorf("need %d bytes after %#x, have %d", n, c, len(b))
+		}
+		return nil
+	}
+	length := func(size int) (int, error) {
+		if err := need(size); err != nil {
+			return 0, err
+		}
+		var n int
+		switch size {
+		case 1:
+			n = int(b[0])
+		case 2:
+			n = int(binary.BigEndian.Uint16(b))
+		default:
+			n = int(binary.BigEndian.Uint32(b))
+		}
+		b = b[size:]
+		return n, nil
+	}
+	var n int
+	var err error
+	switch {
+	case c <= 0x7f:
+		return float64(c), b, nil
+	case c >= 0xe0:
+		return float64(int8(c)), b, nil
+	case c == 0xd3:
+		if err := need(8); err != nil {
+			return nil, nil, err
+		}
+		return float64(int64(binary.BigEndian.Uint64(b))), b[8:], nil
+	case c == 0xcb:
+		if err := need(8); err != nil {
+			return nil, nil, err
+		}
+		return math.Float64frombits(binary.BigEndian.Uint64(b)), b[8:], nil
+	case c&0xe0 == 0xa0, c == 0xd9, c == 0xda, c == 0xdb:
+		switch c {
+		case 0xd9:
+			n, err = length(1)
+		case 0xda:
+			n, err = length(2)
+		case 0xdb:
+			n, err = length(4)
+		default:
+			n = int(c & 0x1f)
+		}
+		if err == nil {
+			err = need(n)
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		return string(b[:n]), b[n:], nil
+	case c&0xf0 == 0x90, c == 0xdc, c == 0xdd:
+		switch c {
+		case 0xdc:
+			n, err = length(2)
+		case 0xdd:
+			n, err = length(4)
+		default:
+			n = int(c & 0x0f)
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		out := make([]any, n)
+		for i := range out {
+			if out[i], b, err = msgpackTestDecode(b); err != nil {
+				return nil, nil, err
+			}
+		}
+		return out, b, nil
+	case c&0xf0 == 0x80, c == 0xde, c == 0xdf:
+		switch c {
+		case 0xde:
+			n, err = length(2)
+		case 0xdf:
+			n, err = length(4)
+		default:
+			n = int(c & 0x0f)
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		out := make(map[string]any, n)
+		for i := 0; i < n; i++ {
+			var k, v any
+			if k, b, err = msgpackTestDecode(b); err != nil {
+				return nil, nil, err
+			}
+			key, ok := k.(string)
+			if !ok {
+				return nil, nil, fmt.Errorf("map key %v is not a string", k)
+			}
+			if _, dup := out[key]; dup {
+				return nil, nil, fmt.Errorf("duplicate key %q", key)
+			}
+			if v, b, err = msgpackTestDecode(b); err != nil {
+				return nil, nil, err
+			}
+			out[key] = v
+		}
+		return out, b, nil
+	}
+	return nil, nil, fmt.Errorf("unexpected type byte %#x", c)
+}
+
+// TestSeriesMsgpackMatchesJSON checks that every field, optional ones
+// included, comes out of writeSeriesMsgpack as it does from the JSON
+// encoding, and that the map headers count exactly the keys written.
+func TestSeriesMsgpackMatchesJSON(t *testing.T) {
+	many := make([]float64, 40)
+	times := make([]int64, 40)
+	for i := range many {
+		many[i] = float64(i) * 1.25
+		times[i] = 1704067200000 + int64(i)*5000
+	}
+	tests := []struct {
+		name string
+		resp SeriesResponse
+	}{
+		{"empty", SeriesResponse{Times: []int64{}, Series: []SeriesPayload{}}},
+		{"error", SeriesResponse{Times: []int64{}, Series: []SeriesPayload{}, Error: "no columns selected"}},
+		{"plain", SeriesResponse{
+			Times:  []int64{1704067200000, 1704067205000},
+			Series: []SeriesPayload{{Name: `\\esx01\Group Cpu(1:vm-a)\% Ready`, Values: []float64{1.5, -2}}},
+			Start:  1704067200000, End: 1704067205000, Rows: 2, Step: 1,
+		}},
+		{"everything", SeriesResponse{
+			Times: times,
+			Series: []SeriesPayload{
+				{
+					Name: strings.Repeat("x", 300), Values: many, Min: many, Max: many,
+					Quality: &SeriesQuality{Rows: 400, VarianceRetained: 0.98, Clipped: 3, Min: -1, Max: 50},
+				},
+				{Name: "plain", Values: many, Quality: &SeriesQuality{Rows: 400, VarianceRetained: 1}},
+				{Name: "bands", Values: []float64{1}, Min: []float64{0}, Max: []float64{2}},
+			},
+			Start: 1704067200000, End: 1704067395000, Rows: 400, Step: 10,
+			MaxPoints: 40, Bucket: 50000, Agg: "envelope",
+			Trace: &QueryTrace{SeekMs: 0.5, ReadMs: 1.25, ParseMs: 2, AggregateMs: 0.125, TotalMs: 4,
+				StartOffset: -1, BytesRead: 1 << 40, RowsRead: 400, RowsSkipped: 7},
+			Error: "partial",
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			writeSeriesMsgpack(rec, 200, tt.resp)
+			got, rest, err := msgpackTestDecode(rec.Body.Bytes())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(rest) != 0 {
+				t.Fatalf("%d bytes after the response map", len(rest))
+			}
+			data, err := json.Marshal(tt.resp)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var want any
+			if err := json.Unmarshal(data, &want); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("msgpack decodes to\n%v\nJSON to\n%v", got, want)
+			}
+		})
+	}
+}
+
+func TestMsgpackWriterEncoding(t *testing.T) {
+	tests := []struct {
+		name string
+		enc  func(*msgpackWriter)
+		want []byte
+	}{
+		{"fixint", func(m *msgpackWriter) { m.int(127) }, []byte{0x7f}},
+		{"negative fixint", func(m *msgpackWriter) { m.int(-32) }, []byte{0xe0}},
+		{"int64", func(m *msgpackWriter) { m.int(128) }, []byte{0xd3, 0, 0, 0, 0, 0, 0, 0, 0x80}},
+		{"int64 negative", func(m *msgpackWriter) { m.int(-33) }, []byte{0xd3, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xdf}},
+		{"float64", func(m *msgpackWriter) { m.float(1.5) }, []byte{0xcb, 0x3f, 0xf8, 0, 0, 0, 0, 0, 0}},
+		{"fixstr", func(m *msgpackWriter) { m.str("ab") }, []byte{0xa2, 'a', 'b'}},
+		{"fixmap", func(m *msgpackWriter) { m.mapHeader(15) }, []byte{0x8f}},
+		{"map16", func(m *msgpackWriter) { m.mapHeader(16) }, []byte{0xde, 0, 16}},
+		{"fixarray", func(m *msgpackWriter) { m.arrayHeader(0) }, []byte{0x90}},
+		{"array32", func(m *msgpackWriter) { m.arrayHeader(1 << 16) }, []byte{0xdd, 0, 1, 0, 0}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			m := newMsgpackWriter(rec)
+			tt.enc(m)
+			if err := m.flush(); err != nil {
+				t.Fatal(err)
+			}
+			if got := rec.Body.Bytes(); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got % x, want % x", got, tt.want)
+			}
+		})
+	}
+	for _, n := range []int{31, 32, 255, 256, 65536} {
+		rec := httptest.NewRecorder()
+		m := newMsgpackWriter(rec)
+		m.str(strings.Repeat("s", n))
+		_ = m.flush()
+		got, rest, err := msgpackTestDecode(rec.Body.Bytes())
+		if err != nil || len(rest) != 0 || got != strings.Repeat("s", n) {
+			t.Errorf("string of %d bytes does not round-trip (%v, %d bytes left)", n, err, len(rest))
+		}
+	}
+}
@@ -0,0 +1,243 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// -shared is for one instance used by a team, where a session ID is passed
+// around (attach, X-ESX-Session-ID) and several people can end up looking
+// at the same session. Every file load records who did it; /api/meta shows
+// the owner, a viewer can take a soft lock so nobody else swaps the file
+// out from under them without ?force=1, and /api/loads is the feed of who
+// loaded what.
+
+// maxLoadRecords bounds the activity feed.
+const maxLoadRecords = 500
+
+// FileOwner identifies whoever loaded a file: the proxy-asserted user
+// when -user-header is set, otherwise only the client address.
+type FileOwner struct {
+	User    string `json:"user,omitempty"`
+	Client  string `json:"client"`
+	Session string `json:"session"` // first characters of the session ID
+	Source  string `json:"source,omitempty"`
+	Loaded  int64  `json:"loaded,omitempty"`
+}
+
+// same reports whether o and p are the same person. The user name decides
+// when either side has one; addresses only tell anonymous clients apart.
+func (o FileOwner) same(p FileOwner) bool {
+	if o.User != "" || p.User != "" {
+		return strings.EqualFold(o.User, p.User)
+	}
+	return o.Client == p.Client
+}
+
+func (o FileOwner) String() string {
+	if o.User != "" {
+		return o.User
+	}
+	return o.Client
+}
+
+// FileLock is a soft lock on a session's open file. It is cleared when the
+// file is replaced.
+type FileLock struct {
+	By    FileOwner `json:"by"`
+	Since int64     `json:"since"`
+	Note  string    `json:"note,omitempty"`
+}
+
+// FileHandoff is kept when someone other than the file's owner replaced
+// it, so the previous viewer can be told what happened.
+type FileHandoff struct {
+	From FileOwner `json:"from"`
+	File string    `json:"file"`
+}
+
+// LoadRecord is one entry of /api/loads and the data of a "load" event.
+type LoadRecord struct {
+	Time     int64      `json:"time"`
+	File     string     `json:"file"`
+	Rows     int64      `json:"rows"`
+	By       FileOwner  `json:"by"`
+	Replaced string     `json:"replaced,omitempty"`
+	Previous *FileOwner `json:"previous,omitempty"`
+	Forced   bool       `json:"forced,omitempty"`
+}
+
+// fileLockedError is returned when a replacement runs into another
+// person's lock.
+type fileLockedError struct {
+	lock FileLock
+}
+
+func (e *fileLockedError) Error() string {
+	msg := fmt.Sprintf("file is locked by %s", e.lock.By)
+	if e.lock.Note != "" {
+		msg += " (" + e.lock.Note + ")"
+	}
+	return msg + "; retry with force=1 to replace it anyway"
+}
+
+type sharedFiles struct {
+	mu         sync.Mutex
+	userHeader string
+	loads      []LoadRecord // oldest first
+	events     *eventLog
+}
+
+func newSharedFiles(userHeader string, events *eventLog) *sharedFiles {
+	return &sharedFiles{userHeader: strings.TrimSpace(userHeader), events: events}
+}
+
+// requester describes who is behind r. Clients behind one proxy share an
+// address, so -user-header is what makes ownership meaningful there.
+func (s *sharedFiles) requester(r *http.Request, sess *Session, source string) FileOwner {
+	o := FileOwner{Source: source, Session: sess.shortID()}
+	if s != nil && s.userHeader != "" {
+		o.User = strings.TrimSpace(r.Header.Get(s.userHeader))
+	}
+	o.Client = r.RemoteAddr
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		o.Client = host
+	}
+	return o
+}
+
+// check reports a lock that would stop by from replacing the session's
+// file. Uploads call it before queueing so a locked session fails fast.
+func (s *sharedFiles) check(sess *Session, by FileOwner, force bool) error {
+	if s == nil || force {
+		return nil
+	}
+	sess.mu.RLock()
+	defer sess.mu.RUnlock()
+	if sess.lock != nil && !sess.lock.By.same(by) {
+		return &fileLockedError{lock: *sess.lock}
+	}
+	return nil
+}
+
+// replace opens df in the session on behalf of by. Without -shared it is
+// Session.Replace.
+func (s *sharedFiles) replace(sess *Session, df *DataFile, by FileOwner, force bool) error {
+	if s == nil {
+		sess.Replace(df)
+		return nil
+	}
+	by.Loaded = time.Now().UnixMilli()
+	rec := LoadRecord{Time: by.Loaded, File: df.Label, Rows: df.Rows, By: by}
+
+	sess.mu.Lock()
+	if sess.lock != nil && !sess.lock.By.same(by) {
+		if !force {
+			lock := *sess.lock
+			sess.mu.Unlock()
+			return &fileLockedError{lock: lock}
+		}
+		rec.Forced = true
+	}
+	if sess.df != nil {
+		rec.Replaced = sess.df.Label
+	}
+	sess.handoff = nil
+	if sess.owner != nil && !sess.owner.same(by) {
+		prev := *sess.owner
+		rec.Previous = &prev
+		if sess.df != nil {
+			sess.handoff = &FileHandoff{From: prev, File: sess.df.Label}
+		}
+	}
+	owner := by
+	sess.owner = &owner
+	sess.lock = nil
+	old := sess.df
+	sess.df = df
+	sess.generation++
+	sess.releaseLocked(old)
+	sess.mu.Unlock()
+
+	s.mu.Lock()
+	s.loads = append(s.loads, rec)
+	if n := len(s.loads) - maxLoadRecords; n > 0 {
+		s.loads = append(s.loads[:0], s.loads[n:]...)
+	}
+	s.mu.Unlock()
+	s.events.publish(nil, "load", rec)
+	return nil
+}
+
+// setLock takes (locked) or releases the session's soft lock. Releasing
+// another person's lock needs force.
+func (s *sharedFiles) setLock(sess *Session, by FileOwner, locked bool, note string, force bool) (*FileLock, error) {
+	sess.mu.Lock()
+	defer sess.mu.Unlock()
+	if sess.df == nil {
+		return nil, fmt.Errorf("no file loaded")
+	}
+	if sess.lock != nil && !sess.lock.By.same(by) && !force {
+		return nil, &fileLockedError{lock: *sess.lock}
+	}
+	if !locked {
+		sess.lock = nil
+		return nil, nil
+	}
+	by.Source, by.Loaded = "", 0
+	sess.lock = &FileLock{By: by, Since: time.Now().UnixMilli(), Note: strings.TrimSpace(note)}
+	lock := *sess.lock
+	return &lock, nil
+}
+
+// ownership is the /api/meta view of the session's file for viewer.
+func (s *sharedFiles) ownership(sess *Session, viewer FileOwner) map[string]any {
+	sess.mu.RLock()
+	defer sess.mu.RUnlock()
+	out := map[string]any{"ownedByYou": sess.owner == nil || sess.owner.same(viewer)}
+	if sess.owner != nil {
+		out["owner"] = *sess.owner
+	}
+	if sess.lock != nil {
+		out["lock"] = *sess.lock
+		out["lockedByYou"] = sess.lock.By.same(viewer)
+	}
+	if sess.handoff != nil {
+		out["replacedFrom"] = *sess.handoff
+	}
+	return out
+}
+
+// recent returns up to limit loads, newest first, optionally only those
+// by user.
+func (s *sharedFiles) recent(limit int, user string) []LoadRecord {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	out := []LoadRecord{}
+	for i := len(s.loads) - 1; i >= 0 && len(out) < limit; i-- {
+		if user != "" && !strings.EqualFold(s.loads[i].By.User, user) {
+			continue
+		}
+		out = append(out, s.loads[i])
+	}
+	return out
+}
+
+// shortID is enough of the session ID to tell sessions apart in the feed
+// without handing out the ID itself, which grants access to the session.
+func (s *Session) shortID() string {
+	if len(s.id) > 8 {
+		return s.id[:8]
+	}
+	return s.id
+}
+
+func isFileLocked(err error) bool {
+	var locked *fileLockedError
+	return errors.As(err, &locked)
+}
@@ -0,0 +1,616 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Captures export to Parquet as one wide table: a required "time" column
+// (UTC timestamp in milliseconds) followed by one optional column per
+// counter, DOUBLE when every cell in range is numeric and UTF-8 strings
+// otherwise (latency sensitivity levels, power policies). Empty cells are
+// nulls. Column names drop the \\host\ prefix when the capture has a single
+// host, and the file's key-value metadata carries the parsed host, object,
+// instance and counter of each column under "esx-doctor.columns", so pandas
+// or DuckDB never re-parse PDH headers.
+//
+// The writer needs nothing beyond the standard library: pages are PLAIN
+// encoded and uncompressed, and the footer is hand-encoded Thrift compact
+// protocol.
+
+// parquetGroupCells bounds how many cells a row group buffers, so very
+// wide captures get shorter row groups rather than more memory.
+const parquetGroupCells = 4 << 20
+
+// Parquet enum values used below (parquet.thrift).
+const (
+	parquetInt64     = 2
+	parquetDouble    = 5
+	parquetByteArray = 6
+
+	parquetRequired = 0
+	parquetOptional = 1
+
+	parquetUTF8            = 0
+	parquetTimestampMillis = 9
+
+	parquetPlain = 0
+	parquetRLE   = 3
+)
+
+// ParquetColumn describes one exported column in the file metadata.
+type ParquetColumn struct {
+	Name     string `json:"name"`
+	Column   int    `json:"column"`
+	Header   string `json:"header"`
+	Host     string `json:"host,omitempty"`
+	Object   string `json:"object"`
+	Instance string `json:"instance"`
+	Counter  string `json:"counter"`
+	Type     string `json:"type"` // double or string
+}
+
+// parquetFilename names an export like exportFilename, with the .parquet
+// extension.
+func parquetFilename(df *DataFile, start, end time.Time) string {
+	return strings.TrimSuffix(exportFilename(df, start, end), ".csv") + ".parquet"
+}
+
+// eachRangeRow calls fn for every row of df within [start, end], stopping
+// once ctx is done, like the loops of writeSlice and writeInflux.
+func eachRangeRow(ctx context.Context, df *DataFile, start, end time.Time, fn func(ts time.Time, record []string) error) error {
+	startOffset, _ := df.findOffset(start)
+	f, data, err := df.openData(startOffset)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	lines := getLineReader(data)
+	defer lines.release()
+	var seen int64
+	for {
+		line, err := lines.next()
+		if err != nil && !errors.Is(err, io.EOF) {
+			return err
+		}
+		if len(line) == 0 && errors.Is(err, io.EOF) {
+			return nil
+		}
+		if seen++; seen%exportCheckRows == 0 {
+			if cerr := ctx.Err(); cerr != nil {
+				return cerr
+			}
+		}
+		record, perr := lines.record(line)
+		if perr == nil && len(record) > 0 {
+			ts, _, terr := parseTimeValue(record[0])
+			if terr == nil {
+				if !end.IsZero() && ts.After(end) {
+					return nil
+				}
+				if start.IsZero() || !ts.Before(start) {
+					if ferr := fn(ts, record); ferr != nil {
+						return ferr
+					}
+				}
+			}
+		}
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+	}
+}
+
+// parquetColumns names and types cols. Typing takes a pass over the range:
+// a column is DOUBLE unless some non-empty cell fails to parse as a number.
+func parquetColumns(ctx context.Context, df *DataFile, cols []int, start, end time.Time) ([]ParquetColumn, error) {
+	text := make([]bool, len(cols))
+	err := eachRangeRow(ctx, df, start, end, func(_ time.Time, record []string) error {
+		for i, idx := range cols {
+			if text[i] || idx >= len(record) {
+				continue
+			}
+			raw := strings.TrimSpace(record[idx])
+			if raw == "" {
+				continue
+			}
+			if _, err := strconv.ParseFloat(raw, 64); err != nil {
+				text[i] = true
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	hosts := map[string]bool{}
+	for _, idx := range cols {
+		hosts[pdhHost(df.Columns[idx])] = true
+	}
+	out := make([]ParquetColumn, len(cols))
+	used := map[string]bool{"time": true}
+	for i, idx := range cols {
+		c := parsePDHColumnBackend(df.Columns[idx], idx)
+		name := df.Columns[idx]
+		if len(hosts) == 1 {
+			name = stripHostPrefix(name)
+		}
+		if used[name] {
+			name = fmt.Sprintf("%s #%d", name, idx)
+		}
+		used[name] = true
+		out[i] = ParquetColumn{Name: name, Column: idx, Header: df.Columns[idx], Host: pdhHost(c.Raw), Object: c.Object, Instance: c.Instance, Counter: c.Counter, Type: "double"}
+		if text[i] {
+			out[i].Type = "string"
+		}
+	}
+	return out, nil
+}
+
+// writeParquet writes the rows of df within [start, end] as a Parquet file.
+// With no columns every column is written. Like writeSlice it stops once
+// ctx is done; output only starts after the typing pass, so errors found
+// there can still be reported cleanly.
+func writeParquet(ctx context.Context, w io.Writer, df *DataFile, cols []int, start, end time.Time) (int64, error) {
+	for _, idx := range cols {
+		if idx <= 0 || idx >= len(df.Columns) {
+			return 0, fmt.Errorf("column %d out of range", idx)
+		}
+	}
+	if len(cols) == 0 {
+		for idx := 1; idx < len(df.Columns); idx++ {
+			cols = append(cols, idx)
+		}
+	}
+	meta, err := parquetColumns(ctx, df, cols, start, end)
+	if err != nil {
+		return 0, err
+	}
+
+	pw := &parquetWriter{w: bufio.NewWriterSize(w, exportChunkSize), columns: meta}
+	pw.groupRows = max(parquetGroupCells/(len(cols)+1), 1)
+	pw.reset()
+	if err := pw.write([]byte("PAR1")); err != nil {
+		return 0, err
+	}
+	err = eachRangeRow(ctx, df, start, end, func(ts time.Time, record []string) error {
+		pw.times = append(pw.times, ts.UnixMilli())
+		for i, idx := range cols {
+			raw := ""
+			if idx < len(record) {
+				raw = strings.TrimSpace(record[idx])
+			}
+			pw.add(i, raw)
+		}
+		if len(pw.times) >= pw.groupRows {
+			return pw.flushGroup()
+		}
+		return nil
+	})
+	if err == nil {
+		err = pw.flushGroup()
+	}
+	if err == nil {
+		err = pw.finish(df)
+	}
+	return pw.rows, err
+}
+
+type parquetWriter struct {
+	w         *bufio.Writer
+	offset    int64
+	columns   []ParquetColumn
+	groupRows int
+	rows      int64
+
+	// The current row group, column by column.
+	times   []int64
+	present [][]bool
+	doubles [][]float64
+	strs    [][]string
+
+	groups []parquetRowGroup
+}
+
+type parquetRowGroup struct {
+	rows   int64
+	chunks []parquetChunk
+}
+
+type parquetChunk struct {
+	typ    int32
+	path   string
+	values int64
+	offset int64
+	size   int64
+}
+
+func (pw *parquetWriter) write(b []byte) error {
+	n, err := pw.w.Write(b)
+	pw.offset += int64(n)
+	return err
+}
+
+func (pw *parquetWriter) reset() {
+	pw.times = pw.times[:0]
+	if pw.present == nil {
+		pw.present = make([][]bool, len(pw.columns))
+		pw.doubles = make([][]float64, len(pw.columns))
+		pw.strs = make([][]string, len(pw.columns))
+	}
+	for i := range pw.columns {
+		pw.present[i] = pw.present[i][:0]
+		pw.doubles[i] = pw.doubles[i][:0]
+		pw.strs[i] = pw.strs[i][:0]
+	}
+}
+
+// add appends one cell of column i; empty and non-finite cells are null.
+func (pw *parquetWriter) add(i int, raw string) {
+	if raw == "" {
+		pw.present[i] = append(pw.present[i], false)
+		return
+	}
+	if pw.columns[i].Type == "string" {
+		pw.present[i] = append(pw.present[i], true)
+		pw.strs[i] = append(pw.strs[i], strings.Clone(raw))
+		return
+	}
+	v, err := strconv.ParseFloat(raw, 64)
+	if err != nil || !NumberFinite(v) {
+		pw.present[i] = append(pw.present[i], false)
+		return
+	}
+	pw.present[i] = append(pw.present[i], true)
+	pw.doubles[i] = append(pw.doubles[i], v)
+}
+
+func (pw *parquetWriter) flushGroup() error {
+	n := len(pw.times)
+	if n == 0 {
+		return nil
+	}
+	group := parquetRowGroup{rows: int64(n)}
+	var page []byte
+	for _, t := range pw.times {
+		page = binary.LittleEndian.AppendUint64(page, uint64(t))
+	}
+	chunk, err := pw.writePage("time", parquetInt64, n, page)
+	if err != nil {
+		return err
+	}
+	group.chunks = append(group.chunks, chunk)
+	for i, c := range pw.columns {
+		page = appendDefinitionLevels(page[:0], pw.present[i])
+		typ := int32(parquetDouble)
+		if c.Type == "string" {
+			typ = parquetByteArray
+			for _, s := range pw.strs[i] {
+				page = binary.LittleEndian.AppendUint32(page, uint32(len(s)))
+				page = append(page, s...)
+			}
+		} else {
+			for _, v := range pw.doubles[i] {
+				page = binary.LittleEndian.AppendUint64(page, math.Float64bits(v))
+			}
+		}
+		chunk, err := pw.writePage(c.Name, typ, n, page)
+		if err != nil {
+			return err
+		}
+		group.chunks = append(group.chunks, chunk)
+	}
+	pw.groups = append(pw.groups, group)
+	pw.rows += int64(n)
+	pw.reset()
+	return nil
+}
+
+// writePage writes one column chunk holding a single uncompressed data page.
+func (pw *parquetWriter) writePage(path string, typ int32, values int, page []byte) (parquetChunk, error) {
+	var t thriftWriter
+	t.i32(1, 0) // DATA_PAGE
+	t.i32(2, int32(len(page)))
+	t.i32(3, int32(len(page)))
+	t.beginStruct(5)
+	t.i32(1, int32(values))
+	t.i32(2, parquetPlain)
+	t.i32(3, parquetRLE)
+	t.i32(4, parquetRLE)
+	t.endStruct()
+	t.stop()
+	chunk := parquetChunk{typ: typ, path: path, values: int64(values), offset: pw.offset, size: int64(len(t.buf) + len(page))}
+	if err := pw.write(t.buf); err != nil {
+		return chunk, err
+	}
+	return chunk, pw.write(page)
+}
+
+// appendDefinitionLevels encodes present as bit-packed definition levels
+// (bit width 1) in the RLE/bit-packing hybrid, behind the 4-byte length a
+// v1 data page expects.
+func appendDefinitionLevels(buf []byte, present []bool) []byte {
+	groups := (len(present) + 7) / 8
+	mark := len(buf)
+	buf = append(buf, 0, 0, 0, 0)
+	buf = binary.AppendUvarint(buf, uint64(groups)<<1|1)
+	for g := 0; g < groups; g++ {
+		var b byte
+		for bit := 0; bit < 8; bit++ {
+			if i := g*8 + bit; i < len(present) && present[i] {
+				b |= 1 << bit
+			}
+		}
+		buf = append(buf, b)
+	}
+	binary.LittleEndian.PutUint32(buf[mark:], uint32(len(buf)-mark-4))
+	return buf
+}
+
+// finish writes the footer: FileMetaData, its length and the magic.
+func (pw *parquetWriter) finish(df *DataFile) error {
+	columns, err := json.Marshal(pw.columns)
+	if err != nil {
+		return err
+	}
+	var t thriftWriter
+	t.i32(1, 1)
+	t.beginList(2, thriftStruct, len(pw.columns)+2)
+	t.beginElem()
+	t.binary(4, "schema")
+	t.i32(5, int32(len(pw.columns)+1))
+	t.endStruct()
+	t.beginElem()
+	t.i32(1, parquetInt64)
+	t.i32(3, parquetRequired)
+	t.binary(4, "time")
+	t.i32(6, parquetTimestampMillis)
+	t.beginStruct(10) // LogicalType
+	t.beginStruct(8)  // TIMESTAMP
+	t.boolean(1, true)
+	t.beginStruct(2) // unit
+	t.beginStruct(1) // MILLIS
+	t.endStruct()
+	t.endStruct()
+	t.endStruct()
+	t.endStruct()
+	t.endStruct()
+	for _, c := range pw.columns {
+		t.beginElem()
+		if c.Type == "string" {
+			t.i32(1, parquetByteArray)
+		} else {
+			t.i32(1, parquetDouble)
+		}
+		t.i32(3, parquetOptional)
+		t.binary(4, c.Name)
+		if c.Type == "string" {
+			t.i32(6, parquetUTF8)
+		}
+		t.i32(9, int32(c.Column))
+		if c.Type == "string" {
+			t.beginStruct(10)
+			t.beginStruct(1) // STRING
+			t.endStruct()
+			t.endStruct()
+		}
+		t.endStruct()
+	}
+	t.i64(3, pw.rows)
+	t.beginList(4, thriftStruct, len(pw.groups))
+	for _, g := range pw.groups {
+		t.beginElem()
+		t.beginList(1, thriftStruct, len(g.chunks))
+		var total int64
+		for _, c := range g.chunks {
+			total += c.size
+			t.beginElem()
+			t.i64(2, c.offset)
+			t.beginStruct(3)
+			t.i32(1, c.typ)
+			t.beginList(2, thriftI32, 2)
+			t.listI32(parquetPlain)
+			t.listI32(parquetRLE)
+			t.beginList(3, thriftBinary, 1)
+			t.listBinary(c.path)
+			t.i32(4, 0) // UNCOMPRESSED
+			t.i64(5, c.values)
+			t.i64(6, c.size)
+			t.i64(7, c.size)
+			t.i64(9, c.offset)
+			t.endStruct()
+			t.endStruct()
+		}
+		t.i64(2, total)
+		t.i64(3, g.rows)
+		t.endStruct()
+	}
+	t.beginList(5, thriftStruct, 2)
+	for _, kv := range [][2]string{{"esx-doctor.columns", string(columns)}, {"esx-doctor.source", df.Label}} {
+		t.beginElem()
+		t.binary(1, kv[0])
+		t.binary(2, kv[1])
+		t.endStruct()
+	}
+	t.binary(6, "esx-doctor")
+	t.stop()
+
+	if err := pw.write(t.buf); err != nil {
+		return err
+	}
+	if err := pw.write(binary.LittleEndian.AppendUint32(nil, uint32(len(t.buf)))); err != nil {
+		return err
+	}
+	if err := pw.write([]byte("PAR1")); err != nil {
+		return err
+	}
+	return pw.w.Flush()
+}
+
+// Thrift compact protocol type codes.
+const (
+	thriftTrue   = 1
+	thriftFalse  = 2
+	thriftI32    = 5
+	thriftI64    = 6
+	thriftBinary = 8
+	thriftList   = 9
+	thriftStruct = 12
+)
+
+// thriftWriter encodes the Thrift compact protocol, just enough for Parquet
+// page headers and the file footer. Struct nesting is tracked so field ids
+// are delta encoded against the enclosing struct.
+type thriftWriter struct {
+	buf    []byte
+	last   int16
+	parent []int16
+}
+
+func (t *thriftWriter) field(id int16, typ byte) {
+	if d := id - t.last; d > 0 && d <= 15 {
+		t.buf = append(t.buf, byte(d)<<4|typ)
+	} else {
+		t.buf = append(t.buf, typ)
+		t.buf = binary.AppendVarint(t.buf, int64(id))
+	}
+	t.last = id
+}
+
+func (t *thriftWriter) i32(id int16, v int32) {
+	t.field(id, thriftI32)
+	t.buf = binary.AppendVarint(t.buf, int64(v))
+}
+
+func (t *thriftWriter) i64(id int16, v int64) {
+	t.field(id, thriftI64)
+	t.buf = binary.AppendVarint(t.buf, v)
+}
+
+func (t *thriftWriter) boolean(id int16, v bool) {
+	if v {
+		t.field(id, thriftTrue)
+	} else {
+		t.field(id, thriftFalse)
+	}
+}
+
+func (t *thriftWriter) binary(id int16, s string) {
+	t.field(id, thriftBinary)
+	t.buf = binary.AppendUvarint(t.buf, uint64(len(s)))
+	t.buf = append(t.buf, s...)
+}
+
+func (t *thriftWriter) beginStruct(id int16) {
+	t.field(id, thriftStruct)
+	t.beginElem()
+}
+
+// beginElem starts a struct that is a list element, which has no field
+// header of its own.
+func (t *thriftWriter) beginElem() {
+	t.parent = append(t.parent, t.last)
+	t.last = 0
+}
+
+func (t *thriftWriter) endStruct() {
+	t.stop()
+	t.last = t.parent[len(t.parent)-1]
+	t.parent = t.parent[:len(t.parent)-1]
+}
+
+func (t *thriftWriter) stop() {
+	t.buf = append(t.buf, 0)
+}
+
+func (t *thriftWriter) beginList(id int16, elem byte, n int) {
+	t.field(id, thriftList)
+	if n < 15 {
+		t.buf = append(t.buf, byte(n)<<4|elem)
+		return
+	}
+	t.buf = append(t.buf, 0xf0|elem)
+	t.buf = binary.AppendUvarint(t.buf, uint64(n))
+}
+
+func (t *thriftWriter) listI32(v int32) {
+	t.buf = binary.AppendVarint(t.buf, int64(v))
+}
+
+func (t *thriftWriter) listBinary(s string) {
+	t.buf = binary.AppendUvarint(t.buf, uint64(len(s)))
+	t.buf = append(t.buf, s...)
+}
+
+// runParquet implements `esx-doctor parquet [-cols 1,2] [-out x.parquet] <file.csv>`.
+func runParquet(args []string) int {
+	fs := flag.NewFlagSet("parquet", flag.ContinueOnError)
+	out := fs.String("out", "", "File to write (default x.parquet next to the input)")
+	colsFlag := fs.String("cols", "", "Comma-separated column indexes to export (default all)")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: esx-doctor parquet [-cols 1,2] [-out x.parquet] <file.csv>")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return 2
+	}
+	var cols []int
+	for _, raw := range strings.Split(*colsFlag, ",") {
+		if raw = strings.TrimSpace(raw); raw == "" {
+			continue
+		}
+		idx, err := strconv.Atoi(raw)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "invalid column %q\n", raw)
+			return 2
+		}
+		cols = append(cols, idx)
+	}
+	path, err := filepath.Abs(fs.Arg(0))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "invalid path: %v\n", err)
+		return 1
+	}
+	dest := *out
+	if strings.TrimSpace(dest) == "" {
+		dest = trimCaptureExt(path) + ".parquet"
+	}
+	df, err := loadOrBuildIndex(path)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "index build failed: %v\n", err)
+		return 1
+	}
+	w, err := os.Create(dest)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "create %s: %v\n", dest, err)
+		return 1
+	}
+	rows, err := writeParquet(context.Background(), w, df, cols, time.Time{}, time.Time{})
+	if cerr := w.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		_ = os.Remove(dest)
+		fmt.Fprintf(os.Stderr, "export failed: %v\n", err)
+		return 1
+	}
+	fmt.Fprintf(os.Stderr, "wrote %d rows to %s\n", rows, dest)
+	return 0
+}
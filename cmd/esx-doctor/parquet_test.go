@@ -0,0 +1,176 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/hex"
+	"math"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+const parquetTestCSV = `"(PDH-CSV 4.0) (UTC)(0)","\\esx01\Memory\Free MBytes","\\esx01\Group Cpu(1:vm-a)\Latency Sensitivity"
+"01/01/2024 00:00:00","100","normal"
+"01/01/2024 00:00:05","","high"
+"01/01/2024 00:00:10","102.5",""
+`
+
+func TestThriftWriterPageHeader(t *testing.T) {
+	var tw thriftWriter
+	tw.i32(1, 0)
+	tw.i32(2, 16)
+	tw.i32(3, 16)
+	tw.beginStruct(5)
+	tw.i32(1, 2)
+	tw.i32(2, parquetPlain)
+	tw.endStruct()
+	tw.i64(21, -1) // a delta over 15 takes the long form
+	tw.stop()
+	if got, want := hex.EncodeToString(tw.buf), "1500152015202c1504150000062a0100"; got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+}
+
+// parquetTestPage reads the page header at the front of b (i32 fields and
+// the nested DataPageHeader) and returns the page's value count, its body
+// and what follows it.
+func parquetTestPage(t *testing.T, b []byte) (values int, body, rest []byte) {
+	t.Helper()
+	size := -1
+	var walk func(nested bool)
+	walk = func(nested bool) {
+		var id int
+		for {
+			if len(b) == 0 {
+				t.Fatal("page header runs past the file")
+			}
+			h := b[0]
+			b = b[1:]
+			if h == 0 {
+				return
+			}
+			id += int(h >> 4)
+			switch h & 0x0f {
+			case thriftI32:
+				v, n := binary.Varint(b)
+				if n <= 0 {
+					t.Fatal("bad varint in page header")
+				}
+				b = b[n:]
+				switch {
+				case nested && id == 1:
+					values = int(v)
+				case !nested && id == 3:
+					size = int(v)
+				}
+			case thriftStruct:
+				walk(true)
+			default:
+				t.Fatalf("unexpected field type %d in page header", h&0x0f)
+			}
+		}
+	}
+	walk(false)
+	if size < 0 || size > len(b) {
+		t.Fatalf("page size %d with %d bytes left", size, len(b))
+	}
+	return values, b[:size], b[size:]
+}
+
+// parquetTestLevels decodes the bit-packed definition levels at the front
+// of a page body.
+func parquetTestLevels(t *testing.T, body []byte, n int) (present []bool, rest []byte) {
+	t.Helper()
+	l := int(binary.LittleEndian.Uint32(body))
+	levels, rest := body[4:4+l], body[4+l:]
+	header, k := binary.Uvarint(levels)
+	if header&1 != 1 || int(header>>1) != (n+7)/8 {
+		t.Fatalf("definition level run header %#x for %d values", header, n)
+	}
+	for i := 0; i < n; i++ {
+		present = append(present, levels[k+i/8]&(1<<(i%8)) != 0)
+	}
+	return present, rest
+}
+
+func TestWriteParquet(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "capture.csv")
+	if err := os.WriteFile(path, []byte(parquetTestCSV), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	df, err := buildIndex(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	rows, err := writeParquet(context.Background(), &buf, df, nil, time.Time{}, time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rows != 3 {
+		t.Errorf("rows = %d, want 3", rows)
+	}
+
+	file := buf.Bytes()
+	if len(file) < 12 || string(file[:4]) != "PAR1" || string(file[len(file)-4:]) != "PAR1" {
+		t.Fatalf("missing PAR1 magic: % x ... % x", file[:min(4, len(file))], file[max(len(file)-4, 0):])
+	}
+
+	// One row group: time, then the two counters.
+	b := file[4:]
+	n, body, b := parquetTestPage(t, b)
+	if n != 3 || len(body) != 3*8 {
+		t.Fatalf("time page: %d values in %d bytes", n, len(body))
+	}
+	t0 := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC).UnixMilli()
+	for i := 0; i < 3; i++ {
+		if got := int64(binary.LittleEndian.Uint64(body[i*8:])); got != t0+int64(i)*5000 {
+			t.Errorf("time[%d] = %d, want %d", i, got, t0+int64(i)*5000)
+		}
+	}
+
+	n, body, b = parquetTestPage(t, b)
+	present, body := parquetTestLevels(t, body, n)
+	if !reflect.DeepEqual(present, []bool{true, false, true}) || len(body) != 2*8 {
+		t.Fatalf("free MBytes: present %v, %d value bytes", present, len(body))
+	}
+	for i, want := range []float64{100, 102.5} {
+		if got := math.Float64frombits(binary.LittleEndian.Uint64(body[i*8:])); got != want {
+			t.Errorf("free MBytes value %d = %v, want %v", i, got, want)
+		}
+	}
+
+	n, body, b = parquetTestPage(t, b)
+	present, body = parquetTestLevels(t, body, n)
+	if !reflect.DeepEqual(present, []bool{true, true, false}) {
+		t.Fatalf("latency sensitivity: present %v", present)
+	}
+	var strs []string
+	for len(body) >= 4 {
+		l := int(binary.LittleEndian.Uint32(body))
+		strs = append(strs, string(body[4:4+l]))
+		body = body[4+l:]
+	}
+	if !reflect.DeepEqual(strs, []string{"normal", "high"}) || len(body) != 0 {
+		t.Errorf("latency sensitivity values %q with %d bytes left", strs, len(body))
+	}
+
+	// What is left is the footer, its length and the closing magic.
+	footerLen := int(binary.LittleEndian.Uint32(file[len(file)-8:]))
+	if footerLen != len(b)-8 {
+		t.Fatalf("footer length %d, but %d bytes sit between the pages and the trailer", footerLen, len(b)-8)
+	}
+	footer := b[:footerLen]
+	if !bytes.HasPrefix(footer, []byte{0x15, 0x02}) {
+		t.Errorf("footer does not start with version 1: % x", footer[:2])
+	}
+	for _, s := range []string{"time", `Memory\Free MBytes`, `Group Cpu(1:vm-a)\Latency Sensitivity`, "esx-doctor.columns", path} {
+		if !bytes.Contains(footer, []byte(s)) {
+			t.Errorf("footer lacks %q", s)
+		}
+	}
+}
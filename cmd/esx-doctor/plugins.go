@@ -0,0 +1,152 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"plugin"
+	"sort"
+	"strings"
+	"time"
+)
+
+// A detector plugin is a Go plugin (.so, built with `go build
+// -buildmode=plugin` against the same Go release) that exports
+//
+//	func NewProcessor(template []byte, columns []string) (any, error)
+//
+// template is the DiagnosticTemplate as JSON and columns the CSV header. The
+// returned value must implement pluginProcessor. Only standard library types
+// cross the boundary, so plugins never import this package. The detector
+// type is the exported string DetectorType when present, else the file name
+// without extension; templates select it with "detector": {"type": ...}.
+type pluginProcessor interface {
+	ColumnIndexes() []int
+	// OnRow must not keep record, which is reused for the next row, and
+	// should strings.Clone any field it keeps.
+	OnRow(ts time.Time, record []string)
+	// Finalize returns a JSON array of DiagnosticFinding objects.
+	Finalize() []byte
+}
+
+type pluginFactory func(template []byte, columns []string) (any, error)
+
+var detectorPlugins = map[string]pluginFactory{}
+
+func defaultPluginDir() string {
+	home, err := os.UserHomeDir()
+	if err != nil || strings.TrimSpace(home) == "" {
+		return ""
+	}
+	return filepath.Join(home, ".esx-doctor", "plugins")
+}
+
+// loadDetectorPlugins opens every *.so in dir. Broken plugins are logged and
+// skipped so one bad file does not keep the server from starting.
+func loadDetectorPlugins(dir string) {
+	if strings.TrimSpace(dir) == "" {
+		return
+	}
+	matches, err := filepath.Glob(filepath.Join(dir, "*.so"))
+	if err != nil || len(matches) == 0 {
+		return
+	}
+	sort.Strings(matches)
+	for _, path := range matches {
+		name, err := loadDetectorPlugin(path)
+		if err != nil {
+			log.Printf("skipping detector plugin %s: %v", path, err)
+			continue
+		}
+		log.Printf("loaded detector plugin %s (type %q)", filepath.Base(path), name)
+	}
+}
+
+func loadDetectorPlugin(path string) (string, error) {
+	p, err := plugin.Open(path)
+	if err != nil {
+		return "", err
+	}
+	sym, err := p.Lookup("NewProcessor")
+	if err != nil {
+		return "", err
+	}
+	factory, ok := sym.(func([]byte, []string) (any, error))
+	if !ok {
+		return "", fmt.Errorf("NewProcessor has signature %T", sym)
+	}
+	name := strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+	if sym, err := p.Lookup("DetectorType"); err == nil {
+		if s, ok := sym.(*string); ok && strings.TrimSpace(*s) != "" {
+			name = strings.TrimSpace(*s)
+		}
+	}
+	if _, exists := detectorPlugins[name]; exists {
+		return "", fmt.Errorf("detector type %q already registered", name)
+	}
+	detectorPlugins[name] = factory
+	return name, nil
+}
+
+// pluginRowProcessor adapts a plugin's processor to rowProcessor.
+type pluginRowProcessor struct {
+	template DiagnosticTemplate
+	impl     pluginProcessor
+	indexes  []int
+}
+
+func newPluginRowProcessor(t DiagnosticTemplate, columns []string) (rowProcessor, error) {
+	factory, ok := detectorPlugins[t.Detector.Type]
+	if !ok {
+		return nil, fmt.Errorf("no detector plugin for type %q", t.Detector.Type)
+	}
+	raw, err := json.Marshal(t)
+	if err != nil {
+		return nil, err
+	}
+	v, err := factory(raw, columns)
+	if err != nil {
+		return nil, err
+	}
+	impl, ok := v.(pluginProcessor)
+	if !ok {
+		return nil, fmt.Errorf("processor %T does not implement ColumnIndexes/OnRow/Finalize", v)
+	}
+	var idxs []int
+	for _, idx := range impl.ColumnIndexes() {
+		if idx > 0 && idx < len(columns) {
+			idxs = append(idxs, idx)
+		}
+	}
+	return &pluginRowProcessor{template: t, impl: impl, indexes: idxs}, nil
+}
+
+func (p *pluginRowProcessor) onRow(ts time.Time, record []string) {
+	p.impl.OnRow(ts, record)
+}
+
+func (p *pluginRowProcessor) columnIndexes() []int {
+	return p.indexes
+}
+
+func (p *pluginRowProcessor) finalize() []DiagnosticFinding {
+	var findings []DiagnosticFinding
+	if err := json.Unmarshal(p.impl.Finalize(), &findings); err != nil {
+		log.Printf("detector plugin %q returned invalid findings: %v", p.template.Detector.Type, err)
+		return nil
+	}
+	for i := range findings {
+		f := &findings[i]
+		f.TemplateID = p.template.ID
+		f.TemplateName = p.template.Name
+		if f.Severity == "" {
+			f.Severity = p.template.Severity
+		}
+		if f.ReportKey == "" {
+			f.ReportKey = inferReportKeyFromAttribute(f.AttributeLabel)
+		}
+	}
+	return findings
+}
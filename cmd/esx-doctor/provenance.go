@@ -0,0 +1,109 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"log"
+	"os"
+	"sync"
+)
+
+// Provenance records how a DataFile came to be, so two people looking at
+// "the same" capture can tell whether they really have the same bytes and
+// the same index.
+type Provenance struct {
+	// Source is how the capture was opened: path, upload, url, stitch,
+	// bundle, fleet or watch.
+	Source string `json:"source"`
+	// Origin is the path, uploaded file name or URL it came from.
+	Origin string `json:"origin,omitempty"`
+	// ContentHash is the SHA-256 of the capture's bytes (of every part in
+	// time order when stitched). Empty until known; see contentHash.
+	ContentHash string `json:"contentHash,omitempty"`
+	// Index is built (scanned here), sidecar (loaded from IndexFile) or
+	// extended (a rolling export re-indexed from where it stopped).
+	Index           string `json:"index"`
+	IndexFile       string `json:"indexFile,omitempty"`
+	IndexDurationMs int64  `json:"indexDurationMs"`
+	IndexedAt       int64  `json:"indexedAt"`
+	Stride          int64  `json:"stride"`
+	IndexEntries    int    `json:"indexEntries"`
+	// IndexVersion is the format version a sidecar index was written in,
+	// before it was upgraded on load.
+	IndexVersion int `json:"indexVersion,omitempty"`
+	// Compression is "gzip" when a capture opened by path was read from
+	// its decompressed copy (see gzip.go).
+	Compression string `json:"compression,omitempty"`
+}
+
+// contentHashState holds a hash computed after indexing, for files whose
+// index was not built from a full read (sidecar, extended, stitched).
+type contentHashState struct {
+	once sync.Once
+	mu   sync.Mutex
+	sum  string
+	done bool
+}
+
+// contentHash returns the capture's SHA-256 and whether it is known yet.
+// When the index did not see every byte, the first call starts hashing in
+// the background and later calls pick up the result.
+func (df *DataFile) contentHash() (string, bool) {
+	if df.Provenance.ContentHash != "" {
+		return df.Provenance.ContentHash, true
+	}
+	h := &df.hashState
+	h.once.Do(func() {
+		go func() {
+			sum, err := hashFiles(df.sourcePaths())
+			if err != nil {
+				log.Printf("content hash of %s failed: %v", df.Label, err)
+			}
+			h.mu.Lock()
+			h.sum, h.done = sum, true
+			h.mu.Unlock()
+		}()
+	})
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.sum, h.done
+}
+
+// sourcePaths lists the files making up df in data order.
+func (df *DataFile) sourcePaths() []string {
+	if len(df.Parts) == 0 {
+		return []string{df.Path}
+	}
+	paths := make([]string, 0, len(df.Parts))
+	for _, p := range df.Parts {
+		paths = append(paths, p.Path)
+	}
+	return paths
+}
+
+func hashFiles(paths []string) (string, error) {
+	h := sha256.New()
+	for _, p := range paths {
+		f, err := os.Open(p)
+		if err != nil {
+			return "", err
+		}
+		_, err = io.Copy(h, f)
+		_ = f.Close()
+		if err != nil {
+			return "", err
+		}
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// provenance returns df.Provenance with the content hash filled in when
+// known; pending reports that it is still being computed.
+func (df *DataFile) provenance() (p Provenance, pending bool) {
+	p = df.Provenance
+	p.IndexEntries = len(df.Index)
+	sum, ok := df.contentHash()
+	p.ContentHash = sum
+	return p, !ok
+}
@@ -0,0 +1,384 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// QuerySelector picks columns by attribute label (through aliases) and,
+// optionally, by exact instance names or an instance regex. Any string may
+// contain ${param} placeholders filled from the request's query string.
+type QuerySelector struct {
+	Attribute     string   `json:"attribute"`
+	Instances     []string `json:"instances,omitempty"`
+	InstanceRegex string   `json:"instance_regex,omitempty"`
+}
+
+type QueryTransform struct {
+	Op    string  `json:"op"`
+	Value float64 `json:"value,omitempty"`
+}
+
+// SavedQuery is a named, parameterized /api/series request. Start and End
+// accept "${start}"/"${end}" (request parameters), "bookmark:<name>",
+// Unix milliseconds or a CSV timestamp; empty means the whole capture.
+type SavedQuery struct {
+	Name        string           `json:"name"`
+	Description string           `json:"description,omitempty"`
+	Selectors   []QuerySelector  `json:"selectors"`
+	Transforms  []QueryTransform `json:"transforms,omitempty"`
+	Aggregate   string           `json:"aggregate,omitempty"`
+	Start       string           `json:"start,omitempty"`
+	End         string           `json:"end,omitempty"`
+	MaxPoints   int              `json:"max_points,omitempty"`
+	// Align is the default wall-clock alignment (see parseSeriesAlign);
+	// an align request parameter overrides it.
+	Align string `json:"align,omitempty"`
+}
+
+type queryStore struct {
+	mu      sync.RWMutex
+	path    string
+	queries map[string]SavedQuery
+}
+
+var queryParamPattern = regexp.MustCompile(`\$\{([A-Za-z0-9_.-]+)\}`)
+
+func defaultQueryStorePath() string {
+	home, err := os.UserHomeDir()
+	if err != nil || strings.TrimSpace(home) == "" {
+		return ".esx-doctor-queries.json"
+	}
+	return filepath.Join(home, ".esx-doctor", "queries.json")
+}
+
+func newQueryStore(path string) (*queryStore, error) {
+	if strings.TrimSpace(path) == "" {
+		path = defaultQueryStorePath()
+	}
+	s := &queryStore{path: path, queries: map[string]SavedQuery{}}
+	if err := s.load(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *queryStore) load() error {
+	data, err := os.ReadFile(s.path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	var payload struct {
+		Queries []SavedQuery `json:"queries"`
+	}
+	if err := json.Unmarshal(data, &payload); err != nil {
+		return fmt.Errorf("invalid query store file: %w", err)
+	}
+	for _, q := range payload.Queries {
+		if strings.TrimSpace(q.Name) == "" {
+			continue
+		}
+		s.queries[queryKey(q.Name)] = q
+	}
+	return nil
+}
+
+// reload re-reads saved queries from disk, discarding in-memory state.
+func (s *queryStore) reload() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	prev := s.queries
+	s.queries = map[string]SavedQuery{}
+	if err := s.load(); err != nil {
+		s.queries = prev
+		return err
+	}
+	return nil
+}
+
+func (s *queryStore) persistLocked() error {
+	dir := filepath.Dir(s.path)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(map[string]any{"queries": s.listLocked()}, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(s.path, data, 0o644)
+}
+
+func queryKey(name string) string {
+	return strings.ToLower(strings.TrimSpace(name))
+}
+
+func (s *queryStore) listLocked() []SavedQuery {
+	out := make([]SavedQuery, 0, len(s.queries))
+	for _, q := range s.queries {
+		out = append(out, q)
+	}
+	sort.Slice(out, func(i, j int) bool { return queryKey(out[i].Name) < queryKey(out[j].Name) })
+	return out
+}
+
+func (s *queryStore) list() []SavedQuery {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.listLocked()
+}
+
+func (s *queryStore) get(name string) (SavedQuery, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	q, ok := s.queries[queryKey(name)]
+	return q, ok
+}
+
+func (s *queryStore) upsert(q SavedQuery) (SavedQuery, error) {
+	q.Name = strings.TrimSpace(q.Name)
+	q.Description = strings.TrimSpace(q.Description)
+	q.Aggregate = strings.ToLower(strings.TrimSpace(q.Aggregate))
+	if q.Name == "" {
+		return q, fmt.Errorf("query name is required")
+	}
+	if len(q.Selectors) == 0 {
+		return q, fmt.Errorf("query needs at least one selector")
+	}
+	for _, sel := range q.Selectors {
+		if strings.TrimSpace(sel.Attribute) == "" {
+			return q, fmt.Errorf("every selector needs an attribute")
+		}
+		if sel.InstanceRegex != "" && !queryParamPattern.MatchString(sel.InstanceRegex) {
+			if _, err := regexp.Compile(sel.InstanceRegex); err != nil {
+				return q, fmt.Errorf("invalid instance_regex: %w", err)
+			}
+		}
+	}
+	switch q.Aggregate {
+	case "", "sum", "avg", "min", "max":
+	default:
+		return q, fmt.Errorf("unsupported aggregate %q", q.Aggregate)
+	}
+	q.Align = strings.ToLower(strings.TrimSpace(q.Align))
+	if _, err := parseSeriesAlign(q.Align); err != nil {
+		return q, err
+	}
+	for i, t := range q.Transforms {
+		q.Transforms[i].Op = strings.ToLower(strings.TrimSpace(t.Op))
+		switch q.Transforms[i].Op {
+		case "scale", "offset", "delta", "abs":
+		default:
+			return q, fmt.Errorf("unsupported transform %q", t.Op)
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.queries[queryKey(q.Name)] = q
+	if err := s.persistLocked(); err != nil {
+		return q, err
+	}
+	return q, nil
+}
+
+func (s *queryStore) delete(name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if strings.TrimSpace(name) == "" {
+		return fmt.Errorf("query name is required")
+	}
+	delete(s.queries, queryKey(name))
+	return s.persistLocked()
+}
+
+// expandQueryParams fills ${param} placeholders from params.
+func expandQueryParams(s string, params url.Values) (string, error) {
+	var missing string
+	out := queryParamPattern.ReplaceAllStringFunc(s, func(m string) string {
+		key := queryParamPattern.FindStringSubmatch(m)[1]
+		if !params.Has(key) {
+			missing = key
+			return m
+		}
+		return params.Get(key)
+	})
+	if missing != "" {
+		return "", fmt.Errorf("missing query parameter %q", missing)
+	}
+	return out, nil
+}
+
+func resolveQueryTime(df *DataFile, raw string, params url.Values, bookmarks *bookmarkStore, useEnd bool) (time.Time, error) {
+	raw = strings.TrimSpace(raw)
+	// A bare ${start}/${end} left out of the request means "unbounded".
+	if m := queryParamPattern.FindStringSubmatch(raw); m != nil && m[0] == raw && (m[1] == "start" || m[1] == "end") && !params.Has(m[1]) {
+		return time.Time{}, nil
+	}
+	val, err := expandQueryParams(raw, params)
+	if err != nil || val == "" {
+		return time.Time{}, err
+	}
+	if name, ok := strings.CutPrefix(val, "bookmark:"); ok {
+		start, end, err := bookmarks.resolve(df, name)
+		if useEnd {
+			return end, err
+		}
+		return start, err
+	}
+	if ms, err := strconv.ParseInt(val, 10, 64); err == nil {
+		return time.UnixMilli(ms).UTC(), nil
+	}
+	t, _, err := parseTimeValue(val)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid time %q", val)
+	}
+	return t, nil
+}
+
+// runSavedQuery executes q against df with params from the request.
+func runSavedQuery(df *DataFile, q SavedQuery, params url.Values, bookmarks *bookmarkStore) (SeriesResponse, error) {
+	var cols []int
+	seen := map[int]bool{}
+	for _, sel := range q.Selectors {
+		attr, err := expandQueryParams(sel.Attribute, params)
+		if err != nil {
+			return SeriesResponse{}, err
+		}
+		var instances []string
+		for _, inst := range sel.Instances {
+			v, err := expandQueryParams(inst, params)
+			if err != nil {
+				return SeriesResponse{}, err
+			}
+			instances = append(instances, v)
+		}
+		var re *regexp.Regexp
+		if sel.InstanceRegex != "" {
+			expr, err := expandQueryParams(sel.InstanceRegex, params)
+			if err != nil {
+				return SeriesResponse{}, err
+			}
+			if re, err = regexp.Compile(expr); err != nil {
+				return SeriesResponse{}, fmt.Errorf("invalid instance_regex: %w", err)
+			}
+		}
+		for _, idx := range df.resolveColumnsByAttribute(attr, instances) {
+			if seen[idx] {
+				continue
+			}
+			if re != nil && !re.MatchString(parsePDHColumnBackend(df.Columns[idx], idx).Instance) {
+				continue
+			}
+			seen[idx] = true
+			cols = append(cols, idx)
+		}
+	}
+	if len(cols) == 0 {
+		return SeriesResponse{}, fmt.Errorf("query %q matched no columns", q.Name)
+	}
+
+	startRaw, endRaw := q.Start, q.End
+	if startRaw == "" && params.Has("start") {
+		startRaw = "${start}"
+	}
+	if endRaw == "" && params.Has("end") {
+		endRaw = "${end}"
+	}
+	start, err := resolveQueryTime(df, startRaw, params, bookmarks, false)
+	if err != nil {
+		return SeriesResponse{}, err
+	}
+	end, err := resolveQueryTime(df, endRaw, params, bookmarks, true)
+	if err != nil {
+		return SeriesResponse{}, err
+	}
+	alignRaw := q.Align
+	if params.Has("align") {
+		alignRaw = params.Get("align")
+	}
+	align, err := parseSeriesAlign(alignRaw)
+	if err != nil {
+		return SeriesResponse{}, err
+	}
+	resp, err := df.extractSeries(cols, nil, start, end, seriesMaxPoints(params, q.MaxPoints), align, false, newQueryTrace(params))
+	if err != nil {
+		return resp, err
+	}
+	for si := range resp.Series {
+		applyQueryTransforms(resp.Series[si].Values, q.Transforms)
+	}
+	if q.Aggregate != "" && len(resp.Series) > 0 {
+		resp.Series = []SeriesPayload{aggregateSeries(resp.Series, q.Aggregate, q.Name)}
+	}
+	return resp, nil
+}
+
+func applyQueryTransforms(values []float64, transforms []QueryTransform) {
+	for _, t := range transforms {
+		switch t.Op {
+		case "scale":
+			for i := range values {
+				values[i] *= t.Value
+			}
+		case "offset":
+			for i := range values {
+				values[i] += t.Value
+			}
+		case "abs":
+			for i, v := range values {
+				if v < 0 {
+					values[i] = -v
+				}
+			}
+		case "delta":
+			prev := 0.0
+			for i, v := range values {
+				if i == 0 {
+					values[i] = 0
+				} else {
+					values[i] = v - prev
+				}
+				prev = v
+			}
+		}
+	}
+}
+
+func aggregateSeries(series []SeriesPayload, op, name string) SeriesPayload {
+	n := len(series[0].Values)
+	out := SeriesPayload{Name: fmt.Sprintf("%s(%s)", op, name), Values: make([]float64, n)}
+	for i := 0; i < n; i++ {
+		acc := 0.0
+		for si, s := range series {
+			if i >= len(s.Values) {
+				continue
+			}
+			v := s.Values[i]
+			switch {
+			case si == 0:
+				acc = v
+			case op == "min" && v < acc, op == "max" && v > acc:
+				acc = v
+			case op == "sum", op == "avg":
+				acc += v
+			}
+		}
+		if op == "avg" {
+			acc /= float64(len(series))
+		}
+		out.Values[i] = acc
+	}
+	return out
+}
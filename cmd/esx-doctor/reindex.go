@@ -0,0 +1,170 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"log"
+	"os"
+	"time"
+)
+
+// refreshCheckInterval throttles how often a capture is stat'ed for changes.
+const refreshCheckInterval = 2 * time.Second
+
+// latest returns the newest DataFile for this capture. When the file on disk
+// changed since it was indexed, the index is extended (append) or rebuilt
+// (replace) once and remembered as the successor, so every session holding
+// the old DataFile converges on the same new one.
+func (df *DataFile) latest() *DataFile {
+	cur := df
+	for {
+		cur.refreshMu.Lock()
+		next := cur.successor
+		if next == nil {
+			next = cur.refreshLocked()
+			cur.successor = next
+		}
+		cur.refreshMu.Unlock()
+		if next == nil {
+			return cur
+		}
+		cur = next
+	}
+}
+
+func (df *DataFile) refreshLocked() *DataFile {
+	if df.OwnedTemp || df.Path == "" || len(df.Parts) > 0 {
+		return nil
+	}
+	now := time.Now()
+	if now.Sub(df.lastCheck) < refreshCheckInterval {
+		return nil
+	}
+	df.lastCheck = now
+	st, err := os.Stat(df.Path)
+	if err != nil || (st.Size() == df.Size && st.ModTime().Equal(df.ModTime)) {
+		return nil
+	}
+	next, err := extendIndex(df)
+	if err != nil {
+		log.Printf("incremental re-index of %s failed, rebuilding: %v", df.Label, err)
+		next, err = buildIndexFollow(df.Path, df.Follow)
+		if err != nil {
+			log.Printf("re-index of %s failed: %v", df.Label, err)
+			return nil
+		}
+	}
+	next.Label = df.Label
+	if next.Rows != df.Rows || !df.Follow {
+		// A followed capture grows a partial row at a time; stay quiet
+		// until it completes one.
+		log.Printf("re-indexed %s: %d -> %d rows", df.Label, df.Rows, next.Rows)
+	}
+	return next
+}
+
+// extendIndex continues indexing an appended capture from where df stopped.
+// It fails when the file shrank or its header changed, i.e. it was replaced.
+func extendIndex(df *DataFile) (*DataFile, error) {
+	began := time.Now()
+	f, err := os.Open(df.Path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	st, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if st.Size() < df.DataEndOffset || st.Size() < df.Size {
+		return nil, fmt.Errorf("file shrank")
+	}
+	if err := verifyHeader(f, df.DataStartOffset, df.Columns); err != nil {
+		return nil, err
+	}
+	if sum, err := tailChecksum(f, df.DataEndOffset); err != nil || sum != df.tailSum {
+		return nil, fmt.Errorf("indexed rows changed")
+	}
+	if _, err := f.Seek(df.DataEndOffset, io.SeekStart); err != nil {
+		return nil, err
+	}
+
+	next := &DataFile{
+		Path:            df.Path,
+		Label:           df.Label,
+		Columns:         df.Columns,
+		Index:           append(make([]IndexEntry, 0, len(df.Index)+16), df.Index...),
+		Rows:            df.Rows,
+		StartTime:       df.StartTime,
+		EndTime:         df.EndTime,
+		DataStartOffset: df.DataStartOffset,
+		DataEndOffset:   df.DataEndOffset,
+		TimeLayout:      df.TimeLayout,
+		MalformedLines:  df.MalformedLines,
+		BadTimestamps:   df.BadTimestamps,
+		ShortRows:       df.ShortRows,
+		LenientLines:    df.LenientLines,
+		LenientSamples:  append([]LenientLine(nil), df.LenientSamples...),
+		ShortRowSamples: append([]ShortRow(nil), df.ShortRowSamples...),
+		Size:            st.Size(),
+		ModTime:         st.ModTime(),
+		Follow:          df.Follow,
+		Remote:          df.Remote,
+	}
+	lines := getLineReader(f)
+	defer lines.release()
+	if err := next.indexRows(lines, df.DataEndOffset, df.Rows); err != nil {
+		return nil, err
+	}
+	next.tailSum, _ = tailChecksum(f, next.DataEndOffset)
+	next.Provenance = Provenance{
+		Source:          df.Provenance.Source,
+		Origin:          df.Provenance.Origin,
+		Index:           "extended",
+		IndexDurationMs: time.Since(began).Milliseconds(),
+		IndexedAt:       time.Now().UnixMilli(),
+		Stride:          indexStride,
+	}
+	return next, nil
+}
+
+// tailChecksum fingerprints the last few KiB before end, which is enough to
+// tell an appended capture from one rewritten with the same header.
+func tailChecksum(f *os.File, end int64) (uint32, error) {
+	start := end - 4096
+	if start < 0 {
+		start = 0
+	}
+	buf := make([]byte, end-start)
+	if _, err := f.ReadAt(buf, start); err != nil && !errors.Is(err, io.EOF) {
+		return 0, err
+	}
+	return crc32.ChecksumIEEE(buf), nil
+}
+
+// verifyHeader checks that r starts with the header a DataFile was indexed
+// with: same byte length and same column names.
+func verifyHeader(r io.Reader, dataStart int64, columns []string) error {
+	line, err := bufio.NewReader(r).ReadBytes('\n')
+	if err != nil && !errors.Is(err, io.EOF) {
+		return err
+	}
+	if int64(len(line)) != dataStart {
+		return fmt.Errorf("capture header changed")
+	}
+	header, err := readCSVLine(line)
+	if err != nil || len(header) != len(columns) {
+		return fmt.Errorf("capture header changed")
+	}
+	// columns were normalized at index time.
+	normalizeRemoteHeader(header)
+	for i := 1; i < len(header); i++ {
+		if header[i] != columns[i] {
+			return fmt.Errorf("capture header changed (column %d)", i)
+		}
+	}
+	return nil
+}
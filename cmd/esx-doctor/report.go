@@ -0,0 +1,229 @@
+package main
+
+import (
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// reportSectionDef is one report tab: an attribute belongs to the first
+// section with a pattern matching its label, case-insensitively, and to
+// "other" when none does. The UI builds its tabs from these
+// (/api/report/sections), so patterns must read the same as Go and
+// JavaScript regular expressions.
+type reportSectionDef struct {
+	Key      string   `json:"key"`
+	Label    string   `json:"label"`
+	Patterns []string `json:"patterns"`
+	res      []*regexp.Regexp
+}
+
+func newReportSectionDef(key, label string, patterns ...string) reportSectionDef {
+	def := reportSectionDef{Key: key, Label: label, Patterns: patterns}
+	for _, p := range patterns {
+		def.res = append(def.res, regexp.MustCompile("(?i)"+p))
+	}
+	return def
+}
+
+var reportSectionDefs = []reportSectionDef{
+	newReportSectionDef("cpu", "CPU", `cpu`, `% used`, `% ready`),
+	newReportSectionDef("memory", "Memory", `memory`, `swap`, `memctl`, `compressed`),
+	newReportSectionDef("numa", "NUMA", `numa`),
+	newReportSectionDef("power", "Power", `power`, `watts`, `pstate`, `cstate`),
+	newReportSectionDef("network", "Network", `\bnet`, `nic`, `network`),
+	newReportSectionDef("storage", "Storage", `disk`, `datastore`, `storage`, `latency`, `iops`),
+	newReportSectionDef("vsan", "vSAN", `vsan`),
+}
+
+const (
+	reportMaxCharts       = 6
+	reportMaxChartColumns = 8
+	reportMaxStatColumns  = 64
+)
+
+type SuggestedChart struct {
+	Attribute string   `json:"attribute"`
+	Columns   []int    `json:"columns"`
+	Instances []string `json:"instances"`
+	Reason    string   `json:"reason"`
+}
+
+type ReportSection struct {
+	ReportKey   string              `json:"reportKey"`
+	Label       string              `json:"label"`
+	Findings    []DiagnosticFinding `json:"findings"`
+	Charts      []SuggestedChart    `json:"charts"`
+	Stats       []ColumnStats       `json:"stats"`
+	RowsScanned int64               `json:"rowsScanned"`
+	Warnings    []string            `json:"warnings,omitempty"`
+	Error       string              `json:"error,omitempty"`
+}
+
+func reportKeyForAttribute(label string) string {
+	for _, def := range reportSectionDefs {
+		for _, re := range def.res {
+			if re.MatchString(label) {
+				return def.Key
+			}
+		}
+	}
+	return "other"
+}
+
+func reportSectionLabel(key string) (string, bool) {
+	if key == "other" {
+		return "Other", true
+	}
+	for _, def := range reportSectionDefs {
+		if def.Key == key {
+			return def.Label, true
+		}
+	}
+	return "", false
+}
+
+// buildReportSection runs the selected templates and assembles the findings,
+// suggested charts and per-column statistics for one report key. Charts come
+// from the findings first, then from the most active attributes in the
+// section so quiet captures still get something to look at.
+func buildReportSection(df *DataFile, key string, selected []DiagnosticTemplate, start, end time.Time) (ReportSection, error) {
+	label, _ := reportSectionLabel(key)
+	sec := ReportSection{
+		ReportKey: key,
+		Label:     label,
+		Findings:  []DiagnosticFinding{},
+		Charts:    []SuggestedChart{},
+		Stats:     []ColumnStats{},
+	}
+	run, err := runDiagnostics(df, selected, start, end)
+	if err != nil {
+		return sec, err
+	}
+	sec.RowsScanned = run.RowsScanned
+	sec.Warnings = run.Warnings
+	for _, f := range run.Findings {
+		if f.ReportKey == key {
+			sec.Findings = append(sec.Findings, f)
+		}
+	}
+
+	byAttr := map[string][]parsedColumn{}
+	var attrOrder []string
+	for i, raw := range df.Columns {
+		if i == 0 {
+			continue
+		}
+		c := parsePDHColumnBackend(raw, i)
+		if reportKeyForAttribute(c.AttributeLabel) != key {
+			continue
+		}
+		if _, ok := byAttr[c.AttributeLabel]; !ok {
+			attrOrder = append(attrOrder, c.AttributeLabel)
+		}
+		byAttr[c.AttributeLabel] = append(byAttr[c.AttributeLabel], c)
+	}
+
+	// Findings on the same attribute share one chart with their instances merged.
+	type findingChart struct {
+		attr      string
+		instances []string
+		templates []string
+	}
+	var fromFindings []*findingChart
+	byFindingAttr := map[string]*findingChart{}
+	for _, f := range sec.Findings {
+		if f.AttributeLabel == "" {
+			continue
+		}
+		k := strings.ToLower(canonicalAttributeLabel(f.AttributeLabel))
+		fc, ok := byFindingAttr[k]
+		if !ok {
+			fc = &findingChart{attr: f.AttributeLabel}
+			byFindingAttr[k] = fc
+			fromFindings = append(fromFindings, fc)
+		}
+		for _, inst := range f.Instances {
+			fc.instances = appendUnique(fc.instances, inst)
+		}
+		fc.templates = appendUnique(fc.templates, f.TemplateName)
+	}
+	charted := map[string]bool{}
+	for _, fc := range fromFindings {
+		if len(sec.Charts) >= reportMaxCharts {
+			break
+		}
+		idxs := df.resolveColumnsByAttribute(fc.attr, fc.instances)
+		if len(idxs) == 0 {
+			continue
+		}
+		charted[strings.ToLower(canonicalAttributeLabel(fc.attr))] = true
+		sec.Charts = append(sec.Charts, chartForColumns(df, fc.attr, idxs, "findings: "+strings.Join(fc.templates, ", ")))
+	}
+
+	if len(sec.Charts) < reportMaxCharts && len(attrOrder) > 0 {
+		scores, err := df.Activity()
+		if err == nil {
+			type ranked struct {
+				attr  string
+				cols  []parsedColumn
+				score float64
+			}
+			var candidates []ranked
+			for _, attr := range attrOrder {
+				if charted[strings.ToLower(canonicalAttributeLabel(attr))] {
+					continue
+				}
+				cols := append([]parsedColumn(nil), byAttr[attr]...)
+				sort.SliceStable(cols, func(i, j int) bool { return scores[cols[i].Idx] > scores[cols[j].Idx] })
+				if scores[cols[0].Idx] <= 0 {
+					continue
+				}
+				candidates = append(candidates, ranked{attr, cols, scores[cols[0].Idx]})
+			}
+			sort.SliceStable(candidates, func(i, j int) bool { return candidates[i].score > candidates[j].score })
+			for _, cand := range candidates {
+				if len(sec.Charts) >= reportMaxCharts {
+					break
+				}
+				idxs := make([]int, 0, reportMaxChartColumns)
+				for _, c := range cand.cols {
+					if len(idxs) >= reportMaxChartColumns {
+						break
+					}
+					idxs = append(idxs, c.Idx)
+				}
+				sec.Charts = append(sec.Charts, chartForColumns(df, cand.attr, idxs, "most active"))
+			}
+		}
+	}
+
+	var statCols []int
+	for _, ch := range sec.Charts {
+		for _, idx := range ch.Columns {
+			if len(statCols) < reportMaxStatColumns {
+				statCols = append(statCols, idx)
+			}
+		}
+	}
+	if len(statCols) > 0 {
+		stats, err := computeColumnStats(df, statCols, start, end)
+		if err != nil {
+			return sec, err
+		}
+		sec.Stats = stats
+	}
+	return sec, nil
+}
+
+func chartForColumns(df *DataFile, attr string, idxs []int, reason string) SuggestedChart {
+	if len(idxs) > reportMaxChartColumns {
+		idxs = idxs[:reportMaxChartColumns]
+	}
+	ch := SuggestedChart{Attribute: attr, Columns: idxs, Instances: make([]string, 0, len(idxs)), Reason: reason}
+	for _, idx := range idxs {
+		ch.Instances = append(ch.Instances, parsePDHColumnBackend(df.Columns[idx], idx).Instance)
+	}
+	return ch
+}
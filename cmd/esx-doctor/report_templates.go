@@ -0,0 +1,438 @@
+package main
+
+import (
+	"embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"io"
+	"math"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ReportPanel is one chart in a report template: either the name of a
+// saved query or an inline selector set in the saved query format.
+// Narrative may use ${min}, ${avg}, ${max} and ${peak_time} of the panel's
+// busiest series.
+type ReportPanel struct {
+	Title      string           `json:"title"`
+	Query      string           `json:"query,omitempty"`
+	Selectors  []QuerySelector  `json:"selectors,omitempty"`
+	Transforms []QueryTransform `json:"transforms,omitempty"`
+	Aggregate  string           `json:"aggregate,omitempty"`
+	Unit       string           `json:"unit,omitempty"`
+	Narrative  string           `json:"narrative,omitempty"`
+}
+
+type ReportTemplateSection struct {
+	Title     string        `json:"title"`
+	Narrative string        `json:"narrative,omitempty"`
+	Panels    []ReportPanel `json:"panels"`
+	// Diagnostics lists diagnostic template IDs whose findings are listed
+	// under the section.
+	Diagnostics []string `json:"diagnostics,omitempty"`
+}
+
+// ReportTemplate declares a standard deliverable ("Storage Performance
+// Review"). Narrative strings may use ${file}, ${host}, ${start} and ${end}.
+type ReportTemplate struct {
+	ID          string                  `json:"id"`
+	Title       string                  `json:"title"`
+	Description string                  `json:"description,omitempty"`
+	Narrative   string                  `json:"narrative,omitempty"`
+	Sections    []ReportTemplateSection `json:"sections"`
+	Source      string                  `json:"source"`
+}
+
+const reportPanelMaxSeries = 8
+
+func defaultReportTemplateDir() string {
+	home, err := os.UserHomeDir()
+	if err != nil || strings.TrimSpace(home) == "" {
+		return ""
+	}
+	return filepath.Join(home, ".esx-doctor", "reports")
+}
+
+func parseReportTemplate(data []byte, name, source string) (ReportTemplate, error) {
+	var rt ReportTemplate
+	if err := json.Unmarshal(data, &rt); err != nil {
+		return rt, fmt.Errorf("invalid report template %s: %w", name, err)
+	}
+	if strings.TrimSpace(rt.ID) == "" || strings.TrimSpace(rt.Title) == "" || len(rt.Sections) == 0 {
+		return rt, fmt.Errorf("invalid report template %s: missing required fields", name)
+	}
+	for _, sec := range rt.Sections {
+		for _, p := range sec.Panels {
+			if p.Query == "" && len(p.Selectors) == 0 {
+				return rt, fmt.Errorf("invalid report template %s: panel %q needs a query or selectors", name, p.Title)
+			}
+		}
+	}
+	rt.Source = source
+	return rt, nil
+}
+
+// loadReportTemplates returns the built-in report templates plus any *.json
+// in dir, which is read on every call so teams can drop in new ones without
+// a restart. A user template with a built-in ID replaces it.
+func loadReportTemplates(fs embed.FS, dir string) ([]ReportTemplate, error) {
+	byID := map[string]ReportTemplate{}
+	entries, err := fs.ReadDir("reports")
+	if err != nil {
+		return nil, err
+	}
+	for _, e := range entries {
+		if e.IsDir() || !strings.HasSuffix(strings.ToLower(e.Name()), ".json") {
+			continue
+		}
+		data, err := fs.ReadFile("reports/" + e.Name())
+		if err != nil {
+			return nil, err
+		}
+		rt, err := parseReportTemplate(data, e.Name(), "builtin")
+		if err != nil {
+			return nil, err
+		}
+		byID[rt.ID] = rt
+	}
+	if dir != "" {
+		userEntries, err := os.ReadDir(dir)
+		if err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+		for _, e := range userEntries {
+			if e.IsDir() || !strings.HasSuffix(strings.ToLower(e.Name()), ".json") {
+				continue
+			}
+			data, err := os.ReadFile(filepath.Join(dir, e.Name()))
+			if err != nil {
+				return nil, err
+			}
+			rt, err := parseReportTemplate(data, e.Name(), "user")
+			if err != nil {
+				return nil, err
+			}
+			byID[rt.ID] = rt
+		}
+	}
+	out := make([]ReportTemplate, 0, len(byID))
+	for _, rt := range byID {
+		out = append(out, rt)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Title < out[j].Title })
+	return out, nil
+}
+
+// expandNarrative fills ${name} placeholders from vars; unknown names are
+// left as written so typos stay visible in the output.
+func expandNarrative(s string, vars map[string]string) string {
+	return queryParamPattern.ReplaceAllStringFunc(s, func(m string) string {
+		if v, ok := vars[m[2:len(m)-1]]; ok {
+			return v
+		}
+		return m
+	})
+}
+
+type renderedSeries struct {
+	Name   string
+	Color  string
+	Points string
+	Min    string
+	Avg    string
+	Max    string
+}
+
+type renderedPanel struct {
+	Title     string
+	Narrative string
+	Unit      string
+	Error     string
+	Series    []renderedSeries
+	YMax      string
+	YMin      string
+}
+
+type renderedSection struct {
+	Title     string
+	Narrative string
+	Panels    []renderedPanel
+	Findings  []DiagnosticFinding
+	HasDiag   bool
+}
+
+type renderedReport struct {
+	Title     string
+	Narrative string
+	File      string
+	Window    string
+	Generated string
+	Sections  []renderedSection
+}
+
+var reportChartColors = []string{"#2563eb", "#dc2626", "#16a34a", "#d97706", "#7c3aed", "#0891b2", "#db2777", "#4b5563"}
+
+const (
+	reportChartWidth  = 720
+	reportChartHeight = 200
+)
+
+func formatReportNumber(v float64) string {
+	return strconv.FormatFloat(v, 'f', 2, 64)
+}
+
+// renderPanel runs the panel's query and lays its series out as SVG
+// polylines scaled to a shared y axis.
+func renderPanel(df *DataFile, p ReportPanel, params url.Values, queries *queryStore, bookmarks *bookmarkStore) renderedPanel {
+	out := renderedPanel{Title: p.Title, Unit: p.Unit}
+	q := SavedQuery{Name: p.Title, Selectors: p.Selectors, Transforms: p.Transforms, Aggregate: p.Aggregate, MaxPoints: reportChartWidth}
+	if p.Query != "" {
+		saved, ok := queries.get(p.Query)
+		if !ok {
+			out.Error = fmt.Sprintf("saved query %q not found", p.Query)
+			return out
+		}
+		q = saved
+		if q.MaxPoints <= 0 {
+			q.MaxPoints = reportChartWidth
+		}
+	}
+	resp, err := runSavedQuery(df, q, params, bookmarks)
+	if err != nil {
+		out.Error = err.Error()
+		return out
+	}
+	if len(resp.Times) == 0 {
+		out.Error = "no samples in the report window"
+		return out
+	}
+
+	series := resp.Series
+	if len(series) > reportPanelMaxSeries {
+		// Keep the busiest series so crowded panels stay readable.
+		peaks := make([]float64, len(series))
+		for i, s := range series {
+			for _, v := range s.Values {
+				peaks[i] = math.Max(peaks[i], v)
+			}
+		}
+		order := make([]int, len(series))
+		for i := range order {
+			order[i] = i
+		}
+		sort.SliceStable(order, func(a, b int) bool { return peaks[order[a]] > peaks[order[b]] })
+		kept := make([]SeriesPayload, 0, reportPanelMaxSeries)
+		for _, i := range order[:reportPanelMaxSeries] {
+			kept = append(kept, series[i])
+		}
+		series = kept
+	}
+
+	yMin, yMax := math.Inf(1), math.Inf(-1)
+	for _, s := range series {
+		for _, v := range s.Values {
+			yMin, yMax = math.Min(yMin, v), math.Max(yMax, v)
+		}
+	}
+	if yMin > 0 {
+		yMin = 0
+	}
+	if yMax <= yMin {
+		yMax = yMin + 1
+	}
+	out.YMin, out.YMax = formatReportNumber(yMin), formatReportNumber(yMax)
+
+	t0, t1 := resp.Times[0], resp.Times[len(resp.Times)-1]
+	span := float64(t1 - t0)
+	var busiest struct {
+		max, avg, min float64
+		at            int64
+		set           bool
+	}
+	for si, s := range series {
+		var pts strings.Builder
+		lo, hi, sum := math.Inf(1), math.Inf(-1), 0.0
+		peakAt := int64(0)
+		for i, v := range s.Values {
+			if i >= len(resp.Times) {
+				break
+			}
+			x := 0.0
+			if span > 0 {
+				x = float64(resp.Times[i]-t0) / span * reportChartWidth
+			}
+			y := reportChartHeight - (v-yMin)/(yMax-yMin)*reportChartHeight
+			fmt.Fprintf(&pts, "%.1f,%.1f ", x, y)
+			lo, sum = math.Min(lo, v), sum+v
+			if v > hi {
+				hi, peakAt = v, resp.Times[i]
+			}
+		}
+		avg := sum / float64(len(s.Values))
+		out.Series = append(out.Series, renderedSeries{
+			Name:   s.Name,
+			Color:  reportChartColors[si%len(reportChartColors)],
+			Points: strings.TrimSpace(pts.String()),
+			Min:    formatReportNumber(lo),
+			Avg:    formatReportNumber(avg),
+			Max:    formatReportNumber(hi),
+		})
+		if !busiest.set || hi > busiest.max {
+			busiest.max, busiest.avg, busiest.min, busiest.at, busiest.set = hi, avg, lo, peakAt, true
+		}
+	}
+	out.Narrative = expandNarrative(p.Narrative, map[string]string{
+		"min":       formatReportNumber(busiest.min),
+		"avg":       formatReportNumber(busiest.avg),
+		"max":       formatReportNumber(busiest.max),
+		"peak_time": time.UnixMilli(busiest.at).UTC().Format("2006-01-02 15:04:05"),
+	})
+	return out
+}
+
+// renderReportTemplate writes rt as a standalone HTML document. Print styles
+// put each section on its own page, so the browser's "Save as PDF" gives the
+// PDF deliverable.
+func renderReportTemplate(w io.Writer, df *DataFile, rt ReportTemplate, params url.Values, queries *queryStore, bookmarks *bookmarkStore, templates *diagnosticTemplateStore) error {
+	start, err := resolveQueryTime(df, params.Get("start"), params, bookmarks, false)
+	if err != nil {
+		return err
+	}
+	end, err := resolveQueryTime(df, params.Get("end"), params, bookmarks, true)
+	if err != nil {
+		return err
+	}
+	winStart, winEnd := df.StartTime, df.EndTime
+	if !start.IsZero() {
+		winStart = start
+	}
+	if !end.IsZero() {
+		winEnd = end
+	}
+	var hosts []string
+	for _, raw := range df.Columns {
+		if h := pdhHost(raw); h != "" {
+			hosts = appendUnique(hosts, h)
+		}
+	}
+	vars := map[string]string{
+		"file":  df.Label,
+		"host":  strings.Join(hosts, ", "),
+		"start": winStart.UTC().Format("2006-01-02 15:04:05"),
+		"end":   winEnd.UTC().Format("2006-01-02 15:04:05"),
+	}
+	view := renderedReport{
+		Title:     rt.Title,
+		Narrative: expandNarrative(rt.Narrative, vars),
+		File:      df.Label,
+		Window:    vars["start"] + " .. " + vars["end"] + " UTC",
+		Generated: time.Now().UTC().Format(time.RFC3339),
+	}
+
+	// One diagnostics pass covers every section's templates.
+	var diagIDs []string
+	for _, sec := range rt.Sections {
+		for _, id := range sec.Diagnostics {
+			diagIDs = appendUnique(diagIDs, id)
+		}
+	}
+	var findings []DiagnosticFinding
+	if len(diagIDs) > 0 {
+		selected, skipped := templates.byID(diagIDs)
+		if len(skipped) > 0 {
+			return errors.New(strings.Join(skipped, "; "))
+		}
+		run, err := runDiagnostics(df, selected, start, end)
+		if err != nil {
+			return err
+		}
+		findings = run.Findings
+	}
+
+	for _, sec := range rt.Sections {
+		rs := renderedSection{Title: sec.Title, Narrative: expandNarrative(sec.Narrative, vars), HasDiag: len(sec.Diagnostics) > 0}
+		for _, p := range sec.Panels {
+			rs.Panels = append(rs.Panels, renderPanel(df, p, params, queries, bookmarks))
+		}
+		for _, f := range findings {
+			for _, id := range sec.Diagnostics {
+				if f.flaggedBy(id) {
+					rs.Findings = append(rs.Findings, f)
+					break
+				}
+			}
+		}
+		view.Sections = append(view.Sections, rs)
+	}
+	return reportHTML.Execute(w, view)
+}
+
+var reportHTML = template.Must(template.New("report").Parse(`<!doctype html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>{{.Title}}</title>
+<style>
+body { font-family: -apple-system, "Segoe UI", Helvetica, Arial, sans-serif; color: #111827; margin: 32px auto; max-width: 800px; }
+h1 { margin-bottom: 4px; }
+.meta { color: #6b7280; font-size: 13px; margin-bottom: 16px; }
+section { margin-top: 32px; }
+.panel { margin: 16px 0 24px; }
+.panel h3 { margin: 0 0 6px; font-size: 15px; }
+svg { border: 1px solid #e5e7eb; background: #fff; }
+table { border-collapse: collapse; font-size: 12px; margin-top: 6px; width: 100%; }
+th, td { border-bottom: 1px solid #e5e7eb; padding: 3px 6px; text-align: left; }
+td.num { text-align: right; font-variant-numeric: tabular-nums; }
+.swatch { display: inline-block; width: 10px; height: 10px; margin-right: 6px; }
+.error { color: #b91c1c; }
+.finding { margin: 6px 0; }
+@media print {
+  body { margin: 0; max-width: none; }
+  section { page-break-before: always; }
+  .panel { page-break-inside: avoid; }
+}
+</style>
+</head>
+<body>
+<h1>{{.Title}}</h1>
+<div class="meta">{{.File}} &middot; {{.Window}} &middot; generated {{.Generated}}</div>
+{{if .Narrative}}<p>{{.Narrative}}</p>{{end}}
+{{range .Sections}}
+<section>
+<h2>{{.Title}}</h2>
+{{if .Narrative}}<p>{{.Narrative}}</p>{{end}}
+{{range .Panels}}
+<div class="panel">
+<h3>{{.Title}}{{if .Unit}} ({{.Unit}}){{end}}</h3>
+{{if .Error}}<p class="error">{{.Error}}</p>{{else}}
+<svg viewBox="0 0 720 200" width="720" height="200" preserveAspectRatio="none">
+{{range .Series}}<polyline fill="none" stroke="{{.Color}}" stroke-width="1.2" points="{{.Points}}"/>
+{{end}}</svg>
+<div class="meta">y axis {{.YMin}} .. {{.YMax}}</div>
+<table>
+<tr><th>Series</th><th>Min</th><th>Avg</th><th>Max</th></tr>
+{{range .Series}}<tr><td><span class="swatch" style="background: {{.Color}}"></span>{{.Name}}</td><td class="num">{{.Min}}</td><td class="num">{{.Avg}}</td><td class="num">{{.Max}}</td></tr>
+{{end}}</table>
+{{if .Narrative}}<p>{{.Narrative}}</p>{{end}}
+{{end}}
+</div>
+{{end}}
+{{if .HasDiag}}
+<h3>Findings</h3>
+{{range .Findings}}<div class="finding"><strong>[{{.Severity}}] {{.Title}}</strong> &mdash; {{.Summary}}</div>
+{{else}}<p>No findings.</p>
+{{end}}
+{{end}}
+</section>
+{{end}}
+</body>
+</html>
+`))
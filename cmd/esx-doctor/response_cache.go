@@ -0,0 +1,185 @@
+package main
+
+import (
+	"bytes"
+	"container/list"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// maxCachedResponse keeps one huge series read from evicting everything else.
+const maxCachedResponse = 8 << 20
+
+// responseCache is a small LRU of successful GET responses for read-only
+// endpoints (meta, catalog, series). Keys start with the session file's
+// fingerprint, so switching files or a rolling export growing simply stops
+// matching the old entries, which then age out.
+type responseCache struct {
+	mu       sync.Mutex
+	maxBytes int64
+	size     int64
+	order    *list.List
+	entries  map[string]*list.Element
+}
+
+type cachedResponse struct {
+	key         string
+	contentType string
+	body        []byte
+}
+
+func newResponseCache(maxBytes int64) *responseCache {
+	return &responseCache{maxBytes: maxBytes, order: list.New(), entries: map[string]*list.Element{}}
+}
+
+func (c *responseCache) get(key string) (*cachedResponse, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	el, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+	c.order.MoveToFront(el)
+	return el.Value.(*cachedResponse), true
+}
+
+func (c *responseCache) put(entry *cachedResponse) {
+	n := int64(len(entry.body))
+	if n > maxCachedResponse || n > c.maxBytes {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if el, ok := c.entries[entry.key]; ok {
+		c.size -= int64(len(el.Value.(*cachedResponse).body))
+		c.order.Remove(el)
+	}
+	c.entries[entry.key] = c.order.PushFront(entry)
+	c.size += n
+	for c.size > c.maxBytes {
+		el := c.order.Back()
+		old := el.Value.(*cachedResponse)
+		c.order.Remove(el)
+		delete(c.entries, old.key)
+		c.size -= int64(len(old.body))
+	}
+}
+
+// fingerprint identifies the file contents df was indexed from: path, size,
+// modification time and tail checksum of every part. It is computed once.
+func (df *DataFile) fingerprint() string {
+	df.fingerprintOnce.Do(func() {
+		h := sha256.New()
+		var buf [8]byte
+		num := func(v int64) {
+			binary.LittleEndian.PutUint64(buf[:], uint64(v))
+			h.Write(buf[:])
+		}
+		h.Write([]byte(df.Path))
+		num(df.Size)
+		num(df.ModTime.UnixNano())
+		num(int64(df.tailSum))
+		num(df.Rows)
+		num(df.DataEndOffset)
+		for _, p := range df.Parts {
+			h.Write([]byte(p.Path))
+			num(p.DataEnd)
+		}
+		df.fingerprintSum = hex.EncodeToString(h.Sum(nil)[:16])
+	})
+	return df.fingerprintSum
+}
+
+// cacheKey builds the key for r against df. Query parameters are sorted so
+// equivalent URLs share an entry; Accept is included because series can
+// answer in MessagePack.
+func cacheKey(df *DataFile, r *http.Request) string {
+	q := r.URL.Query()
+	names := make([]string, 0, len(q))
+	for k := range q {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	var b strings.Builder
+	b.WriteString(df.fingerprint())
+	b.WriteByte('|')
+	b.WriteString(r.URL.Path)
+	for _, k := range names {
+		vals := append([]string(nil), q[k]...)
+		sort.Strings(vals)
+		for _, v := range vals {
+			b.WriteByte('|')
+			b.WriteString(k)
+			b.WriteByte('=')
+			b.WriteString(v)
+		}
+	}
+	b.WriteString("|accept=")
+	b.WriteString(r.Header.Get("Accept"))
+	return b.String()
+}
+
+// captureWriter records a response while passing it through.
+type captureWriter struct {
+	http.ResponseWriter
+	status int
+	buf    bytes.Buffer
+}
+
+func (c *captureWriter) WriteHeader(status int) {
+	c.status = status
+	c.ResponseWriter.WriteHeader(status)
+}
+
+func (c *captureWriter) Write(b []byte) (int, error) {
+	if c.status == 0 {
+		c.status = http.StatusOK
+	}
+	if c.buf.Len() <= maxCachedResponse {
+		c.buf.Write(b)
+	}
+	return c.ResponseWriter.Write(b)
+}
+
+// wrap serves repeat GETs of next from the cache. Requests without a session
+// cookie are passed through: resolving their file here would start a second
+// session. Saved queries and bookmarks can be edited between requests, so
+// responses that depend on them are not cached either, nor debug requests,
+// whose trace would otherwise describe the first request instead of this one.
+func (c *responseCache) wrap(sessions *SessionStore, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if c == nil || r.Method != http.MethodGet || sessions.getSessionIDFromRequest(r) == "" {
+			next(w, r)
+			return
+		}
+		q := r.URL.Query()
+		if q.Get("query") != "" || q.Get("bookmark") != "" || parseTruthy(q.Get("debug")) {
+			next(w, r)
+			return
+		}
+		df := sessions.SessionForRequest(w, r).Get()
+		if df == nil {
+			next(w, r)
+			return
+		}
+		key := cacheKey(df, r)
+		if hit, ok := c.get(key); ok {
+			w.Header().Set("Content-Type", hit.contentType)
+			w.Header().Set("X-Cache", "hit")
+			w.WriteHeader(http.StatusOK)
+			_, _ = w.Write(hit.body)
+			return
+		}
+		w.Header().Set("X-Cache", "miss")
+		cw := &captureWriter{ResponseWriter: w}
+		next(cw, r)
+		if cw.status == http.StatusOK && cw.buf.Len() <= maxCachedResponse && w.Header().Get("Cache-Control") != "no-store" {
+			c.put(&cachedResponse{key: key, contentType: w.Header().Get("Content-Type"), body: cw.buf.Bytes()})
+		}
+	}
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// resxtop is esxtop run from another machine, against a host directly or
+// through vCenter (resxtop --server vc --vihost esx01). Its batch output is
+// esxtop's, except that the host part of the headers can name the server
+// it went through, and people tend to sample it less often to spare the
+// link. The headers are rewritten at index time to the plain
+// \\host\Object(instance)\Counter form, so the rest of esx-doctor (the
+// UI's column parser included) sees an ordinary capture:
+//
+//	\\vc01\esx01\Group Cpu(1:vm)\% Ready   path    (an extra segment)
+//	\\vc01:esx01\Group Cpu(1:vm)\% Ready   prefix  (also vc01/esx01)
+//	\\esx01:443\Group Cpu(1:vm)\% Ready    port
+//
+// The path form is the one that matters most: unrewritten, every column
+// would parse with the host as its object.
+
+// esxtopDefaultInterval is esxtop's -d default, what the templates'
+// sample-count thresholds were tuned for.
+const esxtopDefaultInterval = 5 * time.Second
+
+// RemoteCapture is the "remote" block of /api/meta, set when the header
+// shows a capture was taken with resxtop.
+type RemoteCapture struct {
+	Tool string `json:"tool"`
+	// Server is the vCenter (or other host) resxtop connected through.
+	Server string   `json:"server,omitempty"`
+	Hosts  []string `json:"hosts"`
+	// Form is how the headers named the host: path, prefix or port.
+	Form string `json:"form"`
+	// Normalized counts the rewritten columns.
+	Normalized int `json:"normalized"`
+}
+
+// normalizeRemoteHeader rewrites a resxtop header in place and describes
+// the capture, or returns nil (and leaves header alone) for local esxtop
+// output. header[0] is the time column and is not looked at.
+func normalizeRemoteHeader(header []string) *RemoteCapture {
+	var pdh []int
+	for i := 1; i < len(header); i++ {
+		if strings.HasPrefix(header[i], `\\`) {
+			pdh = append(pdh, i)
+		}
+	}
+	if len(pdh) == 0 {
+		return nil
+	}
+	rc := &RemoteCapture{Tool: "resxtop"}
+	hosts := map[string]bool{}
+
+	// Path form: every column has a segment too many, and the one where
+	// the object should be has no instance. A counter may contain a
+	// backslash, but not in every column of a capture.
+	path := true
+	for _, i := range pdh {
+		parts := strings.Split(header[i], `\`)
+		if len(parts) < 6 || strings.TrimSpace(parts[3]) == "" || strings.ContainsAny(parts[3], "()") {
+			path = false
+			break
+		}
+	}
+	if path {
+		rc.Form = "path"
+		for _, i := range pdh {
+			parts := strings.Split(header[i], `\`)
+			if rc.Server == "" {
+				rc.Server = strings.TrimSpace(parts[2])
+			}
+			host := strings.TrimSpace(parts[3])
+			hosts[host] = true
+			header[i] = `\\` + host + `\` + strings.Join(parts[4:], `\`)
+			rc.Normalized++
+		}
+	} else {
+		for _, i := range pdh {
+			raw := header[i]
+			end := strings.Index(raw[2:], `\`)
+			if end < 0 {
+				continue
+			}
+			server, host, form := splitRemoteHost(raw[2 : 2+end])
+			if form == "" {
+				continue
+			}
+			if rc.Form == "" {
+				rc.Form = form
+			}
+			if rc.Server == "" {
+				rc.Server = server
+			}
+			hosts[host] = true
+			header[i] = `\\` + host + raw[2+end:]
+			rc.Normalized++
+		}
+	}
+	if rc.Normalized == 0 {
+		return nil
+	}
+	rc.Hosts = make([]string, 0, len(hosts))
+	for h := range hosts {
+		rc.Hosts = append(rc.Hosts, h)
+	}
+	sort.Strings(rc.Hosts)
+	return rc
+}
+
+// splitRemoteHost splits the host part of a header into the server it was
+// reached through and the ESX host, with the form it was written in; form
+// is "" for a plain host name. IPv6 addresses are left alone.
+func splitRemoteHost(s string) (server, host, form string) {
+	s = strings.TrimSpace(s)
+	if i := strings.LastIndex(s, "/"); i > 0 && i < len(s)-1 {
+		return strings.TrimSpace(s[:i]), strings.TrimSpace(s[i+1:]), "prefix"
+	}
+	if strings.Count(s, ":") != 1 || strings.HasPrefix(s, "[") {
+		return "", s, ""
+	}
+	left, right, _ := strings.Cut(s, ":")
+	left, right = strings.TrimSpace(left), strings.TrimSpace(right)
+	if left == "" || right == "" {
+		return "", s, ""
+	}
+	if strings.Trim(right, "0123456789") == "" {
+		return "", left, "port"
+	}
+	return left, right, "prefix"
+}
+
+// remoteIntervalWarning notes when a resxtop capture was sampled more
+// coarsely than esxtop's default: streaks measured in samples then span
+// more time, and spikes shorter than an interval average out.
+func remoteIntervalWarning(df *DataFile) string {
+	if df.Remote == nil {
+		return ""
+	}
+	interval := df.SampleInterval()
+	if interval < esxtopDefaultInterval*3/2 {
+		return ""
+	}
+	return fmt.Sprintf("resxtop capture sampled every %s (esxtop's default is %s): min_consecutive streaks span %.0fx longer and spikes shorter than a sample average out",
+		interval.Round(time.Second), esxtopDefaultInterval, float64(interval)/float64(esxtopDefaultInterval))
+}
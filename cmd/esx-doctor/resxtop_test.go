@@ -0,0 +1,167 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSplitRemoteHost(t *testing.T) {
+	tests := []struct {
+		in                 string
+		server, host, form string
+	}{
+		{"esx01", "", "esx01", ""},
+		{"vc01/esx01", "vc01", "esx01", "prefix"},
+		{"vc01.corp.local/esx01.corp.local", "vc01.corp.local", "esx01.corp.local", "prefix"},
+		{"vc01:esx01", "vc01", "esx01", "prefix"},
+		{" vc01 : esx01 ", "vc01", "esx01", "prefix"},
+		{"esx01:443", "", "esx01", "port"},
+		{"10.0.0.5:443", "", "10.0.0.5", "port"},
+		{"fe80::1", "", "fe80::1", ""},
+		{"[fe80::1]:443", "", "[fe80::1]:443", ""},
+		{"esx01:", "", "esx01:", ""},
+		{"/esx01", "", "/esx01", ""},
+	}
+	for _, tt := range tests {
+		server, host, form := splitRemoteHost(tt.in)
+		if server != tt.server || host != tt.host || form != tt.form {
+			t.Errorf("splitRemoteHost(%q) = %q, %q, %q; want %q, %q, %q", tt.in, server, host, form, tt.server, tt.host, tt.form)
+		}
+	}
+}
+
+func TestNormalizeRemoteHeader(t *testing.T) {
+	const timeCol = "(PDH-CSV 4.0) (UTC)(0)"
+	tests := []struct {
+		name   string
+		header []string
+		want   []string
+		remote *RemoteCapture // nil: header left alone
+	}{
+		{
+			name: "path",
+			header: []string{timeCol,
+				`\\vc01\esx01\Group Cpu(1:vm-a)\% Ready`,
+				`\\vc01\esx02\Memory\Free MBytes`},
+			want: []string{timeCol,
+				`\\esx01\Group Cpu(1:vm-a)\% Ready`,
+				`\\esx02\Memory\Free MBytes`},
+			remote: &RemoteCapture{Tool: "resxtop", Server: "vc01", Hosts: []string{"esx01", "esx02"}, Form: "path", Normalized: 2},
+		},
+		{
+			name: "prefix",
+			header: []string{timeCol,
+				`\\vc01:esx01\Group Cpu(1:vm-a)\% Ready`,
+				`\\vc01/esx01\Memory\Free MBytes`},
+			want: []string{timeCol,
+				`\\esx01\Group Cpu(1:vm-a)\% Ready`,
+				`\\esx01\Memory\Free MBytes`},
+			remote: &RemoteCapture{Tool: "resxtop", Server: "vc01", Hosts: []string{"esx01"}, Form: "prefix", Normalized: 2},
+		},
+		{
+			name: "port",
+			header: []string{timeCol,
+				`\\esx01:443\Group Cpu(1:vm-a)\% Ready`,
+				`\\esx01:443\Memory\Free MBytes`},
+			want: []string{timeCol,
+				`\\esx01\Group Cpu(1:vm-a)\% Ready`,
+				`\\esx01\Memory\Free MBytes`},
+			remote: &RemoteCapture{Tool: "resxtop", Hosts: []string{"esx01"}, Form: "port", Normalized: 2},
+		},
+		{
+			name: "ipv6",
+			header: []string{timeCol,
+				`\\fe80::1\Group Cpu(1:vm-a)\% Ready`,
+				`\\[fe80::1]:443\Memory\Free MBytes`},
+		},
+		{
+			name: "local esxtop",
+			header: []string{timeCol,
+				`\\esx01\Group Cpu(1:vm-a)\% Ready`,
+				`\\esx01\Physical Cpu(_Total)\% Util Time`,
+				`\\esx01\Memory\Free MBytes`},
+		},
+		{
+			name:   "not pdh",
+			header: []string{"Time", "cpu", "mem"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			header := append([]string(nil), tt.header...)
+			got := normalizeRemoteHeader(header)
+			if !reflect.DeepEqual(got, tt.remote) {
+				t.Fatalf("remote = %+v, want %+v", got, tt.remote)
+			}
+			want := tt.want
+			if want == nil {
+				want = tt.header
+			}
+			if !reflect.DeepEqual(header, want) {
+				t.Errorf("header = %q, want %q", header, want)
+			}
+		})
+	}
+}
+
+// TestResxtopIndexRoundTrip indexes a gen-fixture -vcenter capture and
+// checks the rewritten headers and the remote block survive an exported
+// index.
+func TestResxtopIndexRoundTrip(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "remote.csv")
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	spec := FixtureSpec{
+		Host: "esx01", VCenter: "vc01", Start: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC),
+		Interval: 5 * time.Second, Samples: 20, VMs: 2, VCPUs: 2, PCPUs: 4, NUMANodes: 2,
+		Adapters: 1, NICs: 1, Seed: 1,
+	}
+	if _, err := writeFixture(f, spec); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	df, err := buildIndex(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if df.Remote == nil {
+		t.Fatal("capture not detected as resxtop")
+	}
+	want := RemoteCapture{Tool: "resxtop", Server: "vc01", Hosts: []string{"esx01"}, Form: "path", Normalized: len(df.Columns) - 1}
+	if !reflect.DeepEqual(*df.Remote, want) {
+		t.Errorf("remote = %+v, want %+v", *df.Remote, want)
+	}
+	for _, c := range df.Columns[1:] {
+		if !strings.HasPrefix(c, `\\esx01\`) || strings.Contains(c, "vc01") {
+			t.Fatalf("column %q was not normalized", c)
+		}
+	}
+	if df.Rows != int64(spec.Samples) {
+		t.Errorf("rows = %d, want %d", df.Rows, spec.Samples)
+	}
+
+	idxPath := sidecarIndexPath(path)
+	if err := exportIndex(df, idxPath); err != nil {
+		t.Fatal(err)
+	}
+	imported, err := importIndex(path, idxPath)
+	if err != nil {
+		t.Fatalf("importIndex: %v", err)
+	}
+	if !reflect.DeepEqual(imported.Columns, df.Columns) {
+		t.Error("imported columns differ from the indexed ones")
+	}
+	if !reflect.DeepEqual(imported.Remote, df.Remote) {
+		t.Errorf("imported remote = %+v, want %+v", imported.Remote, df.Remote)
+	}
+}
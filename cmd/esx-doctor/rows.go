@@ -0,0 +1,124 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+const (
+	defaultRowLimit = 20
+	maxRowLimit     = 500
+	// maxRowColumns keeps a careless request from echoing a whole
+	// 10,000-column row back for every line.
+	maxRowColumns = 200
+)
+
+type RowColumn struct {
+	Column    int    `json:"column"`
+	Name      string `json:"name"`
+	Attribute string `json:"attribute"`
+	Instance  string `json:"instance"`
+}
+
+// RawRow is one CSV record as read from disk. Raw holds the cells exactly as
+// written; Values the numbers they parse to, or null where they do not.
+type RawRow struct {
+	Time   int64      `json:"time"`
+	Stamp  string     `json:"stamp"`
+	Raw    []string   `json:"raw"`
+	Values []*float64 `json:"values"`
+	// Error is set for lines the CSV reader rejected; Raw is then empty.
+	Error string `json:"error,omitempty"`
+}
+
+type RowsResponse struct {
+	Columns []RowColumn `json:"columns"`
+	Rows    []RawRow    `json:"rows"`
+	// Next is the time of the row after the last one returned, to pass as
+	// start for the following page; zero at the end of the range.
+	Next  int64  `json:"next"`
+	Error string `json:"error,omitempty"`
+}
+
+// readRows returns up to limit consecutive rows from start (zero means the
+// beginning) up to end, with the cells of cols.
+func (df *DataFile) readRows(cols []int, start, end time.Time, limit int) (RowsResponse, error) {
+	out := RowsResponse{Columns: []RowColumn{}, Rows: []RawRow{}}
+	if len(cols) == 0 {
+		return out, fmt.Errorf("no columns selected")
+	}
+	if len(cols) > maxRowColumns {
+		return out, fmt.Errorf("%d columns selected; at most %d are allowed", len(cols), maxRowColumns)
+	}
+	for _, idx := range cols {
+		if idx <= 0 || idx >= len(df.Columns) {
+			return out, fmt.Errorf("column %d out of range", idx)
+		}
+		c := parsePDHColumnBackend(df.Columns[idx], idx)
+		out.Columns = append(out.Columns, RowColumn{Column: idx, Name: c.Raw, Attribute: c.AttributeLabel, Instance: c.Instance})
+	}
+	if limit <= 0 {
+		limit = defaultRowLimit
+	}
+	if limit > maxRowLimit {
+		limit = maxRowLimit
+	}
+
+	startOffset, _ := df.findOffset(start)
+	f, data, err := df.openData(startOffset)
+	if err != nil {
+		return out, err
+	}
+	defer f.Close()
+
+	lines := getLineReader(data)
+	defer lines.release()
+	for {
+		line, err := lines.next()
+		if err != nil && !errors.Is(err, io.EOF) {
+			return out, err
+		}
+		if len(line) == 0 && errors.Is(err, io.EOF) {
+			break
+		}
+		record, perr := lines.record(line)
+		if perr != nil {
+			// Without a timestamp a broken line can only be placed by
+			// its neighbours, so show it once the window has started.
+			if len(out.Rows) > 0 && len(out.Rows) < limit {
+				out.Rows = append(out.Rows, RawRow{Raw: []string{}, Values: []*float64{}, Error: perr.Error()})
+			}
+		} else if len(record) > 0 {
+			ts, _, terr := parseTimeValue(record[0])
+			if terr == nil {
+				if !end.IsZero() && ts.After(end) {
+					break
+				}
+				if start.IsZero() || !ts.Before(start) {
+					if len(out.Rows) == limit {
+						out.Next = ts.UnixMilli()
+						break
+					}
+					row := RawRow{Time: ts.UnixMilli(), Stamp: strings.Clone(record[0]), Raw: make([]string, len(cols)), Values: make([]*float64, len(cols))}
+					for i, idx := range cols {
+						if idx >= len(record) {
+							continue
+						}
+						row.Raw[i] = strings.Clone(record[idx])
+						if v, ok := parseFloatValue(record[idx]); ok && NumberFinite(v) {
+							row.Values[i] = &v
+						}
+					}
+					out.Rows = append(out.Rows, row)
+				}
+			}
+		}
+		if errors.Is(err, io.EOF) {
+			break
+		}
+	}
+	return out, nil
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const maxStoredRuns = 50
+
+// StoredRun is a diagnostics run kept in memory so it can be compared with
+// later runs (same file after template tuning, or another capture).
+type StoredRun struct {
+	ID          string                `json:"id"`
+	File        string                `json:"file"`
+	At          int64                 `json:"at"`
+	TemplateIDs []string              `json:"templateIds"`
+	Start       int64                 `json:"start,omitempty"`
+	End         int64                 `json:"end,omitempty"`
+	Result      DiagnosticRunResponse `json:"result"`
+}
+
+// runStore keeps the most recent runs, oldest evicted first.
+type runStore struct {
+	mu   sync.Mutex
+	seq  int64
+	runs []StoredRun
+}
+
+func (s *runStore) add(df *DataFile, selected []DiagnosticTemplate, start, end time.Time, result DiagnosticRunResponse) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.seq++
+	run := StoredRun{
+		ID:          fmt.Sprintf("run-%d", s.seq),
+		At:          time.Now().UnixMilli(),
+		TemplateIDs: make([]string, 0, len(selected)),
+		Start:       unixMilliOrZero(start),
+		End:         unixMilliOrZero(end),
+		Result:      result,
+	}
+	if df != nil {
+		run.File = df.Label
+	}
+	for _, t := range selected {
+		run.TemplateIDs = append(run.TemplateIDs, t.ID)
+	}
+	run.Result.RunID = run.ID
+	run.Result.Trace = nil
+	s.runs = append(s.runs, run)
+	if len(s.runs) > maxStoredRuns {
+		s.runs = s.runs[len(s.runs)-maxStoredRuns:]
+	}
+	return run.ID
+}
+
+func (s *runStore) get(id string) (StoredRun, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, run := range s.runs {
+		if run.ID == id {
+			return run, true
+		}
+	}
+	return StoredRun{}, false
+}
+
+// list returns runs newest first, without their findings.
+func (s *runStore) list() []StoredRun {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	out := make([]StoredRun, 0, len(s.runs))
+	for i := len(s.runs) - 1; i >= 0; i-- {
+		run := s.runs[i]
+		run.Result.Findings = nil
+		out = append(out, run)
+	}
+	return out
+}
+
+type FindingChange struct {
+	Base    DiagnosticFinding `json:"base"`
+	Target  DiagnosticFinding `json:"target"`
+	Changes []string          `json:"changes"`
+}
+
+type FindingsDiff struct {
+	Base      string              `json:"base"`
+	Target    string              `json:"target"`
+	New       []DiagnosticFinding `json:"new"`
+	Resolved  []DiagnosticFinding `json:"resolved"`
+	Changed   []FindingChange     `json:"changed"`
+	Unchanged int                 `json:"unchanged"`
+	Error     string              `json:"error,omitempty"`
+}
+
+// findingIdentity groups findings that describe the same rule firing on the
+// same counter; instances decide which of them pair up.
+func findingIdentity(f DiagnosticFinding) string {
+	return f.TemplateID + "|" + strings.ToLower(canonicalAttributeLabel(f.AttributeLabel))
+}
+
+func findingInstanceSet(f DiagnosticFinding) map[string]bool {
+	out := map[string]bool{}
+	for _, inst := range f.Instances {
+		inst = strings.TrimSpace(inst)
+		if inst != "" && !strings.HasPrefix(inst, "... and ") {
+			out[strings.ToLower(inst)] = true
+		}
+	}
+	return out
+}
+
+func instanceOverlap(a, b map[string]bool) int {
+	n := 0
+	for k := range a {
+		if b[k] {
+			n++
+		}
+	}
+	return n
+}
+
+// diffFindings pairs findings of the base and target runs. A pair needs the
+// same template and counter plus overlapping instances (or no instances on
+// either side); paired findings that differ in severity, instances, window
+// or summary are "changed". Unpaired findings are new or resolved.
+func diffFindings(base, target []DiagnosticFinding) FindingsDiff {
+	diff := FindingsDiff{New: []DiagnosticFinding{}, Resolved: []DiagnosticFinding{}, Changed: []FindingChange{}}
+	used := make([]bool, len(target))
+	for _, b := range base {
+		bSet := findingInstanceSet(b)
+		best, bestOverlap := -1, -1
+		for ti, t := range target {
+			if used[ti] || findingIdentity(t) != findingIdentity(b) {
+				continue
+			}
+			tSet := findingInstanceSet(t)
+			overlap := instanceOverlap(bSet, tSet)
+			if overlap == 0 && (len(bSet) > 0 || len(tSet) > 0) {
+				continue
+			}
+			if overlap > bestOverlap {
+				best, bestOverlap = ti, overlap
+			}
+		}
+		if best < 0 {
+			diff.Resolved = append(diff.Resolved, b)
+			continue
+		}
+		used[best] = true
+		t := target[best]
+		var changes []string
+		if !strings.EqualFold(formatSRSeverity(b.Severity), formatSRSeverity(t.Severity)) {
+			changes = append(changes, fmt.Sprintf("severity %s -> %s", formatSRSeverity(b.Severity), formatSRSeverity(t.Severity)))
+		}
+		bSet, tSet := findingInstanceSet(b), findingInstanceSet(t)
+		if len(bSet) != len(tSet) || instanceOverlap(bSet, tSet) != len(bSet) {
+			changes = append(changes, fmt.Sprintf("instances %d -> %d", len(bSet), len(tSet)))
+		}
+		if b.Start != t.Start || b.End != t.End {
+			changes = append(changes, "window")
+		}
+		if b.Summary != t.Summary && len(changes) == 0 {
+			changes = append(changes, "summary")
+		}
+		if len(changes) == 0 {
+			diff.Unchanged++
+			continue
+		}
+		diff.Changed = append(diff.Changed, FindingChange{Base: b, Target: t, Changes: changes})
+	}
+	for ti, t := range target {
+		if !used[ti] {
+			diff.New = append(diff.New, t)
+		}
+	}
+	sort.SliceStable(diff.Changed, func(i, j int) bool {
+		return diff.Changed[i].Target.Title < diff.Changed[j].Target.Title
+	})
+	return diff
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// serverOptions configure the http.Server. The zero-value http.Server has
+// no timeouts, so a client that trickles its headers holds a connection
+// (and a goroutine) forever; ReadHeaderTimeout is what stops that.
+// ReadTimeout and WriteTimeout cover whole requests and responses, which
+// for multi-gigabyte uploads and streamed exports can legitimately take
+// minutes, so they default to off.
+type serverOptions struct {
+	ReadHeaderTimeout time.Duration
+	ReadTimeout       time.Duration
+	WriteTimeout      time.Duration
+	IdleTimeout       time.Duration
+	MaxHeaderBytes    int
+	// MaxConns caps open connections; further clients wait to be
+	// accepted. 0 means no limit.
+	MaxConns int
+	// H2C serves HTTP/2 without TLS next to HTTP/1.1, for reverse proxies
+	// that speak h2c to their backends.
+	H2C bool
+}
+
+func (o serverOptions) newServer(h http.Handler) *http.Server {
+	srv := &http.Server{
+		Handler:           h,
+		ReadHeaderTimeout: o.ReadHeaderTimeout,
+		ReadTimeout:       o.ReadTimeout,
+		WriteTimeout:      o.WriteTimeout,
+		IdleTimeout:       o.IdleTimeout,
+		MaxHeaderBytes:    o.MaxHeaderBytes,
+	}
+	if o.H2C {
+		srv.Protocols = new(http.Protocols)
+		srv.Protocols.SetHTTP1(true)
+		srv.Protocols.SetUnencryptedHTTP2(true)
+	}
+	return srv
+}
+
+// listener applies MaxConns to ln.
+func (o serverOptions) listener(ln net.Listener) net.Listener {
+	if o.MaxConns <= 0 {
+		return ln
+	}
+	return &limitListener{Listener: ln, slots: make(chan struct{}, o.MaxConns), done: make(chan struct{})}
+}
+
+// limitListener stops accepting while every slot is taken by an open
+// connection; a slot frees when its connection is closed.
+type limitListener struct {
+	net.Listener
+	slots     chan struct{}
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+func (l *limitListener) Accept() (net.Conn, error) {
+	select {
+	case l.slots <- struct{}{}:
+	case <-l.done:
+		return nil, net.ErrClosed
+	}
+	c, err := l.Listener.Accept()
+	if err != nil {
+		<-l.slots
+		return nil, err
+	}
+	return &limitConn{Conn: c, release: func() { <-l.slots }}, nil
+}
+
+// Close also wakes an Accept waiting for a slot, so Shutdown is not held
+// up by a full server.
+func (l *limitListener) Close() error {
+	err := l.Listener.Close()
+	l.closeOnce.Do(func() { close(l.done) })
+	return err
+}
+
+type limitConn struct {
+	net.Conn
+	once    sync.Once
+	release func()
+}
+
+func (c *limitConn) Close() error {
+	err := c.Conn.Close()
+	c.once.Do(c.release)
+	return err
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// systemd passes activated sockets starting at fd 3 (SD_LISTEN_FDS_START).
+const systemdListenFDStart = 3
+
+// systemdListener returns the first socket handed over by systemd socket
+// activation, or nil when the process was not socket-activated.
+func systemdListener() (net.Listener, error) {
+	pid, err := strconv.Atoi(strings.TrimSpace(os.Getenv("LISTEN_PID")))
+	if err != nil || pid != os.Getpid() {
+		return nil, nil
+	}
+	n, err := strconv.Atoi(strings.TrimSpace(os.Getenv("LISTEN_FDS")))
+	if err != nil || n < 1 {
+		return nil, nil
+	}
+	_ = os.Unsetenv("LISTEN_PID")
+	_ = os.Unsetenv("LISTEN_FDS")
+	_ = os.Unsetenv("LISTEN_FDNAMES")
+	f := os.NewFile(uintptr(systemdListenFDStart), "LISTEN_FD_3")
+	if f == nil {
+		return nil, fmt.Errorf("systemd socket fd %d is not valid", systemdListenFDStart)
+	}
+	defer f.Close()
+	ln, err := net.FileListener(f)
+	if err != nil {
+		return nil, fmt.Errorf("systemd socket: %w", err)
+	}
+	return ln, nil
+}
+
+func writePIDFile(path string) error {
+	return os.WriteFile(path, []byte(strconv.Itoa(os.Getpid())+"\n"), 0o644)
+}
+
+// runService serves mux until SIGINT/SIGTERM, calling reload on every SIGHUP.
+// The listener comes from systemd when socket-activated, otherwise addr.
+func runService(mux http.Handler, addr, pidFile string, opts serverOptions, reload func()) error {
+	ln, err := systemdListener()
+	if err != nil {
+		return err
+	}
+	if ln == nil {
+		ln, err = net.Listen("tcp", addr)
+		if err != nil {
+			return err
+		}
+		log.Printf("esx-doctor service listening on %s", ln.Addr())
+	} else {
+		log.Printf("esx-doctor service using systemd socket %s", ln.Addr())
+	}
+
+	if strings.TrimSpace(pidFile) != "" {
+		if err := writePIDFile(pidFile); err != nil {
+			_ = ln.Close()
+			return fmt.Errorf("failed to write pid file: %w", err)
+		}
+		defer os.Remove(pidFile)
+	}
+
+	srv := opts.newServer(mux)
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- srv.Serve(opts.listener(ln))
+	}()
+
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, syscall.SIGHUP, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(sigCh)
+
+	for {
+		select {
+		case err := <-errCh:
+			if err == http.ErrServerClosed {
+				return nil
+			}
+			return err
+		case sig := <-sigCh:
+			if sig == syscall.SIGHUP {
+				log.Printf("SIGHUP received; reloading")
+				reload()
+				continue
+			}
+			log.Printf("%s received; shutting down", sig)
+			ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+			err := srv.Shutdown(ctx)
+			cancel()
+			return err
+		}
+	}
+}
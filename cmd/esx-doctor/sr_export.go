@@ -0,0 +1,121 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+const srTimeLayout = "2006-01-02 15:04:05 UTC"
+
+func formatSRTime(ms int64) string {
+	if ms <= 0 {
+		return "n/a"
+	}
+	return time.UnixMilli(ms).UTC().Format(srTimeLayout)
+}
+
+func srUnderline(title string) string {
+	return title + "\n" + strings.Repeat("-", len(title)) + "\n"
+}
+
+// renderSRNote lays findings out as the plain-text block support engineers
+// paste into VMware SR updates: problem statement, evidence, affected objects.
+func renderSRNote(df *DataFile, findings []DiagnosticFinding) string {
+	var b strings.Builder
+
+	counts := map[string]int{}
+	for _, f := range findings {
+		counts[strings.ToLower(formatSRSeverity(f.Severity))]++
+	}
+
+	b.WriteString(srUnderline("PROBLEM STATEMENT"))
+	capture := "the loaded capture"
+	if df != nil {
+		capture = filepath.Base(df.Label)
+	}
+	if len(findings) == 0 {
+		fmt.Fprintf(&b, "esx-doctor diagnostics found no issues in %s.\n", capture)
+	} else {
+		parts := make([]string, 0, 5)
+		for _, sev := range []string{"critical", "high", "medium", "low", "info"} {
+			if counts[sev] > 0 {
+				parts = append(parts, fmt.Sprintf("%d %s", counts[sev], sev))
+			}
+		}
+		fmt.Fprintf(&b, "esx-doctor diagnostics found %d finding(s) in %s (%s).\n", len(findings), capture, strings.Join(parts, ", "))
+	}
+	if df != nil {
+		fmt.Fprintf(&b, "Health score: %d/100.\n", healthScore(findings, unixMilliOrZero(df.StartTime), unixMilliOrZero(df.EndTime)))
+		fmt.Fprintf(&b, "Capture window: %s to %s (%d samples).\n", formatSRTime(unixMilliOrZero(df.StartTime)), formatSRTime(unixMilliOrZero(df.EndTime)), df.Rows)
+	}
+	b.WriteString("\n")
+
+	b.WriteString(srUnderline("EVIDENCE"))
+	if len(findings) == 0 {
+		b.WriteString("None.\n")
+	}
+	for i, f := range findings {
+		fmt.Fprintf(&b, "%d. [%s] %s\n", i+1, strings.ToUpper(formatSRSeverity(f.Severity)), f.Title)
+		end := formatSRTime(f.End)
+		if f.End <= 0 && f.Start > 0 {
+			end = "end of capture"
+		}
+		fmt.Fprintf(&b, "   Window:    %s to %s\n", formatSRTime(f.Start), end)
+		if f.AttributeLabel != "" {
+			fmt.Fprintf(&b, "   Counter:   %s\n", f.AttributeLabel)
+		}
+		if len(f.Instances) > 0 {
+			fmt.Fprintf(&b, "   Objects:   %s\n", strings.Join(f.Instances, ", "))
+		}
+		fmt.Fprintf(&b, "   Detail:    %s\n", f.Summary)
+		fmt.Fprintf(&b, "   Rule:      %s (%s)\n", f.TemplateName, f.TemplateID)
+		for _, a := range f.AlsoFlaggedBy {
+			fmt.Fprintf(&b, "   Also:      %s (%s)\n", a.TemplateName, a.TemplateID)
+		}
+	}
+	b.WriteString("\n")
+
+	b.WriteString(srUnderline("AFFECTED OBJECTS"))
+	objects := map[string][]string{}
+	for _, f := range findings {
+		for _, inst := range f.Instances {
+			inst = strings.TrimSpace(inst)
+			if inst == "" || strings.HasPrefix(inst, "... and ") {
+				continue
+			}
+			objects[inst] = appendUnique(objects[inst], f.Title)
+		}
+	}
+	if len(objects) == 0 {
+		b.WriteString("None.\n")
+	}
+	names := make([]string, 0, len(objects))
+	for name := range objects {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(&b, "- %s: %s\n", name, strings.Join(objects[name], "; "))
+	}
+	return b.String()
+}
+
+func formatSRSeverity(s string) string {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return "medium"
+	}
+	return s
+}
+
+func appendUnique(list []string, v string) []string {
+	for _, x := range list {
+		if x == v {
+			return list
+		}
+	}
+	return append(list, v)
+}
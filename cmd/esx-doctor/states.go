@@ -0,0 +1,261 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// StateThresholds decide when a VM sample counts as degraded. Group Cpu
+// percentages are summed over the VM's vCPUs, as esxtop reports them.
+type StateThresholds struct {
+	CPUReady  float64 `json:"cpuReady"`
+	CPUCostop float64 `json:"cpuCostop"`
+	SwapWait  float64 `json:"swapWait"`
+	StorageMs float64 `json:"storageMs"`
+}
+
+var defaultStateThresholds = StateThresholds{CPUReady: 10, CPUCostop: 3, SwapWait: 1, StorageMs: 20}
+
+// vmStates lists the reported states in output order. A sample can be in
+// several degraded states at once; "healthy" means none of them fired.
+var vmStates = []string{"healthy", "cpu-contended", "memory-pressured", "storage-slow"}
+
+type VMStateShare struct {
+	State   string  `json:"state"`
+	Samples int64   `json:"samples"`
+	Pct     float64 `json:"pct"`
+}
+
+type VMStateBreakdown struct {
+	VM       string         `json:"vm"`
+	Instance string         `json:"instance"`
+	Samples  int64          `json:"samples"`
+	States   []VMStateShare `json:"states"`
+	// Missing names states that could not be evaluated because the capture
+	// lacks their counters for this VM.
+	Missing []string `json:"missing"`
+
+	ready, costop, swapWait, disk []int
+	counts                        map[string]int64
+}
+
+type VMStatesResponse struct {
+	Start      time.Time           `json:"start"`
+	End        time.Time           `json:"end"`
+	Thresholds StateThresholds     `json:"thresholds"`
+	VMs        []*VMStateBreakdown `json:"vms"`
+}
+
+// parseStateThresholds reads cpu_ready, cpu_costop, swap_wait and
+// storage_ms overrides from q.
+func parseStateThresholds(q url.Values) (StateThresholds, error) {
+	t := defaultStateThresholds
+	for _, p := range []struct {
+		name string
+		dst  *float64
+	}{
+		{"cpu_ready", &t.CPUReady},
+		{"cpu_costop", &t.CPUCostop},
+		{"swap_wait", &t.SwapWait},
+		{"storage_ms", &t.StorageMs},
+	} {
+		raw := strings.TrimSpace(q.Get(p.name))
+		if raw == "" {
+			continue
+		}
+		v, err := strconv.ParseFloat(raw, 64)
+		if err != nil || !NumberFinite(v) {
+			return t, fmt.Errorf("invalid %s %q", p.name, raw)
+		}
+		*p.dst = v
+	}
+	return t, nil
+}
+
+// vmDisplayName strips the group ID from a Group Cpu instance ("100:vm1").
+func vmDisplayName(instance string) string {
+	if p := strings.Index(instance, ":"); p >= 0 {
+		return instance[p+1:]
+	}
+	return instance
+}
+
+// buildVMStates classifies every sample of each VM matching vm (all VMs when
+// empty) within [start, end] and reports the share of samples per state.
+func buildVMStates(df *DataFile, vm string, t StateThresholds, start, end time.Time) (VMStatesResponse, error) {
+	resp := VMStatesResponse{Start: df.StartTime, End: df.EndTime, Thresholds: t, VMs: []*VMStateBreakdown{}}
+	if !start.IsZero() {
+		resp.Start = start
+	}
+	if !end.IsZero() {
+		resp.End = end
+	}
+
+	byInstance := map[string]*VMStateBreakdown{}
+	byName := map[string]*VMStateBreakdown{}
+	vm = strings.TrimSpace(vm)
+	assign := func(attr string, field func(*VMStateBreakdown) *[]int) {
+		for _, idx := range df.resolveColumnsByAttribute(attr, nil) {
+			inst := parsePDHColumnBackend(df.Columns[idx], idx).Instance
+			if isSystemGroup(inst) {
+				continue
+			}
+			name := vmDisplayName(inst)
+			if vm != "" && !strings.EqualFold(vm, inst) && !strings.EqualFold(vm, name) {
+				continue
+			}
+			b := byInstance[inst]
+			if b == nil {
+				b = &VMStateBreakdown{VM: name, Instance: inst, counts: map[string]int64{}}
+				byInstance[inst] = b
+				byName[strings.ToLower(name)] = b
+				resp.VMs = append(resp.VMs, b)
+			}
+			*field(b) = append(*field(b), idx)
+		}
+	}
+	assign("Group Cpu: % Ready", func(b *VMStateBreakdown) *[]int { return &b.ready })
+	assign("Group Cpu: % CoStop", func(b *VMStateBreakdown) *[]int { return &b.costop })
+	assign("Group Cpu: % Swap Wait", func(b *VMStateBreakdown) *[]int { return &b.swapWait })
+	if len(resp.VMs) == 0 {
+		if vm != "" {
+			return resp, fmt.Errorf("no Group Cpu counters for VM %q", vm)
+		}
+		return resp, nil
+	}
+
+	// Virtual Disk instances are the VM name, optionally followed by the
+	// device ("vm1:scsi0:0").
+	for _, attr := range []string{"Virtual Disk: Average MilliSec/Read", "Virtual Disk: Average MilliSec/Write"} {
+		for _, idx := range df.resolveColumnsByAttribute(attr, nil) {
+			inst := parsePDHColumnBackend(df.Columns[idx], idx).Instance
+			name := inst
+			if p := strings.Index(name, ":"); p >= 0 {
+				name = name[:p]
+			}
+			if b := byName[strings.ToLower(name)]; b != nil {
+				b.disk = append(b.disk, idx)
+			}
+		}
+	}
+
+	if err := scanVMStates(df, resp.VMs, t, start, end); err != nil {
+		return resp, err
+	}
+	for _, b := range resp.VMs {
+		b.Missing = []string{}
+		if len(b.ready) == 0 && len(b.costop) == 0 {
+			b.Missing = append(b.Missing, "cpu-contended")
+		}
+		if len(b.swapWait) == 0 {
+			b.Missing = append(b.Missing, "memory-pressured")
+		}
+		if len(b.disk) == 0 {
+			b.Missing = append(b.Missing, "storage-slow")
+		}
+		b.States = make([]VMStateShare, 0, len(vmStates))
+		for _, state := range vmStates {
+			share := VMStateShare{State: state, Samples: b.counts[state]}
+			if b.Samples > 0 {
+				share.Pct = float64(share.Samples) / float64(b.Samples) * 100
+			}
+			b.States = append(b.States, share)
+		}
+	}
+	sort.SliceStable(resp.VMs, func(i, j int) bool {
+		return strings.ToLower(resp.VMs[i].VM) < strings.ToLower(resp.VMs[j].VM)
+	})
+	return resp, nil
+}
+
+// maxColumnValue returns the largest parseable value of idxs in record.
+func maxColumnValue(record []string, idxs []int) (float64, bool) {
+	best, found := 0.0, false
+	for _, idx := range idxs {
+		if idx >= len(record) {
+			continue
+		}
+		v, ok := parseFloatValue(record[idx])
+		if !ok || !NumberFinite(v) {
+			continue
+		}
+		if !found || v > best {
+			best, found = v, true
+		}
+	}
+	return best, found
+}
+
+func scanVMStates(df *DataFile, vms []*VMStateBreakdown, t StateThresholds, start, end time.Time) error {
+	startOffset, _ := df.findOffset(start)
+	f, data, err := df.openData(startOffset)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	lines := getLineReader(data)
+	defer lines.release()
+	for {
+		line, err := lines.next()
+		if err != nil && !errors.Is(err, io.EOF) {
+			return err
+		}
+		if len(line) == 0 && errors.Is(err, io.EOF) {
+			break
+		}
+		record, perr := lines.record(line)
+		if perr == nil && len(record) > 0 {
+			ts, _, terr := parseTimeValue(record[0])
+			if terr == nil {
+				if !end.IsZero() && ts.After(end) {
+					break
+				}
+				if start.IsZero() || !ts.Before(start) {
+					for _, b := range vms {
+						classifyVMSample(b, record, t)
+					}
+				}
+			}
+		}
+		if errors.Is(err, io.EOF) {
+			break
+		}
+	}
+	return nil
+}
+
+// classifyVMSample counts one row for b. Rows where none of the VM's
+// counters parse (VM powered off, short row) are not counted at all.
+func classifyVMSample(b *VMStateBreakdown, record []string, t StateThresholds) {
+	ready, okReady := maxColumnValue(record, b.ready)
+	costop, okCostop := maxColumnValue(record, b.costop)
+	swap, okSwap := maxColumnValue(record, b.swapWait)
+	disk, okDisk := maxColumnValue(record, b.disk)
+	if !okReady && !okCostop && !okSwap && !okDisk {
+		return
+	}
+	b.Samples++
+	degraded := false
+	if (okReady && ready > t.CPUReady) || (okCostop && costop > t.CPUCostop) {
+		b.counts["cpu-contended"]++
+		degraded = true
+	}
+	if okSwap && swap > t.SwapWait {
+		b.counts["memory-pressured"]++
+		degraded = true
+	}
+	if okDisk && disk > t.StorageMs {
+		b.counts["storage-slow"]++
+		degraded = true
+	}
+	if !degraded {
+		b.counts["healthy"]++
+	}
+}
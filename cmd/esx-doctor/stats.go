@@ -0,0 +1,141 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"math"
+	"math/rand/v2"
+	"slices"
+	"time"
+)
+
+type ColumnStats struct {
+	Column int     `json:"column"`
+	Name   string  `json:"name"`
+	Count  int64   `json:"count"`
+	Min    float64 `json:"min"`
+	Max    float64 `json:"max"`
+	Mean   float64 `json:"mean"`
+	StdDev float64 `json:"stddev"`
+	P95    float64 `json:"p95"`
+	// Last is the last parsable value in the range, sampled at LastTime.
+	Last     float64 `json:"last"`
+	LastTime int64   `json:"lastTime,omitempty"`
+}
+
+type StatsResponse struct {
+	Start int64         `json:"start,omitempty"`
+	End   int64         `json:"end,omitempty"`
+	Stats []ColumnStats `json:"stats"`
+	Error string        `json:"error,omitempty"`
+}
+
+const (
+	// maxStatsColumns bounds one /api/stats request.
+	maxStatsColumns = 2000
+	// statsReservoir is how many values per column p95 is computed from;
+	// beyond that it is estimated from a uniform sample of the range.
+	statsReservoir = 20000
+)
+
+// computeColumnStats makes one pass over rows in [start, end] (zero means
+// unbounded) and returns count/min/max/mean/stddev/p95/last for each
+// requested column. The standard deviation is the population one,
+// accumulated with Welford's method so long captures do not lose
+// precision.
+func computeColumnStats(df *DataFile, cols []int, start, end time.Time) ([]ColumnStats, error) {
+	out := make([]ColumnStats, len(cols))
+	sums := make([]float64, len(cols))
+	m2 := make([]float64, len(cols))
+	samples := make([][]float64, len(cols))
+	// A fixed seed keeps the estimate, and so cached responses, stable.
+	rng := rand.New(rand.NewPCG(1, 2))
+	for i, idx := range cols {
+		out[i] = ColumnStats{Column: idx, Min: math.Inf(1), Max: math.Inf(-1)}
+		if idx >= 0 && idx < len(df.Columns) {
+			out[i].Name = df.Columns[idx]
+		}
+	}
+
+	startOffset, _ := df.findOffset(start)
+	f, data, err := df.openData(startOffset)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	lines := getLineReader(data)
+	defer lines.release()
+	for {
+		line, err := lines.next()
+		if err != nil && !errors.Is(err, io.EOF) {
+			return nil, err
+		}
+		if len(line) == 0 && errors.Is(err, io.EOF) {
+			break
+		}
+		record, perr := lines.record(line)
+		if perr == nil && len(record) > 0 {
+			ts, _, terr := parseTimeValue(record[0])
+			if terr == nil {
+				if !end.IsZero() && ts.After(end) {
+					break
+				}
+				if start.IsZero() || !ts.Before(start) {
+					for i, idx := range cols {
+						if idx <= 0 || idx >= len(record) {
+							continue
+						}
+						v, ok := parseFloatValue(record[idx])
+						if !ok || !NumberFinite(v) {
+							continue
+						}
+						s := &out[i]
+						s.Count++
+						sums[i] += v
+						delta := v - s.Mean
+						s.Mean += delta / float64(s.Count)
+						m2[i] += delta * (v - s.Mean)
+						s.Last, s.LastTime = v, ts.UnixMilli()
+						if len(samples[i]) < statsReservoir {
+							samples[i] = append(samples[i], v)
+						} else if j := rng.Int64N(s.Count); j < statsReservoir {
+							samples[i][j] = v
+						}
+						if v < s.Min {
+							s.Min = v
+						}
+						if v > s.Max {
+							s.Max = v
+						}
+					}
+				}
+			}
+		}
+		if errors.Is(err, io.EOF) {
+			break
+		}
+	}
+
+	for i := range out {
+		if out[i].Count == 0 {
+			out[i].Min, out[i].Max = 0, 0
+			continue
+		}
+		out[i].Mean = sums[i] / float64(out[i].Count)
+		out[i].StdDev = math.Sqrt(m2[i] / float64(out[i].Count))
+		out[i].P95 = percentile(samples[i], 0.95)
+	}
+	return out, nil
+}
+
+// percentile returns the p-th quantile of values (sorted in place), using
+// the nearest-rank method so the result is always an observed value.
+func percentile(values []float64, p float64) float64 {
+	if len(values) == 0 {
+		return 0
+	}
+	slices.Sort(values)
+	rank := int(math.Ceil(p*float64(len(values)))) - 1
+	return values[min(max(rank, 0), len(values)-1)]
+}
@@ -0,0 +1,244 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// StitchPart is one file of a rotated capture. VirtualStart is where its
+// data rows begin in the stitched DataFile's offset space, which is the
+// parts' data regions laid end to end.
+type StitchPart struct {
+	Path string
+	// Label is the part's own file name; Path is a decompressed copy when
+	// the part is a .gz.
+	Label        string
+	DataStart    int64
+	DataEnd      int64
+	VirtualStart int64
+	// needsNewline is set when the part's last row has no line terminator,
+	// so one is supplied before the next part begins.
+	needsNewline bool
+}
+
+func (p StitchPart) length() int64 {
+	n := p.DataEnd - p.DataStart
+	if p.needsNewline {
+		n++
+	}
+	return n
+}
+
+var rotationDigits = regexp.MustCompile(`\d+`)
+
+// rotationSiblings finds the files rotated alongside path: same directory,
+// same name except for the last run of digits (capture-01.csv,
+// capture-02.csv, ...). The result includes path and is sorted by name.
+func rotationSiblings(path string) ([]string, error) {
+	dir, base := filepath.Split(path)
+	locs := rotationDigits.FindAllStringIndex(base, -1)
+	if len(locs) == 0 {
+		return []string{path}, nil
+	}
+	last := locs[len(locs)-1]
+	pattern := regexp.MustCompile("^" + regexp.QuoteMeta(base[:last[0]]) + `\d+` + regexp.QuoteMeta(base[last[1]:]) + "$")
+	if dir == "" {
+		dir = "."
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var out []string
+	for _, e := range entries {
+		if !e.IsDir() && pattern.MatchString(e.Name()) {
+			out = append(out, filepath.Join(dir, e.Name()))
+		}
+	}
+	sort.Strings(out)
+	return out, nil
+}
+
+// stitchCapture indexes every rotation sibling of path and joins the ones
+// whose header matches path's into one continuous DataFile, ordered by
+// their first timestamp rather than their names. Siblings with a different
+// header belong to another capture and are reported in skipped.
+func stitchCapture(path string) (*DataFile, []string, error) {
+	paths, err := rotationSiblings(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	first, err := loadOrBuildIndex(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	parts := []*DataFile{first}
+	var skipped []string
+	for _, p := range paths {
+		if p == path {
+			continue
+		}
+		df, err := loadOrBuildIndex(p)
+		if err != nil {
+			skipped = append(skipped, fmt.Sprintf("%s: %v", filepath.Base(p), err))
+			continue
+		}
+		if !sameColumns(df.Columns, first.Columns) {
+			skipped = append(skipped, fmt.Sprintf("%s: header differs", filepath.Base(p)))
+			continue
+		}
+		parts = append(parts, df)
+	}
+	if len(parts) == 1 {
+		return first, skipped, nil
+	}
+	sort.SliceStable(parts, func(i, j int) bool {
+		return parts[i].StartTime.Before(parts[j].StartTime)
+	})
+	out, err := joinDataFiles(parts)
+	return out, skipped, err
+}
+
+func sameColumns(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// joinDataFiles concatenates indexed parts (already in time order) into a
+// DataFile whose offsets address the parts' data regions end to end.
+func joinDataFiles(parts []*DataFile) (*DataFile, error) {
+	out := &DataFile{
+		Path:       parts[0].Path,
+		Label:      fmt.Sprintf("%s (+%d rotated)", parts[0].Label, len(parts)-1),
+		Columns:    parts[0].Columns,
+		TimeLayout: parts[0].TimeLayout,
+		StartTime:  parts[0].StartTime,
+		Truncated:  parts[len(parts)-1].Truncated,
+		Remote:     parts[0].Remote,
+	}
+	var virtual, rows int64
+	for _, p := range parts {
+		part := StitchPart{Path: p.Path, Label: p.Label, DataStart: p.DataStartOffset, DataEnd: p.DataEndOffset, VirtualStart: virtual}
+		if p.DataEndOffset > p.DataStartOffset {
+			nl, err := endsWithNewline(p.Path, p.DataEndOffset)
+			if err != nil {
+				return nil, err
+			}
+			part.needsNewline = !nl
+		}
+		for _, e := range p.Index {
+			out.Index = append(out.Index, IndexEntry{Row: rows + e.Row, Offset: virtual + e.Offset - p.DataStartOffset, Time: e.Time})
+		}
+		out.Parts = append(out.Parts, part)
+		virtual += part.length()
+		rows += p.Rows
+		if !p.EndTime.IsZero() {
+			out.EndTime = p.EndTime
+		}
+		out.MalformedLines += p.MalformedLines
+		out.BadTimestamps += p.BadTimestamps
+		out.ShortRows += p.ShortRows
+		out.LenientLines += p.LenientLines
+		out.Size += p.Size
+		if p.ModTime.After(out.ModTime) {
+			out.ModTime = p.ModTime
+		}
+	}
+	out.Rows = rows
+	out.DataEndOffset = virtual
+	out.Provenance = Provenance{
+		Source:    "stitch",
+		Origin:    parts[0].Label,
+		Index:     "built",
+		IndexedAt: time.Now().UnixMilli(),
+		Stride:    parts[0].Provenance.Stride,
+	}
+	for _, p := range parts {
+		out.Provenance.IndexDurationMs += p.Provenance.IndexDurationMs
+		if p.Provenance.Index == "sidecar" {
+			out.Provenance.Index = "sidecar"
+		}
+	}
+	return out, nil
+}
+
+func endsWithNewline(path string, end int64) (bool, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+	var b [1]byte
+	if _, err := f.ReadAt(b[:], end-1); err != nil {
+		return false, err
+	}
+	return b[0] == '\n', nil
+}
+
+// partFiles closes every file opened for a stitched read.
+type partFiles []*os.File
+
+func (p partFiles) Close() error {
+	var first error
+	for _, f := range p {
+		if err := f.Close(); err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
+
+// openStitched returns the stitched data from virtual offset on.
+func (df *DataFile) openStitched(offset int64) (io.Closer, io.Reader, error) {
+	var files partFiles
+	var readers []io.Reader
+	for _, p := range df.Parts {
+		partEnd := p.VirtualStart + p.length()
+		if offset >= partEnd {
+			continue
+		}
+		f, err := os.Open(p.Path)
+		if err != nil {
+			_ = files.Close()
+			return nil, nil, err
+		}
+		files = append(files, f)
+		skip := int64(0)
+		if offset > p.VirtualStart {
+			skip = offset - p.VirtualStart
+		}
+		dataLen := p.DataEnd - p.DataStart
+		if skip < dataLen {
+			readers = append(readers, io.NewSectionReader(f, p.DataStart+skip, dataLen-skip))
+		}
+		if p.needsNewline {
+			readers = append(readers, strings.NewReader("\n"))
+		}
+	}
+	return files, io.MultiReader(readers...), nil
+}
+
+// partNames lists the stitched files in time order, or nil for a single file.
+func (df *DataFile) partNames() []string {
+	if len(df.Parts) == 0 {
+		return nil
+	}
+	out := make([]string, 0, len(df.Parts))
+	for _, p := range df.Parts {
+		out = append(out, filepath.Base(p.Label))
+	}
+	return out
+}
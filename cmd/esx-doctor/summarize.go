@@ -0,0 +1,266 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+type CaptureConsumer struct {
+	Instance string  `json:"instance"`
+	Mean     float64 `json:"mean"`
+	Max      float64 `json:"max"`
+}
+
+type CaptureConsumerGroup struct {
+	Category  string            `json:"category"`
+	Attribute string            `json:"attribute"`
+	Top       []CaptureConsumer `json:"top"`
+}
+
+type CaptureSummary struct {
+	File            string                 `json:"file"`
+	Hosts           []string               `json:"hosts"`
+	Start           time.Time              `json:"start"`
+	End             time.Time              `json:"end"`
+	DurationSeconds float64                `json:"durationSeconds"`
+	IntervalSeconds float64                `json:"intervalSeconds"`
+	Rows            int64                  `json:"rows"`
+	Columns         int                    `json:"columns"`
+	VMs             int                    `json:"vms"`
+	MalformedLines  int64                  `json:"malformedLines"`
+	BadTimestamps   int64                  `json:"badTimestamps"`
+	ShortRows       int64                  `json:"shortRows"`
+	LenientLines    int64                  `json:"lenientLines"`
+	Truncated       bool                   `json:"truncated"`
+	Remote          *RemoteCapture         `json:"remote,omitempty"`
+	Consumers       []CaptureConsumerGroup `json:"consumers"`
+}
+
+// summaryConsumerAttributes lists, per category, the attributes tried in
+// order to rank top consumers; the first one present in the capture is used.
+var summaryConsumerAttributes = []struct {
+	category   string
+	attributes []string
+}{
+	{"cpu", []string{"Group Cpu: % Used", "Vcpu: % Used"}},
+	{"memory", []string{"Group Memory: Memory Granted MBytes", "Group Memory: Touched MBytes", "Group Memory: Memory Size MBytes"}},
+	{"storage", []string{"Physical Disk SCSI Device: Commands/sec", "Physical Disk Adapter: Commands/sec", "Physical Disk SCSI Device: Average Driver MilliSec/Command"}},
+}
+
+// esxtop system groups that show up as Group Cpu instances next to VMs.
+var systemGroupNames = []string{"system", "idle", "helper", "drivers", "ft", "vmotion", "vmkapimod", "vmkboot", "user", "ovs", "netsched", "storagerm", "visorfs", "init", "sh", "sshd", "vpxa", "hostd", "dcui"}
+
+func isSystemGroup(instance string) bool {
+	name := instance
+	if p := strings.Index(name, ":"); p >= 0 {
+		name = name[p+1:]
+	}
+	name = strings.ToLower(strings.TrimSpace(name))
+	for _, sys := range systemGroupNames {
+		if name == sys || strings.HasPrefix(name, sys+".") {
+			return true
+		}
+	}
+	return false
+}
+
+func pdhHost(raw string) string {
+	if !strings.HasPrefix(raw, "\\\\") {
+		return ""
+	}
+	parts := strings.Split(raw, "\\")
+	if len(parts) < 3 {
+		return ""
+	}
+	return strings.TrimSpace(parts[2])
+}
+
+func summarizeCapture(df *DataFile, topN int) (CaptureSummary, error) {
+	sum := CaptureSummary{
+		File:           df.Label,
+		Start:          df.StartTime,
+		End:            df.EndTime,
+		Rows:           df.Rows,
+		Columns:        len(df.Columns),
+		MalformedLines: df.MalformedLines,
+		BadTimestamps:  df.BadTimestamps,
+		ShortRows:      df.ShortRows,
+		LenientLines:   df.LenientLines,
+		Truncated:      df.Truncated,
+		Remote:         df.Remote,
+		Hosts:          []string{},
+		Consumers:      []CaptureConsumerGroup{},
+	}
+	if !df.StartTime.IsZero() && !df.EndTime.IsZero() {
+		sum.DurationSeconds = df.EndTime.Sub(df.StartTime).Seconds()
+	}
+	sum.IntervalSeconds = df.SampleInterval().Seconds()
+
+	hosts := map[string]bool{}
+	vms := map[string]bool{}
+	byAttr := map[string][]parsedColumn{}
+	for i, raw := range df.Columns {
+		if i == 0 {
+			continue
+		}
+		if h := pdhHost(raw); h != "" {
+			hosts[h] = true
+		}
+		c := parsePDHColumnBackend(raw, i)
+		if strings.EqualFold(c.Object, "Group Cpu") && !isSystemGroup(c.Instance) {
+			vms[c.Instance] = true
+		}
+		key := strings.ToLower(canonicalAttributeLabel(c.AttributeLabel))
+		byAttr[key] = append(byAttr[key], c)
+	}
+	for h := range hosts {
+		sum.Hosts = append(sum.Hosts, h)
+	}
+	sort.Strings(sum.Hosts)
+	sum.VMs = len(vms)
+
+	var statCols []int
+	type pick struct {
+		category, attribute string
+		cols                []parsedColumn
+	}
+	var picks []pick
+	for _, cat := range summaryConsumerAttributes {
+		for _, attr := range cat.attributes {
+			cols := byAttr[strings.ToLower(canonicalAttributeLabel(attr))]
+			if len(cols) == 0 {
+				continue
+			}
+			picks = append(picks, pick{cat.category, attr, cols})
+			for _, c := range cols {
+				statCols = append(statCols, c.Idx)
+			}
+			break
+		}
+	}
+	if len(statCols) == 0 {
+		return sum, nil
+	}
+	stats, err := computeColumnStats(df, statCols, time.Time{}, time.Time{})
+	if err != nil {
+		return sum, err
+	}
+	byCol := make(map[int]ColumnStats, len(stats))
+	for _, s := range stats {
+		byCol[s.Column] = s
+	}
+	for _, p := range picks {
+		g := CaptureConsumerGroup{Category: p.category, Attribute: p.attribute}
+		for _, c := range p.cols {
+			s := byCol[c.Idx]
+			if s.Count == 0 || (p.category == "cpu" && isSystemGroup(c.Instance)) {
+				continue
+			}
+			g.Top = append(g.Top, CaptureConsumer{Instance: c.Instance, Mean: s.Mean, Max: s.Max})
+		}
+		sort.Slice(g.Top, func(i, j int) bool { return g.Top[i].Mean > g.Top[j].Mean })
+		if len(g.Top) > topN {
+			g.Top = g.Top[:topN]
+		}
+		sum.Consumers = append(sum.Consumers, g)
+	}
+	return sum, nil
+}
+
+func writeSummaryText(w io.Writer, s CaptureSummary) {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(tw, "File\t%s\n", s.File)
+	hosts := strings.Join(s.Hosts, ", ")
+	if hosts == "" {
+		hosts = "unknown"
+	}
+	fmt.Fprintf(tw, "Host\t%s\n", hosts)
+	if s.Remote != nil {
+		via := ""
+		if s.Remote.Server != "" {
+			via = " via " + s.Remote.Server
+		}
+		fmt.Fprintf(tw, "Captured\tremotely (%s%s)\n", s.Remote.Tool, via)
+	}
+	if !s.Start.IsZero() {
+		fmt.Fprintf(tw, "Start\t%s\n", s.Start.Format(time.RFC3339))
+		fmt.Fprintf(tw, "End\t%s\n", s.End.Format(time.RFC3339))
+	}
+	fmt.Fprintf(tw, "Duration\t%s\n", (time.Duration(s.DurationSeconds) * time.Second).String())
+	fmt.Fprintf(tw, "Interval\t%.1fs\n", s.IntervalSeconds)
+	fmt.Fprintf(tw, "Rows\t%d\n", s.Rows)
+	fmt.Fprintf(tw, "Columns\t%d\n", s.Columns)
+	fmt.Fprintf(tw, "VMs\t%d\n", s.VMs)
+	fmt.Fprintf(tw, "Malformed lines\t%d\n", s.MalformedLines)
+	fmt.Fprintf(tw, "Bad timestamps\t%d\n", s.BadTimestamps)
+	fmt.Fprintf(tw, "Short rows\t%d\n", s.ShortRows)
+	fmt.Fprintf(tw, "Lenient-quoted lines\t%d\n", s.LenientLines)
+	fmt.Fprintf(tw, "Truncated\t%t\n", s.Truncated)
+	_ = tw.Flush()
+	for _, g := range s.Consumers {
+		fmt.Fprintf(w, "\nTop %s consumers (%s)\n", g.Category, g.Attribute)
+		tw = tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(tw, "INSTANCE\tMEAN\tMAX")
+		for _, c := range g.Top {
+			fmt.Fprintf(tw, "%s\t%.2f\t%.2f\n", c.Instance, c.Mean, c.Max)
+		}
+		_ = tw.Flush()
+	}
+}
+
+// runSummarize implements `esx-doctor summarize [-json] [-top N] [-rebuild-index] <file.csv>`.
+func runSummarize(args []string) int {
+	fs := flag.NewFlagSet("summarize", flag.ContinueOnError)
+	asJSON := fs.Bool("json", false, "Print the summary as JSON")
+	topN := fs.Int("top", 5, "Number of top consumers to list per category")
+	fs.BoolVar(&rebuildIndexes, "rebuild-index", false, "Ignore the capture's x.idx.json and rewrite it from a fresh scan")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: esx-doctor summarize [-json] [-top N] [-rebuild-index] <file.csv>")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return 2
+	}
+	if *topN < 0 {
+		fmt.Fprintf(os.Stderr, "invalid -top %d: must be 0 or more\n", *topN)
+		return 2
+	}
+	path, err := filepath.Abs(fs.Arg(0))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "invalid path: %v\n", err)
+		return 1
+	}
+	df, err := loadOrBuildIndex(path)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "index build failed: %v\n", err)
+		return 1
+	}
+	sum, err := summarizeCapture(df, *topN)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "summary failed: %v\n", err)
+		return 1
+	}
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.SetEscapeHTML(false)
+		if err := enc.Encode(sum); err != nil {
+			return 1
+		}
+		return 0
+	}
+	writeSummaryText(os.Stdout, sum)
+	return 0
+}
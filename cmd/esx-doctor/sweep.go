@@ -0,0 +1,108 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+// maxSweepPoints bounds the grid: every point is one more processor in the
+// same pass, so a large grid costs CPU rather than extra reads.
+const maxSweepPoints = 100
+
+type SweepPoint struct {
+	Threshold      float64 `json:"threshold"`
+	MinConsecutive int     `json:"minConsecutive"`
+	Findings       int     `json:"findings"`
+	Instances      int     `json:"instances"`
+	// FlaggedSeconds sums the time covered by the findings, so a threshold
+	// that keeps the count but shortens every episode is still visible.
+	FlaggedSeconds float64 `json:"flaggedSeconds"`
+	// Capped means the detector hit its per-run finding limit, so the real
+	// count at this point is at least Findings.
+	Capped bool `json:"capped,omitempty"`
+}
+
+type SweepResponse struct {
+	TemplateID   string       `json:"templateId"`
+	TemplateName string       `json:"templateName"`
+	Points       []SweepPoint `json:"points"`
+	RowsScanned  int64        `json:"rowsScanned"`
+	DurationMs   int64        `json:"durationMs"`
+	Warnings     []string     `json:"warnings,omitempty"`
+	Error        string       `json:"error,omitempty"`
+}
+
+// sweepTemplate runs t once per combination of thresholds and
+// minConsecutive (an empty list keeps the template's own value) in a
+// single scan of [start, end] and reports the findings at each point.
+func sweepTemplate(df *DataFile, t DiagnosticTemplate, thresholds []float64, minConsecutive []int, start, end time.Time) (SweepResponse, error) {
+	resp := SweepResponse{TemplateID: t.ID, TemplateName: t.Name, Points: []SweepPoint{}}
+	if len(thresholds) == 0 {
+		thresholds = []float64{t.Detector.Threshold}
+	}
+	if len(minConsecutive) == 0 {
+		minConsecutive = []int{t.Detector.MinConsecutive}
+	}
+	for _, v := range thresholds {
+		if !NumberFinite(v) || v < 0 {
+			return resp, fmt.Errorf("invalid threshold %v", v)
+		}
+	}
+	for _, v := range minConsecutive {
+		if v < 0 {
+			return resp, fmt.Errorf("invalid min_consecutive %d", v)
+		}
+	}
+	if n := len(thresholds) * len(minConsecutive); n > maxSweepPoints {
+		return resp, fmt.Errorf("sweep has %d points; at most %d are allowed", n, maxSweepPoints)
+	}
+
+	var variants []DiagnosticTemplate
+	for _, mc := range minConsecutive {
+		for _, th := range thresholds {
+			v := t
+			v.ID = fmt.Sprintf("%s@%d", t.ID, len(variants))
+			v.Detector.Threshold = th
+			v.Detector.MinConsecutive = mc
+			variants = append(variants, v)
+			resp.Points = append(resp.Points, SweepPoint{Threshold: th, MinConsecutive: mc})
+		}
+	}
+	run, err := runDiagnostics(df, variants, start, end)
+	if err != nil {
+		return resp, err
+	}
+	byID := make(map[string]int, len(variants))
+	for i, v := range variants {
+		byID[v.ID] = i
+	}
+	instances := make([]map[string]bool, len(variants))
+	for _, f := range run.Findings {
+		i, ok := byID[f.TemplateID]
+		if !ok {
+			continue
+		}
+		p := &resp.Points[i]
+		p.Findings++
+		if f.End > f.Start {
+			p.FlaggedSeconds += float64(f.End-f.Start) / 1000
+		}
+		if instances[i] == nil {
+			instances[i] = map[string]bool{}
+		}
+		for _, inst := range f.Instances {
+			instances[i][inst] = true
+		}
+	}
+	for i := range resp.Points {
+		resp.Points[i].Instances = len(instances[i])
+		resp.Points[i].Capped = resp.Points[i].Findings >= 20
+	}
+	resp.RowsScanned = run.RowsScanned
+	resp.DurationMs = run.DurationMs
+	// Every variant shares the template's name, so warnings repeat.
+	for _, w := range run.Warnings {
+		resp.Warnings = appendUnique(resp.Warnings, w)
+	}
+	return resp, nil
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// templatePrefStore keeps each analyst's own default checklist: per
+// template, whether it runs when no templates are named, overriding the
+// template's Enabled flag. Users identified by -user-header keep theirs on
+// disk; anonymous sessions keep theirs on the Session and lose them when
+// it expires.
+type templatePrefStore struct {
+	mu         sync.RWMutex
+	path       string
+	userHeader string
+	users      map[string]map[string]bool
+}
+
+func defaultTemplatePrefsPath() string {
+	home, err := os.UserHomeDir()
+	if err != nil || strings.TrimSpace(home) == "" {
+		return ".esx-doctor-template-prefs.json"
+	}
+	return filepath.Join(home, ".esx-doctor", "template-prefs.json")
+}
+
+func newTemplatePrefStore(path, userHeader string) (*templatePrefStore, error) {
+	if strings.TrimSpace(path) == "" {
+		path = defaultTemplatePrefsPath()
+	}
+	s := &templatePrefStore{path: path, userHeader: strings.TrimSpace(userHeader), users: map[string]map[string]bool{}}
+	if err := s.load(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *templatePrefStore) load() error {
+	data, err := os.ReadFile(s.path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	var payload struct {
+		Users map[string]map[string]bool `json:"users"`
+	}
+	if err := json.Unmarshal(data, &payload); err != nil {
+		return fmt.Errorf("invalid template preferences file: %w", err)
+	}
+	for user, prefs := range payload.Users {
+		if user = strings.ToLower(strings.TrimSpace(user)); user != "" && len(prefs) > 0 {
+			s.users[user] = prefs
+		}
+	}
+	return nil
+}
+
+// reload re-reads user preferences from disk, discarding in-memory state.
+func (s *templatePrefStore) reload() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	prev := s.users
+	s.users = map[string]map[string]bool{}
+	if err := s.load(); err != nil {
+		s.users = prev
+		return err
+	}
+	return nil
+}
+
+func (s *templatePrefStore) persistLocked() error {
+	dir := filepath.Dir(s.path)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(map[string]any{"users": s.users}, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(s.path, data, 0o644)
+}
+
+// user returns the proxy-asserted user for r, or "" when preferences
+// should stay with the session.
+func (s *templatePrefStore) user(r *http.Request) string {
+	if s.userHeader == "" {
+		return ""
+	}
+	return strings.ToLower(strings.TrimSpace(r.Header.Get(s.userHeader)))
+}
+
+// get returns the preferences that apply to r and whether they belong to
+// the user or the session.
+func (s *templatePrefStore) get(sess *Session, r *http.Request) (string, map[string]bool) {
+	if user := s.user(r); user != "" {
+		s.mu.RLock()
+		defer s.mu.RUnlock()
+		return "user", copyTemplatePrefs(s.users[user])
+	}
+	sess.mu.RLock()
+	defer sess.mu.RUnlock()
+	return "session", copyTemplatePrefs(sess.templatePrefs)
+}
+
+// update applies changes (nil clears a template's override) on top of the
+// current preferences, or on top of none when replace is set.
+func (s *templatePrefStore) update(sess *Session, r *http.Request, changes map[string]*bool, replace bool) (string, map[string]bool, error) {
+	apply := func(prefs map[string]bool) map[string]bool {
+		next := map[string]bool{}
+		if !replace {
+			for id, v := range prefs {
+				next[id] = v
+			}
+		}
+		for id, v := range changes {
+			if id = strings.TrimSpace(id); id == "" {
+				continue
+			}
+			if v == nil {
+				delete(next, id)
+			} else {
+				next[id] = *v
+			}
+		}
+		return next
+	}
+	if user := s.user(r); user != "" {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		prev, had := s.users[user]
+		next := apply(prev)
+		if len(next) == 0 {
+			delete(s.users, user)
+		} else {
+			s.users[user] = next
+		}
+		if err := s.persistLocked(); err != nil {
+			if had {
+				s.users[user] = prev
+			} else {
+				delete(s.users, user)
+			}
+			return "user", copyTemplatePrefs(prev), err
+		}
+		return "user", copyTemplatePrefs(next), nil
+	}
+	sess.mu.Lock()
+	defer sess.mu.Unlock()
+	sess.templatePrefs = apply(sess.templatePrefs)
+	return "session", copyTemplatePrefs(sess.templatePrefs), nil
+}
+
+func copyTemplatePrefs(prefs map[string]bool) map[string]bool {
+	out := make(map[string]bool, len(prefs))
+	for id, v := range prefs {
+		out[id] = v
+	}
+	return out
+}
+
+// preferredTemplateIDs lists the templates of list that run by default
+// under prefs.
+func preferredTemplateIDs(list []DiagnosticTemplate, prefs map[string]bool) []string {
+	ids := make([]string, 0, len(list))
+	for _, t := range list {
+		enabled := t.Enabled
+		if v, ok := prefs[t.ID]; ok {
+			enabled = v
+		}
+		if enabled {
+			ids = append(ids, t.ID)
+		}
+	}
+	return ids
+}
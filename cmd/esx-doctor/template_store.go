@@ -70,6 +70,19 @@ func (s *diagnosticTemplateStore) loadCustom() error {
 	return nil
 }
 
+// reload re-reads custom templates from disk, discarding in-memory state.
+func (s *diagnosticTemplateStore) reload() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	prev := s.custom
+	s.custom = map[string]DiagnosticTemplate{}
+	if err := s.loadCustom(); err != nil {
+		s.custom = prev
+		return err
+	}
+	return nil
+}
+
 func (s *diagnosticTemplateStore) persistCustomLocked() error {
 	dir := filepath.Dir(s.path)
 	if err := os.MkdirAll(dir, 0o755); err != nil {
@@ -93,9 +106,15 @@ func normalizeTemplate(t DiagnosticTemplate) DiagnosticTemplate {
 	t.ID = strings.TrimSpace(t.ID)
 	t.Name = strings.TrimSpace(t.Name)
 	t.Description = strings.TrimSpace(t.Description)
+	t.Extends = strings.TrimSpace(t.Extends)
 	if strings.TrimSpace(t.Severity) == "" {
 		t.Severity = "medium"
 	}
+	if t.Extends != "" {
+		// Leave detector fields unset so the base template's values show
+		// through when the template is resolved.
+		return t
+	}
 	if strings.TrimSpace(t.Detector.Type) == "" {
 		t.Detector.Type = "threshold_sustained"
 	}
@@ -108,6 +127,85 @@ func normalizeTemplate(t DiagnosticTemplate) DiagnosticTemplate {
 	return t
 }
 
+func (s *diagnosticTemplateStore) lookupLocked(id string) (DiagnosticTemplate, bool) {
+	if t, ok := s.custom[id]; ok {
+		return t, true
+	}
+	t, ok := s.builtins[id]
+	return t, ok
+}
+
+// resolveLocked flattens the extends chain of t, overlaying each template's
+// explicitly set detector fields on top of its base.
+func (s *diagnosticTemplateStore) resolveLocked(t DiagnosticTemplate, seen map[string]bool) (DiagnosticTemplate, error) {
+	base := strings.TrimSpace(t.Extends)
+	if base == "" {
+		return normalizeTemplate(t), nil
+	}
+	if seen[t.ID] {
+		return t, fmt.Errorf("template %q has a circular extends chain", t.ID)
+	}
+	seen[t.ID] = true
+	parent, ok := s.lookupLocked(base)
+	if !ok {
+		return t, fmt.Errorf("template %q extends unknown template %q", t.ID, base)
+	}
+	resolvedParent, err := s.resolveLocked(parent, seen)
+	if err != nil {
+		return t, err
+	}
+	detector, err := mergeDetector(resolvedParent.Detector, t.Detector)
+	if err != nil {
+		return t, err
+	}
+	t.Detector = detector
+	t.Extends = ""
+	t = normalizeTemplate(t)
+	t.Extends = base
+	return t, nil
+}
+
+// mergeDetector overlays the non-empty fields of override onto base.
+func mergeDetector(base, override DetectorTemplate) (DetectorTemplate, error) {
+	baseJSON, err := json.Marshal(base)
+	if err != nil {
+		return base, err
+	}
+	overrideJSON, err := json.Marshal(override)
+	if err != nil {
+		return base, err
+	}
+	var merged, fields map[string]any
+	if err := json.Unmarshal(baseJSON, &merged); err != nil {
+		return base, err
+	}
+	if err := json.Unmarshal(overrideJSON, &fields); err != nil {
+		return base, err
+	}
+	for k, v := range fields {
+		switch x := v.(type) {
+		case string:
+			if strings.TrimSpace(x) == "" {
+				continue
+			}
+		case map[string]any:
+			if len(x) == 0 {
+				continue
+			}
+		}
+		merged[k] = v
+	}
+	data, err := json.Marshal(merged)
+	if err != nil {
+		return base, err
+	}
+	var out DetectorTemplate
+	if err := json.Unmarshal(data, &out); err != nil {
+		return base, err
+	}
+	return out, nil
+}
+
 func (s *diagnosticTemplateStore) list() []DiagnosticTemplate {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
@@ -127,38 +225,48 @@ func (s *diagnosticTemplateStore) list() []DiagnosticTemplate {
 	return out
 }
 
-func (s *diagnosticTemplateStore) byID(ids []string) []DiagnosticTemplate {
+// byID resolves the given templates, or every enabled one when ids is
+// empty. Templates that are unknown or whose extends chain no longer
+// resolves are left out and described in skipped, for the run's warnings.
+func (s *diagnosticTemplateStore) byID(ids []string) (out []DiagnosticTemplate, skipped []string) {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
+	add := func(t DiagnosticTemplate) {
+		r, err := s.resolveLocked(t, map[string]bool{})
+		if err != nil {
+			skipped = append(skipped, fmt.Sprintf("%s: %v; template skipped", t.Name, err))
+			return
+		}
+		out = append(out, r)
+	}
 	if len(ids) == 0 {
-		all := make([]DiagnosticTemplate, 0, len(s.builtins)+len(s.custom))
+		out = make([]DiagnosticTemplate, 0, len(s.builtins)+len(s.custom))
 		for _, t := range s.builtins {
 			if t.Enabled {
-				all = append(all, normalizeTemplate(t))
+				add(t)
 			}
 		}
 		for _, t := range s.custom {
 			if t.Enabled {
-				all = append(all, normalizeTemplate(t))
+				add(t)
 			}
 		}
-		return all
+		return out, skipped
 	}
-	out := make([]DiagnosticTemplate, 0, len(ids))
+	out = make([]DiagnosticTemplate, 0, len(ids))
 	for _, id := range ids {
 		id = strings.TrimSpace(id)
 		if id == "" {
 			continue
 		}
-		if t, ok := s.custom[id]; ok {
-			out = append(out, normalizeTemplate(t))
+		t, ok := s.lookupLocked(id)
+		if !ok {
+			skipped = append(skipped, fmt.Sprintf("unknown template %q; skipped", id))
 			continue
 		}
-		if t, ok := s.builtins[id]; ok {
-			out = append(out, normalizeTemplate(t))
-		}
+		add(t)
 	}
-	return out
+	return out, skipped
 }
 
 func templateIDFromName(name string) string {
@@ -196,12 +304,20 @@ func (s *diagnosticTemplateStore) upsert(t DiagnosticTemplate) (DiagnosticTempla
 	if strings.TrimSpace(t.Name) == "" {
 		return t, fmt.Errorf("template name is required")
 	}
-	if strings.TrimSpace(t.Detector.Type) == "" {
+	if strings.TrimSpace(t.Detector.Type) == "" && t.Extends == "" {
 		return t, fmt.Errorf("detector type is required")
 	}
 	if _, exists := s.builtins[t.ID]; exists {
 		return t, fmt.Errorf("built-in template %q is read-only; duplicate to customize", t.ID)
 	}
+	if !validFindingRank(t.Detector.Rank) {
+		return t, fmt.Errorf("unknown rank %q (use %s)", t.Detector.Rank, strings.Join(findingRanks, ", "))
+	}
+	if t.Extends != "" {
+		if _, err := s.resolveLocked(t, map[string]bool{}); err != nil {
+			return t, err
+		}
+	}
 	s.custom[t.ID] = t
 	if err := s.persistCustomLocked(); err != nil {
 		return t, err
@@ -219,6 +335,11 @@ func (s *diagnosticTemplateStore) delete(id string) error {
 	if _, exists := s.builtins[id]; exists {
 		return fmt.Errorf("built-in templates cannot be deleted")
 	}
+	for _, t := range s.custom {
+		if t.ID != id && t.Extends == id {
+			return fmt.Errorf("template %q is extended by %q; delete or re-base it first", id, t.ID)
+		}
+	}
 	delete(s.custom, id)
 	return s.persistCustomLocked()
 }
@@ -237,7 +358,7 @@ func (s *diagnosticTemplateStore) importTemplates(in []DiagnosticTemplate, repla
 		if _, exists := s.builtins[t.ID]; exists {
 			continue
 		}
-		if t.Name == "" || t.Detector.Type == "" {
+		if t.Name == "" || (t.Detector.Type == "" && t.Extends == "") {
 			continue
 		}
 		s.custom[t.ID] = t
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"net/url"
+	"time"
+)
+
+// QueryTrace is the timing breakdown attached to series and diagnostics
+// responses with debug=true, so a "this is slow on my capture" report can
+// carry numbers instead of impressions. Phases are wall time in ms:
+// seek covers finding and opening the start offset, read pulling lines off
+// disk, parse splitting fields and timestamps, and aggregate whatever the
+// caller does with a row (downsampling into series, running detectors).
+type QueryTrace struct {
+	SeekMs      float64 `json:"seekMs"`
+	ReadMs      float64 `json:"readMs"`
+	ParseMs     float64 `json:"parseMs"`
+	AggregateMs float64 `json:"aggregateMs"`
+	TotalMs     float64 `json:"totalMs"`
+	StartOffset int64   `json:"startOffset"`
+	BytesRead   int64   `json:"bytesRead"`
+	RowsRead    int64   `json:"rowsRead"`
+	// RowsSkipped counts lines read but dropped as unparseable or before
+	// the requested window.
+	RowsSkipped int64 `json:"rowsSkipped"`
+
+	start, mark time.Time
+	phases      [4]time.Duration
+}
+
+const (
+	traceSeek = iota
+	traceRead
+	traceParse
+	traceAggregate
+)
+
+// newQueryTrace returns a running trace when q asks for debug, else nil.
+// Every method is a no-op on a nil trace, so the scan loops call them
+// unconditionally.
+func newQueryTrace(q url.Values) *QueryTrace {
+	if !parseTruthy(q.Get("debug")) {
+		return nil
+	}
+	return startQueryTrace()
+}
+
+func startQueryTrace() *QueryTrace {
+	now := time.Now()
+	return &QueryTrace{start: now, mark: now}
+}
+
+// skip restarts the lap clock without charging the time to any phase.
+func (t *QueryTrace) skip() {
+	if t != nil {
+		t.mark = time.Now()
+	}
+}
+
+// lap charges the time since the previous lap to phase.
+func (t *QueryTrace) lap(phase int) {
+	if t == nil {
+		return
+	}
+	now := time.Now()
+	t.phases[phase] += now.Sub(t.mark)
+	t.mark = now
+}
+
+func (t *QueryTrace) line(n int, skipped bool) {
+	if t == nil {
+		return
+	}
+	t.BytesRead += int64(n)
+	t.RowsRead++
+	if skipped {
+		t.RowsSkipped++
+	}
+}
+
+// finish fills in the ms fields; it returns t so callers can assign it.
+func (t *QueryTrace) finish() *QueryTrace {
+	if t == nil {
+		return nil
+	}
+	ms := func(d time.Duration) float64 { return float64(d.Microseconds()) / 1000 }
+	t.SeekMs = ms(t.phases[traceSeek])
+	t.ReadMs = ms(t.phases[traceRead])
+	t.ParseMs = ms(t.phases[traceParse])
+	t.AggregateMs = ms(t.phases[traceAggregate])
+	t.TotalMs = ms(time.Since(t.start))
+	return t
+}
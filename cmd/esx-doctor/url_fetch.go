@@ -0,0 +1,220 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	neturl "net/url"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// internalNetworks are never fetched unless -url-allow-private is set:
+// loopback, RFC 1918, CGNAT, link-local (cloud metadata at 169.254.169.254)
+// and their IPv6 counterparts.
+var internalNetworks = mustParseCIDRs(
+	"0.0.0.0/8", "10.0.0.0/8", "100.64.0.0/10", "127.0.0.0/8", "169.254.0.0/16",
+	"172.16.0.0/12", "192.0.0.0/24", "192.168.0.0/16", "198.18.0.0/15", "224.0.0.0/4", "240.0.0.0/4",
+	"::/128", "::1/128", "fc00::/7", "fe80::/10", "ff00::/8",
+)
+
+func mustParseCIDRs(cidrs ...string) []*net.IPNet {
+	out := make([]*net.IPNet, 0, len(cidrs))
+	for _, c := range cidrs {
+		_, n, err := net.ParseCIDR(c)
+		if err != nil {
+			panic(err)
+		}
+		out = append(out, n)
+	}
+	return out
+}
+
+// urlFetchPolicy decides which URLs /api/open-url may fetch.
+type urlFetchPolicy struct {
+	// allowHosts, when set, lists the only host names that may be fetched;
+	// "*.example.com" matches subdomains.
+	allowHosts   []string
+	deny         []*net.IPNet
+	allowPrivate bool
+	maxBytes     int64
+}
+
+func newURLFetchPolicy(allowHosts, denyCIDRs string, allowPrivate bool, maxBytes int64) (*urlFetchPolicy, error) {
+	p := &urlFetchPolicy{allowPrivate: allowPrivate, maxBytes: maxBytes}
+	for _, h := range strings.Split(allowHosts, ",") {
+		if h = strings.ToLower(strings.TrimSpace(h)); h != "" {
+			p.allowHosts = append(p.allowHosts, h)
+		}
+	}
+	for _, c := range strings.Split(denyCIDRs, ",") {
+		c = strings.TrimSpace(c)
+		if c == "" {
+			continue
+		}
+		if !strings.Contains(c, "/") {
+			if ip := net.ParseIP(c); ip != nil && ip.To4() != nil {
+				c += "/32"
+			} else {
+				c += "/128"
+			}
+		}
+		_, n, err := net.ParseCIDR(c)
+		if err != nil {
+			return nil, fmt.Errorf("invalid -url-deny entry %q: %w", c, err)
+		}
+		p.deny = append(p.deny, n)
+	}
+	return p, nil
+}
+
+func (p *urlFetchPolicy) checkURL(u *neturl.URL) error {
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("URL must use http or https")
+	}
+	if u.User != nil {
+		return fmt.Errorf("URLs with credentials are not allowed")
+	}
+	if len(p.allowHosts) == 0 {
+		return nil
+	}
+	host := strings.ToLower(u.Hostname())
+	for _, allowed := range p.allowHosts {
+		if suffix, ok := strings.CutPrefix(allowed, "*."); ok {
+			if strings.HasSuffix(host, "."+suffix) {
+				return nil
+			}
+		} else if host == allowed {
+			return nil
+		}
+	}
+	return fmt.Errorf("host %s is not in the -url-allow list", host)
+}
+
+func (p *urlFetchPolicy) checkIP(ip net.IP) error {
+	for _, n := range p.deny {
+		if n.Contains(ip) {
+			return fmt.Errorf("destination %s is denied", ip)
+		}
+	}
+	if !p.allowPrivate {
+		for _, n := range internalNetworks {
+			if n.Contains(ip) {
+				return fmt.Errorf("destination %s is an internal address (start with -url-allow-private to permit)", ip)
+			}
+		}
+	}
+	return nil
+}
+
+// client returns an HTTP client that re-checks every hop. The IP check runs
+// on the address actually dialed, after DNS resolution, so a name that
+// resolves (or later rebinds) to an internal address is refused too.
+func (p *urlFetchPolicy) client() *http.Client {
+	dialer := &net.Dialer{
+		Timeout: 10 * time.Second,
+		Control: func(network, address string, _ syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			ip := net.ParseIP(host)
+			if ip == nil {
+				return fmt.Errorf("unexpected dial address %q", address)
+			}
+			return p.checkIP(ip)
+		},
+	}
+	return &http.Client{
+		Timeout: 60 * time.Second,
+		Transport: &http.Transport{
+			DialContext:         dialer.DialContext,
+			TLSHandshakeTimeout: 10 * time.Second,
+		},
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) >= 5 {
+				return fmt.Errorf("too many redirects")
+			}
+			return p.checkURL(req.URL)
+		},
+	}
+}
+
+// acceptedCSVContentTypes are media types a CSV download may plausibly carry.
+var acceptedCSVContentTypes = []string{"text/csv", "text/plain", "application/csv", "application/octet-stream", "application/vnd.ms-excel", "binary/octet-stream"}
+
+// checkResponse rejects oversized or obviously non-CSV responses before any
+// bytes are persisted.
+func (p *urlFetchPolicy) checkResponse(resp *http.Response) error {
+	if p.maxBytes > 0 && resp.ContentLength > p.maxBytes {
+		return fmt.Errorf("response is %d bytes, limit is %d", resp.ContentLength, p.maxBytes)
+	}
+	if ct := strings.TrimSpace(resp.Header.Get("Content-Type")); ct != "" {
+		media, _, err := mime.ParseMediaType(ct)
+		if err != nil {
+			return fmt.Errorf("invalid content type %q", ct)
+		}
+		ok := false
+		for _, accepted := range acceptedCSVContentTypes {
+			if media == accepted {
+				ok = true
+				break
+			}
+		}
+		if !ok {
+			return fmt.Errorf("content type %s is not CSV", media)
+		}
+	}
+	return nil
+}
+
+var errResponseTooLarge = errors.New("response exceeds the size limit")
+
+// body wraps resp.Body with the size limit and refuses payloads that start
+// like an HTML page (login portals often answer 200 with one).
+func (p *urlFetchPolicy) body(resp *http.Response) (io.Reader, error) {
+	var r io.Reader = resp.Body
+	if p.maxBytes > 0 {
+		r = &cappedReader{r: r, remaining: p.maxBytes}
+	}
+	br := bufio.NewReader(r)
+	head, _ := br.Peek(512)
+	lower := bytes.ToLower(bytes.TrimSpace(head))
+	if bytes.HasPrefix(lower, []byte("<!doctype html")) || bytes.HasPrefix(lower, []byte("<html")) {
+		return nil, fmt.Errorf("URL returned an HTML page, not a CSV")
+	}
+	return br, nil
+}
+
+type cappedReader struct {
+	r         io.Reader
+	remaining int64
+	// tooLarge is returned past the limit; errResponseTooLarge when nil.
+	tooLarge error
+}
+
+func (c *cappedReader) Read(b []byte) (int, error) {
+	if c.remaining <= 0 {
+		// Probe one byte to tell "exactly at the limit" from "over it".
+		var one [1]byte
+		if n, _ := c.r.Read(one[:]); n > 0 {
+			if c.tooLarge != nil {
+				return 0, c.tooLarge
+			}
+			return 0, errResponseTooLarge
+		}
+		return 0, io.EOF
+	}
+	if int64(len(b)) > c.remaining {
+		b = b[:c.remaining]
+	}
+	n, err := c.r.Read(b)
+	c.remaining -= int64(n)
+	return n, err
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// maxDevicePixelRatio caps dpr; beyond it extra points are not visible.
+	maxDevicePixelRatio = 4
+	// minViewportPoints keeps a tiny sparkline from collapsing to a few dots.
+	minViewportPoints = 32
+	// maxViewportPoints bounds what a client can ask for by claiming a huge
+	// viewport; maxPoints=0 still fetches every row.
+	maxViewportPoints = 16384
+)
+
+// seriesMaxPoints picks the point budget for a series request. An explicit
+// maxPoints wins; otherwise width (CSS pixels) and dpr size it to one point
+// per device pixel, which is all a line chart can draw. Without either,
+// fallback applies.
+func seriesMaxPoints(q url.Values, fallback int) int {
+	if v, err := strconv.Atoi(q.Get("maxPoints")); err == nil {
+		return v
+	}
+	width, err := strconv.ParseFloat(q.Get("width"), 64)
+	if err != nil || !NumberFinite(width) || width <= 0 {
+		return fallback
+	}
+	dpr, err := strconv.ParseFloat(q.Get("dpr"), 64)
+	if err != nil || !NumberFinite(dpr) || dpr < 1 {
+		dpr = 1
+	}
+	dpr = math.Min(dpr, maxDevicePixelRatio)
+	points := int(math.Ceil(width * dpr))
+	return max(minViewportPoints, min(points, maxViewportPoints))
+}
+
+// alignBucketLadder lists the bucket widths wall-clock alignment rounds up
+// to; each divides a day, so boundaries fall on the same clock times every
+// day whatever the capture start.
+var alignBucketLadder = []time.Duration{
+	time.Second, 2 * time.Second, 5 * time.Second, 10 * time.Second, 15 * time.Second, 30 * time.Second,
+	time.Minute, 2 * time.Minute, 5 * time.Minute, 10 * time.Minute, 15 * time.Minute, 30 * time.Minute,
+	time.Hour, 2 * time.Hour, 3 * time.Hour, 6 * time.Hour, 12 * time.Hour, 24 * time.Hour,
+}
+
+// parseSeriesAlign reads the align option: "minute", "hour", "auto" (any
+// boundary from the ladder) or a duration such as 5m. Empty means buckets
+// start at the first row, as before.
+func parseSeriesAlign(v string) (time.Duration, error) {
+	switch v = strings.ToLower(strings.TrimSpace(v)); v {
+	case "", "none", "off":
+		return 0, nil
+	case "auto", "true", "1":
+		return time.Second, nil
+	case "minute":
+		return time.Minute, nil
+	case "hour":
+		return time.Hour, nil
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil || d < time.Second {
+		return 0, fmt.Errorf("invalid align %q (use minute, hour, auto or a duration of at least 1s)", v)
+	}
+	return d, nil
+}
+
+// parseSeriesAgg reads the agg parameter: "sample" (the default) keeps one
+// row per point, "envelope" makes downsampled points bucket means with
+// min/max bands.
+func parseSeriesAgg(v string) (bool, error) {
+	switch v = strings.ToLower(strings.TrimSpace(v)); v {
+	case "", "sample":
+		return false, nil
+	case "envelope", "minmax":
+		return true, nil
+	}
+	return false, fmt.Errorf("invalid agg %q (use sample or envelope)", v)
+}
+
+// alignedBucket returns the bucket width for a downsampled series: the
+// smallest ladder width that is a multiple of unit and at least want, so
+// the point budget still holds. Units off the ladder fall back to
+// multiples of themselves.
+func alignedBucket(want, unit time.Duration) time.Duration {
+	for _, d := range alignBucketLadder {
+		if d >= want && d >= unit && d%unit == 0 {
+			return d
+		}
+	}
+	n := (want + unit - 1) / unit
+	return max(n, 1) * unit
+}
+
+// SeriesQuality says how much of a downsampled series the points show, so
+// a chart can warn to zoom in before a conclusion is drawn from it.
+type SeriesQuality struct {
+	// Rows is how many values the points stand for.
+	Rows int64 `json:"rows"`
+	// VarianceRetained is the variance of the points over that of the
+	// rows, capped at 1: near 1 the shape survived, near 0 the swings
+	// happened between points.
+	VarianceRetained float64 `json:"varianceRetained"`
+	// Clipped counts points whose bucket held a value outside the drawn
+	// range (for envelope, the band); Min and Max are the rows' extremes.
+	Clipped int     `json:"clipped"`
+	Min     float64 `json:"min"`
+	Max     float64 `json:"max"`
+}
+
+// seriesRowStats accumulates every row of a downsampled series (Welford).
+type seriesRowStats struct {
+	n        int64
+	mean, m2 float64
+	min, max float64
+}
+
+func (r *seriesRowStats) add(v float64) {
+	if r.n == 0 || v < r.min {
+		r.min = v
+	}
+	if r.n == 0 || v > r.max {
+		r.max = v
+	}
+	r.n++
+	d := v - r.mean
+	r.mean += d / float64(r.n)
+	r.m2 += d * (v - r.mean)
+}
+
+// quality compares the rows with s's points; s.Min and s.Max hold each
+// bucket's extremes.
+func (r *seriesRowStats) quality(s *SeriesPayload, envelope bool) *SeriesQuality {
+	if r.n == 0 || len(s.Values) == 0 {
+		return nil
+	}
+	q := &SeriesQuality{Rows: r.n, VarianceRetained: 1, Min: r.min, Max: r.max}
+	var points seriesRowStats
+	for _, v := range s.Values {
+		points.add(v)
+	}
+	if rows := r.m2 / float64(r.n); rows > 0 {
+		q.VarianceRetained = math.Min(1, points.m2/float64(points.n)/rows)
+	}
+	lo, hi := points.min, points.max
+	if envelope {
+		lo, hi = slices.Min(s.Min), slices.Max(s.Max)
+	}
+	for i := range s.Values {
+		if s.Min[i] < lo || s.Max[i] > hi {
+			q.Clipped++
+		}
+	}
+	return q
+}
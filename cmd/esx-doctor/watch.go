@@ -0,0 +1,337 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// -watch-dir turns esx-doctor into a drop folder: every CSV (or .csv.gz)
+// copied into the directory is indexed once it has stopped growing, run
+// through the enabled templates, and answered with a findings file next
+// to it (capture.findings.json). Unlike -fleet nothing is kept in memory
+// but the queue state; the findings file is the result, and a capture
+// whose findings file is newer than it is not analyzed again, so a
+// restart does not redo the backlog.
+
+// WatchItem is one capture seen in the watch directory.
+type WatchItem struct {
+	Path         string `json:"path"`
+	Status       string `json:"status"` // settling, queued, indexing, analyzing, done, failed
+	Size         int64  `json:"size"`
+	ModTime      int64  `json:"modTime"`
+	Rows         int64  `json:"rows,omitempty"`
+	HealthScore  int    `json:"healthScore,omitempty"`
+	FindingCount int    `json:"findingCount"`
+	Output       string `json:"output,omitempty"`
+	Error        string `json:"error,omitempty"`
+	Seen         int64  `json:"seen"`
+	Finished     int64  `json:"finished,omitempty"`
+}
+
+type WatchResponse struct {
+	Dir       string         `json:"dir"`
+	Interval  int64          `json:"intervalMs"`
+	ScannedAt int64          `json:"scannedAt"`
+	Status    map[string]int `json:"status"`
+	// Items lists the captures most recently seen first.
+	Items []WatchItem `json:"items"`
+}
+
+// WatchFindings is what is written next to each capture.
+type WatchFindings struct {
+	File        string              `json:"file"`
+	AnalyzedAt  int64               `json:"analyzedAt"`
+	Rows        int64               `json:"rows"`
+	Start       int64               `json:"start"`
+	End         int64               `json:"end"`
+	Templates   int                 `json:"templates"`
+	HealthScore int                 `json:"healthScore"`
+	Findings    []DiagnosticFinding `json:"findings"`
+}
+
+type watchStore struct {
+	mu        sync.RWMutex
+	dir       string
+	interval  time.Duration
+	templates *diagnosticTemplateStore
+	history   *historyStore
+	events    *eventLog
+	items     map[string]*WatchItem // by path
+	queue     chan string
+	scannedAt time.Time
+}
+
+func newWatchStore(dir string, interval time.Duration, templates *diagnosticTemplateStore, history *historyStore, events *eventLog, workers int) (*watchStore, error) {
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, err
+	}
+	st, err := os.Stat(abs)
+	if err != nil {
+		return nil, err
+	}
+	if !st.IsDir() {
+		return nil, fmt.Errorf("%s is not a directory", abs)
+	}
+	if interval <= 0 {
+		interval = 10 * time.Second
+	}
+	if workers < 1 {
+		workers = 1
+	}
+	w := &watchStore{
+		dir:       abs,
+		interval:  interval,
+		templates: templates,
+		history:   history,
+		events:    events,
+		items:     map[string]*WatchItem{},
+		queue:     make(chan string, 1024),
+	}
+	for i := 0; i < workers; i++ {
+		go w.worker()
+	}
+	return w, nil
+}
+
+// watchFindingsPath is where the findings for the capture at path go.
+func watchFindingsPath(path string) string {
+	return filepath.Join(filepath.Dir(path), trimCaptureExt(filepath.Base(path))+".findings.json")
+}
+
+func isWatchedCapture(name string) bool {
+	lower := strings.ToLower(name)
+	return strings.HasSuffix(lower, ".csv") || strings.HasSuffix(lower, ".csv.gz")
+}
+
+// scan looks at the directory once. A capture is queued when its size and
+// modification time are the same as on the previous scan, i.e. whatever
+// was copying it in has finished; captures that changed since they were
+// analyzed go through the same settling again.
+func (w *watchStore) scan() error {
+	found := map[string]fs.FileInfo{}
+	err := filepath.WalkDir(w.dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			if path != w.dir && strings.HasPrefix(d.Name(), ".") {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if strings.HasPrefix(d.Name(), ".") || !isWatchedCapture(d.Name()) {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return nil
+		}
+		found[path] = info
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	var todo []string
+	w.mu.Lock()
+	for path := range w.items {
+		if _, ok := found[path]; !ok {
+			delete(w.items, path)
+		}
+	}
+	for path, info := range found {
+		size, mod := info.Size(), info.ModTime().UnixMilli()
+		item, ok := w.items[path]
+		if !ok {
+			item = &WatchItem{Path: path, Status: "settling", Size: size, ModTime: mod, Seen: now.UnixMilli()}
+			w.items[path] = item
+			if prev, ok := readWatchFindings(path, info.ModTime()); ok {
+				item.Status, item.Output = "done", watchFindingsPath(path)
+				item.Rows, item.HealthScore, item.FindingCount = prev.Rows, prev.HealthScore, len(prev.Findings)
+				item.Finished = prev.AnalyzedAt
+			}
+			continue
+		}
+		if item.Size != size || item.ModTime != mod {
+			*item = WatchItem{Path: path, Status: "settling", Size: size, ModTime: mod, Seen: now.UnixMilli()}
+			continue
+		}
+		if item.Status == "settling" {
+			item.Status = "queued"
+			todo = append(todo, path)
+		}
+	}
+	w.scannedAt = now
+	w.mu.Unlock()
+
+	sort.Strings(todo)
+	go func() {
+		for _, path := range todo {
+			w.queue <- path
+		}
+	}()
+	return nil
+}
+
+// readWatchFindings loads the findings file of the capture at path when it
+// was written after the capture last changed.
+func readWatchFindings(path string, modTime time.Time) (WatchFindings, bool) {
+	out := watchFindingsPath(path)
+	st, err := os.Stat(out)
+	if err != nil || st.ModTime().Before(modTime) {
+		return WatchFindings{}, false
+	}
+	data, err := os.ReadFile(out)
+	if err != nil {
+		return WatchFindings{}, false
+	}
+	var prev WatchFindings
+	if err := json.Unmarshal(data, &prev); err != nil {
+		return WatchFindings{}, false
+	}
+	return prev, true
+}
+
+// run scans every interval until the process exits.
+func (w *watchStore) run() {
+	ticker := time.NewTicker(w.interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		if err := w.scan(); err != nil {
+			log.Printf("watch scan failed: %v", err)
+		}
+	}
+}
+
+func (w *watchStore) worker() {
+	for path := range w.queue {
+		w.analyze(path)
+	}
+}
+
+func (w *watchStore) analyze(path string) {
+	if !w.setStatus(path, "indexing") {
+		return
+	}
+	df, err := loadOrBuildIndex(path)
+	if err != nil {
+		w.fail(path, fmt.Errorf("index build failed: %w", err))
+		return
+	}
+	if df.Rows == 0 {
+		w.fail(path, fmt.Errorf("no data rows"))
+		return
+	}
+	df.Provenance.Source, df.Provenance.Origin = "watch", path
+	if !w.setStatus(path, "analyzing") {
+		return
+	}
+	selected, skipped := w.templates.byID(nil)
+	resp, err := runDiagnostics(df, selected, time.Time{}, time.Time{})
+	if err != nil {
+		w.fail(path, err)
+		return
+	}
+	resp.Warnings = append(skipped, resp.Warnings...)
+	if w.history != nil {
+		if _, err := w.history.add(df, selected, time.Time{}, time.Time{}, resp); err != nil {
+			log.Printf("recording watch run history failed: %v", err)
+		}
+	}
+	result := WatchFindings{
+		File:        path,
+		AnalyzedAt:  time.Now().UnixMilli(),
+		Rows:        df.Rows,
+		Start:       unixMilliOrZero(df.StartTime),
+		End:         unixMilliOrZero(df.EndTime),
+		Templates:   resp.Templates,
+		HealthScore: resp.HealthScore,
+		Findings:    resp.Findings,
+	}
+	out := watchFindingsPath(path)
+	if err := writeWatchFindings(out, result); err != nil {
+		w.fail(path, fmt.Errorf("writing findings: %w", err))
+		return
+	}
+	log.Printf("watch: %s: health %d, %d finding(s)", filepath.Base(path), resp.HealthScore, len(resp.Findings))
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if item, ok := w.items[path]; ok {
+		item.Status, item.Error, item.Output = "done", "", out
+		item.Rows, item.HealthScore, item.FindingCount = df.Rows, resp.HealthScore, len(resp.Findings)
+		item.Finished = result.AnalyzedAt
+		w.events.publish(nil, "watch", *item)
+	}
+}
+
+// writeWatchFindings writes through a temp file so a consumer polling for
+// the findings never reads half of them.
+func writeWatchFindings(path string, result WatchFindings) error {
+	data, err := json.MarshalIndent(result, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := path + ".part"
+	if err := os.WriteFile(tmp, append(data, '\n'), 0o644); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		_ = os.Remove(tmp)
+		return err
+	}
+	return nil
+}
+
+// setStatus moves path to status, reporting false when the capture was
+// removed or changed meanwhile.
+func (w *watchStore) setStatus(path, status string) bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	item, ok := w.items[path]
+	if !ok || item.Status == "settling" {
+		return false
+	}
+	item.Status = status
+	return true
+}
+
+func (w *watchStore) fail(path string, err error) {
+	log.Printf("watch: %s: %v", filepath.Base(path), err)
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if item, ok := w.items[path]; ok {
+		item.Status, item.Error = "failed", err.Error()
+		item.Finished = time.Now().UnixMilli()
+		w.events.publish(nil, "watch", *item)
+	}
+}
+
+func (w *watchStore) overview() WatchResponse {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	out := WatchResponse{Dir: w.dir, Interval: w.interval.Milliseconds(), ScannedAt: unixMilliOrZero(w.scannedAt), Status: map[string]int{}, Items: make([]WatchItem, 0, len(w.items))}
+	for _, item := range w.items {
+		out.Status[item.Status]++
+		out.Items = append(out.Items, *item)
+	}
+	sort.Slice(out.Items, func(i, j int) bool {
+		a, b := out.Items[i], out.Items[j]
+		if a.Seen != b.Seen {
+			return a.Seen > b.Seen
+		}
+		return a.Path < b.Path
+	})
+	return out
+}
@@ -4,7 +4,9 @@ const state = {
   indexMap: new Map(),
   attributes: [],
   attributeMap: new Map(),
+  activity: null,
   reports: [],
+  reportDefs: null,
   activeReport: null,
   windows: [],
   activeWindowId: null,
@@ -86,6 +88,7 @@ const $reports = document.getElementById("reports");
 const $attributes = document.getElementById("attributes");
 const $instances = document.getElementById("instances");
 const $instanceSearch = document.getElementById("instanceSearch");
+const $instanceSort = document.getElementById("instanceSort");
 const $filePath = document.getElementById("filePath");
 const $filePicker = document.getElementById("filePicker");
 const $urlInput = document.getElementById("urlInput");
@@ -98,6 +101,7 @@ const $filterMin = document.getElementById("filterMin");
 const $filterMax = document.getElementById("filterMax");
 const $diagTemplates = document.getElementById("diagTemplates");
 const $runDiagnostics = document.getElementById("runDiagnostics");
+const $copySRNote = document.getElementById("copySRNote");
 const $openTemplateManager = document.getElementById("openTemplateManager");
 const $diagFindings = document.getElementById("diagFindings");
 const $diagRunMeta = document.getElementById("diagRunMeta");
@@ -554,6 +558,7 @@ function renderDiagnosticTemplates() {
     cb.addEventListener("change", () => {
       if (cb.checked) state.selectedDiagnosticTemplateIds.add(t.id);
       else state.selectedDiagnosticTemplateIds.delete(t.id);
+      saveTemplatePreference(t, cb.checked);
     });
     const textWrap = document.createElement("div");
     const title = document.createElement("div");
@@ -589,7 +594,8 @@ function renderDiagnosticFindings() {
     const meta = document.createElement("div");
     meta.className = "diag-finding-meta";
     const range = Number.isFinite(f.start) && Number.isFinite(f.end) ? `${fmtTime(f.start)} to ${fmtTime(f.end)}` : "";
-    meta.textContent = [f.templateName, f.reportKey ? `report: ${f.reportKey}` : "", range].filter(Boolean).join(" | ");
+    const also = Array.isArray(f.alsoFlaggedBy) && f.alsoFlaggedBy.length > 0 ? `also: ${f.alsoFlaggedBy.map((a) => a.templateName || a.templateId).join(", ")}` : "";
+    meta.textContent = [f.templateName, also, f.reportKey ? `report: ${f.reportKey}` : "", range].filter(Boolean).join(" | ");
     const summary = document.createElement("div");
     summary.className = "diag-finding-meta";
     summary.textContent = f.summary || "";
@@ -621,13 +627,30 @@ async function loadDiagnosticTemplates() {
     const data = await res.json();
     const list = Array.isArray(data.templates) ? data.templates : [];
     state.diagnosticsTemplates = list;
-    state.selectedDiagnosticTemplateIds = new Set(list.filter((t) => t.enabled !== false).map((t) => t.id));
+    const prefs = data.preferences && typeof data.preferences === "object" ? data.preferences : {};
+    const enabled = (t) => (typeof prefs[t.id] === "boolean" ? prefs[t.id] : t.enabled !== false);
+    state.selectedDiagnosticTemplateIds = new Set(list.filter(enabled).map((t) => t.id));
     renderDiagnosticTemplates();
   } catch (_err) {
     $diagTemplates.textContent = "Failed to load templates.";
   }
 }
 
+// saveTemplatePreference remembers a checkbox change for this user (or
+// session); matching the template's own default clears the override.
+async function saveTemplatePreference(t, checked) {
+  const value = checked === (t.enabled !== false) ? null : checked;
+  try {
+    await apiFetch("/api/diagnostics/preferences", {
+      method: "POST",
+      headers: { "Content-Type": "application/json" },
+      body: JSON.stringify({ enabled: { [t.id]: value } }),
+    });
+  } catch (_err) {
+    setStatus("Could not save template preference.");
+  }
+}
+
 function setupTemplateSync() {
   window.addEventListener("storage", (e) => {
     if (e.key === templateSyncStorageKey) loadDiagnosticTemplates();
@@ -668,13 +691,137 @@ async function runDiagnostics() {
     }
     state.diagnosticsFindings = Array.isArray(data.findings) ? data.findings : [];
     renderDiagnosticFindings();
-    if ($diagRunMeta) $diagRunMeta.textContent = `Scanned ${data.rowsScanned || 0} rows in ${data.durationMs || 0}ms using ${data.templates || 0} templates`;
-    setStatus(`Diagnostics complete: ${state.diagnosticsFindings.length} finding(s).`);
+    if ($diagRunMeta) $diagRunMeta.textContent = `Health score ${Number.isFinite(data.healthScore) ? data.healthScore : 100}/100 | Scanned ${data.rowsScanned || 0} rows in ${data.durationMs || 0}ms using ${data.templates || 0} templates`;
+    const warnings = Array.isArray(data.warnings) ? data.warnings : [];
+    if (warnings.length > 0 && $diagRunMeta) $diagRunMeta.textContent += ` | ${warnings.join(" | ")}`;
+    setStatus(`Diagnostics complete: ${state.diagnosticsFindings.length} finding(s)${warnings.length ? ` (${warnings.length} warning(s))` : ""}.`);
   } catch (_err) {
     setStatus("Diagnostics request failed.");
   }
 }
 
+async function copySRNote() {
+  const findings = Array.isArray(state.diagnosticsFindings) ? state.diagnosticsFindings : [];
+  if (findings.length === 0) {
+    setStatus("Run diagnostics first to build an SR note.");
+    return;
+  }
+  try {
+    const res = await apiFetch("/api/diagnostics/export/sr", {
+      method: "POST",
+      headers: { "Content-Type": "application/json" },
+      body: JSON.stringify({ findings }),
+    });
+    if (!res.ok) {
+      setStatus("SR note export failed.");
+      return;
+    }
+    const text = await res.text();
+    await navigator.clipboard.writeText(text);
+    setStatus(`Copied SR note with ${findings.length} finding(s) to clipboard.`);
+  } catch (_err) {
+    setStatus("SR note export failed.");
+  }
+}
+
+async function copyChartLink() {
+  const columns = Array.from(state.selected).map((idx) => state.columns[idx]).filter(Boolean);
+  if (columns.length === 0) {
+    setStatus("Select some instances first.");
+    return;
+  }
+  const attr = currentAttribute();
+  const body = { report: state.activeReport || "", attribute: attr ? attr.label : "", columns };
+  if (isZoomed()) {
+    body.start = Math.round(state.view.start);
+    body.end = Math.round(state.view.end);
+  }
+  if (Number.isFinite(state.filter.min)) body.filterMin = state.filter.min;
+  if (Number.isFinite(state.filter.max)) body.filterMax = state.filter.max;
+  try {
+    const res = await apiFetch("/api/links", {
+      method: "POST",
+      headers: { "Content-Type": "application/json" },
+      body: JSON.stringify(body),
+    });
+    const data = await res.json();
+    if (!res.ok || data.error) {
+      setStatus(data.error || "Creating the link failed.");
+      return;
+    }
+    const url = `${window.location.origin}${data.url}`;
+    await navigator.clipboard.writeText(url);
+    setStatus(`Copied ${url}`);
+  } catch (_err) {
+    setStatus("Creating the link failed.");
+  }
+}
+
+// exportChartCSV downloads the selected columns over the visible range as
+// a trimmed CSV; the session cookie identifies the capture.
+function exportChartCSV() {
+  const cols = Array.from(state.selected);
+  if (cols.length === 0) {
+    setStatus("Select some instances first.");
+    return;
+  }
+  const params = new URLSearchParams({ cols: cols.join(",") });
+  if (isZoomed()) {
+    params.set("start", String(Math.round(state.view.start)));
+    params.set("end", String(Math.round(state.view.end)));
+  }
+  const link = document.createElement("a");
+  link.href = `/api/export?${params.toString()}`;
+  link.click();
+}
+
+// restoreLinkFromPath reopens the chart of a /l/<token> link: columns are
+// matched by header name, so it works on a re-indexed copy of the capture.
+async function restoreLinkFromPath() {
+  const m = window.location.pathname.match(/^\/l\/([a-z0-9]+)\/?$/i);
+  if (!m) return false;
+  let link;
+  try {
+    const res = await apiFetch(`/api/links/${m[1]}`);
+    link = await res.json();
+    if (!res.ok || link.error) {
+      setStatus(link.error || "Link not found.");
+      return false;
+    }
+  } catch (_err) {
+    setStatus("Loading the link failed.");
+    return false;
+  }
+  const byName = new Map(state.columns.map((c, i) => [c, i]));
+  const idxs = (link.columns || []).map((c) => byName.get(c)).filter((i) => Number.isFinite(i));
+  if (idxs.length === 0) {
+    setStatus(`None of the link's columns are in the loaded capture (it was made on ${link.file || "another file"}).`);
+    return false;
+  }
+  if (link.report) selectReport(link.report);
+  const attr = state.attributes.find((a) => a.label === link.attribute);
+  if (attr) {
+    state.selectedAttribute = attr.key;
+    enforceSingleAttributeSelection();
+  }
+  state.selected = new Set(idxs);
+  renderAttributes();
+  renderInstances();
+  state.filter = {
+    min: Number.isFinite(link.filterMin) ? link.filterMin : null,
+    max: Number.isFinite(link.filterMax) ? link.filterMax : null,
+  };
+  syncFilterInputs();
+  await loadSeries();
+  if (Number.isFinite(link.start) && Number.isFinite(link.end) && link.end > link.start) {
+    zoomToRange(link.start, link.end);
+  }
+  const missing = (link.columns || []).length - idxs.length;
+  const note = link.file && link.file !== state.file ? ` (made on ${link.file})` : "";
+  setStatus(missing > 0 ? `Link opened${note}; ${missing} column(s) are not in this capture.` : `Link opened${note}.`);
+  return true;
+}
+
 async function jumpToFinding(finding) {
   if (!finding) return;
   if (finding.reportKey) selectReport(finding.reportKey);
@@ -1032,16 +1179,25 @@ function buildAttributeModel() {
   buildReportsModel();
 }
 
+// Report tabs come from the server (/api/report/sections) so an attribute
+// lands in the same tab as its /api/report/section/{key} findings.
+async function loadReportDefs() {
+  try {
+    const res = await apiFetch("/api/report/sections");
+    const data = await res.json();
+    if (!res.ok || !Array.isArray(data.sections)) return;
+    state.reportDefs = data.sections.map((d) => ({
+      key: d.key,
+      label: d.label,
+      patterns: (d.patterns || []).map((p) => new RegExp(p, "i")),
+    }));
+  } catch (_err) {
+    // Without the sections every attribute shows under All and Other.
+  }
+}
+
 function buildReportsModel() {
-  const defs = [
-    { key: "cpu", label: "CPU", patterns: [/cpu/i, /vcpu/i, /group cpu/i, /% used/i, /% ready/i] },
-    { key: "memory", label: "Memory", patterns: [/memory/i, /swap/i, /memctl/i, /compressed/i, /group memory/i] },
-    { key: "numa", label: "NUMA", patterns: [/numa/i] },
-    { key: "power", label: "Power", patterns: [/power/i, /watts/i, /pstate/i, /cstate/i] },
-    { key: "network", label: "Network", patterns: [/\bnet/i, /nic/i, /network/i] },
-    { key: "storage", label: "Storage", patterns: [/disk/i, /datastore/i, /storage/i, /latency/i, /iops/i] },
-    { key: "vsan", label: "vSAN", patterns: [/vsan/i] },
-  ];
+  const defs = state.reportDefs || [];
 
   const reportMap = new Map(defs.map((d) => [d.key, { key: d.key, label: d.label, attrs: [] }]));
   const other = { key: "other", label: "Other", attrs: [] };
@@ -1147,7 +1303,13 @@ function getVisibleInstances(attr) {
       const compact = compactInstanceName(a).toLowerCase();
       return raw.includes(filter) || compact.includes(filter);
     })
-    .sort((a, b) => a.instance.localeCompare(b.instance));
+    .sort((a, b) => {
+      if ($instanceSort && $instanceSort.value === "activity" && state.activity) {
+        const diff = (state.activity[b.idx] || 0) - (state.activity[a.idx] || 0);
+        if (diff !== 0) return diff;
+      }
+      return a.instance.localeCompare(b.instance);
+    });
 }
 
 function renderAttributes() {
@@ -1235,7 +1397,16 @@ function selectReport(type) {
   saveCurrentWindowState();
 }
 
+function applyReadOnly(readOnly) {
+  ["openFile", "openUrl", "filePicker", "urlInput"].forEach((id) => {
+    const el = document.getElementById(id);
+    if (el) el.disabled = !!readOnly;
+  });
+  if (readOnly) $filePath.title = "This server is read-only: opening other files is disabled.";
+}
+
 function applyMeta(data) {
+  applyReadOnly(data.readOnly);
   state.columns = data.columns || [];
   state.file = data.file || "";
   state.rows = data.rows || 0;
@@ -1285,9 +1456,52 @@ function applyMeta(data) {
 }
 
 async function loadMeta() {
+  if (!state.reportDefs) await loadReportDefs();
   const res = await apiFetch("/api/meta");
   const data = await res.json();
   applyMeta(data);
+  state.activity = null;
+  if (data.loaded) loadActivity();
+  await fetchSessionGeneration();
+}
+
+// Files attached with `esx-doctor attach` replace this tab's file on the
+// server; the session generation tells us to reload.
+let sessionGeneration = null;
+
+async function fetchSessionGeneration() {
+  try {
+    const res = await apiFetch("/api/session");
+    if (!res.ok) return null;
+    const data = await res.json();
+    sessionGeneration = data.generation;
+    return data;
+  } catch (_err) {
+    return null;
+  }
+}
+
+async function watchSessionAttach() {
+  const before = sessionGeneration;
+  const data = await fetchSessionGeneration();
+  if (data && before !== null && data.generation !== before) {
+    setStatus(`Attached ${data.file} from the command line.`);
+    await loadMeta();
+    await loadSeries();
+  }
+  setTimeout(watchSessionAttach, 5000);
+}
+
+async function loadActivity() {
+  try {
+    const res = await apiFetch("/api/activity");
+    const data = await res.json();
+    if (!res.ok || !Array.isArray(data.scores)) return;
+    state.activity = data.scores;
+    if ($instanceSort && $instanceSort.value === "activity") renderInstances();
+  } catch (_err) {
+    state.activity = null;
+  }
 }
 
 async function openPickedFile() {
@@ -1305,12 +1519,32 @@ async function openPickedFile() {
     method: "POST",
     body: form,
   });
-  const data = await res.json();
+  let data = await res.json();
   if (!res.ok || data.error) {
     setStatus(data.error || "Failed to open CSV");
     return;
   }
 
+  // Uploads are indexed in the background; poll the job until it settles.
+  setStatus(`Indexing ${file.name}...`);
+  while (data.status === "queued" || data.status === "indexing") {
+    await new Promise((resolve) => setTimeout(resolve, 500));
+    const jobRes = await apiFetch(`/api/jobs/${encodeURIComponent(data.id)}`);
+    data = await jobRes.json();
+    if (!jobRes.ok) {
+      setStatus(data.error || "Failed to open CSV");
+      return;
+    }
+  }
+  if (data.status === "failed") {
+    setStatus(data.error || "Failed to open CSV");
+    return;
+  }
+  if (data.status === "superseded") {
+    // A later upload took over; its own poll loads the file.
+    return;
+  }
+
   await loadMeta();
   await loadSeries();
 }
@@ -1898,6 +2132,7 @@ document.getElementById("clearInstances").addEventListener("click", () => {
   attr.items.forEach((item) => state.selected.delete(item.idx));
   renderInstances();
 });
+if ($instanceSort) $instanceSort.addEventListener("change", () => renderInstances());
 $instanceSearch.addEventListener("input", () => {
   renderInstances();
   saveCurrentWindowState();
@@ -1950,7 +2185,10 @@ if ($markMenuColor) $markMenuColor.addEventListener("input", () => {
 
 document.getElementById("loadSeries").addEventListener("click", () => loadSeries());
 document.getElementById("screenshot").addEventListener("click", () => downloadScreenshot());
+document.getElementById("copyLink").addEventListener("click", () => copyChartLink());
+document.getElementById("exportCsv").addEventListener("click", () => exportChartCSV());
 if ($runDiagnostics) $runDiagnostics.addEventListener("click", () => runDiagnostics());
+if ($copySRNote) $copySRNote.addEventListener("click", () => copySRNote());
 if ($openTemplateManager) {
   $openTemplateManager.addEventListener("click", (e) => {
     e.preventDefault();
@@ -2204,4 +2442,9 @@ updateMarkButtons();
 renderDiagnosticFindings();
 setupTemplateSync();
 loadDiagnosticTemplates();
-loadMeta().then(() => loadSeries());
+const $sessionHint = document.getElementById("sessionHint");
+if ($sessionHint) $sessionHint.textContent = `esx-doctor attach -session ${clientSessionID} <file.csv>`;
+loadMeta()
+  .then(() => restoreLinkFromPath())
+  .then((restored) => (restored ? null : loadSeries()))
+  .finally(() => setTimeout(watchSessionAttach, 5000));
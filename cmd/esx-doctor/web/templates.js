@@ -175,7 +175,10 @@ function createConditionRow(cond = defaultCondition()) {
   row.className = "tm-cond-row";
 
   const op = document.createElement("select");
-  [["contains", "contains"], ["not_contains", "not contains"], ["eq", "equals"], ["neq", "not equals"], ["regex", "regex"], ["not_regex", "not regex"]].forEach(([v, l]) => {
+  [
+    ["contains", "contains"], ["not_contains", "not contains"], ["eq", "equals"], ["neq", "not equals"], ["regex", "regex"], ["not_regex", "not regex"],
+    ["in", "number in"], ["not_in", "number not in"], ["gte", "number >="], ["lte", "number <="], ["gt", "number >"], ["lt", "number <"], ["even", "number even"], ["odd", "number odd"],
+  ].forEach(([v, l]) => {
     const o = document.createElement("option");
     o.value = v;
     o.textContent = l;
@@ -185,8 +188,15 @@ function createConditionRow(cond = defaultCondition()) {
 
   const valueInput = document.createElement("input");
   valueInput.type = "text";
-  valueInput.placeholder = "Instance match text or regex";
   valueInput.value = cond.value || "";
+  const syncPlaceholder = () => {
+    const numeric = ["in", "not_in", "gt", "gte", "lt", "lte"].includes(op.value);
+    const bare = op.value === "even" || op.value === "odd";
+    valueInput.disabled = bare;
+    valueInput.placeholder = bare ? "(no value)" : numeric ? "Number or ranges, e.g. 0-3,8" : "Instance match text or regex";
+  };
+  op.addEventListener("change", syncPlaceholder);
+  syncPlaceholder();
 
   const remove = document.createElement("button");
   remove.className = "btn ghost";
@@ -219,6 +229,8 @@ function clearForm() {
   $desc.value = "";
   $severity.value = "medium";
   $enabled.value = "true";
+  $("tmMaxFindings").value = "";
+  $("tmRank").value = "";
   $type.value = "threshold_sustained";
   renderAttributeOptions("");
   $filterLogic.value = "and";
@@ -272,6 +284,8 @@ function fillForm(t) {
   $("tmLowThreshold").value = t.detector?.low_threshold || 20;
   $("tmImbalanceGap").value = t.detector?.min_gap || 45;
   $("tmImbalanceConsecutive").value = t.detector?.min_consecutive || 6;
+  $("tmMaxFindings").value = t.detector?.max_findings > 0 ? String(t.detector.max_findings) : "";
+  $("tmRank").value = t.detector?.rank || "";
 
   refreshTypeParams();
 }
@@ -280,7 +294,7 @@ function collectConditions() {
   const rows = Array.from($conditions.querySelectorAll(".tm-cond-row"));
   return rows
     .map((r) => (typeof r._get === "function" ? r._get() : null))
-    .filter((x) => x && x.op && x.value);
+    .filter((x) => x && x.op && (x.value || x.op === "even" || x.op === "odd"));
 }
 
 function parseNum(id) {
@@ -315,8 +329,13 @@ function toTemplatePayload() {
     detector.min_gap = parseNum("tmImbalanceGap");
     detector.min_consecutive = Math.max(1, parseInt($("tmImbalanceConsecutive").value || "6", 10));
   }
+  const maxFindings = parseInt($("tmMaxFindings").value || "", 10);
+  if (Number.isFinite(maxFindings) && maxFindings > 0) detector.max_findings = maxFindings;
+  if ($("tmRank").value) detector.rank = $("tmRank").value;
+  const existing = state.templates.find((t) => t.id === state.selectedId);
   return {
     id: state.selectedId || "",
+    extends: existing && existing.extends ? existing.extends : undefined,
     name: ($name.value || "").trim(),
     description: ($desc.value || "").trim(),
     severity: ($severity.value || "medium").trim(),
@@ -352,7 +371,8 @@ async function saveTemplate() {
     const selected = state.templates.find((t) => t.id === state.selectedId);
     if (selected) fillForm(selected);
     notifyTemplatesUpdated();
-    setStatus("Template saved.");
+    const warnings = Array.isArray(data.warnings) ? data.warnings : [];
+    setStatus(warnings.length ? `Template saved with warning: ${warnings.join(" | ")}` : "Template saved.");
   } catch (_err) {
     setStatus("Save request failed.");
   }
@@ -438,6 +458,10 @@ async function loadTemplates() {
     const res = await apiFetch("/api/diagnostics/templates");
     const data = await res.json();
     state.templates = Array.isArray(data.templates) ? data.templates : [];
+    ["tmNew", "tmDuplicate", "tmDelete", "tmImport", "tmSave"].forEach((id) => {
+      $(id).disabled = !!data.readOnly;
+    });
+    if (data.readOnly) setStatus("This server is read-only: templates can be viewed and exported but not changed.");
     renderTemplateList();
     if (state.templates.length > 0) {
       state.selectedId = state.templates[0].id;